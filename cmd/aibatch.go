@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Status of a batch of AI translations.
+const (
+	batchQueued    = "queued"
+	batchRunning   = "running"
+	batchCompleted = "completed"
+	batchFailed    = "failed"
+	batchCancelled = "cancelled"
+)
+
+const (
+	// maxQueuedBatches bounds the batches waiting for the worker.
+	maxQueuedBatches = 16
+	// keptBatches is the number of batches whose status is kept after they finished.
+	keptBatches = 100
+)
+
+type TranslateBatchRequest struct {
+	FilePath string `json:"file_path"`
+	// ContentIDs are the segments to translate, in order; empty selects every
+	// untranslated segment of the file.
+	ContentIDs   []string `json:"content_ids"`
+	Instructions string   `json:"instructions"`
+}
+
+type batchSegmentError struct {
+	ContentID string `json:"content_id"`
+	Error     string `json:"error"`
+}
+
+// aiBatchStatus is the progress of a batch as the API reports it.
+type aiBatchStatus struct {
+	ID       string `json:"id"`
+	FilePath string `json:"file_path"`
+	Status   string `json:"status"`
+	// JobID is the job whose log records the batch, once it started.
+	JobID      string              `json:"job_id,omitempty"`
+	Total      int                 `json:"total"`
+	Translated int                 `json:"translated"`
+	Failed     int                 `json:"failed"`
+	Errors     []batchSegmentError `json:"errors"`
+	Queued     time.Time           `json:"queued"`
+	Finished   *time.Time          `json:"finished,omitempty"`
+}
+
+type aiBatch struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	contentIDs   []string
+	instructions string
+	// user pays for the translations of the batch.
+	user string
+	// status is guarded by the mutex of the queue.
+	status aiBatchStatus
+}
+
+// aiBatchQueue holds the batches of AI translations queued in serve. One
+// worker translates them in order, segment by segment, so a batch takes one
+// of the --max-ai-requests slots at a time and leaves the others to the
+// segments translated in the browser.
+type aiBatchQueue struct {
+	mu      sync.Mutex
+	batches map[string]*aiBatch
+	// order lists the IDs of the batches, oldest first.
+	order   []string
+	pending chan *aiBatch
+	lastID  int
+	// stopping is set when serve shuts down; the running batch stops after
+	// its segment and no other starts. done is closed when the worker ends.
+	stopping bool
+	done     chan struct{}
+
+	unpackedEpubPath string
+	contentDirPath   string
+	bookTitle        string
+	citations        *citationStore
+	// locks keep the segments a batch is going to translate from being edited
+	// meanwhile; segments being edited are skipped.
+	locks *segmentLocks
+}
+
+// batchJobs lets one batch run at a time when serve has several books, each
+// with its own queue: the job log of the running batch is global.
+var batchJobs sync.Mutex
+
+// batchQueues are the queues of the served books, stopped on shutdown.
+var batchQueues struct {
+	sync.Mutex
+	queues []*aiBatchQueue
+}
+
+func newAIBatchQueue(unpackedEpubPath, contentDirPath, bookTitle string, citations *citationStore, locks *segmentLocks) *aiBatchQueue {
+	q := &aiBatchQueue{
+		batches:          make(map[string]*aiBatch),
+		pending:          make(chan *aiBatch, maxQueuedBatches),
+		done:             make(chan struct{}),
+		unpackedEpubPath: unpackedEpubPath,
+		contentDirPath:   contentDirPath,
+		bookTitle:        bookTitle,
+		citations:        citations,
+		locks:            locks,
+	}
+	go q.run()
+
+	batchQueues.Lock()
+	batchQueues.queues = append(batchQueues.queues, q)
+	batchQueues.Unlock()
+	return q
+}
+
+// enqueue adds a batch, or reports false when the queue is full.
+func (q *aiBatchQueue) enqueue(filePath string, contentIDs []string, instructions, user string) (aiBatchStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopping {
+		return aiBatchStatus{}, false
+	}
+
+	q.lastID++
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &aiBatch{
+		ctx:          ctx,
+		cancel:       cancel,
+		contentIDs:   contentIDs,
+		instructions: instructions,
+		user:         user,
+		status: aiBatchStatus{
+			ID:       strconv.Itoa(q.lastID),
+			FilePath: filePath,
+			Status:   batchQueued,
+			Total:    len(contentIDs),
+			Errors:   []batchSegmentError{},
+			Queued:   time.Now(),
+		},
+	}
+
+	select {
+	case q.pending <- b:
+	default:
+		cancel()
+		return aiBatchStatus{}, false
+	}
+
+	q.batches[b.status.ID] = b
+	q.order = append(q.order, b.status.ID)
+	q.forgetFinished()
+
+	// Segments being edited are locked again when their turn comes.
+	href := editHref(filePath)
+	for _, id := range contentIDs {
+		q.locks.lock(href, id, lockAI, batchLockHolder(b.status.ID))
+	}
+	return b.status, true
+}
+
+// forgetFinished drops the oldest finished batches beyond keptBatches. The
+// caller holds q.mu.
+func (q *aiBatchQueue) forgetFinished() {
+	for i := 0; len(q.order) > keptBatches && i < len(q.order); {
+		b := q.batches[q.order[i]]
+		if b.status.Finished == nil {
+			i++
+			continue
+		}
+		delete(q.batches, b.status.ID)
+		q.order = append(q.order[:i], q.order[i+1:]...)
+	}
+}
+
+// get returns the status of a batch.
+func (q *aiBatchQueue) get(id string) (aiBatchStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b, ok := q.batches[id]
+	if !ok {
+		return aiBatchStatus{}, false
+	}
+	status := b.status
+	status.Errors = append([]batchSegmentError{}, b.status.Errors...)
+	return status, true
+}
+
+// cancelBatch stops a batch; the segment being translated is abandoned.
+func (q *aiBatchQueue) cancelBatch(id string) (aiBatchStatus, bool) {
+	q.mu.Lock()
+	b, ok := q.batches[id]
+	if ok {
+		b.cancel()
+		if b.status.Status == batchQueued {
+			q.finish(b, batchCancelled)
+		}
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return aiBatchStatus{}, false
+	}
+	return q.get(id)
+}
+
+// finish records the end of a batch and releases the segments it has not
+// translated. The caller holds q.mu.
+func (q *aiBatchQueue) finish(b *aiBatch, status string) {
+	finished := time.Now()
+	b.status.Status = status
+	b.status.Finished = &finished
+	q.locks.unlockAll(batchLockHolder(b.status.ID))
+}
+
+func (q *aiBatchQueue) update(b *aiBatch, change func(s *aiBatchStatus)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	change(&b.status)
+}
+
+func (q *aiBatchQueue) run() {
+	defer close(q.done)
+	for b := range q.pending {
+		q.process(b)
+	}
+}
+
+// stop lets the running batch finish the segment it is translating and
+// cancels the others.
+func (q *aiBatchQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.stopping {
+		q.stopping = true
+		close(q.pending)
+	}
+}
+
+func (q *aiBatchQueue) isStopping() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stopping
+}
+
+// stopBatchQueues stops the queues of all books and waits up to timeout for
+// the segments being translated. It reports whether they all finished.
+func stopBatchQueues(timeout time.Duration) bool {
+	batchQueues.Lock()
+	queues := append([]*aiBatchQueue{}, batchQueues.queues...)
+	batchQueues.Unlock()
+
+	for _, q := range queues {
+		q.stop()
+	}
+	deadline := time.After(timeout)
+	for _, q := range queues {
+		select {
+		case <-q.done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+func (q *aiBatchQueue) process(b *aiBatch) {
+	if b.ctx.Err() != nil {
+		return
+	}
+	if q.isStopping() {
+		q.update(b, func(s *aiBatchStatus) {
+			q.finish(b, batchCancelled)
+		})
+		return
+	}
+	batchJobs.Lock()
+	defer batchJobs.Unlock()
+
+	filePath := path.Join(q.contentDirPath, b.status.FilePath)
+	fileName := path.Base(filePath)
+	href, holder := editHref(b.status.FilePath), batchLockHolder(b.status.ID)
+
+	j, err := startJob(q.unpackedEpubPath, "ai-translate-batch")
+	if err != nil {
+		fmt.Printf("Error starting batch %s: %v\n", b.status.ID, err)
+		q.update(b, func(s *aiBatchStatus) {
+			s.Errors = append(s.Errors, batchSegmentError{Error: err.Error()})
+			q.finish(b, batchFailed)
+		})
+		return
+	}
+	q.update(b, func(s *aiBatchStatus) {
+		s.Status, s.JobID = batchRunning, j.info.ID
+	})
+	jobLog.Info("file started", "file", fileName, "segments", len(b.contentIDs), "batch", b.status.ID)
+
+	provider, err := getServeTranslator()
+	var citationMode string
+	if err == nil {
+		citationMode, err = citationModeFor(q.citations, q.contentDirPath, filePath)
+	}
+	if err != nil {
+		q.update(b, func(s *aiBatchStatus) {
+			s.Errors = append(s.Errors, batchSegmentError{Error: err.Error()})
+			q.finish(b, batchFailed)
+		})
+		j.finish(err)
+		return
+	}
+	origin := provenance{Provider: translationProvider, Model: provider.Model(), PromptVersion: provider.PromptVersion(), Sampling: provider.Sampling()}
+
+	stopped := false
+	var budgetErr error
+	ctx := withSpendUser(b.ctx, b.user)
+	for _, id := range b.contentIDs {
+		if b.ctx.Err() != nil {
+			break
+		}
+		if q.isStopping() {
+			stopped = true
+			break
+		}
+		if budgetErr = serveSpend.check(b.user); budgetErr != nil {
+			jobLog.Warn("batch stopped", "file", fileName, "user", b.user, "error", budgetErr)
+			break
+		}
+
+		var err error
+		if _, ok := q.locks.lock(href, id, lockAI, holder); !ok {
+			err = errSegmentBeingEdited
+		} else {
+			err = translateSegmentInFile(ctx, filePath, id, b.instructions, q.bookTitle, citationMode, origin)
+			q.locks.unlock(href, id, holder)
+		}
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+		switch {
+		case errors.Is(err, errSegmentBeingEdited):
+			jobLog.Warn("segment skipped", "file", fileName, "content_id", id, "error", err)
+		case err != nil:
+			jobLog.Error("segment translation failed", "file", fileName, "content_id", id, "error", err)
+		default:
+			jobLog.Info("segment translated", "file", fileName, "content_id", id, "provenance", origin.String())
+		}
+
+		q.update(b, func(s *aiBatchStatus) {
+			if err != nil {
+				s.Failed++
+				s.Errors = append(s.Errors, batchSegmentError{ContentID: id, Error: err.Error()})
+			} else {
+				s.Translated++
+			}
+		})
+	}
+
+	status, jobErr := batchCompleted, error(nil)
+	switch {
+	case b.ctx.Err() != nil:
+		status, jobErr = batchCancelled, errors.New("batch cancelled")
+	case stopped:
+		status, jobErr = batchCancelled, errors.New("serve shut down")
+	case budgetErr != nil:
+		status, jobErr = batchFailed, budgetErr
+	}
+	q.update(b, func(s *aiBatchStatus) {
+		q.finish(b, status)
+	})
+	j.finish(jobErr)
+}
+
+// errSegmentBeingEdited skips the segments whose translation is being edited
+// when a batch comes to them.
+var errSegmentBeingEdited = errors.New("skipped: the translation is being edited")
+
+// translateSegmentInFile translates the segment contentID of filePath with the
+// serve translator and writes the translation to the file, replacing the
+// current translation. The file is read again before writing, so edits saved
+// meanwhile are kept. Bibliography entries are translated in citation mode.
+func translateSegmentInFile(ctx context.Context, filePath, contentID, instructions, bookTitle, citationMode string, origin provenance) error {
+	fileLock := getFileLock(filePath)
+
+	fileLock.Lock()
+	doc, err := openAndReadFile(filePath)
+	fileLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	original, translation := findSegment(doc, contentID)
+	if original == nil {
+		return fmt.Errorf("segment not found")
+	}
+	content, _ := withoutPageBreaks(original)
+	var citations []string
+	if isCitation(original, citationMode) {
+		content, citations = maskCitation(content)
+		instructions = strings.TrimSpace(citationInstructions + "\n\n" + instructions)
+	}
+	if translation != nil {
+		previous, _ := translation.Html()
+		instructions = withPreviousTranslation(previous, instructions)
+	}
+
+	release, err := waitAISlot(ctx)
+	if err != nil {
+		return err
+	}
+	translated, err := translateWithAI(ctx, content, instructions, bookTitle, nil)
+	release()
+	if err != nil {
+		return err
+	}
+	if !isTranslationValid(content, translated) {
+		return fmt.Errorf("translation rejected: markup differs from the original")
+	}
+	if translated, err = unmaskCitation(translated, citations); err != nil {
+		return fmt.Errorf("citation lost in translation: %w", err)
+	}
+
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	doc, err = openAndReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	original, translation = findSegment(doc, contentID)
+	switch {
+	case original == nil:
+		return fmt.Errorf("segment not found")
+	case translation != nil:
+		translation.SetHtml(translated)
+		origin.apply(translation)
+		stampSource(translation, original)
+	case original.AttrOr(util.TranslationByIdKey, "") != "":
+		return fmt.Errorf("the translation is in another file")
+	default:
+		if err := placeTranslation(doc, original, filePath, targetLanguage, translated, origin); err != nil {
+			return err
+		}
+	}
+
+	return writeContentToFile(filePath, doc)
+}
+
+// findSegment returns the original with contentID and its translation, nil if
+// the segment is not translated or its translation is in another file.
+func findSegment(doc *goquery.Document, contentID string) (original, translation *goquery.Selection) {
+	return findSegmentAt(doc, contentID, 0)
+}
+
+// findSegmentAt is findSegment for the original with contentID after
+// occurrence others with it, as repeats of a block share its content id.
+// Translations that share an ID follow the order of their originals.
+func findSegmentAt(doc *goquery.Document, contentID string, occurrence int) (original, translation *goquery.Selection) {
+	originals := doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.AttrOr(util.ContentIdKey, "") == contentID
+	})
+	if occurrence < 0 || occurrence >= originals.Length() {
+		return nil, nil
+	}
+	original = originals.Eq(occurrence)
+
+	translationID := original.AttrOr(util.TranslationByIdKey, "")
+	if translationID == "" {
+		return original, nil
+	}
+	linked := doc.Find(fmt.Sprintf("[%s]", util.TranslationByIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.AttrOr(util.TranslationByIdKey, "") == translationID
+	})
+	return original, findTranslationAt(doc, translationID, linked.IndexOfSelection(original))
+}
+
+// batchContentIDs checks the requested segments of the file, or selects its
+// untranslated segments when none are requested. Failures are *fiber.Error.
+func batchContentIDs(filePath string, requested []string) ([]string, error) {
+	doc, err := openAndReadFile(filePath)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "File not found")
+	}
+
+	if len(requested) > 0 {
+		for _, id := range requested {
+			if original, _ := findSegment(doc, id); original == nil {
+				return nil, fiber.NewError(fiber.StatusNotFound, "Content ID not found: "+id)
+			}
+		}
+		return requested, nil
+	}
+
+	var ids []string
+	doc.Find(fmt.Sprintf("[%s]:not([%s])", util.ContentIdKey, util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+		ids = append(ids, s.AttrOr(util.ContentIdKey, ""))
+	})
+	if len(ids) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "No untranslated segments in the file")
+	}
+	return ids, nil
+}
+
+// registerBatchAPI adds the endpoints queueing batches of AI translations.
+func registerBatchAPI(api fiber.Router, queue *aiBatchQueue) {
+	api.Post("/ai-translate-batch", func(c *fiber.Ctx) error {
+		var req TranslateBatchRequest
+		if err := c.BodyParser(&req); err != nil || req.FilePath == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		// Cleaning against the root keeps the file inside the book.
+		filePath := path.Clean("/" + req.FilePath)
+		ids, err := batchContentIDs(path.Join(queue.contentDirPath, filePath), req.ContentIDs)
+		if err != nil {
+			return aiTranslationError(c, err)
+		}
+
+		user := requestUser(c)
+		if err := serveSpend.check(user); err != nil {
+			return budgetError(c, err)
+		}
+		status, ok := queue.enqueue(filePath, ids, req.Instructions, user)
+		if !ok {
+			c.Set(fiber.HeaderRetryAfter, "60")
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many batches queued, try again later"})
+		}
+
+		c.Location(bookBase(c) + apiV1 + "/ai-translate-batch/" + status.ID)
+		return c.Status(fiber.StatusAccepted).JSON(status)
+	})
+
+	api.Get("/ai-translate-batch/:id", func(c *fiber.Ctx) error {
+		status, ok := queue.get(c.Params("id"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Batch not found"})
+		}
+		return c.JSON(status)
+	})
+
+	api.Delete("/ai-translate-batch/:id", func(c *fiber.Ctx) error {
+		status, ok := queue.cancelBatch(c.Params("id"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Batch not found"})
+		}
+		return c.JSON(status)
+	})
+}
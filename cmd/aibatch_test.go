@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBatchContentIDs(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "chapter.xhtml")
+	content := `<html><body>
+<p data-content-id="a" data-translation-by-id="t">One</p><p data-translation-id="t">Một</p>
+<p data-content-id="b">Two</p>
+<p data-content-id="c">Three</p>
+</body></html>`
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		requested []string
+		want      []string
+		status    int
+	}{
+		{nil, []string{"b", "c"}, 0},
+		{[]string{"c", "a"}, []string{"c", "a"}, 0},
+		{[]string{"a", "x"}, nil, fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		got, err := batchContentIDs(filePath, tt.requested)
+		var fiberErr *fiber.Error
+		if tt.status != 0 {
+			if !errors.As(err, &fiberErr) || fiberErr.Code != tt.status {
+				t.Errorf("batchContentIDs(%v) error = %v, want status %d", tt.requested, err, tt.status)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("batchContentIDs(%v) = %v, %v, want %v", tt.requested, got, err, tt.want)
+		}
+	}
+}
+
+func TestStopBatchQueues(t *testing.T) {
+	q := newAIBatchQueue(t.TempDir(), t.TempDir(), "Book", nil, newSegmentLocks())
+	if !stopBatchQueues(time.Second) {
+		t.Fatal("stopBatchQueues() = false for an idle queue")
+	}
+	if _, ok := q.enqueue("ch1.xhtml", []string{"a"}, "", anonymousUser); ok {
+		t.Error("enqueue() accepted a batch after the queue stopped")
+	}
+}
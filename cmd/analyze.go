@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/liushuangls/go-anthropic/v2"
+	"github.com/spf13/cobra"
+)
+
+var Analyze = &cobra.Command{
+	Use:   "analyze [unpackedEpubPath]",
+	Short: "Report vocabulary statistics and translation difficulty per chapter",
+	Long: `This command analyzes the source text of an unpacked EPUB before translating it. It reports vocabulary
+statistics for the whole book and, for every chapter, the average sentence length, the ratio of rare words and
+the dialogue density. From these it estimates a difficulty score and suggests a model and prompt profile.`,
+	Example: `epubtrans analyze path/to/unpacked/epub --top 30`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runAnalyze,
+}
+
+func init() {
+	Analyze.Flags().Int("top", 20, "number of most frequent terms to list")
+}
+
+// chapterAnalysis holds the statistics of one chapter.
+type chapterAnalysis struct {
+	Href              string
+	Words             int
+	Sentences         int
+	AvgSentenceLength float64
+	RareWordRatio     float64
+	DialogueDensity   float64
+	TechnicalRatio    float64
+	Difficulty        float64
+}
+
+var (
+	analyzeWordRegex     = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{M}\p{N}'’-]*`)
+	analyzeSentenceRegex = regexp.MustCompile(`[^.!?。！？]+[.!?。！？]*`)
+	analyzeQuoteRegex    = regexp.MustCompile(`["“”«»「」『』]`)
+)
+
+func runAnalyze(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	top, _ := cmd.Flags().GetInt("top")
+
+	chapters, err := readSpineTexts(unzipPath, sourceText)
+	if err != nil {
+		return err
+	}
+
+	if len(chapters) == 0 {
+		return fmt.Errorf("no text found in %s", unzipPath)
+	}
+
+	frequencies := make(map[string]int)
+	for _, chapter := range chapters {
+		for _, word := range analyzeWordRegex.FindAllString(chapter.Text, -1) {
+			frequencies[strings.ToLower(word)]++
+		}
+	}
+
+	totalWords := 0
+	for _, count := range frequencies {
+		totalWords += count
+	}
+
+	fmt.Printf("Words: %d\n", totalWords)
+	fmt.Printf("Unique words: %d (type-token ratio %.3f)\n", len(frequencies), float64(len(frequencies))/float64(max(totalWords, 1)))
+
+	fmt.Printf("\nMost frequent terms:\n")
+	for _, term := range topTerms(frequencies, top) {
+		fmt.Printf("  %-24s %d\n", term, frequencies[term])
+	}
+
+	fmt.Printf("\n%-32s %7s %8s %7s %9s %10s  %s\n", "Chapter", "Words", "Sent.len", "Rare", "Dialogue", "Difficulty", "Suggestion")
+	for _, chapter := range chapters {
+		a := analyzeChapter(chapter, frequencies)
+		model, profile := suggestProfile(a)
+		fmt.Printf("%-32s %7d %8.1f %6.1f%% %8.1f%% %10.0f  %s / %s\n",
+			a.Href, a.Words, a.AvgSentenceLength, a.RareWordRatio*100, a.DialogueDensity*100, a.Difficulty, model, profile)
+	}
+
+	return nil
+}
+
+// sourceText extracts the untranslated text of a chapter: the marked segments if
+// the book was marked, otherwise the whole body.
+func sourceText(doc *goquery.Document) string {
+	marked := doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey))
+	if marked.Length() == 0 {
+		return strings.TrimSpace(doc.Find("body").Text())
+	}
+
+	var parts []string
+	marked.Each(func(i int, s *goquery.Selection) {
+		parts = append(parts, strings.TrimSpace(s.Text()))
+	})
+	return strings.Join(parts, "\n")
+}
+
+func analyzeChapter(chapter chapterText, bookFrequencies map[string]int) chapterAnalysis {
+	a := chapterAnalysis{Href: chapter.Href}
+
+	words := analyzeWordRegex.FindAllString(chapter.Text, -1)
+	a.Words = len(words)
+	if a.Words == 0 {
+		return a
+	}
+
+	rare, technical := 0, 0
+	for _, word := range words {
+		if bookFrequencies[strings.ToLower(word)] <= 1 {
+			rare++
+		}
+		if isTechnicalToken(word) {
+			technical++
+		}
+	}
+	a.RareWordRatio = float64(rare) / float64(a.Words)
+	a.TechnicalRatio = float64(technical) / float64(a.Words)
+
+	dialogue := 0
+	for _, sentence := range analyzeSentenceRegex.FindAllString(chapter.Text, -1) {
+		if strings.TrimSpace(sentence) == "" {
+			continue
+		}
+		a.Sentences++
+		if analyzeQuoteRegex.MatchString(sentence) {
+			dialogue++
+		}
+	}
+	a.Sentences = max(a.Sentences, 1)
+	a.AvgSentenceLength = float64(a.Words) / float64(a.Sentences)
+	a.DialogueDensity = float64(dialogue) / float64(a.Sentences)
+
+	// Long sentences weigh most, followed by rare vocabulary and dialogue, whose
+	// tone and forms of address are easy to get wrong.
+	a.Difficulty = 50*min(a.AvgSentenceLength/40, 1) + 30*min(a.RareWordRatio/0.2, 1) + 20*a.DialogueDensity
+
+	return a
+}
+
+// isTechnicalToken reports whether a word looks like an acronym, identifier or number.
+func isTechnicalToken(word string) bool {
+	upper, lower, digits := 0, 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+
+	isAcronym := upper >= 2 && lower == 0
+	isCamelCase := upper >= 1 && lower >= 1 && !unicode.IsUpper([]rune(word)[0])
+	return isAcronym || isCamelCase || digits > 0
+}
+
+// suggestProfile picks a model and one of the built-in prompt profiles for a chapter.
+func suggestProfile(a chapterAnalysis) (string, string) {
+	model := string(anthropic.ModelClaude3Haiku20240307)
+	if a.Difficulty >= 40 {
+		model = string(anthropic.ModelClaude3Dot5SonnetLatest)
+	}
+
+	profile := "psychology"
+	if a.TechnicalRatio >= 0.05 {
+		profile = "technical"
+	}
+
+	return model, profile
+}
+
+func topTerms(frequencies map[string]int, n int) []string {
+	terms := make([]string, 0, len(frequencies))
+	for term := range frequencies {
+		// Short words are mostly function words and tell little about the book.
+		if len([]rune(term)) > 3 {
+			terms = append(terms, term)
+		}
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if frequencies[terms[i]] != frequencies[terms[j]] {
+			return frequencies[terms[i]] > frequencies[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms
+}
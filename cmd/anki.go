@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/anki"
+	"github.com/dutchsteven/epubtrans/pkg/tts"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	audioNone        = "none"
+	audioOriginal    = "original"
+	audioTranslation = "translation"
+	audioBoth        = "both"
+)
+
+var ExportAnki = &cobra.Command{
+	Use:   "export-anki [unpackedEpubPath] [outputDir]",
+	Short: "Export the sentences of a translated book as Anki decks",
+	Long: `This command turns the translated book into flashcards for language learners: one Anki deck (.apkg) per
+chapter, numbered in reading order, with a card per sentence of the original and its translation on the back.
+Sentences are paired like the bilingual layout of audiobook pairs them; paragraphs whose original and translation do not
+have the same number of sentences, and all paragraphs with --granularity paragraph, become one card.
+
+The back of a card lists the rare words of the sentence, those used at most --rare times in the book, as analyze
+counts them; the description of a deck lists the most frequent terms of its chapter. With --audio, the cards also play
+the sentences read by a text to speech provider, with the pronunciation lexicon of the book.
+
+Decks whose file exists are skipped. Importing a deck again updates its cards instead of adding them twice.`,
+	Example: `epubtrans export-anki path/to/unpacked/epub decks --audio original --source-voice onyx`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and outputDir are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runExportAnki,
+}
+
+func init() {
+	ExportAnki.Flags().String("granularity", granularitySentence, "unit of a card: sentence or paragraph")
+	ExportAnki.Flags().Int("rare", 1, "list the words of a sentence used at most this many times in the book")
+	ExportAnki.Flags().Int("top", 20, "number of most frequent terms of the chapter in the deck description")
+	ExportAnki.Flags().String("audio", audioNone, "sentences to add audio clips of: none, original, translation or both")
+	ExportAnki.Flags().StringVar(&ttsProvider, "tts-provider", "openai", "text to speech provider: "+strings.Join(tts.Providers(), ", "))
+	ExportAnki.Flags().StringVar(&ttsModel, "tts-model", "", "model of the provider; for piper, the .onnx voice model")
+	ExportAnki.Flags().StringVar(&ttsVoice, "voice", "", "voice reading the translation; defaults to the provider's default voice")
+	ExportAnki.Flags().StringVar(&ttsSourceVoice, "source-voice", "", "voice reading the original; defaults to --voice")
+	ExportAnki.Flags().Float64Var(&ttsSpeed, "speed", 0, "speaking rate, 1 being normal; defaults to the provider's rate")
+}
+
+// ankiModel is the note type of the exported cards.
+var ankiModel = anki.Model{
+	Name:   "epubtrans sentence",
+	Fields: []string{"Original", "Translation", "Vocabulary", "OriginalAudio", "TranslationAudio"},
+	Front:  `<div class="original">{{Original}}</div>{{OriginalAudio}}`,
+	Back: `{{FrontSide}}<hr id="answer"><div class="translation">{{Translation}}</div>{{TranslationAudio}}` +
+		`{{#Vocabulary}}<div class="vocabulary">{{Vocabulary}}</div>{{/Vocabulary}}`,
+	CSS: `.card { font-family: serif; font-size: 22px; text-align: center; }
+.translation { color: #333; }
+.vocabulary { margin-top: 1em; font-size: 16px; color: #777; }`,
+}
+
+// sentenceCard is a sentence of the original and its translation.
+type sentenceCard struct {
+	original    string
+	translation string
+	vocabulary  []string
+}
+
+func runExportAnki(cmd *cobra.Command, args []string) error {
+	unzipPath, outputDir := args[0], args[1]
+	granularity, _ := cmd.Flags().GetString("granularity")
+	rare, _ := cmd.Flags().GetInt("rare")
+	top, _ := cmd.Flags().GetInt("top")
+	audio, _ := cmd.Flags().GetString("audio")
+
+	if granularity != granularitySentence && granularity != granularityParagraph {
+		return fmt.Errorf("invalid granularity %q: use sentence or paragraph", granularity)
+	}
+	if audio != audioNone && audio != audioOriginal && audio != audioTranslation && audio != audioBoth {
+		return fmt.Errorf("invalid audio %q: use none, original, translation or both", audio)
+	}
+
+	var source, target tts.Synthesizer
+	sourceVoice := ttsVoice
+	if ttsSourceVoice != "" {
+		sourceVoice = ttsSourceVoice
+	}
+	if audio != audioNone {
+		lexicon, err := loadBookLexicon(unzipPath)
+		if err != nil {
+			return err
+		}
+		if target, err = newSpeaker(ttsVoice, lexicon); err != nil {
+			return err
+		}
+		source = target
+		if ttsSourceVoice != "" && ttsSourceVoice != ttsVoice {
+			if source, err = newSpeaker(ttsSourceVoice, lexicon); err != nil {
+				return err
+			}
+		}
+	}
+
+	bookName, err := extractBookName(unzipPath)
+	if err != nil {
+		return fmt.Errorf("error extracting book name: %v", err)
+	}
+	chapters, err := collectAudioChapters(unzipPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", outputDir, err)
+	}
+
+	frequencies := make(map[string]int)
+	for _, chapter := range chapters {
+		for _, segment := range chapter.segments {
+			addWordCounts(frequencies, segment.original)
+		}
+	}
+
+	exported := 0
+	for i, chapter := range chapters {
+		name := strings.TrimSuffix(filepath.Base(chapter.href), filepath.Ext(chapter.href))
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("%03d-%s.apkg", i+1, name))
+		if _, err := os.Stat(outputPath); err == nil {
+			fmt.Printf("Skipping %s: %s exists\n", chapter.href, filepath.Base(outputPath))
+			continue
+		}
+
+		cards := sentenceCards(chapter.segments, granularity, frequencies, rare)
+		if len(cards) == 0 {
+			continue
+		}
+
+		chapterFrequencies := make(map[string]int)
+		for _, segment := range chapter.segments {
+			addWordCounts(chapterFrequencies, segment.original)
+		}
+
+		deck := &anki.Deck{
+			Name:        fmt.Sprintf("%s::%03d %s", bookName, i+1, name),
+			Description: html.EscapeString(strings.Join(topTerms(chapterFrequencies, top), ", ")),
+			Model:       ankiModel,
+			Media:       make(map[string][]byte),
+		}
+		fmt.Printf("Exporting %s (%d cards)\n", chapter.href, len(cards))
+
+		for _, card := range cards {
+			var originalAudio, translationAudio string
+			if audio == audioOriginal || audio == audioBoth {
+				if originalAudio, err = addAudioClip(cmd, deck, source, sourceVoice, card.original); err != nil {
+					return fmt.Errorf("synthesizing %s: %w", chapter.href, err)
+				}
+			}
+			if audio == audioTranslation || audio == audioBoth {
+				if translationAudio, err = addAudioClip(cmd, deck, target, ttsVoice, card.translation); err != nil {
+					return fmt.Errorf("synthesizing %s: %w", chapter.href, err)
+				}
+			}
+
+			deck.Notes = append(deck.Notes, anki.Note{
+				GUID: bookName + "\x00" + chapter.href + "\x00" + card.original,
+				Fields: []string{
+					html.EscapeString(card.original),
+					html.EscapeString(card.translation),
+					html.EscapeString(strings.Join(card.vocabulary, ", ")),
+					originalAudio,
+					translationAudio,
+				},
+				Tags: []string{"epubtrans", strings.ReplaceAll(name, " ", "_")},
+			})
+		}
+
+		if err := deck.WriteFile(outputPath); err != nil {
+			return fmt.Errorf("writing %s: %w", outputPath, err)
+		}
+		exported++
+	}
+
+	fmt.Printf("Exported %d decks to %s\n", exported, outputDir)
+	return nil
+}
+
+// sentenceCards pairs the sentences of the translated segments. The
+// vocabulary of a card are its words used at most rare times in the book,
+// skipping the short words topTerms skips too.
+func sentenceCards(segments []audioSegment, granularity string, bookFrequencies map[string]int, rare int) []sentenceCard {
+	var cards []sentenceCard
+	for _, segment := range segments {
+		if segment.original == "" || segment.translation == "" {
+			continue
+		}
+
+		originals, translations := alignSentences(segment, granularity)
+		for i := range originals {
+			card := sentenceCard{original: originals[i], translation: translations[i]}
+			seen := make(map[string]bool)
+			for _, word := range analyzeWordRegex.FindAllString(originals[i], -1) {
+				word = strings.ToLower(word)
+				if len([]rune(word)) > 3 && bookFrequencies[word] <= rare && !seen[word] {
+					seen[word] = true
+					card.vocabulary = append(card.vocabulary, word)
+				}
+			}
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+func addWordCounts(frequencies map[string]int, text string) {
+	for _, word := range analyzeWordRegex.FindAllString(text, -1) {
+		frequencies[strings.ToLower(word)]++
+	}
+}
+
+// addAudioClip reads text aloud into a media file of the deck and returns the
+// field that plays it. Files are named after a hash of the voice and text, as
+// Anki keeps the media of all decks in one folder.
+func addAudioClip(cmd *cobra.Command, deck *anki.Deck, speaker tts.Synthesizer, voice, text string) (string, error) {
+	sum := sha1.Sum([]byte(ttsProvider + "\x00" + ttsModel + "\x00" + voice + "\x00" + text))
+	name := "epubtrans-" + hex.EncodeToString(sum[:8]) + "." + speaker.Format()
+
+	if _, ok := deck.Media[name]; !ok {
+		clip, err := speaker.Synthesize(cmd.Context(), text)
+		if err != nil {
+			return "", err
+		}
+		deck.Media[name] = clip
+	}
+	return "[sound:" + name + "]", nil
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSentenceCards(t *testing.T) {
+	segments := []audioSegment{
+		{original: "Untranslated."},
+		{original: "The lighthouse stood. Waves crashed.", translation: "Ngọn hải đăng đứng. Sóng vỗ."},
+		{original: "One sentence here. Another.", translation: "Một câu."},
+	}
+	frequencies := map[string]int{"lighthouse": 1, "stood": 1, "waves": 2, "crashed": 1, "sentence": 3, "here": 4, "another": 1}
+
+	got := sentenceCards(segments, granularitySentence, frequencies, 1)
+	want := []sentenceCard{
+		{original: "The lighthouse stood.", translation: "Ngọn hải đăng đứng.", vocabulary: []string{"lighthouse", "stood"}},
+		{original: "Waves crashed.", translation: "Sóng vỗ.", vocabulary: []string{"crashed"}},
+		{original: "One sentence here. Another.", translation: "Một câu.", vocabulary: []string{"another"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sentenceCards() = %v, want %v", got, want)
+	}
+}
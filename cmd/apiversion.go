@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiV1 is the prefix of version 1 of the serve API.
+//
+// Within a version, endpoints, parameters and response fields are only added:
+// none is removed, renamed or given another type, and no request field becomes
+// required. TestAPIContract checks this against testdata/api/v1.json. Changes
+// that break clients go to a new version, served next to the previous one.
+const apiV1 = "/api/v1"
+
+// legacyAPI serves the unversioned /api paths of earlier releases from version
+// 1, so scripts written against them keep working. Their responses carry a
+// Deprecation header and link to the versioned path.
+func legacyAPI() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rest := strings.TrimPrefix(c.Path(), bookBase(c)+"/api")
+		if isVersionedAPIPath(rest) {
+			return c.Next()
+		}
+
+		successor := bookBase(c) + apiV1 + rest
+		c.Set("Deprecation", "true")
+		c.Set(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+		c.Path(successor)
+		return c.Next()
+	}
+}
+
+// isVersionedAPIPath reports whether rest, the path after /api, starts with a
+// version such as /v1.
+func isVersionedAPIPath(rest string) bool {
+	version, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	if len(version) < 2 || version[0] != 'v' {
+		return false
+	}
+	for _, r := range version[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiV1Contract = "testdata/api/v1.json"
+
+// TestAPIContract fails when a change breaks clients of version 1 of the serve
+// API: an endpoint, parameter, response or field of testdata/api/v1.json was
+// removed or changed type. Additions are compatible; run go test with -update
+// to add them to the contract.
+func TestAPIContract(t *testing.T) {
+	app := fiber.New()
+	api := app.Group(apiV1)
+	for key := range registeredAPIRoutes(t) {
+		method, path, _ := strings.Cut(key, " ")
+		api.Add(method, path, func(c *fiber.Ctx) error { return nil })
+	}
+
+	doc := openAPIDocument(app, "")
+	current, err := json.MarshalIndent(fiber.Map{"paths": doc["paths"], "components": doc["components"]}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(apiV1Contract), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(apiV1Contract, append(current, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(apiV1Contract)
+	if err != nil {
+		t.Fatalf("reading contract: %v (run go test with -update to create it)", err)
+	}
+
+	var contract, got any
+	if err := json.Unmarshal(data, &contract); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(current, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range incompatibilities("", contract, got) {
+		t.Errorf("breaking change to %s: %s", apiV1, problem)
+	}
+}
+
+// incompatibilities lists what of the contract is missing or different in
+// got. Summaries and descriptions may change.
+func incompatibilities(path string, contract, got any) []string {
+	switch want := contract.(type) {
+	case map[string]any:
+		have, ok := got.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s is no longer an object", path)}
+		}
+		var problems []string
+		for key, value := range want {
+			if key == "summary" || key == "description" {
+				continue
+			}
+			if _, ok := have[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s/%s was removed", path, key))
+				continue
+			}
+			problems = append(problems, incompatibilities(path+"/"+key, value, have[key])...)
+		}
+		sort.Strings(problems)
+		return problems
+	case []any:
+		// Parameters are matched by name, as their order does not matter.
+		have, _ := got.([]any)
+		byName := map[any]any{}
+		for _, v := range have {
+			if m, ok := v.(map[string]any); ok {
+				byName[m["name"]] = v
+			}
+		}
+		var problems []string
+		for i, v := range want {
+			m, ok := v.(map[string]any)
+			if !ok || m["name"] == nil {
+				if i >= len(have) || !reflect.DeepEqual(v, have[i]) {
+					problems = append(problems, fmt.Sprintf("%s[%d] changed", path, i))
+				}
+				continue
+			}
+			if _, ok := byName[m["name"]]; !ok {
+				problems = append(problems, fmt.Sprintf("%s parameter %v was removed", path, m["name"]))
+				continue
+			}
+			problems = append(problems, incompatibilities(fmt.Sprintf("%s[%v]", path, m["name"]), v, byName[m["name"]])...)
+		}
+		return problems
+	default:
+		if !reflect.DeepEqual(contract, got) {
+			return []string{fmt.Sprintf("%s changed from %v to %v", path, contract, got)}
+		}
+		return nil
+	}
+}
+
+func TestIncompatibilities(t *testing.T) {
+	contract := map[string]any{
+		"/info": map[string]any{"get": map[string]any{
+			"summary":    "Book metadata",
+			"parameters": []any{map[string]any{"name": "a", "in": "query"}, map[string]any{"name": "b", "in": "query"}},
+			"schema":     map[string]any{"properties": map[string]any{"title": map[string]any{"type": "string"}}},
+		}},
+	}
+
+	tests := []struct {
+		name string
+		got  map[string]any
+		want int
+	}{
+		{
+			name: "additions and new summary",
+			got: map[string]any{
+				"/info": map[string]any{"get": map[string]any{
+					"summary":    "Metadata of the book",
+					"parameters": []any{map[string]any{"name": "c", "in": "query"}, map[string]any{"name": "b", "in": "query"}, map[string]any{"name": "a", "in": "query"}},
+					"schema":     map[string]any{"properties": map[string]any{"title": map[string]any{"type": "string"}, "isbn": map[string]any{"type": "string"}}},
+				}},
+				"/spine": map[string]any{},
+			},
+		},
+		{
+			name: "removed parameter and changed field type",
+			got: map[string]any{
+				"/info": map[string]any{"get": map[string]any{
+					"parameters": []any{map[string]any{"name": "a", "in": "query"}},
+					"schema":     map[string]any{"properties": map[string]any{"title": map[string]any{"type": "integer"}}},
+				}},
+			},
+			want: 2,
+		},
+		{
+			name: "removed endpoint",
+			got:  map[string]any{},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := incompatibilities("", contract, tt.got); len(got) != tt.want {
+				t.Errorf("incompatibilities() = %q, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyAPI(t *testing.T) {
+	app := fiber.New()
+	app.Use("/api", legacyAPI())
+	api := app.Group(apiV1)
+	api.Get("/jobs/:id/logs", func(c *fiber.Ctx) error {
+		return c.SendString(c.Params("id") + " " + c.Query("level"))
+	})
+
+	tests := []struct {
+		path       string
+		status     int
+		body       string
+		deprecated bool
+	}{
+		{path: "/api/v1/jobs/7/logs?level=warn", status: 200, body: "7 warn"},
+		{path: "/api/jobs/7/logs?level=warn", status: 200, body: "7 warn", deprecated: true},
+		{path: "/api/v2/jobs/7/logs", status: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if body, _ := io.ReadAll(resp.Body); tt.body != "" && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if got := resp.Header.Get("Deprecation") == "true"; got != tt.deprecated {
+				t.Errorf("deprecated = %v, want %v", got, tt.deprecated)
+			}
+			if tt.deprecated && resp.Header.Get(fiber.HeaderLink) != `</api/v1/jobs/7/logs>; rel="successor-version"` {
+				t.Errorf("Link = %q", resp.Header.Get(fiber.HeaderLink))
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The scripts and stylesheets of the pages are built into the binary, so
+// serve needs no network and serves the assets of the version it is. Pages
+// load the bundles of package bundle, rebuilt from the sources in assets with
+// go generate ./cmd.
+//
+//go:generate go run ../scripts/bundle -src assets -out assets/dist
+//go:embed assets/dist/*
+var embeddedAssets embed.FS
+
+// registerAssets serves the embedded assets under /assets. They change only
+// with the binary, so browsers revalidate them by their ETag.
+func registerAssets(app *fiber.App) {
+	app.Get(serveBasePath+"/assets/:filename", func(c *fiber.Ctx) error {
+		filename := c.Params("filename")
+		content, err := embeddedAssets.ReadFile("assets/dist/" + filename)
+		if err != nil || strings.Contains(filename, "/") {
+			return fiber.ErrNotFound
+		}
+
+		sum := sha256.Sum256(content)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if c.Get(fiber.HeaderIfNoneMatch) == etag {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		// Browsers ignore stylesheets served as text/plain.
+		c.Type(strings.TrimPrefix(path.Ext(filename), "."))
+		return c.Send(content)
+	})
+}
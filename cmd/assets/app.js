@@ -1,34 +1,179 @@
+// bookBase is the path the book is served under, "" when it is served at the
+// root; the API of the book is under it too.
+const bookBase = document.querySelector('meta[name="epubtrans-base"]')?.content || '';
+// chapterPath is the path of the chapter in the book, as the API expects it.
+const chapterPath = window.location.pathname.slice(bookBase.length);
+
 function enableContentEditable() {
     document.querySelectorAll('[data-translation-id]').forEach(element => {
-        let originalContent = element.innerHTML;
+        // savedContent is what the server has; undo sets it as well.
+        element.savedContent = element.innerHTML;
         element.contentEditable = true;
+        element.addEventListener('focus', function () {
+            lockSegment(this);
+        });
         element.addEventListener('blur', function () {
-            if (!isTranslating && this.innerHTML !== originalContent) {
-                originalContent = this.innerHTML;
-            updateTranslateContent(this.dataset.translationId, this.innerHTML);
-}
+            let saved = Promise.resolve();
+            if (!isTranslating && this.innerHTML !== this.savedContent) {
+                this.savedContent = this.innerHTML;
+                saved = updateTranslateContent(this.dataset.translationId, this.innerHTML);
+            }
+            // Keep AI batches off the segment until the edit is saved.
+            this.saving = saved.finally(() => unlockSegment(this));
         });
     });
 }
 
+// lockHolder identifies this page in the locks of the segments edited in it.
+const lockHolder = window.crypto && crypto.randomUUID ? crypto.randomUUID() : String(Math.random()).slice(2);
+
+// lockedContentID returns the content id of the original of a translation.
+function lockedContentID(element) {
+    const original = document.querySelector(`[data-translation-by-id="${element.dataset.translationId}"]`);
+    return original ? original.dataset.contentId : null;
+}
+
+function segmentLockRequest(method, element) {
+    return fetch(bookBase + '/api/v1/segment-lock', {
+        method,
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+        body: JSON.stringify({ file_path: chapterPath, content_id: lockedContentID(element), holder: lockHolder })
+    });
+}
+
+// lockSegment keeps AI batches from translating the segment while its
+// translation is edited, renewing the lock until the translation loses the
+// focus. A segment an AI batch is about to translate cannot be edited.
+function lockSegment(element) {
+    if (!lockedContentID(element)) {
+        return;
+    }
+    segmentLockRequest('POST', element)
+        .then(response => response.json())
+        .then(lock => {
+            if (lock.error) {
+                element.blur();
+                markLocked(element, lock.error);
+                return;
+            }
+            clearInterval(element.lockRenewal);
+            element.lockRenewal = setInterval(() => segmentLockRequest('POST', element), 60000);
+        })
+        .catch(error => console.error('Error locking segment:', error));
+}
+
+function unlockSegment(element) {
+    clearInterval(element.lockRenewal);
+    if (!lockedContentID(element)) {
+        return;
+    }
+    segmentLockRequest('DELETE', element)
+        .catch(error => console.error('Error unlocking segment:', error));
+}
+
+// markLocked shows that a segment cannot be edited, or with an empty reason
+// that it can again.
+function markLocked(element, reason) {
+    element.classList.toggle('epubtrans-locked', !!reason);
+    if (!element.dataset.translationId) {
+        element.title = reason;
+        return;
+    }
+    element.contentEditable = !reason && !isTranslating;
+    if (reason) {
+        element.title = reason;
+    } else {
+        showProvenance(element);
+    }
+}
+
+// showLocks marks the segments locked by AI batches, and those edited on
+// other pages, and unmarks those no longer locked.
+function showLocks() {
+    return fetch(`${bookBase}/api/v1/segment-locks?file_path=${encodeURIComponent(chapterPath)}`)
+        .then(response => response.json())
+        .then(locks => {
+            const reasons = new Map();
+            locks.filter(lock => lock.holder !== lockHolder).forEach(lock => {
+                reasons.set(lock.content_id, lock.kind === 'ai' ? `Being translated by AI ${lock.holder}` : 'Being edited on another page');
+            });
+            document.querySelectorAll('[data-content-id]').forEach(original => {
+                const reason = reasons.get(original.dataset.contentId) || '';
+                const translation = original.dataset.translationById &&
+                    document.querySelector(`[data-translation-id="${original.dataset.translationById}"]`);
+                for (const element of [original, translation]) {
+                    if (element && (reason || element.classList.contains('epubtrans-locked'))) {
+                        markLocked(element, reason);
+                    }
+                }
+            });
+        })
+        .catch(error => console.error('Error loading segment locks:', error));
+}
 
 function updateTranslateContent(translationID, translationContent) {
-    fetch('/api/update-translation', {
+    return fetch(bookBase + '/api/v1/update-translation', {
         method: 'PATCH',
         headers: {
             'Content-Type': 'application/json',
+            'X-CSRF-Token': csrfToken(),
         },
         body: JSON.stringify({
-            file_path: window.location.pathname,
+            file_path: chapterPath,
             translation_id: translationID,
             translation_content: translationContent
         })
     })
         .then(response => response.json())
-        .then(data => console.log('Success:', data))
+        .then(data => {
+            if (data.error) {
+                alert('Translation not saved: ' + data.error);
+                return;
+            }
+            console.log('Success:', data);
+            const element = document.querySelector(`[data-translation-id="${translationID}"]`);
+            if (!element) {
+                return;
+            }
+            if (data.removed && data.removed.length > 0) {
+                // Show what was actually saved after removing unsafe markup.
+                element.innerHTML = data.translation_content;
+                console.warn('Removed from translation:', data.removed);
+            }
+            element.dataset.translationProvider = 'manual';
+            delete element.dataset.translationModel;
+            delete element.dataset.translationPromptVersion;
+            delete element.dataset.translationSampling;
+            showProvenance(element);
+        })
         .catch((error) => console.error('Error:', error));
 }
 
+// provenanceText describes what produced a translation.
+function provenanceText(element) {
+    const provider = element.dataset.translationProvider || 'unknown provider';
+    let text = 'Translated by ' + provider;
+    if (element.dataset.translationModel) {
+        text += '/' + element.dataset.translationModel;
+    }
+    if (element.dataset.translationPromptVersion) {
+        text += ' (prompt ' + element.dataset.translationPromptVersion + ')';
+    }
+    if (element.dataset.translationSampling) {
+        text += ' [' + element.dataset.translationSampling + ']';
+    }
+    return text;
+}
+
+// showProvenance shows what produced a translation when hovering over it and,
+// since touch screens cannot hover, in the action bar when it is selected.
+function showProvenance(element) {
+    element.title = provenanceText(element);
+    if (actionBar && actionBar.selected === element) {
+        actionBar.provenance.textContent = element.title;
+    }
+}
+
 
 function addTranslateButtons() {
     document.querySelectorAll('[data-content-id]').forEach(element => {
@@ -69,40 +214,622 @@ function translateContent(contentId, translationID, button, instructions) {
     button.textContent = 'Translating...';
     button.classList.add('loading');
 
-    fetch('/api/ai-translate', {
-        method: 'POST',
-        headers: {
-            'Content-Type': 'application/json',
-        },
-        body: JSON.stringify({
-            file_path: window.location.pathname,
-            content_id: contentId,
-            translation_id: translationID,
-            instructions: instructions
-        })
+    const previous = element.innerHTML;
+    let streamed = '';
+
+    streamTranslation({
+        file_path: chapterPath,
+        content_id: contentId,
+        translation_id: translationID,
+        instructions: instructions,
+        holder: lockHolder
+    }, function (delta) {
+        // Show the translation as it arrives
+        streamed += delta;
+        element.innerHTML = streamed;
     })
-    .then(response => response.json())
-    .then(data => {
-        if (data.translated_content) {
-            const element = document.querySelector(`[data-translation-id="${translationID}"]`);
-            element.innerHTML = data.translated_content;
-            element.contentEditable = true
-        }
+    .then(translated => {
+        element.innerHTML = translated;
+    })
+    .catch((error) => {
+        element.innerHTML = previous;
+        console.error('Translation Error:', error);
     })
-    .catch((error) => console.error('Translation Error:', error))
     .finally(() => {
         // Re-enable the button and remove loading state
         button.disabled = false;
         button.textContent = 'Translate';
         button.classList.remove('loading');
-        // Re-enable editing
+        // Re-enable editing; on touch screens focusing would pop up the keyboard
         isTranslating = false;
         element.contentEditable = true;
-        element.focus();
+        if (!isTouchScreen()) {
+            element.focus();
+        }
+    });
+}
+
+// streamTranslation posts an AI translation request and passes every piece of
+// the translation to onDelta as the server streams it. It resolves with the
+// whole translation.
+async function streamTranslation(body, onDelta) {
+    const response = await fetch(bookBase + '/api/v1/ai-translate/stream', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+            'X-CSRF-Token': csrfToken(),
+        },
+        body: JSON.stringify(body)
+    });
+    if (!response.ok) {
+        const data = await response.json().catch(() => ({}));
+        throw new Error(data.error || response.statusText);
+    }
+
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = '';
+    for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+            throw new Error('Translation stream ended early');
+        }
+        buffer += decoder.decode(value, { stream: true });
+
+        // Events are separated by a blank line
+        let end;
+        while ((end = buffer.indexOf('\n\n')) !== -1) {
+            const event = parseEvent(buffer.slice(0, end));
+            buffer = buffer.slice(end + 2);
+            if (event.type === 'delta') {
+                onDelta(event.data.text);
+            } else if (event.type === 'done') {
+                return event.data.translated_content;
+            } else if (event.type === 'error') {
+                throw new Error(event.data.error);
+            }
+        }
+    }
+}
+
+function parseEvent(text) {
+    const event = { type: 'message', data: null };
+    for (const line of text.split('\n')) {
+        if (line.startsWith('event:')) {
+            event.type = line.slice(6).trim();
+        } else if (line.startsWith('data:')) {
+            event.data = JSON.parse(line.slice(5));
+        }
+    }
+    return event;
+}
+
+function isTouchScreen() {
+    return window.matchMedia('(hover: none)').matches;
+}
+
+// ensureViewport makes phones and tablets render the chapter at their own
+// width; most EPUB documents do not declare a viewport.
+function ensureViewport() {
+    if (document.querySelector('meta[name="viewport"]')) {
+        return;
+    }
+    const meta = document.createElement('meta');
+    meta.name = 'viewport';
+    meta.content = 'width=device-width, initial-scale=1';
+    document.head.appendChild(meta);
+}
+
+let actionBar = null;
+
+// addActionBar adds the bar at the bottom of the screen with the actions on
+// the selected segment. Tapping a translation or its original selects it, so
+// every action works without hovering or a mouse.
+function addActionBar() {
+    const bar = document.createElement('div');
+    bar.className = 'action-bar';
+
+    const provenance = document.createElement('span');
+    provenance.className = 'action-provenance';
+    provenance.textContent = 'Tap a paragraph to select it';
+
+    const input = document.createElement('input');
+    input.type = 'text';
+    input.placeholder = 'Instructions for AI';
+    input.className = 'translate-instructions';
+    input.enterKeyHint = 'send';
+
+    const button = document.createElement('button');
+    button.textContent = 'Translate';
+    button.className = 'translate-button';
+    button.disabled = true;
+
+    const listen = document.createElement('button');
+    listen.textContent = 'Listen';
+    listen.className = 'listen-button';
+    listen.title = 'Read the translation aloud; Shift-click to read the original';
+    listen.disabled = true;
+
+    bar.appendChild(provenance);
+    bar.appendChild(input);
+    bar.appendChild(button);
+    bar.appendChild(listen);
+    document.body.appendChild(bar);
+
+    actionBar = { bar, provenance, button, selected: null };
+
+    function select(element) {
+        if (!element || actionBar.selected === element) {
+            return;
+        }
+        if (actionBar.selected) {
+            actionBar.selected.classList.remove('epubtrans-selected');
+        }
+        actionBar.selected = element;
+        element.classList.add('epubtrans-selected');
+        provenance.textContent = provenanceText(element);
+        button.disabled = false;
+        listen.disabled = false;
+    }
+
+    document.querySelectorAll('[data-translation-id]').forEach(element => {
+        element.addEventListener('focus', () => select(element));
+    });
+    document.querySelectorAll('[data-content-id][data-translation-by-id]').forEach(element => {
+        element.addEventListener('click', () => {
+            select(document.querySelector(`[data-translation-id="${element.dataset.translationById}"]`));
+        });
+    });
+
+    function translateSelected() {
+        const element = actionBar.selected;
+        if (!element || button.disabled) {
+            return;
+        }
+        const original = document.querySelector(`[data-translation-by-id="${element.dataset.translationId}"]`);
+        if (!original) {
+            return;
+        }
+        translateContent(original.dataset.contentId, element.dataset.translationId, button, input.value);
+    }
+
+    button.addEventListener('click', translateSelected);
+    listen.addEventListener('click', event => {
+        const element = actionBar.selected;
+        const original = element && document.querySelector(`[data-translation-by-id="${element.dataset.translationId}"]`);
+        if (original) {
+            speakSegment(original.dataset.contentId, event.shiftKey, listen);
+        }
+    });
+    input.addEventListener('keydown', event => {
+        if (event.key === 'Enter') {
+            event.preventDefault();
+            input.blur();
+            translateSelected();
+        }
+    });
+
+    return bar;
+}
+
+let speech = null;
+
+// speakSegment reads a translation, or with original its original, aloud with
+// the text to speech provider of serve. Clicking again while it plays stops it.
+function speakSegment(contentId, original, button) {
+    if (speech) {
+        speech.pause();
+        URL.revokeObjectURL(speech.src);
+        speech = null;
+        button.textContent = 'Listen';
+        return;
+    }
+
+    button.disabled = true;
+    button.textContent = 'Loading...';
+    fetch(bookBase + '/api/v1/speak', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+        body: JSON.stringify({ file_path: chapterPath, content_id: contentId, original })
+    })
+    .then(async response => {
+        if (!response.ok) {
+            const body = await response.json().catch(() => ({}));
+            throw new Error(body.error || response.statusText);
+        }
+        return response.blob();
+    })
+    .then(audio => {
+        speech = new Audio(URL.createObjectURL(audio));
+        speech.addEventListener('ended', () => {
+            URL.revokeObjectURL(speech.src);
+            speech = null;
+            button.textContent = 'Listen';
+        });
+        button.textContent = 'Stop';
+        return speech.play();
+    })
+    .catch(error => {
+        button.textContent = 'Listen';
+        speech = null;
+        alert('Reading aloud failed: ' + error.message);
+    })
+    .finally(() => {
+        button.disabled = false;
+    });
+}
+
+function addLogViewer(bar) {
+    const toggle = document.createElement('button');
+    toggle.textContent = 'Logs';
+    toggle.className = 'log-toggle';
+
+    const pane = document.createElement('div');
+    pane.className = 'log-pane';
+    pane.hidden = true;
+
+    const controls = document.createElement('div');
+    controls.className = 'log-controls';
+
+    const jobSelect = document.createElement('select');
+
+    const levelSelect = document.createElement('select');
+    ['DEBUG', 'INFO', 'WARN', 'ERROR'].forEach(level => {
+        const option = document.createElement('option');
+        option.value = level;
+        option.textContent = level;
+        levelSelect.appendChild(option);
+    });
+    levelSelect.value = 'INFO';
+
+    const chapterOnly = document.createElement('label');
+    const chapterCheckbox = document.createElement('input');
+    chapterCheckbox.type = 'checkbox';
+    chapterCheckbox.checked = true;
+    chapterOnly.appendChild(chapterCheckbox);
+    chapterOnly.appendChild(document.createTextNode(' this chapter'));
+
+    const entries = document.createElement('div');
+    entries.className = 'log-entries';
+
+    controls.appendChild(jobSelect);
+    controls.appendChild(levelSelect);
+    controls.appendChild(chapterOnly);
+    pane.appendChild(controls);
+    pane.appendChild(entries);
+
+    const chapter = decodeURIComponent(window.location.pathname.split('/').pop());
+
+    function loadLogs() {
+        if (!jobSelect.value) {
+            entries.textContent = 'No translation jobs yet.';
+            return;
+        }
+
+        const params = new URLSearchParams({ level: levelSelect.value });
+        if (chapterCheckbox.checked) {
+            params.set('file', chapter);
+        }
+
+        fetch(`${bookBase}/api/v1/jobs/${jobSelect.value}/logs?${params}`)
+            .then(response => response.json())
+            .then(data => {
+                entries.innerHTML = '';
+                if (!Array.isArray(data) || data.length === 0) {
+                    entries.textContent = 'No log entries.';
+                    return;
+                }
+                data.forEach(entry => {
+                    const line = document.createElement('div');
+                    line.className = 'log-entry log-' + entry.level.toLowerCase();
+                    const { time, level, msg, job, ...attrs } = entry;
+                    const details = Object.entries(attrs).map(([key, value]) => `${key}=${value}`).join(' ');
+                    line.textContent = `${new Date(time).toLocaleTimeString()} ${level} ${msg} ${details}`;
+                    entries.appendChild(line);
+                });
+            })
+            .catch((error) => console.error('Error loading logs:', error));
+    }
+
+    function loadJobs() {
+        fetch(bookBase + '/api/v1/jobs')
+            .then(response => response.json())
+            .then(jobs => {
+                jobSelect.innerHTML = '';
+                jobs.forEach(job => {
+                    const option = document.createElement('option');
+                    option.value = job.id;
+                    option.textContent = `${job.id} (${job.status})`;
+                    jobSelect.appendChild(option);
+                });
+                loadLogs();
+            })
+            .catch((error) => console.error('Error loading jobs:', error));
+    }
+
+    jobSelect.addEventListener('change', loadLogs);
+    levelSelect.addEventListener('change', loadLogs);
+    chapterCheckbox.addEventListener('change', loadLogs);
+
+    toggle.addEventListener('click', function () {
+        pane.hidden = !pane.hidden;
+        if (!pane.hidden) {
+            loadJobs();
+        }
+    });
+
+    bar.appendChild(toggle);
+    document.body.appendChild(pane);
+}
+
+// addChapterTranslation adds the button that queues AI translations of every
+// untranslated segment of the chapter on the server. While they run, the
+// button shows the progress and cancels them; the chapter is reloaded when
+// they are done.
+function addChapterTranslation(bar) {
+    const button = document.createElement('button');
+    button.textContent = 'Translate chapter';
+    button.className = 'batch-toggle';
+
+    const storageKey = 'epubtrans-batch:' + window.location.pathname;
+    let batchID = sessionStorage.getItem(storageKey);
+
+    function poll() {
+        fetch(`${bookBase}/api/v1/ai-translate-batch/${batchID}`)
+            .then(response => response.json())
+            .then(batch => {
+                if (batch.error) {
+                    throw new Error(batch.error);
+                }
+                if (batch.status === 'queued' || batch.status === 'running') {
+                    button.textContent = `Cancel (${batch.translated + batch.failed}/${batch.total})`;
+                    showLocks();
+                    setTimeout(poll, 2000);
+                    return;
+                }
+                showLocks();
+
+                sessionStorage.removeItem(storageKey);
+                batchID = null;
+                button.textContent = 'Translate chapter';
+                if (batch.failed > 0) {
+                    alert(`${batch.failed} of ${batch.total} segments were not translated; see the logs of job ${batch.job_id}.`);
+                }
+                if (batch.translated > 0) {
+                    window.location.reload();
+                }
+            })
+            .catch(error => {
+                console.error('Error loading batch:', error);
+                sessionStorage.removeItem(storageKey);
+                batchID = null;
+                button.textContent = 'Translate chapter';
+            });
+    }
+
+    button.addEventListener('click', function () {
+        const headers = { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() };
+        if (batchID) {
+            fetch(`${bookBase}/api/v1/ai-translate-batch/${batchID}`, { method: 'DELETE', headers })
+                .catch(error => console.error('Error cancelling batch:', error));
+            return;
+        }
+        if (!confirm('Translate every untranslated segment of this chapter with AI?')) {
+            return;
+        }
+
+        fetch(bookBase + '/api/v1/ai-translate-batch', {
+            method: 'POST',
+            headers,
+            body: JSON.stringify({ file_path: chapterPath })
+        })
+            .then(response => response.json())
+            .then(batch => {
+                if (batch.error) {
+                    alert('Chapter not translated: ' + batch.error);
+                    return;
+                }
+                batchID = batch.id;
+                sessionStorage.setItem(storageKey, batchID);
+                poll();
+            })
+            .catch(error => console.error('Error queueing batch:', error));
+    });
+
+    bar.appendChild(button);
+    if (batchID) {
+        poll();
+    }
+}
+
+// addCitationMode adds the choice of the citation mode of the chapter, in
+// which the titles, authors, DOIs and links of bibliography entries are left
+// untranslated. Auto mode tells whether it finds a bibliography.
+function addCitationMode(bar) {
+    const select = document.createElement('select');
+    select.className = 'citation-mode';
+    select.title = 'Citation mode: keep titles, authors, DOIs and links of bibliography entries';
+    const options = { auto: 'Citations: auto', on: 'Citations: on', off: 'Citations: off' };
+    for (const [value, label] of Object.entries(options)) {
+        const option = document.createElement('option');
+        option.value = value;
+        option.textContent = label;
+        select.appendChild(option);
+    }
+
+    function show(status) {
+        if (status.error) {
+            throw new Error(status.error);
+        }
+        select.value = status.mode;
+        select.options[0].textContent = 'Citations: auto (' + (status.detected ? 'bibliography' : 'none found') + ')';
+    }
+
+    const filePath = encodeURIComponent(chapterPath);
+    fetch(`${bookBase}/api/v1/citation-mode?file_path=${filePath}`)
+        .then(response => response.json())
+        .then(show)
+        .catch(error => console.error('Error loading citation mode:', error));
+
+    select.addEventListener('change', function () {
+        fetch(bookBase + '/api/v1/citation-mode', {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+            body: JSON.stringify({ file_path: chapterPath, mode: select.value })
+        })
+            .then(response => response.json())
+            .then(show)
+            .catch(error => alert('Citation mode not saved: ' + error.message));
+    });
+
+    bar.appendChild(select);
+}
+
+// addEditHistory adds the undoing of the latest edit of the selected
+// translation, and a link to the edits of the chapter.
+function addEditHistory(bar) {
+    const button = document.createElement('button');
+    button.textContent = 'Undo edit';
+    button.className = 'undo-button';
+    button.title = 'Restore the selected translation as it was before its latest edit';
+
+    button.addEventListener('click', function () {
+        const element = actionBar.selected;
+        if (!element) {
+            alert('Select a translation first.');
+            return;
+        }
+        fetch(bookBase + '/api/v1/undo-translation', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+            body: JSON.stringify({ file_path: chapterPath, translation_id: element.dataset.translationId })
+        })
+            .then(response => response.json())
+            .then(result => {
+                if (result.error) {
+                    throw new Error(result.error);
+                }
+                element.innerHTML = result.translation_content;
+                element.savedContent = element.innerHTML;
+                const origin = result.provenance || {};
+                const fields = { translationProvider: origin.provider, translationModel: origin.model, translationPromptVersion: origin.prompt_version, translationSampling: origin.sampling };
+                for (const [key, value] of Object.entries(fields)) {
+                    if (value) {
+                        element.dataset[key] = value;
+                    } else {
+                        delete element.dataset[key];
+                    }
+                }
+                showProvenance(element);
+            })
+            .catch(error => alert('Edit not undone: ' + error.message));
+    });
+
+    const link = document.createElement('a');
+    link.textContent = 'History';
+    link.href = bookBase + '/history' + chapterPath;
+
+    bar.appendChild(button);
+    bar.appendChild(link);
+}
+
+// addExport adds the download of the book as edited so far, packed by the
+// server as a bilingual or a translated-only EPUB.
+function addExport(bar) {
+    const select = document.createElement('select');
+    select.className = 'export-mode';
+    select.title = 'Pack the book and download the EPUB';
+    const options = { '': 'Export EPUB…', bilingual: 'Export: bilingual', translated: 'Export: translated only' };
+    for (const [value, label] of Object.entries(options)) {
+        const option = document.createElement('option');
+        option.value = value;
+        option.textContent = label;
+        select.appendChild(option);
+    }
+
+    select.addEventListener('change', function () {
+        const mode = select.value;
+        if (!mode) {
+            return;
+        }
+        select.disabled = true;
+        select.options[0].textContent = 'Exporting…';
+        select.value = '';
+
+        fetch(bookBase + '/api/v1/export', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+            body: JSON.stringify({ mode })
+        })
+            .then(response => {
+                if (!response.ok) {
+                    return response.json().then(body => { throw new Error(body.error); });
+                }
+                const disposition = response.headers.get('Content-Disposition') || '';
+                const match = disposition.match(/filename="?([^";]+)"?/);
+                return response.blob().then(blob => {
+                    const link = document.createElement('a');
+                    link.href = URL.createObjectURL(blob);
+                    link.download = match ? match[1] : `book-${mode}.epub`;
+                    document.body.appendChild(link);
+                    link.click();
+                    link.remove();
+                    URL.revokeObjectURL(link.href);
+                });
+            })
+            .catch(error => alert('Book not exported: ' + error.message))
+            .finally(() => {
+                select.disabled = false;
+                select.options[0].textContent = 'Export EPUB…';
+            });
+    });
+
+    bar.appendChild(select);
+}
+
+// listenForChanges reloads the chapter when it, or a stylesheet, is changed
+// on disk by another program, such as translate running meanwhile. A
+// translation being edited is saved first.
+function listenForChanges() {
+    if (!window.WebSocket) {
+        return;
+    }
+    const chapter = decodeURIComponent(chapterPath).replace(/^\/+/, '');
+    const scheme = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const socket = new WebSocket(`${scheme}//${window.location.host}${bookBase}/api/v1/live`);
+
+    socket.addEventListener('message', function (event) {
+        const message = JSON.parse(event.data);
+        if (message.type !== 'changed' || !message.files.some(file => file === chapter || file.endsWith('.css'))) {
+            return;
+        }
+        const editing = document.activeElement;
+        if (!editing || !editing.isContentEditable) {
+            window.location.reload();
+            return;
+        }
+        editing.addEventListener('blur', function () {
+            Promise.resolve(editing.saving).finally(() => window.location.reload());
+        }, { once: true });
+    });
+    // Reconnect after serve restarts.
+    socket.addEventListener('close', function () {
+        setTimeout(listenForChanges, 5000);
     });
 }
 
 window.onload = function (e) {
+    ensureViewport();
+    document.querySelectorAll('[data-translation-id]').forEach(showProvenance);
     enableContentEditable();
+    showLocks();
     addTranslateButtons();
+    const bar = addActionBar();
+    addChapterTranslation(bar);
+    addCitationMode(bar);
+    addEditHistory(bar);
+    addExport(bar);
+    addLogViewer(bar);
+    addThemeToggle(bar);
+    listenForChanges();
 }
@@ -0,0 +1,203 @@
+/* Generated by go generate ./cmd from cmd/assets; do not edit. */
+
+/* theme.js */
+// Theme of the serve UI: "auto" follows the colour scheme of the system,
+// "light" and "dark" are picked with the toggle and remembered in this
+// browser. Loaded before app.js and review.js, so the theme applies before
+// the page shows, and every page shares csrfToken from here.
+const themeStorageKey = 'epubtrans-theme';
+const themes = [
+    { name: 'auto', icon: '◐', label: 'Theme: system' },
+    { name: 'light', icon: '☀', label: 'Theme: light' },
+    { name: 'dark', icon: '☾', label: 'Theme: dark' },
+];
+
+function storedTheme() {
+    try {
+        return localStorage.getItem(themeStorageKey) || 'auto';
+    } catch (e) {
+        // Storage can be disabled, e.g. in private browsing.
+        return 'auto';
+    }
+}
+
+function applyTheme(name) {
+    if (name === 'light' || name === 'dark') {
+        document.documentElement.dataset.epubtransTheme = name;
+    } else {
+        delete document.documentElement.dataset.epubtransTheme;
+    }
+}
+
+// addThemeToggle adds a button cycling through the themes to container.
+function addThemeToggle(container) {
+    const button = document.createElement('button');
+    button.type = 'button';
+    button.className = 'theme-toggle';
+
+    function show(name) {
+        const theme = themes.find(t => t.name === name) || themes[0];
+        button.textContent = theme.icon;
+        button.title = theme.label;
+        button.setAttribute('aria-label', theme.label);
+    }
+
+    button.addEventListener('click', function () {
+        const current = document.documentElement.dataset.epubtransTheme || 'auto';
+        const index = themes.findIndex(t => t.name === current);
+        const next = themes[(index + 1) % themes.length].name;
+        try {
+            localStorage.setItem(themeStorageKey, next);
+        } catch (e) {
+            // The theme then only lasts for this page.
+        }
+        applyTheme(next);
+        show(next);
+    });
+
+    show(storedTheme());
+    container.appendChild(button);
+    return button;
+}
+
+// csrfToken returns the token serve sets in a cookie; mutating requests must
+// send it back so other sites cannot make them.
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)epubtrans_csrf=([^;]*)/);
+    return match ? match[1] : '';
+}
+
+applyTheme(storedTheme());
+
+/* review.js */
+// The /review pages: approve or reject each translation of the chapter, with
+// the buttons of a row or the keyboard, and keep a note on it. The /history
+// pages, which share the layout, undo edits.
+
+// bookBase is the path the book is served under, "" at the root.
+const bookBase = document.querySelector('meta[name="epubtrans-base"]')?.content || '';
+
+function saveVerdict(row, status) {
+    const filePath = document.querySelector('.review-progress').dataset.filePath;
+    const note = row.querySelector('.review-note').value;
+    // Clicking the current verdict again removes it.
+    if (row.dataset.status === status) {
+        status = '';
+    }
+
+    return fetch(`${bookBase}/api/v1/review/${encodeURIComponent(row.dataset.contentId)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+        body: JSON.stringify({ file_path: filePath, status: status, note: note })
+    })
+        .then(response => response.json())
+        .then(annotation => {
+            if (annotation.error) {
+                throw new Error(annotation.error);
+            }
+            row.dataset.status = annotation.status;
+            updateProgress();
+        })
+        .catch(error => alert('Verdict not saved: ' + error.message));
+}
+
+function updateProgress() {
+    const rows = document.querySelectorAll('.review-row');
+    const reviewed = document.querySelectorAll('.review-row[data-status="approved"], .review-row[data-status="rejected"]');
+    const progress = document.querySelector('.review-progress');
+    progress.firstChild.textContent = `${reviewed.length} of ${rows.length} segments reviewed. Keys: j/k to move, a to approve, r to reject.`;
+}
+
+function selectRow(row) {
+    document.querySelectorAll('.review-row.current').forEach(r => r.classList.remove('current'));
+    if (row) {
+        row.classList.add('current');
+        row.scrollIntoView({ block: 'nearest', behavior: 'smooth' });
+    }
+}
+
+// nextRow returns the row after the current one, or the first not reviewed.
+function nextRow(step) {
+    const rows = Array.from(document.querySelectorAll('.review-row'));
+    const current = document.querySelector('.review-row.current');
+    if (!current) {
+        return rows.find(r => r.dataset.status !== 'approved' && r.dataset.status !== 'rejected') || rows[0];
+    }
+    const index = rows.indexOf(current) + step;
+    return rows[Math.max(0, Math.min(rows.length - 1, index))];
+}
+
+document.addEventListener('DOMContentLoaded', function () {
+    if (!document.querySelector('.review-row')) {
+        return;
+    }
+
+    document.querySelectorAll('.review-row').forEach(row => {
+        row.querySelectorAll('button[data-verdict]').forEach(button => {
+            button.addEventListener('click', function () {
+                selectRow(row);
+                saveVerdict(row, button.dataset.verdict);
+            });
+        });
+        // A changed note is saved with the current verdict.
+        row.querySelector('.review-note').addEventListener('change', function () {
+            if (row.dataset.status === 'approved' || row.dataset.status === 'rejected') {
+                const status = row.dataset.status;
+                row.dataset.status = '';
+                saveVerdict(row, status);
+            }
+        });
+    });
+
+    document.addEventListener('keydown', function (event) {
+        if (event.target.tagName === 'INPUT' || event.ctrlKey || event.metaKey || event.altKey) {
+            return;
+        }
+        const current = document.querySelector('.review-row.current');
+        switch (event.key) {
+            case 'j':
+                selectRow(nextRow(1));
+                break;
+            case 'k':
+                selectRow(nextRow(-1));
+                break;
+            case 'a':
+            case 'r':
+                if (current) {
+                    saveVerdict(current, event.key === 'a' ? 'approved' : 'rejected')
+                        .then(() => selectRow(nextRow(1)));
+                }
+                break;
+            default:
+                return;
+        }
+        event.preventDefault();
+    });
+
+    selectRow(nextRow(1));
+});
+
+// On the /history pages, Undo reverts the latest edit of a translation.
+document.addEventListener('DOMContentLoaded', function () {
+    const file = document.querySelector('.history-file');
+    if (!file) {
+        return;
+    }
+    document.querySelectorAll('.history-undo').forEach(button => {
+        button.addEventListener('click', function () {
+            fetch(bookBase + '/api/v1/undo-translation', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+                body: JSON.stringify({ file_path: file.dataset.filePath, translation_id: button.dataset.translationId })
+            })
+                .then(response => response.json())
+                .then(result => {
+                    if (result.error) {
+                        throw new Error(result.error);
+                    }
+                    window.location.reload();
+                })
+                .catch(error => alert('Edit not undone: ' + error.message));
+        });
+    });
+});
@@ -0,0 +1,131 @@
+// The /review pages: approve or reject each translation of the chapter, with
+// the buttons of a row or the keyboard, and keep a note on it. The /history
+// pages, which share the layout, undo edits.
+
+// bookBase is the path the book is served under, "" at the root.
+const bookBase = document.querySelector('meta[name="epubtrans-base"]')?.content || '';
+
+function saveVerdict(row, status) {
+    const filePath = document.querySelector('.review-progress').dataset.filePath;
+    const note = row.querySelector('.review-note').value;
+    // Clicking the current verdict again removes it.
+    if (row.dataset.status === status) {
+        status = '';
+    }
+
+    return fetch(`${bookBase}/api/v1/review/${encodeURIComponent(row.dataset.contentId)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+        body: JSON.stringify({ file_path: filePath, status: status, note: note })
+    })
+        .then(response => response.json())
+        .then(annotation => {
+            if (annotation.error) {
+                throw new Error(annotation.error);
+            }
+            row.dataset.status = annotation.status;
+            updateProgress();
+        })
+        .catch(error => alert('Verdict not saved: ' + error.message));
+}
+
+function updateProgress() {
+    const rows = document.querySelectorAll('.review-row');
+    const reviewed = document.querySelectorAll('.review-row[data-status="approved"], .review-row[data-status="rejected"]');
+    const progress = document.querySelector('.review-progress');
+    progress.firstChild.textContent = `${reviewed.length} of ${rows.length} segments reviewed. Keys: j/k to move, a to approve, r to reject.`;
+}
+
+function selectRow(row) {
+    document.querySelectorAll('.review-row.current').forEach(r => r.classList.remove('current'));
+    if (row) {
+        row.classList.add('current');
+        row.scrollIntoView({ block: 'nearest', behavior: 'smooth' });
+    }
+}
+
+// nextRow returns the row after the current one, or the first not reviewed.
+function nextRow(step) {
+    const rows = Array.from(document.querySelectorAll('.review-row'));
+    const current = document.querySelector('.review-row.current');
+    if (!current) {
+        return rows.find(r => r.dataset.status !== 'approved' && r.dataset.status !== 'rejected') || rows[0];
+    }
+    const index = rows.indexOf(current) + step;
+    return rows[Math.max(0, Math.min(rows.length - 1, index))];
+}
+
+document.addEventListener('DOMContentLoaded', function () {
+    if (!document.querySelector('.review-row')) {
+        return;
+    }
+
+    document.querySelectorAll('.review-row').forEach(row => {
+        row.querySelectorAll('button[data-verdict]').forEach(button => {
+            button.addEventListener('click', function () {
+                selectRow(row);
+                saveVerdict(row, button.dataset.verdict);
+            });
+        });
+        // A changed note is saved with the current verdict.
+        row.querySelector('.review-note').addEventListener('change', function () {
+            if (row.dataset.status === 'approved' || row.dataset.status === 'rejected') {
+                const status = row.dataset.status;
+                row.dataset.status = '';
+                saveVerdict(row, status);
+            }
+        });
+    });
+
+    document.addEventListener('keydown', function (event) {
+        if (event.target.tagName === 'INPUT' || event.ctrlKey || event.metaKey || event.altKey) {
+            return;
+        }
+        const current = document.querySelector('.review-row.current');
+        switch (event.key) {
+            case 'j':
+                selectRow(nextRow(1));
+                break;
+            case 'k':
+                selectRow(nextRow(-1));
+                break;
+            case 'a':
+            case 'r':
+                if (current) {
+                    saveVerdict(current, event.key === 'a' ? 'approved' : 'rejected')
+                        .then(() => selectRow(nextRow(1)));
+                }
+                break;
+            default:
+                return;
+        }
+        event.preventDefault();
+    });
+
+    selectRow(nextRow(1));
+});
+
+// On the /history pages, Undo reverts the latest edit of a translation.
+document.addEventListener('DOMContentLoaded', function () {
+    const file = document.querySelector('.history-file');
+    if (!file) {
+        return;
+    }
+    document.querySelectorAll('.history-undo').forEach(button => {
+        button.addEventListener('click', function () {
+            fetch(bookBase + '/api/v1/undo-translation', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+                body: JSON.stringify({ file_path: file.dataset.filePath, translation_id: button.dataset.translationId })
+            })
+                .then(response => response.json())
+                .then(result => {
+                    if (result.error) {
+                        throw new Error(result.error);
+                    }
+                    window.location.reload();
+                })
+                .catch(error => alert('Edit not undone: ' + error.message));
+        });
+    });
+});
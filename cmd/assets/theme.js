@@ -0,0 +1,67 @@
+// Theme of the serve UI: "auto" follows the colour scheme of the system,
+// "light" and "dark" are picked with the toggle and remembered in this
+// browser. Loaded before app.js and review.js, so the theme applies before
+// the page shows, and every page shares csrfToken from here.
+const themeStorageKey = 'epubtrans-theme';
+const themes = [
+    { name: 'auto', icon: '◐', label: 'Theme: system' },
+    { name: 'light', icon: '☀', label: 'Theme: light' },
+    { name: 'dark', icon: '☾', label: 'Theme: dark' },
+];
+
+function storedTheme() {
+    try {
+        return localStorage.getItem(themeStorageKey) || 'auto';
+    } catch (e) {
+        // Storage can be disabled, e.g. in private browsing.
+        return 'auto';
+    }
+}
+
+function applyTheme(name) {
+    if (name === 'light' || name === 'dark') {
+        document.documentElement.dataset.epubtransTheme = name;
+    } else {
+        delete document.documentElement.dataset.epubtransTheme;
+    }
+}
+
+// addThemeToggle adds a button cycling through the themes to container.
+function addThemeToggle(container) {
+    const button = document.createElement('button');
+    button.type = 'button';
+    button.className = 'theme-toggle';
+
+    function show(name) {
+        const theme = themes.find(t => t.name === name) || themes[0];
+        button.textContent = theme.icon;
+        button.title = theme.label;
+        button.setAttribute('aria-label', theme.label);
+    }
+
+    button.addEventListener('click', function () {
+        const current = document.documentElement.dataset.epubtransTheme || 'auto';
+        const index = themes.findIndex(t => t.name === current);
+        const next = themes[(index + 1) % themes.length].name;
+        try {
+            localStorage.setItem(themeStorageKey, next);
+        } catch (e) {
+            // The theme then only lasts for this page.
+        }
+        applyTheme(next);
+        show(next);
+    });
+
+    show(storedTheme());
+    container.appendChild(button);
+    return button;
+}
+
+// csrfToken returns the token serve sets in a cookie; mutating requests must
+// send it back so other sites cannot make them.
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)epubtrans_csrf=([^;]*)/);
+    return match ? match[1] : '';
+}
+
+applyTheme(storedTheme());
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/bundle"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRegisterAssets(t *testing.T) {
+	app := fiber.New()
+	registerAssets(app)
+
+	tests := []struct {
+		filename    string
+		status      int
+		contentType string
+	}{
+		{"editor.js", fiber.StatusOK, "text/javascript"},
+		{"theme.css", fiber.StatusOK, "text/css"},
+		{"review.js", fiber.StatusOK, "text/javascript"},
+		// Sources are only served in their bundles.
+		{"app.js", fiber.StatusNotFound, ""},
+		// Nothing is fetched from elsewhere.
+		{"missing.js", fiber.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/assets/"+tt.filename, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.filename, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status != fiber.StatusOK {
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("GET %s: Content-Type %q, want %q", tt.filename, got, tt.contentType)
+		}
+
+		req := httptest.NewRequest("GET", "/assets/"+tt.filename, nil)
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		if resp, err := app.Test(req); err != nil || resp.StatusCode != fiber.StatusNotModified {
+			t.Errorf("GET %s with its ETag: %v, %v; want 304", tt.filename, resp, err)
+		}
+	}
+}
+
+// TestAssetBundlesUpToDate fails when a source in assets changed without
+// rebuilding the bundles that serve embeds.
+func TestAssetBundlesUpToDate(t *testing.T) {
+	for _, b := range bundle.Bundles {
+		want, err := bundle.Build(os.DirFS("assets"), b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := embeddedAssets.ReadFile("assets/dist/" + b.Name)
+		if err != nil {
+			t.Fatalf("%s is not embedded: %v (run go generate ./cmd)", b.Name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("assets/dist/%s is out of date; run go generate ./cmd", b.Name)
+		}
+	}
+}
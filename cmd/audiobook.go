@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/tts"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	layoutTranslation = "translation"
+	layoutBilingual   = "bilingual"
+
+	granularitySentence  = "sentence"
+	granularityParagraph = "paragraph"
+)
+
+var (
+	ttsProvider    string
+	ttsModel       string
+	ttsVoice       string
+	ttsSourceVoice string
+	ttsSpeed       float64
+)
+
+var Audiobook = &cobra.Command{
+	Use:   "audiobook [unpackedEpubPath] [outputDir]",
+	Short: "Read a translated book aloud into one audio file per chapter",
+	Long: `This command reads the translated book aloud with a text to speech provider and writes one audio file per
+chapter to outputDir, numbered in reading order.
+
+The translation layout reads the translation, and the original of segments that are not translated. The bilingual
+layout, for language learners, reads every sentence of the original followed by its translation; paragraphs whose
+original and translation do not have the same number of sentences, and all paragraphs with --granularity paragraph,
+are read paragraph by paragraph instead.
+
+The pronunciation lexicon of the book applies to both languages. Chapters whose audio file exists are skipped, so an
+interrupted run continues where it stopped; delete the files to record them again.`,
+	Example: `epubtrans audiobook path/to/unpacked/epub audio --layout bilingual --voice nova --source-voice onyx`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and outputDir are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runAudiobook,
+}
+
+func init() {
+	Audiobook.Flags().String("layout", layoutTranslation, "what to read: translation, or bilingual to follow every sentence of the original by its translation")
+	Audiobook.Flags().String("granularity", granularitySentence, "unit of the bilingual layout: sentence or paragraph")
+	Audiobook.Flags().StringVar(&ttsProvider, "tts-provider", "openai", "text to speech provider: "+strings.Join(tts.Providers(), ", "))
+	Audiobook.Flags().StringVar(&ttsModel, "tts-model", "", "model of the provider; for piper, the .onnx voice model")
+	Audiobook.Flags().StringVar(&ttsVoice, "voice", "", "voice reading the translation; defaults to the provider's default voice")
+	Audiobook.Flags().StringVar(&ttsSourceVoice, "source-voice", "", "voice reading the original; defaults to --voice")
+	Audiobook.Flags().Float64Var(&ttsSpeed, "speed", 0, "speaking rate, 1 being normal; defaults to the provider's rate")
+}
+
+// utterance is text read by one voice.
+type utterance struct {
+	original bool
+	text     string
+}
+
+// audioSegment is the plain text of a segment and its translation, empty if
+// the segment is not translated.
+type audioSegment struct {
+	original    string
+	translation string
+}
+
+type audioChapter struct {
+	href     string
+	segments []audioSegment
+}
+
+func runAudiobook(cmd *cobra.Command, args []string) error {
+	unzipPath, outputDir := args[0], args[1]
+	layout, _ := cmd.Flags().GetString("layout")
+	granularity, _ := cmd.Flags().GetString("granularity")
+
+	if layout != layoutTranslation && layout != layoutBilingual {
+		return fmt.Errorf("invalid layout %q: use translation or bilingual", layout)
+	}
+	if granularity != granularitySentence && granularity != granularityParagraph {
+		return fmt.Errorf("invalid granularity %q: use sentence or paragraph", granularity)
+	}
+
+	lexicon, err := loadBookLexicon(unzipPath)
+	if err != nil {
+		return err
+	}
+	target, err := newSpeaker(ttsVoice, lexicon)
+	if err != nil {
+		return err
+	}
+	source := target
+	if ttsSourceVoice != "" && ttsSourceVoice != ttsVoice {
+		if source, err = newSpeaker(ttsSourceVoice, lexicon); err != nil {
+			return err
+		}
+	}
+
+	chapters, err := collectAudioChapters(unzipPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", outputDir, err)
+	}
+
+	for i, chapter := range chapters {
+		name := strings.TrimSuffix(filepath.Base(chapter.href), filepath.Ext(chapter.href))
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("%03d-%s.%s", i+1, name, target.Format()))
+		if _, err := os.Stat(outputPath); err == nil {
+			fmt.Printf("Skipping %s: %s exists\n", chapter.href, filepath.Base(outputPath))
+			continue
+		}
+
+		script := audioScript(chapter.segments, layout, granularity)
+		fmt.Printf("Recording %s (%d segments, %d utterances)\n", chapter.href, len(chapter.segments), len(script))
+
+		clips := make([][]byte, 0, len(script))
+		for _, u := range script {
+			speaker := target
+			if u.original {
+				speaker = source
+			}
+			clip, err := speaker.Synthesize(cmd.Context(), u.text)
+			if err != nil {
+				return fmt.Errorf("synthesizing %s: %w", chapter.href, err)
+			}
+			clips = append(clips, clip)
+		}
+
+		audio, err := tts.Concat(target.Format(), clips)
+		if err != nil {
+			return fmt.Errorf("joining the audio of %s: %w", chapter.href, err)
+		}
+		if err := util.WriteFileAtomic(outputPath, audio, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", outputPath, err)
+		}
+	}
+
+	fmt.Printf("Audiobook written to %s\n", outputDir)
+	return nil
+}
+
+// newSpeaker creates a synthesizer of --tts-provider with voice that applies
+// the pronunciation lexicon.
+func newSpeaker(voice string, lexicon *tts.Lexicon) (tts.Synthesizer, error) {
+	s, err := tts.New(ttsProvider, &tts.Config{Model: ttsModel, Voice: voice, Speed: ttsSpeed})
+	if err != nil {
+		return nil, fmt.Errorf("error getting text to speech provider: %w", err)
+	}
+	return tts.WithLexicon(s, lexicon), nil
+}
+
+// collectAudioChapters returns the chapters of the book in reading order with
+// the text of their segments. Translations are looked up in the whole book,
+// as endnote placement moves them to a chapter of their own.
+func collectAudioChapters(unzipPath string) ([]audioChapter, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	translations := map[string]string{}
+	var chapters []audioChapter
+	var originals [][]*goquery.Selection
+
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" || processor.ShouldExcludeFile(item.Href) {
+			continue
+		}
+
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			translations[s.AttrOr(util.TranslationIdKey, "")] = spokenText(s)
+		})
+
+		segments := doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey))
+		if segments.Length() == 0 {
+			continue
+		}
+		var chapterOriginals []*goquery.Selection
+		segments.Each(func(i int, s *goquery.Selection) {
+			chapterOriginals = append(chapterOriginals, s)
+		})
+		chapters = append(chapters, audioChapter{href: item.Href})
+		originals = append(originals, chapterOriginals)
+	}
+
+	for i := range chapters {
+		for _, s := range originals[i] {
+			segment := audioSegment{original: spokenText(s)}
+			if id, ok := s.Attr(util.TranslationByIdKey); ok {
+				segment.translation = translations[id]
+			}
+			if segment.original != "" || segment.translation != "" {
+				chapters[i].segments = append(chapters[i].segments, segment)
+			}
+		}
+	}
+
+	return chapters, nil
+}
+
+// spokenText returns the text of a segment without note references and page
+// numbers, and with its whitespace collapsed.
+func spokenText(s *goquery.Selection) string {
+	clone := s.Clone()
+	clone.Find("a.epubtrans-noteref, rt, rp").Remove()
+	clone.Find("*").FilterFunction(isPageBreak).Remove()
+	return strings.Join(strings.Fields(clone.Text()), " ")
+}
+
+// audioScript returns what to read of the segments in the layout. Adjacent
+// utterances of one voice are joined, so a chapter is read in as few requests
+// as possible.
+func audioScript(segments []audioSegment, layout, granularity string) []utterance {
+	var script []utterance
+	say := func(original bool, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(script); n > 0 && script[n-1].original == original {
+			script[n-1].text += "\n\n" + text
+			return
+		}
+		script = append(script, utterance{original: original, text: text})
+	}
+
+	for _, segment := range segments {
+		if segment.translation == "" {
+			say(true, segment.original)
+			continue
+		}
+		if layout == layoutTranslation {
+			say(false, segment.translation)
+			continue
+		}
+
+		originals, translations := alignSentences(segment, granularity)
+		for i := range originals {
+			say(true, originals[i])
+			say(false, translations[i])
+		}
+	}
+
+	return script
+}
+
+// alignSentences pairs the sentences of a translated segment with the
+// sentences of its translation. With paragraph granularity, or when their
+// numbers of sentences differ, the whole segment is one pair.
+func alignSentences(segment audioSegment, granularity string) (originals, translations []string) {
+	originals, translations = splitSentences(segment.original), splitSentences(segment.translation)
+	if granularity == granularityParagraph || len(originals) != len(translations) {
+		return []string{segment.original}, []string{segment.translation}
+	}
+	return originals, translations
+}
+
+// sentenceAbbreviations end with a period that does not end a sentence.
+var sentenceAbbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "prof": true, "vs": true, "etc": true, "e.g": true, "i.e": true, "no": true,
+}
+
+// splitSentences splits text after sentence terminators followed by a space,
+// or after the full-width terminators of CJK text.
+func splitSentences(text string) []string {
+	runes := []rune(text)
+	var sentences []string
+	start := 0
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		fullWidth := r == '。' || r == '！' || r == '？'
+		if !fullWidth && r != '.' && r != '!' && r != '?' && r != '…' {
+			continue
+		}
+
+		// Closing quotes and brackets belong to the sentence.
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(`"'”’»)]」』`, runes[end]) {
+			end++
+		}
+		if !fullWidth && end < len(runes) && !unicode.IsSpace(runes[end]) {
+			continue
+		}
+		if r == '.' {
+			words := strings.Fields(string(runes[start:i]))
+			if len(words) > 0 && sentenceAbbreviations[strings.ToLower(words[len(words)-1])] {
+				continue
+			}
+		}
+
+		if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start, i = end, end-1
+	}
+
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"One. Two! Three?", []string{"One.", "Two!", "Three?"}},
+		{`He said "Stop." Then he left.`, []string{`He said "Stop."`, "Then he left."}},
+		{"Mr. Smith met Dr. Who. Fine.", []string{"Mr. Smith met Dr. Who.", "Fine."}},
+		{"Pi is 3.14 exactly", []string{"Pi is 3.14 exactly"}},
+		{"第一句。第二句！", []string{"第一句。", "第二句！"}},
+		{"No terminator", []string{"No terminator"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitSentences(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAudioScript(t *testing.T) {
+	segments := []audioSegment{
+		{original: "Hello. Bye.", translation: "Xin chào. Tạm biệt."},
+		{original: "One. Two.", translation: "Một hai."},
+		{original: "Untranslated."},
+		{original: "Also untranslated."},
+	}
+
+	tests := []struct {
+		layout, granularity string
+		want                []utterance
+	}{
+		{layoutTranslation, granularitySentence, []utterance{
+			{false, "Xin chào. Tạm biệt.\n\nMột hai."},
+			{true, "Untranslated.\n\nAlso untranslated."},
+		}},
+		{layoutBilingual, granularitySentence, []utterance{
+			{true, "Hello."}, {false, "Xin chào."},
+			{true, "Bye."}, {false, "Tạm biệt."},
+			{true, "One. Two."}, {false, "Một hai."},
+			{true, "Untranslated.\n\nAlso untranslated."},
+		}},
+		{layoutBilingual, granularityParagraph, []utterance{
+			{true, "Hello. Bye."}, {false, "Xin chào. Tạm biệt."},
+			{true, "One. Two."}, {false, "Một hai."},
+			{true, "Untranslated.\n\nAlso untranslated."},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout+"/"+tt.granularity, func(t *testing.T) {
+			if got := audioScript(segments, tt.layout, tt.granularity); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("audioScript() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dutchsteven/epubtrans/pkg/evaluation"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+)
+
+// minBackTranslateChars is the length of the shortest original that is
+// back-translated: chrF says little about a few words.
+const minBackTranslateChars = 40
+
+const backTranslatePrompt = `Translate the text back literally, sentence by sentence, keeping its meaning exactly as it is, even where it seems wrong or odd. Do not improve, complete or explain it. Answer with the translation only.`
+
+// backTranslateJudgePrompt asks whether a back-translation kept the meaning
+// of its original, for the segments chrF cannot vouch for.
+const backTranslateJudgePrompt = `Do not translate this time. Below are an original text and a back-translation of its translation. Judge whether they mean the same: ignore wording, word order, style and synonyms, and look only for meaning that was added, lost or changed, such as other facts, numbers, names, negations, tense or who does what. Answer with SAME if the meaning is the same, or with DIFFERENT followed by a colon and one short sentence on what changed.`
+
+var BackTranslate = &cobra.Command{
+	Use:   "backtranslate [unpackedEpubPath]",
+	Short: "Back-translate a sample of the translations to flag likely meaning drift",
+	Long: `This command is a cheap automated fidelity check: it translates a random sample of the translations of the book
+back to the source language and compares every back-translation with its original. chrF is a pre-filter only: a
+back-translation scoring at least --threshold shares enough of the wording of its original to pass. The others, which
+may just be faithful translations that came back in other words, are judged by the model, which is asked whether the
+back-translation means the same as the original. Translations whose meaning changed are listed for a human to review.
+Originals shorter than 40 characters are left out, as their scores say little.
+
+Neither check is proof of fidelity. The report, with the scores and judgments of all segments of the sample, is kept
+next to the unpacked book as <unpacked-dir>-backtranslation.json.`,
+	Example: `epubtrans backtranslate path/to/unpacked/epub --sample 0.05 --threshold 30`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runBackTranslate,
+}
+
+func init() {
+	BackTranslate.Flags().String("source", "English", "language of the original text, which the translations are translated back to")
+	BackTranslate.Flags().StringVar(&translationProvider, "provider", providerFromEnv(), "translation provider: "+strings.Join(translator.Providers(), ", "))
+	BackTranslate.Flags().String("model", "", "model to use; defaults to the default model of the provider")
+	BackTranslate.Flags().StringVar(&mockFixture, "fixture", "", "with --provider mock, replay the translations recorded in this file with translate --record")
+	BackTranslate.Flags().Float64("sample", 0.1, "share of the translated segments to back-translate, from 0 to 1")
+	BackTranslate.Flags().Int64("seed", 1, "seed of the random sample; the same seed picks the same segments")
+	BackTranslate.Flags().Float64("threshold", 35, "chrF score, from 0 to 100, below which the model judges whether the meaning of a translation changed")
+}
+
+// backTranslation is a segment of the sample with its back-translation.
+type backTranslation struct {
+	File      string `json:"file"`
+	ContentID string `json:"content_id"`
+	// Original and Translation are the text of the segment, without markup.
+	Original        string  `json:"original"`
+	Translation     string  `json:"translation"`
+	Language        string  `json:"language"`
+	BackTranslation string  `json:"back_translation"`
+	Score           float64 `json:"chrf"`
+	// Judgment is the answer of the model for the segments scoring below the
+	// threshold, "" for the others.
+	Judgment string `json:"judgment,omitempty"`
+	Drift    bool   `json:"drift"`
+}
+
+// backTranslationReport is the result of a backtranslate run.
+type backTranslationReport struct {
+	Created   time.Time         `json:"created"`
+	Model     string            `json:"model"`
+	Threshold float64           `json:"threshold"`
+	Flagged   int               `json:"flagged"`
+	Segments  []backTranslation `json:"segments"`
+}
+
+func backTranslationReportPath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-backtranslation.json"
+}
+
+func runBackTranslate(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	source, _ := cmd.Flags().GetString("source")
+	model, _ := cmd.Flags().GetString("model")
+	share, _ := cmd.Flags().GetFloat64("sample")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	threshold, _ := cmd.Flags().GetFloat64("threshold")
+	if share <= 0 || share > 1 {
+		return fmt.Errorf("--sample must be more than 0 and at most 1")
+	}
+	if translationProvider == "deepl" {
+		return fmt.Errorf("backtranslate needs a language model to judge the meaning; deepl would translate the question instead")
+	}
+
+	sample, total, err := backTranslationSample(unzipPath, share, seed)
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("no translations of at least %d characters to back-translate", minBackTranslateChars)
+	}
+	fmt.Printf("Back-translating %d of %d translations into %s\n", len(sample), total, source)
+
+	provider, err := translator.New(translationProvider, &translator.Config{
+		Model:     model,
+		MaxTokens: 8192,
+		Fixture:   mockFixture,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting translator: %v", err)
+	}
+	limiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(runThrottle.requestsPerMinute)), runThrottle.burst)
+
+	flagged, err := backTranslateSample(cmd.Context(), provider, limiter, source, threshold, sample)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sample {
+		if !s.Drift {
+			continue
+		}
+		fmt.Printf("%s#%s: chrF %.1f, %s\n  original: %s\n  back:     %s\n", s.File, s.ContentID, s.Score, s.Judgment, s.Original, s.BackTranslation)
+	}
+
+	report := backTranslationReport{Created: time.Now().UTC(), Model: provider.Model(), Threshold: threshold, Flagged: flagged, Segments: sample}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(backTranslationReportPath(unzipPath), data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Flagged %d of %d translations whose meaning changed for review; report in %s\n", flagged, len(sample), backTranslationReportPath(unzipPath))
+	return nil
+}
+
+// backTranslationSample returns a random share of the translated segments of
+// the book, in reading order, and the number of segments it was drawn from.
+// The same seed draws the same sample from the same book.
+func backTranslationSample(unzipPath string, share float64, seed int64) ([]backTranslation, int, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unzipPath))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var candidates []backTranslation
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		segments, err := fileSegments(book, item.Href, reviews, newSegmentLocks())
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		for _, segment := range segments {
+			original := strings.Join(strings.Fields(plainText(segment.Source)), " ")
+			translation := strings.Join(strings.Fields(plainText(segment.Translation)), " ")
+			if translation == "" || utf8.RuneCountInString(original) < minBackTranslateChars {
+				continue
+			}
+			candidates = append(candidates, backTranslation{
+				File:        item.Href,
+				ContentID:   segment.ContentID,
+				Original:    original,
+				Translation: translation,
+				Language:    segment.Lang,
+			})
+		}
+	}
+
+	n := int(math.Ceil(share * float64(len(candidates))))
+	picked := rand.New(rand.NewSource(seed)).Perm(len(candidates))[:n]
+	sort.Ints(picked)
+	sample := make([]backTranslation, n)
+	for i, p := range picked {
+		sample[i] = candidates[p]
+	}
+	return sample, len(candidates), nil
+}
+
+// backTranslateSample translates the segments of sample back into source with
+// t and scores them. Those below threshold are judged by t, which flags those
+// whose meaning changed. It returns the number of flagged segments.
+func backTranslateSample(ctx context.Context, t translator.Translator, limiter translator.Limiter, source string, threshold float64, sample []backTranslation) (int, error) {
+	flagged := 0
+	for i := range sample {
+		s := &sample[i]
+		language := "the language of the text"
+		if s.Language != "" {
+			language = tm.LanguageName(s.Language)
+		}
+
+		if err := limiter.Wait(ctx); err != nil {
+			return 0, fmt.Errorf("rate limiter error: %w", err)
+		}
+		back, err := t.Translate(ctx, backTranslatePrompt, s.Translation, language, source, "")
+		if err != nil {
+			return 0, fmt.Errorf("back-translating %s#%s: %w", s.File, s.ContentID, err)
+		}
+
+		s.BackTranslation = strings.Join(strings.Fields(back), " ")
+		s.Score = evaluation.ComputeChrFStats(s.BackTranslation, s.Original).Score()
+		if s.Score >= threshold {
+			continue
+		}
+
+		if err := limiter.Wait(ctx); err != nil {
+			return 0, fmt.Errorf("rate limiter error: %w", err)
+		}
+		judgment, err := t.Translate(ctx, backTranslateJudgePrompt, backTranslateJudgeInput(s.Original, s.BackTranslation), source, source, "")
+		if err != nil {
+			return 0, fmt.Errorf("judging %s#%s: %w", s.File, s.ContentID, err)
+		}
+		s.Judgment = strings.Join(strings.Fields(judgment), " ")
+		s.Drift = !meaningKept(s.Judgment)
+		if s.Drift {
+			flagged++
+		}
+	}
+	return flagged, nil
+}
+
+// backTranslateJudgeInput is the text the model judges.
+func backTranslateJudgeInput(original, back string) string {
+	return "Original:\n" + original + "\n\nBack-translation:\n" + back
+}
+
+// meaningKept tells whether the judgment of the model says the meaning was
+// kept. An answer that is neither SAME nor DIFFERENT flags the segment, for a
+// human to look at.
+func meaningKept(judgment string) bool {
+	answer := strings.ToUpper(strings.TrimLeft(judgment, " *\"'"))
+	return strings.HasPrefix(answer, "SAME") && !strings.Contains(answer, "DIFFERENT")
+}
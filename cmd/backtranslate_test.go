@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+// fixedBackTranslator translates every text to what its map holds for it.
+type fixedBackTranslator map[string]string
+
+func (f fixedBackTranslator) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	return f[content], nil
+}
+
+func (f fixedBackTranslator) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	return f[content], nil
+}
+
+func TestBackTranslate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeSyncBook(t, dir)
+	editSyncChapter(t, dir, ">Hello<", ">The old man walked slowly down to the harbour at dawn.<")
+	editSyncChapter(t, dir, ">Hallo<", ">Der alte Mann ging im Morgengrauen langsam zum Hafen.<")
+	editSyncChapter(t, dir, `"tc">One<`, `"tc">She closed the door and never opened it again.<`)
+	editSyncChapter(t, dir, ">Eins<", ">Sie kaufte im Laden drei Äpfel.<")
+	editSyncChapter(t, dir, ">Two<", ">The letter arrived three days after the funeral.<")
+	editSyncChapter(t, dir, ">Zwei<", ">Der Brief kam drei Tage nach der Beerdigung an.<")
+
+	sample, total, err := backTranslationSample(dir, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b is not translated.
+	if total != 3 || len(sample) != 3 || sample[0].ContentID != "a" || sample[1].ContentID != "c" || sample[2].ContentID != "d" {
+		t.Fatalf("sample = %+v of %d", sample, total)
+	}
+	if half, _, _ := backTranslationSample(dir, 0.5, 1); len(half) != 2 {
+		t.Errorf("half sample has %d segments", len(half))
+	}
+
+	// a passes chrF; c and d score low and are judged, d as a faithful
+	// translation that came back in other words.
+	back := fixedBackTranslator{
+		"Der alte Mann ging im Morgengrauen langsam zum Hafen.": "At dawn the old man walked slowly to the harbour.",
+		"Sie kaufte im Laden drei Äpfel.":                       "In the shop, she bought three apples.",
+		"Der Brief kam drei Tage nach der Beerdigung an.":       "On the third day following the burial, post came.",
+	}
+	back[backTranslateJudgeInput(sample[1].Original, "In the shop, she bought three apples.")] = "DIFFERENT: another action entirely."
+	back[backTranslateJudgeInput(sample[2].Original, "On the third day following the burial, post came.")] = "SAME"
+	flagged, err := backTranslateSample(context.Background(), back, rate.NewLimiter(rate.Inf, 1), "English", 35, sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flagged != 1 || sample[0].Drift || !sample[1].Drift || sample[2].Drift {
+		t.Errorf("flagged %d: %+v", flagged, sample)
+	}
+	if sample[0].Judgment != "" || sample[2].Score >= 35 || sample[2].Judgment != "SAME" {
+		t.Errorf("a is judged or d passes chrF: %+v", sample)
+	}
+}
+
+func TestMeaningKept(t *testing.T) {
+	tests := []struct {
+		judgment string
+		want     bool
+	}{
+		{"SAME", true},
+		{"Same.", true},
+		{"**SAME**", true},
+		{"DIFFERENT: the date changed.", false},
+		{"SAME, but DIFFERENT in tone", false},
+		{"I cannot tell.", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := meaningKept(tt.judgment); got != tt.want {
+			t.Errorf("meaningKept(%q) = %v, want %v", tt.judgment, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keptBackups is the number of backups kept per book; older ones are removed.
+const keptBackups = 20
+
+// backupInterval is how often serve copies the files changed since the last
+// backup; 0 turns backups off.
+var backupInterval time.Duration
+
+func init() {
+	Serve.Flags().DurationVar(&backupInterval, "backup-interval", 0, "copy the files changed since the last backup into .epubtrans/backups next to the book at this interval, such as 10m")
+}
+
+// bookBackup copies the files of a served book changed since its last backup
+// into a folder per backup under .epubtrans/backups/<book> in the folder of
+// the book, which keeps them out of the EPUB.
+type bookBackup struct {
+	mu   sync.Mutex
+	path string
+	dir  string
+	// hashes are those of the files at the last backup, or when serve started.
+	hashes map[string]string
+}
+
+func backupDir(unpackedEpubPath string) string {
+	clean := filepath.Clean(unpackedEpubPath)
+	return filepath.Join(filepath.Dir(clean), ".epubtrans", "backups", filepath.Base(clean))
+}
+
+func newBookBackup(unpackedEpubPath string) (*bookBackup, error) {
+	hashes, err := hashBookFiles(unpackedEpubPath)
+	if err != nil {
+		return nil, fmt.Errorf("hashing the files of %s: %w", unpackedEpubPath, err)
+	}
+	return &bookBackup{path: unpackedEpubPath, dir: backupDir(unpackedEpubPath), hashes: hashes}, nil
+}
+
+// take copies the changed files into a backup named after now and returns
+// how many it copied.
+func (b *bookBackup) take(now time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, err := hashBookFiles(b.path)
+	if err != nil {
+		return 0, err
+	}
+	var changed []string
+	for file, hash := range current {
+		if b.hashes[file] != hash {
+			changed = append(changed, file)
+		}
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	dest := filepath.Join(b.dir, now.Format("20060102-150405"))
+	for _, file := range changed {
+		data, err := os.ReadFile(filepath.Join(b.path, filepath.FromSlash(file)))
+		if err != nil {
+			return 0, err
+		}
+		target := filepath.Join(dest, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return 0, err
+		}
+	}
+	b.hashes = current
+
+	return len(changed), b.prune()
+}
+
+// prune removes the oldest backups beyond keptBackups.
+func (b *bookBackup) prune() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, e := range entries {
+		if e.IsDir() {
+			backups = append(backups, e.Name())
+		}
+	}
+	// The names sort by time.
+	sort.Strings(backups)
+	for len(backups) > keptBackups {
+		if err := os.RemoveAll(filepath.Join(b.dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// bookBackups are the backups of the served books.
+type bookBackups []*bookBackup
+
+// startBackups takes a backup of the books every backupInterval until ctx is
+// done. It returns no backups when they are off.
+func startBackups(ctx context.Context, books []servedBook) (bookBackups, error) {
+	if backupInterval <= 0 {
+		return nil, nil
+	}
+
+	var backups bookBackups
+	for _, book := range books {
+		b, err := newBookBackup(book.Path)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	slog.Info("Backing up changed files", "every", backupInterval, "to", filepath.Dir(backups[0].dir))
+
+	go func() {
+		ticker := time.NewTicker(backupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				backups.takeAll()
+			}
+		}
+	}()
+	return backups, nil
+}
+
+// takeAll takes a backup of every book; failures are logged, as serve goes on.
+func (backups bookBackups) takeAll() {
+	now := time.Now()
+	for _, b := range backups {
+		n, err := b.take(now)
+		switch {
+		case err != nil:
+			slog.Warn("Backup failed", "book", b.path, "error", err)
+		case n > 0:
+			slog.Info("Backed up changed files", "book", b.path, "files", n)
+		}
+	}
+}
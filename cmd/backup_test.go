@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBookBackup(t *testing.T) {
+	book := filepath.Join(t.TempDir(), "book")
+	writeLibraryBook(t, book, "Backup")
+	b, err := newBookBackup(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	if n, err := b.take(now); err != nil || n != 0 {
+		t.Fatalf("take() without changes = %d, %v; want 0", n, err)
+	}
+
+	// The translation cache is no part of the book.
+	if err := os.WriteFile(filepath.Join(book, bookCacheFile), []byte("cache"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chapter := filepath.Join(book, "OEBPS", "ch1.xhtml")
+	if err := os.WriteFile(chapter, []byte("<p>Hallo</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.take(now); err != nil || n != 1 {
+		t.Fatalf("take() after writing a chapter = %d, %v; want 1", n, err)
+	}
+	backedUp := filepath.Join(filepath.Dir(book), ".epubtrans", "backups", "book", "20261016-090000", "OEBPS", "ch1.xhtml")
+	if data, err := os.ReadFile(backedUp); err != nil || string(data) != "<p>Hallo</p>" {
+		t.Errorf("backup of the chapter = %q, %v", data, err)
+	}
+
+	// Only the latest backups are kept.
+	for i := 1; i <= keptBackups; i++ {
+		if err := os.WriteFile(chapter, []byte(fmt.Sprintf("<p>%d</p>", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.take(now.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != keptBackups || entries[0].Name() != "20261016-090100" {
+		t.Errorf("%d backups starting with %s, want %d starting with 20261016-090100", len(entries), entries[0].Name(), keptBackups)
+	}
+}
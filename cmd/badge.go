@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+)
+
+// translationProgress counts the marked segments of the spine and how many of
+// them have a translation.
+func translationProgress(unzipPath string) (translated, total int, err error) {
+	progress, err := readingProgress(unzipPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return progress.Translated, progress.Segments, nil
+}
+
+// progressColor follows the shields.io palette from red to bright green.
+func progressColor(percent int) string {
+	switch {
+	case percent >= 100:
+		return "#4c1"
+	case percent >= 75:
+		return "#97ca00"
+	case percent >= 50:
+		return "#dfb317"
+	case percent >= 25:
+		return "#fe7d37"
+	default:
+		return "#e05d44"
+	}
+}
+
+// renderBadge renders a flat shields.io style badge.
+func renderBadge(label, message, color string) string {
+	// Verdana 11px averages about 7px per character.
+	labelWidth := 7*len([]rune(label)) + 10
+	messageWidth := 7*len([]rune(message)) + 10
+	width := labelWidth + messageWidth
+
+	label, message = html.EscapeString(label), html.EscapeString(message)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>`, width, labelWidth, messageWidth, label, message, color, labelWidth/2, labelWidth+messageWidth/2)
+}
@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/hotspots"
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+)
+
+var Bench = &cobra.Command{
+	Use:   "bench [epub_or_unpacked_path]",
+	Short: "Measure the speed and allocations of the pipeline on a book",
+	Long: `This command runs clean, mark, translate and pack on a copy of the book, translating with a mock
+translator that answers at once with pseudo-translations, so only the work of epubtrans itself is measured.
+It reports the time, throughput and allocations of every stage, for the fastest of --runs runs, and the
+functions taking the most CPU time and allocating the most memory over all runs. The book itself is not changed.
+Run it before and after a change meant to make the pipeline faster; use --cpuprofile and --memprofile to
+look further with go tool pprof.`,
+	Example: `epubtrans bench path/to/book.epub --runs 5`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("the path to an EPUB file or an unpacked EPUB directory is required")
+		}
+		return nil
+	},
+	RunE: runBench,
+}
+
+func init() {
+	Bench.Flags().Int("runs", 3, "number of runs; the fastest is reported")
+	Bench.Flags().Int("workers", runtime.NumCPU(), "number of files processed at once by every stage")
+	Bench.Flags().Int("top", 10, "number of hot spots listed")
+	Bench.Flags().String("cpuprofile", "", "also write the CPU profile of all runs to this file")
+	Bench.Flags().String("memprofile", "", "also write the allocation profile of all runs to this file")
+	Bench.Flags().Bool("verbose", false, "show the output of the stages, which is discarded to keep it out of the measurements")
+}
+
+// benchStage is the measurement of one stage of a run.
+type benchStage struct {
+	Name     string
+	Duration time.Duration
+	// Bytes is the size of the content documents when the stage started.
+	Bytes int64
+	Files int
+	// Segments counts the segments marked or translated by the stage.
+	Segments int
+	Allocs   uint64
+	Alloced  uint64
+	GCs      uint32
+}
+
+type benchRun struct {
+	Stages []benchStage
+	// Marked and Translated count the segments of the book after the run.
+	Marked     int
+	Translated int
+}
+
+func (r benchRun) total() time.Duration {
+	var total time.Duration
+	for _, s := range r.Stages {
+		total += s.Duration
+	}
+	return total
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	runs, _ := cmd.Flags().GetInt("runs")
+	workers, _ := cmd.Flags().GetInt("workers")
+	top, _ := cmd.Flags().GetInt("top")
+	cpuProfilePath, _ := cmd.Flags().GetString("cpuprofile")
+	memProfilePath, _ := cmd.Flags().GetString("memprofile")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	if runs <= 0 || workers <= 0 {
+		return fmt.Errorf("--runs and --workers must be greater than 0")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "epubtrans-bench-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Start from a fresh copy of the book for every run.
+	original := filepath.Join(tmpDir, "original")
+	if err := copyBook(args[0], original); err != nil {
+		return err
+	}
+	if err := util.ValidateEpubPath(original); err != nil {
+		return err
+	}
+	if progress, err := readingProgress(original); err == nil && progress.Segments > 0 {
+		fmt.Println("Note: the book is already marked, so mark and translate have less to do than on a fresh book.")
+	}
+
+	var cpuProfile bytes.Buffer
+	if err := pprof.StartCPUProfile(&cpuProfile); err != nil {
+		return fmt.Errorf("starting CPU profile: %w", err)
+	}
+
+	var best *benchRun
+	for i := 1; i <= runs; i++ {
+		unzipPath := filepath.Join(tmpDir, fmt.Sprintf("run%d", i))
+		if err := copyDir(original, unzipPath); err != nil {
+			pprof.StopCPUProfile()
+			return err
+		}
+
+		run, err := benchPipeline(cmd.Context(), unzipPath, workers, verbose)
+		if err != nil {
+			pprof.StopCPUProfile()
+			return fmt.Errorf("run %d: %w", i, err)
+		}
+		fmt.Printf("Run %d: %s\n", i, run.total().Round(time.Millisecond))
+		if best == nil || run.total() < best.total() {
+			best = run
+		}
+		os.RemoveAll(unzipPath)
+	}
+	pprof.StopCPUProfile()
+
+	runtime.GC()
+	var memProfile bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&memProfile, 0); err != nil {
+		return fmt.Errorf("writing allocation profile: %w", err)
+	}
+
+	printBenchRun(best, runs)
+
+	for _, p := range []struct {
+		path    string
+		profile []byte
+	}{{cpuProfilePath, cpuProfile.Bytes()}, {memProfilePath, memProfile.Bytes()}} {
+		if p.path == "" {
+			continue
+		}
+		if err := os.WriteFile(p.path, p.profile, 0644); err != nil {
+			return fmt.Errorf("writing profile: %w", err)
+		}
+		fmt.Printf("\nProfile written to %s; see go tool pprof -top %s\n", p.path, p.path)
+	}
+
+	if err := printHotSpots("CPU time", cpuProfile.Bytes(), "cpu", top, func(v int64) string {
+		return time.Duration(v).Round(time.Millisecond).String()
+	}); err != nil {
+		return err
+	}
+	return printHotSpots("Allocated memory", memProfile.Bytes(), "alloc_space", top, func(v int64) string {
+		return fmt.Sprintf("%.1f MB", float64(v)/1e6)
+	})
+}
+
+// benchPipeline runs the stages of the pipeline on the book at unzipPath.
+func benchPipeline(ctx context.Context, unzipPath string, workers int, verbose bool) (*benchRun, error) {
+	provider, err := translator.NewMock(&translator.Config{})
+	if err != nil {
+		return nil, err
+	}
+	translateWorkers = workers
+
+	bookName, err := extractBookName(unzipPath)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting book name: %v", err)
+	}
+
+	stages := []struct {
+		name string
+		run  func() error
+	}{
+		{"clean", func() error { return cleanBook(ctx, unzipPath, workers) }},
+		{"mark", func() error { return markBook(ctx, unzipPath, workers) }},
+		{"translate", func() error {
+			return runTranslation(ctx, unzipPath, provider, rate.NewLimiter(rate.Inf, 0), bookName)
+		}},
+		{"pack", func() error { return packFiles(unzipPath, unzipPath+".epub", nil, nil, nil) }},
+	}
+
+	run := &benchRun{}
+	for _, stage := range stages {
+		files, size, err := contentSize(unzipPath)
+		if err != nil {
+			return nil, err
+		}
+
+		measured, err := measureStage(stage.run, verbose)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", stage.name, err)
+		}
+		measured.Name, measured.Files, measured.Bytes = stage.name, files, size
+		run.Stages = append(run.Stages, measured)
+	}
+
+	progress, err := readingProgress(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+	run.Marked, run.Translated = progress.Segments, progress.Translated
+	for i := range run.Stages {
+		switch run.Stages[i].Name {
+		case "mark":
+			run.Stages[i].Segments = progress.Segments
+		case "translate":
+			run.Stages[i].Segments = progress.Translated
+		}
+	}
+	return run, nil
+}
+
+// measureStage runs a stage and measures its time and allocations. Unless
+// verbose, what the stage prints is discarded, as printing would take much
+// of the time measured.
+func measureStage(stage func() error, verbose bool) (benchStage, error) {
+	stdout := os.Stdout
+	if !verbose {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return benchStage{}, err
+		}
+		defer devNull.Close()
+		os.Stdout = devNull
+	}
+	defer func() { os.Stdout = stdout }()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	err := stage()
+
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return benchStage{
+		Duration: duration,
+		Allocs:   after.Mallocs - before.Mallocs,
+		Alloced:  after.TotalAlloc - before.TotalAlloc,
+		GCs:      after.NumGC - before.NumGC,
+	}, err
+}
+
+// contentSize returns the number and total size of the content documents.
+func contentSize(unzipPath string) (int, int64, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, size := 0, int64(0)
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return 0, 0, err
+		}
+		files++
+		size += fi.Size()
+	}
+	return files, size, nil
+}
+
+func printBenchRun(run *benchRun, runs int) {
+	fmt.Printf("\nFastest of %d runs, %s:\n", runs, run.total().Round(time.Millisecond))
+	fmt.Printf("%-10s %10s %10s %12s %14s %12s %10s %5s\n", "Stage", "Time", "MB/s", "Files/s", "Segments/s", "Allocs", "MB alloc", "GCs")
+	for _, s := range run.Stages {
+		seconds := s.Duration.Seconds()
+		segments := "-"
+		if s.Segments > 0 {
+			segments = fmt.Sprintf("%.0f", float64(s.Segments)/seconds)
+		}
+		fmt.Printf("%-10s %10s %10.1f %12.0f %14s %12d %10.1f %5d\n",
+			s.Name, s.Duration.Round(time.Microsecond*100), float64(s.Bytes)/1e6/seconds, float64(s.Files)/seconds,
+			segments, s.Allocs, float64(s.Alloced)/1e6, s.GCs)
+	}
+
+	fmt.Printf("\n%d segments marked, %d translated by the mock translator\n", run.Marked, run.Translated)
+}
+
+// printHotSpots lists the functions with the largest share of a profile.
+func printHotSpots(title string, profile []byte, sampleType string, top int, format func(int64) string) error {
+	functions, total, err := hotspots.Top(bytes.NewReader(profile), sampleType, top)
+	if err != nil {
+		return fmt.Errorf("reading profile: %w", err)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	fmt.Printf("\n%s, %s in total:\n", title, format(total))
+	fmt.Printf("%12s %7s %12s %7s  %s\n", "Flat", "Flat%", "Cum", "Cum%", "Function")
+	for _, f := range functions {
+		fmt.Printf("%12s %6.1f%% %12s %6.1f%%  %s\n",
+			format(f.Flat), float64(f.Flat)*100/float64(total), format(f.Cum), float64(f.Cum)*100/float64(total), f.Name)
+	}
+	return nil
+}
+
+// copyBook unpacks an EPUB file or copies an unpacked directory to dst.
+func copyBook(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if err := unzipBook(src, dst, func(format string, a ...interface{}) error { return nil }); err != nil {
+			return fmt.Errorf("failed to unzip book: %w", err)
+		}
+		return nil
+	}
+	return copyDir(src, dst)
+}
+
+// copyDir copies the files below src to dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/evaluation"
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var Benchmark = &cobra.Command{
+	Use:   "benchmark [unpackedEpubPath]",
+	Short: "Score the machine translation against a reference translation",
+	Long: `This command compares the translated segments of an unpacked EPUB with an official human translation
+of the same book and reports BLEU and chrF scores per chapter and for the whole book.
+Chapters are aligned by file name when both books share them, otherwise by their order in the spine.
+Use the scores to compare models and prompts on the same book. Neural metrics such as COMET are not computed.`,
+	Example: `epubtrans benchmark path/to/unpacked/epub --reference path/to/official-translation.epub`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runBenchmark,
+}
+
+func init() {
+	Benchmark.Flags().String("reference", "", "reference translation, either an EPUB file or an unpacked EPUB directory")
+	Benchmark.MarkFlagRequired("reference")
+}
+
+// chapterText is the plain text of one spine item.
+type chapterText struct {
+	Href string
+	Text string
+}
+
+func runBenchmark(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	referencePath, _ := cmd.Flags().GetString("reference")
+
+	referenceDir, cleanup, err := openReferenceBook(referencePath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	hypotheses, err := readSpineTexts(unzipPath, func(doc *goquery.Document) string {
+		var parts []string
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			parts = append(parts, strings.TrimSpace(s.Text()))
+		})
+		return strings.Join(parts, "\n")
+	})
+	if err != nil {
+		return err
+	}
+
+	references, err := readSpineTexts(referenceDir, func(doc *goquery.Document) string {
+		return strings.TrimSpace(doc.Find("body").Text())
+	})
+	if err != nil {
+		return fmt.Errorf("reading reference: %w", err)
+	}
+
+	pairs := alignChapters(hypotheses, references)
+	if len(pairs) == 0 {
+		return fmt.Errorf("no translated chapters could be aligned with the reference")
+	}
+
+	var totalBLEU evaluation.BLEUStats
+	var totalChrF evaluation.ChrFStats
+
+	fmt.Printf("%-40s %8s %8s\n", "Chapter", "BLEU", "chrF")
+	for _, pair := range pairs {
+		bleu := evaluation.ComputeBLEUStats(pair[0].Text, pair[1].Text)
+		chrF := evaluation.ComputeChrFStats(pair[0].Text, pair[1].Text)
+		totalBLEU.Add(bleu)
+		totalChrF.Add(chrF)
+
+		fmt.Printf("%-40s %8.2f %8.2f\n", pair[0].Href, bleu.Score(), chrF.Score())
+	}
+
+	fmt.Printf("%-40s %8.2f %8.2f\n", fmt.Sprintf("Total (%d chapters)", len(pairs)), totalBLEU.Score(), totalChrF.Score())
+	return nil
+}
+
+// openReferenceBook returns an unpacked directory for the reference, extracting
+// it to a temporary directory if it is a packed EPUB.
+func openReferenceBook(referencePath string) (string, func(), error) {
+	fi, err := os.Stat(referencePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("reference %s: %w", referencePath, err)
+	}
+	if fi.IsDir() {
+		return referencePath, func() {}, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "epubtrans-reference-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	if err := unzipBook(referencePath, tmpDir, func(format string, a ...interface{}) error { return nil }); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to unzip reference: %w", err)
+	}
+
+	return tmpDir, cleanup, nil
+}
+
+// readSpineTexts extracts text from every XHTML spine item in reading order,
+// skipping items without text.
+func readSpineTexts(unzipPath string, extract func(doc *goquery.Document) string) ([]chapterText, error) {
+	container, err := loader.ParseContainer(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	opfPath := filepath.Join(unzipPath, container.Rootfile.FullPath)
+	pkg, err := loader.ParsePackage(opfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	contentDir := filepath.Dir(opfPath)
+
+	var chapters []chapterText
+	for _, ref := range pkg.Spine.ItemRefs {
+		item := pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		doc, err := openAndReadFile(filepath.Join(contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		if text := extract(doc); text != "" {
+			chapters = append(chapters, chapterText{Href: item.Href, Text: text})
+		}
+	}
+
+	return chapters, nil
+}
+
+// alignChapters pairs hypotheses with references by href, or by position when
+// the books do not share file names.
+func alignChapters(hypotheses, references []chapterText) [][2]chapterText {
+	byHref := make(map[string]chapterText, len(references))
+	for _, ref := range references {
+		byHref[ref.Href] = ref
+	}
+
+	var pairs [][2]chapterText
+	for _, hyp := range hypotheses {
+		if ref, ok := byHref[hyp.Href]; ok {
+			pairs = append(pairs, [2]chapterText{hyp, ref})
+		}
+	}
+
+	if len(pairs) > 0 {
+		return pairs
+	}
+
+	for i := 0; i < len(hypotheses) && i < len(references); i++ {
+		pairs = append(pairs, [2]chapterText{hypotheses[i], references[i]})
+	}
+
+	return pairs
+}
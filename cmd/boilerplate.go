@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	// skipPatterns are user supplied regular expressions matched against file names.
+	skipPatterns []string
+	// includeBoilerplate disables the boilerplate heuristic.
+	includeBoilerplate bool
+)
+
+// boilerplateMaxWords is the length above which a page is considered content,
+// whatever it contains: a real chapter may well mention a copyright.
+const boilerplateMaxWords = 800
+
+var boilerplateMarkers = []struct {
+	name  string
+	regex *regexp.Regexp
+}{
+	{"ISBN", regexp.MustCompile(`(?i)\bISBN(?:-1[03])?:?\s*[\dX][\d\s-]{8,}`)},
+	{"rights notice", regexp.MustCompile(`(?i)all rights reserved|no part of this (?:publication|book) may be|tous droits réservés|alle rechte vorbehalten`)},
+	{"copyright", regexp.MustCompile(`(?i)copyright\s*(?:©|\(c\))?\s*\d{4}|©\s*\d{4}`)},
+	{"printing details", regexp.MustCompile(`(?i)printed in (?:the )?[A-Z]|first (?:published|edition)|cataloging.in.publication|library of congress`)},
+	{"book list", regexp.MustCompile(`(?i)also (?:by|available from)|other (?:books|titles) by|coming soon from`)},
+	{"marketing", regexp.MustCompile(`(?i)sign up for (?:our|the) newsletter|praise for|discover more|visit us (?:at|online)|www\.[a-z0-9-]+\.[a-z]+`)},
+}
+
+// skipReason returns why filePath should not be translated, or "" to translate it.
+func skipReason(filePath string, doc *goquery.Document) (string, error) {
+	name := filepath.Base(filePath)
+	for _, pattern := range skipPatterns {
+		matched, err := regexp.MatchString(pattern, name)
+		if err != nil {
+			return "", fmt.Errorf("invalid skip pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return fmt.Sprintf("matches skip pattern %q", pattern), nil
+		}
+	}
+
+	if len(includeTypes) > 0 || len(excludeTypes) > 0 {
+		types, ok := bookSemantics[filePath]
+		if !ok {
+			types = documentTypes(doc)
+		}
+		if reason := semanticSkipReason(types); reason != "" {
+			return reason, nil
+		}
+	}
+
+	if includeBoilerplate {
+		return "", nil
+	}
+
+	if markers := boilerplateMarkersIn(doc.Find("body").Text()); len(markers) > 0 {
+		return "looks like boilerplate (" + strings.Join(markers, ", ") + ")", nil
+	}
+
+	return "", nil
+}
+
+// boilerplateMarkersIn returns the boilerplate markers found in a short text.
+// Two different markers are needed, so a single copyright line in a dedication
+// does not get the page skipped.
+func boilerplateMarkersIn(text string) []string {
+	if countWords(text) > boilerplateMaxWords {
+		return nil
+	}
+
+	var found []string
+	for _, marker := range boilerplateMarkers {
+		if marker.regex.MatchString(text) {
+			found = append(found, marker.name)
+		}
+	}
+
+	if len(found) < 2 {
+		return nil
+	}
+	return found
+}
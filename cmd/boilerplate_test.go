@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBoilerplateMarkersIn(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{
+			name: "Copyright page",
+			text: "Copyright © 2019 by Jane Doe. All rights reserved. ISBN 978-0-12-345678-9. Printed in the United States of America.",
+			want: true,
+		},
+		{
+			name: "Publisher ad",
+			text: "Also by Jane Doe: The First Book, The Second Book. Sign up for our newsletter at www.example.com.",
+			want: true,
+		},
+		{
+			name: "Dedication with a single copyright line",
+			text: "For my mother, who taught me to read. Copyright 2019.",
+			want: false,
+		},
+		{
+			name: "Long chapter mentioning rights",
+			text: "All rights reserved, ISBN 978-0-12-345678-9. " + strings.Repeat("It was a dark and stormy night. ", 200),
+			want: false,
+		},
+		{
+			name: "Regular prose",
+			text: "It was a dark and stormy night.",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := len(boilerplateMarkersIn(tt.text)) > 0
+			if got != tt.want {
+				t.Errorf("boilerplateMarkersIn() = %v, want boilerplate %v", boilerplateMarkersIn(tt.text), tt.want)
+			}
+		})
+	}
+}
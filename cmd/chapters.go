@@ -0,0 +1,518 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var Split = &cobra.Command{
+	Use:   "split [unpackedEpubPath]",
+	Short: "Split oversized XHTML files into several spine items",
+	Long: `This command splits every XHTML file larger than --max-size into several files, cutting between top-level
+elements of the body and preferring to cut before headings. The manifest, the spine and all links, including the
+NCX table of contents, are updated. Huge single-file books otherwise blow past model limits and slow down serve.`,
+	Example: `epubtrans split path/to/unpacked/epub --max-size 100000`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runSplit,
+}
+
+var Merge = &cobra.Command{
+	Use:   "merge [unpackedEpubPath]",
+	Short: "Merge tiny XHTML files into the preceding spine item",
+	Long: `This command merges every XHTML file smaller than --min-size into the spine item before it (or after it
+for the first item). The merged content is wrapped in an element with an id so links to the old file keep working.
+The manifest, the spine and all links, including the NCX table of contents, are updated.`,
+	Example: `epubtrans merge path/to/unpacked/epub --min-size 2000`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runMerge,
+}
+
+func init() {
+	Split.Flags().Int("max-size", 100*1024, "maximum size in bytes of an XHTML file")
+	Merge.Flags().Int("min-size", 2*1024, "files smaller than this many bytes are merged")
+}
+
+// linkRewriter maps a link target (absolute file path and fragment) to a new target.
+type linkRewriter func(target, fragment string) (string, string, bool)
+
+type bookFiles struct {
+	opfPath    string
+	contentDir string
+	pkg        *loader.Package
+}
+
+func openBookFiles(unzipPath string) (*bookFiles, error) {
+	container, err := loader.ParseContainer(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	opfPath := filepath.Join(unzipPath, container.Rootfile.FullPath)
+	pkg, err := loader.ParsePackage(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing package: %v", err)
+	}
+
+	return &bookFiles{opfPath: opfPath, contentDir: filepath.Dir(opfPath), pkg: pkg}, nil
+}
+
+func runSplit(cmd *cobra.Command, args []string) error {
+	maxSize, _ := cmd.Flags().GetInt("max-size")
+	if maxSize <= 0 {
+		return fmt.Errorf("max-size must be greater than 0")
+	}
+
+	book, err := openBookFiles(args[0])
+	if err != nil {
+		return err
+	}
+
+	opf, err := os.ReadFile(book.opfPath)
+	if err != nil {
+		return err
+	}
+	opfContent := string(opf)
+
+	// moved maps split file -> fragment -> file holding it now, and splitFrom
+	// maps a new part to the file it was split from.
+	moved := make(map[string]map[string]string)
+	splitFrom := make(map[string]string)
+
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		filePath := filepath.Join(book.contentDir, item.Href)
+		fi, err := os.Stat(filePath)
+		if err != nil || fi.Size() <= int64(maxSize) {
+			continue
+		}
+
+		parts, fragments, err := splitChapter(filePath, maxSize)
+		if err != nil {
+			return fmt.Errorf("splitting %s: %w", item.Href, err)
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		moved[filePath] = fragments
+
+		var items, itemRefs []string
+		for i, part := range parts {
+			splitFrom[part] = filePath
+			href, err := filepath.Rel(book.contentDir, part)
+			if err != nil {
+				return err
+			}
+			id := fmt.Sprintf("%s-part%d", item.ID, i+2)
+			items = append(items, fmt.Sprintf(`<item id="%s" href="%s" media-type="application/xhtml+xml"/>`, id, html.EscapeString(filepath.ToSlash(href))))
+			itemRefs = append(itemRefs, fmt.Sprintf(`<itemref idref="%s"/>`, id))
+		}
+
+		opfContent, err = insertAfterTag(opfContent, "item", "id", item.ID, items)
+		if err != nil {
+			return err
+		}
+		opfContent, err = insertAfterTag(opfContent, "itemref", "idref", item.ID, itemRefs)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Split %s into %d files\n", item.Href, len(parts)+1)
+	}
+
+	if len(moved) == 0 {
+		fmt.Println("No file exceeds the maximum size")
+		return nil
+	}
+
+	if err := writeBookFile(book.opfPath, []byte(opfContent)); err != nil {
+		return err
+	}
+
+	// A link into a split file, including a link to a fragment of the same
+	// document in one of its parts, must go to the part that holds the
+	// fragment.
+	return rewriteBookLinks(book.opfPath, func(target, fragment string) (string, string, bool) {
+		source := target
+		if original, ok := splitFrom[target]; ok {
+			source = original
+		}
+		if newFile, ok := moved[source][fragment]; ok && fragment != "" && newFile != target {
+			return newFile, fragment, true
+		}
+		return target, fragment, false
+	})
+}
+
+var splitHeadingRegex = regexp.MustCompile(`^h[1-3]$`)
+
+// splitChapter writes the second and following parts of filePath to new files
+// and truncates filePath to the first part. It returns the new files and the
+// part, filePath for the first, that holds each id.
+func splitChapter(filePath string, maxSize int) ([]string, map[string]string, error) {
+	original, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(original)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	children := doc.Find("body").Contents()
+	var boundaries []int
+	size := 0
+	children.Each(func(i int, s *goquery.Selection) {
+		childHTML, _ := goquery.OuterHtml(s)
+		isHeading := splitHeadingRegex.MatchString(goquery.NodeName(s))
+
+		if size > 0 && (size+len(childHTML) > maxSize || (isHeading && size > maxSize/2)) {
+			boundaries = append(boundaries, i)
+			size = 0
+		}
+		size += len(childHTML)
+	})
+
+	if len(boundaries) == 0 {
+		return nil, nil, nil
+	}
+
+	ranges := make([][2]int, 0, len(boundaries)+1)
+	start := 0
+	for _, b := range boundaries {
+		ranges = append(ranges, [2]int{start, b})
+		start = b
+	}
+	ranges = append(ranges, [2]int{start, children.Length()})
+
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+
+	var parts []string
+	fragments := make(map[string]string)
+
+	for i, r := range ranges {
+		partDoc, err := goquery.NewDocumentFromReader(strings.NewReader(string(original)))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		partDoc.Find("body").Contents().Each(func(j int, s *goquery.Selection) {
+			if j < r[0] || j >= r[1] {
+				s.Remove()
+			}
+		})
+
+		partPath := filePath
+		if i > 0 {
+			partPath = fmt.Sprintf("%s-part%d%s", base, i+1, ext)
+			parts = append(parts, partPath)
+		}
+		partDoc.Find("body [id]").Each(func(j int, s *goquery.Selection) {
+			id, _ := s.Attr("id")
+			fragments[id] = partPath
+		})
+
+		if err := writeContentToFile(partPath, partDoc); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return parts, fragments, nil
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	minSize, _ := cmd.Flags().GetInt("min-size")
+	if minSize <= 0 {
+		return fmt.Errorf("min-size must be greater than 0")
+	}
+
+	book, err := openBookFiles(args[0])
+	if err != nil {
+		return err
+	}
+
+	opf, err := os.ReadFile(book.opfPath)
+	if err != nil {
+		return err
+	}
+	opfContent := string(opf)
+
+	var spine []*loader.Item
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		if item := book.pkg.Manifest.GetItemByID(ref.IDRef); item != nil && item.MediaType == "application/xhtml+xml" {
+			spine = append(spine, item)
+		}
+	}
+
+	// merged maps removed file -> file and anchor that now hold its content
+	type mergeTarget struct {
+		file   string
+		anchor string
+	}
+	merged := make(map[string]mergeTarget)
+
+	for i := 0; i < len(spine) && len(spine) > 1; i++ {
+		item := spine[i]
+		filePath := filepath.Join(book.contentDir, item.Href)
+		fi, err := os.Stat(filePath)
+		if err != nil || fi.Size() >= int64(minSize) {
+			continue
+		}
+
+		var target *loader.Item
+		appendContent := true
+		if i > 0 {
+			target = spine[i-1]
+		} else {
+			target = spine[i+1]
+			appendContent = false
+		}
+
+		targetPath := filepath.Join(book.contentDir, target.Href)
+		anchor := "epubtrans-merged-" + item.ID
+		if err := mergeChapter(filePath, targetPath, anchor, appendContent); err != nil {
+			return fmt.Errorf("merging %s into %s: %w", item.Href, target.Href, err)
+		}
+
+		merged[filePath] = mergeTarget{file: targetPath, anchor: anchor}
+		// Links that pointed into an earlier merged file must follow it.
+		for from, to := range merged {
+			if to.file == filePath {
+				merged[from] = mergeTarget{file: targetPath, anchor: to.anchor}
+			}
+		}
+
+		opfContent = removeTag(opfContent, "item", "id", item.ID)
+		opfContent = removeTag(opfContent, "itemref", "idref", item.ID)
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+
+		fmt.Printf("Merged %s into %s\n", item.Href, target.Href)
+
+		spine = append(spine[:i], spine[i+1:]...)
+		i--
+	}
+
+	if len(merged) == 0 {
+		fmt.Println("No file is below the minimum size")
+		return nil
+	}
+
+	if err := writeBookFile(book.opfPath, []byte(opfContent)); err != nil {
+		return err
+	}
+
+	return rewriteBookLinks(book.opfPath, func(target, fragment string) (string, string, bool) {
+		to, ok := merged[target]
+		if !ok {
+			return target, fragment, false
+		}
+		if fragment == "" {
+			fragment = to.anchor
+		}
+		return to.file, fragment, true
+	})
+}
+
+// mergeChapter moves the body of filePath into targetPath, wrapped in an element
+// with the given id, either after or before the existing content. The
+// stylesheets and styles of filePath come along, as its content needs them.
+func mergeChapter(filePath, targetPath, anchor string, appendContent bool) error {
+	source, err := openAndReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	target, err := openAndReadFile(targetPath)
+	if err != nil {
+		return err
+	}
+
+	body, err := source.Find("body").Html()
+	if err != nil {
+		return err
+	}
+
+	wrapped := fmt.Sprintf(`<div id="%s">%s</div>`, anchor, body)
+	if appendContent {
+		target.Find("body").AppendHtml(wrapped)
+	} else {
+		target.Find("body").PrependHtml(wrapped)
+	}
+	if err := mergeHeadStyles(source, target, filepath.Dir(filePath), filepath.Dir(targetPath)); err != nil {
+		return err
+	}
+
+	return writeContentToFile(targetPath, target)
+}
+
+// mergeHeadStyles adds the stylesheet links and style elements of the head of
+// source, in sourceDir, to the head of target, in targetDir, unless it has
+// them already.
+func mergeHeadStyles(source, target *goquery.Document, sourceDir, targetDir string) error {
+	// Stylesheets are told apart by their path, or URL when remote.
+	stylesheetKey := func(dir, href string) string {
+		if strings.Contains(href, ":") {
+			return href
+		}
+		return filepath.Join(dir, filepath.FromSlash(href))
+	}
+
+	head := target.Find("head")
+	stylesheets := map[string]bool{}
+	styles := map[string]bool{}
+	head.Find(`link[rel~="stylesheet"]`).Each(func(i int, s *goquery.Selection) {
+		stylesheets[stylesheetKey(targetDir, s.AttrOr("href", ""))] = true
+	})
+	head.Find("style").Each(func(i int, s *goquery.Selection) {
+		styles[strings.TrimSpace(s.Text())] = true
+	})
+
+	var err error
+	source.Find(`head link[rel~="stylesheet"], head style`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		element := s.Clone()
+		if goquery.NodeName(s) == "style" {
+			css := strings.TrimSpace(s.Text())
+			if styles[css] {
+				return true
+			}
+			styles[css] = true
+		} else {
+			href := s.AttrOr("href", "")
+			key := stylesheetKey(sourceDir, href)
+			if stylesheets[key] {
+				return true
+			}
+			stylesheets[key] = true
+			if !strings.Contains(href, ":") {
+				var rel string
+				if rel, err = filepath.Rel(targetDir, key); err != nil {
+					return false
+				}
+				element.SetAttr("href", filepath.ToSlash(rel))
+			}
+		}
+
+		var markup string
+		if markup, err = goquery.OuterHtml(element); err != nil {
+			return false
+		}
+		head.AppendHtml(markup)
+		return true
+	})
+	return err
+}
+
+var linkAttrRegex = regexp.MustCompile(`\b(href|src)="([^"]*)"`)
+
+// rewriteBookLinks applies rewrite to every relative link of the XHTML and NCX
+// files listed in the package document.
+func rewriteBookLinks(opfPath string, rewrite linkRewriter) error {
+	pkg, err := loader.ParsePackage(opfPath)
+	if err != nil {
+		return err
+	}
+
+	contentDir := filepath.Dir(opfPath)
+	for _, item := range pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" && item.MediaType != "application/x-dtbncx+xml" {
+			continue
+		}
+
+		filePath := filepath.Join(contentDir, item.Href)
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Dir(filePath)
+		changed := false
+		updated := linkAttrRegex.ReplaceAllStringFunc(string(content), func(match string) string {
+			parts := linkAttrRegex.FindStringSubmatch(match)
+			value := html.UnescapeString(parts[2])
+			if strings.Contains(value, ":") {
+				return match // external link
+			}
+
+			file, fragment, _ := strings.Cut(value, "#")
+			target := filePath
+			if file != "" {
+				target = filepath.Join(dir, file)
+			}
+
+			newTarget, newFragment, ok := rewrite(target, fragment)
+			if !ok {
+				return match
+			}
+
+			rel, err := filepath.Rel(dir, newTarget)
+			if err != nil {
+				return match
+			}
+			newValue := filepath.ToSlash(rel)
+			if newFragment != "" {
+				newValue += "#" + newFragment
+			}
+
+			changed = true
+			return fmt.Sprintf(`%s="%s"`, parts[1], html.EscapeString(newValue))
+		})
+
+		if changed {
+			if err := writeBookFile(filePath, []byte(updated)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func tagRegex(tag, attr, value string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`<(?:opf:)?%s\b[^>]*\b%s="%s"[^>]*?/?>(?:\s*</(?:opf:)?%s>)?`,
+		tag, attr, regexp.QuoteMeta(value), tag))
+}
+
+// insertAfterTag inserts elements after the tag whose attr equals value.
+func insertAfterTag(content, tag, attr, value string, elements []string) (string, error) {
+	loc := tagRegex(tag, attr, value).FindStringIndex(content)
+	if loc == nil {
+		return "", fmt.Errorf("no <%s %s=%q> found in package document", tag, attr, value)
+	}
+
+	insert := "\n" + strings.Join(elements, "\n")
+	return content[:loc[1]] + insert + content[loc[1]:], nil
+}
+
+// removeTag removes the tag whose attr equals value.
+func removeTag(content, tag, attr, value string) string {
+	return tagRegex(tag, attr, value).ReplaceAllString(content, "")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// writeChaptersBook writes an unpacked book whose spine holds the chapters,
+// by file name in OEBPS.
+func writeChaptersBook(t *testing.T, dir string, chapters map[string]string, spine ...string) {
+	t.Helper()
+	writeLibraryBook(t, dir, "Chapters")
+	var items, itemRefs strings.Builder
+	for i, name := range spine {
+		id := strings.TrimSuffix(name, ".xhtml")
+		items.WriteString(`<item id="` + id + `" href="` + name + `" media-type="application/xhtml+xml"/>`)
+		itemRefs.WriteString(`<itemref idref="` + id + `"/>`)
+		if err := os.WriteFile(filepath.Join(dir, "OEBPS", name), []byte(chapters[spine[i]]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Chapters</dc:title></metadata>
+  <manifest>` + items.String() + `</manifest>
+  <spine>` + itemRefs.String() + `</spine>
+</package>`
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "content.opf"), []byte(opf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func chapterDocument(head, body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Chapter</title>` + head + `</head><body>` + body + `</body></html>`
+}
+
+func TestSplitRewritesFragmentLinks(t *testing.T) {
+	filler := "<p>" + strings.Repeat("All work and no play makes Jack a dull boy. ", 4) + "</p>"
+	body := `<h1 id="top">Title</h1><p><a href="#end">To the end</a></p>` +
+		strings.Repeat(filler, 3) + `<h2 id="middle">Middle</h2><p><a href="#end">Onwards</a></p>` +
+		strings.Repeat(filler, 3) + `<h2 id="end">End</h2><p><a href="#top">Back</a> <a href="#middle">Middle</a></p>`
+	dir := t.TempDir()
+	writeChaptersBook(t, dir, map[string]string{"ch1.xhtml": chapterDocument("", body)}, "ch1.xhtml")
+
+	defer Split.Flags().Set("max-size", Split.Flags().Lookup("max-size").DefValue)
+	if err := Split.Flags().Set("max-size", "600"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSplit(Split, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := filepath.Glob(filepath.Join(dir, "OEBPS", "ch1*.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 3 {
+		t.Fatalf("split into %d parts, want at least 3", len(parts))
+	}
+	ids := map[string]string{}
+	docs := map[string]*goquery.Document{}
+	for _, part := range parts {
+		doc, err := openAndReadFile(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs[part] = doc
+		doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+			ids[s.AttrOr("id", "")] = part
+		})
+	}
+
+	links := 0
+	for part, doc := range docs {
+		doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			links++
+			href := s.AttrOr("href", "")
+			file, fragment, _ := strings.Cut(href, "#")
+			target := part
+			if file != "" {
+				target = filepath.Join(filepath.Dir(part), file)
+			}
+			if ids[fragment] != target {
+				t.Errorf("%s links to %s, but %s is in %s", filepath.Base(part), href, fragment, filepath.Base(ids[fragment]))
+			}
+		})
+	}
+	if links != 4 {
+		t.Errorf("%d links after the split, want 4", links)
+	}
+}
+
+func TestMergeCarriesHeadStyles(t *testing.T) {
+	long := "<p>" + strings.Repeat("A long chapter that stays. ", 20) + "</p>"
+	dir := t.TempDir()
+	writeChaptersBook(t, dir, map[string]string{
+		"ch1.xhtml": chapterDocument(`<link rel="stylesheet" type="text/css" href="style.css"/>`, long),
+		"ch2.xhtml": chapterDocument(`<link rel="stylesheet" type="text/css" href="style.css"/><link rel="stylesheet" type="text/css" href="poem.css"/><style>.stanza { margin: 1em }</style>`,
+			`<p class="stanza">Short poem</p>`),
+	}, "ch1.xhtml", "ch2.xhtml")
+
+	defer Merge.Flags().Set("min-size", Merge.Flags().Lookup("min-size").DefValue)
+	if err := Merge.Flags().Set("min-size", "400"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMerge(Merge, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := openAndReadFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hrefs []string
+	doc.Find(`head link[rel="stylesheet"]`).Each(func(i int, s *goquery.Selection) {
+		hrefs = append(hrefs, s.AttrOr("href", ""))
+	})
+	if strings.Join(hrefs, " ") != "style.css poem.css" {
+		t.Errorf("stylesheets after the merge = %q, want style.css and poem.css once", hrefs)
+	}
+	if style := doc.Find("head style").Text(); !strings.Contains(style, ".stanza") {
+		t.Errorf("the style of the merged chapter was lost: %q", style)
+	}
+	if doc.Find("body .stanza").Length() != 1 {
+		t.Error("the content of the merged chapter is missing")
+	}
+}
@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bibliography entries are translated in citation mode: their titles, author
+// names, DOIs and links are masked like formulas, so the model only
+// translates the annotations around them and references stay searchable.
+
+const (
+	// citationAuto detects bibliographies by their markup and heading.
+	citationAuto = "auto"
+	citationOn   = "on"
+	citationOff  = "off"
+)
+
+// citationStore keeps the citation mode chosen for files in the serve UI,
+// next to the unpacked directory like the share links. Files without an
+// entry are in auto mode.
+type citationStore struct {
+	mu   sync.Mutex
+	path string
+	// Files maps the href of a file, relative to the content directory, to
+	// its mode.
+	Files map[string]string `json:"files"`
+}
+
+var (
+	// bookCitations holds the citation modes of the book being translated,
+	// whose files are below bookContentDir.
+	bookCitations  *citationStore
+	bookContentDir string
+)
+
+func citationStorePath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-citations.json"
+}
+
+func loadCitationStore(storePath string) (*citationStore, error) {
+	store := &citationStore{path: storePath}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the modes from disk, so a translate run picks up the modes
+// changed in serve meanwhile.
+func (s *citationStore) reload() error {
+	files := make(map[string]string)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading citation modes: %w", err)
+	}
+
+	if err == nil {
+		var stored citationStore
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("parsing citation modes: %w", err)
+		}
+		for href, mode := range stored.Files {
+			files[href] = mode
+		}
+	}
+
+	s.Files = files
+	return nil
+}
+
+func (s *citationStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling citation modes: %w", err)
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// mode returns the citation mode of href.
+func (s *citationStore) mode(href string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return "", err
+	}
+	if mode, ok := s.Files[href]; ok {
+		return mode, nil
+	}
+	return citationAuto, nil
+}
+
+func (s *citationStore) setMode(href, mode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if mode == citationAuto {
+		delete(s.Files, href)
+	} else {
+		s.Files[href] = mode
+	}
+	return s.save()
+}
+
+var bibliographyHeadingRegex = regexp.MustCompile(`(?i)^(?:bibliography|references|works cited|literature cited|sources|further reading|select bibliography|literaturverzeichnis|bibliographie|références|bibliografía|bibliografia|tài liệu tham khảo)$`)
+
+func isBibliographyAttrs(epubType, role string) bool {
+	for _, t := range strings.Fields(epubType) {
+		if t == "bibliography" || t == "biblioentry" {
+			return true
+		}
+	}
+	for _, r := range strings.Fields(role) {
+		if r == "doc-bibliography" || r == "doc-biblioentry" {
+			return true
+		}
+	}
+	return false
+}
+
+// inBibliography reports whether the segment is part of a bibliography: an
+// element marked as such, or a section or file whose first heading names one.
+func inBibliography(s *goquery.Selection) bool {
+	for n := s; n.Length() > 0; n = n.Parent() {
+		if isBibliographyAttrs(n.AttrOr("epub:type", ""), n.AttrOr("role", "")) {
+			return true
+		}
+		if name := goquery.NodeName(n); name == "section" || name == "body" {
+			heading := n.Find("h1, h2, h3, h4, h5, h6").First()
+			if heading.Length() > 0 && bibliographyHeadingRegex.MatchString(strings.TrimSpace(heading.Text())) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCitation reports whether the segment is translated in citation mode.
+func isCitation(s *goquery.Selection, mode string) bool {
+	switch mode {
+	case citationOn:
+		return true
+	case citationOff:
+		return false
+	default:
+		return inBibliography(s)
+	}
+}
+
+func citationPlaceholder(i int) string {
+	return fmt.Sprintf("{{CITE_%d}}", i)
+}
+
+var (
+	// citationTitleRegex matches the titles set in italics or as a citation.
+	citationTitleRegex = regexp.MustCompile(`(?s)<(cite|i|em)\b[^>]*>.*?</(?:cite|i|em)>`)
+	citationLinkRegex  = regexp.MustCompile(`(?i)(?:https?://|doi:\s*|\b10\.\d{4,9}/)[^\s<>"]*[^\s<>".,;:)\]]`)
+	// citationQuoteRegex matches quoted titles in text, which attributes are
+	// not part of.
+	citationQuoteRegex = regexp.MustCompile(`“[^”<>]+”|"[^"<>]+"|‘[^’<>]+’`)
+	// citationAuthorsRegex matches the authors at the start of an entry,
+	// followed by the year or the masked title.
+	citationAuthorsRegex = regexp.MustCompile(`^\s*([^<>{}()]*?[^<>{}()\s])[.,]?\s*(?:\(\d{4}[a-z]?\)|\d{4}[a-z]?[.,]|\{\{CITE_\d+\}\})`)
+	htmlTagSplitRegex    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// maskCitation replaces the titles, links, DOIs and authors of a
+// bibliography entry with placeholders.
+func maskCitation(htmlContent string) (string, []string) {
+	var parts []string
+	mask := func(part string) string {
+		parts = append(parts, part)
+		return citationPlaceholder(len(parts) - 1)
+	}
+
+	masked := citationTitleRegex.ReplaceAllStringFunc(htmlContent, mask)
+
+	// Links and quotes are only masked in text, not in the attributes of tags.
+	var b strings.Builder
+	last := 0
+	for _, tag := range htmlTagSplitRegex.FindAllStringIndex(masked, -1) {
+		b.WriteString(maskCitationText(masked[last:tag[0]], mask))
+		b.WriteString(masked[tag[0]:tag[1]])
+		last = tag[1]
+	}
+	b.WriteString(maskCitationText(masked[last:], mask))
+	masked = b.String()
+
+	if m := citationAuthorsRegex.FindStringSubmatchIndex(masked); m != nil && strings.IndexFunc(masked[m[2]:m[3]], unicode.IsLetter) >= 0 {
+		masked = masked[:m[2]] + mask(masked[m[2]:m[3]]) + masked[m[3]:]
+	}
+
+	return masked, parts
+}
+
+func maskCitationText(text string, mask func(string) string) string {
+	text = citationLinkRegex.ReplaceAllStringFunc(text, mask)
+	return citationQuoteRegex.ReplaceAllStringFunc(text, mask)
+}
+
+// unmaskCitation puts the masked parts back, failing like unmaskMath if a
+// placeholder was lost or duplicated.
+func unmaskCitation(translated string, parts []string) (string, error) {
+	// Later parts may contain earlier ones, e.g. a quote an italic title.
+	for i := len(parts) - 1; i >= 0; i-- {
+		placeholder := citationPlaceholder(i)
+		if n := strings.Count(translated, placeholder); n != 1 {
+			return "", fmt.Errorf("placeholder %s found %d times", placeholder, n)
+		}
+		translated = strings.Replace(translated, placeholder, parts[i], 1)
+	}
+
+	return translated, nil
+}
+
+// isOnlyCitation reports whether nothing but placeholders, punctuation and
+// numbers is left to translate, as in an entry without annotation.
+func isOnlyCitation(masked string, parts []string) bool {
+	if len(parts) == 0 {
+		return false
+	}
+
+	text := htmlTagSplitRegex.ReplaceAllString(masked, "")
+	for i := range parts {
+		text = strings.Replace(text, citationPlaceholder(i), "", 1)
+	}
+	return strings.IndexFunc(text, unicode.IsLetter) < 0
+}
+
+func batchHasCitations(batch translationBatch) bool {
+	for _, element := range batch.elements {
+		if len(element.citations) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// citationInstructions tell the model what the placeholders of masked
+// bibliography entries are.
+const citationInstructions = "Placeholders such as {{CITE_0}} stand for the titles, authors, DOIs and links of bibliography entries. Keep every placeholder exactly once and unchanged and translate only the remaining annotations."
+
+// citationModeFor returns the citation mode of the file at filePath, an
+// absolute path below contentDir. A missing store means auto mode.
+func citationModeFor(store *citationStore, contentDir, filePath string) (string, error) {
+	if store == nil {
+		return citationAuto, nil
+	}
+	href, err := filepath.Rel(contentDir, filePath)
+	if err != nil {
+		return citationAuto, nil
+	}
+	return store.mode(filepath.ToSlash(href))
+}
+
+type CitationModeRequest struct {
+	FilePath string `json:"file_path"`
+	Mode     string `json:"mode"`
+}
+
+// registerCitationAPI adds the endpoints to read and set the citation mode
+// of a file.
+func registerCitationAPI(api fiber.Router, store *citationStore, contentDirPath string) {
+	// chapter returns the href of the file_path of a request and the file.
+	chapter := func(filePath string) (string, *goquery.Document, error) {
+		href := strings.TrimPrefix(path.Clean("/"+filePath), "/")
+		doc, err := openAndReadFile(filepath.Join(contentDirPath, href))
+		if os.IsNotExist(err) {
+			return "", nil, fiber.NewError(fiber.StatusNotFound, "File not found")
+		}
+		if err != nil {
+			return "", nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to read file")
+		}
+		return href, doc, nil
+	}
+
+	// detected tells whether auto mode finds a bibliography in the file.
+	detected := func(doc *goquery.Document) bool {
+		found := false
+		doc.Find("[data-content-id]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			found = inBibliography(s)
+			return !found
+		})
+		return found
+	}
+
+	api.Get("/citation-mode", func(c *fiber.Ctx) error {
+		href, doc, err := chapter(c.Query("file_path"))
+		if err != nil {
+			return aiTranslationError(c, err)
+		}
+		mode, err := store.mode(href)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read citation modes"})
+		}
+		return c.JSON(fiber.Map{"file_path": "/" + href, "mode": mode, "detected": detected(doc)})
+	})
+
+	api.Put("/citation-mode", func(c *fiber.Ctx) error {
+		var req CitationModeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if req.Mode != citationAuto && req.Mode != citationOn && req.Mode != citationOff {
+			return c.Status(400).JSON(fiber.Map{"error": "Mode must be auto, on or off"})
+		}
+
+		href, doc, err := chapter(req.FilePath)
+		if err != nil {
+			return aiTranslationError(c, err)
+		}
+		if err := store.setMode(href, req.Mode); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save citation mode"})
+		}
+		return c.JSON(fiber.Map{"file_path": "/" + href, "mode": req.Mode, "detected": detected(doc)})
+	})
+}
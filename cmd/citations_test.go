@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestMaskCitation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			"APA",
+			`Smith, J., &amp; Doe, A. (2019). <i>The history of maps</i>. Oxford University Press. https://doi.org/10.1000/xyz123. A classic survey.`,
+			`{{CITE_2}}. (2019). {{CITE_0}}. Oxford University Press. {{CITE_1}}. A classic survey.`,
+		},
+		{
+			"MLA",
+			`Smith, John. “Mapping the Sea.” <em>Journal of Maps</em>, vol. 3, 2019, pp. 1-20. Argues against the consensus.`,
+			`{{CITE_2}}. {{CITE_1}} {{CITE_0}}, vol. 3, 2019, pp. 1-20. Argues against the consensus.`,
+		},
+		{
+			"link attributes stay",
+			`See <a href="https://example.com/a">the archive</a>, doi:10.1234/abc.`,
+			`See <a href="https://example.com/a">the archive</a>, {{CITE_0}}.`,
+		},
+		{"no citation parts", `Translated by the author.`, `Translated by the author.`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked, parts := maskCitation(tt.content)
+			if masked != tt.want {
+				t.Errorf("maskCitation() = %q, want %q", masked, tt.want)
+			}
+
+			restored, err := unmaskCitation(masked, parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored != tt.content {
+				t.Errorf("unmaskCitation() = %q, want %q", restored, tt.content)
+			}
+		})
+	}
+}
+
+func TestIsOnlyCitation(t *testing.T) {
+	masked, parts := maskCitation(`Smith, J. (2019). <i>Maps</i>. https://doi.org/10.1/x`)
+	if !isOnlyCitation(masked, parts) {
+		t.Errorf("%q has nothing to translate", masked)
+	}
+
+	masked, parts = maskCitation(`Smith, J. (2019). <i>Maps</i>. Out of print.`)
+	if isOnlyCitation(masked, parts) {
+		t.Errorf("%q has an annotation to translate", masked)
+	}
+}
+
+func TestInBibliography(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<body>
+<h1>Chapter 3</h1><p id="text">Text.</p>
+<section><h2>References</h2><p id="heading">Smith (2019).</p></section>
+<ol role="doc-bibliography"><li id="role">Doe (2020).</li></ol>
+</body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{"text": false, "heading": true, "role": true}
+	for id, want := range tests {
+		if got := inBibliography(doc.Find("#" + id)); got != want {
+			t.Errorf("inBibliography(#%s) = %v, want %v", id, got, want)
+		}
+	}
+
+	if isCitation(doc.Find("#heading"), citationOff) || !isCitation(doc.Find("#text"), citationOn) {
+		t.Error("the mode of the file overrides detection")
+	}
+}
@@ -16,7 +16,7 @@ import (
 var Clean = &cobra.Command{
 	Use:     "clean [unpackedEpubPath]",
 	Short:   "Clean the HTML files in the unpacked EPUB",
-	Long:    "This command cleans the HTML files by removing empty anchor and div tags. It should be called before any other commands like translate, styling, or mark to ensure the content is properly formatted.",
+	Long:    "This command cleans the HTML files by removing empty anchor and div tags, except page breaks and the targets of the page list. It should be called before any other commands like translate, styling, or mark to ensure the content is properly formatted.",
 	Example: "epubtrans clean path/to/unpacked/epub",
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
@@ -45,11 +45,30 @@ func runCleaner(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	return cleanBook(ctx, unzipPath, workers)
+}
+
+func cleanBook(ctx context.Context, unzipPath string, workers int) error {
 	cleaningOps := []CleaningOperation{
+		expandPageBreaks,
 		removeEmptyAnchor,
 		removeEmptyDiv,
 	}
 
+	// Empty anchors are also the page breaks of older books.
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return err
+	}
+	targets, err := pageListTargets(book)
+	if err != nil {
+		return err
+	}
+	pageListIDs = make(map[string]bool, len(targets))
+	for _, t := range targets {
+		pageListIDs[t.Fragment] = true
+	}
+
 	return processor.ProcessEpub(ctx, unzipPath, processor.Config{
 		Workers:      workers,
 		JobBuffer:    10,
@@ -71,7 +90,7 @@ func cleanFile(ctx context.Context, filePath string, cleaningOps []CleaningOpera
 	}
 
 	if cleanedContent != string(content) {
-		err = os.WriteFile(filePath, []byte(cleanedContent), 0644)
+		err = writeBookFile(filePath, []byte(cleanedContent))
 		if err != nil {
 			return fmt.Errorf("failed to write file %s: %w", filePath, err)
 		}
@@ -83,12 +102,37 @@ func cleanFile(ctx context.Context, filePath string, cleaningOps []CleaningOpera
 	return nil
 }
 
+var (
+	emptyAnchorRegex = regexp.MustCompile(`<a[^>]*(?:/>|>[\s\n]*</a>)`)
+	emptyDivRegex    = regexp.MustCompile(`<div[^>]*>[\s\n]*</div>`)
+)
+
 func removeEmptyAnchor(htmlContent string) string {
-	regexPattern := regexp.MustCompile(`<a[^>]*(?:/>|>[\s\n]*</a>)`)
-	return regexPattern.ReplaceAllString(htmlContent, "")
+	return emptyAnchorRegex.ReplaceAllStringFunc(htmlContent, keepPageBreak)
 }
 
 func removeEmptyDiv(htmlContent string) string {
-	regexPattern := regexp.MustCompile(`<div[^>]*>[\s\n]*</div>`)
-	return regexPattern.ReplaceAllString(htmlContent, "")
+	return emptyDivRegex.ReplaceAllStringFunc(htmlContent, keepPageBreak)
+}
+
+var softHyphenRegex = regexp.MustCompile(`\x{00AD}|&shy;|&#173;|&#[xX]0*[aA][dD];`)
+
+// removeSoftHyphens strips discretionary hyphens, which split words into
+// fragments the model cannot recognise.
+func removeSoftHyphens(htmlContent string) string {
+	return softHyphenRegex.ReplaceAllString(htmlContent, "")
+}
+
+var hyphenatedBreakRegex = regexp.MustCompile(`(\p{L})-(?:\s*<br\s*/?>\s*|\s*</span>\s*<span[^>]*>)(\p{Ll})`)
+
+// joinHyphenatedLineBreaks rejoins words that the source split across a line
+// break or across two spans, e.g. "transla-<br/>tion".
+func joinHyphenatedLineBreaks(htmlContent string) string {
+	return hyphenatedBreakRegex.ReplaceAllString(htmlContent, "$1$2")
+}
+
+// normalizeHyphenation applies the hyphenation clean-ups to a single segment.
+// They are left out of clean, so the book itself keeps its hyphenation.
+func normalizeHyphenation(htmlContent string) string {
+	return joinHyphenatedLineBreaks(removeSoftHyphens(htmlContent))
 }
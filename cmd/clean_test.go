@@ -0,0 +1,26 @@
+package cmd
+
+import "testing"
+
+func TestNormalizeHyphenation(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"soft hyphen character", "trans\u00adla\u00adtion", "translation"},
+		{"soft hyphen entity", "trans&shy;la&#173;t&#xAD;i&#x00ad;on", "translation"},
+		{"compound hyphen", "a well-known mother-in-law", "a well-known mother-in-law"},
+		{"compound hyphen before a capital", "the Franco-<br/>Prussian war", "the Franco-<br/>Prussian war"},
+		{"number range", "pages 12-<br/>14", "pages 12-<br/>14"},
+		{"line-end hyphen", "transla-<br/>tion", "translation"},
+		{"line-end hyphen with spaces", "transla- <br /> tion", "translation"},
+		{"hyphen across spans", `<span class="l">transla-</span> <span class="l">tion</span>`, `<span class="l">translation</span>`},
+		{"hyphen before a paragraph break", "<p>transla-</p><p>tion</p>", "<p>transla-</p><p>tion</p>"},
+		{"soft hyphen at a line end", "trans\u00ad<br/>lation", "trans<br/>lation"},
+		{"non-ASCII letters", "Stra-<br/>ße", "Straße"},
+	}
+	for _, tt := range tests {
+		if got := normalizeHyphenation(tt.in); got != tt.want {
+			t.Errorf("%s: normalizeHyphenation(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	csrfCookieName = "epubtrans_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// allowedOrigins are origins besides the server's own that may call the
+// mutating endpoints, e.g. the public URL of a reverse proxy.
+var allowedOrigins []string
+
+// newCSRFToken returns the token browsers must echo on mutating requests. It
+// lives as long as the server, so pages opened before a restart must be
+// reloaded.
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// csrfProtection rejects mutating requests made by a page of another origin.
+// Safe requests receive the token in a cookie that app.js sends back in the
+// X-CSRF-Token header. Requests without Origin, Referer, Sec-Fetch-Site or
+// cookies come from scripts like curl, which no web page can drive, and need
+// no token.
+func csrfProtection(token string, trusted []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			if c.Cookies(csrfCookieName) != token {
+				c.Cookie(&fiber.Cookie{
+					Name:        csrfCookieName,
+					Value:       token,
+					Path:        "/",
+					SameSite:    fiber.CookieSameSiteStrictMode,
+					Secure:      c.Secure(),
+					SessionOnly: true,
+				})
+			}
+			return c.Next()
+		}
+
+		if site := c.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cross-site request rejected"})
+		}
+
+		origin := c.Get(fiber.HeaderOrigin)
+		if origin == "" {
+			origin = refererOrigin(c.Get(fiber.HeaderReferer))
+		}
+		if origin != "" && !sameOrigin(origin, c.BaseURL(), trusted) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cross-origin request rejected"})
+		}
+
+		fromBrowser := origin != "" || c.Get("Sec-Fetch-Site") != "" || c.Get(fiber.HeaderCookie) != ""
+		if fromBrowser && subtle.ConstantTimeCompare([]byte(c.Get(csrfHeaderName)), []byte(token)) != 1 {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Missing or invalid CSRF token, reload the page"})
+		}
+
+		return c.Next()
+	}
+}
+
+// refererOrigin returns the scheme://host part of a Referer header.
+func refererOrigin(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func sameOrigin(origin, self string, trusted []string) bool {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	if origin == strings.ToLower(self) {
+		return true
+	}
+	for _, t := range trusted {
+		if origin == strings.TrimSuffix(strings.ToLower(t), "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	const token = "secret"
+
+	app := fiber.New()
+	app.Use(csrfProtection(token, []string{"https://book.example.com"}))
+	app.Get("/chapter.xhtml", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Patch("/api/update-translation", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"page load", "GET", nil, 200},
+		{"script without browser headers", "PATCH", nil, 200},
+		{"same origin with token", "PATCH", map[string]string{"Origin": "http://example.com", csrfHeaderName: token}, 200},
+		{"same origin without token", "PATCH", map[string]string{"Origin": "http://example.com"}, 403},
+		{"same origin with wrong token", "PATCH", map[string]string{"Origin": "http://example.com", csrfHeaderName: "guess"}, 403},
+		{"cookie without token", "PATCH", map[string]string{"Cookie": csrfCookieName + "=" + token}, 403},
+		{"other origin with token", "PATCH", map[string]string{"Origin": "http://evil.example", csrfHeaderName: token}, 403},
+		{"other referer", "PATCH", map[string]string{"Referer": "http://evil.example/page.html", csrfHeaderName: token}, 403},
+		{"same referer", "PATCH", map[string]string{"Referer": "http://example.com/chapter.xhtml", csrfHeaderName: token}, 200},
+		{"cross-site fetch", "PATCH", map[string]string{"Sec-Fetch-Site": "cross-site", csrfHeaderName: token}, 403},
+		{"trusted origin", "PATCH", map[string]string{"Origin": "https://book.example.com", csrfHeaderName: token}, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api/update-translation"
+			if tt.method == "GET" {
+				path = "/chapter.xhtml"
+			}
+			req := httptest.NewRequest(tt.method, "http://example.com"+path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.method == "GET" && !strings.Contains(resp.Header.Get("Set-Cookie"), csrfCookieName+"="+token) {
+				t.Errorf("Set-Cookie = %q, want the CSRF token", resp.Header.Get("Set-Cookie"))
+			}
+		})
+	}
+}
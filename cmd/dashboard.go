@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// chapterProgress is the translation progress of a spine file.
+type chapterProgress struct {
+	Href       string `json:"href"`
+	Segments   int    `json:"segments"`
+	Translated int    `json:"translated"`
+	// Stale counts the translations whose original changed since.
+	Stale   int `json:"stale"`
+	Percent int `json:"percent"`
+}
+
+// bookProgress is the translation progress of the spine files, in reading
+// order, and of the whole book.
+type bookProgress struct {
+	Chapters   []chapterProgress `json:"chapters"`
+	Segments   int               `json:"segments"`
+	Translated int               `json:"translated"`
+	Stale      int               `json:"stale"`
+	Percent    int               `json:"percent"`
+}
+
+func percentOf(part, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return part * 100 / total
+}
+
+// readingProgress counts the marked segments of every spine file and how many
+// of them have a translation that is not empty, and how many translations are
+// stale. Translations may be placed in another file, e.g. with endnote
+// placement, so all files are read.
+func readingProgress(unzipPath string) (*bookProgress, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(map[string]*goquery.Document)
+	// translated tells by translation id whether the translation has text,
+	// and sourceHashes holds the source hashes of the translations.
+	translated := make(map[string]bool)
+	sourceHashes := make(map[string]string)
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		docs[item.ID] = doc
+
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			translated[s.AttrOr(util.TranslationIdKey, "")] = strings.TrimSpace(s.Text()) != ""
+			sourceHashes[s.AttrOr(util.TranslationIdKey, "")] = s.AttrOr(util.TranslationSourceHashKey, "")
+		})
+	}
+
+	progress := &bookProgress{Chapters: []chapterProgress{}}
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || docs[item.ID] == nil {
+			continue
+		}
+
+		chapter := chapterProgress{Href: item.Href}
+		docs[item.ID].Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+			chapter.Segments++
+			translationID := s.AttrOr(util.TranslationByIdKey, "")
+			if translated[translationID] {
+				chapter.Translated++
+			}
+			if staleHash(s, sourceHashes[translationID]) {
+				chapter.Stale++
+			}
+		})
+		chapter.Percent = percentOf(chapter.Translated, chapter.Segments)
+
+		progress.Chapters = append(progress.Chapters, chapter)
+		progress.Segments += chapter.Segments
+		progress.Translated += chapter.Translated
+		progress.Stale += chapter.Stale
+	}
+	progress.Percent = percentOf(progress.Translated, progress.Segments)
+
+	return progress, nil
+}
+
+// registerProgressAPI adds the endpoint with the translation progress per
+// chapter.
+func registerProgressAPI(api fiber.Router, unpackedEpubPath string) {
+	api.Get("/progress", func(c *fiber.Ctx) error {
+		progress, err := readingProgress(unpackedEpubPath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read book"})
+		}
+		return c.JSON(progress)
+	})
+}
+
+// registerProgressPage adds /progress, a dashboard of the translation progress
+// that refreshes itself while a translation runs.
+func registerProgressPage(app fiber.Router, unpackedEpubPath string) {
+	app.Get("/progress", func(c *fiber.Ctx) error {
+		progress, err := readingProgress(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error reading book: %v", err))
+		}
+
+		base := html.EscapeString(bookBase(c))
+		var rows strings.Builder
+		for _, chapter := range progress.Chapters {
+			if chapter.Segments == 0 {
+				continue
+			}
+			stale := ""
+			if chapter.Stale > 0 {
+				stale = fmt.Sprintf(" (%d stale)", chapter.Stale)
+			}
+			fmt.Fprintf(&rows, `<tr><td><a href="%s/%s">%s</a></td><td>%d / %d%s</td><td><meter min="0" max="100" low="50" high="99" optimum="100" value="%d"></meter> %d%%</td></tr>`,
+				base, html.EscapeString(chapter.Href), html.EscapeString(chapter.Href), chapter.Translated, chapter.Segments, stale, chapter.Percent, chapter.Percent)
+		}
+
+		c.Set("Content-Type", "text/html")
+		return c.SendString(fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="refresh" content="30">
+    <title>Translation progress</title>
+    <link rel="stylesheet" href="%s">
+    <script src="%s"></script>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; max-width: 900px; margin: 0 auto; padding: 16px; }
+        table { width: 100%%; border-collapse: collapse; }
+        td, th { padding: 4px 8px; text-align: left; border-bottom: 1px solid var(--epubtrans-border-subtle); }
+        meter { width: 160px; }
+    </style>
+</head>
+<body>
+    <h1>Translation progress</h1>
+    <p>%d of %d segments translated (%d%%), %d stale. <a href="%s/review">Review</a></p>
+    <table>
+        <tr><th>Chapter</th><th>Translated segments</th><th>Progress</th></tr>
+        %s
+    </table>
+    <script>addThemeToggle(document.body);</script>
+</body>
+</html>
+`, assetURL("theme.css"), assetURL("theme.js"), progress.Translated, progress.Segments, progress.Percent, progress.Stale, base, rows.String()))
+	})
+}
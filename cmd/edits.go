@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// editEntry is a change of a translation made in serve, or the undoing of
+// one.
+type editEntry struct {
+	Time          time.Time `json:"time"`
+	TranslationID string    `json:"translation_id"`
+	Old           string    `json:"old"`
+	New           string    `json:"new"`
+	// OldProvenance is restored when the edit is undone.
+	OldProvenance provenance `json:"old_provenance"`
+	// Undo marks the entries that revert the latest edit of the translation
+	// not undone yet.
+	Undo bool `json:"undo,omitempty"`
+}
+
+// editLog keeps an append-only log of the edits of every file in
+// <unpacked-dir>-edits/, one JSON line per edit.
+type editLog struct {
+	dir string
+}
+
+func newEditLog(unpackedEpubPath string) *editLog {
+	return &editLog{dir: filepath.Clean(unpackedEpubPath) + "-edits"}
+}
+
+// logPath returns the log of the file href, relative to the content directory.
+func (l *editLog) logPath(href string) string {
+	return filepath.Join(l.dir, url.PathEscape(href)+".jsonl")
+}
+
+// add appends an entry to the log of href. Callers hold the lock of the file.
+func (l *editLog) add(href string, e editEntry) error {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.logPath(href), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// entries returns the log of href, oldest first.
+func (l *editLog) entries(href string) ([]editEntry, error) {
+	f, err := os.Open(l.logPath(href))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []editEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e editEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("reading edit log of %s: %w", href, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// undoable returns, by translation id, the indexes of the edits an undo would
+// revert, latest last: every undo entry takes back the latest edit before it.
+func undoable(entries []editEntry) map[string][]int {
+	stacks := make(map[string][]int)
+	for i, e := range entries {
+		stack := stacks[e.TranslationID]
+		switch {
+		case !e.Undo:
+			stacks[e.TranslationID] = append(stack, i)
+		case len(stack) > 0:
+			stacks[e.TranslationID] = stack[:len(stack)-1]
+		}
+	}
+	return stacks
+}
+
+// editHref returns the href of the file_path of a request, relative to the
+// content directory.
+func editHref(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filePath), "/")
+}
+
+// recordEdit logs the change of a translation, unless nothing changed. The
+// edit is already saved, so a failure is only logged.
+func recordEdit(edits *editLog, href string, e editEntry) {
+	if e.Old == e.New {
+		return
+	}
+	if err := edits.add(href, e); err != nil {
+		slog.Warn("failed to record edit", "file", href, "translation_id", e.TranslationID, "error", err)
+	}
+}
+
+type UndoRequest struct {
+	FilePath      string `json:"file_path"`
+	TranslationID string `json:"translation_id"`
+}
+
+// registerEditAPI adds the edit history of a file and the undoing of the
+// latest edit of a translation.
+func registerEditAPI(api fiber.Router, edits *editLog, locks *segmentLocks, contentDirPath string) {
+	api.Get("/edit-history", func(c *fiber.Ctx) error {
+		if c.Query("file_path") == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "file_path is required"})
+		}
+		entries, err := edits.entries(editHref(c.Query("file_path")))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read edit history"})
+		}
+		if id := c.Query("translation_id"); id != "" {
+			var filtered []editEntry
+			for _, e := range entries {
+				if e.TranslationID == id {
+					filtered = append(filtered, e)
+				}
+			}
+			entries = filtered
+		}
+		if entries == nil {
+			entries = []editEntry{}
+		}
+		return c.JSON(entries)
+	})
+
+	api.Post("/undo-translation", func(c *fiber.Ctx) error {
+		var req UndoRequest
+		if err := c.BodyParser(&req); err != nil || req.FilePath == "" || req.TranslationID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		href := editHref(req.FilePath)
+		filePath := path.Join(contentDirPath, href)
+		fileLock := getFileLock(filePath)
+		fileLock.Lock()
+		defer fileLock.Unlock()
+
+		entries, err := edits.entries(href)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read edit history"})
+		}
+		stack := undoable(entries)[req.TranslationID]
+		if len(stack) == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "No edit to undo"})
+		}
+		edit := entries[stack[len(stack)-1]]
+
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read file"})
+		}
+		if rejectAILocked(c, locks, href, doc, req.TranslationID) {
+			return nil
+		}
+		translation := doc.Find(fmt.Sprintf("[%s=%q]", util.TranslationIdKey, req.TranslationID)).First()
+		if translation.Length() == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "Translation ID not found"})
+		}
+		current, _ := translation.Html()
+		if current != edit.New {
+			// Written since, e.g. by an AI batch; undoing would lose that.
+			return c.Status(409).JSON(fiber.Map{"error": "The translation changed after the edit; edit it instead"})
+		}
+
+		translation.SetHtml(edit.Old)
+		undone := provenanceOf(translation)
+		edit.OldProvenance.apply(translation)
+		restored, _ := translation.Html()
+		if err := writeContentToFile(filePath, doc); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to write file"})
+		}
+		recordEdit(edits, href, editEntry{Time: time.Now(), TranslationID: req.TranslationID, Old: current, New: restored, OldProvenance: undone, Undo: true})
+
+		return c.JSON(fiber.Map{
+			"translation_content": restored,
+			"provenance":          edit.OldProvenance,
+			"undoable":            len(stack) > 1,
+		})
+	})
+}
+
+// registerEditPages adds /history/<file>, the edits of a chapter, newest
+// first, with the undoing of the latest edit of every translation.
+func registerEditPages(app fiber.Router, edits *editLog) {
+	app.Get("/history/*", func(c *fiber.Ctx) error {
+		href := editHref(c.Params("*"))
+		entries, err := edits.entries(href)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		// The latest edit of a translation that can be undone gets the button.
+		latest := make(map[int]bool)
+		for _, stack := range undoable(entries) {
+			if len(stack) > 0 {
+				latest[stack[len(stack)-1]] = true
+			}
+		}
+
+		var rows strings.Builder
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			action := "Edit"
+			if e.Undo {
+				action = "Undo"
+			}
+			undo := ""
+			if latest[i] {
+				undo = fmt.Sprintf(`<button class="history-undo" data-translation-id="%s">Undo</button>`, html.EscapeString(e.TranslationID))
+			}
+			fmt.Fprintf(&rows, `<tr class="history-row">
+            <td class="history-meta">%s<br>%s<br><code>%s</code><br>was %s</td>
+            <td class="review-original">%s</td>
+            <td class="review-translation">%s</td>
+            <td class="review-actions">%s</td>
+        </tr>`, e.Time.Local().Format("2006-01-02 15:04:05"), action, html.EscapeString(e.TranslationID),
+				html.EscapeString(e.OldProvenance.String()), e.Old, e.New, undo)
+		}
+		if len(entries) == 0 {
+			rows.WriteString(`<tr><td>No edits yet.</td></tr>`)
+		}
+
+		c.Set("Content-Type", "text/html")
+		return c.SendString(reviewPage(bookBase(c), "Edits of "+href, "/"+href, fmt.Sprintf(`
+    <nav class="review-nav"><a href="%s/%s">Edit</a> · <a href="%s/review/%s">Review</a></nav>
+    <h1>Edits of %s</h1>
+    <p class="history-file" data-file-path="/%s">Newest first: before and after every edit.</p>
+    <table class="review-chapter">
+        %s
+    </table>`, html.EscapeString(bookBase(c)), html.EscapeString(href), html.EscapeString(bookBase(c)), html.EscapeString(href), html.EscapeString(href), html.EscapeString(href), rows.String())))
+	})
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEditLog(t *testing.T) {
+	edits := newEditLog(filepath.Join(t.TempDir(), "book"))
+
+	entries, err := edits.entries("text/ch1.xhtml")
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("entries of a file without edits = %v, %v", entries, err)
+	}
+
+	first := editEntry{Time: time.Unix(1, 0).UTC(), TranslationID: "t1", Old: "Hallo", New: "Hoi", OldProvenance: provenance{Provider: "anthropic"}}
+	second := editEntry{Time: time.Unix(2, 0).UTC(), TranslationID: "t1", Old: "Hoi", New: "Hé"}
+	for _, e := range []editEntry{first, second} {
+		if err := edits.add("text/ch1.xhtml", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := edits.add("text/ch2.xhtml", first); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = edits.entries("text/ch1.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, []editEntry{first, second}) {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestUndoable(t *testing.T) {
+	entries := []editEntry{
+		{TranslationID: "t1", Old: "a", New: "b"},
+		{TranslationID: "t2", Old: "x", New: "y"},
+		{TranslationID: "t1", Old: "b", New: "c"},
+		{TranslationID: "t1", Old: "c", New: "b", Undo: true},
+		{TranslationID: "t2", Old: "y", New: "x", Undo: true},
+	}
+
+	stacks := undoable(entries)
+	if got := stacks["t1"]; !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("undoable t1 = %v, want the first edit", got)
+	}
+	if got := stacks["t2"]; len(got) != 0 {
+		t.Errorf("undoable t2 = %v, want none", got)
+	}
+
+	// An undo without an edit left to revert changes nothing.
+	entries = append(entries, editEntry{TranslationID: "t2", Undo: true})
+	if got := undoable(entries)["t2"]; len(got) != 0 {
+		t.Errorf("undoable t2 after extra undo = %v", got)
+	}
+}
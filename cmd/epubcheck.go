@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+// The checks below look at a book the way a reading system opens it, in the
+// spirit of epubcheck: they work on an fs.FS, so a packed EPUB is checked
+// from its archive and an unpacked one from the directory.
+
+const epubMimetype = "application/epub+zip"
+
+// checkArchive checks the packed EPUB at epubPath: the mimetype entry, which
+// must come first and uncompressed, and then its content as checkContent does,
+// including the manifest.
+func checkArchive(epubPath string) ([]string, error) {
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("not an EPUB archive: %w", err)
+	}
+	defer r.Close()
+
+	var problems []string
+	if len(r.File) == 0 || r.File[0].Name != "mimetype" {
+		problems = append(problems, "mimetype is not the first entry of the archive")
+	} else if r.File[0].Method != zip.Store {
+		problems = append(problems, "mimetype is compressed")
+	}
+
+	content, err := checkContent(&r.Reader, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, content...), nil
+}
+
+// checkContent checks the book in fsys: the mimetype file, the container, the
+// internal links and the well-formedness of the XHTML documents of the
+// manifest. With manifest, it also checks the manifest against the files,
+// which validate does in more detail for unpacked books.
+func checkContent(fsys fs.FS, manifest bool) ([]string, error) {
+	var problems []string
+
+	mimetype, err := fs.ReadFile(fsys, "mimetype")
+	switch {
+	case err != nil:
+		problems = append(problems, "mimetype is missing")
+	case string(mimetype) != epubMimetype:
+		problems = append(problems, fmt.Sprintf("mimetype holds %q instead of %q", mimetype, epubMimetype))
+	}
+
+	var container loader.Container
+	if err := decodeXMLFile(fsys, "META-INF/container.xml", &container); err != nil {
+		return append(problems, fmt.Sprintf("META-INF/container.xml: %v", err)), nil
+	}
+	opfPath := container.Rootfile.FullPath
+	if opfPath == "" {
+		return append(problems, "META-INF/container.xml names no package document"), nil
+	}
+	var pkg loader.Package
+	if err := decodeXMLFile(fsys, opfPath, &pkg); err != nil {
+		return append(problems, fmt.Sprintf("%s: %v", opfPath, err)), nil
+	}
+
+	contentDir := path.Dir(opfPath)
+	inManifest := make(map[string]bool)
+	docs := make(map[string]*xhtmlDoc)
+	var docNames []string
+	for _, item := range pkg.Manifest.Items {
+		name := manifestPath(contentDir, item.Href)
+		inManifest[name] = true
+
+		if _, err := fs.Stat(fsys, name); err != nil {
+			if manifest {
+				problems = append(problems, fmt.Sprintf("%s: manifest item %q has no file", opfPath, item.ID))
+			}
+			continue
+		}
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		doc, err := readXHTML(fsys, name)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: malformed XHTML: %v", name, err))
+			continue
+		}
+		docs[name] = doc
+		docNames = append(docNames, name)
+	}
+
+	if manifest {
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if name == "mimetype" || name == opfPath || strings.HasPrefix(name, "META-INF/") || inManifest[name] {
+				return nil
+			}
+			problems = append(problems, fmt.Sprintf("%s: not in the manifest", name))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(docNames)
+	for _, name := range docNames {
+		for _, link := range docs[name].links {
+			if problem := checkLink(fsys, docs, name, link.target); problem != "" {
+				problems = append(problems, fmt.Sprintf("%s:%d: %s", name, link.line, problem))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// manifestPath returns the path in the book of a manifest href.
+func manifestPath(contentDir, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(contentDir, href)
+}
+
+// checkLink returns the problem of the link to target in the document name,
+// or "" if it leads somewhere.
+func checkLink(fsys fs.FS, docs map[string]*xhtmlDoc, name, target string) string {
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil {
+		return fmt.Sprintf("invalid link %q", target)
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+
+	file := name
+	if u.Path != "" {
+		file = path.Join(path.Dir(name), u.Path)
+		if _, err := fs.Stat(fsys, file); err != nil {
+			return fmt.Sprintf("broken link to %s", target)
+		}
+	}
+	if u.Fragment == "" {
+		return ""
+	}
+	if doc, ok := docs[file]; ok && !doc.ids[u.Fragment] {
+		return fmt.Sprintf("broken link to %s: no element with id %q", target, u.Fragment)
+	}
+	return ""
+}
+
+// xhtmlDoc holds the ids of an XHTML document and the links in it.
+type xhtmlDoc struct {
+	ids   map[string]bool
+	links []xhtmlLink
+}
+
+type xhtmlLink struct {
+	line   int
+	target string
+}
+
+// readXHTML parses the XHTML document name as XML. Named HTML entities are
+// accepted, as EPUB 2 books declare them through the XHTML doctype.
+func readXHTML(fsys fs.FS, name string) (*xhtmlDoc, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc := &xhtmlDoc{ids: make(map[string]bool)}
+	d := xml.NewDecoder(f)
+	d.Entity = xml.HTMLEntity
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return doc, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, _ := d.InputPos()
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "id":
+				doc.ids[attr.Value] = true
+			case "href", "src":
+				if attr.Value != "" && start.Name.Local != "base" {
+					doc.links = append(doc.links, xhtmlLink{line: line, target: attr.Value})
+				}
+			}
+		}
+	}
+}
+
+func decodeXMLFile(fsys fs.FS, name string, v any) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return xml.NewDecoder(f).Decode(v)
+}
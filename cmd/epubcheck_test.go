@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeSyncBook(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "mimetype"), []byte(epubMimetype), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Chapters are written back as translate writes them.
+	chapter := filepath.Join(dir, "OEBPS", "ch1.xhtml")
+	doc, err := openAndReadFile(chapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Find("body").AppendHtml(`<p id="end"><a href="ch1.xhtml#end">again</a> <a href="https://example.com/">site</a></p>`)
+	if err := writeContentToFile(chapter, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	if err := packFiles(dir, epubPath, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := checkArchive(epubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("packed book has problems: %q", problems)
+	}
+
+	// An archive with the mimetype last and compressed, like pack used to write.
+	badPath := filepath.Join(t.TempDir(), "bad.epub")
+	f, err := os.Create(badPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/ch1.xhtml", "OEBPS/extra.css", "mimetype"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	problems, err = checkArchive(badPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mimetype is not the first entry of the archive", "OEBPS/extra.css: not in the manifest"}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems = %q, want %q", problems, want)
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeSyncBook(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "mimetype"), []byte("application/zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editSyncChapter(t, dir, `<p data-content-id="b">World</p>`,
+		`<p data-content-id="b"><a href="ch2.xhtml">World</a> <a href="#nowhere">map</a> <img src="images/map.png" alt=""/></p>`)
+
+	problems, err := checkContent(os.DirFS(dir), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`mimetype holds "application/zip" instead of "application/epub+zip"`,
+		"OEBPS/ch1.xhtml:4: broken link to ch2.xhtml",
+		`OEBPS/ch1.xhtml:4: broken link to #nowhere: no element with id "nowhere"`,
+		"OEBPS/ch1.xhtml:4: broken link to images/map.png",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems = %q, want %q", problems, want)
+	}
+
+	editSyncChapter(t, dir, `<p data-content-id="c"`, `<p><br><p data-content-id="c"`)
+	problems, err = checkContent(os.DirFS(dir), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 || !strings.Contains(problems[1], "OEBPS/ch1.xhtml: malformed XHTML") {
+		t.Errorf("problems = %q, want malformed XHTML", problems)
+	}
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var Estimate = &cobra.Command{
+	Use:   "estimate [unpackedEpubPath]",
+	Short: "Estimate the tokens and cost of translating a book",
+	Long: `This command collects the segments translate would send, in the same batches, and estimates the input and
+output tokens of the run and what it costs with the models of every provider. Segments that are already translated and
+files translate skips are left out.
+
+Tokens are approximated from the characters of the text, since the tokenizers of the models are not available offline,
+so expect the actual usage to differ by some 20%. Costs use list prices without the discounts of prompt caching.`,
+	Example: `epubtrans estimate path/to/unpacked/epub --target Vietnamese --provider anthropic`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runEstimate,
+}
+
+func init() {
+	Estimate.Flags().String("source", "English", "source language")
+	Estimate.Flags().String("target", "Vietnamese", "target language")
+	Estimate.Flags().String("provider", "", "only list the models of this provider")
+	Estimate.Flags().String("model", "", "only list this model")
+	Estimate.Flags().StringSliceVar(&skipPatterns, "skip", nil, "regular expression for file names not to translate (repeatable)")
+	Estimate.Flags().BoolVar(&includeBoilerplate, "include-boilerplate", false, "also count pages that look like copyright pages or publisher ads")
+}
+
+// outputTokenRatios approximates how many tokens a translation into a language
+// takes per token of English source text. Scripts that tokenizers split into
+// many small pieces need more; unlisted languages use defaultOutputTokenRatio.
+var outputTokenRatios = map[string]float64{
+	"arabic":     1.5,
+	"chinese":    1.0,
+	"greek":      1.6,
+	"hebrew":     1.5,
+	"hindi":      2.5,
+	"japanese":   1.2,
+	"korean":     1.3,
+	"russian":    1.4,
+	"thai":       2.0,
+	"ukrainian":  1.5,
+	"vietnamese": 1.5,
+}
+
+const defaultOutputTokenRatio = 1.1
+
+// bookEstimate is what translating the untranslated segments of a book takes.
+type bookEstimate struct {
+	Chapters   int
+	Segments   int
+	Translated int
+	Requests   int
+	// Characters counts the text of the segments, which character-billed APIs charge for.
+	Characters int
+	// InputTokens excludes the system prompt, which is sent with every request.
+	InputTokens  int
+	OutputTokens int
+}
+
+func runEstimate(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	source, _ := cmd.Flags().GetString("source")
+	target, _ := cmd.Flags().GetString("target")
+	provider, _ := cmd.Flags().GetString("provider")
+	model, _ := cmd.Flags().GetString("model")
+
+	ratio, ok := outputTokenRatios[strings.ToLower(target)]
+	if !ok {
+		ratio = defaultOutputTokenRatio
+	}
+
+	est, err := estimateBook(unzipPath, ratio)
+	if err != nil {
+		return err
+	}
+
+	bookName, err := extractBookName(unzipPath)
+	if err != nil {
+		return fmt.Errorf("error extracting book name: %v", err)
+	}
+	terms, err := loadBookGlossary(unzipPath)
+	if err != nil {
+		return err
+	}
+	systemTokens := translator.EstimateTokens(translator.SystemPrompt(source, target, "", bookName) + terms.Prompt())
+	inputTokens := est.InputTokens + est.Requests*systemTokens
+
+	fmt.Printf("Chapters: %d\n", est.Chapters)
+	fmt.Printf("Segments to translate: %d (%d already translated)\n", est.Segments, est.Translated)
+	fmt.Printf("Requests: %d\n", est.Requests)
+	fmt.Printf("Characters: %d\n", est.Characters)
+	fmt.Printf("Input tokens: ~%d (%d per request for the system prompt)\n", inputTokens, systemTokens)
+	fmt.Printf("Output tokens: ~%d\n", est.OutputTokens)
+
+	fmt.Printf("\n%-10s %-28s %-24s %10s\n", "Provider", "Model", "List price", "Cost")
+	listed := 0
+	for _, p := range translator.Prices {
+		if (provider != "" && p.Provider != provider) || (model != "" && p.Model != model) {
+			continue
+		}
+
+		price := "free"
+		switch {
+		case p.Characters > 0:
+			price = fmt.Sprintf("$%.2f/M characters", p.Characters)
+		case p.Input > 0 || p.Output > 0:
+			price = fmt.Sprintf("$%.3g/$%.3g per M tokens", p.Input, p.Output)
+		}
+
+		fmt.Printf("%-10s %-28s %-24s %10s\n", p.Provider, p.Model, price,
+			fmt.Sprintf("$%.2f", p.Cost(inputTokens, est.OutputTokens, est.Characters)))
+		listed++
+	}
+	if listed == 0 {
+		fmt.Printf("No list price known for %s; the token counts above still apply.\n", strings.Trim(provider+" "+model, " "))
+	}
+
+	return nil
+}
+
+// estimateBook batches the segments of the book as translate does and
+// estimates the tokens of every batch. outputRatio is the number of output
+// tokens per input token of a segment.
+func estimateBook(unzipPath string, outputRatio float64) (*bookEstimate, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadBookSemantics(book); err != nil {
+		return nil, err
+	}
+
+	est := &bookEstimate{}
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" || processor.ShouldExcludeFile(item.Href) {
+			continue
+		}
+
+		filePath := filepath.Join(book.contentDir, item.Href)
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		reason, err := skipReason(filePath, doc)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			continue
+		}
+
+		est.Translated += doc.Find(fmt.Sprintf("[%s][%s]", util.ContentIdKey, util.TranslationByIdKey)).Length()
+
+		var batch, labels []string
+		batchLength, segments := 0, 0
+		doc.Find(fmt.Sprintf("[%s]:not([%s])", util.ContentIdKey, util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+			content, err := withoutPageBreaks(s)
+			if err != nil || len(content) <= 1 {
+				return
+			}
+			content = normalizeHyphenation(content)
+
+			segments++
+			est.Characters += utf8.RuneCountInString(s.Text())
+
+			if isSVGLabel(s) {
+				labels = append(labels, content)
+				return
+			}
+			if batchLength+len(content) > maxBatchLength && len(batch) > 0 {
+				est.addBatch(batch, outputRatio)
+				batch, batchLength = nil, 0
+			}
+			batch = append(batch, content)
+			batchLength += len(content)
+		})
+		est.addBatch(batch, outputRatio)
+		est.addBatch(labels, outputRatio)
+
+		if segments > 0 {
+			est.Chapters++
+			est.Segments += segments
+		}
+	}
+
+	return est, nil
+}
+
+// addBatch counts one request with the given segments, built like processBatch builds it.
+func (e *bookEstimate) addBatch(segments []string, outputRatio float64) {
+	if len(segments) == 0 {
+		return
+	}
+
+	e.Requests++
+	e.InputTokens += translator.EstimateTokens(batchPreamble)
+	for i, segment := range segments {
+		markers := translator.EstimateTokens(fmt.Sprintf("<SEGMENT_%d>\n\n</SEGMENT_%d>\n\n", i, i))
+		tokens := translator.EstimateTokens(segment)
+		e.InputTokens += markers + tokens
+		e.OutputTokens += markers + int(math.Ceil(float64(tokens)*outputRatio))
+	}
+}
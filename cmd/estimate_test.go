@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimateBook(t *testing.T) {
+	defer func(patterns []string) { skipPatterns = patterns }(skipPatterns)
+	skipPatterns = []string{"^notes"}
+
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Estimate")
+	long := strings.Repeat("word ", 400)
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Estimate</dc:title></metadata>
+  <manifest>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="notes" href="notes.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/><itemref idref="ch2"/><itemref idref="notes"/></spine>
+</package>`,
+		"ch1.xhtml": `<html><body>
+<p data-content-id="a">Translation of the storm.</p>
+<p data-content-id="b" data-translation-by-id="tb">The sea.</p>
+<p data-translation-id="tb">Biển.</p>
+</body></html>`,
+		// Two segments too long for one batch.
+		"ch2.xhtml":   `<html><body><p data-content-id="c">` + long + `</p><p data-content-id="d">` + long + `</p></body></html>`,
+		"notes.xhtml": `<html><body><p data-content-id="e">Skipped by --skip.</p></body></html>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "OEBPS", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	est, err := estimateBook(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Chapters != 2 || est.Segments != 3 || est.Translated != 1 || est.Requests != 3 {
+		t.Errorf("estimate = %+v, want 2 chapters, 3 segments in 3 requests and 1 translated", est)
+	}
+	if want := len("Translation of the storm.") + 2*len(long); est.Characters != want {
+		t.Errorf("characters = %d, want %d", est.Characters, want)
+	}
+	if est.InputTokens == 0 || est.OutputTokens == 0 {
+		t.Errorf("estimate = %+v, want tokens", est)
+	}
+
+	// Only the output depends on the output ratio.
+	longer, err := estimateBook(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if longer.InputTokens != est.InputTokens || longer.OutputTokens <= est.OutputTokens {
+		t.Errorf("estimate with output ratio 2 = %+v, with 1 = %+v", longer, est)
+	}
+}
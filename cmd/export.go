@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	exportBilingual  = "bilingual"
+	exportTranslated = "translated"
+)
+
+// ExportRequest chooses the edition packed by POST /export.
+type ExportRequest struct {
+	// Mode is "bilingual", the default, or "translated" for a translated-only
+	// edition.
+	Mode         string `json:"mode"`
+	BilingualTOC bool   `json:"bilingual_toc"`
+}
+
+// exportBook packs a copy of the book at unzipPath as the edition of mode and
+// returns the EPUB. The book itself is left as it is, so editing goes on.
+func exportBook(ctx context.Context, unzipPath, mode string, bilingualTOC bool) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "epubtrans-export-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bookDir := filepath.Join(tmpDir, filepath.Base(unzipPath))
+	if err := copyDir(unzipPath, bookDir); err != nil {
+		return nil, fmt.Errorf("copying book: %w", err)
+	}
+
+	vertical, err := isVerticalBook(bookDir)
+	if err != nil {
+		return nil, err
+	}
+	options := StylingOptions{Hide: "none", Vertical: vertical}
+	if mode == exportTranslated {
+		// As with styling --hide source --horizontal: the translations are
+		// horizontal, so the book should be as well.
+		if vertical {
+			if err := makeHorizontal(bookDir); err != nil {
+				return nil, err
+			}
+		}
+		options = StylingOptions{Hide: "source"}
+	}
+
+	// The copy is the export's own, so the page is written into it and
+	// styled along with the chapters.
+	if bilingualTOC {
+		files, err := generateBilingualTOC(bookDir, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate bilingual table of contents: %w", err)
+		}
+		for path, content := range files {
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	workers := runtime.NumCPU()
+	if err := processor.ProcessEpub(ctx, bookDir, processor.Config{
+		Workers:      workers,
+		JobBuffer:    10,
+		ResultBuffer: 10,
+	}, func(ctx context.Context, filePath string) error {
+		return stylingFile(ctx, filePath, options)
+	}); err != nil {
+		return nil, fmt.Errorf("styling: %w", err)
+	}
+
+	outputPath := filepath.Join(tmpDir, "book.epub")
+	if err := packFiles(bookDir, outputPath, nil, nil, nil); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(outputPath)
+}
+
+// registerExportAPI adds the endpoint that packs the book as it is being
+// edited and answers the EPUB as a download.
+func registerExportAPI(api fiber.Router, unpackedEpubPath string) {
+	// Exports copy the whole book; one at a time is enough.
+	var mu sync.Mutex
+
+	api.Post("/export", func(c *fiber.Ctx) error {
+		var req ExportRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		if req.Mode == "" {
+			req.Mode = exportBilingual
+		}
+		if req.Mode != exportBilingual && req.Mode != exportTranslated {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": `mode must be "bilingual" or "translated"`})
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		epub, err := exportBook(c.UserContext(), unpackedEpubPath, req.Mode, req.BilingualTOC)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to export book: %v", err)})
+		}
+
+		c.Attachment(fmt.Sprintf("%s-%s.epub", filepath.Base(unpackedEpubPath), req.Mode))
+		c.Set("Content-Type", "application/epub+zip")
+		return c.SendStream(bytes.NewReader(epub), len(epub))
+	})
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/spf13/pflag"
+)
+
+// When a translate run ends with segments it could not translate, or fails,
+// it writes a failure report next to its job, <unpacked-dir>-jobs/<id>-failures.json:
+// which segments failed, grouped by the class of the error, with a
+// suggested fix for every class and the command that retries just those
+// segments with the settings of the run. The report is also sent to the
+// --notify targets.
+
+// Classes of segment failures that are not errors of the provider.
+const (
+	failureProvider        = "provider"
+	failureSegmentMismatch = "segment_mismatch"
+	failureMarkupChanged   = "markup_changed"
+	failurePlaceholderLost = "placeholder_lost"
+	failureInsert          = "insert_failed"
+	failureWrite           = "write_failed"
+	failureFile            = "file_failed"
+)
+
+// failureFixes suggest what to do about the failures of a class, unless
+// translator.Diagnose has a hint for the error.
+var failureFixes = map[string]string{
+	failureProvider:        "retry; if it keeps failing, check the status page of the provider",
+	failureSegmentMismatch: "retry, as the model returned another number of segments; if it recurs, try another --model",
+	failureMarkupChanged:   "retry, as the model changed the markup of the segment; if it recurs, try another --model",
+	failurePlaceholderLost: "retry, as the model lost or repeated a placeholder; if it recurs, try another --model",
+	failureInsert:          "check the chapter with epubtrans validate",
+	failureWrite:           "check that the book is writable and the disk not full",
+	failureFile:            "check the chapter with epubtrans validate, then retry",
+}
+
+// failureReport is the failure report of a translate run.
+type failureReport struct {
+	Job    string    `json:"job"`
+	Book   string    `json:"book"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+	// Classes sums the failures up by class, most segments first.
+	Classes  []failureClass   `json:"classes,omitempty"`
+	Failures []segmentFailure `json:"failures,omitempty"`
+	// Retry is the command that translates the failed segments again.
+	Retry string `json:"retry"`
+}
+
+type failureClass struct {
+	Class    string `json:"class"`
+	Segments int    `json:"segments,omitempty"`
+	// Files counts the files that failed as a whole.
+	Files int    `json:"files,omitempty"`
+	Fix   string `json:"fix"`
+}
+
+// segmentFailure is a failure of segments of a file, keyed by its path
+// relative to the unpacked book; without content IDs, the whole file failed.
+type segmentFailure struct {
+	File       string   `json:"file"`
+	ContentIDs []string `json:"content_ids,omitempty"`
+	Class      string   `json:"class"`
+	Error      string   `json:"error"`
+	Fix        string   `json:"fix"`
+}
+
+// runFailures collects the failures of the running translation. Its methods
+// do nothing when it is nil, as for runs that are not tracked.
+var runFailures *failureCollector
+
+type failureCollector struct {
+	mu       sync.Mutex
+	root     string
+	failures []segmentFailure
+}
+
+func newFailureCollector(unzipPath string) *failureCollector {
+	return &failureCollector{root: unzipPath}
+}
+
+// add records the failure of the segments of the file at filePath with the
+// class, or the class translator.Diagnose finds for err.
+func (c *failureCollector) add(filePath string, contentIDs []string, class string, err error) {
+	if c == nil {
+		return
+	}
+
+	fix := failureFixes[class]
+	if diagnosed, hint := translator.Diagnose(err); diagnosed != "" {
+		class, fix = diagnosed, hint
+	}
+	key, relErr := filepath.Rel(c.root, filePath)
+	if relErr != nil {
+		key = filePath
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = append(c.failures, segmentFailure{
+		File:       filepath.ToSlash(key),
+		ContentIDs: contentIDs,
+		Class:      class,
+		Error:      err.Error(),
+		Fix:        fix,
+	})
+}
+
+func batchSegmentIDs(batch translationBatch) []string {
+	ids := make([]string, 0, len(batch.elements))
+	for _, element := range batch.elements {
+		ids = append(ids, contentID(element))
+	}
+	return ids
+}
+
+// report returns the failure report of the finished job, or nil if nothing
+// failed.
+func (c *failureCollector) report(info jobInfo, retry string) *failureReport {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.failures) == 0 && info.Error == "" {
+		return nil
+	}
+
+	r := &failureReport{
+		Job:      info.ID,
+		Book:     info.Book,
+		Status:   info.Status,
+		Error:    info.Error,
+		Time:     time.Now(),
+		Failures: c.failures,
+		Retry:    retry,
+	}
+	sort.SliceStable(r.Failures, func(i, j int) bool { return r.Failures[i].File < r.Failures[j].File })
+
+	classes := map[string]int{}
+	for _, f := range r.Failures {
+		i, ok := classes[f.Class]
+		if !ok {
+			i = len(r.Classes)
+			classes[f.Class] = i
+			r.Classes = append(r.Classes, failureClass{Class: f.Class, Fix: f.Fix})
+		}
+		r.Classes[i].Segments += len(f.ContentIDs)
+		if len(f.ContentIDs) == 0 {
+			r.Classes[i].Files++
+		}
+	}
+	sort.Slice(r.Classes, func(i, j int) bool {
+		if r.Classes[i].Segments != r.Classes[j].Segments {
+			return r.Classes[i].Segments > r.Classes[j].Segments
+		}
+		if r.Classes[i].Files != r.Classes[j].Files {
+			return r.Classes[i].Files > r.Classes[j].Files
+		}
+		return r.Classes[i].Class < r.Classes[j].Class
+	})
+	return r
+}
+
+func failureReportPath(unzipPath, jobID string) string {
+	return filepath.Join(jobsDir(unzipPath), jobID+"-failures.json")
+}
+
+// runFlags holds the flags the translate run was given on the command line,
+// for its retry command to give them again.
+var runFlags []string
+
+// notReplayed are the flags retryCommand leaves out: those it sets itself,
+// and those choosing the segments to translate, which the report replaces.
+var notReplayed = map[string]bool{
+	"source": true, "target": true, "provider": true, "model": true,
+	"retry-failures": true, "resume": true, "retranslate-where": true,
+}
+
+// changedFlags returns the flags set in flags, except those retryCommand
+// leaves out, as arguments; a repeatable flag is repeated for every value.
+func changedFlags(flags *pflag.FlagSet) []string {
+	var args []string
+	flags.Visit(func(f *pflag.Flag) {
+		if notReplayed[f.Name] {
+			return
+		}
+		if f.Value.Type() == "bool" {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+			return
+		}
+		values := []string{f.Value.String()}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			values = slice.GetSlice()
+		}
+		for _, value := range values {
+			args = append(args, "--"+f.Name, value)
+		}
+	})
+	return args
+}
+
+// retryCommand returns the translate command that retries the failures of
+// the report at reportPath with the settings of the run: its languages,
+// provider and model, and the flags it was given.
+func retryCommand(unzipPath, reportPath, model string, flags []string) string {
+	args := []string{"epubtrans", "translate", unzipPath,
+		"--source", sourceLanguage, "--target", targetLanguage,
+		"--provider", translationProvider}
+	if model != "" {
+		args = append(args, "--model", model)
+	}
+	args = append(args, flags...)
+	args = append(args, "--retry-failures", reportPath)
+
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?&;|<>()") {
+			args[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// writeFailureReport writes the failure report of the finished job, if
+// anything failed, prints where it is and sends it to the --notify targets.
+func writeFailureReport(unzipPath string, info jobInfo, model string) {
+	collector := runFailures
+	runFailures = nil
+
+	reportPath := failureReportPath(unzipPath, info.ID)
+	report := collector.report(info, retryCommand(unzipPath, reportPath, model, runFlags))
+	if report == nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Printf("Error writing failure report: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		fmt.Printf("Error writing failure report: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\n%s", report.text())
+	fmt.Printf("Failure report: %s\n", reportPath)
+	if err := notify(notifyTargets, fmt.Sprintf("epubtrans: %s of %s %s", info.Kind, info.Book, failureSubject(report)), report.text(), report); err != nil {
+		fmt.Printf("Error sending failure report: %v\n", err)
+	}
+}
+
+func failureSubject(r *failureReport) string {
+	if r.Error != "" {
+		return "failed"
+	}
+	segments, files := 0, 0
+	for _, class := range r.Classes {
+		segments += class.Segments
+		files += class.Files
+	}
+	if files > 0 {
+		return fmt.Sprintf("left %s and %s untranslated", countOf(segments, "segment"), countOf(files, "file"))
+	}
+	return fmt.Sprintf("left %s untranslated", countOf(segments, "segment"))
+}
+
+// text describes the report for people.
+func (r *failureReport) text() string {
+	var b strings.Builder
+	if r.Error != "" {
+		fmt.Fprintf(&b, "Job %s of %s failed: %s\n", r.Job, r.Book, r.Error)
+	} else {
+		fmt.Fprintf(&b, "Job %s of %s finished with failures:\n", r.Job, r.Book)
+	}
+	for _, class := range r.Classes {
+		var counts []string
+		if class.Segments > 0 {
+			counts = append(counts, countOf(class.Segments, "segment"))
+		}
+		if class.Files > 0 {
+			counts = append(counts, countOf(class.Files, "file"))
+		}
+		fmt.Fprintf(&b, "- %s (%s): %s\n", class.Class, strings.Join(counts, ", "), class.Fix)
+	}
+	fmt.Fprintf(&b, "Retry with:\n  %s\n", r.Retry)
+	return b.String()
+}
+
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// retryFailures, when set, is the failure report whose failed segments
+// translate retries, leaving the rest of the book alone.
+var retryFailures string
+
+func init() {
+	Translate.Flags().StringVar(&retryFailures, "retry-failures", "", "translate only the segments that failed in this failure report of an earlier run")
+}
+
+// retrySet holds the segments a retry translates: the content IDs, and the
+// files that failed as a whole.
+type retrySet struct {
+	contentIDs map[string]bool
+	files      map[string]bool
+}
+
+// retrySegments limits the translation to the failures of --retry-failures;
+// it is nil when the run translates everything.
+var retrySegments *retrySet
+
+// loadRetrySet reads the failures of the report at path. A report without
+// failures, as of a job that failed before translating, retries everything.
+func loadRetrySet(unzipPath, path string) (*retrySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading failure report: %w", err)
+	}
+	var report failureReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parsing failure report %s: %w", path, err)
+	}
+	if len(report.Failures) == 0 {
+		return nil, nil
+	}
+
+	set := &retrySet{contentIDs: map[string]bool{}, files: map[string]bool{}}
+	for _, f := range report.Failures {
+		if len(f.ContentIDs) == 0 {
+			set.files[filepath.Join(unzipPath, filepath.FromSlash(f.File))] = true
+		}
+		for _, id := range f.ContentIDs {
+			set.contentIDs[id] = true
+		}
+	}
+	return set, nil
+}
+
+// retries reports whether the segment with the content ID in the file at
+// filePath is translated.
+func (s *retrySet) retries(filePath, contentID string) bool {
+	return s == nil || s.files[filepath.Clean(filePath)] || s.contentIDs[contentID]
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/spf13/pflag"
+)
+
+func TestFailureReport(t *testing.T) {
+	defer func(source, target, provider string, targets []string) {
+		sourceLanguage, targetLanguage, translationProvider, notifyTargets = source, target, provider, targets
+	}(sourceLanguage, targetLanguage, translationProvider, notifyTargets)
+	sourceLanguage, targetLanguage, translationProvider = "English", "Vietnamese", "openai"
+
+	var posted failureReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+	notifyTargets = []string{server.URL}
+
+	// The retry gives the flags of the run again, but not those choosing
+	// the segments.
+	flags := pflag.NewFlagSet("translate", pflag.ContinueOnError)
+	flags.String("sampling", "default", "")
+	flags.String("placement", placementAuto, "")
+	flags.String("cache", bookCacheSpec, "")
+	flags.String("glossary", "", "")
+	flags.String("prompt-version", "", "")
+	flags.String("provider", "", "")
+	flags.Bool("resume", false, "")
+	flags.Bool("include-boilerplate", false, "")
+	flags.StringSlice("qa-rules", nil, "")
+	flags.StringSlice("retranslate-where", nil, "")
+	if err := flags.Parse([]string{"--sampling", "deterministic", "--placement=popup", "--cache", "file:/tmp/cache",
+		"--glossary", "my terms.yaml", "--prompt-version", "abc123", "--provider", "openai", "--resume", "--include-boilerplate",
+		"--qa-rules", "a.yaml,b.yaml", "--retranslate-where", "model=x"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func(flags []string) { runFlags = flags }(runFlags)
+	runFlags = changedFlags(flags)
+
+	dir := filepath.Join(t.TempDir(), "book")
+	if err := os.MkdirAll(jobsDir(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	chapter1 := filepath.Join(dir, "OEBPS", "ch1.xhtml")
+	chapter2 := filepath.Join(dir, "OEBPS", "ch2.xhtml")
+
+	runFailures = newFailureCollector(dir)
+	runFailures.add(chapter1, []string{"a", "b"}, failureProvider, fmt.Errorf("max retries reached: %w", translator.ErrRateLimitExceeded))
+	runFailures.add(chapter1, []string{"c"}, failureMarkupChanged, errors.New("markup differs from the original"))
+	runFailures.add(chapter2, nil, failureFile, errors.New("parsing chapter"))
+
+	info := jobInfo{ID: "20261016-120000", Kind: "translate", Book: "book", Status: "completed"}
+	writeFailureReport(dir, info, "gpt-4o-mini")
+	if runFailures != nil {
+		t.Error("the failures of the run were kept after the report")
+	}
+
+	reportPath := failureReportPath(dir, info.ID)
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report failureReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Classes) != 3 || report.Classes[0].Class != translator.ErrorClassRateLimit || report.Classes[0].Segments != 2 {
+		t.Errorf("classes = %+v", report.Classes)
+	}
+	for _, class := range report.Classes {
+		if class.Fix == "" {
+			t.Errorf("class %s has no suggested fix", class.Class)
+		}
+	}
+	if len(report.Failures) != 3 || report.Failures[2].File != "OEBPS/ch2.xhtml" {
+		t.Errorf("failures = %+v", report.Failures)
+	}
+	for _, want := range []string{"epubtrans translate ", "--provider openai", "--model gpt-4o-mini",
+		"--sampling deterministic", "--placement popup", "--cache file:/tmp/cache", "--glossary 'my terms.yaml'",
+		"--prompt-version abc123", "--include-boilerplate=true", "--qa-rules a.yaml --qa-rules b.yaml",
+		"--retry-failures " + reportPath} {
+		if !strings.Contains(report.Retry, want) {
+			t.Errorf("retry command %q lacks %q", report.Retry, want)
+		}
+	}
+	for _, unwanted := range []string{"--resume", "--retranslate-where", "--provider openai --provider"} {
+		if strings.Contains(report.Retry, unwanted) {
+			t.Errorf("retry command %q has %q", report.Retry, unwanted)
+		}
+	}
+	if posted.Job != info.ID || len(posted.Failures) != 3 {
+		t.Errorf("posted report = %+v", posted)
+	}
+
+	retry, err := loadRetrySet(dir, reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		file, id string
+		want     bool
+	}{
+		{chapter1, "a", true},
+		{chapter1, "c", true},
+		{chapter1, "d", false},
+		{chapter2, "e", true},
+	} {
+		if got := retry.retries(tt.file, tt.id); got != tt.want {
+			t.Errorf("retries(%s, %s) = %v, want %v", filepath.Base(tt.file), tt.id, got, tt.want)
+		}
+	}
+
+	// A run without failures writes no report.
+	runFailures = newFailureCollector(dir)
+	info.ID = "20261016-130000"
+	writeFailureReport(dir, info, "")
+	if _, err := os.Stat(failureReportPath(dir, info.ID)); !os.IsNotExist(err) {
+		t.Errorf("report written for a run without failures: %v", err)
+	}
+}
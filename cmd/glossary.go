@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/glossary"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+// glossaryFile overrides the glossary of a book, see bookGlossaryPath.
+var glossaryFile string
+
+var Glossary = &cobra.Command{
+	Use:   "glossary",
+	Short: "Manage the glossary of preferred term translations of a book",
+	Long: `The glossary of a book lists terms with their preferred translation. translate adds it to the prompt and
+warns about every translation that does not use the preferred translation of a term of the original.
+
+The glossary is kept next to the unpacked book as <unpacked-dir>-glossary.yaml, a mapping of term to translation,
+or as <unpacked-dir>-glossary.csv with term,translation rows; use --file for another location.`,
+}
+
+var glossaryAdd = &cobra.Command{
+	Use:     "add [unpackedEpubPath] [term] [translation]",
+	Short:   "Add a term or change its translation",
+	Example: `epubtrans glossary add path/to/unpacked/epub "Ministry of Magic" "Bộ Pháp thuật"`,
+	Args:    glossaryArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateGlossary(args[0], func(g *glossary.Glossary) error {
+			g.Add(args[1], args[2])
+			fmt.Printf("%s → %s\n", args[1], args[2])
+			return nil
+		})
+	},
+}
+
+var glossaryList = &cobra.Command{
+	Use:   "list [unpackedEpubPath]",
+	Short: "List the terms of the glossary",
+	Args:  glossaryArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := loadBookGlossary(args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, t := range g.Terms() {
+			fmt.Printf("%s → %s\n", t.Source, t.Target)
+		}
+		return nil
+	},
+}
+
+var glossaryRemove = &cobra.Command{
+	Use:   "remove [unpackedEpubPath] [term]",
+	Short: "Remove a term from the glossary",
+	Args:  glossaryArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateGlossary(args[0], func(g *glossary.Glossary) error {
+			if !g.Remove(args[1]) {
+				return fmt.Errorf("term %q is not in the glossary", args[1])
+			}
+			fmt.Printf("Removed %s\n", args[1])
+			return nil
+		})
+	},
+}
+
+func init() {
+	Glossary.PersistentFlags().StringVar(&glossaryFile, "file", "", "glossary file (.yaml, .yml or .csv) instead of the one next to the book")
+	Glossary.AddCommand(glossaryAdd, glossaryList, glossaryRemove)
+}
+
+func glossaryArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(n)(cmd, args); err != nil {
+			return err
+		}
+		return util.ValidateEpubPath(args[0])
+	}
+}
+
+// bookGlossaryPath returns the glossary of the unpacked book: --file or
+// --glossary if given, else <dir>-glossary.yaml, or the .csv variant if only
+// that exists.
+func bookGlossaryPath(unpackedEpubPath string) string {
+	if glossaryFile != "" {
+		return glossaryFile
+	}
+	return termsFilePath(unpackedEpubPath, "glossary")
+}
+
+// termsFilePath returns the term list kind kept next to the unpacked book,
+// <dir>-<kind>.yaml, or the .csv variant if only that exists.
+func termsFilePath(unpackedEpubPath, kind string) string {
+	base := filepath.Clean(unpackedEpubPath) + "-" + kind
+	if _, err := os.Stat(base + ".yaml"); os.IsNotExist(err) {
+		if _, err := os.Stat(base + ".csv"); err == nil {
+			return base + ".csv"
+		}
+	}
+	return base + ".yaml"
+}
+
+func loadBookGlossary(unpackedEpubPath string) (*glossary.Glossary, error) {
+	return glossary.Load(bookGlossaryPath(unpackedEpubPath))
+}
+
+func updateGlossary(unpackedEpubPath string, update func(g *glossary.Glossary) error) error {
+	g, err := loadBookGlossary(unpackedEpubPath)
+	if err != nil {
+		return err
+	}
+	if err := update(g); err != nil {
+		return err
+	}
+	return g.Save(bookGlossaryPath(unpackedEpubPath))
+}
+
+// checkGlossary warns about every term of the original whose preferred
+// translation the translation does not use.
+func checkGlossary(fileName, contentID, original, translation string) {
+	if bookGlossary.Len() == 0 {
+		return
+	}
+
+	for _, t := range bookGlossary.Check(plainText(original), plainText(translation)) {
+		fmt.Printf("Glossary: %q should be translated as %q in %s\n", t.Source, t.Target, fileName)
+		jobLog.Warn("glossary term not used", "file", fileName, "content_id", contentID, "term", t.Source, "expected", t.Target)
+	}
+}
+
+// plainText returns the text of an HTML fragment.
+func plainText(fragment string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return fragment
+	}
+	return doc.Text()
+}
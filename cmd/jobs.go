@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobLog receives the structured log of the running job. It discards
+// everything when no job is running.
+var jobLog = discardLogger()
+
+// jobLogHold holds back the records of jobLog about files that are processed
+// concurrently. Its methods do nothing when it is nil, as when no job is
+// running.
+var jobLogHold *logHold
+
+// jobIDRegex guards the job id taken from URLs against path traversal.
+var jobIDRegex = regexp.MustCompile(`^\d{8}-\d{6}(?:-\d+)?$`)
+
+// jobInfo describes a job; it is stored as <id>.json next to the <id>.log of the job.
+type jobInfo struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Book     string     `json:"book"`
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type job struct {
+	info    jobInfo
+	dir     string
+	logFile *os.File
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(io.Discard, nil))
+}
+
+// jobsDir keeps the jobs next to the unpacked directory, so they never end up
+// in the packed EPUB.
+func jobsDir(unzipPath string) string {
+	return filepath.Clean(unzipPath) + "-jobs"
+}
+
+// startJob records a new job and points jobLog at its log file.
+func startJob(unzipPath, kind string) (*job, error) {
+	dir := jobsDir(unzipPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating jobs directory: %w", err)
+	}
+
+	id := time.Now().Format("20060102-150405")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, id+".json")); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), n)
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, id+".log"))
+	if err != nil {
+		return nil, fmt.Errorf("creating job log: %w", err)
+	}
+
+	j := &job{
+		info:    jobInfo{ID: id, Kind: kind, Book: filepath.Base(filepath.Clean(unzipPath)), Status: "running", Started: time.Now()},
+		dir:     dir,
+		logFile: logFile,
+	}
+	if err := j.save(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	jobLogHold = &logHold{held: make(map[string][]heldRecord)}
+	handler := slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug})
+	jobLog = slog.New(&holdingHandler{Handler: handler, hold: jobLogHold}).With("job", id)
+	jobLog.Info("job started", "kind", kind, "book", j.info.Book)
+	fmt.Printf("Job %s, log: %s\n", id, logFile.Name())
+
+	return j, nil
+}
+
+// finish records the outcome of the job and closes its log.
+func (j *job) finish(err error) {
+	finished := time.Now()
+	j.info.Finished = &finished
+	j.info.Status = "completed"
+	if err != nil {
+		j.info.Status = "failed"
+		j.info.Error = err.Error()
+		jobLog.Error("job failed", "error", err)
+	} else {
+		jobLog.Info("job completed", "duration", finished.Sub(j.info.Started).Round(time.Second).String())
+	}
+
+	jobLogHold.releaseAll()
+	jobLogHold = nil
+	jobLog = discardLogger()
+	j.logFile.Close()
+
+	if saveErr := j.save(); saveErr != nil {
+		fmt.Printf("Error saving job %s: %v\n", j.info.ID, saveErr)
+	}
+}
+
+// logHold keeps the records about held files until their file is released,
+// so the log of files processed concurrently comes out in the order they are
+// released rather than interleaved in the order of completion.
+type logHold struct {
+	mu sync.Mutex
+	// held holds the records by the file attribute; a file without an
+	// entry is not held.
+	held map[string][]heldRecord
+}
+
+type heldRecord struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// hold starts holding back the records about file.
+func (h *logHold) hold(file string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.held[file]; !ok {
+		h.held[file] = []heldRecord{}
+	}
+}
+
+// release writes the records held for file and stops holding them.
+func (h *logHold) release(file string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.write(file)
+}
+
+// releaseAll writes the records of the files still held, by file name.
+func (h *logHold) releaseAll() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	files := make([]string, 0, len(h.held))
+	for file := range h.held {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		h.write(file)
+	}
+}
+
+// write writes and forgets the records held for file. The caller holds h.mu.
+func (h *logHold) write(file string) {
+	for _, held := range h.held[file] {
+		held.handler.Handle(context.Background(), held.record)
+	}
+	delete(h.held, file)
+}
+
+// holdingHandler passes records to Handler unless they are about a file held
+// by hold.
+type holdingHandler struct {
+	slog.Handler
+	hold *logHold
+}
+
+func (h *holdingHandler) Handle(ctx context.Context, r slog.Record) error {
+	file := ""
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "file" {
+			file = a.Value.String()
+			return false
+		}
+		return true
+	})
+
+	h.hold.mu.Lock()
+	held, ok := h.hold.held[file]
+	if ok {
+		h.hold.held[file] = append(held, heldRecord{handler: h.Handler, record: r.Clone()})
+	}
+	h.hold.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *holdingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &holdingHandler{Handler: h.Handler.WithAttrs(attrs), hold: h.hold}
+}
+
+func (h *holdingHandler) WithGroup(name string) slog.Handler {
+	return &holdingHandler{Handler: h.Handler.WithGroup(name), hold: h.hold}
+}
+
+func (j *job) save() error {
+	data, err := json.MarshalIndent(j.info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling job: %w", err)
+	}
+	return os.WriteFile(filepath.Join(j.dir, j.info.ID+".json"), data, 0644)
+}
+
+// listJobs returns the jobs of a book, newest first.
+func listJobs(unzipPath string) ([]jobInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(jobsDir(unzipPath), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]jobInfo, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var info jobInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			continue
+		}
+		jobs = append(jobs, info)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.After(jobs[j].Started) })
+	return jobs, nil
+}
+
+// readJobLogs returns the log entries of a job, optionally only those at or
+// above minLevel and those about files whose name contains file.
+func readJobLogs(unzipPath, id, minLevel, file string) ([]map[string]any, error) {
+	if !jobIDRegex.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := os.Open(filepath.Join(jobsDir(unzipPath), id+".log"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	threshold := slog.LevelDebug
+	if minLevel != "" {
+		if err := threshold.UnmarshalText([]byte(minLevel)); err != nil {
+			return nil, fmt.Errorf("invalid level %q", minLevel)
+		}
+	}
+
+	entries := []map[string]any{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		var level slog.Level
+		if s, ok := entry["level"].(string); ok && level.UnmarshalText([]byte(s)) == nil && level < threshold {
+			continue
+		}
+		if s, _ := entry["file"].(string); file != "" && !strings.Contains(s, file) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHoldingHandler(t *testing.T) {
+	var out bytes.Buffer
+	hold := &logHold{held: make(map[string][]heldRecord)}
+	log := slog.New(&holdingHandler{Handler: slog.NewJSONHandler(&out, nil), hold: hold}).With("job", "test")
+
+	// Chapter 2 is translated while chapter 1 still runs.
+	hold.hold("ch1.xhtml")
+	hold.hold("ch2.xhtml")
+	log.Info("file started", "file", "ch1.xhtml")
+	log.Info("file started", "file", "ch2.xhtml")
+	log.Info("batch translated", "file", "ch2.xhtml")
+	log.Info("not about a file")
+	log.Info("batch translated", "file", "ch1.xhtml")
+	hold.release("ch1.xhtml")
+	hold.releaseAll()
+	log.Info("file started", "file", "ch2.xhtml")
+
+	var got []string
+	for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["job"] != "test" {
+			t.Errorf("record without the job attribute: %s", line)
+		}
+		file, _ := entry["file"].(string)
+		got = append(got, entry["msg"].(string)+" "+file)
+	}
+
+	want := []string{
+		"not about a file ",
+		"file started ch1.xhtml",
+		"batch translated ch1.xhtml",
+		"file started ch2.xhtml",
+		"batch translated ch2.xhtml",
+		"file started ch2.xhtml",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("log = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+
+	first, err := startJob(dir, "translate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(jobsDir(dir), first.info.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state jobInfo
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != "running" || state.Kind != "translate" || state.Book != "book" || state.Finished != nil {
+		t.Errorf("state of the running job = %+v", state)
+	}
+	jobLog.Debug("batch sent", "file", "ch1.xhtml")
+	jobLog.Warn("segment rejected", "file", "ch2.xhtml")
+	first.finish(nil)
+	// The log of a finished job takes nothing more.
+	jobLog.Warn("after the job", "file", "ch1.xhtml")
+
+	// A job started within the same second gets an id of its own.
+	second, err := startJob(dir, "translate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.info.ID == first.info.ID || !jobIDRegex.MatchString(second.info.ID) {
+		t.Errorf("second job id = %q, first %q", second.info.ID, first.info.ID)
+	}
+	second.finish(errors.New("provider unreachable"))
+
+	jobs, err := listJobs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != second.info.ID || jobs[1].ID != first.info.ID {
+		t.Fatalf("listJobs() = %+v, want the second job first", jobs)
+	}
+	if jobs[0].Status != "failed" || jobs[0].Error != "provider unreachable" || jobs[0].Finished == nil {
+		t.Errorf("failed job = %+v", jobs[0])
+	}
+	if jobs[1].Status != "completed" || jobs[1].Error != "" || jobs[1].Finished == nil {
+		t.Errorf("completed job = %+v", jobs[1])
+	}
+
+	var messages []string
+	entries, err := readJobLogs(dir, first.info.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		messages = append(messages, entry["msg"].(string))
+	}
+	if want := []string{"job started", "batch sent", "segment rejected", "job completed"}; !slices.Equal(messages, want) {
+		t.Errorf("log = %v, want %v", messages, want)
+	}
+	if entries, err := readJobLogs(dir, first.info.ID, "warn", "ch2"); err != nil || len(entries) != 1 || entries[0]["msg"] != "segment rejected" {
+		t.Errorf("warnings about ch2 = %v, %v", entries, err)
+	}
+	if _, err := readJobLogs(dir, "../book", "", ""); !os.IsNotExist(err) {
+		t.Errorf("readJobLogs() with a path as id: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// Books re-run after a partial translation, or written in several languages,
+// hold segments in the target language already. translate leaves them
+// untranslated when util.DetectLanguage is sure of their language.
+
+// skipTargetLanguage skips segments already in the target language.
+var skipTargetLanguage bool
+
+func init() {
+	Translate.Flags().BoolVar(&skipTargetLanguage, "skip-target-language", true, "leave segments already written in the target language untranslated")
+}
+
+// isInTargetLanguage reports whether text is in the target language of the
+// run, which is not its source language.
+func isInTargetLanguage(text string) bool {
+	if !skipTargetLanguage {
+		return false
+	}
+	target := primaryLanguageCode(targetLanguage)
+	if target == "" || target == primaryLanguageCode(sourceLanguage) {
+		return false
+	}
+	return util.DetectLanguage(text) == target
+}
+
+// primaryLanguageCode returns the code of a language name or code without
+// its region, e.g. "pt" for "pt-BR".
+func primaryLanguageCode(lang string) string {
+	code, _, _ := strings.Cut(strings.ToLower(tm.LanguageCode(lang)), "-")
+	return code
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import "testing"
+
+func TestIsInTargetLanguage(t *testing.T) {
+	defer func(source, target string, skip bool) {
+		sourceLanguage, targetLanguage, skipTargetLanguage = source, target, skip
+	}(sourceLanguage, targetLanguage, skipTargetLanguage)
+	sourceLanguage, skipTargetLanguage = "English", true
+
+	german := "Der alte Mann ging zum Hafen hinunter und dachte an seinen Sohn."
+	english := "The old man walked down to the harbour and thought of his son."
+	for _, target := range []string{"German", "de", "de-AT"} {
+		targetLanguage = target
+		if !isInTargetLanguage(german) || isInTargetLanguage(english) {
+			t.Errorf("target %s: German %v, English %v", target, isInTargetLanguage(german), isInTargetLanguage(english))
+		}
+	}
+
+	// Translating between variants of a language skips nothing.
+	sourceLanguage, targetLanguage = "English", "en-GB"
+	if isInTargetLanguage(english) {
+		t.Error("English skipped when translating into British English")
+	}
+
+	sourceLanguage, targetLanguage, skipTargetLanguage = "English", "German", false
+	if isInTargetLanguage(german) {
+		t.Error("skipped with --skip-target-language=false")
+	}
+}
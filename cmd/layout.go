@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// Translation placements. Inline inserts the translation after the original,
+// which breaks the page geometry of fixed-layout books; popup and endnote keep
+// the page intact and link to the translation instead.
+const (
+	placementAuto    = "auto"
+	placementInline  = "inline"
+	placementPopup   = "popup"
+	placementEndnote = "endnote"
+
+	endnotesID       = "epubtrans-notes"
+	endnotesFileName = "epubtrans-notes.xhtml"
+	epubNamespace    = "http://www.idpf.org/2007/ops"
+)
+
+var (
+	// translationPlacement is one of the placement constants.
+	translationPlacement = placementAuto
+	// fixedLayoutPages holds the pre-paginated spine files of the book being translated.
+	fixedLayoutPages map[string]bool
+	// endnotes collects endnote placed translations of the book being translated.
+	endnotes *endnoteWriter
+)
+
+var spineCloseRegex = regexp.MustCompile(`</(?:opf:)?spine>`)
+
+// findFixedLayoutPages returns the pre-paginated spine files, honouring both the
+// book-wide rendition:layout and per-item overrides.
+func findFixedLayoutPages(unzipPath string) (map[string]bool, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bookFixed := false
+	for _, meta := range book.pkg.Metadata.Metas {
+		if meta.Property == "rendition:layout" && meta.Refines == "" {
+			bookFixed = strings.TrimSpace(meta.Content) == "pre-paginated"
+		}
+	}
+
+	pages := make(map[string]bool)
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil {
+			continue
+		}
+
+		fixed := bookFixed
+		properties := strings.Fields(ref.Properties)
+		for _, property := range properties {
+			switch property {
+			case "rendition:layout-pre-paginated":
+				fixed = true
+			case "rendition:layout-reflowable":
+				fixed = false
+			}
+		}
+
+		if fixed {
+			pages[filepath.Join(book.contentDir, item.Href)] = true
+		}
+	}
+
+	return pages, nil
+}
+
+// placementFor resolves the placement of translations in filePath.
+func placementFor(filePath string) string {
+	if translationPlacement != placementAuto {
+		return translationPlacement
+	}
+	if fixedLayoutPages[filePath] {
+		return placementPopup
+	}
+	return placementInline
+}
+
+// warnFixedLayout explains what changes for a fixed-layout book.
+func warnFixedLayout(pages int) {
+	fmt.Printf("Warning: %d pages use a fixed layout (pre-paginated).\n", pages)
+
+	switch translationPlacement {
+	case placementInline:
+		fmt.Println("Inline translations will be inserted anyway; they may overflow or overlap the page design.")
+	case placementEndnote:
+		fmt.Printf("Translations are collected in %s and linked from the original text.\n", endnotesFileName)
+	default:
+		fmt.Println("Translations are added as popup footnotes linked from the original text; the page itself keeps its layout.")
+		fmt.Println("Readers without popup footnote support show the notes at the end of each page, which may overflow it.")
+	}
+	fmt.Println("Text that is part of images is not translated, and hide/styling options do not apply to notes.")
+}
+
+// placeTranslation adds the translation of el according to the placement of filePath.
+func placeTranslation(doc *goquery.Document, el *goquery.Selection, filePath, targetLang, translatedContent string, origin provenance) error {
+	placement := placementFor(filePath)
+	if placement == placementInline || isSVGLabel(el) {
+		return manipulateHTML(el, targetLang, translatedContent, origin)
+	}
+
+	translatedElement, translationID, err := newTranslatedElement(el, targetLang, translatedContent, origin)
+	if err != nil {
+		return err
+	}
+
+	noteID := "epubtrans-note-" + translationID[:16]
+	noteHTML, err := goquery.OuterHtml(translatedElement)
+	if err != nil {
+		return err
+	}
+
+	ensureEpubNamespace(doc)
+
+	href := "#" + noteID
+	if placement == placementEndnote && endnotes != nil {
+		href, err = endnotes.add(filePath, noteID, noteHTML)
+		if err != nil {
+			return err
+		}
+	} else {
+		doc.Find("body").AppendHtml(fmt.Sprintf(`<aside epub:type="footnote" id="%s">%s</aside>`, noteID, noteHTML))
+	}
+
+	el.SetAttr(util.TranslationByIdKey, translationID)
+	el.AppendHtml(fmt.Sprintf(`<a epub:type="noteref" class="epubtrans-noteref" href="%s">*</a>`, href))
+
+	return nil
+}
+
+// endnoteWriter collects translations in a separate, reflowable notes document.
+// Chapters are translated concurrently, so notes are kept until flush and
+// written in reading order, whatever order the chapters finished in.
+type endnoteWriter struct {
+	mu      sync.Mutex
+	opfPath string
+	path    string
+	doc     *goquery.Document
+	// order is the reading order position of each chapter path.
+	order map[string]int
+	notes []endnote
+}
+
+type endnote struct {
+	chapterPath string
+	id          string
+	html        string
+}
+
+func newEndnoteWriter(unzipPath string) (*endnoteWriter, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	notesPath := filepath.Join(book.contentDir, endnotesFileName)
+
+	var doc *goquery.Document
+	if _, err := os.Stat(notesPath); err == nil {
+		doc, err = openAndReadFile(notesPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		doc, err = goquery.NewDocumentFromReader(strings.NewReader(fmt.Sprintf(
+			`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="%s"><head><meta charset="utf-8"/><title>Notes</title></head><body><section epub:type="endnotes"></section></body></html>`,
+			epubNamespace)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	order := make(map[string]int)
+	for i, item := range processor.ReadingOrder(book.pkg) {
+		order[filepath.Join(book.contentDir, item.Href)] = i
+	}
+
+	return &endnoteWriter{opfPath: book.opfPath, path: notesPath, doc: doc, order: order}, nil
+}
+
+// add stores a note and returns the href that links to it from chapterPath.
+func (w *endnoteWriter) add(chapterPath, noteID, noteHTML string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.notes = append(w.notes, endnote{chapterPath: chapterPath, id: noteID, html: noteHTML})
+
+	rel, err := filepath.Rel(filepath.Dir(chapterPath), w.path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel) + "#" + noteID, nil
+}
+
+// flush writes the notes document and adds it to the end of the spine.
+func (w *endnoteWriter) flush() error {
+	if len(w.notes) == 0 {
+		return nil
+	}
+
+	// Notes of one chapter were added in document order.
+	sort.SliceStable(w.notes, func(i, j int) bool {
+		return w.order[w.notes[i].chapterPath] < w.order[w.notes[j].chapterPath]
+	})
+	section := w.doc.Find("section").First()
+	for _, note := range w.notes {
+		section.AppendHtml(fmt.Sprintf(`<aside epub:type="endnote" id="%s">%s</aside>`, note.id, note.html))
+	}
+
+	if err := writeContentToFile(w.path, w.doc); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(w.opfPath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", w.opfPath, err)
+	}
+
+	opf := string(content)
+	if strings.Contains(opf, fmt.Sprintf(`id="%s"`, endnotesID)) {
+		return nil
+	}
+
+	loc := manifestCloseRegex.FindStringIndex(opf)
+	if loc == nil {
+		return fmt.Errorf("no manifest found in %s", w.opfPath)
+	}
+	item := fmt.Sprintf(`<item id="%s" href="%s" media-type="application/xhtml+xml"/>`+"\n", endnotesID, endnotesFileName)
+	opf = opf[:loc[0]] + item + opf[loc[0]:]
+
+	loc = spineCloseRegex.FindStringIndex(opf)
+	if loc == nil {
+		return fmt.Errorf("no spine found in %s", w.opfPath)
+	}
+	// The notes flow like a regular chapter even in a fixed-layout book.
+	itemRef := fmt.Sprintf(`<itemref idref="%s" properties="rendition:layout-reflowable"/>`+"\n", endnotesID)
+	opf = opf[:loc[0]] + itemRef + opf[loc[0]:]
+
+	return writeBookFile(w.opfPath, []byte(opf))
+}
+
+func ensureEpubNamespace(doc *goquery.Document) {
+	root := doc.Find("html")
+	if _, ok := root.Attr("xmlns:epub"); !ok {
+		root.SetAttr("xmlns:epub", epubNamespace)
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLayoutBook writes a book of three pages with the metadata and the
+// spine given.
+func writeLayoutBook(t *testing.T, metadata, spine string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Layout")
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Layout</dc:title>` + metadata + `</metadata>
+  <manifest>
+    <item id="p1" href="p1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="p2" href="p2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="p3" href="p3.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>` + spine + `</spine>
+</package>`
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "content.opf"), []byte(opf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFindFixedLayoutPages(t *testing.T) {
+	tests := []struct {
+		name, metadata, spine string
+		want                  []string
+	}{
+		{
+			name:  "reflowable book",
+			spine: `<itemref idref="p1"/><itemref idref="p2"/><itemref idref="p3"/>`,
+		},
+		{
+			name:     "fixed-layout book",
+			metadata: `<meta property="rendition:layout">pre-paginated</meta>`,
+			spine:    `<itemref idref="p1"/><itemref idref="p2"/><itemref idref="p3"/>`,
+			want:     []string{"p1.xhtml", "p2.xhtml", "p3.xhtml"},
+		},
+		{
+			name:     "fixed-layout book with a reflowable page",
+			metadata: `<meta property="rendition:layout">pre-paginated</meta>`,
+			spine:    `<itemref idref="p1"/><itemref idref="p2" properties="page-spread-left rendition:layout-reflowable"/><itemref idref="p3"/>`,
+			want:     []string{"p1.xhtml", "p3.xhtml"},
+		},
+		{
+			name:  "reflowable book with a fixed page",
+			spine: `<itemref idref="p1" properties="rendition:layout-pre-paginated"/><itemref idref="p2"/><itemref idref="p3"/>`,
+			want:  []string{"p1.xhtml"},
+		},
+		{
+			name:     "layout of another item",
+			metadata: `<meta property="rendition:layout" refines="#p2">pre-paginated</meta>`,
+			spine:    `<itemref idref="p1"/><itemref idref="p2"/><itemref idref="p3"/>`,
+		},
+	}
+	for _, tt := range tests {
+		dir := writeLayoutBook(t, tt.metadata, tt.spine)
+		pages, err := findFixedLayoutPages(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != len(tt.want) {
+			t.Errorf("%s: fixed pages = %v, want %v", tt.name, pages, tt.want)
+			continue
+		}
+		for _, page := range tt.want {
+			if !pages[filepath.Join(dir, "OEBPS", page)] {
+				t.Errorf("%s: fixed pages = %v, want %v", tt.name, pages, tt.want)
+			}
+		}
+	}
+}
+
+func TestPlacementFor(t *testing.T) {
+	defer func(placement string, pages map[string]bool) {
+		translationPlacement, fixedLayoutPages = placement, pages
+	}(translationPlacement, fixedLayoutPages)
+	fixedLayoutPages = map[string]bool{"/book/OEBPS/p1.xhtml": true}
+
+	tests := []struct {
+		placement, filePath, want string
+	}{
+		{placementAuto, "/book/OEBPS/p1.xhtml", placementPopup},
+		{placementAuto, "/book/OEBPS/p2.xhtml", placementInline},
+		{placementInline, "/book/OEBPS/p1.xhtml", placementInline},
+		{placementEndnote, "/book/OEBPS/p1.xhtml", placementEndnote},
+		{placementPopup, "/book/OEBPS/p2.xhtml", placementPopup},
+	}
+	for _, tt := range tests {
+		translationPlacement = tt.placement
+		if got := placementFor(tt.filePath); got != tt.want {
+			t.Errorf("placementFor(%s) with --placement %s = %s, want %s", tt.filePath, tt.placement, got, tt.want)
+		}
+	}
+}
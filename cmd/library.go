@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/gofiber/fiber/v2"
+)
+
+// bookBaseKey is the local holding the path a book is served under.
+const bookBaseKey = "epubtrans-book-base"
+
+// servedBook is a book of a serve instance. With several books, each is
+// served under /books/<id>; a single book is served at the root.
+type servedBook struct {
+	ID    string
+	Path  string
+	Title string
+	// Base is the path the book is served under, "" at the root.
+	Base string
+}
+
+// serveBooks returns the books given on the command line and those in the
+// subdirectories of library that are unpacked EPUBs.
+func serveBooks(args []string, library string) ([]servedBook, error) {
+	paths := append([]string{}, args...)
+	if library != "" {
+		entries, err := os.ReadDir(library)
+		if err != nil {
+			return nil, fmt.Errorf("reading library: %w", err)
+		}
+		for _, e := range entries {
+			dir := filepath.Join(library, e.Name())
+			// Skip the files kept next to the books, such as <book>-jobs.
+			if _, err := os.Stat(filepath.Join(dir, "META-INF", "container.xml")); e.IsDir() && err == nil {
+				paths = append(paths, dir)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no unpacked books found in %s", library)
+	}
+
+	var books []servedBook
+	used := make(map[string]bool)
+	for _, p := range paths {
+		container, err := loader.ParseContainer(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		pkg, err := loader.ParsePackage(filepath.Join(p, container.Rootfile.FullPath))
+		if err != nil {
+			return nil, fmt.Errorf("%s: error parsing package: %v", p, err)
+		}
+
+		id := bookID(filepath.Base(filepath.Clean(p)))
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", bookID(filepath.Base(filepath.Clean(p))), n)
+		}
+		used[id] = true
+
+		books = append(books, servedBook{ID: id, Path: p, Title: pkg.Metadata.Title})
+	}
+
+	if len(books) > 1 || library != "" {
+		for i := range books {
+			books[i].Base = "/books/" + books[i].ID
+		}
+	}
+	return books, nil
+}
+
+// bookID turns the directory name of a book into the id in its URLs.
+func bookID(name string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	for strings.Contains(id, "--") {
+		id = strings.ReplaceAll(id, "--", "-")
+	}
+	if id = strings.Trim(id, "-"); id == "" {
+		return "book"
+	}
+	return id
+}
+
+// bookBase returns the path the book of the request is served under, "" for a
+// book served at the root. Pages prefix their links with it.
+func bookBase(c *fiber.Ctx) string {
+	base, _ := c.Locals(bookBaseKey).(string)
+	return base
+}
+
+// withBookBase tells the handlers of the book served under base their base.
+func withBookBase(base string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Use matches by prefix, so /books/a also sees /books/ab.
+		if p := c.Path(); p == base || strings.HasPrefix(p, base+"/") {
+			c.Locals(bookBaseKey, base)
+		}
+		return c.Next()
+	}
+}
+
+// registerLibraryPage adds the root page, the list of the books served with their
+// translation progress.
+func registerLibraryPage(app *fiber.App, books []servedBook) {
+	app.Get(serveBasePath+"/", func(c *fiber.Ctx) error {
+		var rows strings.Builder
+		for _, book := range books {
+			progress := "?"
+			if translated, total, err := translationProgress(book.Path); err == nil {
+				progress = fmt.Sprintf(`<meter min="0" max="100" low="50" high="99" optimum="100" value="%d"></meter> %d%%`, percentOf(translated, total), percentOf(translated, total))
+			}
+			title := book.Title
+			if title == "" {
+				title = book.ID
+			}
+			base := html.EscapeString(book.Base)
+			fmt.Fprintf(&rows, `<tr><td><a href="%s/toc.html">%s</a></td><td>%s</td><td><a href="%s/progress">Progress</a> · <a href="%s/review">Review</a></td></tr>`,
+				base, html.EscapeString(title), progress, base, base)
+		}
+
+		c.Set("Content-Type", "text/html")
+		return c.SendString(fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Library</title>
+    <link rel="stylesheet" href="%s">
+    <script src="%s"></script>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; max-width: 900px; margin: 0 auto; padding: 16px; }
+        table { width: 100%%; border-collapse: collapse; }
+        td, th { padding: 4px 8px; text-align: left; border-bottom: 1px solid var(--epubtrans-border-subtle); }
+        meter { width: 160px; }
+    </style>
+</head>
+<body>
+    <h1>Library</h1>
+    <table>
+        <tr><th>Book</th><th>Translated</th><th></th></tr>
+        %s
+    </table>
+    <script>addThemeToggle(document.body);</script>
+</body>
+</html>
+`, assetURL("theme.css"), assetURL("theme.js"), rows.String()))
+	})
+}
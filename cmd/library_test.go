@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLibraryBook writes the container and the package of an unpacked book.
+func writeLibraryBook(t *testing.T, dir, title string) {
+	t.Helper()
+	files := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>` + title + `</dc:title></metadata>
+</package>`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServeBooks(t *testing.T) {
+	library := t.TempDir()
+	writeLibraryBook(t, filepath.Join(library, "Alice in Wonderland"), "Alice")
+	writeLibraryBook(t, filepath.Join(library, "alice_in_wonderland"), "Alice again")
+	// The files kept next to a book are not books.
+	if err := os.MkdirAll(filepath.Join(library, "Alice in Wonderland-edits"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("single book", func(t *testing.T) {
+		books, err := serveBooks([]string{filepath.Join(library, "Alice in Wonderland")}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].Base != "" || books[0].Title != "Alice" {
+			t.Errorf("serveBooks() = %+v, want one book at the root", books)
+		}
+	})
+
+	t.Run("library", func(t *testing.T) {
+		books, err := serveBooks(nil, library)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 2 {
+			t.Fatalf("serveBooks() = %+v, want 2 books", books)
+		}
+		if books[0].Base != "/books/alice-in-wonderland" || books[1].Base != "/books/alice-in-wonderland-2" {
+			t.Errorf("bases = %q, %q", books[0].Base, books[1].Base)
+		}
+	})
+
+	t.Run("empty library", func(t *testing.T) {
+		if _, err := serveBooks(nil, t.TempDir()); err == nil {
+			t.Error("serveBooks() of an empty library succeeded")
+		}
+	})
+}
+
+func TestBookID(t *testing.T) {
+	tests := map[string]string{
+		"Moby Dick":        "moby-dick",
+		"war_and_peace-vi": "war-and-peace-vi",
+		"  --":             "book",
+		"Book (2)":         "book-2",
+	}
+	for name, want := range tests {
+		if got := bookID(name); got != want {
+			t.Errorf("bookID(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
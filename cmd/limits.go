@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serveLimits protect serve against large requests, slow clients and AI
+// translations piling up.
+var serveLimits = struct {
+	bodyLimit    int
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	// aiTimeout bounds a single AI translation.
+	aiTimeout time.Duration
+	// maxAIRequests bounds the AI translations running at once.
+	maxAIRequests int
+}{}
+
+// aiSlots holds one token per running AI translation.
+var aiSlots chan struct{}
+
+func init() {
+	Serve.Flags().IntVar(&serveLimits.bodyLimit, "body-limit", 1<<20, "maximum request body size in bytes")
+	Serve.Flags().DurationVar(&serveLimits.readTimeout, "read-timeout", 10*time.Second, "maximum time to read a request, including its body")
+	Serve.Flags().DurationVar(&serveLimits.writeTimeout, "write-timeout", 30*time.Second, "maximum time to write a response, or between the events of a streamed one")
+	Serve.Flags().DurationVar(&serveLimits.idleTimeout, "idle-timeout", time.Minute, "maximum time to keep an idle connection open")
+	Serve.Flags().DurationVar(&serveLimits.aiTimeout, "ai-timeout", 2*time.Minute, "maximum time for one AI translation")
+	Serve.Flags().IntVar(&serveLimits.maxAIRequests, "max-ai-requests", 2, "maximum number of AI translations running at once; further requests are refused")
+}
+
+// serveConfig returns the Fiber configuration enforcing serveLimits.
+func serveConfig() fiber.Config {
+	return fiber.Config{
+		DisableStartupMessage: true,
+		BodyLimit:             serveLimits.bodyLimit,
+		ReadTimeout:           serveLimits.readTimeout,
+		WriteTimeout:          serveLimits.writeTimeout,
+		IdleTimeout:           serveLimits.idleTimeout,
+	}
+}
+
+// streamFlusher returns the flush for the body stream writer of the request
+// c. Every flush gives the connection another --write-timeout, so that a
+// stream outlasting the timeout is not cut off while it keeps sending.
+func streamFlusher(c *fiber.Ctx) func(w *bufio.Writer) error {
+	// The request context must not be used from the stream writer.
+	conn := c.Context().Conn()
+	return func(w *bufio.Writer) error {
+		if serveLimits.writeTimeout > 0 {
+			if err := conn.SetWriteDeadline(time.Now().Add(serveLimits.writeTimeout)); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+}
+
+// acquireAISlot reserves a slot for an AI translation without waiting; the
+// returned release must be called when it is done.
+func acquireAISlot() (release func(), ok bool) {
+	select {
+	case aiSlots <- struct{}{}:
+		return func() { <-aiSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+// waitAISlot waits for a slot for an AI translation, for queued work that can
+// wait for the translations requested meanwhile.
+func waitAISlot(ctx context.Context) (release func(), err error) {
+	select {
+	case aiSlots <- struct{}{}:
+		return func() { <-aiSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStreamOutlastsWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { serveLimits.writeTimeout = timeout }(serveLimits.writeTimeout)
+	serveLimits.writeTimeout = 100 * time.Millisecond
+
+	app := fiber.New(serveConfig())
+	app.Get("/stream", func(c *fiber.Ctx) error {
+		flush := streamFlusher(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			for i := 0; i < 4; i++ {
+				time.Sleep(60 * time.Millisecond)
+				writeEvent(w, "delta", fiber.Map{"n": i})
+				if flush(w) != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if n := strings.Count(string(body), "event: delta"); n != 4 {
+		t.Errorf("received %d of 4 events of a stream outlasting the write timeout:\n%s", n, body)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	defer func(limit int) { serveLimits.bodyLimit = limit }(serveLimits.bodyLimit)
+	serveLimits.bodyLimit = 1024
+
+	app := fiber.New(serveConfig())
+	app.Post("/segments", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	for size, want := range map[int]int{512: fiber.StatusOK, 4096: fiber.StatusRequestEntityTooLarge} {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/segments", "text/plain", strings.NewReader(strings.Repeat("a", size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("body of %d bytes: status %d, want %d", size, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestAISlots(t *testing.T) {
+	defer func(slots chan struct{}) { aiSlots = slots }(aiSlots)
+	aiSlots = make(chan struct{}, 2)
+
+	releaseFirst, ok := acquireAISlot()
+	if !ok {
+		t.Fatal("first AI translation refused")
+	}
+	releaseSecond, ok := acquireAISlot()
+	if !ok {
+		t.Fatal("second AI translation refused")
+	}
+	if _, ok := acquireAISlot(); ok {
+		t.Error("third AI translation accepted beyond --max-ai-requests 2")
+	}
+
+	// Queued work waits for a slot, or gives up with its context.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := waitAISlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitAISlot() with every slot taken = %v, want the deadline", err)
+	}
+
+	waited := make(chan func())
+	go func() {
+		release, err := waitAISlot(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		waited <- release
+	}()
+	releaseFirst()
+	select {
+	case release := <-waited:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("waitAISlot() did not get the released slot")
+	}
+
+	releaseSecond()
+	if len(aiSlots) != 0 {
+		t.Errorf("%d slots still taken after every release", len(aiSlots))
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// unixSocketPrefix marks a --host that is the path of a Unix socket.
+const unixSocketPrefix = "unix:"
+
+var (
+	// serveHost is the address serve listens on; "" listens on every
+	// interface.
+	serveHost string
+	// serveBasePath is the path serve is reached under behind a reverse proxy,
+	// such as /epubtrans; "" serves at the root.
+	serveBasePath string
+	// shutdownTimeout bounds the wait for requests and AI translations in
+	// flight when serve is interrupted.
+	shutdownTimeout time.Duration
+)
+
+func init() {
+	Serve.Flags().StringVar(&serveHost, "host", "", "address to listen on, such as 127.0.0.1 or 0.0.0.0, or unix:/path/to.sock for a Unix socket; every interface by default")
+	Serve.Flags().StringVar(&serveBasePath, "base-path", "", "path serve is reached under behind a reverse proxy, such as /epubtrans; the proxy must pass it on")
+	Serve.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 2*time.Minute, "maximum time to wait on Ctrl+C or SIGTERM for requests and AI translations in flight")
+}
+
+// cleanBasePath validates a --base-path and returns it without a trailing
+// slash, "" for the root.
+func cleanBasePath(basePath string) (string, error) {
+	if basePath == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(basePath, "/") || strings.ContainsAny(basePath, "?#\"'<> ") {
+		return "", fmt.Errorf("invalid --base-path %q: it must be a path such as /epubtrans", basePath)
+	}
+	if basePath = path.Clean(basePath); basePath == "/" {
+		return "", nil
+	}
+	return basePath, nil
+}
+
+// assetURL returns the URL of an asset of the pages, under the base path.
+func assetURL(name string) string {
+	return serveBasePath + "/assets/" + name
+}
+
+// serveAddr returns the address serve listens on for --host and port.
+func serveAddr(host, port string) string {
+	if strings.HasPrefix(host, unixSocketPrefix) {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// serveOrigin returns the origin to print the URLs of serve with, "" when it
+// listens on a Unix socket, which only the reverse proxy reaches.
+func serveOrigin(host, port string) string {
+	switch {
+	case strings.HasPrefix(host, unixSocketPrefix):
+		return ""
+	case host == "" || host == "0.0.0.0" || host == "::":
+		host = "localhost"
+	}
+	return serveScheme() + "://" + net.JoinHostPort(host, port)
+}
+
+// listenUnixSocket serves app on the Unix socket at socketPath. A socket
+// left by an earlier run is removed first, as it keeps the socket from being
+// created.
+func listenUnixSocket(app *fiber.App, socketPath string) error {
+	if fi, err := os.Lstat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	return app.Listener(ln)
+}
+
+// serveUntilSignal serves app on addr until it fails or serve is interrupted.
+// On SIGINT or SIGTERM it stops taking requests and batches, waits for the
+// requests and the segments of AI batches in flight, and takes a last backup
+// of the books. A second signal ends serve at once.
+func serveUntilSignal(app *fiber.App, addr, certPrefix string, books []servedBook) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backups, err := startBackups(ctx, books)
+	if err != nil {
+		return err
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- listenServe(app, addr, certPrefix)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down, waiting for requests and AI translations in flight", "timeout", shutdownTimeout)
+	stopped := make(chan bool, 1)
+	go func() {
+		stopped <- stopBatchQueues(shutdownTimeout)
+	}()
+	// Pages listening for changes hold their connections open.
+	closeLiveReloads()
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		slog.Warn("Requests still in flight were cut off", "error", err)
+	}
+	if !<-stopped {
+		slog.Warn("AI batches still translating were abandoned")
+	}
+
+	backups.takeAll()
+	return nil
+}
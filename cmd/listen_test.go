@@ -0,0 +1,46 @@
+package cmd
+
+import "testing"
+
+func TestCleanBasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		want     string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"/epubtrans", "/epubtrans", false},
+		{"/books/epubtrans/", "/books/epubtrans", false},
+		{"epubtrans", "", true},
+		{"/epub\"trans", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := cleanBasePath(tt.basePath)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("cleanBasePath(%q) = %q, %v; want %q, error %v", tt.basePath, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestServeAddr(t *testing.T) {
+	tests := []struct {
+		host, addr, origin string
+	}{
+		{"", ":3000", "http://localhost:3000"},
+		{"0.0.0.0", "0.0.0.0:3000", "http://localhost:3000"},
+		{"192.168.1.5", "192.168.1.5:3000", "http://192.168.1.5:3000"},
+		{"::1", "[::1]:3000", "http://[::1]:3000"},
+		{"unix:/run/epubtrans.sock", "unix:/run/epubtrans.sock", ""},
+	}
+
+	for _, tt := range tests {
+		if got := serveAddr(tt.host, "3000"); got != tt.addr {
+			t.Errorf("serveAddr(%q) = %q, want %q", tt.host, got, tt.addr)
+		}
+		if got := serveOrigin(tt.host, "3000"); got != tt.origin {
+			t.Errorf("serveOrigin(%q) = %q, want %q", tt.host, got, tt.origin)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pages of serve listen on a WebSocket for changes of the files of the book
+// made by other programs, such as translate running meanwhile, and reload
+// themselves. The content directory is watched with fsnotify; the writes of
+// serve itself are left out, as the page made them.
+
+// liveReloadSettle is how long the changed files must be left alone before
+// they are reported, so files are reported once they are written completely.
+var liveReloadSettle = 300 * time.Millisecond
+
+const (
+	// liveReloadPing is how often a listening page is pinged, which finds
+	// closed connections.
+	liveReloadPing = 30 * time.Second
+	// maxWebSocketPayload bounds the messages pages send, which are only
+	// control frames.
+	maxWebSocketPayload = 1 << 16
+)
+
+// liveReloadEvent is the message a page gets when files change.
+type liveReloadEvent struct {
+	// Type is "changed".
+	Type string `json:"type"`
+	// Files are the changed files, by path in the content directory.
+	Files []string `json:"files"`
+}
+
+// liveReload watches the content directory of a book for the pages
+// listening to it.
+type liveReload struct {
+	mu         sync.Mutex
+	bookPath   string
+	contentDir string
+	listeners  map[chan []string]bool
+	watcher    *fsnotify.Watcher
+	// pending are the files changed since the last report, which are
+	// reported when none changed for liveReloadSettle.
+	pending map[string]bool
+	settle  *time.Timer
+	closed  bool
+}
+
+// liveReloads are the watchers of the served books, closed on shutdown.
+var liveReloads struct {
+	sync.Mutex
+	all []*liveReload
+}
+
+func newLiveReload(unpackedEpubPath, contentDirPath string) *liveReload {
+	lr := &liveReload{bookPath: unpackedEpubPath, contentDir: contentDirPath, listeners: map[chan []string]bool{}, pending: map[string]bool{}}
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		lr.watcher = watcher
+		err = lr.watchTree(contentDirPath)
+		go lr.watch()
+	}
+	if err != nil {
+		slog.Warn("Watching the book for changes failed; pages will not reload themselves", "book", unpackedEpubPath, "error", err)
+	}
+
+	liveReloads.Lock()
+	liveReloads.all = append(liveReloads.all, lr)
+	liveReloads.Unlock()
+	return lr
+}
+
+// closeLiveReloads disconnects the listening pages of all books.
+func closeLiveReloads() {
+	liveReloads.Lock()
+	defer liveReloads.Unlock()
+	for _, lr := range liveReloads.all {
+		lr.close()
+	}
+}
+
+func (lr *liveReload) close() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed {
+		return
+	}
+	lr.closed = true
+	if lr.watcher != nil {
+		lr.watcher.Close()
+	}
+	if lr.settle != nil {
+		lr.settle.Stop()
+	}
+	for ch := range lr.listeners {
+		close(ch)
+		delete(lr.listeners, ch)
+	}
+}
+
+// subscribe returns a channel getting the changed files, which is closed
+// when serve shuts down. It returns nil after shutdown.
+func (lr *liveReload) subscribe() chan []string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed {
+		return nil
+	}
+	ch := make(chan []string, 8)
+	lr.listeners[ch] = true
+	return ch
+}
+
+func (lr *liveReload) unsubscribe(ch chan []string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.listeners[ch] {
+		delete(lr.listeners, ch)
+		close(ch)
+	}
+}
+
+// watchTree watches dir and the directories below it, as fsnotify does not
+// watch recursively.
+func (lr *liveReload) watchTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return lr.watcher.Add(p)
+	})
+}
+
+func (lr *liveReload) watch() {
+	for {
+		select {
+		case event, ok := <-lr.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := lr.watchTree(event.Name); err != nil {
+						slog.Warn("Watching a new folder of the book failed", "book", lr.bookPath, "error", err)
+					}
+					continue
+				}
+			}
+			if rel, err := filepath.Rel(lr.contentDir, event.Name); err == nil {
+				lr.changed(filepath.ToSlash(rel))
+			}
+		case err, ok := <-lr.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Watching the book for changes failed", "book", lr.bookPath, "error", err)
+		}
+	}
+}
+
+// changed notes a change of file, while pages listen, and reports the
+// changes once they settle.
+func (lr *liveReload) changed(file string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed || len(lr.listeners) == 0 {
+		return
+	}
+	lr.pending[file] = true
+	if lr.settle == nil {
+		lr.settle = time.AfterFunc(liveReloadSettle, lr.report)
+	} else {
+		lr.settle.Reset(liveReloadSettle)
+	}
+}
+
+func (lr *liveReload) report() {
+	lr.mu.Lock()
+	pending := lr.pending
+	lr.pending = map[string]bool{}
+	lr.mu.Unlock()
+
+	changed, err := lr.others(pending)
+	if err != nil {
+		slog.Warn("Reading the writes of serve failed", "book", lr.bookPath, "error", err)
+		return
+	}
+	if len(changed) > 0 {
+		lr.broadcast(changed)
+	}
+}
+
+func (lr *liveReload) broadcast(changed []string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.listeners {
+		select {
+		case ch <- changed:
+		default:
+			// The page is behind; it reloads for the events it got.
+		}
+	}
+}
+
+// others returns the changed files other than those serve wrote, in order.
+func (lr *liveReload) others(changed map[string]bool) ([]string, error) {
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	writes, err := lastWrites(lr.bookPath)
+	if err != nil {
+		return nil, err
+	}
+	contentRel, err := filepath.Rel(lr.bookPath, lr.contentDir)
+	if err != nil {
+		return nil, err
+	}
+	var others []string
+	for file := range changed {
+		if !lr.ownWrite(file, writes[path.Join(filepath.ToSlash(contentRel), file)]) {
+			others = append(others, file)
+		}
+	}
+	sort.Strings(others)
+	return others, nil
+}
+
+// ownWrite reports whether file is as serve last wrote it.
+func (lr *liveReload) ownWrite(file string, last fileWrite) bool {
+	if last.By != writeSource {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(lr.contentDir, filepath.FromSlash(file)))
+	return err == nil && contentHash(data) == last.SHA256
+}
+
+// serveConn pushes the changes to a page until it goes or serve shuts down.
+func (lr *liveReload) serveConn(conn *websocket.Conn) {
+	ch := lr.subscribe()
+	if ch == nil {
+		return
+	}
+	defer lr.unsubscribe(ch)
+
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteMessage(messageType, data)
+	}
+
+	// Pages send nothing but control frames, which the connection answers.
+	conn.SetReadLimit(maxWebSocketPayload)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(liveReloadPing)
+	defer ping.Stop()
+	for {
+		select {
+		case files, ok := <-ch:
+			if !ok {
+				write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			message, err := json.Marshal(liveReloadEvent{Type: "changed", Files: files})
+			if err != nil || write(websocket.TextMessage, message) != nil {
+				return
+			}
+		case <-ping.C:
+			if write(websocket.PingMessage, nil) != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// registerLiveReload adds the WebSocket pages listen on for changed files.
+// Pages of other origins than serve and trusted are turned away, as the
+// browser lets any page open a WebSocket.
+func registerLiveReload(api fiber.Router, lr *liveReload, trusted []string) {
+	api.Get("/live", func(c *fiber.Ctx) error {
+		if origin := c.Get(fiber.HeaderOrigin); origin != "" && !sameOrigin(origin, c.BaseURL(), trusted) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cross-origin request rejected"})
+		}
+		if !websocket.IsWebSocketUpgrade(c) {
+			return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "Connect with a WebSocket"})
+		}
+		return c.Next()
+	}, websocket.New(lr.serveConn))
+}
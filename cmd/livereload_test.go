@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLiveReload(t *testing.T) {
+	defer func(settle time.Duration) { liveReloadSettle = settle }(liveReloadSettle)
+	liveReloadSettle = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+	contentDir := filepath.Join(dir, "OEBPS")
+	lr := newLiveReload(dir, contentDir)
+	defer lr.close()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	registerLiveReload(app.Group(apiV1), lr, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+	url := "ws://" + ln.Addr().String() + apiV1 + "/live"
+
+	// Pages of other sites may not listen.
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("connecting from another origin = %v, %v; want 403", resp, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://" + ln.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	// Wait for the page to be subscribed.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		lr.mu.Lock()
+		listening := len(lr.listeners) > 0
+		lr.mu.Unlock()
+		if listening || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	// What serve writes itself is not sent.
+	if err := writeBookFile(filepath.Join(contentDir, "ch1.xhtml"), []byte(testSyncChapter+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * liveReloadSettle)
+	if err := os.WriteFile(filepath.Join(contentDir, "style.css"), []byte("p { color: red }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var event liveReloadEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "changed" || !slices.Equal(event.Files, []string{"style.css"}) {
+		t.Errorf("event = %+v, want style.css changed", event)
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Kinds of segment locks.
+const (
+	// lockAI is held by a batch on the segments it is going to translate.
+	lockAI = "ai"
+	// lockEdit is held by a page on the translation being edited in it.
+	lockEdit = "edit"
+)
+
+// editLockTTL is how long an edit lock lasts without being renewed; the page
+// renews it while the translation has the focus, so a closed tab does not
+// keep the segment locked.
+const editLockTTL = 2 * time.Minute
+
+// segmentLock keeps others from changing a segment: an AI batch locks the
+// segments it is going to translate against manual edits, and the editor
+// locks the translation being edited against AI batches, so neither
+// overwrites the work of the other.
+type segmentLock struct {
+	FilePath  string `json:"file_path"`
+	ContentID string `json:"content_id"`
+	Kind      string `json:"kind"`
+	// Holder is "batch <id>" for AI locks and a token of the page for edit
+	// locks.
+	Holder string `json:"holder"`
+	// Expires is set for edit locks.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (l segmentLock) expired(now time.Time) bool {
+	return l.Expires != nil && now.After(*l.Expires)
+}
+
+type segmentKey struct {
+	href      string
+	contentID string
+}
+
+// segmentLocks holds the locks of the segments of the served book, in memory:
+// they only matter while serve runs.
+type segmentLocks struct {
+	mu    sync.Mutex
+	locks map[segmentKey]segmentLock
+}
+
+func newSegmentLocks() *segmentLocks {
+	return &segmentLocks{locks: make(map[segmentKey]segmentLock)}
+}
+
+// lock takes the lock of a segment for holder, or renews it if holder has it
+// already. It returns the lock and whether holder has it; if not, the lock
+// is the one held by another.
+func (l *segmentLocks) lock(href, contentID, kind, holder string) (segmentLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	key := segmentKey{href, contentID}
+	if held, ok := l.locks[key]; ok && !held.expired(now) && held.Holder != holder {
+		return held, false
+	}
+
+	lock := segmentLock{FilePath: "/" + href, ContentID: contentID, Kind: kind, Holder: holder}
+	if kind == lockEdit {
+		expires := now.Add(editLockTTL)
+		lock.Expires = &expires
+	}
+	l.locks[key] = lock
+	return lock, true
+}
+
+// unlock releases the lock of a segment if holder has it.
+func (l *segmentLocks) unlock(href, contentID, holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := segmentKey{href, contentID}
+	if l.locks[key].Holder == holder {
+		delete(l.locks, key)
+	}
+}
+
+// unlockAll releases every lock holder has.
+func (l *segmentLocks) unlockAll(holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, lock := range l.locks {
+		if lock.Holder == holder {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// held returns the lock of a segment, if it is locked.
+func (l *segmentLocks) held(href, contentID string) (segmentLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[segmentKey{href, contentID}]
+	if !ok || lock.expired(time.Now()) {
+		return segmentLock{}, false
+	}
+	return lock, true
+}
+
+// inFile returns the locks of the segments of href, or of every file if href
+// is empty.
+func (l *segmentLocks) inFile(href string) []segmentLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	locks := []segmentLock{}
+	for key, lock := range l.locks {
+		if lock.expired(now) {
+			delete(l.locks, key)
+			continue
+		}
+		if href == "" || key.href == href {
+			locks = append(locks, lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].FilePath != locks[j].FilePath {
+			return locks[i].FilePath < locks[j].FilePath
+		}
+		return locks[i].ContentID < locks[j].ContentID
+	})
+	return locks
+}
+
+// batchLockHolder is the holder of the locks of a batch.
+func batchLockHolder(batchID string) string {
+	return "batch " + batchID
+}
+
+// originalContentID returns the content id of the original translated by
+// translationID, "" if the original is not in doc.
+func originalContentID(doc *goquery.Document, translationID string) string {
+	return doc.Find(fmt.Sprintf("[%s]", util.TranslationByIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.AttrOr(util.TranslationByIdKey, "") == translationID
+	}).First().AttrOr(util.ContentIdKey, "")
+}
+
+// rejectAILocked answers 423 Locked, and reports true, when an AI batch is
+// about to translate the segment translated by translationID.
+func rejectAILocked(c *fiber.Ctx, locks *segmentLocks, href string, doc *goquery.Document, translationID string) bool {
+	lock, ok := locks.held(href, originalContentID(doc, translationID))
+	if !ok || lock.Kind != lockAI {
+		return false
+	}
+	c.Status(http.StatusLocked).JSON(fiber.Map{"error": "The segment is being translated by AI " + lock.Holder, "lock": lock})
+	return true
+}
+
+// lockedMessage tells who holds the lock of a segment.
+func lockedMessage(lock segmentLock) string {
+	if lock.Kind == lockAI {
+		return "The segment is being translated by AI " + lock.Holder
+	}
+	return "The segment is being edited elsewhere"
+}
+
+type SegmentLockRequest struct {
+	FilePath  string `json:"file_path"`
+	ContentID string `json:"content_id"`
+	// Holder identifies the page holding the lock.
+	Holder string `json:"holder"`
+}
+
+// registerLockAPI adds the listing of the locked segments of a file and the
+// edit locks of the editor.
+func registerLockAPI(api fiber.Router, locks *segmentLocks) {
+	api.Get("/segment-locks", func(c *fiber.Ctx) error {
+		href := ""
+		if c.Query("file_path") != "" {
+			href = editHref(c.Query("file_path"))
+		}
+		return c.JSON(locks.inFile(href))
+	})
+
+	api.Post("/segment-lock", func(c *fiber.Ctx) error {
+		var req SegmentLockRequest
+		if err := c.BodyParser(&req); err != nil || req.FilePath == "" || req.ContentID == "" || req.Holder == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		lock, ok := locks.lock(editHref(req.FilePath), req.ContentID, lockEdit, req.Holder)
+		if !ok {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{"error": lockedMessage(lock), "lock": lock})
+		}
+		return c.JSON(lock)
+	})
+
+	api.Delete("/segment-lock", func(c *fiber.Ctx) error {
+		var req SegmentLockRequest
+		if err := c.BodyParser(&req); err != nil || req.FilePath == "" || req.ContentID == "" || req.Holder == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		locks.unlock(editHref(req.FilePath), req.ContentID, req.Holder)
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSegmentLocks(t *testing.T) {
+	locks := newSegmentLocks()
+	batch := batchLockHolder("1")
+
+	if _, ok := locks.lock("Text/ch1.xhtml", "a", lockAI, batch); !ok {
+		t.Fatal("lock of a free segment failed")
+	}
+	held, ok := locks.lock("Text/ch1.xhtml", "a", lockEdit, "page")
+	if ok || held.Kind != lockAI || held.Holder != batch {
+		t.Errorf("editor locked a segment of a batch: %+v, %v", held, ok)
+	}
+	if _, ok := locks.lock("Text/ch1.xhtml", "a", lockAI, batch); !ok {
+		t.Error("holder could not renew its lock")
+	}
+
+	// Only the holder releases a lock.
+	locks.unlock("Text/ch1.xhtml", "a", "page")
+	if _, ok := locks.held("Text/ch1.xhtml", "a"); !ok {
+		t.Error("another holder released the lock")
+	}
+
+	lock, ok := locks.lock("Text/ch1.xhtml", "b", lockEdit, "page")
+	if !ok || lock.Expires == nil || lock.FilePath != "/Text/ch1.xhtml" {
+		t.Fatalf("edit lock = %+v, %v", lock, ok)
+	}
+	if _, ok := locks.lock("Text/ch1.xhtml", "b", lockAI, batch); ok {
+		t.Error("batch locked a segment being edited")
+	}
+	locks.lock("Text/ch2.xhtml", "a", lockAI, batch)
+
+	if got := locks.inFile("Text/ch1.xhtml"); len(got) != 2 || got[0].ContentID != "a" || got[1].ContentID != "b" {
+		t.Errorf("inFile() = %+v", got)
+	}
+	if got := locks.inFile(""); len(got) != 3 {
+		t.Errorf("inFile() of all files = %d locks, want 3", len(got))
+	}
+
+	locks.unlockAll(batch)
+	if got := locks.inFile(""); len(got) != 1 || got[0].Kind != lockEdit {
+		t.Errorf("locks after unlockAll = %+v", got)
+	}
+
+	// An edit lock that was not renewed no longer keeps batches off.
+	expired := time.Now().Add(-time.Second)
+	lock.Expires = &expired
+	locks.locks[segmentKey{"Text/ch1.xhtml", "b"}] = lock
+	if _, ok := locks.held("Text/ch1.xhtml", "b"); ok {
+		t.Error("expired edit lock is held")
+	}
+	if _, ok := locks.lock("Text/ch1.xhtml", "b", lockAI, batch); !ok {
+		t.Error("batch could not lock a segment whose edit lock expired")
+	}
+}
+
+func TestAITranslationInputRespectsLocks(t *testing.T) {
+	dir := t.TempDir()
+	chapter := `<html><body><p data-content-id="a" data-translation-by-id="ta">The sea.</p><p data-translation-id="ta">Biển.</p></body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "ch1.xhtml"), []byte(chapter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	citations, err := loadCitationStore(filepath.Join(dir, "citations.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locks := newSegmentLocks()
+	app := fiber.New()
+	app.Post("/ai-translate", func(c *fiber.Ctx) error {
+		original, _, _, err := aiTranslationInput(c, dir, citations, locks)
+		if err != nil {
+			return aiTranslationError(c, err)
+		}
+		return c.SendString(original)
+	})
+	translate := func(holder string) int {
+		body := `{"file_path":"/ch1.xhtml","content_id":"a","translation_id":"ta","holder":"` + holder + `"}`
+		req := httptest.NewRequest("POST", "/ai-translate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := translate("page"); status != fiber.StatusOK {
+		t.Errorf("unlocked segment: status %d, want 200", status)
+	}
+
+	locks.lock("ch1.xhtml", "a", lockEdit, "other page")
+	if status := translate("page"); status != fiber.StatusLocked {
+		t.Errorf("segment edited in another page: status %d, want 423", status)
+	}
+	if status := translate("other page"); status != fiber.StatusOK {
+		t.Errorf("segment edited in the asking page: status %d, want 200", status)
+	}
+
+	locks.unlockAll("other page")
+	locks.lock("ch1.xhtml", "a", lockAI, batchLockHolder("1"))
+	if status := translate("page"); status != fiber.StatusLocked {
+		t.Errorf("segment of an AI batch: status %d, want 423", status)
+	}
+}
@@ -32,6 +32,9 @@ var blacklist = map[string]bool{
     "noscript": true,
 }
 
+// markSVGText enables marking of <text> labels inside SVG images.
+var markSVGText bool
+
 var Mark = &cobra.Command{
 	Use:     "mark [epub_path]",
 	Short:   "Add unique identifiers to content nodes in EPUB files",
@@ -49,6 +52,8 @@ var Mark = &cobra.Command{
 
 func init() {
 	Mark.Flags().Int("workers", runtime.NumCPU(), "Number of worker goroutines")
+	Mark.Flags().BoolVar(&markSVGText, "svg", false, "also mark text labels inside SVG images, unless the image has translate=\"no\"")
+	Mark.Flags().Bool("verify-roundtrip", false, "only check that every file survives parsing and rendering unchanged, without marking")
 }
 
 func runMark(cmd *cobra.Command, args []string) error {
@@ -79,14 +84,36 @@ func runMark(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("workers must be greater than 0")
 	}
 
-	return processor.ProcessEpub(ctx, unzipPath, processor.Config{
+	verify, _ := cmd.Flags().GetBool("verify-roundtrip")
+	if verify {
+		return verifyBookRoundTrip(ctx, unzipPath, workers)
+	}
+
+	return markBook(ctx, unzipPath, workers)
+}
+
+// markBook marks the content of the book at unzipPath with the flags of mark
+// and the settings file of the book.
+func markBook(ctx context.Context, unzipPath string, workers int) error {
+	m, err := newMarker(unzipPath)
+	if err != nil {
+		return err
+	}
+	if err := processor.ProcessEpub(ctx, unzipPath, processor.Config{
 		Workers:      workers,
 		JobBuffer:    10,
 		ResultBuffer: 10,
-	}, markContentInFile)
+	}, m.markContentInFile); err != nil {
+		return err
+	}
+
+	if !markRecover {
+		return nil
+	}
+	return recoverBook(unzipPath)
 }
 
-func markContentInFile(ctx context.Context, filePath string) error {
+func (m *marker) markContentInFile(ctx context.Context, filePath string) error {
 	if filePath == "" {
 		return fmt.Errorf("filePath cannot be empty")
 	}
@@ -102,16 +129,18 @@ func markContentInFile(ctx context.Context, filePath string) error {
 		return fmt.Errorf("parsing HTML in file %s: %w", filePath, err)
 	}
 
-	processNode(doc)
+	// Leave the file byte-for-byte untouched when there is nothing to mark.
+	if !m.processNode(doc) {
+		return nil
+	}
 
-	f, err = os.Create(filePath)
+	data, err := serializeNode(filePath, doc)
 	if err != nil {
-		return fmt.Errorf("creating file %s: %w", filePath, err)
+		return fmt.Errorf("rendering HTML of file %s: %w", filePath, err)
 	}
-	defer f.Close()
 
-	if err := html.Render(f, doc); err != nil {
-		return fmt.Errorf("rendering HTML to file %s: %w", filePath, err)
+	if err := writeBookFile(filePath, data); err != nil {
+		return fmt.Errorf("writing file %s: %w", filePath, err)
 	}
 
 	return nil
@@ -119,42 +148,117 @@ func markContentInFile(ctx context.Context, filePath string) error {
 
 const minContentLength = 2
 
-func processNode(n *html.Node) {
+// processNode marks the content nodes below n and reports whether any node was marked.
+func (m *marker) processNode(n *html.Node) bool {
+	if m.needsRemarking(n) {
+		return m.remarkNode(n)
+	}
+	return m.markNode(n)
+}
+
+// markNode marks n, or the content nodes below it, unless it is marked already.
+func (m *marker) markNode(n *html.Node) bool {
 	if n.Type == html.ElementNode {
-		// Skip if already marked
+		// Skip if already marked or if this is a translation of a marked node,
+		// or the block translated sentences are collected in
 		for _, attr := range n.Attr {
-			if attr.Key == util.ContentIdKey {
-				return
+			if attr.Key == util.ContentIdKey || attr.Key == util.TranslationIdKey || attr.Key == util.TranslationLangKey {
+				return false
 			}
 		}
 
+		// Page breaks only hold the number of a printed page
+		if isPageBreakNode(n) {
+			return false
+		}
+
+		// Leave excluded elements alone with all they hold
+		if m.isExcluded(n) {
+			return false
+		}
+
 		// Skip if blacklisted
-		if blacklist[n.Data] {
-			return
+		if blacklist[n.Data] && !m.isIncluded(n) {
+			if n.Data == "svg" && m.svgText && !hasTranslateNo(n) {
+				return processSVGNode(n)
+			}
+			return m.markIncluded(n)
 		}
 
-		if !isContainer(n) {
+		if (!isContainer(n) || m.isIncluded(n)) && !hasMarkedDescendant(n) {
 			content := extractTextContent(n)
 			if util.IsEmptyOrWhitespace(content) || len(content) <= minContentLength || util.IsNumeric(content) || isSpecialContent(content) {
 				fmt.Printf("Skipping content in <%s> tag: %q\n", n.Data, content)
-				return
+				return false
 			} else {
+				if !isContainer(n) && m.splitsBlock(content) && m.markSentences(n) {
+					return true
+				}
+
 				// Mark this node
 				randomID, err := generateContentID([]byte(content))
 				if err != nil {
 					fmt.Printf("Error generating content ID: %v\n", err)
-					return
+					return false
 				}
 				n.Attr = append(n.Attr, html.Attribute{Key: util.ContentIdKey, Val: randomID})
-				return
+				return true
 			}
 		}
 	}
 
 	// Process child nodes
+	marked := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if m.processNode(c) {
+			marked = true
+		}
+	}
+
+	return marked
+}
+
+// processSVGNode marks the <text> elements of an SVG image, which hold diagram labels.
+func processSVGNode(n *html.Node) bool {
+	if n.Type == html.ElementNode && n.Data == "text" {
+		for _, attr := range n.Attr {
+			if attr.Key == util.ContentIdKey || attr.Key == util.TranslationIdKey {
+				return false
+			}
+		}
+
+		content := extractTextContent(n)
+		if util.IsEmptyOrWhitespace(content) || util.IsNumeric(content) || isSpecialContent(content) {
+			return false
+		}
+
+		id, err := generateContentID([]byte(content))
+		if err != nil {
+			fmt.Printf("Error generating content ID: %v\n", err)
+			return false
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: util.ContentIdKey, Val: id})
+		return true
+	}
+
+	marked := false
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		processNode(c)
+		if processSVGNode(c) {
+			marked = true
+		}
+	}
+
+	return marked
+}
+
+// hasTranslateNo reports whether the element opted out with translate="no".
+func hasTranslateNo(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "translate" && strings.EqualFold(attr.Val, "no") {
+			return true
+		}
 	}
+	return false
 }
 
 var re = regexp.MustCompile(`^[*=\-_.,:;!?#\s]+$`)
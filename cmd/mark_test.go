@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkContentInFileKeepsXHTML(t *testing.T) {
+	const original = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>Chapter</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <h1 class="title">The&nbsp;Storm</h1>
+  <p>Salt &amp; pepper &#8212; a line<br/>and another.</p>
+  <div class="figure"><img src="a.png" alt=""/></div>
+  <p>Three&#160;o&#x2019;clock, <em>sharp</em>.</p>
+</body>
+</html>
+`
+	filePath := filepath.Join(t.TempDir(), "ch1.xhtml")
+	if err := os.WriteFile(filePath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &marker{granularity: granularityParagraph}
+	if err := m.markContentInFile(context.Background(), filePath); err != nil {
+		t.Fatal(err)
+	}
+	marked, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(string(marked), `data-content-id=`); n != 3 {
+		t.Errorf("marked %d elements, want 3:\n%s", n, marked)
+	}
+	if got := contentIDAttrRegex.ReplaceAllString(string(marked), ""); got != original {
+		offset := firstDifference([]byte(original), []byte(got))
+		t.Errorf("mark changed more than the marked elements at byte %d:\n got: %q\nwant: %q",
+			offset, snippetAt([]byte(got), offset), snippetAt([]byte(original), offset))
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// Which elements mark gives content ids to can be changed with CSS
+// selectors. An element --include-selectors selects is marked as a whole,
+// even if it holds other blocks, like a <blockquote> of paragraphs, or sits
+// in an element mark leaves alone, like the <figcaption> of a <figure>. An
+// element --exclude-selectors selects is left alone with all it holds, like
+// <pre> blocks or poetry; exclusion wins over inclusion.
+//
+// The selectors, and the other settings of mark, can be kept next to the
+// book in <unpacked-dir>-mark.yaml:
+//
+//	granularity: sentence
+//	min_chars: 20
+//	max_chars: 600
+//	include: [figcaption, blockquote]
+//	exclude: [pre, div.poem]
+//
+// Flags given on the command line win over the file; selectors of both are
+// used. The file is read by markBook, so series and watch use it too.
+
+var (
+	markConfigFile string
+	// markIncludeSelectors and markExcludeSelectors are the selectors of the
+	// flags.
+	markIncludeSelectors []string
+	markExcludeSelectors []string
+
+	// markFlagChanged reports whether a flag of mark was given on the
+	// command line.
+	markFlagChanged func(name string) bool
+)
+
+func init() {
+	markFlagChanged = Mark.Flags().Changed
+	Mark.Flags().StringVar(&markConfigFile, "config", "", "mark settings file; defaults to <unpackedEpubPath>-mark.yaml")
+	Mark.Flags().StringArrayVar(&markIncludeSelectors, "include-selectors", nil, "CSS selector of elements to mark as a whole, e.g. 'blockquote, figcaption' (repeatable)")
+	Mark.Flags().StringArrayVar(&markExcludeSelectors, "exclude-selectors", nil, "CSS selector of elements not to mark, with all they hold, e.g. 'pre, .poem' (repeatable)")
+}
+
+// markConfig is the mark settings file of a book.
+type markConfig struct {
+	Granularity string   `yaml:"granularity"`
+	MinChars    int      `yaml:"min_chars"`
+	MaxChars    int      `yaml:"max_chars"`
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+}
+
+func markConfigPath(unpackedEpubPath string) string {
+	if markConfigFile != "" {
+		return markConfigFile
+	}
+	return filepath.Clean(unpackedEpubPath) + "-mark.yaml"
+}
+
+// marker marks the content of a book with the settings of a run.
+type marker struct {
+	granularity        string
+	minChars, maxChars int
+	svgText            bool
+	include, exclude   cascadia.SelectorGroup
+}
+
+// newMarker returns the marker of the book at unpackedEpubPath: the settings
+// of the flags of mark, over those of the settings file of the book where the
+// flags leave them. The flags are left as they are, so books marked one after
+// another each get the settings of their own file.
+func newMarker(unpackedEpubPath string) (*marker, error) {
+	m := &marker{granularity: markGranularity, minChars: minSegmentChars, maxChars: maxSegmentChars, svgText: markSVGText}
+	include := slices.Clone(markIncludeSelectors)
+	exclude := slices.Clone(markExcludeSelectors)
+
+	configPath := markConfigPath(unpackedEpubPath)
+	data, err := os.ReadFile(configPath)
+	if err != nil && (!os.IsNotExist(err) || markConfigFile != "") {
+		return nil, fmt.Errorf("reading mark settings: %w", err)
+	}
+
+	if err == nil {
+		var config markConfig
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("parsing mark settings %s: %w", configPath, err)
+		}
+		if config.Granularity != "" && !markFlagChanged("granularity") {
+			m.granularity = config.Granularity
+		}
+		if config.MinChars != 0 && !markFlagChanged("min-chars") {
+			m.minChars = config.MinChars
+		}
+		if config.MaxChars != 0 && !markFlagChanged("max-chars") {
+			m.maxChars = config.MaxChars
+		}
+		include = append(include, config.Include...)
+		exclude = append(exclude, config.Exclude...)
+	}
+
+	if m.granularity != granularityParagraph && m.granularity != granularitySentence {
+		return nil, fmt.Errorf("unknown granularity %q: use paragraph or sentence", m.granularity)
+	}
+	if m.minChars < 0 || m.maxChars < 0 {
+		return nil, fmt.Errorf("--min-chars and --max-chars must not be negative")
+	}
+	if m.include, err = compileSelectors(include); err != nil {
+		return nil, err
+	}
+	if m.exclude, err = compileSelectors(exclude); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func compileSelectors(selectors []string) (cascadia.SelectorGroup, error) {
+	var group cascadia.SelectorGroup
+	for _, selector := range selectors {
+		sel, err := cascadia.ParseGroup(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+		group = append(group, sel...)
+	}
+	return group, nil
+}
+
+func (m *marker) isIncluded(n *html.Node) bool {
+	return len(m.include) > 0 && m.include.Match(n)
+}
+
+func (m *marker) isExcluded(n *html.Node) bool {
+	return len(m.exclude) > 0 && m.exclude.Match(n)
+}
+
+// markIncluded marks the elements below n that --include-selectors selects,
+// for elements mark otherwise leaves alone.
+func (m *marker) markIncluded(n *html.Node) bool {
+	marked := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if m.isIncluded(c) {
+			marked = m.processNode(c) || marked
+			continue
+		}
+		marked = m.markIncluded(c) || marked
+	}
+	return marked
+}
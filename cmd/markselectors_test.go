@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestMarkSelectors(t *testing.T) {
+	defer func(include, exclude []string) {
+		markIncludeSelectors, markExcludeSelectors = include, exclude
+	}(markIncludeSelectors, markExcludeSelectors)
+
+	dir := filepath.Join(t.TempDir(), "book")
+	config := "granularity: paragraph\ninclude: [figcaption]\nexclude: [div.poem]\n"
+	if err := os.WriteFile(dir+"-mark.yaml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	markIncludeSelectors = []string{"blockquote"}
+	markExcludeSelectors = nil
+	m, err := newMarker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flags are left alone, so the next book gets its own selectors.
+	if len(markIncludeSelectors) != 1 || len(markExcludeSelectors) != 0 {
+		t.Errorf("flag selectors changed to %q, %q", markIncludeSelectors, markExcludeSelectors)
+	}
+	if other, err := newMarker(filepath.Join(t.TempDir(), "other")); err != nil || len(other.exclude) != 0 {
+		t.Errorf("selectors of another book = %v, %v", other, err)
+	}
+
+	body := `<p>A paragraph of text.</p>` +
+		`<blockquote><p>Quoted first.</p><p>Quoted second.</p></blockquote>` +
+		`<figure><img src="a.jpg"/><figcaption>A caption here</figcaption></figure>` +
+		`<div class="poem"><p>Roses are red</p></div>`
+	doc, err := html.Parse(strings.NewReader(`<html><head></head><body>` + body + `</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.processNode(doc)
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	got := contentIDAttrRegex.ReplaceAllString(buf.String(), ` data-content-id=""`)
+	for _, want := range []string{
+		`<p data-content-id="">A paragraph of text.</p>`,
+		`<blockquote data-content-id=""><p>Quoted first.</p><p>Quoted second.</p></blockquote>`,
+		`<figcaption data-content-id="">A caption here</figcaption>`,
+		`<div class="poem"><p>Roses are red</p></div>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s not in\n%s", want, got)
+		}
+	}
+
+	markIncludeSelectors = []string{"p:nth("}
+	if _, err := newMarker(dir); err == nil {
+		t.Error("no error for an invalid selector")
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/rivo/uniseg"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// With --granularity sentence, mark splits the text of a block into
+// sentences, by the Unicode sentence boundaries, and wraps every sentence in
+// a <span class="epubtrans-segment"> with its own content id. Prompts get
+// smaller, and a sentence that recurs, or survives an edit of its paragraph,
+// is found in the cache. A boundary inside an inline element such as <em> is
+// not used: the sentences on either side of it stay one segment.
+//
+// Translate puts the translations of the sentences of a block together, in
+// the order of the sentences, in a sibling block of the same element with
+// the class epubtrans-sentences right after it, so that the translated
+// paragraph reads as one.
+
+// sentenceSpanClass is the class of the spans mark wraps sentences in.
+const sentenceSpanClass = "epubtrans-segment"
+
+// sentenceTranslationsClass is the class of the block translate collects the
+// translated sentences of a block in.
+const sentenceTranslationsClass = "epubtrans-sentences"
+
+// markGranularity is the unit mark gives content ids to: paragraph or
+// sentence.
+var markGranularity = granularityParagraph
+
+func init() {
+	Mark.Flags().StringVar(&markGranularity, "granularity", granularityParagraph, "unit to mark: paragraph marks whole blocks, sentence wraps every sentence of a block in a marked <span>")
+}
+
+// markSentences wraps the sentences of the element n, grouped to honor the
+// segment size limits, in marked spans and reports whether it did. It does
+// not when n holds a single segment, which is marked like a paragraph.
+func (m *marker) markSentences(n *html.Node) bool {
+	groups := m.groupPieces(sentencePieces(n))
+	marked := 0
+	for _, group := range groups {
+		if isSentenceContent(group.text()) {
+			marked++
+		}
+	}
+	if marked < 2 {
+		return false
+	}
+
+	for _, group := range groups {
+		content := strings.TrimSpace(group.text())
+		if !isSentenceContent(content) {
+			continue
+		}
+		id, err := generateContentID([]byte(content))
+		if err != nil {
+			fmt.Printf("Error generating content ID: %v\n", err)
+			continue
+		}
+
+		nodes := trimSpaceNodes(group)
+		if len(nodes) == 0 {
+			continue
+		}
+		span := &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span, Attr: []html.Attribute{
+			{Key: "class", Val: sentenceSpanClass},
+			{Key: util.ContentIdKey, Val: id},
+		}}
+		n.InsertBefore(span, nodes[0])
+		for _, c := range nodes {
+			n.RemoveChild(c)
+			span.AppendChild(c)
+		}
+	}
+	return true
+}
+
+// sentencePiece is a run of children of an element holding one sentence,
+// or several when a boundary falls inside an inline element.
+type sentencePiece []*html.Node
+
+func (p sentencePiece) text() string {
+	var sb strings.Builder
+	for _, c := range p {
+		sb.WriteString(nodeText(c))
+	}
+	return sb.String()
+}
+
+// sentencePieces splits the children of n at the sentence boundaries of its
+// text, splitting text nodes where a boundary falls inside them.
+func sentencePieces(n *html.Node) []sentencePiece {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, c)
+	}
+	boundaries := sentenceBoundaries(nodeText(n))
+
+	var pieces []sentencePiece
+	var piece sentencePiece
+	offset := 0
+	for _, c := range children {
+		text := nodeText(c)
+		start, end := offset, offset+len(text)
+		offset = end
+		if c.Type != html.TextNode {
+			piece = append(piece, c)
+			for len(boundaries) > 0 && boundaries[0] <= end {
+				if boundaries[0] == end {
+					pieces, piece = append(pieces, piece), nil
+				}
+				boundaries = boundaries[1:]
+			}
+			continue
+		}
+
+		// Split the text node at every boundary inside it.
+		for len(boundaries) > 0 && boundaries[0] <= end {
+			cut := boundaries[0] - start
+			boundaries = boundaries[1:]
+			if cut > 0 {
+				head := &html.Node{Type: html.TextNode, Data: c.Data[:cut]}
+				n.InsertBefore(head, c)
+				c.Data = c.Data[cut:]
+				start += cut
+				piece = append(piece, head)
+			}
+			if len(piece) > 0 {
+				pieces, piece = append(pieces, piece), nil
+			}
+		}
+		if c.Data != "" {
+			piece = append(piece, c)
+		} else {
+			n.RemoveChild(c)
+		}
+	}
+	if len(piece) > 0 {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+// sentenceBoundaries returns the byte offsets in text where its sentences
+// end, after their trailing spaces, except the end of the text. A period
+// after an abbreviation such as "Mr." does not end a sentence.
+func sentenceBoundaries(text string) []int {
+	var boundaries []int
+	offset, state := 0, -1
+	rest := text
+	for len(rest) > 0 {
+		var sentence string
+		sentence, rest, state = uniseg.FirstSentenceInString(rest, state)
+		offset += len(sentence)
+		if rest == "" {
+			break
+		}
+		if words := strings.Fields(sentence); len(words) > 0 {
+			last := words[len(words)-1]
+			if strings.HasSuffix(last, ".") && sentenceAbbreviations[strings.ToLower(strings.TrimSuffix(last, "."))] {
+				continue
+			}
+		}
+		boundaries = append(boundaries, offset)
+	}
+	return boundaries
+}
+
+// trimSpaceNodes returns the nodes of piece without the white space at its
+// edges, which is split off into text nodes left outside.
+func trimSpaceNodes(piece sentencePiece) []*html.Node {
+	nodes := []*html.Node(piece)
+	if first := nodes[0]; first.Type == html.TextNode {
+		trimmed := strings.TrimLeft(first.Data, " \t\r\n")
+		if trimmed == "" {
+			nodes = nodes[1:]
+		} else if lead := first.Data[:len(first.Data)-len(trimmed)]; lead != "" {
+			first.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: lead}, first)
+			first.Data = trimmed
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	if last := nodes[len(nodes)-1]; last.Type == html.TextNode {
+		trimmed := strings.TrimRight(last.Data, " \t\r\n")
+		if trimmed == "" {
+			nodes = nodes[:len(nodes)-1]
+		} else if trail := last.Data[len(trimmed):]; trail != "" {
+			last.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: trail}, last.NextSibling)
+			last.Data = trimmed
+		}
+	}
+	return nodes
+}
+
+// isSentenceContent reports whether a sentence is worth a segment, by the
+// rules of processNode for blocks.
+func isSentenceContent(content string) bool {
+	content = strings.TrimSpace(content)
+	return !util.IsEmptyOrWhitespace(content) && len(content) > minContentLength && !util.IsNumeric(content) && !isSpecialContent(content)
+}
+
+// nodeText returns the text of n and its descendants as it is, unlike
+// extractTextContent, which trims it.
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(nodeText(c))
+	}
+	return sb.String()
+}
+
+// hasMarkedDescendant reports whether an element below n has a content id,
+// as the sentences of a block marked before.
+func hasMarkedDescendant(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		for _, attr := range c.Attr {
+			if attr.Key == util.ContentIdKey || attr.Key == util.TranslationIdKey {
+				return true
+			}
+		}
+		if hasMarkedDescendant(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSentenceSegment reports whether s is a sentence span of a block.
+func isSentenceSegment(s *goquery.Selection) bool {
+	return goquery.NodeName(s) == "span" && s.HasClass(sentenceSpanClass) && s.Parent().Length() > 0
+}
+
+// placeSentenceTranslation adds translated, the translation of the sentence
+// span, to the sentence translations block after its parent block, before
+// the translations of the sentences that follow it.
+func placeSentenceTranslation(span, translated *goquery.Selection, targetLang string) {
+	block := span.Parent()
+	translations := block.NextFiltered("." + sentenceTranslationsClass)
+	if translations.Length() == 0 {
+		tag := goquery.NodeName(block)
+		block.AfterHtml(fmt.Sprintf(`<%s class="%s" %s="%s"></%s>`, tag, sentenceTranslationsClass, util.TranslationLangKey, html.EscapeString(targetLang), tag))
+		translations = block.NextFiltered("." + sentenceTranslationsClass)
+	}
+
+	var before *goquery.Selection
+	span.NextAllFiltered("span." + sentenceSpanClass).EachWithBreak(func(i int, next *goquery.Selection) bool {
+		id := next.AttrOr(util.TranslationByIdKey, "")
+		if id == "" {
+			return true
+		}
+		before = translations.ChildrenFiltered(fmt.Sprintf("[%s=%q]", util.TranslationIdKey, id))
+		return before.Length() == 0
+	})
+
+	switch {
+	case before != nil && before.Length() > 0:
+		before.BeforeSelection(translated)
+		before.BeforeHtml(" ")
+	case translations.Children().Length() > 0:
+		translations.AppendHtml(" ")
+		translations.AppendSelection(translated)
+	default:
+		translations.AppendSelection(translated)
+	}
+}
+
+// pruneSentenceTranslations removes the sentence translations blocks left
+// without translations, and the blocks left without their sentences.
+func pruneSentenceTranslations(doc *goquery.Document) {
+	doc.Find("." + sentenceTranslationsClass).Each(func(i int, s *goquery.Selection) {
+		if s.Children().Length() == 0 {
+			s.Remove()
+			return
+		}
+		if block := s.Prev(); block.Length() > 0 && block.Children().Length() == 0 && strings.TrimSpace(block.Text()) == "" {
+			block.Remove()
+		}
+	})
+}
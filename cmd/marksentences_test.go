@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var contentIDAttrRegex = regexp.MustCompile(` data-content-id="[0-9a-f]+"`)
+
+func TestMarkSentences(t *testing.T) {
+	m := &marker{granularity: granularitySentence}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"sentences",
+			`<p>It was late. Mr. Smith left; he said “Goodbye!” Then silence.</p>`,
+			`<p><span class="epubtrans-segment">It was late.</span> <span class="epubtrans-segment">Mr. Smith left; he said “Goodbye!”</span> <span class="epubtrans-segment">Then silence.</span></p>`,
+		},
+		{
+			"inline elements",
+			`<p>She read <em>Dune. Twice.</em> It was <b>long</b>. The end?</p>`,
+			`<p><span class="epubtrans-segment">She read <em>Dune. Twice.</em></span> <span class="epubtrans-segment">It was <b>long</b>.</span> <span class="epubtrans-segment">The end?</span></p>`,
+		},
+		{
+			"one sentence",
+			`<p>Only one sentence here.</p>`,
+			`<p>Only one sentence here.</p>`,
+		},
+		{
+			"short pieces",
+			`<p>1. It began in the spring.</p>`,
+			`<p>1. It began in the spring.</p>`,
+		},
+		{
+			"marked before",
+			`<p><span class="epubtrans-segment" data-content-id="a">One sentence.</span> <span class="epubtrans-segment" data-content-id="b">Another one.</span> 1.</p>`,
+			`<p><span class="epubtrans-segment">One sentence.</span> <span class="epubtrans-segment">Another one.</span> 1.</p>`,
+		},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(`<html><head></head><body>` + tt.body + `</body></html>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.processNode(doc)
+
+		var buf bytes.Buffer
+		if err := html.Render(&buf, doc); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(tt.want, "<span") && strings.Contains(buf.String(), "<p data-content-id") {
+			t.Errorf("%s: the paragraph of the sentences is marked too", tt.name)
+		}
+		got := strings.TrimSuffix(strings.TrimPrefix(buf.String(), "<html><head></head><body>"), "</body></html>")
+		got = contentIDAttrRegex.ReplaceAllString(got, "")
+		if got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMarkSegmentLimits(t *testing.T) {
+	m := &marker{granularity: granularityParagraph}
+
+	mark := func(body string, min, max int) string {
+		t.Helper()
+		m.minChars, m.maxChars = min, max
+		doc, err := html.Parse(strings.NewReader(`<html><head></head><body>` + body + `</body></html>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.processNode(doc)
+		var buf bytes.Buffer
+		if err := html.Render(&buf, doc); err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(buf.String(), "<html><head></head><body>"), "</body></html>")
+	}
+	strip := func(s string) string { return contentIDAttrRegex.ReplaceAllString(s, "") }
+
+	body := `<p>The first sentence is here. The second one too. Yes. And a third sentence ends it.</p>`
+	split := mark(body, 0, 50)
+	want := `<p><span class="epubtrans-segment">The first sentence is here. The second one too.</span> <span class="epubtrans-segment">Yes. And a third sentence ends it.</span></p>`
+	if strip(split) != want {
+		t.Errorf("max 50:\n got %s\nwant %s", strip(split), want)
+	}
+	if got := mark(body, 0, 0); strip(got) != `<p>`+body[3:] {
+		t.Errorf("no limits: %s", got)
+	}
+
+	// Marking again with other limits re-marks untranslated segments.
+	remarked := mark(split, 5, 0)
+	if strings.Contains(remarked, "epubtrans-segment") || !strings.HasPrefix(remarked, `<p data-content-id=`) {
+		t.Errorf("re-marked without limits: %s", remarked)
+	}
+	m.granularity = granularitySentence
+	want = `<p><span class="epubtrans-segment">The first sentence is here.</span> <span class="epubtrans-segment">The second one too. Yes.</span> <span class="epubtrans-segment">And a third sentence ends it.</span></p>`
+	if got := strip(mark(remarked, 5, 0)); got != want {
+		t.Errorf("min 5:\n got %s\nwant %s", got, want)
+	}
+	m.granularity = granularityParagraph
+	if got := mark(split, 0, 50); got != split {
+		t.Errorf("marking again with the same limits changed the segments:\n got %s\nwant %s", got, split)
+	}
+
+	// Translated segments keep their ids.
+	translated := strings.Replace(split, `">The first`, `" data-translation-by-id="t1">The first`, 1)
+	if got := mark(translated, 0, 0); got != translated {
+		t.Errorf("translated segments re-marked: %s", got)
+	}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Models sometimes drop an <em>, a link or a note reference from a segment.
+// Before a segment is sent, its inline markup is replaced with placeholders
+// like formulas are: {{TAG_0}} and {{/TAG_0}} stand for the start and end
+// tags of an element, and {{TAG_1}} alone for an element without content of
+// its own, such as a <br>, a whole note reference or a character reference
+// like &nbsp;. Tags with an alt text or a title stay, so the model
+// translates those with the text. The model may move the placeholders with
+// the words they belong to, but a translation that loses, repeats or
+// crosses them is rejected. DeepL, which is no language model, keeps the
+// markup it is sent, so it gets the tags themselves.
+
+var (
+	markupTagRegex = regexp.MustCompile(`<(/?)([a-zA-Z][\w:.-]*)\b[^>]*>`)
+	// noteRefRegex matches a whole note reference, whose number the model
+	// has no business with.
+	noteRefRegex = regexp.MustCompile(`(?s)<a\b[^>]*\b(?:epub:type="[^"]*\bnoteref\b[^"]*"|role="doc-noteref")[^>]*>.*?</a>`)
+	// markupEntityRegex matches character references, of which only the
+	// ones that escape markup characters are left to the model.
+	markupEntityRegex      = regexp.MustCompile(`&(?:#\d+|#[xX][0-9a-fA-F]+|[a-zA-Z]\w*);`)
+	markupPlaceholderRegex = regexp.MustCompile(`\{\{(/?)TAG_(\d+)\}\}`)
+)
+
+// escapeEntities are the character references that only escape a character
+// of the markup.
+var escapeEntities = map[string]bool{
+	"&amp;": true, "&lt;": true, "&gt;": true, "&quot;": true, "&apos;": true, "&#34;": true, "&#39;": true,
+}
+
+// markupPart is masked inline markup: the start and end tag of an element,
+// or an element or reference as a whole, with an empty end.
+type markupPart struct {
+	start string
+	end   string
+}
+
+func markupPlaceholder(i int) string {
+	return fmt.Sprintf("{{TAG_%d}}", i)
+}
+
+func markupEndPlaceholder(i int) string {
+	return fmt.Sprintf("{{/TAG_%d}}", i)
+}
+
+// maskMarkup replaces the inline markup of htmlContent with placeholders.
+func maskMarkup(htmlContent string) (string, []markupPart) {
+	var parts []markupPart
+	whole := func(s string) string {
+		parts = append(parts, markupPart{start: s})
+		return markupPlaceholder(len(parts) - 1)
+	}
+
+	masked := noteRefRegex.ReplaceAllStringFunc(htmlContent, whole)
+	masked = markupEntityRegex.ReplaceAllStringFunc(masked, func(entity string) string {
+		if escapeEntities[entity] {
+			return entity
+		}
+		return whole(entity)
+	})
+
+	// open holds the elements started and not yet ended; part is -1 for
+	// the elements left to the model.
+	type openElement struct {
+		name string
+		part int
+	}
+	var open []openElement
+	var b strings.Builder
+	last := 0
+	for _, m := range markupTagRegex.FindAllStringSubmatchIndex(masked, -1) {
+		b.WriteString(masked[last:m[0]])
+		last = m[1]
+		tag, name := masked[m[0]:m[1]], strings.ToLower(masked[m[4]:m[5]])
+
+		switch {
+		case m[3] > m[2]:
+			// An end tag ends the innermost element of its name.
+			i := len(open) - 1
+			for i >= 0 && open[i].name != name {
+				i--
+			}
+			switch {
+			case i < 0:
+				b.WriteString(whole(tag))
+				continue
+			case open[i].part < 0:
+				b.WriteString(tag)
+			default:
+				parts[open[i].part].end = tag
+				b.WriteString(markupEndPlaceholder(open[i].part))
+			}
+			open = append(open[:i], open[i+1:]...)
+		case qaAttributeRegex.MatchString(tag):
+			// Alt texts and titles are translated with the text.
+			b.WriteString(tag)
+			if !voidElements[name] && !strings.HasSuffix(tag, "/>") {
+				open = append(open, openElement{name, -1})
+			}
+		case voidElements[name] || strings.HasSuffix(tag, "/>"):
+			b.WriteString(whole(tag))
+		default:
+			b.WriteString(whole(tag))
+			open = append(open, openElement{name, len(parts) - 1})
+		}
+	}
+	b.WriteString(masked[last:])
+
+	return b.String(), parts
+}
+
+// unmaskMarkup puts the masked markup back. It fails like unmaskMath if a
+// placeholder was lost or duplicated, and if an element would end before it
+// starts or cross another one.
+func unmaskMarkup(translated string, parts []markupPart) (string, error) {
+	seen := make([]int, len(parts))
+	ended := make([]bool, len(parts))
+	var open []int
+	for _, m := range markupPlaceholderRegex.FindAllStringSubmatch(translated, -1) {
+		i, err := strconv.Atoi(m[2])
+		if err != nil || i >= len(parts) {
+			return "", fmt.Errorf("unknown placeholder %s", m[0])
+		}
+		if m[1] == "" {
+			seen[i]++
+			if parts[i].end != "" {
+				open = append(open, i)
+			}
+			continue
+		}
+		if len(open) == 0 || open[len(open)-1] != i {
+			return "", fmt.Errorf("placeholder %s out of place", m[0])
+		}
+		open = open[:len(open)-1]
+		if ended[i] {
+			return "", fmt.Errorf("placeholder %s found more than once", m[0])
+		}
+		ended[i] = true
+	}
+	for i, part := range parts {
+		if seen[i] != 1 {
+			return "", fmt.Errorf("placeholder %s found %d times", markupPlaceholder(i), seen[i])
+		}
+		if part.end != "" && !ended[i] {
+			return "", fmt.Errorf("placeholder %s not found", markupEndPlaceholder(i))
+		}
+	}
+
+	return markupPlaceholderRegex.ReplaceAllStringFunc(translated, func(placeholder string) string {
+		m := markupPlaceholderRegex.FindStringSubmatch(placeholder)
+		i, _ := strconv.Atoi(m[2])
+		if m[1] != "" {
+			return parts[i].end
+		}
+		return parts[i].start
+	}), nil
+}
+
+// masksMarkup reports whether the provider of the run gets placeholders
+// for inline markup.
+func masksMarkup() bool {
+	return translationProvider != "deepl"
+}
+
+func batchHasMarkup(batch translationBatch) bool {
+	for _, element := range batch.elements {
+		if len(element.markup) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// markupInstructions tell the model what the placeholders of masked inline
+// markup are.
+const markupInstructions = "Placeholders such as {{TAG_0}} and {{/TAG_0}} stand for inline markup such as emphasis, links and note references: {{TAG_0}} starts an element and {{/TAG_0}} ends it, and a {{TAG_1}} without an end stands alone. Keep every placeholder exactly once and unchanged, around the translation of the words it surrounds, without crossing other pairs."
@@ -0,0 +1,93 @@
+package cmd
+
+import "testing"
+
+func TestMaskMarkupRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		translated string
+		wantMasked string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "No markup",
+			content:    "Plain prose &amp; more.",
+			translated: "Văn xuôi &amp; hơn.",
+			wantMasked: "Plain prose &amp; more.",
+			want:       "Văn xuôi &amp; hơn.",
+		},
+		{
+			name:       "Moved with its words",
+			content:    `A <em>red</em> <a href="b.xhtml">car</a>.`,
+			translated: "Một chiếc {{TAG_1}}xe{{/TAG_1}} {{TAG_0}}đỏ{{/TAG_0}}.",
+			wantMasked: "A {{TAG_0}}red{{/TAG_0}} {{TAG_1}}car{{/TAG_1}}.",
+			want:       `Một chiếc <a href="b.xhtml">xe</a> <em>đỏ</em>.`,
+		},
+		{
+			name:       "Note references, line breaks and entities",
+			content:    `Fin<a epub:type="noteref" href="#n1">1</a>.<br/>Au&nbsp;revoir`,
+			translated: "Hết{{TAG_0}}.{{TAG_2}}Tạm{{TAG_1}}biệt",
+			wantMasked: "Fin{{TAG_0}}.{{TAG_2}}Au{{TAG_1}}revoir",
+			want:       `Hết<a epub:type="noteref" href="#n1">1</a>.<br/>Tạm&nbsp;biệt`,
+		},
+		{
+			name:       "Titles stay for the model",
+			content:    `An <abbr title="Example">ex.</abbr> of <b>it</b>`,
+			translated: `Một <abbr title="Ví dụ">vd.</abbr> về {{TAG_0}}nó{{/TAG_0}}`,
+			wantMasked: `An <abbr title="Example">ex.</abbr> of {{TAG_0}}it{{/TAG_0}}`,
+			want:       `Một <abbr title="Ví dụ">vd.</abbr> về <b>nó</b>`,
+		},
+		{
+			name:       "Nested",
+			content:    `<b>Very <i>bold</i></b>`,
+			translated: "{{TAG_0}}Rất {{TAG_1}}đậm{{/TAG_1}}{{/TAG_0}}",
+			wantMasked: "{{TAG_0}}Very {{TAG_1}}bold{{/TAG_1}}{{/TAG_0}}",
+			want:       `<b>Rất <i>đậm</i></b>`,
+		},
+		{
+			name:       "Placeholder dropped by the model",
+			content:    `A <em>red</em> car`,
+			translated: "Một chiếc xe {{TAG_0}}đỏ",
+			wantMasked: "A {{TAG_0}}red{{/TAG_0}} car",
+			wantErr:    true,
+		},
+		{
+			name:       "Pairs crossed by the model",
+			content:    `<b>Very <i>bold</i></b>`,
+			translated: "{{TAG_0}}Rất {{TAG_1}}đậm{{/TAG_0}}{{/TAG_1}}",
+			wantMasked: "{{TAG_0}}Very {{TAG_1}}bold{{/TAG_1}}{{/TAG_0}}",
+			wantErr:    true,
+		},
+		{
+			name:       "Placeholder repeated by the model",
+			content:    `A<br/>B`,
+			translated: "A{{TAG_0}}{{TAG_0}}B",
+			wantMasked: "A{{TAG_0}}B",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked, parts := maskMarkup(tt.content)
+			if masked != tt.wantMasked {
+				t.Errorf("maskMarkup() = %q, want %q", masked, tt.wantMasked)
+			}
+
+			original, err := unmaskMarkup(masked, parts)
+			if err != nil || original != tt.content {
+				t.Errorf("unmaskMarkup() of the masked content = %q, %v", original, err)
+			}
+
+			got, err := unmaskMarkup(tt.translated, parts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmaskMarkup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("unmaskMarkup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var mathRegex = regexp.MustCompile(`(?s)<(?:\w+:)?math\b.*?</(?:\w+:)?math>`)
+
+func mathPlaceholder(i int) string {
+	return fmt.Sprintf("{{MATH_%d}}", i)
+}
+
+// maskMath replaces every MathML formula in htmlContent with a placeholder so
+// the model can translate the surrounding prose without touching the formulas.
+func maskMath(htmlContent string) (string, []string) {
+	var formulas []string
+	masked := mathRegex.ReplaceAllStringFunc(htmlContent, func(formula string) string {
+		formulas = append(formulas, formula)
+		return mathPlaceholder(len(formulas) - 1)
+	})
+
+	return masked, formulas
+}
+
+// unmaskMath puts the formulas back. It fails if a placeholder was lost or
+// duplicated, since the formula could not be restored faithfully.
+func unmaskMath(translated string, formulas []string) (string, error) {
+	for i, formula := range formulas {
+		placeholder := mathPlaceholder(i)
+		if n := strings.Count(translated, placeholder); n != 1 {
+			return "", fmt.Errorf("placeholder %s found %d times", placeholder, n)
+		}
+		translated = strings.Replace(translated, placeholder, formula, 1)
+	}
+
+	return translated, nil
+}
+
+// isOnlyMath reports whether nothing but formulas and whitespace is left after masking.
+func isOnlyMath(masked string, formulas []string) bool {
+	if len(formulas) == 0 {
+		return false
+	}
+
+	for i := range formulas {
+		masked = strings.Replace(masked, mathPlaceholder(i), "", 1)
+	}
+
+	return strings.TrimSpace(masked) == ""
+}
+
+func batchHasMath(batch translationBatch) bool {
+	for _, element := range batch.elements {
+		if len(element.formulas) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import "testing"
+
+func TestMaskMathRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		translated   string
+		wantMasked   string
+		wantOnlyMath bool
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:       "No formulas",
+			content:    "Plain <em>prose</em>.",
+			translated: "Văn <em>xuôi</em>.",
+			wantMasked: "Plain <em>prose</em>.",
+			want:       "Văn <em>xuôi</em>.",
+		},
+		{
+			name:       "Formula inside prose",
+			content:    `Let <math><mi>x</mi></math> be a number.`,
+			translated: "Gọi {{MATH_0}} là một số.",
+			wantMasked: "Let {{MATH_0}} be a number.",
+			want:       `Gọi <math><mi>x</mi></math> là một số.`,
+		},
+		{
+			name:         "Only formulas",
+			content:      ` <m:math><m:mi>y</m:mi></m:math> `,
+			wantMasked:   " {{MATH_0}} ",
+			wantOnlyMath: true,
+		},
+		{
+			name:       "Placeholder dropped by the model",
+			content:    `Since <math><mn>1</mn></math> and <math><mn>2</mn></math> differ`,
+			translated: "Vì {{MATH_0}} khác nhau",
+			wantMasked: "Since {{MATH_0}} and {{MATH_1}} differ",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked, formulas := maskMath(tt.content)
+			if masked != tt.wantMasked {
+				t.Errorf("maskMath() = %q, want %q", masked, tt.wantMasked)
+			}
+			if got := isOnlyMath(masked, formulas); got != tt.wantOnlyMath {
+				t.Errorf("isOnlyMath() = %v, want %v", got, tt.wantOnlyMath)
+			}
+			if tt.wantOnlyMath {
+				return
+			}
+
+			got, err := unmaskMath(tt.translated, formulas)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmaskMath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("unmaskMath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// notifyTargets are where translate sends its failure report: mailto:
+// addresses, sent through the SMTP server of send, and http(s) URLs, which
+// get the report POSTed as JSON, e.g. a chat webhook.
+var notifyTargets []string
+
+const notifyTimeout = 30 * time.Second
+
+func init() {
+	Translate.Flags().StringSliceVar(&notifyTargets, "notify", notifyTargetsFromEnv(), "send the failure report of a run to this mailto: address or http(s) webhook URL (repeatable); defaults to EPUBTRANS_NOTIFY")
+}
+
+// notifyTargetsFromEnv returns the comma separated targets of EPUBTRANS_NOTIFY.
+func notifyTargetsFromEnv() []string {
+	var targets []string
+	for _, target := range strings.Split(os.Getenv("EPUBTRANS_NOTIFY"), ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// notify sends text, with subject, to every mailto: target and payload as
+// JSON to every webhook. It tries every target and returns their errors.
+func notify(targets []string, subject, text string, payload any) error {
+	var errs []error
+	for _, target := range targets {
+		var err error
+		switch {
+		case strings.HasPrefix(target, "mailto:"):
+			err = notifyByEmail(strings.TrimPrefix(target, "mailto:"), subject, text)
+		case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+			err = notifyWebhook(target, payload)
+		default:
+			err = errors.New("use a mailto: address or an http(s) URL")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notifying %s: %w", target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func notifyByEmail(to, subject, text string) error {
+	cfg, err := loadSMTPConfig()
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(cfg.Host, cfg.Port), auth, cfg.From, []string{to}, msg.Bytes())
+}
+
+func notifyWebhook(url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
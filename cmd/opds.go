@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsAcquisitionRel  = "http://opds-spec.org/acquisition"
+	epubMediaType       = "application/epub+zip"
+)
+
+var OPDS = &cobra.Command{
+	Use:   "opds [libraryDir]",
+	Short: "Publish packed translations as an OPDS catalog",
+	Long: `This command starts a web server that publishes every packed EPUB in a directory as an OPDS feed.
+E-readers with OPDS support, such as KOReader, can browse the catalog and download the translated books directly.
+The packs of a book are one entry, with a download for each edition: bilingual, translated and original. Of
+repeated packs of an edition, such as book-bilangual-(1).epub, the newest is offered.`,
+	Example: `epubtrans opds path/to/library
+		# Add http://<your-ip>:3001/opds as a catalog in your e-reader`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("libraryDir is required. Please provide the directory containing packed EPUB files.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runOPDS,
+}
+
+func init() {
+	OPDS.Flags().StringP("port", "p", "3001", "port to serve the OPDS catalog")
+	OPDS.Flags().String("title", "epubtrans library", "title of the catalog")
+}
+
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []opdsLink  `xml:"link"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Updated  string      `xml:"updated"`
+	Author   *opdsAuthor `xml:"author,omitempty"`
+	Language string      `xml:"http://purl.org/dc/terms/ language,omitempty"`
+	Summary  string      `xml:"summary,omitempty"`
+	Links    []opdsLink  `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+// repeatedPackRegex matches the suffix getUniqueFilename gives a pack when
+// the book was packed before.
+var repeatedPackRegex = regexp.MustCompile(`-\(\d+\)$`)
+
+// opdsEditionSuffixes are the name suffixes pack gives the editions of a
+// book, in the order the catalog offers them.
+var opdsEditionSuffixes = []struct{ suffix, edition string }{
+	{strings.TrimSuffix(defaultSuffix, ".epub"), packBilingual},
+	{"-" + packTranslated, packTranslated},
+	{"-" + packOriginal, packOriginal},
+}
+
+// opdsEdition returns the book a packed EPUB is an edition of, by the name
+// of its file, and the edition, or "" for a file not named by pack.
+func opdsEdition(filename string) (book, edition string) {
+	book = repeatedPackRegex.ReplaceAllString(strings.TrimSuffix(filename, filepath.Ext(filename)), "")
+	for _, e := range opdsEditionSuffixes {
+		if strings.HasSuffix(book, e.suffix) {
+			return strings.TrimSuffix(book, e.suffix), e.edition
+		}
+	}
+	return book, ""
+}
+
+// opdsEditionRank returns the place of the edition in the catalog, after
+// the editions of pack for a file not named by pack.
+func opdsEditionRank(edition string) int {
+	for i, e := range opdsEditionSuffixes {
+		if e.edition == edition {
+			return i
+		}
+	}
+	return len(opdsEditionSuffixes)
+}
+
+// opdsPack is a packed EPUB of the library.
+type opdsPack struct {
+	filename string
+	edition  string
+	modTime  time.Time
+}
+
+func runOPDS(cmd *cobra.Command, args []string) error {
+	libraryDir := args[0]
+	title, _ := cmd.Flags().GetString("title")
+
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+
+	app.Get("/opds", func(c *fiber.Ctx) error {
+		feed, err := buildOPDSFeed(libraryDir, title)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error building catalog: %v", err))
+		}
+
+		body, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error encoding catalog")
+		}
+
+		c.Set("Content-Type", opdsAcquisitionType)
+		return c.Send(append([]byte(xml.Header), body...))
+	})
+
+	app.Get("/books/:filename", func(c *fiber.Ctx) error {
+		filename, err := url.PathUnescape(c.Params("filename"))
+		if err != nil || filename != filepath.Base(filename) || !isEpubFile(filename) {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid file name")
+		}
+
+		c.Set("Content-Type", epubMediaType)
+		return c.Download(filepath.Join(libraryDir, filename), filename)
+	})
+
+	port := cmd.Flag("port").Value.String()
+
+	slog.Info("- http://localhost:" + port + "/opds")
+
+	return app.Listen(net.JoinHostPort("", port))
+}
+
+func buildOPDSFeed(libraryDir, title string) (*opdsFeed, error) {
+	entries, err := os.ReadDir(libraryDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading library directory: %w", err)
+	}
+
+	feed := &opdsFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      "urn:epubtrans:catalog",
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []opdsLink{
+			{Rel: "self", Href: "/opds", Type: opdsAcquisitionType},
+			{Rel: "start", Href: "/opds", Type: opdsAcquisitionType},
+		},
+	}
+
+	// The newest pack of every edition of every book.
+	books := make(map[string][]opdsPack)
+	for _, entry := range entries {
+		if entry.IsDir() || !isEpubFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		book, edition := opdsEdition(entry.Name())
+		pack := opdsPack{filename: entry.Name(), edition: edition, modTime: info.ModTime()}
+		i := slices.IndexFunc(books[book], func(p opdsPack) bool { return p.edition == edition })
+		switch {
+		case i < 0:
+			books[book] = append(books[book], pack)
+		case pack.modTime.After(books[book][i].modTime):
+			books[book][i] = pack
+		}
+	}
+
+	for book, packs := range books {
+		bookEntry, err := buildOPDSEntry(libraryDir, book, packs)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", book, err)
+			continue
+		}
+
+		feed.Entries = append(feed.Entries, *bookEntry)
+	}
+
+	sort.Slice(feed.Entries, func(i, j int) bool {
+		if feed.Entries[i].Title != feed.Entries[j].Title {
+			return feed.Entries[i].Title < feed.Entries[j].Title
+		}
+		return feed.Entries[i].ID < feed.Entries[j].ID
+	})
+
+	return feed, nil
+}
+
+// buildOPDSEntry returns the entry of a book with a download for each of its
+// packs. The metadata is read from the first of them in the order of
+// opdsEditionSuffixes.
+func buildOPDSEntry(libraryDir, book string, packs []opdsPack) (*opdsEntry, error) {
+	sort.Slice(packs, func(i, j int) bool { return opdsEditionRank(packs[i].edition) < opdsEditionRank(packs[j].edition) })
+
+	pkg, err := loader.ParseEpubPackage(filepath.Join(libraryDir, packs[0].filename))
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(book))
+	entry := &opdsEntry{
+		ID:       "urn:epubtrans:book:" + hex.EncodeToString(hash[:8]),
+		Title:    pkg.Metadata.Title,
+		Language: pkg.Metadata.Language,
+		Summary:  pkg.Metadata.Description,
+	}
+
+	var updated time.Time
+	for _, pack := range packs {
+		entry.Links = append(entry.Links, opdsLink{Rel: opdsAcquisitionRel, Href: "/books/" + url.PathEscape(pack.filename), Type: epubMediaType, Title: pack.edition})
+		if pack.modTime.After(updated) {
+			updated = pack.modTime
+		}
+	}
+	entry.Updated = updated.UTC().Format(time.RFC3339)
+
+	if entry.Title == "" {
+		entry.Title = book
+	}
+	if pkg.Metadata.Creator != "" {
+		entry.Author = &opdsAuthor{Name: pkg.Metadata.Creator}
+	}
+
+	return entry, nil
+}
+
+func isEpubFile(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".epub")
+}
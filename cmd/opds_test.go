@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOPDSEdition(t *testing.T) {
+	tests := []struct {
+		filename, book, edition string
+	}{
+		{"Alice-bilangual.epub", "Alice", packBilingual},
+		{"Alice-bilangual-(2).epub", "Alice", packBilingual},
+		{"Alice-translated.epub", "Alice", packTranslated},
+		{"Alice-original-(1).epub", "Alice", packOriginal},
+		{"Other book.epub", "Other book", ""},
+	}
+	for _, tt := range tests {
+		if book, edition := opdsEdition(tt.filename); book != tt.book || edition != tt.edition {
+			t.Errorf("opdsEdition(%q) = %q, %q, want %q, %q", tt.filename, book, edition, tt.book, tt.edition)
+		}
+	}
+}
+
+func TestBuildOPDSFeed(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "Alice")
+	writeLibraryBook(t, src, "Alice")
+	library := t.TempDir()
+
+	// Alice packed twice, then as a translated edition.
+	packs := []string{"Alice-bilangual.epub", "Alice-bilangual-(1).epub", "Alice-translated.epub"}
+	for i, name := range packs {
+		path := filepath.Join(library, name)
+		if err := packFiles(src, path, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Date(2026, 10, 16, 12, i, 0, 0, time.UTC)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, err := buildOPDSFeed(library, "library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("feed has %d entries, want one for the book: %+v", len(feed.Entries), feed.Entries)
+	}
+	entry := feed.Entries[0]
+	if entry.Title != "Alice" || entry.Updated != "2026-10-16T12:02:00Z" {
+		t.Errorf("entry = %+v", entry)
+	}
+	want := []opdsLink{
+		{Rel: opdsAcquisitionRel, Href: "/books/Alice-bilangual-%281%29.epub", Type: epubMediaType, Title: packBilingual},
+		{Rel: opdsAcquisitionRel, Href: "/books/Alice-translated.epub", Type: epubMediaType, Title: packTranslated},
+	}
+	if len(entry.Links) != len(want) {
+		t.Fatalf("links = %+v, want %+v", entry.Links, want)
+	}
+	for i := range want {
+		if entry.Links[i] != want[i] {
+			t.Errorf("link %d = %+v, want %+v", i, entry.Links[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiOperation documents an endpoint of the serve API. The OpenAPI document
+// combines these with the routes actually registered, and the request and
+// response schemas are reflected from the Go values the handlers use, so the
+// document follows the code.
+type apiOperation struct {
+	Summary string
+	Params  []apiParam
+	// Request is a value of the JSON request body, nil for none.
+	Request any
+	// Response is a value of the JSON response body; nil with ContentType for
+	// other responses.
+	Response    any
+	ContentType string
+	// Status is the status of a successful response, 200 if zero.
+	Status int
+	// Errors are the statuses answered with an {"error": "..."} body.
+	Errors []int
+}
+
+type apiParam struct {
+	Name        string
+	In          string // path or query
+	Description string
+}
+
+// apiOperations documents the endpoints by method and path below apiV1.
+var apiOperations = map[string]apiOperation{
+	"GET /openapi.json": {
+		Summary:  "This OpenAPI document",
+		Response: map[string]any{},
+	},
+	"GET /info": {
+		Summary:  "Book metadata from the package document",
+		Response: loader.Metadata{},
+	},
+	"GET /manifest": {
+		Summary:  "Manifest items of the book",
+		Response: loader.Manifest{},
+	},
+	"GET /spine": {
+		Summary:  "Reading order of the book",
+		Response: loader.Spine{},
+	},
+	"GET /jobs": {
+		Summary:  "Translation jobs, newest first",
+		Response: []jobInfo{},
+		Errors:   []int{500},
+	},
+	"GET /jobs/:id/logs": {
+		Summary: "Structured log entries of a job",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "job id"},
+			{Name: "level", In: "query", Description: "minimum level: debug, info, warn or error"},
+			{Name: "file", In: "query", Description: "only entries about this file"},
+		},
+		Response: []map[string]any{},
+		Errors:   []int{400, 404},
+	},
+	"GET /provenance": {
+		Summary:  "Number of translations per provider, model and prompt version",
+		Response: []provenanceCount{},
+		Errors:   []int{500},
+	},
+	"GET /translation-status": {
+		Summary:  "Number of segments untranslated, machine-translated, human-edited, approved and rejected, in total and per chapter",
+		Response: translationStatus{},
+		Errors:   []int{500},
+	},
+	"GET /progress": {
+		Summary:  "Marked and translated segments per spine file, in reading order",
+		Response: bookProgress{},
+		Errors:   []int{500},
+	},
+	"GET /search": {
+		Summary: "Find the segments and translations containing every word of a query, in reading order",
+		Params: []apiParam{
+			{Name: "q", In: "query", Description: "words to find, in any case"},
+			{Name: "limit", In: "query", Description: "maximum number of hits, 50 by default"},
+		},
+		Response: searchResults{},
+		Errors:   []int{400, 500},
+	},
+	"POST /export": {
+		Summary:     "Pack the book as edited so far, bilingual or translated-only, and download the EPUB",
+		Request:     ExportRequest{},
+		ContentType: "application/epub+zip",
+		Errors:      []int{400, 500},
+	},
+	"GET /edit-history": {
+		Summary: "Edits of the translations of a file saved in serve, oldest first",
+		Params: []apiParam{
+			{Name: "file_path", In: "query", Description: "path of the file in the content directory"},
+			{Name: "translation_id", In: "query", Description: "only the edits of this translation"},
+		},
+		Response: []editEntry{},
+		Errors:   []int{400, 500},
+	},
+	"POST /undo-translation": {
+		Summary: "Restore a translation, and its provenance, as it was before its latest edit not undone yet",
+		Request: UndoRequest{},
+		Errors:  []int{400, 404, 409, 423, 500},
+	},
+	"GET /sync/state": {
+		Summary:  "Hashes of the translation and the review verdict of every segment, by content id, for sync",
+		Response: syncState{},
+		Errors:   []int{500},
+	},
+	"POST /sync/fetch": {
+		Summary:  "Translations and review verdicts of the segments with the given content ids",
+		Request:  SyncFetchRequest{},
+		Response: []syncSegment{},
+		Errors:   []int{400, 500},
+	},
+	"POST /sync/push": {
+		Summary:  "Apply the changes of sync to the segments whose fields still have the base hashes; the others are conflicts",
+		Request:  SyncPushRequest{},
+		Response: syncResult{},
+		Errors:   []int{400, 500},
+	},
+	"GET /files/*/segments": {
+		Summary:  "Original and translation of every segment of a file, in reading order",
+		Params:   []apiParam{{Name: "path", In: "path", Description: "path of the file in the content directory"}},
+		Response: fileSegmentsResponse{},
+		Errors:   []int{400, 404, 500},
+	},
+	"PUT /segments/:id": {
+		Summary: "Replace or add the translation of the segment with the given content id",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "content id of the segment"},
+			{Name: "If-Match", In: "header", Description: "hash of the translation last read; the update fails with 412 if it changed"},
+		},
+		Request:  UpdateSegmentRequest{},
+		Response: fiber.Map{"segment": apiSegment{}, "removed": []string{}},
+		Errors:   []int{400, 404, 409, 412, 423, 500},
+	},
+	"GET /live": {
+		Summary:  "WebSocket sending a changed event with the files of the content directory changed by other programs than serve",
+		Response: liveReloadEvent{},
+		Errors:   []int{426},
+	},
+	"GET /segment-locks": {
+		Summary:  "Locked segments: those an AI batch is going to translate and those being edited",
+		Params:   []apiParam{{Name: "file_path", In: "query", Description: "path of the file in the content directory; all files if empty"}},
+		Response: []segmentLock{},
+	},
+	"POST /segment-lock": {
+		Summary:  "Lock a segment being edited against AI batches for two minutes, or renew the lock",
+		Request:  SegmentLockRequest{},
+		Response: segmentLock{},
+		Errors:   []int{400, 423},
+	},
+	"DELETE /segment-lock": {
+		Summary: "Release the edit lock of a segment",
+		Request: SegmentLockRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{400},
+	},
+	"GET /badge.svg": {
+		Summary:     "Badge showing the share of translated segments",
+		Params:      []apiParam{{Name: "label", In: "query", Description: `label of the badge, "translated" by default`}},
+		ContentType: "image/svg+xml",
+	},
+	"PATCH /update-translation": {
+		Summary: "Save an edited translation; markup outside the allow-list is removed",
+		Request: TranslateRequest{},
+		Response: fiber.Map{
+			"message":             "",
+			"translation_content": "",
+			"removed":             []string{},
+		},
+		Errors: []int{400, 404, 423, 500},
+	},
+	"POST /ai-translate": {
+		Summary:  "Translate a segment again with the --provider translator",
+		Request:  TranslateAIRequest{},
+		Response: fiber.Map{"translated_content": ""},
+		Errors:   []int{400, 402, 404, 423, 429, 500, 504},
+	},
+	"POST /ai-translate/stream": {
+		Summary:     `Like /ai-translate, streamed as server-sent events: "delta" events with {"text"} as the model produces it, then "done" with {"translated_content"} or "error" with {"error"}`,
+		Request:     TranslateAIRequest{},
+		ContentType: "text/event-stream",
+		Errors:      []int{400, 402, 404, 423, 429, 500},
+	},
+	"POST /speak": {
+		Summary:     "Read a segment, or its original, aloud with the --tts-provider of serve; the audio is MP3, WAV or Ogg depending on the provider",
+		Request:     SpeakRequest{},
+		ContentType: "audio/mpeg",
+		Errors:      []int{400, 404, 500, 502, 503, 504},
+	},
+	"POST /ai-translate-batch": {
+		Summary:  "Queue AI translations of the given segments, or of every untranslated segment of the file; they are written to the file as they are done",
+		Request:  TranslateBatchRequest{},
+		Response: aiBatchStatus{},
+		Status:   http.StatusAccepted,
+		Errors:   []int{400, 402, 404, 429},
+	},
+	"GET /spend": {
+		Summary:  "What the AI translations of every user cost in a month at list prices, with their budgets; tracked with --user-header only",
+		Params:   []apiParam{{Name: "month", In: "query", Description: "month as YYYY-MM, the current one by default"}},
+		Response: spendReport{},
+		Errors:   []int{400, 404},
+	},
+	"GET /ai-translate-batch/:id": {
+		Summary:  "Progress of a batch of AI translations",
+		Params:   []apiParam{{Name: "id", In: "path", Description: "batch id"}},
+		Response: aiBatchStatus{},
+		Errors:   []int{404},
+	},
+	"DELETE /ai-translate-batch/:id": {
+		Summary:  "Cancel a batch of AI translations, abandoning the segment being translated",
+		Params:   []apiParam{{Name: "id", In: "path", Description: "batch id"}},
+		Response: aiBatchStatus{},
+		Errors:   []int{404},
+	},
+	"GET /citation-mode": {
+		Summary:  "Citation mode of a file (auto, on or off) and whether auto mode finds a bibliography in it",
+		Params:   []apiParam{{Name: "file_path", In: "query", Description: "path of the file in the content directory"}},
+		Response: fiber.Map{"file_path": "", "mode": "", "detected": false},
+		Errors:   []int{404, 500},
+	},
+	"PUT /citation-mode": {
+		Summary:  "Set the citation mode of a file, in which titles, authors, DOIs and links of bibliography entries are left untranslated",
+		Request:  CitationModeRequest{},
+		Response: fiber.Map{"file_path": "", "mode": "", "detected": false},
+		Errors:   []int{400, 404, 500},
+	},
+	"GET /reviews": {
+		Summary:  "Review verdicts of one file by content id, or of the whole book by file#content id",
+		Params:   []apiParam{{Name: "file_path", In: "query", Description: "path of the file in the content directory; all files if empty"}},
+		Response: map[string]reviewAnnotation{},
+		Errors:   []int{500},
+	},
+	"PUT /review/:id": {
+		Summary:  "Approve or reject the translation of a segment, or remove the verdict with an empty status",
+		Params:   []apiParam{{Name: "id", In: "path", Description: "content id of the segment"}},
+		Request:  ReviewRequest{},
+		Response: reviewAnnotation{},
+		Errors:   []int{400, 404, 500},
+	},
+	"POST /share": {
+		Summary:  "Create a read-only share link for a chapter",
+		Request:  ShareRequest{},
+		Response: fiber.Map{"token": "", "url": ""},
+		Errors:   []int{400, 404, 500},
+	},
+	"GET /shares": {
+		Summary:  "Share links by token",
+		Response: map[string]shareLink{},
+		Errors:   []int{500},
+	},
+	"DELETE /share/:token": {
+		Summary:  "Revoke a share link",
+		Params:   []apiParam{{Name: "token", In: "path", Description: "share token"}},
+		Response: fiber.Map{"message": ""},
+		Errors:   []int{404, 500},
+	},
+}
+
+// registerOpenAPI serves the OpenAPI 3 description of the version 1 routes of
+// app on api.
+func registerOpenAPI(app *fiber.App, api fiber.Router) {
+	api.Get("/openapi.json", func(c *fiber.Ctx) error {
+		return c.JSON(openAPIDocument(app, bookBase(c)))
+	})
+}
+
+// openAPIDocument describes the version 1 routes registered on app for the
+// book served under base. Paths are relative to the apiV1 server URL of the
+// book, as are the keys of apiOperations.
+func openAPIDocument(app *fiber.App, base string) fiber.Map {
+	paths := map[string]fiber.Map{}
+	for _, route := range app.GetRoutes(true) {
+		rest, ok := strings.CutPrefix(route.Path, base+apiV1)
+		if !ok || !strings.HasPrefix(rest, "/") || route.Method == fiber.MethodHead {
+			continue
+		}
+
+		op, ok := apiOperations[route.Method+" "+rest]
+		if !ok {
+			op = apiOperation{Summary: "Undocumented"}
+		}
+
+		path := openAPIPath(rest)
+		if paths[path] == nil {
+			paths[path] = fiber.Map{}
+		}
+		paths[path][strings.ToLower(route.Method)] = openAPIOperation(route.Method, op)
+	}
+
+	return fiber.Map{
+		"openapi": "3.0.3",
+		"info": fiber.Map{
+			"title":       "epubtrans serve API",
+			"version":     Upgrade.Version,
+			"description": "Mutating requests from a browser must send the token of the epubtrans_csrf cookie in the X-CSRF-Token header.",
+		},
+		"servers": []fiber.Map{{"url": base + apiV1}},
+		"paths":   paths,
+		"components": fiber.Map{
+			"schemas": fiber.Map{
+				"Error": fiber.Map{
+					"type":       "object",
+					"properties": fiber.Map{"error": fiber.Map{"type": "string"}},
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath turns /api/share/:token into /api/share/{token}, and a
+// wildcard into {path}.
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if part == "*" {
+			parts[i] = "{path}"
+		} else if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + strings.TrimSuffix(part[1:], "?") + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func openAPIOperation(method string, op apiOperation) fiber.Map {
+	operation := fiber.Map{"summary": op.Summary}
+
+	if len(op.Params) > 0 {
+		params := make([]fiber.Map, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, fiber.Map{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Description,
+				"schema":      fiber.Map{"type": "string"},
+			})
+		}
+		operation["parameters"] = params
+	}
+
+	if op.Request != nil {
+		operation["requestBody"] = fiber.Map{
+			"required": true,
+			"content":  fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": jsonSchema(reflect.ValueOf(op.Request))}},
+		}
+	}
+
+	ok := fiber.Map{"description": "OK"}
+	switch {
+	case op.ContentType != "" && op.ContentType != fiber.MIMEApplicationJSON:
+		ok["content"] = fiber.Map{op.ContentType: fiber.Map{"schema": fiber.Map{"type": "string"}}}
+	case op.Response != nil:
+		ok["content"] = fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": jsonSchema(reflect.ValueOf(op.Response))}}
+	}
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok["description"] = http.StatusText(status)
+	responses := fiber.Map{strconv.Itoa(status): ok}
+
+	statuses := op.Errors
+	if method != fiber.MethodGet {
+		// Rejected by csrfProtection.
+		statuses = append(statuses[:len(statuses):len(statuses)], http.StatusForbidden)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = fiber.Map{
+			"description": http.StatusText(status),
+			"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": fiber.Map{"$ref": "#/components/schemas/Error"}}},
+		}
+	}
+	operation["responses"] = responses
+
+	return operation
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the JSON schema of v as encoding/json would marshal it.
+// The keys of maps with values, such as fiber.Map, become properties.
+func jsonSchema(v reflect.Value) fiber.Map {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fiber.Map{}
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Len() > 0 {
+		properties := fiber.Map{}
+		for _, key := range v.MapKeys() {
+			properties[key.String()] = jsonSchema(v.MapIndex(key))
+		}
+		return fiber.Map{"type": "object", "properties": properties}
+	}
+
+	return typeSchema(v.Type())
+}
+
+func typeSchema(t reflect.Type) fiber.Map {
+	if t == timeType {
+		return fiber.Map{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return fiber.Map{"type": "string"}
+	case reflect.Bool:
+		return fiber.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fiber.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return fiber.Map{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return fiber.Map{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return fiber.Map{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := fiber.Map{}
+		addStructProperties(t, properties)
+		return fiber.Map{"type": "object", "properties": properties}
+	default:
+		return fiber.Map{}
+	}
+}
+
+// addStructProperties adds the JSON fields of t, including those of embedded
+// structs, to properties.
+func addStructProperties(t reflect.Type, properties fiber.Map) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// Like encoding/json, flatten embedded structs even if unexported.
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
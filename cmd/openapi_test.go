@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestAPIOperationsDocumented fails when a serve endpoint is added without
+// documenting it in apiOperations.
+func TestAPIOperationsDocumented(t *testing.T) {
+	for key, file := range registeredAPIRoutes(t) {
+		if _, ok := apiOperations[key]; !ok {
+			t.Errorf("%s: %s is not documented in apiOperations", file, key)
+		}
+	}
+}
+
+// registeredAPIRoutes returns the files registering each "METHOD /path" route
+// of the API, found by scanning the sources for api.Get("/path", ...) calls.
+func registeredAPIRoutes(t *testing.T) map[string]string {
+	t.Helper()
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]string{}
+	route := regexp.MustCompile(`api\.(Get|Post|Put|Patch|Delete)\("(/[^"]*)"`)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range route.FindAllStringSubmatch(string(data), -1) {
+			routes[strings.ToUpper(m[1])+" "+m[2]] = file
+		}
+	}
+	if len(routes) == 0 {
+		t.Fatal("no API routes found")
+	}
+	return routes
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	app := fiber.New()
+	api := app.Group(apiV1)
+	api.Get("/provenance", func(c *fiber.Ctx) error { return nil })
+	api.Delete("/share/:token", func(c *fiber.Ctx) error { return nil })
+	app.Get("/api/v2/provenance", func(c *fiber.Ctx) error { return nil })
+	app.Get("/chapter.xhtml", func(c *fiber.Ctx) error { return nil })
+	registerOpenAPI(app, api)
+
+	paths := openAPIDocument(app, "")["paths"].(map[string]fiber.Map)
+	if len(paths) != 3 {
+		t.Errorf("paths = %v, want the three version 1 routes", paths)
+	}
+
+	del := paths["/share/{token}"]["delete"].(fiber.Map)
+	if _, ok := del["responses"].(fiber.Map)["403"]; !ok {
+		t.Error("mutating endpoint does not document the CSRF rejection")
+	}
+
+	// provenanceCount embeds provenance, whose fields must be flattened.
+	schema := paths["/provenance"]["get"].(fiber.Map)["responses"].(fiber.Map)["200"].(fiber.Map)["content"].(fiber.Map)[fiber.MIMEApplicationJSON].(fiber.Map)["schema"].(fiber.Map)
+	properties := schema["items"].(fiber.Map)["properties"].(fiber.Map)
+	for _, name := range []string{"provider", "model", "prompt_version", "translations", "files"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("provenance schema lacks %s: %v", name, properties)
+		}
+	}
+}
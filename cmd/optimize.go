@@ -0,0 +1,328 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	_ "image/gif"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+const (
+	// optimizeImageMinSize is the size above which an image is worth recompressing.
+	optimizeImageMinSize = 256 * 1024
+	optimizeJPEGQuality  = 80
+)
+
+// packOptimizer shrinks the packed book: it drops manifest items nothing refers
+// to and recompresses oversized images. The unpacked directory is left untouched.
+type packOptimizer struct {
+	opfPath       string
+	unusedIDs     []string
+	unused        map[string]bool
+	maxImageWidth int
+
+	savedBytes   int64
+	removedFiles int
+	images       int
+}
+
+func newPackOptimizer(srcDir string, maxImageWidth int) (*packOptimizer, error) {
+	book, err := openBookFiles(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	opf, err := os.ReadFile(book.opfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var unusedIDs []string
+	unusedPaths := make(map[string]bool)
+	for _, item := range findUnusedItems(book.pkg, book.contentDir, string(opf)) {
+		unusedIDs = append(unusedIDs, item.ID)
+		unusedPaths[filepath.Join(book.contentDir, item.Href)] = true
+	}
+
+	return &packOptimizer{
+		opfPath:       book.opfPath,
+		unusedIDs:     unusedIDs,
+		unused:        unusedPaths,
+		maxImageWidth: maxImageWidth,
+	}, nil
+}
+
+// skip reports whether filePath is an unused manifest item to leave out of the book.
+func (o *packOptimizer) skip(filePath string, size int64) bool {
+	if !o.unused[filePath] {
+		return false
+	}
+
+	o.savedBytes += size
+	o.removedFiles++
+	fmt.Printf("Removed unused file: %s\n", filepath.Base(filePath))
+	return true
+}
+
+// transform returns the optimized content of filePath, read from the file
+// unless content is given, or nil to keep the file as is.
+func (o *packOptimizer) transform(filePath string, content []byte) []byte {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if filePath != o.opfPath && ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+		return nil
+	}
+
+	data := content
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(filePath); err != nil {
+			return nil
+		}
+	}
+
+	if filePath == o.opfPath {
+		opf := string(data)
+		for _, id := range o.unusedIDs {
+			opf = removeTag(opf, "item", "id", id)
+		}
+		return []byte(opf)
+	}
+
+	optimized, ok := optimizeImage(data, ext, o.maxImageWidth)
+	if !ok {
+		return nil
+	}
+
+	o.savedBytes += int64(len(data) - len(optimized))
+	o.images++
+	fmt.Printf("Recompressed image: %s (%.2f KB -> %.2f KB)\n", filepath.Base(filePath),
+		float64(len(data))/1024, float64(len(optimized))/1024)
+	return optimized
+}
+
+func (o *packOptimizer) report() {
+	fmt.Printf("Optimization: %d images recompressed, %d unused files removed, %.2f KB saved\n",
+		o.images, o.removedFiles, float64(o.savedBytes)/1024)
+}
+
+// optimizeImage downscales images wider than maxWidth and re-encodes large
+// ones. It returns false if the result would not be smaller.
+func optimizeImage(data []byte, ext string, maxWidth int) ([]byte, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	tooWide := maxWidth > 0 && cfg.Width > maxWidth
+	if !tooWide && len(data) < optimizeImageMinSize {
+		return nil, false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	if tooWide {
+		img = downscaleImage(img, maxWidth)
+	}
+
+	var buf bytes.Buffer
+	if ext == ".png" {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: optimizeJPEGQuality})
+	}
+
+	if err != nil || buf.Len() >= len(data) {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
+
+// downscaleImage resizes img to the given width with a box filter, keeping the aspect ratio.
+func downscaleImage(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	height := max(1, src.Dy()*width/src.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+var (
+	referenceAttrRegex = regexp.MustCompile(`(?:href|src|poster|data)\s*=\s*["']([^"']+)["']`)
+	cssURLRegex        = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)|@import\s+["']([^"']+)["']`)
+	// opfTagRegex and opfAttrRegex match the tags of a package document and
+	// their attributes.
+	opfTagRegex  = regexp.MustCompile(`<(?:opf:)?(meta|item|reference|link)\b([^>]*)>`)
+	opfAttrRegex = regexp.MustCompile(`([\w:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// findUnusedItems returns the manifest items that cannot be reached from the
+// spine, the navigation documents, the cover or the guide.
+func findUnusedItems(pkg *loader.Package, contentDir, opf string) []loader.Item {
+	byPath := make(map[string]loader.Item, len(pkg.Manifest.Items))
+	for _, item := range pkg.Manifest.Items {
+		byPath[filepath.Join(contentDir, item.Href)] = item
+	}
+
+	var queue []string
+	visit := func(filePath string) {
+		if _, ok := byPath[filePath]; ok {
+			queue = append(queue, filePath)
+		}
+	}
+
+	for _, ref := range pkg.Spine.ItemRefs {
+		if item := pkg.Manifest.GetItemByID(ref.IDRef); item != nil {
+			visit(filepath.Join(contentDir, item.Href))
+		}
+	}
+	if item := pkg.Manifest.GetItemByID(pkg.Spine.Toc); item != nil {
+		visit(filepath.Join(contentDir, item.Href))
+	}
+	for _, item := range pkg.Manifest.Items {
+		if strings.Contains(item.Properties, "nav") || strings.Contains(item.Properties, "cover-image") {
+			visit(filepath.Join(contentDir, item.Href))
+		}
+	}
+	ids, hrefs := opfReferences(opf)
+	for _, id := range ids {
+		if item := pkg.Manifest.GetItemByID(id); item != nil {
+			visit(filepath.Join(contentDir, item.Href))
+		}
+	}
+	for _, href := range hrefs {
+		visit(filepath.Join(contentDir, href))
+	}
+
+	reached := make(map[string]bool)
+	for len(queue) > 0 {
+		filePath := queue[0]
+		queue = queue[1:]
+		if reached[filePath] {
+			continue
+		}
+		reached[filePath] = true
+
+		for _, ref := range fileReferences(filePath) {
+			visit(ref)
+		}
+	}
+
+	var unused []loader.Item
+	for _, item := range pkg.Manifest.Items {
+		if !reached[filepath.Join(contentDir, item.Href)] {
+			unused = append(unused, item)
+		}
+	}
+
+	return unused
+}
+
+// opfReferences returns what the package document opf refers to besides its
+// spine and table of contents: the IDs of the items named by the cover meta
+// and by the fallback and media-overlay of other items, and the local files
+// of the guide and of the links of the metadata, relative to the package.
+func opfReferences(opf string) (ids, hrefs []string) {
+	for _, tag := range opfTagRegex.FindAllStringSubmatch(opf, -1) {
+		attrs := make(map[string]string)
+		for _, m := range opfAttrRegex.FindAllStringSubmatch(tag[2], -1) {
+			attrs[m[1]] = html.UnescapeString(m[2] + m[3])
+		}
+
+		switch tag[1] {
+		case "meta":
+			if attrs["name"] == "cover" && attrs["content"] != "" {
+				ids = append(ids, attrs["content"])
+			}
+		case "item":
+			for _, attr := range []string{"fallback", "media-overlay"} {
+				if attrs[attr] != "" {
+					ids = append(ids, attrs[attr])
+				}
+			}
+		case "reference", "link":
+			ref, _, _ := strings.Cut(attrs["href"], "#")
+			if ref == "" || strings.Contains(ref, ":") {
+				continue
+			}
+			if unescaped, err := url.PathUnescape(ref); err == nil {
+				ref = unescaped
+			}
+			hrefs = append(hrefs, ref)
+		}
+	}
+	return ids, hrefs
+}
+
+// fileReferences returns the local files referenced by a markup or CSS file.
+func fileReferences(filePath string) []string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	switch ext {
+	case ".xhtml", ".html", ".htm", ".ncx", ".svg", ".css", ".xml":
+	default:
+		return nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+
+	var refs []string
+	add := func(ref string) {
+		ref, _, _ = strings.Cut(ref, "#")
+		ref, _, _ = strings.Cut(ref, "?")
+		if ref == "" || strings.Contains(ref, ":") {
+			return
+		}
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+		refs = append(refs, filepath.Join(filepath.Dir(filePath), ref))
+	}
+
+	for _, m := range referenceAttrRegex.FindAllStringSubmatch(string(content), -1) {
+		add(m[1])
+	}
+	for _, m := range cssURLRegex.FindAllStringSubmatch(string(content), -1) {
+		add(m[1] + m[2])
+	}
+
+	return refs
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFindUnusedItems(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Unused")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Unused</dc:title>
+    <meta content='cover-jpg' name='cover'/>
+    <link rel="record" href="record.xml" media-type="application/xml"/>
+  </metadata>
+  <manifest>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml" media-overlay="ch1-smil"/>
+    <item id="ch1-smil" href="Audio/ch1.smil" media-type="application/smil+xml"/>
+    <item id="cover-jpg" href="Images/cover.jpg" media-type="image/jpeg"/>
+    <item id="colophon" href="Text/colophon.xhtml" media-type="application/xhtml+xml"/>
+    <item id="chart" href="Images/chart.svg" media-type="image/svg+xml" fallback="chart-png"/>
+    <item id="chart-png" href="Images/chart.png" media-type="image/png"/>
+    <item id="record" href="record.xml" media-type="application/xml"/>
+    <item id="orphan" href="Images/orphan.png" media-type="image/png"/>
+  </manifest>
+  <spine><itemref idref="ch1"/></spine>
+  <guide><reference type="colophon" title="Colophon" href="Text/colophon.xhtml#c"/></guide>
+</package>`,
+		"Text/ch1.xhtml":      `<html><body><img src="../Images/chart.svg" alt=""/></body></html>`,
+		"Text/colophon.xhtml": `<html><body><p>Colophon</p></body></html>`,
+		"Audio/ch1.smil":      `<smil/>`,
+		"Images/cover.jpg":    "jpeg",
+		"Images/chart.svg":    `<svg/>`,
+		"Images/chart.png":    "png",
+		"Images/orphan.png":   "png",
+		"record.xml":          `<record/>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "OEBPS", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unused []string
+	for _, item := range findUnusedItems(book.pkg, book.contentDir, files["content.opf"]) {
+		unused = append(unused, item.ID)
+	}
+	// Items only the package refers to are used too.
+	if !slices.Equal(unused, []string{"orphan"}) {
+		t.Errorf("findUnusedItems() = %v, want [orphan]", unused)
+	}
+}
+
+func TestPackOptimizedHeadingTitles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeLibraryBook(t, dir, "Optimized")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Optimized</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="orphan" href="Images/orphan.png" media-type="image/png"/>
+  </manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`,
+		"nav.xhtml": `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="Text/ch1.xhtml">Chapter 1</a></li></ol></nav>
+</body></html>`,
+		"Text/ch1.xhtml":    `<html><body><h1>The Storm</h1></body></html>`,
+		"Images/orphan.png": "png",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "OEBPS", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As pack --optimize --heading-titles --bilingual-toc does.
+	replaced, _, _, err := headingTOCTitles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tocFiles, err := generateBilingualTOC(dir, replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range tocFiles {
+		replaced[path] = content
+	}
+	optimizer, err := newPackOptimizer(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	if err := packFiles(dir, epubPath, optimizer, nil, replaced); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	packed := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		packed[f.Name] = string(content)
+	}
+
+	if nav := packed["OEBPS/nav.xhtml"]; !strings.Contains(nav, `<a href="Text/ch1.xhtml">The Storm</a>`) {
+		t.Errorf("packed nav document is not titled after the heading:\n%s", nav)
+	}
+	if _, ok := packed["OEBPS/Images/orphan.png"]; ok {
+		t.Error("the unused image was packed")
+	}
+	opf := packed["OEBPS/content.opf"]
+	if strings.Contains(opf, `id="orphan"`) {
+		t.Errorf("packed content.opf keeps the unused item:\n%s", opf)
+	}
+	if !strings.Contains(opf, `<itemref idref="`+bilingualTOCID+`"/>`) {
+		t.Errorf("packed content.opf lacks the bilingual table of contents:\n%s", opf)
+	}
+}
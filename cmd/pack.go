@@ -2,13 +2,16 @@ package cmd
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/dutchsteven/epubtrans/pkg/util"
 	"github.com/spf13/cobra"
@@ -39,15 +42,113 @@ This command is useful after modifying the contents of an unpacked EPUB.`,
 
 func init() {
 	Pack.Flags().StringP("output", "o", "", "output file path")
+	Pack.Flags().Bool("bilingual-toc", false, "insert a table of contents page with original and translated titles at the front of the book")
+	Pack.Flags().Bool("heading-titles", false, "title the table of contents entries that are missing a title or only number the chapter after the first heading of their document, as translated, and add entries for the chapters it leaves out; the unpacked book is left as it is")
+	Pack.Flags().String("mode", packBilingual, "bilingual keeps both languages, translated leaves out the translated originals, original leaves out the translations")
+	Pack.Flags().Bool("validate", false, "check the packed EPUB for structural problems and fail if it has any")
+	Pack.Flags().Bool("optimize", false, "recompress oversized images and leave out unused manifest items")
+	Pack.Flags().Int("max-image-width", 1600, "with --optimize, downscale images wider than this many pixels (0 to keep the size)")
 }
 
 func runPack(cmd *cobra.Command, args []string) error {
 	srcDir := args[0]
 	outputPath, _ := cmd.Flags().GetString("output")
-	return packFiles(srcDir, outputPath)
+	bilingualTOC, _ := cmd.Flags().GetBool("bilingual-toc")
+	headingTitles, _ := cmd.Flags().GetBool("heading-titles")
+	mode, _ := cmd.Flags().GetString("mode")
+	validate, _ := cmd.Flags().GetBool("validate")
+	optimize, _ := cmd.Flags().GetBool("optimize")
+	maxImageWidth, _ := cmd.Flags().GetInt("max-image-width")
+
+	var filter *languageFilter
+	if mode != packBilingual {
+		var err error
+		filter, err = newLanguageFilter(srcDir, mode)
+		if err != nil {
+			return err
+		}
+		if outputPath == "" {
+			outputPath = srcDir + "-" + mode + ".epub"
+		}
+	}
+
+	// The titled table of contents goes into the package only.
+	var replaced map[string][]byte
+	if headingTitles {
+		var titled, added int
+		var err error
+		replaced, titled, added, err = headingTOCTitles(srcDir)
+		if err != nil {
+			return fmt.Errorf("failed to title the table of contents: %w", err)
+		}
+		fmt.Printf("Titled %d table of contents entries after their headings and added %d for the chapters it missed\n", titled, added)
+	}
+
+	if bilingualTOC {
+		files, err := generateBilingualTOC(srcDir, replaced)
+		if err != nil {
+			return fmt.Errorf("failed to generate bilingual table of contents: %w", err)
+		}
+		if replaced == nil {
+			replaced = make(map[string][]byte)
+		}
+		for path, content := range files {
+			replaced[path] = content
+		}
+		fmt.Println("Added a bilingual table of contents to the front of the book")
+	}
+
+	// Page-number citations only resolve when the page list still leads to
+	// the page breaks.
+	if book, err := openBookFiles(srcDir); err == nil {
+		broken, err := brokenPageTargets(book)
+		if err != nil {
+			return err
+		}
+		if len(broken) > 0 {
+			fmt.Printf("Warning: %d entries of the page list lead nowhere, e.g. %s; run validate for the full list\n", len(broken), broken[0])
+		}
+	}
+
+	var optimizer *packOptimizer
+	if optimize {
+		var err error
+		optimizer, err = newPackOptimizer(srcDir, maxImageWidth)
+		if err != nil {
+			return fmt.Errorf("failed to prepare optimization: %w", err)
+		}
+	}
+
+	if err := warnStale(srcDir); err != nil {
+		return err
+	}
+	if outputPath == "" {
+		outputPath = srcDir + defaultSuffix
+	}
+	outputPath = getUniqueFilename(outputPath)
+	if err := packFiles(srcDir, outputPath, optimizer, filter, replaced); err != nil {
+		return err
+	}
+	if validate {
+		problems, err := checkArchive(outputPath)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("the packed book %s has %d problems", outputPath, len(problems))
+		}
+		fmt.Println("The packed book has no structural problems")
+	}
+	return takeSnapshot(srcDir, "pack")
 }
 
-func packFiles(srcDir string, outputPath string) error {
+// packFiles zips srcDir into outputPath. A non-nil optimizer shrinks the content on the way,
+// a non-nil filter leaves out one of the languages, and replaced gives the content to pack
+// instead of that of the files at its paths.
+func packFiles(srcDir string, outputPath string, optimizer *packOptimizer, filter *languageFilter, replaced map[string][]byte) error {
 	if outputPath == "" {
 		outputPath = getUniqueFilename(srcDir + defaultSuffix)
 	} else {
@@ -96,7 +197,15 @@ func packFiles(srcDir string, outputPath string) error {
 		}
 	}()
 
+	// Reading systems expect the mimetype first and uncompressed, whatever
+	// order the directory lists it in.
+	mimetypePath := filepath.Join(srcDir, "mimetype")
+	if info, err := os.Stat(mimetypePath); err == nil {
+		fileInfoChan <- fileInfo{path: mimetypePath, relPath: "mimetype", info: info}
+	}
+
 	// Walk the directory and send file info to the channel
+	packed := make(map[string]bool)
 	err = filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("error walking directory: %w", err)
@@ -105,16 +214,60 @@ func packFiles(srcDir string, outputPath string) error {
 		if info.IsDir() {
 			return nil // Skip directories
 		}
+		if info.Name() == bookCacheFile || filePath == mimetypePath {
+			return nil
+		}
 
 		relPath, err := filepath.Rel(srcDir, filePath)
 		if err != nil {
 			return fmt.Errorf("failed to get relative path: %w", err)
 		}
 
-		fileInfoChan <- fileInfo{path: filePath, relPath: relPath, info: info}
+		packed[filePath] = true
+		fi := fileInfo{path: filePath, relPath: relPath, info: info, data: replaced[filePath]}
+		if optimizer != nil {
+			if optimizer.skip(filePath, info.Size()) {
+				return nil
+			}
+			if data := optimizer.transform(filePath, fi.data); data != nil {
+				fi.data = data
+			}
+		}
+		if filter != nil {
+			data, err := filter.transform(filePath, fi.data)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				fi.data = data
+			}
+		}
+
+		fileInfoChan <- fi
 		return nil
 	})
 
+	// Files made for the package alone, such as the bilingual table of
+	// contents, have no copy on disk.
+	if err == nil {
+		var added []string
+		for filePath := range replaced {
+			if !packed[filePath] {
+				added = append(added, filePath)
+			}
+		}
+		sort.Strings(added)
+		for _, filePath := range added {
+			relPath, relErr := filepath.Rel(srcDir, filePath)
+			if relErr != nil {
+				err = fmt.Errorf("failed to get relative path: %w", relErr)
+				break
+			}
+			data := replaced[filePath]
+			fileInfoChan <- fileInfo{path: filePath, relPath: relPath, info: packedFileInfo{name: filepath.Base(filePath), size: int64(len(data))}, data: data}
+		}
+	}
+
 	close(fileInfoChan)
 	wg.Wait()
 
@@ -126,6 +279,13 @@ func packFiles(srcDir string, outputPath string) error {
 		return fmt.Errorf("failed to pack files: %w", err)
 	}
 
+	if optimizer != nil {
+		optimizer.report()
+	}
+	if filter != nil {
+		filter.report()
+	}
+
 	fmt.Printf("\nZip creation complete:\n")
 	fmt.Printf("Total files: %d\n", progress.fileCount)
 	fmt.Printf("Total size: %.2f MB\n", float64(progress.totalSize)/(1024*1024))
@@ -138,8 +298,23 @@ type fileInfo struct {
 	path    string
 	relPath string
 	info    os.FileInfo
+	// data replaces the file content when set
+	data []byte
 }
 
+// packedFileInfo describes a file that is packed without being on disk.
+type packedFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi packedFileInfo) Name() string       { return fi.name }
+func (fi packedFileInfo) Size() int64        { return fi.size }
+func (fi packedFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi packedFileInfo) ModTime() time.Time { return time.Now() }
+func (fi packedFileInfo) IsDir() bool        { return false }
+func (fi packedFileInfo) Sys() any           { return nil }
+
 type packingProgress struct {
 	fileCount int64
 	totalSize int64
@@ -155,7 +330,7 @@ func addFileToZip(zipWriter *zip.Writer, fi fileInfo, progress *packingProgress)
 	if err != nil {
 		return fmt.Errorf("failed to create file header: %w", err)
 	}
-	zipFileHeader.Name = fi.relPath
+	zipFileHeader.Name = filepath.ToSlash(fi.relPath)
 	zipFileHeader.Method = chooseCompressionMethod(fi.path)
 
 	writer, err := zipWriter.CreateHeader(zipFileHeader)
@@ -163,6 +338,15 @@ func addFileToZip(zipWriter *zip.Writer, fi fileInfo, progress *packingProgress)
 		return fmt.Errorf("failed to create zip entry: %w", err)
 	}
 
+	if fi.data != nil {
+		if _, err := io.Copy(writer, bytes.NewReader(fi.data)); err != nil {
+			return fmt.Errorf("failed to write file to zip: %w", err)
+		}
+
+		progress.update(int64(len(fi.data)))
+		return nil
+	}
+
 	file, err := os.Open(fi.path)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
@@ -179,6 +363,10 @@ func addFileToZip(zipWriter *zip.Writer, fi fileInfo, progress *packingProgress)
 }
 
 func chooseCompressionMethod(filePath string) uint16 {
+	if filepath.Base(filePath) == "mimetype" {
+		return zip.Store
+	}
+
 	ext := strings.ToLower(filepath.Ext(filePath))
 
 	// Danh sách các định dạng file đã được nén hoặc không nén hiệu quả
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// Pack modes. Bilingual packs the book as it is; translated and original
+// leave out the other language, so one translated book gives three editions.
+const (
+	packBilingual  = "bilingual"
+	packTranslated = "translated"
+	packOriginal   = "original"
+)
+
+// noteSelector matches the popup and endnote asides holding translations.
+const noteSelector = `aside[id^="epubtrans-note-"]`
+
+// languageFilter leaves the originals or the translations out of the content
+// documents as they are packed. The unpacked book is not modified.
+type languageFilter struct {
+	mode string
+	// endnotes holds the translations placed as endnotes, by translation id.
+	endnotes map[string]string
+	removed  int
+}
+
+func newLanguageFilter(unzipPath, mode string) (*languageFilter, error) {
+	switch mode {
+	case packTranslated, packOriginal:
+	default:
+		return nil, fmt.Errorf("unknown mode %q: use bilingual, translated or original", mode)
+	}
+
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &languageFilter{mode: mode, endnotes: make(map[string]string)}
+
+	notesPath := filepath.Join(book.contentDir, endnotesFileName)
+	if _, err := os.Stat(notesPath); err != nil {
+		return f, nil
+	}
+	doc, err := openAndReadFile(notesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", notesPath, err)
+	}
+	doc.Find(noteSelector + " [" + util.TranslationIdKey + "]").Each(func(_ int, s *goquery.Selection) {
+		id, _ := s.Attr(util.TranslationIdKey)
+		if html, err := goquery.OuterHtml(s); err == nil {
+			f.endnotes[id] = html
+		}
+	})
+	return f, nil
+}
+
+// transform returns the content of filePath, read from the file unless
+// content is given, in the language of the mode, or nil when it is not a
+// content document with segments.
+func (f *languageFilter) transform(filePath string, content []byte) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".xhtml", ".html", ".htm":
+	default:
+		return nil, nil
+	}
+
+	if content == nil {
+		var err error
+		if content, err = os.ReadFile(filePath); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Contains(content, []byte(util.TranslationIdKey)) && !bytes.Contains(content, []byte(util.TranslationByIdKey)) {
+		return nil, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filePath, err)
+	}
+
+	if f.mode == packOriginal {
+		f.removed += doc.Find("[" + util.TranslationIdKey + "]").Length()
+		doc.Find("[" + util.TranslationIdKey + "]").Remove()
+	} else {
+		doc.Find("[" + util.TranslationByIdKey + "]").Each(func(_ int, original *goquery.Selection) {
+			id, _ := original.Attr(util.TranslationByIdKey)
+			translation := doc.Find(fmt.Sprintf("[%s=%q]", util.TranslationIdKey, id)).First()
+			switch {
+			case translation.Length() > 0 && translation.Closest(noteSelector).Length() == 0:
+				// Inline: the translation follows the original.
+				original.Remove()
+			case translation.Length() > 0:
+				original.ReplaceWithSelection(translation.Clone())
+			case f.endnotes[id] != "":
+				original.ReplaceWithHtml(f.endnotes[id])
+			default:
+				// The translation is missing; keep the original.
+				return
+			}
+			f.removed++
+		})
+	}
+
+	// The notes and the links to them only make sense in the bilingual edition.
+	doc.Find(noteSelector).Remove()
+	doc.Find("a.epubtrans-noteref").Remove()
+	pruneSentenceTranslations(doc)
+
+	return serializeDocument(filePath, doc)
+}
+
+func (f *languageFilter) report() {
+	if f.mode == packOriginal {
+		fmt.Printf("Left out %d translations\n", f.removed)
+		return
+	}
+	fmt.Printf("Left out %d translated originals\n", f.removed)
+}
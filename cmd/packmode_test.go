@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLanguageFilter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeSyncBook(t, dir)
+	// d is placed as a popup footnote.
+	editSyncChapter(t, dir,
+		`<p data-content-id="d" data-translation-by-id="td">Two</p><p data-translation-id="td" data-translation-lang="de">Zwei</p>`,
+		`<p data-content-id="d" data-translation-by-id="td" id="two">Two<a epub:type="noteref" class="epubtrans-noteref" href="#epubtrans-note-td">*</a></p>`)
+	editSyncChapter(t, dir, `</body>`,
+		`<aside epub:type="footnote" id="epubtrans-note-td"><p data-translation-id="td" data-translation-lang="de" id="two">Zwei</p></aside></body>`)
+	chapter := filepath.Join(dir, "OEBPS", "ch1.xhtml")
+
+	tests := []struct {
+		mode           string
+		want, unwanted []string
+	}{
+		{packTranslated, []string{"Hallo", "World", "Eins", `id="two">Zwei</p>`}, []string{"Hello", ">One</p>", ">Two", "noteref", "<aside"}},
+		{packOriginal, []string{"Hello", "World", "One", "Two"}, []string{"Hallo", "Eins", "Zwei", "noteref", "<aside"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			filter, err := newLanguageFilter(dir, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := filter.transform(chapter, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(data), s) {
+					t.Errorf("%q missing from\n%s", s, data)
+				}
+			}
+			for _, s := range tt.unwanted {
+				if strings.Contains(string(data), s) {
+					t.Errorf("%q left in\n%s", s, data)
+				}
+			}
+			if filter.removed != 3 {
+				t.Errorf("removed %d, want 3", filter.removed)
+			}
+		})
+	}
+
+	if _, err := newLanguageFilter(dir, "both"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	filter, _ := newLanguageFilter(dir, packTranslated)
+	if data, err := filter.transform(filepath.Join(dir, "OEBPS", "content.opf"), nil); err != nil || data != nil {
+		t.Errorf("the package document was transformed: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Page breaks mark where the pages of the print edition begin, e.g.
+// <span epub:type="pagebreak" id="page_12" title="12"/>, and the page list of
+// the navigation document links to them, so that citations by page number
+// can be followed in the book. They stay in the original text only: clean
+// keeps them although they are empty, mark leaves them unmarked and segments
+// are translated without them, so they are neither lost nor duplicated.
+
+// pageTarget is an entry of the page list of the nav document or the NCX.
+type pageTarget struct {
+	Label string
+	// File is the path of the target file, Fragment the id in it.
+	File     string
+	Fragment string
+	// Source is the nav document or NCX listing the entry.
+	Source string
+}
+
+func (t pageTarget) String() string {
+	return fmt.Sprintf("page %q -> %s#%s", t.Label, filepath.Base(t.File), t.Fragment)
+}
+
+// pageListIDs holds the fragments the page list of the book being cleaned
+// links to.
+var pageListIDs map[string]bool
+
+func isPageBreakAttrs(epubType, role string) bool {
+	for _, t := range strings.Fields(epubType) {
+		if t == "pagebreak" {
+			return true
+		}
+	}
+	for _, r := range strings.Fields(role) {
+		if r == "doc-pagebreak" {
+			return true
+		}
+	}
+	return false
+}
+
+// isPageBreakNode reports whether n is a page break marker.
+func isPageBreakNode(n *html.Node) bool {
+	var epubType, role string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "epub:type":
+			epubType = attr.Val
+		case "role":
+			role = attr.Val
+		}
+	}
+	return isPageBreakAttrs(epubType, role)
+}
+
+func isPageBreak(i int, s *goquery.Selection) bool {
+	return isPageBreakAttrs(s.AttrOr("epub:type", ""), s.AttrOr("role", ""))
+}
+
+// withoutPageBreaks returns the inner HTML of a segment without its page
+// breaks, which is what is translated.
+func withoutPageBreaks(s *goquery.Selection) (string, error) {
+	if s.Find("*").FilterFunction(isPageBreak).Length() == 0 {
+		return s.Html()
+	}
+
+	clone := s.Clone()
+	clone.Find("*").FilterFunction(isPageBreak).Remove()
+	return clone.Html()
+}
+
+var (
+	epubTypeAttrRegex = regexp.MustCompile(`\sepub:type\s*=\s*"([^"]*)"`)
+	roleAttrRegex     = regexp.MustCompile(`\srole\s*=\s*"([^"]*)"`)
+	idAttrRegex       = regexp.MustCompile(`\sid\s*=\s*"([^"]*)"`)
+)
+
+// keepPageBreak is the replacement of an empty element that clean removes:
+// page breaks and targets of the page list are kept.
+func keepPageBreak(element string) string {
+	openingTag, _, _ := strings.Cut(element, ">")
+	attr := func(re *regexp.Regexp) string {
+		if m := re.FindStringSubmatch(openingTag); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+
+	if isPageBreakAttrs(attr(epubTypeAttrRegex), attr(roleAttrRegex)) {
+		return element
+	}
+	if id := attr(idAttrRegex); id != "" && pageListIDs[id] {
+		return element
+	}
+	return ""
+}
+
+var selfClosingRegex = regexp.MustCompile(`<([a-zA-Z][\w:-]*)(\s[^<>]*?)?\s*/>`)
+
+// voidElements are the HTML elements without end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// expandPageBreaks writes self-closing page breaks and page list targets,
+// e.g. <span epub:type="pagebreak" title="12"/>, with an end tag. The HTML
+// parser the other commands use ignores the slash and would make the text
+// that follows part of the page break.
+func expandPageBreaks(htmlContent string) string {
+	return selfClosingRegex.ReplaceAllStringFunc(htmlContent, func(element string) string {
+		m := selfClosingRegex.FindStringSubmatch(element)
+		if voidElements[strings.ToLower(m[1])] || keepPageBreak(element) == "" {
+			return element
+		}
+		return "<" + m[1] + m[2] + "></" + m[1] + ">"
+	})
+}
+
+// pageListTargets returns the page list of the EPUB 3 nav document and the
+// NCX of the book.
+func pageListTargets(book *bookFiles) ([]pageTarget, error) {
+	var targets []pageTarget
+
+	for _, item := range book.pkg.Manifest.Items {
+		isNav := strings.Contains(" "+item.Properties+" ", " nav ")
+		isNCX := item.MediaType == "application/x-dtbncx+xml"
+		if !isNav && !isNCX {
+			continue
+		}
+
+		source := filepath.Join(book.contentDir, item.Href)
+		doc, err := openAndReadFile(source)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		add := func(label, href string) {
+			file, fragment, _ := strings.Cut(href, "#")
+			target := source
+			if file != "" {
+				target = filepath.Join(filepath.Dir(source), file)
+			}
+			targets = append(targets, pageTarget{Label: strings.TrimSpace(label), File: target, Fragment: fragment, Source: source})
+		}
+
+		if isNav {
+			doc.Find("nav").FilterFunction(func(i int, s *goquery.Selection) bool {
+				return strings.Contains(" "+s.AttrOr("epub:type", "")+" ", " page-list ")
+			}).Find("a[href]").Each(func(i int, s *goquery.Selection) {
+				add(s.Text(), s.AttrOr("href", ""))
+			})
+		} else {
+			// The HTML parser lowercases the element names of the NCX.
+			doc.Find("pagelist pagetarget").Each(func(i int, s *goquery.Selection) {
+				add(s.Find("navlabel text").First().Text(), s.Find("content").AttrOr("src", ""))
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// brokenPageTargets returns the entries of the page list whose file or id
+// does not exist.
+func brokenPageTargets(book *bookFiles) ([]pageTarget, error) {
+	targets, err := pageListTargets(book)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := map[string]*goquery.Document{}
+	var broken []pageTarget
+	for _, t := range targets {
+		doc, ok := docs[t.File]
+		if !ok {
+			doc, _ = openAndReadFile(t.File)
+			docs[t.File] = doc
+		}
+
+		if doc == nil {
+			broken = append(broken, t)
+			continue
+		}
+		if t.Fragment != "" && doc.Find("[id]").FilterFunction(func(i int, s *goquery.Selection) bool {
+			return s.AttrOr("id", "") == t.Fragment
+		}).Length() == 0 {
+			broken = append(broken, t)
+		}
+	}
+
+	return broken, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestCleanKeepsPageBreaks(t *testing.T) {
+	pageListIDs = map[string]bool{"page_6": true}
+	defer func() { pageListIDs = nil }()
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"self-closing span", `a <span epub:type="pagebreak" id="page_5" title="5"/>b`, `a <span epub:type="pagebreak" id="page_5" title="5"></span>b`},
+		{"role", `<span role="doc-pagebreak" id="p7"/>`, `<span role="doc-pagebreak" id="p7"></span>`},
+		{"page list anchor", `<a id="page_6"/><p>x</p>`, `<a id="page_6"></a><p>x</p>`},
+		{"empty anchor", `<a id="note"/><p>x</p>`, `<p>x</p>`},
+		{"page break div", `<div epub:type="pagebreak" title="7"></div>`, `<div epub:type="pagebreak" title="7"></div>`},
+		{"empty div", `<div class="x"> </div>`, ``},
+		{"void element", `<br/><img src="a.png" role="doc-pagebreak"/>`, `<br/><img src="a.png" role="doc-pagebreak"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := removeEmptyDiv(removeEmptyAnchor(expandPageBreaks(tt.content)))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithoutPageBreaks(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<p id="a">Hello <span epub:type="pagebreak" title="5"></span>world <em>now</em></p><p id="b">Plain</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]string{"a": `Hello world <em>now</em>`, "b": `Plain`} {
+		got, err := withoutPageBreaks(doc.Find("#" + id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("withoutPageBreaks(#%s) = %q, want %q", id, got, want)
+		}
+	}
+	if doc.Find("[epub\\:type]").Length() != 1 {
+		t.Error("withoutPageBreaks removed the page break from the document")
+	}
+}
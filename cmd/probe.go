@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+)
+
+// Before translating, translate and serve send the provider a one word test
+// request, so a wrong API key, a misspelled model or an unreachable endpoint
+// shows up at once with a hint of what to fix, rather than on the first
+// segment minutes into a run. serve only warns, as it works without AI.
+
+// probeTimeout bounds the test request.
+const probeTimeout = 30 * time.Second
+
+// probeEnabled sends the test request; --probe=false skips it, e.g. offline
+// with a warm cache.
+var probeEnabled = true
+
+func init() {
+	Translate.Flags().BoolVar(&probeEnabled, "probe", true, "send the provider a test request before translating, to check the API key, the model and the connection")
+	Serve.Flags().BoolVar(&probeEnabled, "probe", true, "send the provider a test request at startup and warn if AI translation will not work")
+}
+
+// probeProvider checks that the provider of the run translates with model.
+// It uses a provider of its own without a cache, so a cached translation
+// cannot hide a failure.
+func probeProvider(ctx context.Context, model string) error {
+	name := translationProvider
+	if name == "" {
+		name = "anthropic"
+	}
+
+	provider, err := translator.New(name, &translator.Config{
+		Model:       model,
+		Temperature: translationSampling.Temperature,
+		TopP:        translationSampling.TopP,
+		Seed:        translationSampling.Seed,
+		MaxTokens:   64,
+		Cache:       translator.NoopCache{},
+		GlossaryID:  deepLGlossaryID,
+		Fixture:     mockFixture,
+	})
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "missing ") {
+			return fmt.Errorf("provider %s: %w; set it in the environment or choose another --provider", name, err)
+		}
+		return fmt.Errorf("provider %s: %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if err := translator.Probe(ctx, provider, sourceLanguage, targetLanguage); err != nil {
+		return fmt.Errorf("provider %s, model %s: %w", name, provider.Model(), err)
+	}
+	return nil
+}
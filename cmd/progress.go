@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status of a run or a file in the progress of translate.
+const (
+	progressRunning     = "running"
+	progressDone        = "done"
+	progressIncomplete  = "incomplete"
+	progressFailed      = "failed"
+	progressSkipped     = "skipped"
+	progressInterrupted = "interrupted"
+	progressPaused      = "paused"
+)
+
+// translateProgress records the progress of the running translation. Its
+// methods do nothing when it is nil, as for runs that are not tracked.
+var translateProgress *progressTracker
+
+// runProgress is the checkpoint of a translate run, kept as <dir>-progress.json
+// next to the unpacked book and rewritten after every batch.
+type runProgress struct {
+	Source   string                   `json:"source"`
+	Target   string                   `json:"target"`
+	Provider string                   `json:"provider"`
+	Model    string                   `json:"model"`
+	Status   string                   `json:"status"`
+	Started  time.Time                `json:"started"`
+	Updated  time.Time                `json:"updated"`
+	Files    map[string]*fileProgress `json:"files"`
+}
+
+// fileProgress is the progress of one content file, keyed by its path
+// relative to the unpacked book.
+type fileProgress struct {
+	Status string `json:"status"`
+	// Translated lists the content IDs translated by the run, in order.
+	Translated []string `json:"translated,omitempty"`
+	// Remaining counts the segments left untranslated when the file was finished.
+	Remaining int    `json:"remaining,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type progressTracker struct {
+	mu    sync.Mutex
+	root  string
+	path  string
+	state runProgress
+}
+
+func progressPath(unzipPath string) string {
+	return filepath.Clean(unzipPath) + "-progress.json"
+}
+
+// loadProgress reads the progress of the last translate run of the book.
+func loadProgress(unzipPath string) (*runProgress, error) {
+	data, err := os.ReadFile(progressPath(unzipPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var p runProgress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", progressPath(unzipPath), err)
+	}
+	if p.Files == nil {
+		p.Files = make(map[string]*fileProgress)
+	}
+	return &p, nil
+}
+
+// newProgressTracker starts tracking a run. With previous, the run continues
+// the previous run and keeps the progress of its files.
+func newProgressTracker(unzipPath string, run runProgress, previous *runProgress) (*progressTracker, error) {
+	run.Status = progressRunning
+	run.Started = time.Now()
+	run.Files = make(map[string]*fileProgress)
+	if previous != nil {
+		run.Started = previous.Started
+		run.Files = previous.Files
+	}
+
+	t := &progressTracker{root: unzipPath, path: progressPath(unzipPath), state: run}
+	return t, t.save()
+}
+
+// file returns the progress of the file at filePath, creating it if needed.
+// The caller holds t.mu.
+func (t *progressTracker) file(filePath string) *fileProgress {
+	key, err := filepath.Rel(t.root, filePath)
+	if err != nil {
+		key = filePath
+	}
+	key = filepath.ToSlash(key)
+
+	f, ok := t.state.Files[key]
+	if !ok {
+		f = &fileProgress{}
+		t.state.Files[key] = f
+	}
+	return f
+}
+
+// update changes the progress of a file and writes the checkpoint.
+func (t *progressTracker) update(filePath string, change func(f *fileProgress)) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	change(t.file(filePath))
+	if err := t.save(); err != nil {
+		fmt.Printf("Error writing progress: %v\n", err)
+	}
+}
+
+func (t *progressTracker) started(filePath string) {
+	t.update(filePath, func(f *fileProgress) {
+		f.Status, f.Reason = progressRunning, ""
+	})
+}
+
+func (t *progressTracker) translated(filePath string, contentIDs []string) {
+	if len(contentIDs) == 0 {
+		return
+	}
+	t.update(filePath, func(f *fileProgress) {
+		f.Translated = append(f.Translated, contentIDs...)
+	})
+}
+
+// finished records the end of a file: done when no segment is left,
+// incomplete when some batches failed.
+func (t *progressTracker) finished(filePath string, remaining int) {
+	t.update(filePath, func(f *fileProgress) {
+		f.Status, f.Remaining = progressDone, remaining
+		if remaining > 0 {
+			f.Status = progressIncomplete
+		}
+	})
+}
+
+func (t *progressTracker) skipped(filePath, reason string) {
+	t.update(filePath, func(f *fileProgress) {
+		f.Status, f.Reason = progressSkipped, reason
+	})
+}
+
+func (t *progressTracker) failed(filePath string, err error) {
+	t.update(filePath, func(f *fileProgress) {
+		f.Status, f.Reason = progressFailed, err.Error()
+	})
+}
+
+// isDone reports whether the file was finished without leftovers.
+func (t *progressTracker) isDone(filePath string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file(filePath).Status == progressDone
+}
+
+// finish records the end of the run: paused when ctxErr is errRunPaused,
+// interrupted when it is another error.
+func (t *progressTracker) finish(err, ctxErr error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case errors.Is(ctxErr, errRunPaused):
+		t.state.Status = progressPaused
+	case ctxErr != nil:
+		t.state.Status = progressInterrupted
+	case err != nil:
+		t.state.Status = progressFailed
+	default:
+		t.state.Status = progressDone
+	}
+	if err := t.save(); err != nil {
+		fmt.Printf("Error writing progress: %v\n", err)
+	}
+}
+
+// save writes the checkpoint through a temporary file, so a crash never
+// leaves half of it behind. The caller holds t.mu, or t is not shared yet.
+func (t *progressTracker) save() error {
+	t.state.Updated = time.Now()
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// summary counts the files of a run by status and the segments it translated.
+func (p *runProgress) summary() (files map[string]int, segments int) {
+	files = make(map[string]int)
+	for _, f := range p.Files {
+		files[f.Status]++
+		segments += len(f.Translated)
+	}
+	return files, segments
+}
+
+// printProgressSummary prints what the previous run, which is resumed, did.
+func printProgressSummary(p *runProgress) {
+	files, segments := p.summary()
+	fmt.Printf("Resuming the run started %s (%s), last updated %s\n",
+		p.Started.Format(time.DateTime), p.Status, p.Updated.Format(time.DateTime))
+
+	statuses := make([]string, 0, len(files))
+	for status := range files {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Printf("  %s: %d files\n", status, files[status])
+	}
+	fmt.Printf("  %d segments translated so far\n", segments)
+}
+
+// resumableProgress returns the progress of the run --resume continues, which
+// must have translated between the same languages.
+func resumableProgress(unzipPath, model string) (*runProgress, error) {
+	if len(retranslateWhere) > 0 {
+		return nil, fmt.Errorf("--resume cannot be combined with --retranslate-where, which changes finished files")
+	}
+
+	p, err := loadProgress(unzipPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no run to resume: %s does not exist; run translate without --resume", progressPath(unzipPath))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Source != sourceLanguage || p.Target != targetLanguage {
+		return nil, fmt.Errorf("the run to resume translated from %s to %s; pass the same --source and --target, or run without --resume", p.Source, p.Target)
+	}
+	if p.Provider != translationProvider || p.Model != model {
+		fmt.Printf("Note: the resumed run used %s %s, this run uses %s %s\n", p.Provider, p.Model, translationProvider, model)
+	}
+	return p, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgressTracker(t *testing.T) {
+	book := filepath.Join(t.TempDir(), "book")
+	chapter1 := filepath.Join(book, "OEBPS", "chapter1.xhtml")
+	chapter2 := filepath.Join(book, "OEBPS", "chapter2.xhtml")
+
+	tracker, err := newProgressTracker(book, runProgress{Source: "English", Target: "Vietnamese"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.started(chapter1)
+	tracker.translated(chapter1, []string{"a", "b"})
+	tracker.finished(chapter1, 0)
+	tracker.started(chapter2)
+	tracker.translated(chapter2, []string{"c"})
+	tracker.finish(nil, errors.New("interrupted"))
+
+	previous, err := loadProgress(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous.Status != progressInterrupted {
+		t.Errorf("run status = %q, want %q", previous.Status, progressInterrupted)
+	}
+	files, segments := previous.summary()
+	if files[progressDone] != 1 || files[progressRunning] != 1 || segments != 3 {
+		t.Errorf("summary() = %v, %d; want one done, one running file and 3 segments", files, segments)
+	}
+
+	resumed, err := newProgressTracker(book, runProgress{Source: "English", Target: "Vietnamese"}, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.isDone(chapter1) || resumed.isDone(chapter2) {
+		t.Errorf("isDone = %v, %v; want only chapter1 done", resumed.isDone(chapter1), resumed.isDone(chapter2))
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dutchsteven/epubtrans/pkg/glossary"
+	"github.com/dutchsteven/epubtrans/pkg/tts"
+	"github.com/spf13/cobra"
+)
+
+// pronunciationFile overrides the pronunciation lexicon of a book, see bookLexiconPath.
+var pronunciationFile string
+
+var Pronunciation = &cobra.Command{
+	Use:   "pronunciation",
+	Short: "Manage how names are pronounced when the book is read aloud",
+	Long: `The pronunciation lexicon of a book overrides how text to speech pronounces names and terms, such as
+character names that the voices get wrong. A pronunciation is a phonetic spelling ("her-MY-oh-nee"), or IPA between
+slashes ("/hɜːˈmaɪ.ə.ni/") for voices that support it; other voices read terms with IPA as they are written.
+
+The lexicon is kept next to the unpacked book as <unpacked-dir>-pronunciation.yaml, a mapping of term to pronunciation,
+or as <unpacked-dir>-pronunciation.csv with term,pronunciation rows; use --file for another location.`,
+}
+
+var pronunciationAdd = &cobra.Command{
+	Use:     "add [unpackedEpubPath] [term] [pronunciation]",
+	Short:   "Add a term or change its pronunciation",
+	Example: `epubtrans pronunciation add path/to/unpacked/epub Hermione "her-MY-oh-nee"`,
+	Args:    glossaryArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateLexicon(args[0], func(g *glossary.Glossary) error {
+			g.Add(args[1], args[2])
+			fmt.Printf("%s → %s\n", args[1], args[2])
+			return nil
+		})
+	},
+}
+
+var pronunciationList = &cobra.Command{
+	Use:   "list [unpackedEpubPath]",
+	Short: "List the terms of the lexicon",
+	Args:  glossaryArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := glossary.Load(bookLexiconPath(args[0]))
+		if err != nil {
+			return err
+		}
+
+		for _, t := range g.Terms() {
+			fmt.Printf("%s → %s\n", t.Source, t.Target)
+		}
+		return nil
+	},
+}
+
+var pronunciationRemove = &cobra.Command{
+	Use:   "remove [unpackedEpubPath] [term]",
+	Short: "Remove a term from the lexicon",
+	Args:  glossaryArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateLexicon(args[0], func(g *glossary.Glossary) error {
+			if !g.Remove(args[1]) {
+				return fmt.Errorf("term %q is not in the lexicon", args[1])
+			}
+			fmt.Printf("Removed %s\n", args[1])
+			return nil
+		})
+	},
+}
+
+func init() {
+	Pronunciation.PersistentFlags().StringVar(&pronunciationFile, "file", "", "lexicon file (.yaml, .yml or .csv) instead of the one next to the book")
+	Pronunciation.AddCommand(pronunciationAdd, pronunciationList, pronunciationRemove)
+}
+
+// bookLexiconPath returns the pronunciation lexicon of the unpacked book:
+// --file if given, else <dir>-pronunciation.yaml or .csv.
+func bookLexiconPath(unpackedEpubPath string) string {
+	if pronunciationFile != "" {
+		return pronunciationFile
+	}
+	return termsFilePath(unpackedEpubPath, "pronunciation")
+}
+
+// loadBookLexicon returns the pronunciation lexicon of the unpacked book, to
+// be applied with tts.WithLexicon.
+func loadBookLexicon(unpackedEpubPath string) (*tts.Lexicon, error) {
+	g, err := glossary.Load(bookLexiconPath(unpackedEpubPath))
+	if err != nil {
+		return nil, err
+	}
+
+	pronunciations := make(map[string]string, g.Len())
+	for _, t := range g.Terms() {
+		pronunciations[t.Source] = t.Target
+	}
+	return tts.NewLexicon(pronunciations), nil
+}
+
+// updateLexicon stores a lexicon in the term list format of glossaries.
+func updateLexicon(unpackedEpubPath string, update func(g *glossary.Glossary) error) error {
+	g, err := glossary.Load(bookLexiconPath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+	if err := update(g); err != nil {
+		return err
+	}
+	return g.Save(bookLexiconPath(unpackedEpubPath))
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// provenance records what produced a translation.
+type provenance struct {
+	Provider      string `json:"provider"`
+	Model         string `json:"model,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// Sampling holds the sampling settings, such as "temperature=0 seed=42".
+	Sampling string `json:"sampling,omitempty"`
+}
+
+var (
+	// runProvenance is the provenance of the translations made by the current run.
+	runProvenance provenance
+	// memoryProvenance marks translations reused from the translation memory.
+	memoryProvenance = provenance{Provider: "memory"}
+	// manualProvenance marks translations edited in serve.
+	manualProvenance = provenance{Provider: "manual"}
+)
+
+func (p provenance) String() string {
+	s := p.Provider
+	if s == "" {
+		s = "unknown"
+	}
+	if p.Model != "" {
+		s += "/" + p.Model
+	}
+	if p.PromptVersion != "" {
+		s += " (prompt " + p.PromptVersion + ")"
+	}
+	if p.Sampling != "" {
+		s += " [" + p.Sampling + "]"
+	}
+	return s
+}
+
+// apply marks a translated element with the provenance, replacing any earlier one.
+func (p provenance) apply(s *goquery.Selection) {
+	for _, attr := range []struct{ key, value string }{
+		{util.TranslationProviderKey, p.Provider},
+		{util.TranslationModelKey, p.Model},
+		{util.TranslationPromptVersionKey, p.PromptVersion},
+		{util.TranslationSamplingKey, p.Sampling},
+	} {
+		if attr.value == "" {
+			s.RemoveAttr(attr.key)
+		} else {
+			s.SetAttr(attr.key, attr.value)
+		}
+	}
+}
+
+// provenanceOf reads the provenance of a translated element. Translations
+// made before provenance was recorded have an empty provider.
+func provenanceOf(s *goquery.Selection) provenance {
+	return provenance{
+		Provider:      s.AttrOr(util.TranslationProviderKey, ""),
+		Model:         s.AttrOr(util.TranslationModelKey, ""),
+		PromptVersion: s.AttrOr(util.TranslationPromptVersionKey, ""),
+		Sampling:      s.AttrOr(util.TranslationSamplingKey, ""),
+	}
+}
+
+// provenanceCount is the number of translations with one provenance.
+type provenanceCount struct {
+	provenance
+	Translations int      `json:"translations"`
+	Files        []string `json:"files"`
+}
+
+// provenanceSummary counts the translations of the spine by provenance, most
+// frequent first.
+func provenanceSummary(unzipPath string) ([]provenanceCount, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[provenance]*provenanceCount{}
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			p := provenanceOf(s)
+			c, ok := counts[p]
+			if !ok {
+				c = &provenanceCount{provenance: p}
+				counts[p] = c
+			}
+			c.Translations++
+			if len(c.Files) == 0 || c.Files[len(c.Files)-1] != item.Href {
+				c.Files = append(c.Files, item.Href)
+			}
+		})
+	}
+
+	summary := make([]provenanceCount, 0, len(counts))
+	for _, c := range counts {
+		summary = append(summary, *c)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Translations != summary[j].Translations {
+			return summary[i].Translations > summary[j].Translations
+		}
+		return strings.Compare(summary[i].String(), summary[j].String()) < 0
+	})
+
+	return summary, nil
+}
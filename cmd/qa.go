@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dutchsteven/epubtrans/pkg/qa"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// qaRules lists the QA rule packs, files or directories of them, used on
+	// top of the built-in packs and those kept with the book.
+	qaRules []string
+	// qaPacks holds the rule packs of the language pair being translated.
+	qaPacks qa.Set
+)
+
+var QA = &cobra.Command{
+	Use:   "qa [unpackedEpubPath]",
+	Short: "Check the translations of a book against the QA rules of its language pair",
+	Long: `This command checks every translation of the book against rule packs of the mistakes its language pair is
+prone to, such as false friends ("actually" as "actualmente" in Spanish) and word-for-word translations of idioms.
+translate applies the same rules to every new translation and warns about the mistakes it finds. Stale
+translations, whose original changed after they were translated, are listed as problems too.
+
+Packs for English into Vietnamese, Spanish and German ship with epubtrans. Packs kept next to the book in
+<unpacked-dir>-qa/ and those given with --qa-rules are used too; see the README for their format.`,
+	Example: `epubtrans qa path/to/unpacked/epub --qa-rules my-rules.yaml`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runQA,
+}
+
+func init() {
+	QA.Flags().String("source", "English", "language of the original text")
+	QA.Flags().StringSliceVar(&qaRules, "qa-rules", nil, "QA rule pack, or directory of packs, to check translations with (repeatable)")
+}
+
+// loadQAPacks returns the built-in rule packs, those in <unpacked-dir>-qa/ and
+// those of --qa-rules that apply to translations from sourceLang into
+// targetLang, both language names or codes.
+func loadQAPacks(unpackedEpubPath, sourceLang, targetLang string) (qa.Set, error) {
+	var paths []string
+	if info, err := os.Stat(filepath.Clean(unpackedEpubPath) + "-qa"); err == nil && info.IsDir() {
+		paths = append(paths, filepath.Clean(unpackedEpubPath)+"-qa")
+	}
+	paths = append(paths, qaRules...)
+
+	packs, err := qa.Load(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return append(qa.Builtin(), packs...).For(tm.LanguageCode(sourceLang), tm.LanguageCode(targetLang)), nil
+}
+
+func runQA(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	source, _ := cmd.Flags().GetString("source")
+
+	entries, err := collectSegmentPairs(unzipPath, source)
+	if err != nil {
+		return err
+	}
+
+	// Translations carry their language, which may differ between runs.
+	packsByTarget := make(map[string]qa.Set)
+	found := 0
+	for _, e := range entries {
+		packs, ok := packsByTarget[e.TargetLanguage]
+		if !ok {
+			if packs, err = loadQAPacks(unzipPath, source, e.TargetLanguage); err != nil {
+				return err
+			}
+			packsByTarget[e.TargetLanguage] = packs
+			fmt.Printf("%s → %s: %d QA rules\n", source, e.TargetLanguage, packs.Rules())
+		}
+
+		translated := strings.TrimSpace(plainText(e.Target))
+		for _, f := range packs.Check(plainText(e.Source), translated) {
+			fmt.Printf("%s/%s: %s\n  %s\n", f.Pack, f.Rule, f.Message, snippet(translated, strings.ToLower(f.Match)))
+			found++
+		}
+	}
+
+	stale, err := staleTranslations(unzipPath)
+	if err != nil {
+		return err
+	}
+	for _, s := range stale {
+		fmt.Printf("stale: %s#%s\n  the original changed after it was translated\n", s.File, s.ContentID)
+		found++
+	}
+
+	if found > 0 {
+		return fmt.Errorf("found %d QA problems in %d translations", found, len(entries))
+	}
+	fmt.Printf("No QA problems found in %d translations\n", len(entries))
+	return nil
+}
+
+// checkQARules warns about every rule of qaPacks the translation breaks.
+func checkQARules(fileName, contentID, original, translation string) {
+	if len(qaPacks) == 0 {
+		return
+	}
+
+	for _, f := range qaPacks.Check(plainText(original), plainText(translation)) {
+		fmt.Printf("QA: %s in %s: %s\n", f.Rule, fileName, f.Message)
+		jobLog.Warn("QA rule broken", "file", fileName, "content_id", contentID, "pack", f.Pack, "rule", f.Rule, "match", f.Match)
+	}
+}
+
+// qaAttributeRegex finds the start of a human readable attribute value in translated markup.
+var qaAttributeRegex = regexp.MustCompile(`\s(alt|title|aria-label)\s*=\s*(["“”])`)
+
+var attributeNameRegex = regexp.MustCompile(`^[\w:-]+\s*=`)
+
+var attributeWhitespaceRegex = regexp.MustCompile(`[\r\n\t]+`)
+
+// fixTranslatedAttributes repairs alt, title and aria-label values written by the
+// model so they cannot break the XHTML: smart quotes used as delimiters are
+// straightened, raw quotes inside the value are escaped and line breaks are
+// collapsed. It returns the fixed markup and a description of every fix.
+func fixTranslatedAttributes(translated string) (string, []string) {
+	var sb strings.Builder
+	var issues []string
+
+	pos := 0
+	for {
+		loc := qaAttributeRegex.FindStringSubmatchIndex(translated[pos:])
+		if loc == nil {
+			break
+		}
+
+		name := translated[pos+loc[2] : pos+loc[3]]
+		openQuote := translated[pos+loc[4] : pos+loc[5]]
+		valueStart := pos + loc[1]
+
+		valueEnd, closeQuote := findAttributeValueEnd(translated, valueStart)
+		if valueEnd == -1 {
+			sb.WriteString(translated[pos:valueStart])
+			pos = valueStart
+			continue
+		}
+
+		value := translated[valueStart:valueEnd]
+		fixed := escapeAttributeValue(value)
+
+		if openQuote != `"` || closeQuote != `"` {
+			issues = append(issues, fmt.Sprintf("%s: smart quotes used as attribute delimiters", name))
+		}
+		if fixed != value {
+			issues = append(issues, fmt.Sprintf("%s: escaped quotes or line breaks in %q", name, value))
+		}
+
+		sb.WriteString(translated[pos : pos+loc[4]])
+		sb.WriteString(`"` + fixed + `"`)
+		pos = valueEnd + len(closeQuote)
+	}
+
+	sb.WriteString(translated[pos:])
+	return sb.String(), issues
+}
+
+// findAttributeValueEnd returns the position of the quote closing the value that
+// starts at from. A quote only closes the value if the tag ends or another
+// attribute starts right after it; any other quote is part of the value.
+func findAttributeValueEnd(s string, from int) (int, string) {
+	for i := from; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '"' || r == '“' || r == '”' {
+			rest := strings.TrimLeft(s[i+size:], " \t\r\n")
+			if rest == "" || strings.HasPrefix(rest, ">") || strings.HasPrefix(rest, "/>") || attributeNameRegex.MatchString(rest) {
+				return i, s[i : i+size]
+			}
+		}
+		i += size
+	}
+
+	return -1, ""
+}
+
+func escapeAttributeValue(value string) string {
+	value = attributeWhitespaceRegex.ReplaceAllString(value, " ")
+	value = strings.ReplaceAll(value, `"`, "&quot;")
+	value = strings.ReplaceAll(value, "<", "&lt;")
+	return value
+}
+
+// applyAttributeQA runs fixTranslatedAttributes and reports what it changed,
+// with the provenance of the translation, so recurring issues can be traced to
+// a model or prompt.
+func applyAttributeQA(translated, fileName string, origin provenance) string {
+	fixed, issues := fixTranslatedAttributes(translated)
+	for _, issue := range issues {
+		fmt.Printf("QA fixed attribute %s [%s]\n", issue, origin)
+		jobLog.Warn("QA fixed attribute", "file", fileName, "issue", issue, "provenance", origin.String())
+	}
+	return fixed
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixTranslatedAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		translated string
+		want       string
+		wantIssues int
+	}{
+		{
+			name:       "Clean markup is untouched",
+			translated: `<img src="a.png" alt="Một con mèo"/> và <abbr title="Tổ chức">TC</abbr>`,
+			want:       `<img src="a.png" alt="Một con mèo"/> và <abbr title="Tổ chức">TC</abbr>`,
+		},
+		{
+			name:       "Raw quotes inside the value",
+			translated: `<img alt="Anh ấy nói "xin chào" rồi đi" src="a.png"/>`,
+			want:       `<img alt="Anh ấy nói &quot;xin chào&quot; rồi đi" src="a.png"/>`,
+			wantIssues: 1,
+		},
+		{
+			name:       "Smart quotes as delimiters",
+			translated: `<abbr title=“Tổ chức”>TC</abbr>`,
+			want:       `<abbr title="Tổ chức">TC</abbr>`,
+			wantIssues: 1,
+		},
+		{
+			name:       "Line breaks inside the value",
+			translated: "<span aria-label=\"dòng một\ndòng hai\">x</span>",
+			want:       `<span aria-label="dòng một dòng hai">x</span>`,
+			wantIssues: 1,
+		},
+		{
+			name:       "Smart quotes inside the value are kept",
+			translated: `<img alt="“Trích dẫn”"/>`,
+			want:       `<img alt="“Trích dẫn”"/>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, issues := fixTranslatedAttributes(tt.translated)
+			if got != tt.want {
+				t.Errorf("fixTranslatedAttributes() = %q, want %q", got, tt.want)
+			}
+			if len(issues) != tt.wantIssues {
+				t.Errorf("fixTranslatedAttributes() issues = %v, want %d", issues, tt.wantIssues)
+			}
+		})
+	}
+}
+
+func TestLoadQAPacks(t *testing.T) {
+	unpacked := filepath.Join(t.TempDir(), "book")
+	if err := os.MkdirAll(unpacked+"-qa", 0755); err != nil {
+		t.Fatal(err)
+	}
+	pack := "name: House style\nsource: en\ntarget: vi\nrules:\n  - id: okay\n    target: okê\n    message: write \"được\"\n"
+	if err := os.WriteFile(filepath.Join(unpacked+"-qa", "style.yaml"), []byte(pack), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	packs, err := loadQAPacks(unpacked, "English", "Vietnamese")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 2 || packs[1].Name != "House style" {
+		t.Fatalf("loadQAPacks() = %d packs, want the built-in one and the book's", len(packs))
+	}
+
+	packs, err = loadQAPacks(unpacked, "English", "Thai")
+	if err != nil || len(packs) != 0 {
+		t.Errorf("loadQAPacks() for English → Thai = %d packs, %v", len(packs), err)
+	}
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/memory"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// A translation is orphaned when no segment points to it any more, as when
+// its original changed and was marked again under a new content id. Mark
+// links orphans placed after their original back to the unlinked segment of
+// the same element whose text is most alike.
+
+// minRecoverySimilarity is the score a segment needs to be given an orphan.
+const minRecoverySimilarity = 0.6
+
+var (
+	// markRecover runs the recovery pass after marking.
+	markRecover bool
+	// markMemoryPath is a translation memory to look up the originals of
+	// orphans in, so they are compared in the same language.
+	markMemoryPath string
+)
+
+func init() {
+	Mark.Flags().BoolVar(&markRecover, "recover", true, "link translations orphaned by re-marking back to their segments by text similarity")
+	Mark.Flags().StringVar(&markMemoryPath, "memory", "", "translation memory (.json or .tmx) to look up the originals of orphaned translations in")
+}
+
+// recoverBook runs the recovery pass over the XHTML documents of the book.
+func recoverBook(unzipPath string) error {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return err
+	}
+
+	originals := map[string]string{}
+	if markMemoryPath != "" {
+		m, err := tm.Load(markMemoryPath)
+		if err != nil {
+			return fmt.Errorf("loading translation memory: %w", err)
+		}
+		originals = originalsByTranslation(m)
+	}
+
+	recovered, left := 0, 0
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		filePath := filepath.Join(book.contentDir, item.Href)
+		fileLock := getFileLock(filePath)
+		fileLock.Lock()
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			fileLock.Unlock()
+			return fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		n, orphans := recoverOrphans(doc, originals)
+		if n > 0 {
+			err = writeContentToFile(filePath, doc)
+		}
+		fileLock.Unlock()
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", item.Href, err)
+		}
+		recovered += n
+		left += orphans - n
+	}
+
+	if recovered+left > 0 {
+		fmt.Printf("Recovered %d orphaned translations; %d left without a segment\n", recovered, left)
+	}
+	return nil
+}
+
+// originalsByTranslation returns the original text of every translation in m.
+func originalsByTranslation(m *memory.Memory) map[string]string {
+	originals := map[string]string{}
+	for _, e := range m.Entries() {
+		originals[segmentText(e.Target)] = segmentText(e.Source)
+	}
+	return originals
+}
+
+// segmentText returns the text of segment markup.
+func segmentText(markup string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(markup))
+	if err != nil {
+		return markup
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}
+
+// recoveryMatch is a possible link of an orphan to a segment.
+type recoveryMatch struct {
+	orphan, segment int
+	score           float64
+}
+
+// recoverOrphans links the orphaned translations of doc placed after their
+// originals to unlinked segments, best matches first, and returns how many
+// it linked out of how many orphans there were. originals holds the original
+// texts of translations, when known.
+func recoverOrphans(doc *goquery.Document, originals map[string]string) (int, int) {
+	linked := map[string]bool{}
+	doc.Find(fmt.Sprintf("[%s]", util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+		linked[s.AttrOr(util.TranslationByIdKey, "")] = true
+	})
+
+	var orphans []*goquery.Selection
+	doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+		// Notes are reached through a link in their segment, which re-marking
+		// keeps, so only translations following their original are lost.
+		if !linked[s.AttrOr(util.TranslationIdKey, "")] && s.Closest("aside").Length() == 0 {
+			orphans = append(orphans, s)
+		}
+	})
+	if len(orphans) == 0 {
+		return 0, 0
+	}
+	segments := doc.Find(fmt.Sprintf("[%s]:not([%s])", util.ContentIdKey, util.TranslationByIdKey))
+
+	var matches []recoveryMatch
+	for i, orphan := range orphans {
+		text := strings.Join(strings.Fields(orphan.Text()), " ")
+		original, known := originals[text]
+		segments.Each(func(j int, segment *goquery.Selection) {
+			// Translations are copies of their original element.
+			if goquery.NodeName(segment) != goquery.NodeName(orphan) {
+				return
+			}
+
+			segmentText := strings.Join(strings.Fields(segment.Text()), " ")
+			var score float64
+			if known {
+				score = 0.3*lengthRatio(original, segmentText) + 0.7*dice(trigrams(original), trigrams(segmentText))
+			} else {
+				score = 0.3*lengthRatio(text, segmentText) + 0.7*anchorSimilarity(text, segmentText)
+			}
+			if orphan.Prev().IsSelection(segment) {
+				score += 0.25
+			}
+			if score >= minRecoverySimilarity {
+				matches = append(matches, recoveryMatch{orphan: i, segment: j, score: score})
+			}
+		})
+	}
+
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score > matches[b].score })
+	usedOrphans, usedSegments := map[int]bool{}, map[int]bool{}
+	for _, m := range matches {
+		if usedOrphans[m.orphan] || usedSegments[m.segment] {
+			continue
+		}
+		usedOrphans[m.orphan], usedSegments[m.segment] = true, true
+
+		orphan, segment := orphans[m.orphan], segments.Eq(m.segment)
+		segment.SetAttr(util.TranslationByIdKey, orphan.AttrOr(util.TranslationIdKey, ""))
+		if !orphan.Prev().IsSelection(segment) {
+			segment.AfterSelection(orphan)
+		}
+	}
+
+	return len(usedOrphans), len(orphans)
+}
+
+// anchorPattern finds the words of a text and the ends of its sentences.
+var anchorPattern = regexp.MustCompile(`[\p{L}\p{N}]+|[.!?]`)
+
+// anchors returns the words of text that survive translation: numbers and
+// capitalized words within a sentence, such as names.
+func anchors(text string) map[string]bool {
+	set := map[string]bool{}
+	sentenceStart := true
+	for _, word := range anchorPattern.FindAllString(text, -1) {
+		if word == "." || word == "!" || word == "?" {
+			sentenceStart = true
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(word)
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 || (unicode.IsUpper(first) && !sentenceStart) {
+			set[word] = true
+		}
+		sentenceStart = false
+	}
+	return set
+}
+
+// anchorSimilarity compares a translation with a text of another language by
+// the share of the anchors of the one with fewer found in the other, as some
+// languages capitalize more words. Texts without any are neither alike nor
+// unlike.
+func anchorSimilarity(a, b string) float64 {
+	anchorsA, anchorsB := anchors(a), anchors(b)
+	if len(anchorsA) > len(anchorsB) {
+		anchorsA, anchorsB = anchorsB, anchorsA
+	}
+	if len(anchorsA) == 0 {
+		return 0.3
+	}
+	common := 0
+	for k := range anchorsA {
+		if anchorsB[k] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(anchorsA))
+}
+
+// trigrams returns the character trigrams of text, case-insensitively.
+func trigrams(text string) map[string]bool {
+	runes := []rune(" " + strings.ToLower(text) + " ")
+	set := map[string]bool{}
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
+
+// dice returns the Dice coefficient of two sets.
+func dice(a, b map[string]bool) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for k := range a {
+		if b[k] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// lengthRatio returns the length of the shorter text over that of the longer.
+func lengthRatio(a, b string) float64 {
+	la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+	if la > lb {
+		la, lb = lb, la
+	}
+	if lb == 0 {
+		return 0
+	}
+	return float64(la) / float64(lb)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestRecoverOrphans(t *testing.T) {
+	// The originals were marked again after a change, so no segment points
+	// to the translations any more.
+	const page = `<html><body>
+<p data-content-id="n1">In 1912 Anna left Vienna.</p><p data-translation-id="t1" data-translation-lang="de">1912 verließ Anna Wien.</p>
+<p data-content-id="n2">It rained all day.</p><p data-translation-id="t2" data-translation-lang="de">Es regnete den ganzen Tag.</p>
+<h2 data-content-id="n3">Chapter Two</h2>
+<p data-content-id="n4">Karl met her at 7 in Berlin.</p>
+<p data-translation-id="t4" data-translation-lang="de">Karl traf sie um 7 in Berlin.</p>
+</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, orphans := recoverOrphans(doc, nil)
+	if recovered != 3 || orphans != 3 {
+		t.Fatalf("recoverOrphans() = %d, %d; want 3 of 3", recovered, orphans)
+	}
+	for contentID, translationID := range map[string]string{"n1": "t1", "n2": "t2", "n4": "t4"} {
+		if got := doc.Find(`[data-content-id="`+contentID+`"]`).AttrOr("data-translation-by-id", ""); got != translationID {
+			t.Errorf("segment %s points to %q, want %q", contentID, got, translationID)
+		}
+	}
+	if doc.Find(`[data-content-id="n3"]`).AttrOr("data-translation-by-id", "") != "" {
+		t.Error("heading got a translation of a paragraph")
+	}
+	if !doc.Find(`[data-translation-id="t4"]`).Prev().Is(`[data-content-id="n4"]`) {
+		t.Error("translation t4 not moved after its segment")
+	}
+}
+
+func TestRecoverOrphansWithOriginals(t *testing.T) {
+	// Without anchors, a distant segment is only matched by its original.
+	const page = `<html><body>
+<p data-content-id="n1">the wind was cold and the sea grey</p>
+<p data-content-id="n2">she did not look back</p>
+<hr/>
+<p data-translation-id="t1" data-translation-lang="de">der Wind war kalt und das Meer grau</p>
+</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered, _ := recoverOrphans(doc, nil); recovered != 0 {
+		t.Errorf("recovered %d without originals, want 0", recovered)
+	}
+
+	originals := map[string]string{"der Wind war kalt und das Meer grau": "the wind was cold and the sea gray"}
+	if recovered, _ := recoverOrphans(doc, originals); recovered != 1 {
+		t.Fatalf("recovered %d with originals, want 1", recovered)
+	}
+	if got := doc.Find(`[data-content-id="n1"]`).AttrOr("data-translation-by-id", ""); got != "t1" {
+		t.Errorf("segment n1 points to %q, want t1", got)
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/replace"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// replaceRulesFile overrides the replace rules of a book, see
+	// bookReplacePath.
+	replaceRulesFile string
+	// replaceRules are applied to every new translation of the run.
+	replaceRules replace.Rules
+	// replaceHits counts the replacements of every rule in the run.
+	replaceHits = &ruleHits{}
+)
+
+var Replace = &cobra.Command{
+	Use:   "replace [unpackedEpubPath]",
+	Short: "Apply the find/replace rules of a book to its translations",
+	Long: `The replace rules of a book are regular expressions with their replacement, e.g. to enforce the spellings a
+publisher mandates. translate applies them to every new translation; this command applies them to the translations
+already in the book, recorded in the edit history like edits in serve. Use --dry-run to preview the changes first.
+
+The rules are kept next to the unpacked book as <unpacked-dir>-replace.yaml; use --rules for another file. See the
+README for their format.`,
+	Example: `epubtrans replace path/to/unpacked/epub --dry-run`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runReplace,
+}
+
+func init() {
+	Replace.Flags().StringVar(&replaceRulesFile, "rules", "", "replace rules file instead of the one next to the book")
+	Replace.Flags().Bool("dry-run", false, "list the changes and the hits of every rule without changing the book")
+	Translate.Flags().StringVar(&replaceRulesFile, "replace-rules", "", "find/replace rules applied to every new translation; defaults to <unpackedEpubPath>-replace.yaml")
+}
+
+// bookReplacePath returns the replace rules of the unpacked book: --rules or
+// --replace-rules if given, else <dir>-replace.yaml.
+func bookReplacePath(unpackedEpubPath string) string {
+	if replaceRulesFile != "" {
+		return replaceRulesFile
+	}
+	return filepath.Clean(unpackedEpubPath) + "-replace.yaml"
+}
+
+// ruleHits counts the replacements of rules made by concurrent batches.
+type ruleHits struct {
+	mu     sync.Mutex
+	counts []int
+}
+
+func (h *ruleHits) reset(rules int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts = make([]int, rules)
+}
+
+func (h *ruleHits) add(hits []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, n := range hits {
+		if i < len(h.counts) {
+			h.counts[i] += n
+		}
+	}
+}
+
+// summary lists the hits of every rule, e.g. "colour 3, ok 0".
+func (h *ruleHits) summary(rules replace.Rules) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	parts := make([]string, len(rules))
+	for i, r := range rules {
+		parts[i] = fmt.Sprintf("%s %d", r.ID, h.counts[i])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applyReplaceRules applies the replace rules of the run to a new translation.
+func applyReplaceRules(fileName, contentID, translation string) string {
+	if len(replaceRules) == 0 {
+		return translation
+	}
+
+	replaced, hits := replaceRules.Apply(translation)
+	replaceHits.add(hits)
+	if replaced != translation {
+		jobLog.Info("replace rules applied", "file", fileName, "content_id", contentID)
+	}
+	return replaced
+}
+
+// printReplaceHits reports the hits of the replace rules of the run.
+func printReplaceHits() {
+	if len(replaceRules) == 0 {
+		return
+	}
+	summary := replaceHits.summary(replaceRules)
+	fmt.Printf("Replace rule hits: %s\n", summary)
+	jobLog.Info("replace rule hits", "hits", summary)
+}
+
+func runReplace(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	rules, err := replace.Load(bookReplacePath(unzipPath))
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no replace rules in %s", bookReplacePath(unzipPath))
+	}
+
+	replacements, hits, err := bookReplacements(unzipPath, rules)
+	if err != nil {
+		return err
+	}
+	var edits []segmentEdit
+	for _, r := range replacements {
+		fmt.Printf("%s#%s\n  - %s\n  + %s\n", r.File, r.ContentID, r.before, r.Translation)
+		edits = append(edits, r.segmentEdit)
+	}
+	fmt.Printf("Replace rule hits: %s\n", hits.summary(rules))
+
+	if dryRun {
+		fmt.Printf("Would change %d translations\n", len(edits))
+		return nil
+	}
+	result, err := importSegmentEdits(unzipPath, "", edits)
+	if err != nil {
+		return err
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("  skipped %s\n", skipped)
+	}
+	fmt.Printf("Changed %d translations\n", result.Applied)
+	return nil
+}
+
+// replacement is the edit of a translation by the replace rules.
+type replacement struct {
+	segmentEdit
+	// before is the translation as it was.
+	before string
+}
+
+// bookReplacements returns the translations of the book the rules change and
+// the hits of the rules.
+func bookReplacements(unzipPath string, rules replace.Rules) ([]replacement, *ruleHits, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unzipPath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hits := &ruleHits{}
+	hits.reset(len(rules))
+	var replacements []replacement
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		segments, err := fileSegments(book, item.Href, reviews, newSegmentLocks())
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		for _, segment := range segments {
+			if segment.Translation == "" {
+				continue
+			}
+			replaced, n := rules.Apply(segment.Translation)
+			hits.add(n)
+			if replaced != segment.Translation {
+				edit := segmentEdit{File: item.Href, ContentID: segment.ContentID, Source: segment.Source, Translation: replaced}
+				replacements = append(replacements, replacement{segmentEdit: edit, before: segment.Translation})
+			}
+		}
+	}
+	return replacements, hits, nil
+}
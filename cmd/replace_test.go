@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/replace"
+)
+
+func TestBookReplacements(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeSyncBook(t, dir)
+
+	rules, err := replace.Parse([]byte("rules:\n  - id: eins\n    find: Eins\n    replace: Ein\n  - id: drei\n    find: Drei\n    replace: Vier\n"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacements, hits, err := bookReplacements(dir, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replacements) != 1 || replacements[0].ContentID != "c" || replacements[0].before != "Eins" || replacements[0].Translation != "Ein" {
+		t.Fatalf("replacements = %+v", replacements)
+	}
+	if got := hits.summary(rules); got != "eins 1, drei 0" {
+		t.Errorf("hits = %q", got)
+	}
+
+	result, err := importSegmentEdits(dir, "", []segmentEdit{replacements[0].segmentEdit})
+	if err != nil || result.Applied != 1 {
+		t.Fatalf("import = %+v, %v", result, err)
+	}
+	segments := syncSegments(t, dir)
+	if segments["c"].Translation != "Ein" || segments["a"].Translation != "Hallo" {
+		t.Errorf("segments after replacing: %+v", segments)
+	}
+	if again, _, _ := bookReplacements(dir, rules); len(again) != 0 {
+		t.Errorf("replaced again: %+v", again)
+	}
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+var (
+	// retranslateWhere holds provenance conditions such as model=claude-3-haiku;
+	// translations matching all of them are made again.
+	retranslateWhere []string
+	// retranslateContentIDs are the segments whose translations were cleared by
+	// this run; the translation memory is not consulted for them.
+	retranslateContentIDs map[string]bool
+)
+
+// provenanceFilter is one key=value or key!=value condition on a provenance.
+type provenanceFilter struct {
+	key    string
+	value  string
+	negate bool
+}
+
+// parseProvenanceFilters parses conditions on provider, model, prompt-version
+// and sampling.
+// An empty value matches translations without that provenance, such as those
+// made before provenance was recorded.
+func parseProvenanceFilters(exprs []string) ([]provenanceFilter, error) {
+	filters := make([]provenanceFilter, 0, len(exprs))
+	for _, expr := range exprs {
+		var f provenanceFilter
+		key, value, found := strings.Cut(expr, "!=")
+		if found {
+			f.negate = true
+		} else if key, value, found = strings.Cut(expr, "="); !found {
+			return nil, fmt.Errorf("invalid condition %q: use key=value or key!=value", expr)
+		}
+
+		f.key = strings.TrimSpace(key)
+		f.value = strings.TrimSpace(value)
+		switch f.key {
+		case "provider", "model", "prompt-version", "sampling":
+		default:
+			return nil, fmt.Errorf("invalid condition %q: key must be provider, model, prompt-version or sampling", expr)
+		}
+
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+func (f provenanceFilter) matches(p provenance) bool {
+	var actual string
+	switch f.key {
+	case "provider":
+		actual = p.Provider
+	case "model":
+		actual = p.Model
+	case "prompt-version":
+		actual = p.PromptVersion
+	case "sampling":
+		actual = p.Sampling
+	}
+	return (actual == f.value) != f.negate
+}
+
+func matchesAllFilters(filters []provenanceFilter, p provenance) bool {
+	for _, f := range filters {
+		if !f.matches(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// clearTranslationsWhere removes the translations matching all filters from
+// the spine, including popup and endnote translations, so the next translate
+// run makes them again. Files that translate skips keep their translations,
+// since nothing would translate them again. It returns the content ids of the
+// cleared segments.
+func clearTranslationsWhere(unzipPath string, filters []provenanceFilter) (map[string]bool, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := map[string]*goquery.Document{}
+	skipped := map[string]bool{}
+	var paths []string
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		filePath := filepath.Join(book.contentDir, item.Href)
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		reason, err := skipReason(filePath, doc)
+		if err != nil {
+			return nil, err
+		}
+		docs[filePath] = doc
+		skipped[filePath] = reason != ""
+		paths = append(paths, filePath)
+	}
+
+	// Translations may live in another document than their original (endnotes),
+	// so collect them from all documents first.
+	matched := map[string]bool{}
+	for _, filePath := range paths {
+		docs[filePath].Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			if matchesAllFilters(filters, provenanceOf(s)) {
+				matched[s.AttrOr(util.TranslationIdKey, "")] = true
+			}
+		})
+	}
+
+	translationIDs := map[string]bool{}
+	contentIDs := map[string]bool{}
+	changed := map[string]bool{}
+	for _, filePath := range paths {
+		if skipped[filePath] {
+			continue
+		}
+		docs[filePath].Find(fmt.Sprintf("[%s]", util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+			id := s.AttrOr(util.TranslationByIdKey, "")
+			if !matched[id] {
+				return
+			}
+
+			translationIDs[id] = true
+			s.RemoveAttr(util.TranslationByIdKey)
+			s.Find("a.epubtrans-noteref").Remove()
+			contentIDs[s.AttrOr(util.ContentIdKey, "")] = true
+			changed[filePath] = true
+		})
+	}
+
+	for _, filePath := range paths {
+		docs[filePath].Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			if !translationIDs[s.AttrOr(util.TranslationIdKey, "")] {
+				return
+			}
+
+			if note := s.Parent(); goquery.NodeName(note) == "aside" && strings.HasPrefix(note.AttrOr("id", ""), "epubtrans-note-") {
+				note.Remove()
+			} else {
+				s.Remove()
+			}
+			changed[filePath] = true
+		})
+	}
+
+	for _, filePath := range paths {
+		if !changed[filePath] {
+			continue
+		}
+		pruneSentenceTranslations(docs[filePath])
+		if err := writeContentToFile(filePath, docs[filePath]); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", filePath, err)
+		}
+	}
+
+	return contentIDs, nil
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceFilters(t *testing.T) {
+	haiku := provenance{Provider: "anthropic", Model: "claude-3-haiku", PromptVersion: "abc", Sampling: "temperature=0.7"}
+	legacy := provenance{}
+
+	tests := []struct {
+		name    string
+		exprs   []string
+		p       provenance
+		want    bool
+		wantErr bool
+	}{
+		{"model matches", []string{"model=claude-3-haiku"}, haiku, true, false},
+		{"model differs", []string{"model=gpt-4o"}, haiku, false, false},
+		{"all conditions", []string{"provider=anthropic", "prompt-version!=abc"}, haiku, false, false},
+		{"negated", []string{"prompt-version!=def"}, haiku, true, false},
+		{"empty value matches legacy", []string{"provider="}, legacy, true, false},
+		{"sampling differs", []string{"sampling!=temperature=0 seed=42"}, haiku, true, false},
+		{"unknown key", []string{"temperature=0.7"}, haiku, false, true},
+		{"no operator", []string{"claude-3-haiku"}, haiku, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := parseProvenanceFilters(tt.exprs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProvenanceFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := matchesAllFilters(filters, tt.p); got != tt.want {
+				t.Errorf("matchesAllFilters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearTranslationsWhereKeepsSkippedFiles(t *testing.T) {
+	defer func(patterns []string) { skipPatterns = patterns }(skipPatterns)
+	skipPatterns = []string{"^notes"}
+
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Retranslate")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Retranslate</dc:title></metadata>
+  <manifest>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="notes" href="notes.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/><itemref idref="notes"/></spine>
+</package>`,
+		"ch1.xhtml": `<html><body>
+<p data-content-id="a" data-translation-by-id="ta">The sea.</p>
+<p data-translation-id="ta" data-translation-model="old">Biển.</p>
+</body></html>`,
+		"notes.xhtml": `<html><body>
+<p data-content-id="b" data-translation-by-id="tb">A note.</p>
+<p data-translation-id="tb" data-translation-model="old">Ghi chú.</p>
+</body></html>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "OEBPS", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters, err := parseProvenanceFilters([]string{"model=old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleared, err := clearTranslationsWhere(dir, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cleared) != 1 || !cleared["a"] {
+		t.Errorf("cleared = %v, want only a", cleared)
+	}
+
+	chapter, err := os.ReadFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(chapter), "Biển") || strings.Contains(string(chapter), "data-translation-by-id") {
+		t.Errorf("ch1.xhtml kept its translation:\n%s", chapter)
+	}
+
+	notes, err := os.ReadFile(filepath.Join(dir, "OEBPS", "notes.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(notes) != files["notes.xhtml"] {
+		t.Errorf("the skipped notes.xhtml changed:\n%s", notes)
+	}
+}
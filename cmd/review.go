@@ -0,0 +1,480 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+// reviewAnnotation is the verdict of a reviewer on the translation of a
+// segment.
+type reviewAnnotation struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Note   string `json:"note,omitempty"`
+	// Translation is a hash of the translation reviewed; the verdict is
+	// outdated once the translation changes.
+	Translation string    `json:"translation"`
+	Reviewed    time.Time `json:"reviewed"`
+}
+
+// reviewStore keeps the annotations of a book by file and content id, next to
+// the unpacked directory like the share links.
+type reviewStore struct {
+	mu       sync.Mutex
+	path     string
+	Segments map[string]reviewAnnotation `json:"segments"`
+}
+
+func reviewStorePath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-review.json"
+}
+
+// reviewKey identifies the segment contentID of file in the store; the same
+// block in two files is reviewed apart.
+func reviewKey(file, contentID string) string {
+	return file + "#" + contentID
+}
+
+func loadReviewStore(storePath string) (*reviewStore, error) {
+	store := &reviewStore{path: storePath}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the annotations from disk, so several reviewers can work on
+// the book through different serve processes.
+func (s *reviewStore) reload() error {
+	segments := make(map[string]reviewAnnotation)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading review annotations: %w", err)
+	}
+
+	if err == nil {
+		var stored reviewStore
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("parsing review annotations: %w", err)
+		}
+		for key, annotation := range stored.Segments {
+			// Stores of earlier versions are keyed by content id only.
+			if !strings.Contains(key, "#") {
+				key = reviewKey(annotation.File, key)
+			}
+			segments[key] = annotation
+		}
+	}
+
+	s.Segments = segments
+	return nil
+}
+
+// save writes the annotations through a temporary file, so a reader never
+// sees half of them. The caller holds s.mu and the lock of the file.
+func (s *reviewStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling review annotations: %w", err)
+	}
+	return util.WriteFileAtomic(s.path, data, 0644)
+}
+
+// annotations returns the annotations of the segments of file by content id.
+func (s *reviewStore) annotations(file string) (map[string]reviewAnnotation, error) {
+	all, err := s.bookAnnotations()
+	if err != nil {
+		return nil, err
+	}
+	return fileAnnotations(all, file), nil
+}
+
+// bookAnnotations returns the annotations of the whole book by reviewKey.
+func (s *reviewStore) bookAnnotations() (map[string]reviewAnnotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	annotations := make(map[string]reviewAnnotation, len(s.Segments))
+	for key, annotation := range s.Segments {
+		annotations[key] = annotation
+	}
+	return annotations, nil
+}
+
+// fileAnnotations picks the annotations of file from those of the book, by
+// content id.
+func fileAnnotations(all map[string]reviewAnnotation, file string) map[string]reviewAnnotation {
+	annotations := make(map[string]reviewAnnotation)
+	prefix := reviewKey(file, "")
+	for key, annotation := range all {
+		if annotation.File == file && strings.HasPrefix(key, prefix) {
+			annotations[strings.TrimPrefix(key, prefix)] = annotation
+		}
+	}
+	return annotations
+}
+
+// annotate records the annotation of the segment contentID of file, or
+// removes it if its status is empty. The store is locked on disk meanwhile,
+// so verdicts saved by another serve process are kept.
+func (s *reviewStore) annotate(file, contentID string, annotation reviewAnnotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := util.LockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	key := reviewKey(file, contentID)
+	if annotation.Status == "" {
+		delete(s.Segments, key)
+	} else {
+		annotation.File = file
+		s.Segments[key] = annotation
+	}
+	return s.save()
+}
+
+// reviewSegment is a segment of a chapter with its translation, which may be
+// in another file, e.g. with endnote placement.
+type reviewSegment struct {
+	ContentID   string
+	Original    string
+	Translation string
+	Translated  bool
+}
+
+func translationHash(translation string) string {
+	sum := sha1.Sum([]byte(translation))
+	return hex.EncodeToString(sum[:6])
+}
+
+// chapterSegments returns the segments of the chapter href in the order of the
+// text.
+func chapterSegments(book *bookFiles, href string) ([]reviewSegment, error) {
+	doc, err := openAndReadFile(filepath.Join(book.contentDir, href))
+	if err != nil {
+		return nil, err
+	}
+
+	translations := map[string]string{}
+	collect := func(doc *goquery.Document) {
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			translations[s.AttrOr(util.TranslationIdKey, "")], _ = s.Html()
+		})
+	}
+	collect(doc)
+
+	var segments []reviewSegment
+	missing := false
+	doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+		original, _ := withoutPageBreaks(s)
+		segment := reviewSegment{ContentID: s.AttrOr(util.ContentIdKey, ""), Original: original}
+		if id := s.AttrOr(util.TranslationByIdKey, ""); id != "" {
+			segment.Translation, segment.Translated = translations[id]
+			missing = missing || !segment.Translated
+		}
+		segments = append(segments, segment)
+	})
+	if !missing {
+		return segments, nil
+	}
+
+	// Translations placed elsewhere are looked up in the other files.
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" || item.Href == href {
+			continue
+		}
+		other, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			continue
+		}
+		collect(other)
+	}
+	doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+		if id := s.AttrOr(util.TranslationByIdKey, ""); id != "" && !segments[i].Translated {
+			segments[i].Translation, segments[i].Translated = translations[id]
+		}
+	})
+	return segments, nil
+}
+
+// reviewCounts summarizes the review of a chapter. Verdicts on translations
+// changed since are outdated and count as not reviewed.
+type reviewCounts struct {
+	Segments   int
+	Translated int
+	Approved   int
+	Rejected   int
+	Outdated   int
+}
+
+func countReview(segments []reviewSegment, annotations map[string]reviewAnnotation) reviewCounts {
+	counts := reviewCounts{Segments: len(segments)}
+	for _, segment := range segments {
+		if segment.Translated {
+			counts.Translated++
+		}
+		annotation, ok := annotations[segment.ContentID]
+		switch {
+		case !ok:
+		case annotation.Translation != translationHash(segment.Translation):
+			counts.Outdated++
+		case annotation.Status == reviewApproved:
+			counts.Approved++
+		case annotation.Status == reviewRejected:
+			counts.Rejected++
+		}
+	}
+	return counts
+}
+
+type ReviewRequest struct {
+	FilePath string `json:"file_path"`
+	// Status is approved or rejected; empty removes the annotation.
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// registerReviewAPI adds the endpoints to read and record review verdicts.
+func registerReviewAPI(api fiber.Router, store *reviewStore, unpackedEpubPath string) {
+	api.Get("/reviews", func(c *fiber.Ctx) error {
+		// Without a file, the annotations of the book are keyed by file and
+		// content id.
+		var annotations map[string]reviewAnnotation
+		var err error
+		if filePath := c.Query("file_path"); filePath != "" {
+			annotations, err = store.annotations(strings.TrimPrefix(path.Clean("/"+filePath), "/"))
+		} else {
+			annotations, err = store.bookAnnotations()
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read review annotations"})
+		}
+		return c.JSON(annotations)
+	})
+
+	api.Put("/review/:id", func(c *fiber.Ctx) error {
+		var req ReviewRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if req.Status != "" && req.Status != reviewApproved && req.Status != reviewRejected {
+			return c.Status(400).JSON(fiber.Map{"error": "Status must be approved, rejected or empty"})
+		}
+
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to parse package"})
+		}
+		href := strings.TrimPrefix(path.Clean("/"+req.FilePath), "/")
+		segments, err := chapterSegments(book, href)
+		if os.IsNotExist(err) {
+			return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read file"})
+		}
+
+		for _, segment := range segments {
+			if segment.ContentID != c.Params("id") {
+				continue
+			}
+			annotation := reviewAnnotation{
+				File:        href,
+				Status:      req.Status,
+				Note:        strings.TrimSpace(req.Note),
+				Translation: translationHash(segment.Translation),
+				Reviewed:    time.Now(),
+			}
+			if err := store.annotate(href, segment.ContentID, annotation); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to save review annotation"})
+			}
+			return c.JSON(annotation)
+		}
+		return c.Status(404).JSON(fiber.Map{"error": "Segment not found"})
+	})
+}
+
+// registerReviewPages adds /review, the chapters with their review progress,
+// and /review/<chapter>, the original and translated segments of a chapter
+// side by side.
+func registerReviewPages(app fiber.Router, store *reviewStore, unpackedEpubPath string) {
+	app.Get("/review", func(c *fiber.Ctx) error {
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error parsing package: %v", err))
+		}
+		annotations, err := store.bookAnnotations()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		var rows strings.Builder
+		var total reviewCounts
+		for _, href := range reviewChapters(book.pkg) {
+			segments, err := chapterSegments(book, href)
+			if err != nil {
+				continue
+			}
+			counts := countReview(segments, fileAnnotations(annotations, href))
+			if counts.Segments == 0 {
+				continue
+			}
+			total.Segments += counts.Segments
+			total.Approved += counts.Approved
+			total.Rejected += counts.Rejected
+			fmt.Fprintf(&rows, `<tr><td><a href="%s/review/%s">%s</a></td><td>%d</td><td>%d</td><td>%d</td><td>%d</td><td>%d</td></tr>`,
+				html.EscapeString(bookBase(c)), html.EscapeString(href), html.EscapeString(href), counts.Segments, counts.Translated, counts.Approved, counts.Rejected, counts.Outdated)
+		}
+
+		c.Set("Content-Type", "text/html")
+		return c.SendString(reviewPage(bookBase(c), "Review", "", fmt.Sprintf(`
+    <h1>Review</h1>
+    <p>%d of %d segments reviewed, %d rejected.</p>
+    <table class="review-index">
+        <tr><th>Chapter</th><th>Segments</th><th>Translated</th><th>Approved</th><th>Rejected</th><th>Changed since review</th></tr>
+        %s
+    </table>`, total.Approved+total.Rejected, total.Segments, total.Rejected, rows.String())))
+	})
+
+	app.Get("/review/*", func(c *fiber.Ctx) error {
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error parsing package: %v", err))
+		}
+		href := strings.TrimPrefix(path.Clean("/"+c.Params("*")), "/")
+		chapters := reviewChapters(book.pkg)
+		index := -1
+		for i, chapter := range chapters {
+			if chapter == href {
+				index = i
+			}
+		}
+		if index < 0 {
+			return c.Status(fiber.StatusNotFound).SendString("Chapter not found")
+		}
+
+		segments, err := chapterSegments(book, href)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error reading chapter: %v", err))
+		}
+		annotations, err := store.annotations(href)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		var rows strings.Builder
+		for _, segment := range segments {
+			status, note := "", ""
+			if annotation, ok := annotations[segment.ContentID]; ok {
+				status, note = annotation.Status, annotation.Note
+				if annotation.Translation != translationHash(segment.Translation) {
+					status = "outdated"
+				}
+			}
+			translation := segment.Translation
+			if !segment.Translated {
+				translation = `<em class="review-missing">Not translated</em>`
+			}
+			fmt.Fprintf(&rows, `<tr class="review-row" data-content-id="%s" data-status="%s">
+            <td class="review-original">%s</td>
+            <td class="review-translation">%s</td>
+            <td class="review-actions">
+                <button data-verdict="approved" title="Approve (a)">Approve</button>
+                <button data-verdict="rejected" title="Reject (r)">Reject</button>
+                <input class="review-note" placeholder="Note" value="%s">
+            </td>
+        </tr>`, html.EscapeString(segment.ContentID), status, segment.Original, translation, html.EscapeString(note))
+		}
+
+		base := html.EscapeString(bookBase(c))
+		var nav strings.Builder
+		fmt.Fprintf(&nav, `<a href="%s/review">All chapters</a>`, base)
+		if index > 0 {
+			fmt.Fprintf(&nav, ` · <a href="%s/review/%s">Previous</a>`, base, html.EscapeString(chapters[index-1]))
+		}
+		if index < len(chapters)-1 {
+			fmt.Fprintf(&nav, ` · <a href="%s/review/%s">Next</a>`, base, html.EscapeString(chapters[index+1]))
+		}
+		fmt.Fprintf(&nav, ` · <a href="%s/%s">Edit</a>`, base, html.EscapeString(href))
+
+		counts := countReview(segments, annotations)
+		c.Set("Content-Type", "text/html")
+		// The base makes the images and links of the chapter resolve.
+		return c.SendString(reviewPage(bookBase(c), "Review "+href, "/"+href, fmt.Sprintf(`
+    <nav class="review-nav">%s</nav>
+    <h1>%s</h1>
+    <p class="review-progress" data-file-path="/%s">%d of %d segments reviewed. Keys: j/k to move, a to approve, r to reject.</p>
+    <table class="review-chapter">
+        %s
+    </table>`, nav.String(), html.EscapeString(href), html.EscapeString(href), counts.Approved+counts.Rejected, counts.Segments, rows.String())))
+	})
+}
+
+// reviewChapters returns the hrefs of the XHTML files in reading order.
+func reviewChapters(pkg *loader.Package) []string {
+	var hrefs []string
+	for _, item := range processor.ReadingOrder(pkg) {
+		if item.MediaType == "application/xhtml+xml" && !processor.ShouldExcludeFile(item.Href) {
+			hrefs = append(hrefs, item.Href)
+		}
+	}
+	return hrefs
+}
+
+// reviewPage wraps body in a page of the book served under bookBase. base,
+// relative to the book, is where the links of body resolve.
+func reviewPage(bookBase, title, base, body string) string {
+	baseTag := ""
+	if base != "" {
+		baseTag = fmt.Sprintf(`<base href="%s">`, html.EscapeString(bookBase+base))
+	}
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>%s</title>
+    %s
+    <meta name="epubtrans-base" content="%s">
+    <link rel="stylesheet" href="%s">
+    <script src="%s"></script>
+</head>
+<body>
+    %s
+    <script>addThemeToggle(document.body);</script>
+</body>
+</html>
+`, html.EscapeString(title), baseTag, html.EscapeString(bookBase),
+		assetURL("review.css"), assetURL("review.js"), body)
+}
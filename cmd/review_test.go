@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestReviewStore(t *testing.T) {
+	storePath := reviewStorePath(filepath.Join(t.TempDir(), "book"))
+	store, err := loadReviewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	approved := reviewAnnotation{Status: reviewApproved, Translation: translationHash("Hallo")}
+	if err := store.annotate("ch1.xhtml", "a", approved); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.annotate("ch2.xhtml", "b", reviewAnnotation{Status: reviewRejected}); err != nil {
+		t.Fatal(err)
+	}
+	// The same block in another file is reviewed apart.
+	if err := store.annotate("ch2.xhtml", "a", reviewAnnotation{Status: reviewRejected}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another serve process sees the verdicts.
+	other, err := loadReviewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, err := other.annotations("ch1.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 1 || annotations["a"].Status != reviewApproved || annotations["a"].File != "ch1.xhtml" {
+		t.Errorf("annotations of ch1.xhtml = %v", annotations)
+	}
+
+	if err := other.annotate("ch1.xhtml", "a", reviewAnnotation{}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := store.bookAnnotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all[reviewKey("ch1.xhtml", "a")]; ok || len(all) != 2 || all[reviewKey("ch2.xhtml", "a")].Status != reviewRejected {
+		t.Errorf("annotations after removal = %v", all)
+	}
+	if matches, _ := filepath.Glob(storePath + ".*"); len(matches) != 0 {
+		t.Errorf("files left next to the store: %v", matches)
+	}
+}
+
+func TestReviewStoreConcurrentAnnotate(t *testing.T) {
+	storePath := reviewStorePath(filepath.Join(t.TempDir(), "book"))
+
+	// Each store stands for a serve process of its own.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		store, err := loadReviewStore(storePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.annotate("ch1.xhtml", fmt.Sprint(i), reviewAnnotation{Status: reviewApproved}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := loadReviewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotations, err := store.annotations("ch1.xhtml"); err != nil || len(annotations) != 8 {
+		t.Errorf("annotations = %d (%v), want all 8 verdicts", len(annotations), err)
+	}
+}
+
+func TestReviewStoreReadsContentIDKeys(t *testing.T) {
+	storePath := reviewStorePath(filepath.Join(t.TempDir(), "book"))
+	legacy := `{"segments": {"a": {"file": "ch1.xhtml", "status": "approved", "translation": "x"}}}`
+	if err := os.WriteFile(storePath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := loadReviewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, err := store.annotations("ch1.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotations["a"].Status != reviewApproved {
+		t.Errorf("annotations of ch1.xhtml = %v, want the stored verdict", annotations)
+	}
+}
+
+func TestCountReview(t *testing.T) {
+	segments := []reviewSegment{
+		{ContentID: "a", Translation: "Hallo", Translated: true},
+		{ContentID: "b", Translation: "Wereld", Translated: true},
+		{ContentID: "c", Translation: "Nieuw", Translated: true},
+		{ContentID: "d"},
+	}
+	annotations := map[string]reviewAnnotation{
+		"a": {Status: reviewApproved, Translation: translationHash("Hallo")},
+		"b": {Status: reviewRejected, Translation: translationHash("Wereld")},
+		"c": {Status: reviewApproved, Translation: translationHash("Oud")},
+	}
+
+	got := countReview(segments, annotations)
+	want := reviewCounts{Segments: 4, Translated: 3, Approved: 1, Rejected: 1, Outdated: 1}
+	if got != want {
+		t.Errorf("countReview = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+var (
+	// rightsCheck refuses to translate books whose rights metadata reserves
+	// all rights or that are on the blocklist, unless haveRights is set.
+	rightsCheck     bool
+	rightsBlocklist string
+	haveRights      bool
+)
+
+func init() {
+	Translate.Flags().BoolVar(&rightsCheck, "rights-check", os.Getenv("EPUBTRANS_RIGHTS_CHECK") != "", "refuse books whose rights metadata reserves all rights or that are on the blocklist, unless --i-have-rights is given; defaults to on when EPUBTRANS_RIGHTS_CHECK is set")
+	Translate.Flags().StringVar(&rightsBlocklist, "rights-blocklist", os.Getenv("EPUBTRANS_RIGHTS_BLOCKLIST"), "file of books to refuse, one identifier or title:, creator: or publisher: entry per line; implies --rights-check")
+	Translate.Flags().BoolVar(&haveRights, "i-have-rights", false, "acknowledge having the rights to translate a book the rights check flags")
+}
+
+var (
+	reservedRightsRegex = regexp.MustCompile(`(?i)all rights reserved|tous droits réservés|alle rechte vorbehalten|todos los derechos reservados|tutti i diritti riservati|alle rechten voorbehouden`)
+	openRightsRegex     = regexp.MustCompile(`(?i)creative\s*commons|\bcc[ -]?(?:by|0)\b|public domain|domaine public|gemeinfrei|dominio público`)
+)
+
+// blockRule is a line of the blocklist: a value matched against a field of
+// the metadata.
+type blockRule struct {
+	field string
+	value string
+}
+
+func loadBlocklist(path string) ([]blockRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the rights blocklist: %w", err)
+	}
+	defer f.Close()
+
+	var rules []blockRule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule := blockRule{field: "identifier", value: text}
+		if field, value, ok := strings.Cut(text, ":"); ok {
+			switch f := strings.ToLower(strings.TrimSpace(field)); f {
+			case "title", "creator", "publisher":
+				rule = blockRule{field: f, value: strings.TrimSpace(value)}
+			}
+		}
+		if rule.value == "" {
+			return nil, fmt.Errorf("%s:%d: empty %s", path, line, rule.field)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// normalizeIdentifier makes ISBNs and URNs comparable, e.g.
+// urn:isbn:978-0-14-044913-6 and 9780140449136.
+func normalizeIdentifier(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	for _, prefix := range []string{"urn:", "isbn:", "uuid:"} {
+		id = strings.TrimPrefix(id, prefix)
+	}
+	return strings.NewReplacer("-", "", " ", "").Replace(id)
+}
+
+func (r blockRule) matches(meta loader.Metadata) bool {
+	switch r.field {
+	case "title":
+		return strings.EqualFold(strings.TrimSpace(meta.Title), r.value)
+	case "creator":
+		return strings.EqualFold(strings.TrimSpace(meta.Creator), r.value)
+	case "publisher":
+		return strings.EqualFold(strings.TrimSpace(meta.Publisher), r.value)
+	default:
+		return meta.Identifier != "" && normalizeIdentifier(meta.Identifier) == normalizeIdentifier(r.value)
+	}
+}
+
+// rightsStatement returns the rights of the book: dc:rights, or the
+// dcterms:rights meta of EPUB 3.
+func rightsStatement(meta loader.Metadata) string {
+	if meta.Rights != "" {
+		return strings.TrimSpace(meta.Rights)
+	}
+	for _, m := range meta.Metas {
+		if m.Property == "dcterms:rights" && m.Refines == "" {
+			return strings.TrimSpace(m.Content)
+		}
+	}
+	return ""
+}
+
+// rightsFlags returns why the book may not be translated without
+// acknowledgement: rights reserving all rights without an open license, and
+// matching blocklist entries.
+func rightsFlags(meta loader.Metadata, blocklist []blockRule) []string {
+	var flags []string
+	if rights := rightsStatement(meta); reservedRightsRegex.MatchString(rights) && !openRightsRegex.MatchString(rights) {
+		flags = append(flags, fmt.Sprintf("rights metadata reserves all rights (%q)", rights))
+	}
+	for _, rule := range blocklist {
+		if rule.matches(meta) {
+			flags = append(flags, fmt.Sprintf("on the blocklist (%s %q)", rule.field, rule.value))
+		}
+	}
+	return flags
+}
+
+// checkRights runs the rights check on the book at unzipPath, if enabled. It
+// returns the flags acknowledged with --i-have-rights, to be recorded in the
+// job log.
+func checkRights(unzipPath string) ([]string, error) {
+	if !rightsCheck && rightsBlocklist == "" {
+		return nil, nil
+	}
+
+	var blocklist []blockRule
+	if rightsBlocklist != "" {
+		var err error
+		if blocklist, err = loadBlocklist(rightsBlocklist); err != nil {
+			return nil, err
+		}
+	}
+
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := rightsFlags(book.pkg.Metadata, blocklist)
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	if !haveRights {
+		return nil, fmt.Errorf("%s is flagged by the rights check: %s; pass --i-have-rights to confirm you may translate it", book.pkg.Metadata.Title, strings.Join(flags, ", "))
+	}
+
+	fmt.Printf("Rights check flagged %s, acknowledged with --i-have-rights: %s\n", book.pkg.Metadata.Title, strings.Join(flags, ", "))
+	return flags, nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+func TestRightsFlags(t *testing.T) {
+	blocklist := []blockRule{
+		{field: "identifier", value: "978-0-14-044913-6"},
+		{field: "publisher", value: "Acme Press"},
+	}
+
+	tests := []struct {
+		name  string
+		meta  loader.Metadata
+		flags int
+	}{
+		{"no rights", loader.Metadata{Title: "A"}, 0},
+		{"all rights reserved", loader.Metadata{Rights: "© 2020 Jane Doe. All rights reserved."}, 1},
+		{"creative commons", loader.Metadata{Rights: "All rights reserved except as licensed under CC BY-SA 4.0"}, 0},
+		{"public domain", loader.Metadata{Rights: "Public domain in the USA."}, 0},
+		{"dcterms meta", loader.Metadata{Metas: []loader.Meta{{Property: "dcterms:rights", Content: "Tous droits réservés"}}}, 1},
+		{"blocked isbn", loader.Metadata{Identifier: "urn:isbn:9780140449136"}, 1},
+		{"blocked publisher", loader.Metadata{Publisher: "acme press", Rights: "All Rights Reserved"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if flags := rightsFlags(tt.meta, blocklist); len(flags) != tt.flags {
+				t.Errorf("rightsFlags() = %q, want %d flags", flags, tt.flags)
+			}
+		})
+	}
+}
@@ -9,6 +9,10 @@ var Root = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return cmd.Help()
 	},
+	// The files a command writes are recorded with its name.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		writeSource = cmd.Name()
+	},
 }
 
 func init() {
@@ -20,4 +24,30 @@ func init() {
 	Root.AddCommand(Serve)
 	Root.AddCommand(Styling)
 	Root.AddCommand(Upgrade)
+	Root.AddCommand(Watch)
+	Root.AddCommand(OPDS)
+	Root.AddCommand(Send)
+	Root.AddCommand(Series)
+	Root.AddCommand(Benchmark)
+	Root.AddCommand(Bench)
+	Root.AddCommand(Analyze)
+	Root.AddCommand(Split)
+	Root.AddCommand(Merge)
+	Root.AddCommand(Validate)
+	Root.AddCommand(QA)
+	Root.AddCommand(BackTranslate)
+	Root.AddCommand(Sync)
+	Root.AddCommand(Status)
+	Root.AddCommand(ExportTM)
+	Root.AddCommand(ImportTM)
+	Root.AddCommand(ExportXLIFF)
+	Root.AddCommand(ImportXLIFF)
+	Root.AddCommand(Export)
+	Root.AddCommand(Import)
+	Root.AddCommand(Glossary)
+	Root.AddCommand(Replace)
+	Root.AddCommand(Estimate)
+	Root.AddCommand(Pronunciation)
+	Root.AddCommand(Audiobook)
+	Root.AddCommand(ExportAnki)
 }
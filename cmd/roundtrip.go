@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"golang.org/x/net/html"
+)
+
+// roundTripHTML parses and re-renders content the same way the mark command does.
+func roundTripHTML(content []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	return renderXHTML(content, doc)
+}
+
+// firstDifference returns the offset of the first differing byte, or -1 if a and b are equal.
+func firstDifference(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return n
+	}
+	return -1
+}
+
+func snippetAt(content []byte, offset int) string {
+	start := max(0, offset-30)
+	end := min(len(content), offset+30)
+	return string(content[start:end])
+}
+
+func verifyRoundTrip(ctx context.Context, filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("reading file %s: %w", filePath, err)
+	}
+
+	rendered, err := roundTripHTML(content)
+	if err != nil {
+		return fmt.Errorf("round-tripping %s: %w", filePath, err)
+	}
+
+	offset := firstDifference(content, rendered)
+	if offset == -1 {
+		fmt.Printf("Round-trip OK: %s\n", filepath.Base(filePath))
+		return nil
+	}
+
+	fmt.Printf("Round-trip DIFF: %s at byte %d\n\toriginal: %q\n\trendered: %q\n",
+		filepath.Base(filePath), offset, snippetAt(content, offset), snippetAt(rendered, offset))
+	return fmt.Errorf("%s changes when rendered", filepath.Base(filePath))
+}
+
+func verifyBookRoundTrip(ctx context.Context, unzipPath string, workers int) error {
+	return processor.ProcessEpub(ctx, unzipPath, processor.Config{
+		Workers:      workers,
+		JobBuffer:    10,
+		ResultBuffer: 10,
+	}, verifyRoundTrip)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestRoundTripGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "roundtrip", "*.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no round-trip fixtures found")
+	}
+
+	for _, input := range inputs {
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			content, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := roundTripHTML(content)
+			if err != nil {
+				t.Fatalf("roundTripHTML() error = %v", err)
+			}
+
+			goldenPath := strings.TrimSuffix(input, ".xhtml") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("reading golden file: %v (run go test with -update to create it)", err)
+			}
+
+			if offset := firstDifference(want, got); offset != -1 {
+				t.Errorf("roundTripHTML() differs from golden at byte %d:\n got: %q\nwant: %q",
+					offset, snippetAt(got, offset), snippetAt(want, offset))
+			}
+
+			// The serializer keeps the bytes of the file as they are.
+			if offset := firstDifference(content, got); offset != -1 {
+				t.Errorf("roundTripHTML() changed the input at byte %d:\n got: %q\nwant: %q",
+					offset, snippetAt(got, offset), snippetAt(content, offset))
+			}
+
+			// Rendering the rendered output again must be a no-op.
+			again, err := roundTripHTML(got)
+			if err != nil {
+				t.Fatalf("roundTripHTML() error = %v", err)
+			}
+			if !bytes.Equal(again, got) {
+				offset := firstDifference(got, again)
+				t.Errorf("roundTripHTML() is not idempotent at byte %d:\n got: %q\nwant: %q",
+					offset, snippetAt(again, offset), snippetAt(got, offset))
+			}
+		})
+	}
+}
+
+func TestMarkLeavesMarkedFileUntouched(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "roundtrip", "marked.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "marked.xhtml")
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &marker{granularity: granularityParagraph}
+	if err := m.markContentInFile(context.Background(), filePath); err != nil {
+		t.Fatalf("markContentInFile() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, content) {
+		offset := firstDifference(content, got)
+		t.Errorf("markContentInFile() changed an already marked file at byte %d: %q",
+			offset, snippetAt(got, offset))
+	}
+}
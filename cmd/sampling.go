@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/spf13/cobra"
+)
+
+// deterministicSeed is the seed of the deterministic sampling profile.
+var deterministicSeed int64 = 42
+
+// samplingProfiles are the sampling settings --sampling chooses from.
+var samplingProfiles = map[string]translator.Sampling{
+	// default lets the model vary its wording a little.
+	"default": {Temperature: 0.7},
+	// deterministic makes a rerun with the same input give the same
+	// translation as far as the provider allows, for auditing.
+	"deterministic": {Temperature: 0, Seed: &deterministicSeed},
+}
+
+// translationSampling holds the sampling settings of the translations.
+var translationSampling = samplingProfiles["default"]
+
+func samplingProfileNames() []string {
+	names := make([]string, 0, len(samplingProfiles))
+	for name := range samplingProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addSamplingFlags adds --sampling and the flags overriding its settings.
+func addSamplingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("sampling", "default", "sampling profile: "+strings.Join(samplingProfileNames(), ", "))
+	cmd.Flags().Float32("temperature", 0, "sampling temperature, overriding the --sampling profile")
+	cmd.Flags().Float32("top-p", 0, "nucleus sampling threshold, overriding the --sampling profile")
+	cmd.Flags().Int64("seed", 0, "sampling seed for providers that support one (openai, ollama, gemini), overriding the --sampling profile")
+}
+
+// samplingFromFlags returns the settings of the --sampling profile with the
+// overrides given on the command line.
+func samplingFromFlags(cmd *cobra.Command) (translator.Sampling, error) {
+	name, _ := cmd.Flags().GetString("sampling")
+	s, ok := samplingProfiles[name]
+	if !ok {
+		return s, fmt.Errorf("unknown sampling profile %q: use one of %v", name, samplingProfileNames())
+	}
+
+	if cmd.Flags().Changed("temperature") {
+		s.Temperature, _ = cmd.Flags().GetFloat32("temperature")
+	}
+	if cmd.Flags().Changed("top-p") {
+		s.TopP, _ = cmd.Flags().GetFloat32("top-p")
+	}
+	if cmd.Flags().Changed("seed") {
+		seed, _ := cmd.Flags().GetInt64("seed")
+		s.Seed = &seed
+	}
+	if s.Temperature < 0 || s.TopP < 0 || s.TopP > 1 {
+		return s, fmt.Errorf("--temperature must not be negative and --top-p must be between 0 and 1")
+	}
+	return s, nil
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// trustHTML skips sanitizing translations edited in serve, for single-user
+// setups that need markup outside the allow-list.
+var trustHTML bool
+
+// sanitizeAllowedTags are the elements a translation may contain: text level
+// markup, the blocks that occur inside translated segments, and MathML.
+var sanitizeAllowedTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "br": true, "cite": true, "code": true,
+	"del": true, "dfn": true, "em": true, "i": true, "img": true, "ins": true, "kbd": true, "mark": true,
+	"q": true, "rp": true, "rt": true, "ruby": true, "s": true, "samp": true, "small": true, "span": true,
+	"strong": true, "sub": true, "sup": true, "time": true, "u": true, "var": true, "wbr": true,
+	"blockquote": true, "div": true, "p": true, "pre": true, "ul": true, "ol": true, "li": true,
+	"dl": true, "dt": true, "dd": true, "figcaption": true, "hr": true,
+
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "th": true, "td": true, "caption": true,
+
+	// SVG text labels.
+	"tspan": true,
+
+	// MathML.
+	"math": true, "mi": true, "mn": true, "mo": true, "ms": true, "mtext": true, "mspace": true, "mrow": true,
+	"mfrac": true, "msqrt": true, "mroot": true, "mstyle": true, "mpadded": true, "mphantom": true, "menclose": true,
+	"msub": true, "msup": true, "msubsup": true, "munder": true, "mover": true, "munderover": true,
+	"mmultiscripts": true, "mprescripts": true, "none": true, "mtable": true, "mtr": true, "mtd": true,
+	"semantics": true, "annotation": true,
+}
+
+// sanitizeDroppedTags are removed with their content; other elements outside
+// the allow-list are replaced by their content.
+var sanitizeDroppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "form": true, "input": true, "button": true, "textarea": true,
+	"select": true, "link": true, "meta": true, "base": true, "noscript": true, "template": true,
+	"foreignobject": true, "annotation-xml": true,
+}
+
+var sanitizeAllowedAttributes = map[string]bool{
+	"class": true, "id": true, "title": true, "lang": true, "xml:lang": true, "dir": true, "alt": true,
+	"href": true, "src": true, "width": true, "height": true, "epub:type": true, "role": true,
+	"colspan": true, "rowspan": true, "datetime": true, "cite": true, "style": true,
+}
+
+// sanitizeURLAttributes hold URLs, which must not run script.
+var sanitizeURLAttributes = map[string]bool{"href": true, "src": true, "cite": true, "xlink:href": true}
+
+// sanitizeTranslation removes the elements and attributes outside the
+// allow-list from translated markup. It returns the cleaned markup and a
+// description of everything it removed.
+func sanitizeTranslation(content string) (string, []string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + content + "</body>"))
+	if err != nil {
+		return "", nil, err
+	}
+	body := doc.Find("body")
+
+	var removed []string
+	body.Find("*").Each(func(i int, s *goquery.Selection) {
+		name := goquery.NodeName(s)
+		switch {
+		case sanitizeDroppedTags[name]:
+			removed = append(removed, "<"+name+">")
+			s.Remove()
+			return
+		case !sanitizeAllowedTags[name]:
+			removed = append(removed, "<"+name+"> (content kept)")
+			if s.Contents().Length() > 0 {
+				s.Contents().Unwrap()
+			} else {
+				s.Remove()
+			}
+			return
+		}
+
+		node := s.Get(0)
+		inMath := s.Closest("math").Length() > 0
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			key := strings.ToLower(attr.Key)
+			if attr.Namespace != "" {
+				key = attr.Namespace + ":" + key
+			}
+			if reason := disallowedAttribute(key, attr.Val, name, inMath); reason != "" {
+				removed = append(removed, fmt.Sprintf("%s on <%s> (%s)", key, name, reason))
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+		node.Attr = attrs
+	})
+
+	cleaned, err := body.Html()
+	if err != nil {
+		return "", nil, err
+	}
+	return cleaned, removed, nil
+}
+
+// disallowedAttribute returns why an attribute is removed, or "" to keep it.
+func disallowedAttribute(key, value, element string, inMath bool) string {
+	if strings.HasPrefix(key, "on") {
+		return "event handler"
+	}
+
+	if sanitizeURLAttributes[key] {
+		if !isSafeURL(value, element == "img" && key == "src") {
+			return "unsafe URL"
+		}
+		return ""
+	}
+
+	if key == "style" {
+		lower := strings.ToLower(value)
+		if strings.Contains(lower, "url(") || strings.Contains(lower, "expression(") || strings.Contains(lower, "javascript:") {
+			return "unsafe style"
+		}
+		return ""
+	}
+
+	if sanitizeAllowedAttributes[key] || strings.HasPrefix(key, "data-") || strings.HasPrefix(key, "aria-") {
+		return ""
+	}
+	// MathML has many presentation attributes; none of them runs script.
+	if inMath {
+		return ""
+	}
+
+	return "not allowed"
+}
+
+// isSafeURL accepts relative URLs and http, https and mailto links; images may
+// also use data URLs.
+func isSafeURL(value string, image bool) bool {
+	u := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value))
+
+	scheme, _, found := strings.Cut(u, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+
+	switch scheme {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		return image && strings.HasPrefix(u, "data:image/")
+	default:
+		return false
+	}
+}
+
+// checkWellFormed reports markup whose elements are not properly nested or
+// closed, which would not survive being written into an XHTML file. Void
+// elements may be written as <br> or <br/>.
+func checkWellFormed(content string) error {
+	var open []string
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if !errors.Is(z.Err(), io.EOF) {
+				return z.Err()
+			}
+			if len(open) > 0 {
+				return fmt.Errorf("<%s> is not closed", open[len(open)-1])
+			}
+			return nil
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if !isVoidElement(string(name)) {
+				open = append(open, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if isVoidElement(string(name)) {
+				continue
+			}
+			if len(open) == 0 {
+				return fmt.Errorf("</%s> closes an element that was not opened", name)
+			}
+			if top := open[len(open)-1]; top != string(name) {
+				return fmt.Errorf("</%s> closes <%s>", name, top)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+}
+
+func isVoidElement(name string) bool {
+	switch name {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr":
+		return true
+	}
+	return false
+}
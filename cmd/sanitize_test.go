@@ -0,0 +1,58 @@
+package cmd
+
+import "testing"
+
+func TestSanitizeTranslation(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		want        string
+		wantRemoved int
+	}{
+		{"plain markup", `Xin <em class="x">chào</em><br/>`, `Xin <em class="x">chào</em><br/>`, 0},
+		{"script", `Xin<script>alert(1)</script> chào`, `Xin chào`, 1},
+		{"event handler", `<img src="a.png" onerror="alert(1)"/>`, `<img src="a.png"/>`, 1},
+		{"javascript link", `<a href=" javascript:alert(1)">x</a>`, `<a>x</a>`, 1},
+		{"relative link", `<a href="ch2.xhtml#p1">x</a>`, `<a href="ch2.xhtml#p1">x</a>`, 0},
+		{"unknown element keeps content", `<font color="red">đỏ</font>`, `đỏ`, 1},
+		{"style with url", `<span style="background:url(x)">a</span>`, `<span>a</span>`, 1},
+		{"mathml attributes", `<math><mi mathvariant="bold">x</mi></math>`, `<math><mi mathvariant="bold">x</mi></math>`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed, err := sanitizeTranslation(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeTranslation() = %q, want %q", got, tt.want)
+			}
+			if len(removed) != tt.wantRemoved {
+				t.Errorf("removed = %v, want %d items", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestCheckWellFormed(t *testing.T) {
+	tests := []struct {
+		content string
+		wantErr bool
+	}{
+		{`Xin <em>chào</em>`, false},
+		{`a<br>b<img src="x.png">`, false},
+		{`a &amp; b &nbsp;`, false},
+		{`Xin <em>chào`, true},
+		{`<em>a</strong>`, true},
+		{`a</p><p>b`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			if err := checkWellFormed(tt.content); (err != nil) != tt.wantErr {
+				t.Errorf("checkWellFormed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	searchLimit    = 50
+	maxSearchLimit = 500
+	// snippetBefore and snippetAfter are the runes of context around a match.
+	snippetBefore = 40
+	snippetAfter  = 80
+)
+
+// searchDoc is the text of a segment or of its translation.
+type searchDoc struct {
+	ContentID     string
+	TranslationID string
+	// Field is "source" or "translation".
+	Field string
+	Text  string
+}
+
+// indexedFile is the inverted index of one content document.
+type indexedFile struct {
+	modTime  time.Time
+	docs     []searchDoc
+	postings map[string][]int
+}
+
+// searchIndex is an in-memory inverted index of the source and translated
+// text of the content documents. A file is indexed again when it has changed
+// since it was indexed, so edits made while serving are found.
+type searchIndex struct {
+	contentDir string
+	// hrefs are the content documents, spine files first in reading order.
+	hrefs []string
+
+	mu    sync.Mutex
+	files map[string]*indexedFile
+}
+
+// newSearchIndex indexes the content documents of the book at unzipPath.
+func newSearchIndex(unzipPath string) (*searchIndex, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &searchIndex{contentDir: book.contentDir, files: make(map[string]*indexedFile)}
+	seen := make(map[string]bool)
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item != nil && item.MediaType == "application/xhtml+xml" && !seen[item.Href] {
+			index.hrefs = append(index.hrefs, item.Href)
+			seen[item.Href] = true
+		}
+	}
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType == "application/xhtml+xml" && !seen[item.Href] {
+			index.hrefs = append(index.hrefs, item.Href)
+			seen[item.Href] = true
+		}
+	}
+
+	return index, index.refresh()
+}
+
+// refresh indexes the files changed since they were last indexed.
+func (idx *searchIndex) refresh() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, href := range idx.hrefs {
+		filePath := filepath.Join(idx.contentDir, href)
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			delete(idx.files, href)
+			continue
+		}
+		if f, ok := idx.files[href]; ok && f.modTime.Equal(fi.ModTime()) {
+			continue
+		}
+
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", href, err)
+		}
+		idx.files[href] = indexFile(doc, fi.ModTime())
+	}
+	return nil
+}
+
+// indexFile indexes the marked segments and the translations of a document.
+func indexFile(doc *goquery.Document, modTime time.Time) *indexedFile {
+	f := &indexedFile{modTime: modTime, postings: make(map[string][]int)}
+	add := func(d searchDoc) {
+		if d.Text == "" {
+			return
+		}
+		i := len(f.docs)
+		f.docs = append(f.docs, d)
+		seen := make(map[string]bool)
+		for _, token := range searchTokens(d.Text) {
+			if !seen[token] {
+				f.postings[token] = append(f.postings[token], i)
+				seen[token] = true
+			}
+		}
+	}
+
+	// contentIDs maps the translations in this file to their segment.
+	contentIDs := make(map[string]string)
+	doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+		contentID := s.AttrOr(util.ContentIdKey, "")
+		translationID := s.AttrOr(util.TranslationByIdKey, "")
+		if translationID != "" {
+			contentIDs[translationID] = contentID
+		}
+		add(searchDoc{ContentID: contentID, TranslationID: translationID, Field: "source", Text: strings.Join(strings.Fields(s.Text()), " ")})
+	})
+	doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+		translationID := s.AttrOr(util.TranslationIdKey, "")
+		add(searchDoc{ContentID: contentIDs[translationID], TranslationID: translationID, Field: "translation", Text: strings.Join(strings.Fields(s.Text()), " ")})
+	})
+
+	return f
+}
+
+// isIdeograph reports whether r belongs to a script written without spaces
+// between words; every such character is a token of its own.
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+// searchTokens splits text into lower-case words.
+func searchTokens(text string) []string {
+	var tokens []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isIdeograph(r):
+			flush()
+			tokens = append(tokens, string(unicode.ToLower(r)))
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r):
+			word = append(word, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// searchHit is a segment or translation containing every word of a query.
+type searchHit struct {
+	FilePath      string `json:"file_path"`
+	ContentID     string `json:"content_id,omitempty"`
+	TranslationID string `json:"translation_id,omitempty"`
+	Field         string `json:"field"`
+	Snippet       string `json:"snippet"`
+}
+
+type searchResults struct {
+	Query string      `json:"query"`
+	Total int         `json:"total"`
+	Hits  []searchHit `json:"hits"`
+}
+
+// search returns the first limit texts containing every word of query, in
+// reading order, and the number of texts found.
+func (idx *searchIndex) search(query string, limit int) (searchResults, error) {
+	results := searchResults{Query: query, Hits: []searchHit{}}
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return results, nil
+	}
+	if err := idx.refresh(); err != nil {
+		return results, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, href := range idx.hrefs {
+		f := idx.files[href]
+		if f == nil {
+			continue
+		}
+		for _, i := range f.match(tokens) {
+			results.Total++
+			if len(results.Hits) >= limit {
+				continue
+			}
+			d := f.docs[i]
+			results.Hits = append(results.Hits, searchHit{
+				FilePath:      "/" + href,
+				ContentID:     d.ContentID,
+				TranslationID: d.TranslationID,
+				Field:         d.Field,
+				Snippet:       snippet(d.Text, tokens[0]),
+			})
+		}
+	}
+	return results, nil
+}
+
+// match returns the documents containing all tokens, in document order.
+func (f *indexedFile) match(tokens []string) []int {
+	matches := f.postings[tokens[0]]
+	for _, token := range tokens[1:] {
+		if len(matches) == 0 {
+			return nil
+		}
+		// Postings are in ascending order, so they intersect in one pass.
+		postings := f.postings[token]
+		var both []int
+		for i, j := 0, 0; i < len(matches) && j < len(postings); {
+			switch {
+			case matches[i] < postings[j]:
+				i++
+			case matches[i] > postings[j]:
+				j++
+			default:
+				both = append(both, matches[i])
+				i, j = i+1, j+1
+			}
+		}
+		matches = both
+	}
+	return matches
+}
+
+// snippet returns the part of text around the first occurrence of token.
+func snippet(text, token string) string {
+	// Lower rune by rune, as searchTokens does, so positions in lower are
+	// those in runes.
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	at := 0
+	if i := strings.Index(string(lower), token); i > 0 {
+		at = utf8.RuneCountInString(string(lower)[:i])
+	}
+
+	start, end := max(at-snippetBefore, 0), min(at+snippetAfter, len(runes))
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// registerSearchAPI adds the full-text search over the source and translated
+// text of the book.
+func registerSearchAPI(api fiber.Router, index *searchIndex) {
+	api.Get("/search", func(c *fiber.Ctx) error {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "q is required"})
+		}
+		limit := searchLimit
+		if l := c.Query("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "limit must be a positive number"})
+			}
+			limit = min(n, maxSearchLimit)
+		}
+
+		results, err := index.search(query, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to index book"})
+		}
+		return c.JSON(results)
+	})
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := map[string][]string{
+		"The Translation-Memory, 2nd ed.": {"the", "translation", "memory", "2nd", "ed"},
+		"Café déjà vu":                    {"café", "déjà", "vu"},
+		"翻訳メモリ":                           {"翻", "訳", "メ", "モ", "リ"},
+		"  ":                              nil,
+	}
+	for text, want := range tests {
+		if got := searchTokens(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("searchTokens(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestIndexFile(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+<p data-content-id="c1" data-translation-by-id="t1">The translation memory stores segments.</p>
+<p data-translation-id="t1">Het vertaalgeheugen bewaart segmenten.</p>
+<p data-content-id="c2">Memory of a translation.</p>
+<p data-content-id="c3">Nothing here.</p>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := indexFile(doc, time.Now())
+
+	var got []searchDoc
+	for _, i := range f.match(searchTokens("Translation MEMORY")) {
+		got = append(got, f.docs[i])
+	}
+	if len(got) != 2 || got[0].ContentID != "c1" || got[0].Field != "source" || got[1].ContentID != "c2" {
+		t.Errorf("matches of source text = %+v", got)
+	}
+
+	matches := f.match(searchTokens("vertaalgeheugen"))
+	if len(matches) != 1 {
+		t.Fatalf("matches of translated text = %v", matches)
+	}
+	if d := f.docs[matches[0]]; d.Field != "translation" || d.TranslationID != "t1" || d.ContentID != "c1" {
+		t.Errorf("translation = %+v", d)
+	}
+
+	if matches := f.match(searchTokens("memory nothing")); len(matches) != 0 {
+		t.Errorf("matches of words in different segments = %v", matches)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := strings.Repeat("ÄÖÜ ", 20) + "Terminology" + strings.Repeat(" äöü", 40)
+	s := snippet(text, "terminology")
+	if !strings.HasPrefix(s, "…") || !strings.HasSuffix(s, "…") {
+		t.Errorf("snippet = %q, want ellipses on both sides", s)
+	}
+	if !strings.Contains(s, "Terminology") {
+		t.Errorf("snippet = %q, want the match", s)
+	}
+	if got := snippet("Short text", "text"); got != "Short text" {
+		t.Errorf("snippet of short text = %q", got)
+	}
+}
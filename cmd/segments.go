@@ -0,0 +1,552 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The segment API lists and updates the segments of a book as JSON, so other
+// frontends can work on a book without reading the content ids and
+// translation ids out of its markup.
+
+// apiSegment is a segment of a file with its translation.
+type apiSegment struct {
+	ContentID string `json:"content_id"`
+	// Occurrence counts the segments with the content id before this one in
+	// the file, as repeats of a block share its content id.
+	Occurrence int `json:"occurrence,omitempty"`
+	// Source is the markup of the original.
+	Source        string `json:"source"`
+	TranslationID string `json:"translation_id,omitempty"`
+	// Translation is the markup of the translation, "" if not translated.
+	Translation string `json:"translation"`
+	// TranslationFile is the file of the translation when it is not the file
+	// of the original, as with endnotes.
+	TranslationFile string     `json:"translation_file,omitempty"`
+	Lang            string     `json:"lang,omitempty"`
+	Provenance      provenance `json:"provenance"`
+	// Hash identifies the translation; send it in If-Match to update the
+	// translation only if it did not change meanwhile.
+	Hash string `json:"hash"`
+	// Status is untranslated, machine-translated, human-edited, approved or
+	// rejected.
+	Status string `json:"status"`
+	// Stale tells that the original changed since it was translated.
+	Stale  bool              `json:"stale"`
+	Review *reviewAnnotation `json:"review,omitempty"`
+	Lock   *segmentLock      `json:"lock,omitempty"`
+}
+
+type fileSegmentsResponse struct {
+	FilePath string       `json:"file_path"`
+	Segments []apiSegment `json:"segments"`
+}
+
+type UpdateSegmentRequest struct {
+	// FilePath is the file of the segment, needed only when its content id is
+	// in several files.
+	FilePath    string `json:"file_path"`
+	Translation string `json:"translation"`
+}
+
+var (
+	errSegmentNotFound  = errors.New("segment not found")
+	errSegmentAmbiguous = errors.New("the content id is in several files; pass file_path")
+	errSegmentChanged   = errors.New("the translation changed meanwhile")
+)
+
+// segmentSource returns the markup of an original without the link to its
+// translation in a note.
+func segmentSource(s *goquery.Selection) string {
+	original := s.Clone()
+	original.Find("a.epubtrans-noteref").Remove()
+	html, _ := original.Html()
+	return strings.TrimSpace(html)
+}
+
+// xhtmlFiles returns the hrefs of the XHTML documents of the book.
+func xhtmlFiles(book *bookFiles) []string {
+	var hrefs []string
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType == "application/xhtml+xml" {
+			hrefs = append(hrefs, item.Href)
+		}
+	}
+	return hrefs
+}
+
+// translationFiles indexes the files of the book by the IDs of the
+// translations they hold, for translations kept away from their original.
+// The first file holding an ID wins.
+func translationFiles(book *bookFiles) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, href := range xhtmlFiles(book) {
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", href, err)
+		}
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			if id := s.AttrOr(util.TranslationIdKey, ""); files[id] == "" {
+				files[id] = href
+			}
+		})
+	}
+	return files, nil
+}
+
+func findTranslation(doc *goquery.Document, translationID string) *goquery.Selection {
+	return findTranslationAt(doc, translationID, 0)
+}
+
+// findTranslationAt returns the translation with translationID after
+// occurrence others with it, as repeats of a block translated alike share
+// the ID.
+func findTranslationAt(doc *goquery.Document, translationID string, occurrence int) *goquery.Selection {
+	translations := doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.AttrOr(util.TranslationIdKey, "") == translationID
+	})
+	if occurrence < 0 || occurrence >= translations.Length() {
+		return nil
+	}
+	return translations.Eq(occurrence)
+}
+
+// segmentUnitID identifies a segment of a file in exports: its content id,
+// followed by "." and the occurrence for the repeats of a block, which share
+// its content id.
+func segmentUnitID(contentID string, occurrence int) string {
+	if occurrence == 0 {
+		return contentID
+	}
+	return contentID + "." + strconv.Itoa(occurrence)
+}
+
+// splitSegmentUnitID returns the content id and the occurrence of the
+// segmentUnitID id.
+func splitSegmentUnitID(id string) (contentID string, occurrence int) {
+	contentID, n, ok := strings.Cut(id, ".")
+	if !ok {
+		return id, 0
+	}
+	occurrence, err := strconv.Atoi(n)
+	if err != nil || occurrence < 0 {
+		return id, 0
+	}
+	return contentID, occurrence
+}
+
+// fileSegments returns the segments of the file href in reading order.
+func fileSegments(book *bookFiles, href string, reviews *reviewStore, locks *segmentLocks) ([]apiSegment, error) {
+	doc, err := openAndReadFile(filepath.Join(book.contentDir, href))
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := reviews.annotations(href)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []apiSegment{}
+	// elsewhere holds the originals whose translation is in another file.
+	elsewhere := map[int]*goquery.Selection{}
+	occurrences, linked := map[string]int{}, map[string]int{}
+	doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+		segment := apiSegment{ContentID: s.AttrOr(util.ContentIdKey, ""), Source: segmentSource(s), TranslationID: s.AttrOr(util.TranslationByIdKey, "")}
+		segment.Occurrence = occurrences[segment.ContentID]
+		occurrences[segment.ContentID]++
+		if segment.TranslationID != "" {
+			if translation := findTranslationAt(doc, segment.TranslationID, linked[segment.TranslationID]); translation != nil {
+				segment.setTranslation(s, translation)
+			} else {
+				elsewhere[len(segments)] = s
+			}
+			linked[segment.TranslationID]++
+		}
+		if annotation, ok := annotations[segment.ContentID]; ok {
+			segment.Review = &annotation
+		}
+		if lock, ok := locks.held(href, segment.ContentID); ok {
+			segment.Lock = &lock
+		}
+		segment.Hash = translationHash(segment.Translation)
+		segments = append(segments, segment)
+	})
+
+	// Translations in notes documents are looked up in the other files,
+	// each read once.
+	if len(elsewhere) > 0 {
+		files, err := translationFiles(book)
+		if err != nil {
+			return nil, err
+		}
+		docs := map[string]*goquery.Document{}
+		for i, original := range elsewhere {
+			translationFile := files[segments[i].TranslationID]
+			if translationFile == "" {
+				continue
+			}
+			other, ok := docs[translationFile]
+			if !ok {
+				if other, err = openAndReadFile(filepath.Join(book.contentDir, translationFile)); err != nil {
+					return nil, fmt.Errorf("reading %s: %w", translationFile, err)
+				}
+				docs[translationFile] = other
+			}
+			if translation := findTranslation(other, segments[i].TranslationID); translation != nil {
+				segments[i].setTranslation(original, translation)
+				segments[i].TranslationFile = translationFile
+				segments[i].Hash = translationHash(segments[i].Translation)
+			}
+		}
+	}
+	for i, segment := range segments {
+		segments[i].Status = segmentStatus(segment.Translation, segment.Provenance, segment.Review)
+	}
+	return segments, nil
+}
+
+func (s *apiSegment) setTranslation(original, translation *goquery.Selection) {
+	s.Translation, _ = translation.Html()
+	s.Lang = translation.AttrOr(util.TranslationLangKey, "")
+	s.Provenance = provenanceOf(translation)
+	s.Stale = isStale(original, translation)
+}
+
+// segmentFile returns the file holding the original of contentID.
+func segmentFile(book *bookFiles, contentID string) (string, error) {
+	var found []string
+	for _, href := range xhtmlFiles(book) {
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, href))
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", href, err)
+		}
+		if original, _ := findSegment(doc, contentID); original != nil {
+			found = append(found, href)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", errSegmentNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return "", errSegmentAmbiguous
+	}
+}
+
+// segmentTranslationFile returns the file holding the translation of the
+// segment contentID of href: href itself unless the translation is in a note
+// of another file.
+func segmentTranslationFile(book *bookFiles, href, contentID string) (string, error) {
+	doc, err := openAndReadFile(filepath.Join(book.contentDir, href))
+	if err != nil {
+		return "", err
+	}
+	original, translation := findSegment(doc, contentID)
+	if original == nil {
+		return "", errSegmentNotFound
+	}
+	translationID := original.AttrOr(util.TranslationByIdKey, "")
+	if translation != nil || translationID == "" {
+		return href, nil
+	}
+	files, err := translationFiles(book)
+	if err != nil {
+		return "", err
+	}
+	if files[translationID] == "" {
+		return "", errSegmentNotFound
+	}
+	return files[translationID], nil
+}
+
+// updateSegment replaces the translation of the segment contentID of href,
+// or adds one, as an edit. With ifMatch, the translation is only replaced if
+// it still has that hash.
+func updateSegment(book *bookFiles, href, contentID, translated, ifMatch string, edits *editLog) error {
+	filePath := filepath.Join(book.contentDir, href)
+	// The file of a translation in a note is looked up before any lock is
+	// taken, so both files are locked together and in a fixed order.
+	translationFile, err := segmentTranslationFile(book, href, contentID)
+	if err != nil {
+		return err
+	}
+	unlock := lockFiles(filePath, filepath.Join(book.contentDir, translationFile))
+	defer unlock()
+
+	doc, err := openAndReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	original, translation := findSegment(doc, contentID)
+	if original == nil {
+		return errSegmentNotFound
+	}
+
+	if translationID := original.AttrOr(util.TranslationByIdKey, ""); translation == nil && translationID != "" && translationFile != href {
+		filePath = filepath.Join(book.contentDir, translationFile)
+		if doc, err = openAndReadFile(filePath); err != nil {
+			return err
+		}
+		// The translation moved meanwhile.
+		if translation = findTranslation(doc, translationID); translation == nil {
+			return errSegmentNotFound
+		}
+	}
+
+	if translation == nil {
+		if ifMatch != "" && ifMatch != translationHash("") {
+			return errSegmentChanged
+		}
+		if err := placeTranslation(doc, original, filePath, targetLanguage, translated, manualProvenance); err != nil {
+			return err
+		}
+		return writeContentToFile(filePath, doc)
+	}
+
+	old, _ := translation.Html()
+	if ifMatch != "" && ifMatch != translationHash(old) {
+		return errSegmentChanged
+	}
+	edit := editEntry{Time: time.Now(), TranslationID: translation.AttrOr(util.TranslationIdKey, ""), Old: old, OldProvenance: provenanceOf(translation)}
+	translation.SetHtml(translated)
+	manualProvenance.apply(translation)
+	stampSource(translation, original)
+	edit.New, _ = translation.Html()
+	if err := writeContentToFile(filePath, doc); err != nil {
+		return err
+	}
+	recordEdit(edits, translationFile, edit)
+	return nil
+}
+
+// segmentEdit is a translation of a segment edited outside epubtrans, e.g.
+// in a CAT tool or a spreadsheet.
+type segmentEdit struct {
+	File      string
+	ContentID string
+	// Occurrence tells which of the segments with ContentID in File is
+	// edited, see segmentUnitID.
+	Occurrence int
+	// Source is the original as it was exported; the edit is skipped when
+	// the text of the original changed since.
+	Source      string
+	Translation string
+	// Verdict is approved or rejected to give the translation that verdict
+	// in the review, or "".
+	Verdict string
+}
+
+// segmentImport counts what happened to the edits of an import.
+type segmentImport struct {
+	Applied   int
+	Unchanged int
+	// Skipped describes the edits left alone for a reason worth telling.
+	Skipped []string
+}
+
+// importSegmentEdits writes edits into the book as translations edited by
+// hand, recorded in the edit history. Translations are cleaned like edits in
+// serve. lang is the language of new translations, by default that of the
+// translations in the book.
+func importSegmentEdits(unzipPath, lang string, edits []segmentEdit) (segmentImport, error) {
+	var result segmentImport
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return result, err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unzipPath))
+	if err != nil {
+		return result, err
+	}
+
+	files := map[string]map[string]apiSegment{}
+	var changes []syncChange
+	for _, edit := range edits {
+		href := editHref(edit.File)
+		byID, ok := files[href]
+		if !ok {
+			segments, err := fileSegments(book, href, reviews, newSegmentLocks())
+			if err != nil && !os.IsNotExist(err) {
+				return result, err
+			}
+			byID = make(map[string]apiSegment, len(segments))
+			for _, segment := range segments {
+				byID[segmentUnitID(segment.ContentID, segment.Occurrence)] = segment
+				if lang == "" {
+					lang = segment.Lang
+				}
+			}
+			files[href] = byID
+		}
+
+		// Repeats of a block are told apart by their position in the file.
+		unitID := segmentUnitID(edit.ContentID, edit.Occurrence)
+		segment, ok := byID[unitID]
+		if !ok {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s in %s: segment not in the book", unitID, href))
+			continue
+		}
+		if markupText(edit.Source) != markupText(segment.Source) {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s in %s: the original changed since the export", unitID, href))
+			continue
+		}
+		if err := checkWellFormed(edit.Translation); err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s in %s: invalid translation: %v", unitID, href, err))
+			continue
+		}
+		translated, removed, err := sanitizeTranslation(edit.Translation)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s in %s: invalid translation: %v", unitID, href, err))
+			continue
+		}
+		if len(removed) > 0 {
+			fmt.Printf("  %s in %s: removed %s\n", unitID, href, strings.Join(removed, ", "))
+		}
+
+		change := syncChange{
+			Segment: syncSegment{ContentID: edit.ContentID, Occurrence: edit.Occurrence, File: href, Translation: translated, Lang: lang, Provenance: manualProvenance},
+			Base:    syncHashes{Review: reviewHash(segment.Review)},
+		}
+		if segment.Translation != "" {
+			change.Base.Translation = translationHash(segment.Translation)
+		}
+		change.Translation = translated != segment.Translation
+		if edit.Verdict != "" && (change.Translation || segment.Status != edit.Verdict) {
+			change.Review = true
+			change.Segment.Review = &reviewAnnotation{File: href, Status: edit.Verdict, Translation: translationHash(translated), Reviewed: time.Now()}
+			if segment.Review != nil {
+				change.Segment.Review.Note = segment.Review.Note
+			}
+		}
+		if !change.Translation && !change.Review {
+			result.Unchanged++
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return result, nil
+	}
+	for i := range changes {
+		if changes[i].Segment.Lang == "" {
+			changes[i].Segment.Lang = lang
+		}
+	}
+
+	applied, err := applySyncChanges(unzipPath, reviews, newEditLog(unzipPath), nil, changes)
+	if err != nil {
+		return result, err
+	}
+	for _, conflict := range applied.Conflicts {
+		result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", conflict.ContentID, conflict.Reason))
+	}
+	result.Applied = applied.Applied
+	return result, nil
+}
+
+// markupText returns the text of markup with its whitespace normalized.
+func markupText(markup string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + markup + "</body>"))
+	if err != nil {
+		return markup
+	}
+	return strings.TrimSpace(whitespaceRegex.ReplaceAllString(doc.Find("body").Text(), " "))
+}
+
+// registerSegmentAPI adds the endpoints listing and updating the segments of
+// the files of the book.
+func registerSegmentAPI(api fiber.Router, unpackedEpubPath string, reviews *reviewStore, edits *editLog, locks *segmentLocks) {
+	api.Get("/files/*/segments", func(c *fiber.Ctx) error {
+		filePath, err := url.PathUnescape(c.Params("*"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file path"})
+		}
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		href := editHref(filePath)
+		segments, err := fileSegments(book, href, reviews, locks)
+		if os.IsNotExist(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fileSegmentsResponse{FilePath: href, Segments: segments})
+	})
+
+	api.Put("/segments/:id", func(c *fiber.Ctx) error {
+		var req UpdateSegmentRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if err := checkWellFormed(req.Translation); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid translation: " + err.Error()})
+		}
+		translated, removed := req.Translation, []string{}
+		if !trustHTML {
+			var err error
+			if translated, removed, err = sanitizeTranslation(req.Translation); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid translation: " + err.Error()})
+			}
+		}
+
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		contentID, href := c.Params("id"), editHref(req.FilePath)
+		if req.FilePath == "" {
+			if href, err = segmentFile(book, contentID); err != nil {
+				return segmentError(c, err)
+			}
+		}
+		if lock, ok := locks.held(href, contentID); ok && lock.Kind == lockAI {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{"error": "The segment is being translated by AI " + lock.Holder, "lock": lock})
+		}
+
+		ifMatch := strings.Trim(c.Get(fiber.HeaderIfMatch), `"`)
+		if err := updateSegment(book, href, contentID, translated, ifMatch, edits); err != nil {
+			return segmentError(c, err)
+		}
+
+		segments, err := fileSegments(book, href, reviews, locks)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		for _, segment := range segments {
+			if segment.ContentID == contentID {
+				return c.JSON(fiber.Map{"segment": segment, "removed": removed})
+			}
+		}
+		return segmentError(c, errSegmentNotFound)
+	})
+}
+
+// segmentError answers a failed request of the segment API.
+func segmentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errSegmentNotFound), os.IsNotExist(err):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Segment not found"})
+	case errors.Is(err, errSegmentAmbiguous):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errSegmentChanged):
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSegmentAPI(t *testing.T) {
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits := newEditLog(dir)
+	app := fiber.New()
+	registerSegmentAPI(app.Group(apiV1), dir, reviews, edits, newSegmentLocks())
+
+	list := func() map[string]apiSegment {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", apiV1+"/files/ch1.xhtml/segments", nil))
+		if err != nil || resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("listing segments: %v, %v", resp, err)
+		}
+		var body fileSegmentsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		segments := map[string]apiSegment{}
+		for _, s := range body.Segments {
+			segments[s.ContentID] = s
+		}
+		return segments
+	}
+	put := func(contentID, body, ifMatch string) int {
+		t.Helper()
+		req := httptest.NewRequest("PUT", apiV1+"/segments/"+contentID, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", `"`+ifMatch+`"`)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	segments := list()
+	if len(segments) != 4 {
+		t.Fatalf("got %d segments, want 4", len(segments))
+	}
+	a := segments["a"]
+	if a.Source != "Hello" || a.Translation != "Hallo" || a.TranslationID != "ta" || a.Lang != "de" {
+		t.Errorf("segment a = %+v", a)
+	}
+	if segments["b"].Translation != "" {
+		t.Errorf("segment b is translated: %+v", segments["b"])
+	}
+
+	if status := put("a", `{"translation": "Guten Tag"}`, a.Hash); status != fiber.StatusOK {
+		t.Fatalf("updating a: status %d", status)
+	}
+	if status := put("a", `{"translation": "Servus"}`, a.Hash); status != fiber.StatusPreconditionFailed {
+		t.Errorf("updating a with a stale hash: status %d, want 412", status)
+	}
+	if status := put("b", `{"translation": "Welt"}`, ""); status != fiber.StatusOK {
+		t.Fatalf("translating b: status %d", status)
+	}
+	if status := put("z", `{"translation": "Nichts"}`, ""); status != fiber.StatusNotFound {
+		t.Errorf("updating an unknown segment: status %d, want 404", status)
+	}
+
+	segments = list()
+	if got := segments["a"]; got.Translation != "Guten Tag" || got.Provenance.Provider != "manual" {
+		t.Errorf("segment a after the update = %+v", got)
+	}
+	if got := segments["b"]; got.Translation != "Welt" {
+		t.Errorf("segment b after the update = %+v", got)
+	}
+
+	history, err := edits.entries("ch1.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Old != "Hallo" || history[0].New != "Guten Tag" {
+		t.Errorf("edit history = %+v", history)
+	}
+}
+
+func TestUpdateSegmentInNote(t *testing.T) {
+	dir := t.TempDir()
+	writeChaptersBook(t, dir, map[string]string{
+		"ch1.xhtml":   chapterDocument("", `<p data-content-id="a" data-translation-by-id="ta">Hello</p>`),
+		"notes.xhtml": chapterDocument("", `<aside data-translation-id="ta" data-translation-lang="de">Hallo</aside>`),
+	}, "ch1.xhtml", "notes.xhtml")
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := fileSegments(book, "ch1.xhtml", reviews, newSegmentLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0].Translation != "Hallo" || segments[0].TranslationFile != "notes.xhtml" {
+		t.Fatalf("segments = %+v, want the translation in notes.xhtml", segments)
+	}
+
+	// Locking the files the other way round meanwhile must not deadlock.
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if err := updateSegment(book, "ch1.xhtml", "a", fmt.Sprintf("Hallo %d", i), "", newEditLog(dir)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 50; i++ {
+		lockFiles(filepath.Join(book.contentDir, "notes.xhtml"), filepath.Join(book.contentDir, "ch1.xhtml"))()
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("updateSegment deadlocked")
+	}
+
+	segments, err = fileSegments(book, "ch1.xhtml", reviews, newSegmentLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments[0].Translation != "Hallo 49" {
+		t.Errorf("translation after the updates = %q", segments[0].Translation)
+	}
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/dutchsteven/epubtrans/pkg/xhtml"
+	"golang.org/x/net/html"
+)
+
+// The size of the segments mark makes can be limited, in characters, to
+// suit the model and the review: a block longer than --max-chars is split
+// into spans of whole sentences, as many as fit, and a sentence shorter than
+// --min-chars is merged with the one before it, or after it when it comes
+// first. A single sentence longer than the maximum stays whole, and blocks
+// shorter than the minimum are not merged, as they have no element in
+// common to mark.
+//
+// Marking a book again with other limits re-marks the segments that are not
+// translated yet, so the limits can be tuned without editing the HTML;
+// translated segments keep their ids.
+
+var (
+	// minSegmentChars is the length of the shortest sentence segment; zero
+	// does not merge sentences.
+	minSegmentChars int
+	// maxSegmentChars is the length of the longest segment; zero does not
+	// split blocks.
+	maxSegmentChars int
+)
+
+func init() {
+	Mark.Flags().IntVar(&minSegmentChars, "min-chars", 0, "merge sentences shorter than this many characters with their neighbour when a block is split")
+	Mark.Flags().IntVar(&maxSegmentChars, "max-chars", 0, "split blocks longer than this many characters into segments of whole sentences; 0 for no limit")
+}
+
+// splitsBlock reports whether a block with the text content is marked by
+// sentence segments rather than as a whole.
+func (m *marker) splitsBlock(content string) bool {
+	return m.granularity == granularitySentence || (m.maxChars > 0 && utf8.RuneCountInString(content) > m.maxChars)
+}
+
+// groupPieces groups the sentence pieces of a block into segments: every
+// sentence on its own with --granularity sentence, else as many sentences as
+// fit in --max-chars, and short sentences merged with a neighbour.
+func (m *marker) groupPieces(pieces []sentencePiece) []sentencePiece {
+	length := func(p sentencePiece) int {
+		return utf8.RuneCountInString(strings.TrimSpace(p.text()))
+	}
+
+	var groups []sentencePiece
+	for _, piece := range pieces {
+		last := len(groups) - 1
+		if m.granularity != granularitySentence && last >= 0 && length(append(groups[last][:len(groups[last]):len(groups[last])], piece...)) <= m.maxChars {
+			groups[last] = append(groups[last], piece...)
+			continue
+		}
+		groups = append(groups, piece)
+	}
+
+	if m.minChars <= 0 {
+		return groups
+	}
+	for i := 0; i < len(groups) && len(groups) > 1; {
+		if length(groups[i]) >= m.minChars {
+			i++
+			continue
+		}
+		if i > 0 {
+			groups[i-1] = append(groups[i-1], groups[i]...)
+			groups = append(groups[:i], groups[i+1:]...)
+			continue
+		}
+		groups[1] = append(groups[0], groups[1]...)
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// needsRemarking reports whether the segments of the element n were marked
+// with other limits and none of them is translated yet: a block marked as a
+// whole that is now split, or a block split into sentence spans.
+func (m *marker) needsRemarking(n *html.Node) bool {
+	if n.Type != html.ElementNode || hasAttr(n, util.TranslationIdKey) {
+		return false
+	}
+	if hasAttr(n, util.ContentIdKey) {
+		return !hasAttr(n, util.TranslationByIdKey) && m.splitsBlock(extractTextContent(n))
+	}
+
+	spans := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if hasAttr(c, util.TranslationByIdKey) || hasAttr(c, util.TranslationIdKey) {
+			return false
+		}
+		spans = spans || isSentenceSpan(c)
+	}
+	return spans
+}
+
+// isSentenceSpan reports whether n is a span mark wrapped a sentence segment
+// in.
+func isSentenceSpan(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "span" || !hasAttr(n, util.ContentIdKey) {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == "class" && attr.Val == sentenceSpanClass {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarkSegments removes the segments of the element n, for needsRemarking:
+// its content id, or the sentence spans around its text.
+func unmarkSegments(n *html.Node) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		if attr.Key != util.ContentIdKey {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = attrs
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if isSentenceSpan(c) {
+			for c.FirstChild != nil {
+				child := c.FirstChild
+				c.RemoveChild(child)
+				n.InsertBefore(child, c)
+			}
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+
+	// Join the text the spans split.
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		for c.Type == html.TextNode && c.NextSibling != nil && c.NextSibling.Type == html.TextNode {
+			c.Data += c.NextSibling.Data
+			n.RemoveChild(c.NextSibling)
+		}
+	}
+}
+
+// remarkNode marks the element n again with the current limits and reports
+// whether its markup changed.
+func (m *marker) remarkNode(n *html.Node) bool {
+	var before, after bytes.Buffer
+	xhtml.Render(&before, n)
+	unmarkSegments(n)
+	m.markNode(n)
+	xhtml.Render(&after, n)
+	return before.String() != after.String()
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
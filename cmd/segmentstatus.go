@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The status of a segment tells how far its translation is in review. It is
+// derived from what the book records already: the provenance attributes of
+// the translation and the review verdict in <unpacked-dir>-review.json.
+const (
+	statusUntranslated = "untranslated"
+	// statusMachine is a translation by a model or reused from a memory.
+	statusMachine = "machine-translated"
+	// statusEdited is a translation edited by hand in serve.
+	statusEdited   = "human-edited"
+	statusApproved = "approved"
+	statusRejected = "rejected"
+)
+
+// segmentStatuses are the statuses in the order of the review.
+var segmentStatuses = []string{statusUntranslated, statusMachine, statusEdited, statusApproved, statusRejected}
+
+// segmentStatus returns the status of a segment with the given translation.
+// A verdict on another version of the translation no longer counts.
+func segmentStatus(translation string, origin provenance, review *reviewAnnotation) string {
+	switch {
+	case strings.TrimSpace(translation) == "":
+		return statusUntranslated
+	case review != nil && review.Translation == translationHash(translation) && review.Status == reviewApproved:
+		return statusApproved
+	case review != nil && review.Translation == translationHash(translation) && review.Status == reviewRejected:
+		return statusRejected
+	case origin.Provider == manualProvenance.Provider:
+		return statusEdited
+	default:
+		return statusMachine
+	}
+}
+
+// statusCounts is the number of segments with every status.
+type statusCounts map[string]int
+
+func newStatusCounts() statusCounts {
+	counts := statusCounts{}
+	for _, status := range segmentStatuses {
+		counts[status] = 0
+	}
+	return counts
+}
+
+func (c statusCounts) String() string {
+	parts := make([]string, 0, len(segmentStatuses))
+	for _, status := range segmentStatuses {
+		parts = append(parts, fmt.Sprintf("%d %s", c[status], status))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// chapterStatus counts the statuses of the segments of a chapter.
+type chapterStatus struct {
+	File     string       `json:"file"`
+	Segments int          `json:"segments"`
+	Counts   statusCounts `json:"counts"`
+}
+
+// translationStatus counts the statuses of the segments of the book.
+type translationStatus struct {
+	Segments int             `json:"segments"`
+	Counts   statusCounts    `json:"counts"`
+	Chapters []chapterStatus `json:"chapters"`
+}
+
+// bookTranslationStatus counts the statuses of the segments of the book, by
+// chapter in reading order.
+func bookTranslationStatus(unpackedEpubPath string, reviews *reviewStore) (translationStatus, error) {
+	status := translationStatus{Counts: newStatusCounts(), Chapters: []chapterStatus{}}
+	book, err := openBookFiles(unpackedEpubPath)
+	if err != nil {
+		return status, err
+	}
+	segments, err := bookSyncState(book, reviews)
+	if err != nil {
+		return status, err
+	}
+
+	chapters := map[string]*chapterStatus{}
+	for _, segment := range segments {
+		chapter, ok := chapters[segment.File]
+		if !ok {
+			chapter = &chapterStatus{File: segment.File, Counts: newStatusCounts()}
+			chapters[segment.File] = chapter
+		}
+		s := segmentStatus(segment.Translation, segment.Provenance, segment.Review)
+		chapter.Segments++
+		chapter.Counts[s]++
+		status.Segments++
+		status.Counts[s]++
+	}
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if chapter, ok := chapters[item.Href]; ok {
+			status.Chapters = append(status.Chapters, *chapter)
+		}
+	}
+	return status, nil
+}
+
+// registerStatusAPI adds the endpoint counting the statuses of the segments.
+func registerStatusAPI(api fiber.Router, unpackedEpubPath string, reviews *reviewStore) {
+	api.Get("/translation-status", func(c *fiber.Ctx) error {
+		status, err := bookTranslationStatus(unpackedEpubPath, reviews)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(status)
+	})
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestSegmentStatus(t *testing.T) {
+	approved := &reviewAnnotation{Status: reviewApproved, Translation: translationHash("Hallo")}
+	rejected := &reviewAnnotation{Status: reviewRejected, Translation: translationHash("Hallo")}
+	tests := []struct {
+		translation string
+		origin      provenance
+		review      *reviewAnnotation
+		want        string
+	}{
+		{"", provenance{}, nil, statusUntranslated},
+		{" ", provenance{Provider: "anthropic"}, approved, statusUntranslated},
+		{"Hallo", provenance{Provider: "anthropic"}, nil, statusMachine},
+		{"Hallo", provenance{Provider: "memory"}, nil, statusMachine},
+		{"Hallo", manualProvenance, nil, statusEdited},
+		{"Hallo", manualProvenance, approved, statusApproved},
+		{"Hallo", provenance{Provider: "anthropic"}, rejected, statusRejected},
+		// The verdict was on another translation.
+		{"Hallo!", manualProvenance, approved, statusEdited},
+	}
+	for _, tt := range tests {
+		if got := segmentStatus(tt.translation, tt.origin, tt.review); got != tt.want {
+			t.Errorf("segmentStatus(%q, %v, %v) = %q, want %q", tt.translation, tt.origin, tt.review, got, tt.want)
+		}
+	}
+}
+
+func TestBookTranslationStatus(t *testing.T) {
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+	editSyncChapter(t, dir, `data-translation-id="ta"`, `data-translation-id="ta" data-translation-provider="manual"`)
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reviews.annotate("ch1.xhtml", "c", reviewAnnotation{Status: reviewApproved, Translation: translationHash("Eins")}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := bookTranslationStatus(dir, reviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := statusCounts{statusUntranslated: 1, statusMachine: 1, statusEdited: 1, statusApproved: 1, statusRejected: 0}
+	if status.Segments != 4 || status.Counts.String() != want.String() {
+		t.Errorf("status = %d (%s), want 4 (%s)", status.Segments, status.Counts, want)
+	}
+	if len(status.Chapters) != 1 || status.Chapters[0].File != "ch1.xhtml" || status.Chapters[0].Segments != 4 {
+		t.Errorf("chapters = %+v", status.Chapters)
+	}
+}
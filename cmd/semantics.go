@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+)
+
+// EPUB 3 documents name what they are with epub:type, such as toc,
+// copyright-page or chapter, and which division of the book they belong to:
+// frontmatter, bodymatter or backmatter. translate can be limited to, or keep
+// away from, documents of given types.
+
+var (
+	// includeTypes are the epub:type values of the documents to translate;
+	// empty translates documents of any type.
+	includeTypes []string
+	// excludeTypes are the epub:type values of the documents not to translate.
+	excludeTypes []string
+
+	// bookSemantics holds the epub:type values of the documents of the book
+	// being translated, by file path, from spineSemantics.
+	bookSemantics map[string][]string
+)
+
+func init() {
+	Translate.Flags().StringSliceVar(&includeTypes, "include-type", nil, "translate only documents of this epub:type, e.g. bodymatter (repeatable)")
+	Translate.Flags().StringSliceVar(&excludeTypes, "exclude-type", nil, "do not translate documents of this epub:type, e.g. toc, copyright-page or index (repeatable)")
+	Estimate.Flags().StringSliceVar(&includeTypes, "include-type", nil, "translate only documents of this epub:type, e.g. bodymatter (repeatable)")
+	Estimate.Flags().StringSliceVar(&excludeTypes, "exclude-type", nil, "do not translate documents of this epub:type, e.g. toc, copyright-page or index (repeatable)")
+}
+
+// divisionTypes are the epub:type values of the divisions of a book. A
+// document without one belongs to the division of the document before it.
+var divisionTypes = []string{"frontmatter", "bodymatter", "backmatter"}
+
+// documentTypes returns the epub:type values of the root, the body and the
+// outermost sections of doc, which describe the whole document.
+func documentTypes(doc *goquery.Document) []string {
+	var types []string
+	add := func(s *goquery.Selection) {
+		for _, t := range strings.Fields(strings.ToLower(s.AttrOr("epub:type", ""))) {
+			if !slices.Contains(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+
+	add(doc.Find("html"))
+	body := doc.Find("body")
+	add(body)
+	body.Find("section, nav, article").Each(func(i int, s *goquery.Selection) {
+		if s.ParentsFiltered("section, nav, article").Length() == 0 {
+			add(s)
+		}
+	})
+	body.ChildrenFiltered("div").Each(func(i int, s *goquery.Selection) { add(s) })
+	return types
+}
+
+// spineSemantics returns the epub:type values of the XHTML documents of the
+// book by file path. Documents are given the division of the document before
+// them in reading order, and the types the landmarks of the navigation
+// document point to them with.
+func spineSemantics(book *bookFiles) (map[string][]string, error) {
+	landmarks, err := landmarkTypes(book)
+	if err != nil {
+		return nil, err
+	}
+
+	semantics := map[string][]string{}
+	division := ""
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		filePath := filepath.Join(book.contentDir, item.Href)
+		doc, err := openAndReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		types := documentTypes(doc)
+		for _, t := range landmarks[path.Clean(item.Href)] {
+			if !slices.Contains(types, t) {
+				types = append(types, t)
+			}
+		}
+		if i := slices.IndexFunc(types, func(t string) bool { return slices.Contains(divisionTypes, t) }); i >= 0 {
+			division = types[i]
+		} else if division != "" {
+			types = append(types, division)
+		}
+		semantics[filePath] = types
+	}
+	return semantics, nil
+}
+
+// landmarkTypes returns the epub:type values the landmarks of the navigation
+// document give documents, by href.
+func landmarkTypes(book *bookFiles) (map[string][]string, error) {
+	landmarks := map[string][]string{}
+	for _, item := range book.pkg.Manifest.Items {
+		if !slices.Contains(strings.Fields(item.Properties), "nav") {
+			continue
+		}
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		doc.Find("nav").Each(func(i int, nav *goquery.Selection) {
+			if !slices.Contains(strings.Fields(nav.AttrOr("epub:type", "")), "landmarks") {
+				return
+			}
+			nav.Find("a").Each(func(i int, a *goquery.Selection) {
+				href, _, _ := strings.Cut(a.AttrOr("href", ""), "#")
+				if href == "" {
+					return
+				}
+				target := path.Join(path.Dir(item.Href), href)
+				landmarks[target] = append(landmarks[target], strings.Fields(strings.ToLower(a.AttrOr("epub:type", "")))...)
+			})
+		})
+	}
+	return landmarks, nil
+}
+
+// loadBookSemantics scans the spine for epub:type values when translate is
+// limited by them.
+func loadBookSemantics(book *bookFiles) error {
+	bookSemantics = nil
+	if len(includeTypes) == 0 && len(excludeTypes) == 0 {
+		return nil
+	}
+	semantics, err := spineSemantics(book)
+	if err != nil {
+		return err
+	}
+	bookSemantics = semantics
+	return nil
+}
+
+// semanticSkipReason returns why a document of the given epub:type values
+// should not be translated, or "" to translate it.
+func semanticSkipReason(types []string) string {
+	for _, t := range excludeTypes {
+		if slices.Contains(types, strings.ToLower(t)) {
+			return fmt.Sprintf("has excluded epub:type %q", t)
+		}
+	}
+	if len(includeTypes) == 0 {
+		return ""
+	}
+	for _, t := range includeTypes {
+		if slices.Contains(types, strings.ToLower(t)) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("has none of the epub:types %s", strings.Join(includeTypes, ", "))
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSpineSemantics(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Types")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Types</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="copy" href="Text/copyright.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="Text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="index" href="Text/index.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="nav"/><itemref idref="copy"/><itemref idref="ch1"/><itemref idref="ch2"/><itemref idref="index"/></spine>
+</package>`,
+		"nav.xhtml": `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="Text/ch1.xhtml">One</a></li></ol></nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="bodymatter" href="Text/ch1.xhtml#start">Start</a></li></ol></nav>
+</body></html>`,
+		"Text/copyright.xhtml": `<html><body epub:type="frontmatter"><section epub:type="copyright-page"><p>All rights reserved.</p></section></body></html>`,
+		"Text/ch1.xhtml":       `<html><body><section epub:type="chapter"><p>One</p><section epub:type="subchapter"><p>Two</p></section></section></body></html>`,
+		"Text/ch2.xhtml":       `<html><body><div epub:type="chapter"><p>Three</p></div></body></html>`,
+		"Text/index.xhtml":     `<html><body epub:type="backmatter"><section epub:type="index"><p>Index</p></section></body></html>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "OEBPS", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	semantics, err := spineSemantics(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"nav.xhtml":            {"toc", "landmarks"},
+		"Text/copyright.xhtml": {"frontmatter", "copyright-page"},
+		"Text/ch1.xhtml":       {"chapter", "bodymatter"},
+		"Text/ch2.xhtml":       {"chapter", "bodymatter"},
+		"Text/index.xhtml":     {"backmatter", "index"},
+	}
+	for href, types := range want {
+		if got := semantics[filepath.Join(book.contentDir, filepath.FromSlash(href))]; !slices.Equal(got, types) {
+			t.Errorf("types of %s = %v, want %v", href, got, types)
+		}
+	}
+}
+
+func TestSemanticSkipReason(t *testing.T) {
+	defer func() { includeTypes, excludeTypes = nil, nil }()
+
+	tests := []struct {
+		include, exclude []string
+		types            []string
+		skip             bool
+	}{
+		{nil, nil, []string{"toc"}, false},
+		{nil, []string{"toc", "index"}, []string{"toc"}, true},
+		{nil, []string{"TOC"}, []string{"toc"}, true},
+		{nil, []string{"index"}, []string{"chapter", "bodymatter"}, false},
+		{[]string{"bodymatter"}, nil, []string{"chapter", "bodymatter"}, false},
+		{[]string{"bodymatter"}, nil, []string{"frontmatter", "copyright-page"}, true},
+		{[]string{"bodymatter"}, []string{"chapter"}, []string{"chapter", "bodymatter"}, true},
+	}
+	for _, tt := range tests {
+		includeTypes, excludeTypes = tt.include, tt.exclude
+		if got := semanticSkipReason(tt.types) != ""; got != tt.skip {
+			t.Errorf("include %v, exclude %v: types %v skipped = %v, want %v", tt.include, tt.exclude, tt.types, got, tt.skip)
+		}
+	}
+}
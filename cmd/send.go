@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var Send = &cobra.Command{
+	Use:   "send [epubFile]",
+	Short: "Send a packed EPUB to a Kindle address or an e-reader",
+	Long: `This command delivers a packed EPUB to your reading device. By default the book is emailed as an
+attachment (for example to a Send-to-Kindle address) through an SMTP server. Use --device to copy the
+book into a mounted e-reader folder instead, e.g. a USB-connected Kindle or the KOReader home directory.
+
+The SMTP server and named targets are configured in send.yaml in the epubtrans folder of the user
+configuration directory, e.g. ~/.config/epubtrans/send.yaml, or the file given with --config:
+
+  smtp:
+    host: smtp.example.com
+    port: 587
+    username: me@example.com
+    password: secret
+    from: me@example.com
+  default: kindle
+  targets:
+    kindle:
+      to: me@kindle.com
+    koreader:
+      device: /media/KOBOeReader/books
+
+The SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM environment variables win over
+the file. --to and --device win over the target.`,
+	Example: `epubtrans send book-bilangual.epub --to me@kindle.com
+epubtrans send book-bilangual.epub --device /media/Kindle/documents
+epubtrans send book-bilangual.epub --target koreader`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("epubFile is required. Please provide the path to the packed EPUB file.")
+		}
+
+		fi, err := os.Stat(args[0])
+		if err != nil {
+			return fmt.Errorf("epub file %s: %w", args[0], err)
+		}
+		if fi.IsDir() || !isEpubFile(args[0]) {
+			return fmt.Errorf("%s is not an EPUB file", args[0])
+		}
+
+		return nil
+	},
+	RunE: runSend,
+}
+
+func init() {
+	Send.Flags().String("to", os.Getenv("SEND_TO_EMAIL"), "recipient email address, e.g. your Send-to-Kindle address")
+	Send.Flags().String("device", "", "copy the book into this mounted device directory instead of emailing it")
+	Send.Flags().String("target", "", "target of the configuration file to send the book to; defaults to its default target")
+	Send.Flags().String("config", "", "send configuration file; defaults to send.yaml in the epubtrans user configuration directory")
+}
+
+type smtpConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// sendConfig is the configuration file of send: the SMTP server and the
+// targets books are sent to, by name.
+type sendConfig struct {
+	SMTP    smtpConfig            `yaml:"smtp"`
+	Default string                `yaml:"default"`
+	Targets map[string]sendTarget `yaml:"targets"`
+}
+
+// sendTarget is an email address or a device directory to send books to.
+type sendTarget struct {
+	To     string `yaml:"to"`
+	Device string `yaml:"device"`
+}
+
+// sendConfigPath returns the default configuration file of send, or "" if
+// the system has no user configuration directory.
+func sendConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "epubtrans", "send.yaml")
+}
+
+// loadSendConfig reads the configuration file at configPath, or the default
+// one if it is empty. A missing default file is an empty configuration.
+func loadSendConfig(configPath string) (*sendConfig, error) {
+	explicit := configPath != ""
+	if !explicit {
+		configPath = sendConfigPath()
+	}
+
+	cfg := &sendConfig{}
+	if configPath == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("reading send configuration: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing send configuration %s: %w", configPath, err)
+	}
+	for name, target := range cfg.Targets {
+		if (target.To == "") == (target.Device == "") {
+			return nil, fmt.Errorf("send configuration %s: target %s needs either to or device", configPath, name)
+		}
+	}
+	if cfg.Default != "" {
+		if _, ok := cfg.Targets[cfg.Default]; !ok {
+			return nil, fmt.Errorf("send configuration %s: unknown default target %q", configPath, cfg.Default)
+		}
+	}
+	return cfg, nil
+}
+
+// target returns the target with the name, or the default target if name
+// is empty and there is one.
+func (c *sendConfig) target(name string) (sendTarget, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return sendTarget{}, nil
+	}
+	target, ok := c.Targets[name]
+	if !ok {
+		return sendTarget{}, fmt.Errorf("unknown send target %q", name)
+	}
+	return target, nil
+}
+
+// smtp returns the SMTP settings of the file with those of the environment
+// on top.
+func (c *sendConfig) smtp() (*smtpConfig, error) {
+	cfg := c.SMTP
+	for env, value := range map[string]*string{
+		"SMTP_HOST":     &cfg.Host,
+		"SMTP_PORT":     &cfg.Port,
+		"SMTP_USERNAME": &cfg.Username,
+		"SMTP_PASSWORD": &cfg.Password,
+		"SMTP_FROM":     &cfg.From,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value = v
+		}
+	}
+
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("missing SMTP host: set smtp.host in the send configuration or SMTP_HOST")
+	}
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	if cfg.From == "" {
+		return nil, fmt.Errorf("missing sender: set smtp.from in the send configuration or SMTP_FROM")
+	}
+
+	return &cfg, nil
+}
+
+// loadSMTPConfig returns the SMTP settings of the default configuration
+// file and the environment.
+func loadSMTPConfig() (*smtpConfig, error) {
+	cfg, err := loadSendConfig("")
+	if err != nil {
+		return nil, err
+	}
+	return cfg.smtp()
+}
+
+func runSend(cmd *cobra.Command, args []string) error {
+	epubPath := args[0]
+	to, _ := cmd.Flags().GetString("to")
+	device, _ := cmd.Flags().GetString("device")
+	targetName, _ := cmd.Flags().GetString("target")
+	configPath, _ := cmd.Flags().GetString("config")
+
+	cfg, err := loadSendConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if to == "" && device == "" {
+		target, err := cfg.target(targetName)
+		if err != nil {
+			return err
+		}
+		to, device = target.To, target.Device
+	}
+
+	if device != "" {
+		dst := filepath.Join(device, filepath.Base(epubPath))
+		if err := copyFile(epubPath, dst); err != nil {
+			return fmt.Errorf("failed to copy book to device: %w", err)
+		}
+
+		cmd.Printf("Copied %s to %s\n", filepath.Base(epubPath), dst)
+		return nil
+	}
+
+	if to == "" {
+		return fmt.Errorf("either --to, --device or a target in the send configuration is required")
+	}
+
+	smtpCfg, err := cfg.smtp()
+	if err != nil {
+		return err
+	}
+
+	if err := sendBookByEmail(smtpCfg, to, epubPath); err != nil {
+		return fmt.Errorf("failed to send book: %w", err)
+	}
+
+	cmd.Printf("Sent %s to %s\n", filepath.Base(epubPath), to)
+	return nil
+}
+
+func sendBookByEmail(cfg *smtpConfig, to, epubPath string) error {
+	content, err := os.ReadFile(epubPath)
+	if err != nil {
+		return err
+	}
+
+	msg, err := buildBookMessage(cfg.From, to, filepath.Base(epubPath), content)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(cfg.Host, cfg.Port), auth, cfg.From, []string{to}, msg)
+}
+
+func buildBookMessage(from, to, filename string, content []byte) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {epubMediaType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+	// RFC 2045 limits encoded lines to 76 characters.
+	for len(encoded) > 76 {
+		if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return nil, err
+		}
+		encoded = encoded[76:]
+	}
+	if _, err := part.Write([]byte(encoded + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	title := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSendConfig(t *testing.T) {
+	for _, env := range []string{"SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("SMTP_PASSWORD", "from-env")
+
+	configPath := filepath.Join(t.TempDir(), "send.yaml")
+	config := `smtp:
+  host: smtp.example.com
+  port: 465
+  username: me@example.com
+  password: from-file
+default: kindle
+targets:
+  kindle:
+    to: me@kindle.com
+  koreader:
+    device: /media/KOBOeReader/books
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadSendConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, err := cfg.target(""); err != nil || target.To != "me@kindle.com" {
+		t.Errorf("default target = %+v, %v", target, err)
+	}
+	if target, err := cfg.target("koreader"); err != nil || target.Device != "/media/KOBOeReader/books" {
+		t.Errorf("koreader target = %+v, %v", target, err)
+	}
+	if _, err := cfg.target("kobo"); err == nil {
+		t.Error("unknown target accepted")
+	}
+
+	// The environment wins over the file.
+	smtp, err := cfg.smtp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := smtpConfig{Host: "smtp.example.com", Port: "465", Username: "me@example.com", Password: "from-env", From: "me@example.com"}
+	if *smtp != want {
+		t.Errorf("smtp() = %+v, want %+v", *smtp, want)
+	}
+
+	for name, config := range map[string]string{
+		"target without address": "targets:\n  kindle: {}\n",
+		"unknown default":        "default: kobo\ntargets:\n  kindle:\n    to: me@kindle.com\n",
+	} {
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSendConfig(configPath); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// Only a configuration file that was asked for must exist.
+	if _, err := loadSendConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing --config file accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/dutchsteven/epubtrans/pkg/glossary"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/spf13/cobra"
+)
+
+var Series = &cobra.Command{
+	Use:   "series [projectFile]",
+	Short: "Translate every book listed in a series project file",
+	Long: `This command runs the full pipeline (unpack, clean, mark, translate, pack) on every book of a series.
+The books share one glossary, one character sheet and one translation memory, so names and terms
+stay consistent across volumes. Paths in the project file are relative to the project file itself.
+
+Example project file:
+
+  {
+    "name": "The Long Saga",
+    "source": "English",
+    "target": "Vietnamese",
+    "provider": "openai",
+    "books": ["volume1.epub", "volume2.epub"],
+    "glossary": "glossary.txt",
+    "characters": "characters.txt",
+    "translation_memory": "memory.json",
+    "exclude_types": ["toc", "copyright-page", "index"],
+    "max_segment_chars": 600
+  }
+
+provider and model select the translation provider and its model, as the --provider and --model
+flags of translate do; the provider defaults to EPUBTRANS_PROVIDER, and the model to the default
+model of the provider. include_types and exclude_types limit the translated documents by epub:type, as the --include-type
+and --exclude-type flags of translate do. min_segment_chars and max_segment_chars limit the size of
+the segments, as the --min-chars and --max-chars flags of mark do.`,
+	Example: `epubtrans series path/to/series.json`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("projectFile is required. Please provide the path to the series project file.")
+		}
+
+		return nil
+	},
+	RunE: runSeries,
+}
+
+func init() {
+	Series.Flags().Int("workers", runtime.NumCPU(), "Number of worker goroutines for clean and mark")
+}
+
+// seriesProject describes a group of books translated with shared resources.
+type seriesProject struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Provider and Model select the translation provider and its model;
+	// empty, they are EPUBTRANS_PROVIDER and the provider's default model.
+	Provider          string   `json:"provider"`
+	Model             string   `json:"model"`
+	Books             []string `json:"books"`
+	Glossary          string   `json:"glossary"`
+	Characters        string   `json:"characters"`
+	TranslationMemory string   `json:"translation_memory"`
+	// IncludeTypes and ExcludeTypes are the epub:type values of the
+	// documents to translate and not to translate.
+	IncludeTypes []string `json:"include_types"`
+	ExcludeTypes []string `json:"exclude_types"`
+	// MinSegmentChars and MaxSegmentChars limit the size of the segments
+	// mark makes.
+	MinSegmentChars int `json:"min_segment_chars"`
+	MaxSegmentChars int `json:"max_segment_chars"`
+}
+
+func loadSeriesProject(projectPath string) (*seriesProject, error) {
+	data, err := os.ReadFile(projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading project file: %w", err)
+	}
+
+	var project seriesProject
+	if err := json.Unmarshal(data, &project); err != nil {
+		return nil, fmt.Errorf("parsing project file: %w", err)
+	}
+
+	if len(project.Books) == 0 {
+		return nil, fmt.Errorf("project file %s lists no books", projectPath)
+	}
+
+	if project.Source == "" {
+		project.Source = "English"
+	}
+	if project.Target == "" {
+		project.Target = "Vietnamese"
+	}
+	if project.Provider != "" && !slices.Contains(translator.Providers(), project.Provider) {
+		return nil, fmt.Errorf("project file %s: unknown provider %q: use one of %v", projectPath, project.Provider, translator.Providers())
+	}
+
+	// Resolve every path against the project directory.
+	baseDir := filepath.Dir(projectPath)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(baseDir, p)
+	}
+
+	for i := range project.Books {
+		project.Books[i] = resolve(project.Books[i])
+	}
+	project.Glossary = resolve(project.Glossary)
+	project.Characters = resolve(project.Characters)
+	project.TranslationMemory = resolve(project.TranslationMemory)
+
+	return &project, nil
+}
+
+// structuredGlossary reports whether the glossary is a YAML or CSV file of
+// package glossary rather than free text.
+func (p *seriesProject) structuredGlossary() bool {
+	switch strings.ToLower(filepath.Ext(p.Glossary)) {
+	case ".yaml", ".yml", ".csv":
+		return true
+	}
+	return false
+}
+
+// instructions builds the shared prompt from the character sheet and a free
+// text glossary.
+func (p *seriesProject) instructions() (string, error) {
+	var sb strings.Builder
+
+	sections := []struct {
+		title string
+		path  string
+	}{
+		{"Glossary (always use these translations)", p.Glossary},
+		{"Characters (keep names and forms of address consistent)", p.Characters},
+	}
+	if p.structuredGlossary() {
+		sections = sections[1:]
+	}
+
+	for _, section := range sections {
+		if section.path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(section.path)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", section.path, err)
+		}
+
+		fmt.Fprintf(&sb, "%s:\n%s\n\n", section.title, strings.TrimSpace(string(content)))
+	}
+
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func runSeries(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	// Set up signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Println("Interrupt received, initiating graceful shutdown...")
+		cancel()
+	}()
+
+	workers, _ := cmd.Flags().GetInt("workers")
+	if workers <= 0 {
+		return fmt.Errorf("workers must be greater than 0")
+	}
+
+	project, err := loadSeriesProject(args[0])
+	if err != nil {
+		return err
+	}
+
+	if project.Provider != "" {
+		translationProvider = project.Provider
+	}
+	sourceLanguage = project.Source
+	targetLanguage = project.Target
+	includeTypes, excludeTypes = project.IncludeTypes, project.ExcludeTypes
+	minSegmentChars, maxSegmentChars = project.MinSegmentChars, project.MaxSegmentChars
+
+	translationInstructions, err = project.instructions()
+	if err != nil {
+		return err
+	}
+
+	if project.structuredGlossary() {
+		sharedGlossary, err = glossary.Load(project.Glossary)
+		if err != nil {
+			return err
+		}
+	}
+
+	if project.TranslationMemory != "" {
+		translationMemory, err = tm.Load(project.TranslationMemory)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Loaded %d translation memory entries\n", translationMemory.Len())
+	}
+
+	for i, book := range project.Books {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		fmt.Printf("\n[%d/%d] Running pipeline for %s\n", i+1, len(project.Books), filepath.Base(book))
+		pipelineErr := runPipeline(ctx, book, project.Model, workers)
+
+		// Persist what was learned even if the book failed half way.
+		if translationMemory != nil {
+			if err := tm.Save(project.TranslationMemory, translationMemory); err != nil {
+				return err
+			}
+		}
+
+		if pipelineErr != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(book), pipelineErr)
+		}
+	}
+
+	fmt.Printf("\nSeries %q completed: %d books\n", project.Name, len(project.Books))
+	return nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSeriesProject(t *testing.T) {
+	dir := t.TempDir()
+	load := func(project string) (*seriesProject, error) {
+		path := filepath.Join(dir, "series.json")
+		if err := os.WriteFile(path, []byte(project), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return loadSeriesProject(path)
+	}
+
+	// The model is left to the provider, whichever it is.
+	project, err := load(`{"provider": "openai", "books": ["volume1.epub"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Provider != "openai" || project.Model != "" {
+		t.Errorf("provider %q and model %q, want openai and its default model", project.Provider, project.Model)
+	}
+	if want := filepath.Join(dir, "volume1.epub"); project.Books[0] != want {
+		t.Errorf("book %q, want %q", project.Books[0], want)
+	}
+
+	if _, err := load(`{"provider": "babelfish", "books": ["volume1.epub"]}`); err == nil || !strings.Contains(err.Error(), `unknown provider "babelfish"`) {
+		t.Errorf("unknown provider: err = %v", err)
+	}
+}
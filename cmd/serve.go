@@ -1,46 +1,48 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"html"
 	"log/slog"
-	"net"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-
-	"embed"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gofiber/fiber/v2"
-	"github.com/liushuangls/go-anthropic/v2"
 	"github.com/dutchsteven/epubtrans/pkg/loader"
 	"github.com/dutchsteven/epubtrans/pkg/translator"
 	"github.com/dutchsteven/epubtrans/pkg/util"
 	"github.com/spf13/cobra"
 )
 
-//go:embed assets/app.js assets/app.css
-var embeddedAssets embed.FS
-
 var Serve = &cobra.Command{
-	Use:   "serve [unpackedEpubPath]",
+	Use:   "serve [unpackedEpubPath...]",
 	Short: "Serve the content of an unpacked EPUB as a web server",
-	Long:  `This command starts a web server that serves the content of an unpacked EPUB file. You can access the EPUB content through your web browser. Make sure to provide the path to the unpacked EPUB directory.`,
+	Long:  `This command starts a web server that serves the content of an unpacked EPUB file. You can access the EPUB content through your web browser. Make sure to provide the path to the unpacked EPUB directory. Given several directories, or a folder of unpacked EPUBs with --library, each book is served under /books/<id>/ and / lists them.`,
 	Example: `epubtrans serve path/to/unpacked/epub
-		# This will start the server and serve the EPUB content at http://localhost:3000`,
+		# This will start the server and serve the EPUB content at http://localhost:3000
+	epubtrans serve --library path/to/books`,
 	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) != 1 {
+		if library, _ := cmd.Flags().GetString("library"); len(args) == 0 && library == "" {
 			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
 		}
 
-		return util.ValidateEpubPath(args[0])
+		for _, arg := range args {
+			if err := util.ValidateEpubPath(arg); err != nil {
+				return err
+			}
+		}
+		return nil
 	},
 	RunE: runServe,
 }
@@ -48,6 +50,14 @@ var Serve = &cobra.Command{
 func init() {
 	// port flag
 	Serve.Flags().StringP("port", "p", "3000", "port to serve the EPUB content")
+	Serve.Flags().String("library", "", "serve every unpacked EPUB in this folder, each under /books/<id>/")
+	Serve.Flags().Bool("share-only", false, "serve only the read-only /share pages, e.g. to publish them for beta readers")
+	Serve.Flags().BoolVar(&trustHTML, "trust-html", false, "write edited translations without removing markup outside the allow-list; only for trusted single-user setups")
+	Serve.Flags().StringVar(&translationProvider, "provider", providerFromEnv(), "translation provider for AI translations: "+strings.Join(translator.Providers(), ", "))
+	Serve.Flags().StringVar(&serveModel, "model", "", "model for AI translations; defaults to the provider's default model")
+	addSamplingFlags(Serve)
+	Serve.Flags().StringVar(&mockFixture, "fixture", "", "with --provider mock, replay the translations recorded in this file with translate --record")
+	Serve.Flags().StringSliceVar(&allowedOrigins, "allowed-origin", nil, "additional origin allowed to call the editing endpoints, e.g. https://book.example.com behind a reverse proxy")
 }
 
 var ToInjectContentTypes = []string{
@@ -98,157 +108,359 @@ type NavMap struct {
 	NavPoints []NavPoint `xml:"navPoint"`
 }
 
-func generateTOCHTML(navPoints []NavPoint, level int) string {
+// generateTOCHTML lists the navigation points as links to the chapters of
+// the book served under base; their src is relative to the table of contents
+// file tocHref.
+func generateTOCHTML(navPoints []NavPoint, tocHref, base string, level int) string {
 	if len(navPoints) == 0 {
 		return ""
 	}
 
-	var html strings.Builder
-	html.WriteString("<ul>")
+	var sb strings.Builder
+	sb.WriteString("<ul>")
 
 	for _, np := range navPoints {
-		html.WriteString(fmt.Sprintf("<li><a target=\"_blank\" href=\"%s\">%s</a>", np.Content.Src, np.NavLabel.Text))
+		if np.Content.Src == "" {
+			sb.WriteString(fmt.Sprintf("<li><span>%s</span>", html.EscapeString(np.NavLabel.Text)))
+		} else {
+			link := tocLink(tocHref, np.Content.Src)
+			if strings.HasPrefix(link, "/") {
+				link = base + link
+			}
+			sb.WriteString(fmt.Sprintf("<li><a target=\"_blank\" href=\"%s\">%s</a>", html.EscapeString(link), html.EscapeString(np.NavLabel.Text)))
+		}
 		if len(np.NavPoints) > 0 {
-			html.WriteString(generateTOCHTML(np.NavPoints, level+1))
+			sb.WriteString(generateTOCHTML(np.NavPoints, tocHref, base, level+1))
 		}
-		html.WriteString("</li>")
+		sb.WriteString("</li>")
 	}
 
-	html.WriteString("</ul>")
-	return html.String()
+	sb.WriteString("</ul>")
+	return sb.String()
 }
 
-const (
-	githubRawContent = "https://raw.githubusercontent.com"
-	userRepo         = "dutchsteven/epubtrans"
-	branch           = "main"
-)
-
 type TranslateAIRequest struct {
     FilePath      string `json:"file_path"`
     TranslationID string `json:"translation_id"`
     ContentID     string `json:"content_id"`
     Instructions  string `json:"instructions"`
+    // Holder is the lock holder of the page asking, whose own edit lock on
+    // the segment does not keep it from translating.
+    Holder        string `json:"holder"`
 }
 
-// Add this function to call the AI translation service (you'll need to implement this)
-func translateWithAI(content string, instructions string, bookTitle string) (string, error) {
-    ctx := context.Background()
+var (
+	// serveModel is the model of the --provider used for AI translations.
+	serveModel string
 
-    // Create an Anthropic translator
-    anthropicTranslator, err := translator.GetAnthropicTranslator(&translator.Config{
-        APIKey:      os.Getenv("ANTHROPIC_KEY"),
-        Model:       string(anthropic.ModelClaude3Dot5SonnetLatest), // You might want to make this configurable
-        Temperature: 0.7,
-        MaxTokens:   8192,
-    })
-    if err != nil {
-        return "", fmt.Errorf("error getting translator: %v", err)
-    }
+	serveTranslator     translator.Provider
+	serveTranslatorErr  error
+	serveTranslatorOnce sync.Once
+)
+
+// getServeTranslator creates the translator on the first AI translation, so
+// serve runs without an API key until one is requested.
+func getServeTranslator() (translator.Provider, error) {
+	serveTranslatorOnce.Do(func() {
+		serveTranslator, serveTranslatorErr = translator.New(translationProvider, &translator.Config{
+			Model:       serveModel,
+			Temperature: translationSampling.Temperature,
+			TopP:        translationSampling.TopP,
+			Seed:        translationSampling.Seed,
+			MaxTokens:   8192,
+			Fixture:     mockFixture,
+		})
+	})
+	return serveTranslator, serveTranslatorErr
+}
+
+// translateWithAI translates one segment with the --provider translator. With
+// onDelta, the translation is streamed to it as the model produces it.
+func translateWithAI(ctx context.Context, content string, instructions string, bookTitle string, onDelta func(delta string)) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, serveLimits.aiTimeout)
+	defer cancel()
+
+	provider, err := getServeTranslator()
+	if err != nil {
+		return "", fmt.Errorf("error getting translator: %v", err)
+	}
+	ctx = serveSpend.observe(ctx, provider)
+
+	translatedContent, err := provider.TranslateStream(ctx, instructions, content, "english", "vietnamese", bookTitle, onDelta)
+	if err != nil {
+		return "", fmt.Errorf("translation error: %w", err)
+	}
 
-    // Translate the content
-    translatedContent, err := anthropicTranslator.Translate(ctx, instructions, content, "english", "vietnamese", bookTitle)
-    if err != nil {
-        return "", fmt.Errorf("translation error: %v", err)
-    }
+	// Requests from the browser are few, so write the usage right away.
+	if err := provider.FlushMetadata(); err != nil {
+		fmt.Printf("Error writing translator metadata: %v\n", err)
+	}
 
-    return translatedContent, nil
+	return translatedContent, nil
 }
 
-func runServe(cmd *cobra.Command, args []string) error {
-	unpackedEpubPath := args[0]
+// writeEvent writes a server-sent event with a JSON payload.
+func writeEvent(w *bufio.Writer, event string, data any) {
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
 
-	// Check if the directory exists
-	if _, err := os.Stat(unpackedEpubPath); os.IsNotExist(err) {
-		return fmt.Errorf("the specified directory does not exist: %s", unpackedEpubPath)
+// aiTranslationInput returns the original of the segment of an AI translation
+// request and the instructions for the model, which include the current
+// translation. The titles, authors, DOIs and links of a bibliography entry
+// are masked, and returned to be put back. Failures are *fiber.Error.
+func aiTranslationInput(c *fiber.Ctx, contentDirPath string, citations *citationStore, locks *segmentLocks) (original string, instructions string, masked []string, err error) {
+	var req TranslateAIRequest
+	if err := c.BodyParser(&req); err != nil {
+		return "", "", nil, fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+	}
+	if lock, ok := locks.held(editHref(req.FilePath), req.ContentID); ok && lock.Holder != req.Holder {
+		return "", "", nil, fiber.NewError(fiber.StatusLocked, lockedMessage(lock))
 	}
 
-	// Parse the package to get book information
-	container, err := loader.ParseContainer(unpackedEpubPath)
+	content, err := os.ReadFile(path.Join(contentDirPath, req.FilePath))
 	if err != nil {
-		return err
+		return "", "", nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to read file")
 	}
 
-	opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
-	pkg, err := loader.ParsePackage(opfPath)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(content)))
 	if err != nil {
-		return fmt.Errorf("error parsing package: %v", err)
+		return "", "", nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to parse HTML")
 	}
 
-	// Get the book title
-	bookTitle := pkg.Metadata.Title
+	var segment *goquery.Selection
+	doc.Find("[data-content-id]").Each(func(i int, s *goquery.Selection) {
+		if id, exists := s.Attr("data-content-id"); exists && id == req.ContentID {
+			original, _ = withoutPageBreaks(s)
+			segment = s
+		}
+	})
+	if original == "" {
+		return "", "", nil, fiber.NewError(fiber.StatusNotFound, "Translation ID not found")
+	}
 
-	slog.Info("Book title: " + bookTitle)
+	mode, err := citations.mode(strings.TrimPrefix(path.Clean("/"+req.FilePath), "/"))
+	if err != nil {
+		return "", "", nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to read citation modes")
+	}
+	instructions = req.Instructions
+	if isCitation(segment, mode) {
+		original, masked = maskCitation(original)
+		instructions = strings.TrimSpace(citationInstructions + "\n\n" + instructions)
+	}
 
-	app := fiber.New(fiber.Config{
-		DisableStartupMessage: true,
+	// get the current translated content
+	var currentTranslatedContent string
+	doc.Find("[data-translation-id]").Each(func(i int, s *goquery.Selection) {
+		if id, exists := s.Attr("data-translation-id"); exists && id == req.TranslationID {
+			currentTranslatedContent, _ = s.Html()
+		}
 	})
 
-	var scriptToInject = []byte(`<script src="/assets/app.js"></script><link rel="stylesheet" href="/assets/app.css">`)
+	return original, withPreviousTranslation(currentTranslatedContent, instructions), masked, nil
+}
 
-	// Proxy route for assets
-	app.Get("/assets/:filename", func(c *fiber.Ctx) error {
+// withPreviousTranslation adds the current translation of a segment, if any,
+// to the instructions for the model.
+func withPreviousTranslation(previous, instructions string) string {
+	if len(previous) == 0 {
+		return instructions
+	}
+	return fmt.Sprintf("Previous translation:\n\n%s\n\n%s", previous, instructions)
+}
 
-		filename := c.Params("filename")
-		if filename == "app.js" || filename == "app.css" {
-			content, err := embeddedAssets.ReadFile("assets/" + filename)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).SendString("Error fetching file")
-			}
-			return c.Send(content)
-		}
+// aiTranslationError answers a failed aiTranslationInput.
+func aiTranslationError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
 
-		url := fmt.Sprintf("%s/%s/%s/assets/%s", githubRawContent, userRepo, branch, filename)
+func runServe(cmd *cobra.Command, args []string) error {
+	if err := checkServeTLS(); err != nil {
+		return err
+	}
 
-		// Make request to GitHub
-		resp, err := http.Get(url)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Error fetching file")
+	var err error
+	if translationSampling, err = samplingFromFlags(cmd); err != nil {
+		return err
+	}
+	if serveBasePath, err = cleanBasePath(serveBasePath); err != nil {
+		return err
+	}
+
+	library, _ := cmd.Flags().GetString("library")
+	books, err := serveBooks(args, library)
+	if err != nil {
+		return err
+	}
+
+	// The self-signed certificate and the spend of the users are kept next to
+	// the book or the library.
+	certPrefix := books[0].Path
+	if library != "" {
+		certPrefix = library
+	}
+	if err := loadServeSpend(certPrefix); err != nil {
+		return err
+	}
+	multiple := books[0].Base != ""
+	for i := range books {
+		books[i].Base = serveBasePath + books[i].Base
+	}
+
+	app := fiber.New(serveConfig())
+	aiSlots = make(chan struct{}, max(serveLimits.maxAIRequests, 1))
+
+	port := cmd.Flag("port").Value.String()
+	shareOnly, _ := cmd.Flags().GetBool("share-only")
+	if !shareOnly && probeEnabled {
+		if err := probeProvider(cmd.Context(), serveModel); err != nil {
+			slog.Warn("AI translation will not work", "error", err)
 		}
-		defer resp.Body.Close()
+	}
 
-		// Set content type based on file extension
-		if strings.HasSuffix(filename, ".css") {
-			c.Set("Content-Type", "text/css")
-		} else if strings.HasSuffix(filename, ".js") {
-			c.Set("Content-Type", "application/javascript")
+	if !shareOnly {
+		csrfToken, err := newCSRFToken()
+		if err != nil {
+			return fmt.Errorf("generating CSRF token: %w", err)
 		}
+		app.Use(csrfProtection(csrfToken, allowedOrigins))
 
-		//send the body to the client
-		body, _ := io.ReadAll(resp.Body)
-		return c.Send(body)
-	})
+		registerAssets(app)
 
-	contentDirPath := path.Dir(path.Join(unpackedEpubPath, container.Rootfile.FullPath))
+		if multiple {
+			registerLibraryPage(app, books)
+		}
+	}
 
-	app.Get("/toc.html", func(c *fiber.Ctx) error {
-		opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
-		pkg, err := loader.ParsePackage(opfPath)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error parsing package: %v", err))
+	for _, book := range books {
+		slog.Info("Book title: " + book.Title)
+		router := app.Group(book.Base)
+		router.Use(withBookBase(book.Base))
+		if err := serveBook(app, router, book, shareOnly); err != nil {
+			return err
 		}
+	}
 
-		tocItem := pkg.Manifest.GetItemByID(pkg.Spine.Toc)
+	addr, origin := serveAddr(serveHost, port), serveOrigin(serveHost, port)
+	if origin == "" {
+		slog.Info("Listening on " + addr)
+	}
+
+	if shareOnly {
+		slog.Info("Serving share links only on port " + port)
+		return serveUntilSignal(app, addr, certPrefix, books)
+	}
 
-		if tocItem == nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Error getting toc item")
+	if multiple {
+		slog.Info("- " + origin + serveBasePath + "/")
+		for _, book := range books {
+			slog.Info("- " + origin + book.Base + "/toc.html")
 		}
+		return serveUntilSignal(app, addr, certPrefix, books)
+	}
+
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/info")
+	slog.Info("- " + origin + serveBasePath + "/toc.html")
+	slog.Info("- " + origin + serveBasePath + "/review")
+	slog.Info("- " + origin + serveBasePath + "/progress")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/manifest")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/spine")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/badge.svg")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/search?q=")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/jobs")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/provenance")
+	slog.Info("- " + origin + serveBasePath + apiV1 + "/openapi.json")
+
+	return serveUntilSignal(app, addr, certPrefix, books)
+}
 
-		tocPath := path.Join(contentDirPath, tocItem.Href)
-		// Read the toc.ncx file
-		tocContent, err := ioutil.ReadFile(tocPath)
+// serveBook adds the pages and the API of a book to router, which serves
+// under the base of the book. With shareOnly, only its share links are
+// served.
+func serveBook(app *fiber.App, router fiber.Router, book servedBook, shareOnly bool) error {
+	unpackedEpubPath := book.Path
+
+	// Parse the package to get book information
+	container, err := loader.ParseContainer(unpackedEpubPath)
+	if err != nil {
+		return err
+	}
+
+	opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
+	bookTitle := book.Title
+
+	shares, err := loadShareStore(shareStorePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+
+	if shareOnly {
+		registerSharePages(router, shares, opfPath)
+		return nil
+	}
+
+	router.Use("/api", legacyAPI())
+
+	api := router.Group(apiV1)
+	registerShareAPI(api, shares, opfPath)
+	registerSharePages(router, shares, opfPath)
+	registerOpenAPI(app, api)
+
+	// The base tells app.js where the API of the book is.
+	var scriptToInject = []byte(fmt.Sprintf(`<meta name="epubtrans-base" content="%s"><script src="%s"></script><link rel="stylesheet" href="%s">`,
+		html.EscapeString(book.Base), assetURL("editor.js"), assetURL("editor.css")))
+
+	contentDirPath := path.Dir(path.Join(unpackedEpubPath, container.Rootfile.FullPath))
+	citations, err := loadCitationStore(citationStorePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+	registerCitationAPI(api, citations, contentDirPath)
+	reviews, err := loadReviewStore(reviewStorePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+	registerReviewAPI(api, reviews, unpackedEpubPath)
+	registerReviewPages(router, reviews, unpackedEpubPath)
+	registerStatusAPI(api, unpackedEpubPath, reviews)
+	registerProgressAPI(api, unpackedEpubPath)
+	registerProgressPage(router, unpackedEpubPath)
+	search, err := newSearchIndex(unpackedEpubPath)
+	if err != nil {
+		return fmt.Errorf("error indexing book: %w", err)
+	}
+	registerSearchAPI(api, search)
+	registerLiveReload(api, newLiveReload(unpackedEpubPath, contentDirPath), allowedOrigins)
+	locks := newSegmentLocks()
+	registerLockAPI(api, locks)
+	registerBatchAPI(api, newAIBatchQueue(unpackedEpubPath, contentDirPath, bookTitle, citations, locks))
+	registerExportAPI(api, unpackedEpubPath)
+	edits := newEditLog(unpackedEpubPath)
+	registerEditAPI(api, edits, locks, contentDirPath)
+	registerEditPages(router, edits)
+	registerSyncAPI(api, unpackedEpubPath, reviews, edits, locks)
+	registerSegmentAPI(api, unpackedEpubPath, reviews, edits, locks)
+	registerSpendAPI(api)
+	registerSpeechAPI(api, unpackedEpubPath, contentDirPath)
+
+	router.Get("/toc.html", func(c *fiber.Ctx) error {
+		opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
+		pkg, err := loader.ParsePackage(opfPath)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Error reading toc.ncx file: " + tocPath)
+			return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Error parsing package: %v", err))
 		}
 
-		var ncx NCX
-		err = xml.Unmarshal(tocContent, &ncx)
+		navPoints, tocHref, err := bookNavPoints(contentDirPath, pkg, nil)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Error parsing toc.ncx file")
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
 		// Generate HTML TOC
-		tocHTML := generateTOCHTML(ncx.NavMap.NavPoints, 0)
+		tocHTML := generateTOCHTML(navPoints, tocHref, bookBase(c), 0)
 
 		// Wrap the TOC in a basic HTML structure
 		fullHTML := fmt.Sprintf(`
@@ -258,6 +470,8 @@ func runServe(cmd *cobra.Command, args []string) error {
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>Table of Contents</title>
+    <link rel="stylesheet" href="%s">
+    <script src="%s"></script>
     <style>
         body { font-family: Arial, sans-serif; line-height: 1.6; }
         ul { padding-left: 20px; }
@@ -266,16 +480,17 @@ func runServe(cmd *cobra.Command, args []string) error {
 <body>
     <h1>Table of Contents</h1>
     %s
+    <script>addThemeToggle(document.body);</script>
 </body>
 </html>
-`, tocHTML)
+`, assetURL("theme.css"), assetURL("theme.js"), tocHTML)
 
 		c.Set("Content-Type", "text/html")
 
 		return c.SendString(fullHTML)
 	})
 
-	app.Static("/", contentDirPath, fiber.Static{
+	router.Static("/", contentDirPath, fiber.Static{
 		Browse: true,
 		ModifyResponse: func(c *fiber.Ctx) error {
 			contentType := c.Response().Header.Peek("Content-Type")
@@ -308,13 +523,34 @@ func runServe(cmd *cobra.Command, args []string) error {
 		},
 	})
 
-	app.Patch("/api/update-translation", func(c *fiber.Ctx) error {
+	api.Patch("/update-translation", func(c *fiber.Ctx) error {
 		var req TranslateRequest
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 		}
 
+		if err := checkWellFormed(req.TranslationContent); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid translation: " + err.Error()})
+		}
+
+		translationContent := req.TranslationContent
+		var removed []string
+		if !trustHTML {
+			var err error
+			translationContent, removed, err = sanitizeTranslation(req.TranslationContent)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid translation: " + err.Error()})
+			}
+			if len(removed) > 0 {
+				slog.Warn("removed markup from edited translation", "file", req.FilePath, "translation_id", req.TranslationID, "removed", removed)
+			}
+		}
+
 		filePath := path.Join(contentDirPath, req.FilePath)
+		fileLock := getFileLock(filePath)
+		fileLock.Lock()
+		defer fileLock.Unlock()
+
 		// Read the file
 		content, err := os.ReadFile(filePath)
 		if err != nil {
@@ -327,11 +563,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to parse HTML"})
 		}
 
+		if rejectAILocked(c, locks, editHref(req.FilePath), doc, req.TranslationID) {
+			return nil
+		}
+
 		// Find the element and update its content
 		updated := false
+		edit := editEntry{Time: time.Now(), TranslationID: req.TranslationID}
 		doc.Find("[data-translation-id]").Each(func(i int, s *goquery.Selection) {
 			if id, exists := s.Attr("data-translation-id"); exists && id == req.TranslationID {
-				s.SetHtml(req.TranslationContent)
+				edit.Old, _ = s.Html()
+				edit.OldProvenance = provenanceOf(s)
+				s.SetHtml(translationContent)
+				manualProvenance.apply(s)
+				stampSource(s, doc.Find(fmt.Sprintf("[%s=%q]", util.TranslationByIdKey, id)))
+				edit.New, _ = s.Html()
 				updated = true
 			}
 		})
@@ -341,21 +587,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 		}
 
 		// Write the updated content back to the file
-		html, err := doc.Html()
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to generate HTML"})
-		}
-
-		err = os.WriteFile(filePath, []byte(html), 0644)
+		err = writeContentToFile(filePath, doc)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to write file"})
 		}
+		recordEdit(edits, editHref(req.FilePath), edit)
 
-		return c.JSON(fiber.Map{"message": "Translation updated successfully"})
+		return c.JSON(fiber.Map{
+			"message":             "Translation updated successfully",
+			"translation_content": translationContent,
+			"removed":             removed,
+		})
 	})
 
 	// API endpoint to get ebook information
-	app.Get("/api/info", func(c *fiber.Ctx) error {
+	api.Get("/info", func(c *fiber.Ctx) error {
 		opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
 		pkg, err := loader.ParsePackage(opfPath)
 		if err != nil {
@@ -366,7 +612,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 	})
 
 	// API endpoint to get manifest items
-	app.Get("/api/manifest", func(c *fiber.Ctx) error {
+	api.Get("/manifest", func(c *fiber.Ctx) error {
 		opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
 		pkg, err := loader.ParsePackage(opfPath)
 		if err != nil {
@@ -377,7 +623,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 	})
 
 	// API endpoint to get spine items
-	app.Get("/api/spine", func(c *fiber.Ctx) error {
+	api.Get("/spine", func(c *fiber.Ctx) error {
 		opfPath := filepath.Join(unpackedEpubPath, container.Rootfile.FullPath)
 		pkg, err := loader.ParsePackage(opfPath)
 		if err != nil {
@@ -387,62 +633,139 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return c.JSON(pkg.Spine)
 	})
 
-	app.Post("/api/ai-translate", func(c *fiber.Ctx) error {
-        var req TranslateAIRequest
-        if err := c.BodyParser(&req); err != nil {
-            return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
-        }
+	// Translation jobs and their structured logs
+	api.Get("/jobs", func(c *fiber.Ctx) error {
+		jobs, err := listJobs(unpackedEpubPath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to list jobs"})
+		}
+		return c.JSON(jobs)
+	})
 
-        filePath := path.Join(contentDirPath, req.FilePath)
-        content, err := os.ReadFile(filePath)
-        if err != nil {
-            return c.Status(500).JSON(fiber.Map{"error": "Failed to read file"})
-        }
+	api.Get("/jobs/:id/logs", func(c *fiber.Ctx) error {
+		entries, err := readJobLogs(unpackedEpubPath, c.Params("id"), c.Query("level"), c.Query("file"))
+		if os.IsNotExist(err) {
+			return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+		}
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(entries)
+	})
 
-        doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(content)))
-        if err != nil {
-            return c.Status(500).JSON(fiber.Map{"error": "Failed to parse HTML"})
-        }
+	// Translations of the book counted by provider, model and prompt version
+	api.Get("/provenance", func(c *fiber.Ctx) error {
+		summary, err := provenanceSummary(unpackedEpubPath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read translations"})
+		}
+		return c.JSON(summary)
+	})
 
-        var originalContent string
-        doc.Find("[data-content-id]").Each(func(i int, s *goquery.Selection) {
-            if id, exists := s.Attr("data-content-id"); exists && id == req.ContentID {
-                originalContent, _ = s.Html()
-            }
-        })
+	// Progress badge to embed in a README or a tracking page
+	api.Get("/badge.svg", func(c *fiber.Ctx) error {
+		translated, total, err := translationProgress(unpackedEpubPath)
+		if err != nil {
+			return c.Status(500).SendString(fmt.Sprintf("Error reading book: %v", err))
+		}
 
-        if originalContent == "" {
-            return c.Status(404).JSON(fiber.Map{"error": "Translation ID not found"})
-        }
+		percent := 0
+		if total > 0 {
+			percent = translated * 100 / total
+		}
 
-		// get the current translated content
-		var currentTranslatedContent string
-		doc.Find("[data-translation-id]").Each(func(i int, s *goquery.Selection) {
-			if id, exists := s.Attr("data-translation-id"); exists && id == req.TranslationID {
-				currentTranslatedContent, _ = s.Html()
-			}
-		})
+		c.Set(fiber.HeaderContentType, "image/svg+xml")
+		c.Set(fiber.HeaderCacheControl, "no-cache, max-age=0")
+		return c.SendString(renderBadge(c.Query("label", "translated"), fmt.Sprintf("%d%%", percent), progressColor(percent)))
+	})
 
-		instructment := req.Instructions
+	api.Post("/ai-translate", func(c *fiber.Ctx) error {
+		user := requestUser(c)
+		if err := serveSpend.check(user); err != nil {
+			return budgetError(c, err)
+		}
+		release, ok := acquireAISlot()
+		if !ok {
+			c.Set(fiber.HeaderRetryAfter, "10")
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many AI translations running, try again shortly"})
+		}
+		defer release()
 
-		if len(currentTranslatedContent) > 0 {
-			instructment = fmt.Sprintf("Previous translation:\n\n%s\n\n%s", currentTranslatedContent, instructment)
+		originalContent, instructions, masked, err := aiTranslationInput(c, contentDirPath, citations, locks)
+		if err != nil {
+			return aiTranslationError(c, err)
 		}
 
-		translatedContent, err := translateWithAI(originalContent, instructment, bookTitle)
+		translatedContent, err := translateWithAI(withSpendUser(c.UserContext(), user), originalContent, instructions, bookTitle, nil)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Translation timed out"})
+		}
         if err != nil {
             return c.Status(500).JSON(fiber.Map{"error": "Translation failed"})
         }
+		if translatedContent, err = unmaskCitation(translatedContent, masked); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Translation failed: a citation was lost"})
+		}
 
         return c.JSON(fiber.Map{"translated_content": translatedContent})
     })
 
-	port := cmd.Flag("port").Value.String()
+	// Like /ai-translate, answered with server-sent events: "delta" events with
+	// the pieces of the translation as the model produces them, then "done"
+	// with the whole translation or "error".
+	api.Post("/ai-translate/stream", func(c *fiber.Ctx) error {
+		user := requestUser(c)
+		if err := serveSpend.check(user); err != nil {
+			return budgetError(c, err)
+		}
+		release, ok := acquireAISlot()
+		if !ok {
+			c.Set(fiber.HeaderRetryAfter, "10")
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many AI translations running, try again shortly"})
+		}
+
+		originalContent, instructions, masked, err := aiTranslationInput(c, contentDirPath, citations, locks)
+		if err != nil {
+			release()
+			return aiTranslationError(c, err)
+		}
 
-	slog.Info("- http://localhost:" + port + "/api/info")
-	slog.Info("- http://localhost:" + port + "/toc.html")
-	slog.Info("- http://localhost:" + port + "/api/manifest")
-	slog.Info("- http://localhost:" + port + "/api/spine")
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		flush := streamFlusher(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer release()
+
+			// The stream is written after the handler returned, so the request
+			// context is gone; a failed flush tells that the browser left.
+			ctx, cancel := context.WithCancel(withSpendUser(context.Background(), user))
+			defer cancel()
+
+			translatedContent, err := translateWithAI(ctx, originalContent, instructions, bookTitle, func(delta string) {
+				writeEvent(w, "delta", fiber.Map{"text": delta})
+				if flush(w) != nil {
+					cancel()
+				}
+			})
+			if err == nil {
+				if translatedContent, err = unmaskCitation(translatedContent, masked); err != nil {
+					writeEvent(w, "error", fiber.Map{"error": "Translation failed: a citation was lost"})
+					flush(w)
+					return
+				}
+			}
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				writeEvent(w, "error", fiber.Map{"error": "Translation timed out"})
+			case err != nil:
+				writeEvent(w, "error", fiber.Map{"error": "Translation failed"})
+			default:
+				writeEvent(w, "done", fiber.Map{"translated_content": translatedContent})
+			}
+			flush(w)
+		})
+		return nil
+	})
 
-	return app.Listen(net.JoinHostPort("", port))
+	return nil
 }
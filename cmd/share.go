@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// shareLink gives read-only access to a single chapter.
+type shareLink struct {
+	Href    string    `json:"href"`
+	Created time.Time `json:"created"`
+}
+
+// shareStore keeps the share links of a book. It lives next to the unpacked
+// directory rather than inside it, so it never ends up in the packed EPUB.
+type shareStore struct {
+	mu    sync.Mutex
+	path  string
+	Links map[string]shareLink `json:"links"`
+}
+
+func shareStorePath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-shares.json"
+}
+
+func loadShareStore(storePath string) (*shareStore, error) {
+	store := &shareStore{path: storePath}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the links from disk, so links created or revoked by another
+// serve process (e.g. the editor next to a --share-only server) take effect.
+func (s *shareStore) reload() error {
+	links := make(map[string]shareLink)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading share links: %w", err)
+	}
+
+	if err == nil {
+		var stored shareStore
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("parsing share links: %w", err)
+		}
+		for token, link := range stored.Links {
+			links[token] = link
+		}
+	}
+
+	s.Links = links
+	return nil
+}
+
+func (s *shareStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling share links: %w", err)
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s *shareStore) create(href string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return "", err
+	}
+	s.Links[token] = shareLink{Href: href, Created: time.Now()}
+	return token, s.save()
+}
+
+func (s *shareStore) lookup(token string) (shareLink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return shareLink{}, false
+	}
+	link, ok := s.Links[token]
+	return link, ok
+}
+
+func (s *shareStore) revoke(token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	if _, ok := s.Links[token]; !ok {
+		return false, nil
+	}
+	delete(s.Links, token)
+	return true, s.save()
+}
+
+type ShareRequest struct {
+	FilePath string `json:"file_path"`
+}
+
+// registerShareAPI adds the endpoints to create, list and revoke share links.
+func registerShareAPI(api fiber.Router, store *shareStore, opfPath string) {
+	api.Post("/share", func(c *fiber.Ctx) error {
+		var req ShareRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		pkg, err := loader.ParsePackage(opfPath)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to parse package"})
+		}
+
+		href := strings.TrimPrefix(path.Clean("/"+req.FilePath), "/")
+		if item := findItemByHref(pkg, href); item == nil || item.MediaType != "application/xhtml+xml" {
+			return c.Status(404).JSON(fiber.Map{"error": "Chapter not found"})
+		}
+
+		token, err := store.create(href)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create share link"})
+		}
+
+		return c.JSON(fiber.Map{"token": token, "url": c.BaseURL() + bookBase(c) + "/share/" + token})
+	})
+
+	api.Get("/shares", func(c *fiber.Ctx) error {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		if err := store.reload(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read share links"})
+		}
+		return c.JSON(store.Links)
+	})
+
+	api.Delete("/share/:token", func(c *fiber.Ctx) error {
+		ok, err := store.revoke(c.Params("token"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke share link"})
+		}
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Share link not found"})
+		}
+		return c.JSON(fiber.Map{"message": "Share link revoked"})
+	})
+}
+
+// registerSharePages adds the read-only /share/:token pages. A shared page only
+// exposes its chapter and the styles, images, fonts and media of the manifest.
+func registerSharePages(app fiber.Router, store *shareStore, opfPath string) {
+	contentDirPath := filepath.Dir(opfPath)
+
+	app.Get("/share/:token", func(c *fiber.Ctx) error {
+		link, ok := store.lookup(c.Params("token"))
+		if !ok {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		// Serve the chapter under the token so its relative links resolve there too.
+		return c.Redirect(bookBase(c) + "/share/" + c.Params("token") + "/" + link.Href)
+	})
+
+	app.Get("/share/:token/*", func(c *fiber.Ctx) error {
+		link, ok := store.lookup(c.Params("token"))
+		if !ok {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+
+		pkg, err := loader.ParsePackage(opfPath)
+		if err != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		href := strings.TrimPrefix(path.Clean("/"+c.Params("*")), "/")
+		if href == link.Href {
+			content, err := sharedChapter(filepath.Join(contentDirPath, href))
+			if err != nil {
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+			return c.SendString(content)
+		}
+
+		item := findItemByHref(pkg, href)
+		if item == nil || !isSharedResource(item.MediaType) {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+
+		return c.SendFile(filepath.Join(contentDirPath, href))
+	})
+}
+
+// sharedChapter renders a chapter for beta readers: originals that have a
+// translation are removed, so only the translated text is shown.
+func sharedChapter(filePath string) (string, error) {
+	doc, err := openAndReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	doc.Find(fmt.Sprintf("[%s][%s]", util.ContentIdKey, util.TranslationByIdKey)).Remove()
+
+	return doc.Html()
+}
+
+func isSharedResource(mediaType string) bool {
+	for _, prefix := range []string{"image/", "font/", "audio/", "video/", "text/css"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(mediaType, "font") || strings.Contains(mediaType, "opentype")
+}
+
+func findItemByHref(pkg *loader.Package, href string) *loader.Item {
+	for i, item := range pkg.Manifest.Items {
+		if path.Clean(item.Href) == href {
+			return &pkg.Manifest.Items[i]
+		}
+	}
+	return nil
+}
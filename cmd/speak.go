@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/tts"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+func init() {
+	Serve.Flags().StringVar(&ttsProvider, "tts-provider", "openai", "text to speech provider reading segments aloud in the editor: "+strings.Join(tts.Providers(), ", "))
+	Serve.Flags().StringVar(&ttsModel, "tts-model", "", "model of the text to speech provider; for piper, the .onnx voice model")
+	Serve.Flags().StringVar(&ttsVoice, "voice", "", "voice reading translations; defaults to the provider's default voice")
+	Serve.Flags().StringVar(&ttsSourceVoice, "source-voice", "", "voice reading originals; defaults to --voice")
+	Serve.Flags().Float64Var(&ttsSpeed, "speed", 0, "speaking rate, 1 being normal; defaults to the provider's rate")
+}
+
+type SpeakRequest struct {
+	FilePath  string `json:"file_path"`
+	ContentID string `json:"content_id"`
+	// Original reads the original of the segment instead of its translation.
+	Original bool `json:"original"`
+}
+
+// speechText returns the text of the segment of content id to read aloud, or
+// of its translation.
+func speechText(doc *goquery.Document, contentID string, original bool) (string, error) {
+	segment := doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.AttrOr(util.ContentIdKey, "") == contentID
+	}).First()
+	if segment.Length() == 0 {
+		return "", fiber.NewError(fiber.StatusNotFound, "Content ID not found")
+	}
+	if original {
+		return spokenText(segment), nil
+	}
+
+	translationID := segment.AttrOr(util.TranslationByIdKey, "")
+	translation := doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return translationID != "" && s.AttrOr(util.TranslationIdKey, "") == translationID
+	}).First()
+	if translation.Length() == 0 {
+		return "", fiber.NewError(fiber.StatusNotFound, "The segment is not translated")
+	}
+	return spokenText(translation), nil
+}
+
+// registerSpeechAPI adds the endpoint reading a segment or its translation
+// aloud with the --tts-provider of the audiobook command, so the editor can
+// preview how the book will sound.
+func registerSpeechAPI(api fiber.Router, unpackedEpubPath, contentDirPath string) {
+	api.Post("/speak", func(c *fiber.Ctx) error {
+		var req SpeakRequest
+		if err := c.BodyParser(&req); err != nil || req.FilePath == "" || req.ContentID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		doc, err := openAndReadFile(filepath.Join(contentDirPath, path.Clean("/"+req.FilePath)))
+		if os.IsNotExist(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read file"})
+		}
+		text, err := speechText(doc, req.ContentID, req.Original)
+		if err != nil {
+			return aiTranslationError(c, err)
+		}
+		if text == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Nothing to read"})
+		}
+
+		// The lexicon is read on every request, so edited pronunciations are
+		// heard right away.
+		lexicon, err := loadBookLexicon(unpackedEpubPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read the pronunciation lexicon"})
+		}
+		voice := ttsVoice
+		if req.Original && ttsSourceVoice != "" {
+			voice = ttsSourceVoice
+		}
+		speaker, err := newSpeaker(voice, lexicon)
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Text to speech is not available: " + err.Error()})
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), serveLimits.aiTimeout)
+		defer cancel()
+		audio, err := speaker.Synthesize(ctx, text)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Reading aloud timed out"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Reading aloud failed"})
+		}
+
+		c.Set(fiber.HeaderContentType, tts.ContentType(speaker.Format()))
+		return c.Send(audio)
+	})
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/tts"
+	"github.com/gofiber/fiber/v2"
+)
+
+// echoSpeaker returns the text it is asked to read as its audio.
+type echoSpeaker struct{ voice string }
+
+func (e echoSpeaker) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	return []byte(e.voice + ": " + text), nil
+}
+
+func (e echoSpeaker) Format() string { return "mp3" }
+
+func init() {
+	tts.Register("echo", func(cfg *tts.Config) (tts.Synthesizer, error) {
+		return echoSpeaker{voice: cfg.Voice}, nil
+	})
+}
+
+func TestSpeechAPI(t *testing.T) {
+	defer func(provider, voice, sourceVoice string) {
+		ttsProvider, ttsVoice, ttsSourceVoice = provider, voice, sourceVoice
+	}(ttsProvider, ttsVoice, ttsSourceVoice)
+	ttsProvider, ttsVoice, ttsSourceVoice = "echo", "nova", "onyx"
+
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Speech")
+	chapter := `<html><body>
+<p data-content-id="a" data-translation-by-id="ta">The <b>sea</b>.</p>
+<p data-translation-id="ta">Biển.</p>
+<p data-content-id="b">Not translated.</p>
+</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"), []byte(chapter), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	registerSpeechAPI(app.Group(apiV1), dir, filepath.Join(dir, "OEBPS"))
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{`{"file_path":"ch1.xhtml","content_id":"a"}`, 200, "nova: Biển."},
+		{`{"file_path":"ch1.xhtml","content_id":"a","original":true}`, 200, "onyx: The sea."},
+		{`{"file_path":"ch1.xhtml","content_id":"b"}`, 404, ""},
+		{`{"file_path":"ch2.xhtml","content_id":"a"}`, 404, ""},
+		{`{"file_path":"ch1.xhtml"}`, 400, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", apiV1+"/speak", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.body, resp.StatusCode, tt.wantStatus, body)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		if string(body) != tt.want {
+			t.Errorf("%s: read %q, want %q", tt.body, body, tt.want)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "audio/mpeg" {
+			t.Errorf("%s: content type = %q, want audio/mpeg", tt.body, got)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The AI translations of a shared serve are paid with one API key. With
+// --user-header, the reverse proxy in front of serve names the signed-in user
+// and the cost of every AI translation is added to the spend of that user for
+// the month, at list prices; --user-quota caps it.
+
+// anonymousUser is the user of requests without the user header.
+const anonymousUser = "anonymous"
+
+var (
+	// userHeader is the request header naming the user; "" tracks no spend.
+	userHeader string
+	// userQuotaFlags are the --user-quota values: dollars for every user, or
+	// user=dollars for one.
+	userQuotaFlags []string
+
+	// serveSpend is the spend of the users of serve, nil when not tracked.
+	serveSpend *spendStore
+)
+
+func init() {
+	Serve.Flags().StringVar(&userHeader, "user-header", "", "request header the reverse proxy names the signed-in user in, such as X-Forwarded-User; tracks the AI translation spend of every user")
+	Serve.Flags().StringArrayVar(&userQuotaFlags, "user-quota", nil, "monthly AI translation budget in US dollars of every user, or user=dollars for one user; needs --user-header")
+}
+
+// loadServeSpend starts tracking the spend of the users with --user-header,
+// in the spend file of prefix.
+func loadServeSpend(prefix string) error {
+	quotas, err := parseUserQuotas(userQuotaFlags)
+	if err != nil {
+		return err
+	}
+	if userHeader == "" {
+		if len(userQuotaFlags) > 0 {
+			return fmt.Errorf("--user-quota needs --user-header to tell the users apart")
+		}
+		return nil
+	}
+	serveSpend, err = loadSpendStore(spendStorePath(prefix), quotas)
+	return err
+}
+
+// userSpend is what the AI translations of a user cost in a month.
+type userSpend struct {
+	Calls        int `json:"calls"`
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	Characters   int `json:"characters,omitempty"`
+	// Cost is in US dollars at list prices; models without a known price
+	// cost nothing.
+	Cost float64 `json:"cost"`
+}
+
+// spendQuotas are the monthly budgets of the users in US dollars.
+type spendQuotas struct {
+	// all applies to users without a budget of their own; 0 is none.
+	all   float64
+	users map[string]float64
+}
+
+// parseUserQuotas parses --user-quota values such as 10 or alice=25.
+func parseUserQuotas(values []string) (spendQuotas, error) {
+	quotas := spendQuotas{users: map[string]float64{}}
+	for _, value := range values {
+		user, dollars, found := strings.Cut(value, "=")
+		if !found {
+			user, dollars = "", value
+		}
+		amount, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(dollars), "$"), 64)
+		if err != nil || amount < 0 {
+			return spendQuotas{}, fmt.Errorf("invalid --user-quota %q: use dollars, such as 10, or user=dollars", value)
+		}
+		if user = strings.TrimSpace(user); user == "" {
+			quotas.all = amount
+		} else {
+			quotas.users[user] = amount
+		}
+	}
+	return quotas, nil
+}
+
+// of returns the budget of user, if there is one.
+func (q spendQuotas) of(user string) (float64, bool) {
+	if amount, ok := q.users[user]; ok {
+		return amount, true
+	}
+	return q.all, q.all > 0
+}
+
+// spendStore keeps the spend of the users by month, next to the book or the
+// library served.
+type spendStore struct {
+	mu     sync.Mutex
+	path   string
+	quotas spendQuotas
+	// Months holds the spend of every user by month, such as 2026-10.
+	Months map[string]map[string]*userSpend `json:"months"`
+}
+
+func spendStorePath(prefix string) string {
+	return filepath.Clean(prefix) + "-spend.json"
+}
+
+func loadSpendStore(storePath string, quotas spendQuotas) (*spendStore, error) {
+	store := &spendStore{path: storePath, quotas: quotas, Months: map[string]map[string]*userSpend{}}
+
+	data, err := os.ReadFile(storePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading spend: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("parsing spend: %w", err)
+		}
+	}
+	return store, nil
+}
+
+func (s *spendStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling spend: %w", err)
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+func spendMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// add adds the usage of a call of user to the spend of this month.
+func (s *spendStore) add(user string, usage translator.UsageStats, cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	month := spendMonth(time.Now())
+	if s.Months[month] == nil {
+		s.Months[month] = map[string]*userSpend{}
+	}
+	spent := s.Months[month][user]
+	if spent == nil {
+		spent = &userSpend{}
+		s.Months[month][user] = spent
+	}
+	spent.Calls += usage.Calls
+	spent.InputTokens += usage.InputTokens + usage.CacheReadTokens + usage.CacheWriteTokens
+	spent.OutputTokens += usage.OutputTokens
+	spent.Characters += usage.Characters
+	spent.Cost += cost
+
+	if err := s.save(); err != nil {
+		fmt.Printf("Error writing spend: %v\n", err)
+	}
+}
+
+// errBudgetUsedUp refuses AI translations to a user whose budget is spent.
+type errBudgetUsedUp struct {
+	quota float64
+}
+
+func (e errBudgetUsedUp) Error() string {
+	return fmt.Sprintf("Your AI translation budget of $%.2f for this month is used up", e.quota)
+}
+
+// check returns errBudgetUsedUp when user spent their budget this month. A
+// nil store checks nothing.
+func (s *spendStore) check(user string) error {
+	if s == nil {
+		return nil
+	}
+	quota, ok := s.quotas.of(user)
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if spent := s.Months[spendMonth(time.Now())][user]; spent != nil && spent.Cost >= quota {
+		return errBudgetUsedUp{quota: quota}
+	}
+	return nil
+}
+
+// requestUser returns the user named by the user header of the request.
+func requestUser(c *fiber.Ctx) string {
+	if user := strings.TrimSpace(c.Get(userHeader)); userHeader != "" && user != "" {
+		return user
+	}
+	return anonymousUser
+}
+
+type spendUserContextKey struct{}
+
+// withSpendUser returns a context whose AI translations are paid by user.
+func withSpendUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, spendUserContextKey{}, user)
+}
+
+// observe returns a context adding the calls made with ctx to the spend of
+// its user. A nil store returns ctx.
+func (s *spendStore) observe(ctx context.Context, provider translator.Provider) context.Context {
+	user, ok := ctx.Value(spendUserContextKey{}).(string)
+	if s == nil || !ok {
+		return ctx
+	}
+	price, _ := translator.PriceOf(translationProvider, provider.Model())
+	return translator.WithUsageObserver(ctx, func(usage translator.UsageStats) {
+		s.add(user, usage, price.UsageCost(usage))
+	})
+}
+
+// userSpendReport is the spend of a user in the spend report.
+type userSpendReport struct {
+	User string `json:"user"`
+	userSpend
+	// Quota is the monthly budget of the user, if any.
+	Quota *float64 `json:"quota,omitempty"`
+}
+
+// spendReport is the spend of all users in a month.
+type spendReport struct {
+	Month string `json:"month"`
+	// User is the user asking.
+	User  string            `json:"user"`
+	Users []userSpendReport `json:"users"`
+}
+
+// report returns the spend of month, the most spending users first.
+func (s *spendStore) report(month, user string) spendReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := spendReport{Month: month, User: user, Users: []userSpendReport{}}
+	for name, spent := range s.Months[month] {
+		entry := userSpendReport{User: name, userSpend: *spent}
+		if quota, ok := s.quotas.of(name); ok {
+			entry.Quota = &quota
+		}
+		report.Users = append(report.Users, entry)
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		if report.Users[i].Cost != report.Users[j].Cost {
+			return report.Users[i].Cost > report.Users[j].Cost
+		}
+		return report.Users[i].User < report.Users[j].User
+	})
+	return report
+}
+
+func registerSpendAPI(api fiber.Router) {
+	api.Get("/spend", func(c *fiber.Ctx) error {
+		if serveSpend == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Spend is tracked with --user-header only"})
+		}
+		month := c.Query("month", spendMonth(time.Now()))
+		if _, err := time.Parse("2006-01", month); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid month, use YYYY-MM"})
+		}
+		return c.JSON(serveSpend.report(month, requestUser(c)))
+	})
+}
+
+// budgetError answers a request of a user whose budget is used up.
+func budgetError(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{"error": err.Error()})
+}
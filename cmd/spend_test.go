@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+)
+
+func TestParseUserQuotas(t *testing.T) {
+	quotas, err := parseUserQuotas([]string{"5", "alice=$20", "bob=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user  string
+		quota float64
+		ok    bool
+	}{
+		{"carol", 5, true},
+		{"alice", 20, true},
+		{"bob", 0, true},
+	}
+	for _, tt := range tests {
+		if quota, ok := quotas.of(tt.user); quota != tt.quota || ok != tt.ok {
+			t.Errorf("quota of %s = %v, %v; want %v, %v", tt.user, quota, ok, tt.quota, tt.ok)
+		}
+	}
+
+	if _, err := parseUserQuotas([]string{"alice=lots"}); err == nil {
+		t.Error("parseUserQuotas() accepted a quota that is not a number")
+	}
+}
+
+func TestSpendStore(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "book-spend.json")
+	store, err := loadSpendStore(storePath, spendQuotas{all: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls made for a user are added to their spend.
+	mock, err := translator.NewMock(&translator.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := store.observe(withSpendUser(context.Background(), "alice"), mock)
+	if _, err := mock.Translate(ctx, "", "<p>Hello</p>", "English", "German", "Book"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.check("alice"); err != nil {
+		t.Errorf("check() after a free call = %v", err)
+	}
+
+	store.add("alice", translator.UsageStats{Calls: 1, InputTokens: 1000, OutputTokens: 2000}, 1.25)
+	if err := store.check("alice"); err == nil {
+		t.Error("check() let alice spend beyond the budget")
+	}
+	if err := store.check("bob"); err != nil {
+		t.Errorf("check() of bob = %v", err)
+	}
+
+	reloaded, err := loadSpendStore(storePath, spendQuotas{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := reloaded.report(spendMonth(time.Now()), "alice")
+	if len(report.Users) != 1 || report.Users[0].Calls != 2 || report.Users[0].Characters != len("<p>Hello</p>") || report.Users[0].Cost != 1.25 {
+		t.Errorf("report = %+v, want the two calls of alice", report.Users)
+	}
+}
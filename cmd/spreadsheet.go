@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/dutchsteven/epubtrans/pkg/xlsx"
+	"github.com/spf13/cobra"
+)
+
+const (
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+)
+
+// segmentColumns are the columns of an exported sheet. Imports find them by
+// name, so reviewers may reorder them or add their own.
+var segmentColumns = []string{"file", "segment_id", "source", "translation", "status"}
+
+// utf8BOM starts CSV files so that Excel reads them as UTF-8.
+const utf8BOM = "\uFEFF"
+
+var Export = &cobra.Command{
+	Use:   "export [unpackedEpubPath] [output]",
+	Short: "Export the segments of a book as a CSV or XLSX sheet for review",
+	Long: `This command writes a row for every marked segment of the book, in reading order, with the file, the segment id,
+the source, the current translation and its status: untranslated, machine-translated, human-edited, approved or
+rejected. Review the sheet in a spreadsheet program and bring the edited rows back with import.`,
+	Example: `epubtrans export path/to/unpacked/epub review.xlsx`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and an output file are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runExport,
+}
+
+var Import = &cobra.Command{
+	Use:   "import [unpackedEpubPath] [input]",
+	Short: "Apply the edited rows of a CSV or XLSX sheet to a book",
+	Long: `This command writes the translations of a sheet made by export into the book as translations edited by hand,
+recorded in the edit history like edits in serve. Rows are matched by file and segment id; rows whose translation and
+status did not change are left alone, as are empty translations and rows whose source no longer matches the original.
+A status of approved or rejected gives the translation of the row that verdict in the review.`,
+	Example: `epubtrans import path/to/unpacked/epub review.xlsx`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and an input file are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runImport,
+}
+
+func init() {
+	Export.Flags().String("format", "", "csv or xlsx (default: from the extension of the output, csv otherwise)")
+	Import.Flags().String("format", "", "csv or xlsx (default: from the extension of the input, csv otherwise)")
+}
+
+// sheetFormat returns the format of the sheet name, flagged or guessed from
+// its extension.
+func sheetFormat(flagged, name string) (string, error) {
+	switch strings.ToLower(flagged) {
+	case formatCSV, formatXLSX:
+		return strings.ToLower(flagged), nil
+	case "":
+		if strings.EqualFold(filepath.Ext(name), ".xlsx") {
+			return formatXLSX, nil
+		}
+		return formatCSV, nil
+	default:
+		return "", fmt.Errorf("unknown format %q: use csv or xlsx", flagged)
+	}
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	flagged, _ := cmd.Flags().GetString("format")
+	format, err := sheetFormat(flagged, args[1])
+	if err != nil {
+		return err
+	}
+
+	rows, err := segmentRows(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := encodeSheet(format, rows)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(args[1], data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d segments to %s\n", len(rows)-1, args[1])
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	flagged, _ := cmd.Flags().GetString("format")
+	format, err := sheetFormat(flagged, args[1])
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	rows, err := decodeSheet(format, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+	edits, err := sheetEdits(rows)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+
+	result, err := importSegmentEdits(args[0], "", edits)
+	if err != nil {
+		return err
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("  skipped %s\n", skipped)
+	}
+	fmt.Printf("Imported %d rows from %s (%d unchanged, %d skipped)\n", result.Applied, args[1], result.Unchanged, len(result.Skipped))
+	return nil
+}
+
+// segmentRows returns the header and a row for every segment of the book,
+// in reading order.
+func segmentRows(unzipPath string) ([][]string, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unzipPath))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{segmentColumns}
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		segments, err := fileSegments(book, item.Href, reviews, newSegmentLocks())
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		for _, segment := range segments {
+			rows = append(rows, []string{item.Href, segmentUnitID(segment.ContentID, segment.Occurrence), segment.Source, segment.Translation, segment.Status})
+		}
+	}
+	return rows, nil
+}
+
+// sheetEdits reads the edits of the rows of a sheet, the first row naming
+// the columns.
+func sheetEdits(rows [][]string) ([]segmentEdit, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the sheet is empty")
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range segmentColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the sheet has no %s column", name)
+		}
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var edits []segmentEdit
+	for _, row := range rows[1:] {
+		edit := segmentEdit{
+			File:        cell(row, "file"),
+			Source:      cell(row, "source"),
+			Translation: cell(row, "translation"),
+		}
+		edit.ContentID, edit.Occurrence = splitSegmentUnitID(strings.TrimSpace(cell(row, "segment_id")))
+		if edit.ContentID == "" || strings.TrimSpace(edit.Translation) == "" {
+			continue
+		}
+		switch status := strings.ToLower(strings.TrimSpace(cell(row, "status"))); status {
+		case statusApproved, statusRejected:
+			edit.Verdict = status
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
+}
+
+func encodeSheet(format string, rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	if format == formatXLSX {
+		if err := xlsx.Write(&buf, "Segments", rows); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteString(utf8BOM)
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSheet(format string, data []byte) ([][]string, error) {
+	if format == formatXLSX {
+		return xlsx.Read(bytes.NewReader(data), int64(len(data)))
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte(utf8BOM))))
+	// Spreadsheet programs leave out trailing empty cells.
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
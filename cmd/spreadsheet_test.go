@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpreadsheetRoundTrip(t *testing.T) {
+	for _, format := range []string{formatCSV, formatXLSX} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSyncBook(t, dir)
+
+			rows, err := segmentRows(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"ch1.xhtml", "a", "Hello", "Hallo", statusMachine}; len(rows) != 5 || !reflect.DeepEqual(rows[1], want) {
+				t.Fatalf("segmentRows() = %q", rows)
+			}
+
+			data, err := encodeSheet(format, rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err = decodeSheet(format, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A reviewer moves the status column first, fixes a, approves c
+			// and rejects d.
+			for i, row := range rows {
+				rows[i] = append([]string{row[4]}, row[:4]...)
+			}
+			rows[1][4] = "Guten Tag"
+			rows[3][0] = "Approved"
+			rows[4][0] = "rejected"
+
+			edits, err := sheetEdits(rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := importSegmentEdits(dir, "", edits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Applied != 3 || result.Unchanged != 0 || len(result.Skipped) != 0 {
+				t.Errorf("importSegmentEdits() = %+v", result)
+			}
+
+			rows, err = segmentRows(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string][2]string{}
+			for _, row := range rows[1:] {
+				got[row[1]] = [2]string{row[3], row[4]}
+			}
+			want := map[string][2]string{
+				"a": {"Guten Tag", statusEdited},
+				"b": {"", statusUntranslated},
+				"c": {"Eins", statusApproved},
+				"d": {"Zwei", statusRejected},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("after the import: %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSheetEditsColumns(t *testing.T) {
+	if _, err := sheetEdits([][]string{{"file", "segment_id", "translation"}}); err == nil {
+		t.Error("sheetEdits() accepted a sheet without a source column")
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// A translation records the hash of the text of its original when it is
+// made or edited. Once the original is corrected, e.g. after marking the book
+// again, the hashes differ and the translation is stale: it translates a text
+// the book no longer has. Translations made before the hash was recorded are
+// never stale.
+
+// sourceHash identifies the text of an original, without the link to its
+// translation and the page breaks, so only changes of the text count.
+func sourceHash(original *goquery.Selection) string {
+	clone := original.Clone()
+	clone.Find("a.epubtrans-noteref").Remove()
+	clone.Find("*").FilterFunction(isPageBreak).Remove()
+	return translationHash(strings.Join(strings.Fields(clone.Text()), " "))
+}
+
+// stampSource records on translation the hash of the original it translates
+// now.
+func stampSource(translation, original *goquery.Selection) {
+	if original == nil || original.Length() == 0 {
+		return
+	}
+	translation.SetAttr(util.TranslationSourceHashKey, sourceHash(original))
+}
+
+// isStale reports whether the original changed since translation was made
+// from it.
+func isStale(original, translation *goquery.Selection) bool {
+	return staleHash(original, translation.AttrOr(util.TranslationSourceHashKey, ""))
+}
+
+// staleHash reports whether original no longer has the recorded source hash.
+func staleHash(original *goquery.Selection, recorded string) bool {
+	return recorded != "" && recorded != sourceHash(original)
+}
+
+// staleTranslation is a segment whose translation is stale.
+type staleTranslation struct {
+	File      string `json:"file"`
+	ContentID string `json:"content_id"`
+}
+
+// staleTranslations returns the segments with a stale translation in reading
+// order. Translations may be placed in another file than their original.
+func staleTranslations(unzipPath string) ([]staleTranslation, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	items := processor.ReadingOrder(book.pkg)
+	docs := map[string]*goquery.Document{}
+	// recorded holds the source hash of every translation by translation id.
+	recorded := map[string]string{}
+	for _, item := range items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		docs[item.Href] = doc
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			recorded[s.AttrOr(util.TranslationIdKey, "")] = s.AttrOr(util.TranslationSourceHashKey, "")
+		})
+	}
+
+	var stale []staleTranslation
+	for _, item := range items {
+		doc, ok := docs[item.Href]
+		if !ok {
+			continue
+		}
+		doc.Find(fmt.Sprintf("[%s][%s]", util.ContentIdKey, util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+			if staleHash(s, recorded[s.AttrOr(util.TranslationByIdKey, "")]) {
+				stale = append(stale, staleTranslation{File: item.Href, ContentID: s.AttrOr(util.ContentIdKey, "")})
+			}
+		})
+	}
+	return stale, nil
+}
+
+// warnStale prints a warning about the stale translations of the book.
+func warnStale(unzipPath string) error {
+	stale, err := staleTranslations(unzipPath)
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	files := []string{}
+	for _, s := range stale {
+		if len(files) == 0 || files[len(files)-1] != s.File {
+			files = append(files, s.File)
+		}
+	}
+	fmt.Printf("Warning: %d translations are stale, their originals changed after they were translated (%s); run qa to list them\n", len(stale), strings.Join(files, ", "))
+	return nil
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+func TestSourceHash(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<p id="a">Hello  <em>world</em></p>
+<p id="b">Hello world<span epub:type="pagebreak" title="7"></span><a epub:type="noteref" class="epubtrans-noteref" href="#n">*</a></p>
+<p id="c">Hello, world</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := doc.Find("#a"), doc.Find("#b"), doc.Find("#c")
+	if sourceHash(a) != sourceHash(b) {
+		t.Error("markup, page breaks and note links change the source hash")
+	}
+	if sourceHash(a) == sourceHash(c) {
+		t.Error("a changed text keeps the source hash")
+	}
+
+	translation, _, err := newTranslatedElement(a, "de", "Hallo Welt", runProvenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.AttrOr(util.TranslationSourceHashKey, "") != sourceHash(a) || isStale(a, translation) {
+		t.Errorf("new translation %s is not stamped with its source", translation.AttrOr(util.TranslationSourceHashKey, ""))
+	}
+	if !isStale(c, translation) {
+		t.Error("translation of another text is not stale")
+	}
+}
+
+func TestStaleTranslations(t *testing.T) {
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+	editSyncChapter(t, dir, `data-translation-id="ta"`, `data-translation-id="ta" data-translation-source-hash="`+translationHash("Hello")+`"`)
+	editSyncChapter(t, dir, `data-translation-id="tc"`, `data-translation-id="tc" data-translation-source-hash="`+translationHash("One")+`"`)
+	// Translations without a source hash are never stale.
+	editSyncChapter(t, dir, `>Two<`, `>Two!<`)
+
+	if stale, err := staleTranslations(dir); err != nil || len(stale) != 0 {
+		t.Fatalf("staleTranslations() = %v, %v; want none", stale, err)
+	}
+
+	editSyncChapter(t, dir, `>Hello<`, `>Hello there<`)
+	stale, err := staleTranslations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0] != (staleTranslation{File: "ch1.xhtml", ContentID: "a"}) {
+		t.Errorf("staleTranslations() = %v, want a", stale)
+	}
+
+	progress, err := readingProgress(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Stale != 1 || progress.Chapters[0].Stale != 1 {
+		t.Errorf("progress counts %d stale, want 1", progress.Stale)
+	}
+}
@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+// The content manifest of a book: <unpacked-dir>-snapshot.json holds the
+// hashes of its files when it was unpacked, packed or snapshotted, and
+// <unpacked-dir>-writes.jsonl the hash of every file epubtrans wrote since,
+// with the command that wrote it. A file whose hash is neither is changed
+// outside epubtrans.
+
+var Status = &cobra.Command{
+	Use:   "status [unpackedEpubPath]",
+	Short: "Show the review state of the segments and the files changed since the book was unpacked or packed",
+	Long: `This command counts the segments of the book by status: untranslated, machine-translated, human-edited in
+serve, approved or rejected in review. A verdict on a translation changed since no longer counts.
+
+It then lists the files of the unpacked book added, modified or deleted since the last snapshot of their
+hashes, which unpack and pack take, like git status but without git. Every file epubtrans writes is recorded with
+the command that wrote it, so files changed by hand or by another program are told apart. Chapters show their
+position in the spine, and added files that the package document does not list are pointed out.`,
+	Example: `epubtrans status path/to/unpacked/epub
+epubtrans status path/to/unpacked/epub --chapters
+epubtrans status path/to/unpacked/epub --snapshot`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runStatus,
+}
+
+func init() {
+	Status.Flags().Bool("snapshot", false, "record the hashes of the files as they are now, so later changes are shown")
+	Status.Flags().Bool("chapters", false, "count the segments by status for every chapter too")
+}
+
+// writeSource is the command whose writes are recorded.
+var writeSource = "epubtrans"
+
+// writesMu serializes the records of the writes of the process.
+var writesMu sync.Mutex
+
+// fileSnapshot is the hashes of the files of a book at one moment.
+type fileSnapshot struct {
+	Time time.Time `json:"time"`
+	// Label is unpack, pack or snapshot.
+	Label string `json:"label"`
+	// Files holds the SHA-256 of every file by its slash-separated path in
+	// the unpacked directory.
+	Files map[string]string `json:"files"`
+}
+
+// fileWrite records a file written by epubtrans.
+type fileWrite struct {
+	Time   time.Time `json:"time"`
+	File   string    `json:"file"`
+	SHA256 string    `json:"sha256"`
+	By     string    `json:"by"`
+}
+
+func snapshotPath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-snapshot.json"
+}
+
+func writesPath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-writes.jsonl"
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeBookFile writes a file of an unpacked book and records its hash.
+func writeBookFile(filePath string, data []byte) error {
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return err
+	}
+	recordWrite(filePath, data)
+	return nil
+}
+
+// recordWrite records that data was written to filePath, when it is in an
+// unpacked book. The file is written already, so a failure is only logged.
+func recordWrite(filePath string, data []byte) {
+	root := bookRootOf(filePath)
+	if root == "" {
+		return
+	}
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return
+	}
+
+	line, err := json.Marshal(fileWrite{Time: time.Now(), File: filepath.ToSlash(rel), SHA256: contentHash(data), By: writeSource})
+	if err != nil {
+		return
+	}
+
+	writesMu.Lock()
+	defer writesMu.Unlock()
+	f, err := os.OpenFile(writesPath(root), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		slog.Warn("failed to record the hash of a written file", "file", filePath, "error", err)
+	}
+}
+
+// bookRootOf returns the unpacked book holding filePath, "" if there is none.
+func bookRootOf(filePath string) string {
+	dir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "META-INF", "container.xml")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// hashBookFiles returns the SHA-256 of every file of the unpacked book. The
+// translation cache is left out, as pack leaves it out of the EPUB: it is not
+// part of the book, and changes with every translation.
+func hashBookFiles(unpackedEpubPath string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.Walk(unpackedEpubPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if info.Name() == bookCacheFile {
+			return nil
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(unpackedEpubPath, filePath)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return hashes, err
+}
+
+// takeSnapshot records the hashes of the files of the book as they are now.
+// The writes recorded until now are part of it, so their log starts over.
+func takeSnapshot(unpackedEpubPath, label string) error {
+	files, err := hashBookFiles(unpackedEpubPath)
+	if err != nil {
+		return fmt.Errorf("hashing the files of the book: %w", err)
+	}
+	data, err := json.MarshalIndent(fileSnapshot{Time: time.Now(), Label: label, Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	writesMu.Lock()
+	defer writesMu.Unlock()
+	if err := os.WriteFile(snapshotPath(unpackedEpubPath), data, 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(writesPath(unpackedEpubPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func loadSnapshot(unpackedEpubPath string) (*fileSnapshot, error) {
+	data, err := os.ReadFile(snapshotPath(unpackedEpubPath))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot fileSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("parsing snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// lastWrites returns the latest recorded write of every file.
+func lastWrites(unpackedEpubPath string) (map[string]fileWrite, error) {
+	data, err := os.ReadFile(writesPath(unpackedEpubPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]fileWrite{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	writes := map[string]fileWrite{}
+	for _, line := range strings.Split(string(data), "\n") {
+		var w fileWrite
+		// A line cut short by a crash is skipped.
+		if line == "" || json.Unmarshal([]byte(line), &w) != nil {
+			continue
+		}
+		writes[w.File] = w
+	}
+	return writes, nil
+}
+
+// fileChange is a file changed since the snapshot.
+type fileChange struct {
+	File string
+	// Change is added, modified or deleted.
+	Change string
+	// By is the command that wrote the file as it is; empty when it was
+	// changed outside epubtrans, or deleted.
+	By string
+}
+
+// changedFiles compares the files of the book now with a snapshot and the
+// writes recorded since.
+func changedFiles(snapshot *fileSnapshot, current map[string]string, writes map[string]fileWrite) []fileChange {
+	var changes []fileChange
+	for file, hash := range current {
+		old, ok := snapshot.Files[file]
+		if ok && old == hash {
+			continue
+		}
+		change := fileChange{File: file, Change: "modified"}
+		if !ok {
+			change.Change = "added"
+		}
+		if w, ok := writes[file]; ok && w.SHA256 == hash {
+			change.By = w.By
+		}
+		changes = append(changes, change)
+	}
+	for file := range snapshot.Files {
+		if _, ok := current[file]; !ok {
+			changes = append(changes, fileChange{File: file, Change: "deleted"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].File < changes[j].File })
+	return changes
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	unpackedEpubPath := args[0]
+
+	if snapshot, _ := cmd.Flags().GetBool("snapshot"); snapshot {
+		if err := takeSnapshot(unpackedEpubPath, "snapshot"); err != nil {
+			return err
+		}
+		fmt.Println("Recorded a snapshot of the files of the book")
+		return nil
+	}
+
+	if err := printTranslationStatus(cmd, unpackedEpubPath); err != nil {
+		return err
+	}
+
+	snapshot, err := loadSnapshot(unpackedEpubPath)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("No snapshot of the book yet; run status --snapshot to take one")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := hashBookFiles(unpackedEpubPath)
+	if err != nil {
+		return err
+	}
+	writes, err := lastWrites(unpackedEpubPath)
+	if err != nil {
+		return err
+	}
+
+	changes := changedFiles(snapshot, current, writes)
+	fmt.Printf("Changes since the %s of %s:\n", snapshot.Label, snapshot.Time.Local().Format("2006-01-02 15:04"))
+	if len(changes) == 0 {
+		fmt.Println("  none")
+		return nil
+	}
+
+	// Files are described by their place in the package document.
+	items := map[string]string{}
+	if book, err := openBookFiles(unpackedEpubPath); err == nil {
+		rel, _ := filepath.Rel(unpackedEpubPath, book.contentDir)
+		spine := map[string]int{}
+		for i, ref := range book.pkg.Spine.ItemRefs {
+			spine[ref.IDRef] = i + 1
+		}
+		for _, item := range book.pkg.Manifest.Items {
+			file := path.Join(filepath.ToSlash(rel), item.Href)
+			items[file] = item.MediaType
+			if n, ok := spine[item.ID]; ok {
+				items[file] = fmt.Sprintf("spine %d", n)
+			}
+		}
+	}
+
+	outside := 0
+	for _, c := range changes {
+		var notes []string
+		if item, ok := items[c.File]; ok {
+			notes = append(notes, item)
+		} else if c.Change == "added" && c.File != "mimetype" && !strings.HasPrefix(c.File, "META-INF/") {
+			notes = append(notes, "not in the manifest")
+		}
+		switch {
+		case c.By != "":
+			notes = append(notes, "written by "+c.By)
+		case c.Change != "deleted":
+			notes = append(notes, "changed outside epubtrans")
+			outside++
+		}
+		fmt.Printf("  %-9s %s (%s)\n", c.Change+":", c.File, strings.Join(notes, "; "))
+	}
+	if outside > 0 {
+		fmt.Printf("%d files were changed outside epubtrans\n", outside)
+	}
+	return nil
+}
+
+// printTranslationStatus prints the number of segments with every status.
+func printTranslationStatus(cmd *cobra.Command, unpackedEpubPath string) error {
+	reviews, err := loadReviewStore(reviewStorePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+	status, err := bookTranslationStatus(unpackedEpubPath, reviews)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Segments: %d (%s)\n", status.Segments, status.Counts)
+	if chapters, _ := cmd.Flags().GetBool("chapters"); chapters {
+		for _, chapter := range status.Chapters {
+			fmt.Printf("  %s: %d (%s)\n", chapter.File, chapter.Segments, chapter.Counts)
+		}
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChangedFiles(t *testing.T) {
+	snapshot := &fileSnapshot{Files: map[string]string{
+		"OEBPS/ch1.xhtml": "1",
+		"OEBPS/ch2.xhtml": "2",
+		"OEBPS/ch3.xhtml": "3",
+		"OEBPS/ch4.xhtml": "4",
+	}}
+	current := map[string]string{
+		"OEBPS/ch1.xhtml":  "1",
+		"OEBPS/ch2.xhtml":  "2b",
+		"OEBPS/ch3.xhtml":  "3c",
+		"OEBPS/new.xhtml":  "n",
+		"OEBPS/more.xhtml": "m",
+	}
+	writes := map[string]fileWrite{
+		"OEBPS/ch2.xhtml":  {File: "OEBPS/ch2.xhtml", SHA256: "2b", By: "translate"},
+		"OEBPS/ch3.xhtml":  {File: "OEBPS/ch3.xhtml", SHA256: "3b", By: "translate"},
+		"OEBPS/more.xhtml": {File: "OEBPS/more.xhtml", SHA256: "m", By: "split"},
+	}
+
+	want := []fileChange{
+		{File: "OEBPS/ch2.xhtml", Change: "modified", By: "translate"},
+		{File: "OEBPS/ch3.xhtml", Change: "modified"},
+		{File: "OEBPS/ch4.xhtml", Change: "deleted"},
+		{File: "OEBPS/more.xhtml", Change: "added", By: "split"},
+		{File: "OEBPS/new.xhtml", Change: "added"},
+	}
+	if got := changedFiles(snapshot, current, writes); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedFiles() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecordWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Status")
+	if err := takeSnapshot(dir, "unpack"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeSource = "translate"
+	defer func() { writeSource = "epubtrans" }()
+	if err := writeBookFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"), []byte("<p>Hallo</p>")); err != nil {
+		t.Fatal(err)
+	}
+	// Files outside a book are not recorded.
+	if err := writeBookFile(filepath.Join(t.TempDir(), "x.xhtml"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	writes, err := lastWrites(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, ok := writes["OEBPS/ch1.xhtml"]
+	if len(writes) != 1 || !ok || w.By != "translate" || w.SHA256 != contentHash([]byte("<p>Hallo</p>")) {
+		t.Fatalf("writes = %+v, want the write of ch1.xhtml by translate", writes)
+	}
+
+	// A snapshot takes in the writes so far.
+	if err := takeSnapshot(dir, "pack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(writesPath(dir)); !os.IsNotExist(err) {
+		t.Errorf("writes log after a snapshot: %v, want it removed", err)
+	}
+	snapshot, err := loadSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Files["OEBPS/ch1.xhtml"] != w.SHA256 || snapshot.Files["META-INF/container.xml"] == "" {
+		t.Errorf("snapshot files = %v, want ch1.xhtml and the container", snapshot.Files)
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+)
+
+// A translate run can match the style of an existing translation: the model
+// distills guidance from a sample once, which is kept next to the book in
+// <unpacked-dir>-style.json and added to the instructions of every batch.
+// Later runs reuse the guidance, with or without --style-sample, until
+// another sample is given.
+
+// styleSample is the file with the sample of --style-sample.
+var styleSample string
+
+// maxStyleSample is the length, in characters, of the part of a sample sent
+// to the model.
+const maxStyleSample = 12000
+
+const styleDistillPrompt = `Do not translate this time. The text below is a sample of a published translation into %s whose style the translation of this book must match. Describe that style as guidance for a translator, in English, as a list of at most ten short points: register and tone, sentence length and rhythm, word choice, dialogue and forms of address, punctuation and typographic conventions. Do not mention the names, places or events of the sample. Answer with the list only.`
+
+func init() {
+	Translate.Flags().StringVar(&styleSample, "style-sample", "", "text file with a sample of a translation whose style to match; the guidance distilled from it is kept in <unpackedEpubPath>-style.json")
+}
+
+// styleGuide is the guidance distilled from a style sample.
+type styleGuide struct {
+	// SampleHash identifies the sample and the target language.
+	SampleHash string    `json:"sample_hash"`
+	Target     string    `json:"target"`
+	Model      string    `json:"model"`
+	Created    time.Time `json:"created"`
+	Guidance   string    `json:"guidance"`
+}
+
+func styleGuidePath(unzipPath string) string {
+	return filepath.Clean(unzipPath) + "-style.json"
+}
+
+// styleInstructions returns the style guidance of the book, for the
+// instructions of its batches. With samplePath it distills the guidance from
+// that sample with t, unless it was distilled from it before; without, it
+// returns the guidance kept with the book, if any.
+func styleInstructions(ctx context.Context, unzipPath, samplePath string, t translator.Provider, limiter translator.Limiter) (string, error) {
+	guidePath := styleGuidePath(unzipPath)
+	var guide styleGuide
+	if data, err := os.ReadFile(guidePath); err == nil {
+		if err := json.Unmarshal(data, &guide); err != nil {
+			return "", fmt.Errorf("parsing %s: %w", guidePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if samplePath == "" {
+		if guide.Guidance == "" {
+			return "", nil
+		}
+		// Guidance on the style of another language would mislead the model.
+		if guide.Target != targetLanguage {
+			fmt.Printf("Style: leaving out the guidance distilled for %s\n", guide.Target)
+			return "", nil
+		}
+		fmt.Printf("Style: guidance distilled on %s\n", guide.Created.Format("2006-01-02"))
+		return styleSection(guide.Guidance), nil
+	}
+
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		return "", fmt.Errorf("reading style sample: %w", err)
+	}
+	sample := strings.TrimSpace(string(data))
+	if sample == "" {
+		return "", fmt.Errorf("the style sample %s is empty", samplePath)
+	}
+	if utf8.RuneCountInString(sample) > maxStyleSample {
+		sample = string([]rune(sample)[:maxStyleSample])
+	}
+	hash := sha256.Sum256([]byte(targetLanguage + "\x00" + sample))
+	sampleHash := hex.EncodeToString(hash[:8])
+
+	if guide.SampleHash == sampleHash && guide.Guidance != "" {
+		fmt.Printf("Style: guidance distilled from %s on %s\n", filepath.Base(samplePath), guide.Created.Format("2006-01-02"))
+		return styleSection(guide.Guidance), nil
+	}
+
+	if translationProvider == "deepl" {
+		return "", fmt.Errorf("--style-sample needs a language model; deepl would translate the sample instead")
+	}
+	fmt.Printf("Style: distilling guidance from %s...\n", filepath.Base(samplePath))
+	if err := limiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("rate limiter error: %w", err)
+	}
+	guidance, err := t.Translate(ctx, fmt.Sprintf(styleDistillPrompt, targetLanguage), sample, sourceLanguage, targetLanguage, "")
+	if err != nil {
+		return "", fmt.Errorf("distilling style guidance: %w", err)
+	}
+	guidance = strings.TrimSpace(guidance)
+	if guidance == "" {
+		return "", fmt.Errorf("distilling style guidance: the model answered nothing")
+	}
+
+	guide = styleGuide{SampleHash: sampleHash, Target: targetLanguage, Model: t.Model(), Created: time.Now().UTC(), Guidance: guidance}
+	out, err := json.MarshalIndent(guide, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(guidePath, out, 0644); err != nil {
+		return "", err
+	}
+	fmt.Println(guidance)
+	jobLog.Info("style guidance distilled", "sample", filepath.Base(samplePath), "model", t.Model())
+	return styleSection(guidance), nil
+}
+
+func styleSection(guidance string) string {
+	if guidance == "" {
+		return ""
+	}
+	return "Style (write the translation in this style):\n" + guidance
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"golang.org/x/time/rate"
+)
+
+func TestStyleInstructions(t *testing.T) {
+	defer func(target string) { targetLanguage = target }(targetLanguage)
+	targetLanguage = "German"
+
+	dir := t.TempDir()
+	unzipPath := filepath.Join(dir, "book")
+	samplePath := filepath.Join(dir, "sample.txt")
+	if err := os.WriteFile(samplePath, []byte("Es war einmal ein kurzer Satz."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mock, err := translator.NewMock(&translator.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	ctx := context.Background()
+
+	if style, err := styleInstructions(ctx, unzipPath, "", mock, limiter); err != nil || style != "" {
+		t.Fatalf("without a sample or guidance: %q, %v", style, err)
+	}
+
+	style, err := styleInstructions(ctx, unzipPath, samplePath, mock, limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(style, "Style") || mock.Usage().Calls != 1 {
+		t.Fatalf("style = %q after %d calls", style, mock.Usage().Calls)
+	}
+
+	// The guidance is distilled once per sample, and kept for later runs.
+	for _, sample := range []string{samplePath, ""} {
+		again, err := styleInstructions(ctx, unzipPath, sample, mock, limiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != style || mock.Usage().Calls != 1 {
+			t.Errorf("sample %q: style = %q after %d calls, want the kept guidance", sample, again, mock.Usage().Calls)
+		}
+	}
+
+	if err := os.WriteFile(samplePath, []byte("Ein anderer Stil, viel länger und verschachtelter."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := styleInstructions(ctx, unzipPath, samplePath, mock, limiter); err != nil {
+		t.Fatal(err)
+	}
+	if mock.Usage().Calls != 2 {
+		t.Errorf("a new sample made %d calls, want it distilled again", mock.Usage().Calls-1)
+	}
+
+	// Guidance distilled for German is not used for another language.
+	targetLanguage = "French"
+	if style, err := styleInstructions(ctx, unzipPath, "", mock, limiter); err != nil || style != "" {
+		t.Errorf("guidance for German used for French: %q, %v", style, err)
+	}
+}
@@ -41,12 +41,17 @@ var Styling = &cobra.Command{
 }
 
 type StylingOptions struct {
-	Hide    string
-	Workers int
+	Hide      string
+	Hyphenate bool
+	// Vertical is set for books written top to bottom; translations are kept horizontal.
+	Vertical bool
+	Workers  int
 }
 
 func init() {
 	Styling.Flags().String("hide", "none", "hide source or target language")
+	Styling.Flags().Bool("hyphenate", false, "let the reader hyphenate the translated text")
+	Styling.Flags().Bool("horizontal", false, "remove vertical writing modes, for a translated-only edition (use with --hide source)")
 	Styling.Flags().Int("workers", runtime.NumCPU(), "Number of worker goroutines")
 }
 
@@ -66,17 +71,36 @@ func runStyling(cmd *cobra.Command, args []string) error {
 	}()
 
 	hide, _ := cmd.Flags().GetString("hide")
+	hyphenate, _ := cmd.Flags().GetBool("hyphenate")
+	horizontal, _ := cmd.Flags().GetBool("horizontal")
 	workers, _ := cmd.Flags().GetInt("workers")
 
-	styleOptions := StylingOptions{
-		Hide:    hide,
-		Workers: workers,
+	if err := util.ValidateEpubPath(unzipPath); err != nil {
+		return err
 	}
 
-	if err := util.ValidateEpubPath(unzipPath); err != nil {
+	vertical, err := isVerticalBook(unzipPath)
+	if err != nil {
 		return err
 	}
 
+	if vertical && horizontal {
+		if err := makeHorizontal(unzipPath); err != nil {
+			return err
+		}
+		vertical = false
+	} else if vertical {
+		fmt.Println("Vertical writing detected: originals stay vertical, translations are laid out horizontally.")
+		fmt.Println("Translate with --placement popup to show translations as notes instead.")
+	}
+
+	styleOptions := StylingOptions{
+		Hide:      hide,
+		Hyphenate: hyphenate,
+		Vertical:  vertical,
+		Workers:   workers,
+	}
+
 	return processor.ProcessEpub(ctx, unzipPath, processor.Config{
 		Workers:      workers,
 		JobBuffer:    10,
@@ -86,16 +110,28 @@ func runStyling(cmd *cobra.Command, args []string) error {
 	})
 }
 
-func generateStyleContent(hide string) string {
+func generateStyleContent(options StylingOptions) string {
 	styleContent := fmt.Sprintf("[%s] { opacity: 0.7;}", util.ContentIdKey)
+	// Diagram labels cannot show both languages at once, so the translation replaces the original.
+	svgOriginal := fmt.Sprintf("svg [%s][%s] { display: none; }", util.ContentIdKey, util.TranslationByIdKey)
 
-	switch hide {
+	switch options.Hide {
 	case "source":
 		styleContent += fmt.Sprintf("[%s] { display: none !important; }", util.ContentIdKey)
+	case "none":
+		styleContent += svgOriginal
 	case "target":
 		styleContent = fmt.Sprintf("[%s] { display: none !important; }", util.TranslationIdKey)
 	}
 
+	if options.Hyphenate {
+		styleContent += fmt.Sprintf("[%s] { -webkit-hyphens: auto; hyphens: auto; }", util.TranslationIdKey)
+	}
+
+	if options.Vertical {
+		styleContent += fmt.Sprintf("[%s] { -epub-writing-mode: horizontal-tb; -webkit-writing-mode: horizontal-tb; writing-mode: horizontal-tb; }", util.TranslationIdKey)
+	}
+
 	return styleContent
 }
 
@@ -128,7 +164,7 @@ func stylingFile(ctx context.Context, filePath string, styleOptions StylingOptio
 		return fmt.Errorf("failed to read file %s: %w", filePath, err)
 	}
 
-	styleContent := generateStyleContent(styleOptions.Hide)
+	styleContent := generateStyleContent(styleOptions)
 	styleTag := fmt.Sprintf("<style id=\"injected-style\">\n%s\n</style>", styleContent)
 
 	newContent, err := injectOrReplaceStyle(content, styleTag)
@@ -136,7 +172,7 @@ func stylingFile(ctx context.Context, filePath string, styleOptions StylingOptio
 		return fmt.Errorf("failed to inject or replace style in %s: %w", filePath, err)
 	}
 
-	err = os.WriteFile(filePath, newContent, 0644)
+	err = writeBookFile(filePath, newContent)
 	if err != nil {
 		return fmt.Errorf("failed to write file %s: %w", filePath, err)
 	}
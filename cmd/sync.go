@@ -0,0 +1,740 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cobra"
+)
+
+// Sync replicates the translation state of a book between two epubtrans
+// instances, such as a laptop used offline and the serve instance of the team.
+// Each side describes its segments by hashes of their fields; sync compares
+// them with the hashes both sides had after the last sync, takes the fields
+// only the remote changed, sends those only the local side changed and
+// reports those both changed. Only the changed segments cross the network.
+
+const (
+	syncFieldTranslation = "translation"
+	syncFieldReview      = "review"
+)
+
+// syncBatchSize bounds the size of a request of sync, well below the default
+// --body-limit of serve.
+const syncBatchSize = 256 << 10
+
+var Sync = &cobra.Command{
+	Use:   "sync [unpackedEpubPath]",
+	Short: "Exchange translations and review verdicts with the serve instance of a book",
+	Long: `This command brings the translations of the book, and the review verdicts with their notes, in line with those of
+a serve instance of the same book, e.g. after working offline on a laptop. Fields changed on one side only are copied
+to the other; fields changed on both sides are conflicts, which are reported and left alone unless --prefer says which
+side wins. Translations are never removed, and edits are recorded in the edit history on both sides.
+
+What both sides had after the last sync with a remote is kept in <unpacked-dir>-sync.json. Segments are matched by
+file and content id, and repeats of a block by their position in the file, so both sides must have been marked from
+the same book.`,
+	Example: `epubtrans sync path/to/unpacked/epub --remote https://team.example.com/books/my-book`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runSync,
+}
+
+func init() {
+	Sync.Flags().String("remote", "", "URL of the book on the remote serve instance, with its --base-path and /books/<id> if any")
+	Sync.Flags().Bool("pull-only", false, "only take the changes of the remote")
+	Sync.Flags().Bool("push-only", false, "only send the local changes to the remote")
+	Sync.Flags().String("prefer", "", "resolve conflicts with the local or the remote version: local or remote")
+}
+
+// syncSegment is the state of a segment that sync replicates.
+type syncSegment struct {
+	ContentID string `json:"content_id"`
+	// Occurrence counts the segments with the content id before this one in
+	// its file, as repeats of a block share its content id.
+	Occurrence int `json:"occurrence,omitempty"`
+	// File is the file of the original, relative to the content directory.
+	File string `json:"file"`
+	// Translation is the markup of the translation, "" if not translated.
+	Translation string            `json:"translation,omitempty"`
+	Lang        string            `json:"lang,omitempty"`
+	Provenance  provenance        `json:"provenance"`
+	Review      *reviewAnnotation `json:"review,omitempty"`
+
+	// translationID, translationIndex and translationFile locate the
+	// translation in this book: it comes after translationIndex others with
+	// the ID in translationFile.
+	translationID    string
+	translationIndex int
+	translationFile  string
+}
+
+// key identifies the segment in the state of a book, telling apart the same
+// block in two files and its repeats in a file.
+func (s syncSegment) key() string {
+	return s.File + "#" + segmentUnitID(s.ContentID, s.Occurrence)
+}
+
+// syncHashes identify the fields of a segment, "" for a missing field.
+type syncHashes struct {
+	Translation string `json:"translation,omitempty"`
+	Review      string `json:"review,omitempty"`
+}
+
+func (s syncSegment) hashes() syncHashes {
+	h := syncHashes{Review: reviewHash(s.Review)}
+	if s.Translation != "" {
+		h.Translation = translationHash(s.Translation)
+	}
+	return h
+}
+
+func reviewHash(annotation *reviewAnnotation) string {
+	if annotation == nil {
+		return ""
+	}
+	return translationHash(annotation.Status + "\n" + annotation.Note + "\n" + annotation.Translation)
+}
+
+// syncState lists the hashes of the segments of a book by key.
+type syncState struct {
+	Segments map[string]syncHashes `json:"segments"`
+}
+
+// syncChange replaces the fields of a segment flagged by Translation and
+// Review, provided they still have the hashes of Base.
+type syncChange struct {
+	Segment     syncSegment `json:"segment"`
+	Translation bool        `json:"translation,omitempty"`
+	Review      bool        `json:"review,omitempty"`
+	Base        syncHashes  `json:"base"`
+}
+
+type syncConflict struct {
+	ContentID string `json:"content_id"`
+	// Field is translation or review, "" for the whole segment.
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type syncResult struct {
+	// Applied counts the segments changed.
+	Applied   int            `json:"applied"`
+	Conflicts []syncConflict `json:"conflicts"`
+}
+
+type SyncFetchRequest struct {
+	// ContentIDs are the keys of the segments.
+	ContentIDs []string `json:"content_ids"`
+}
+
+type SyncPushRequest struct {
+	Changes []syncChange `json:"changes"`
+}
+
+// bookSyncState reads the state of every segment of book by key.
+func bookSyncState(book *bookFiles, reviews *reviewStore) (map[string]syncSegment, error) {
+	type translation struct {
+		file, html, lang string
+		origin           provenance
+		// index counts the translations with the ID before it in file.
+		index int
+	}
+	// Repeats of a block translated alike share a translation ID; their
+	// translations are listed in the order of the book.
+	translations := map[string][]translation{}
+	linked := map[string]int{}
+	segments := map[string]syncSegment{}
+
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		inFile := map[string]int{}
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			html, _ := s.Html()
+			id := s.AttrOr(util.TranslationIdKey, "")
+			translations[id] = append(translations[id], translation{file: item.Href, html: html, lang: s.AttrOr(util.TranslationLangKey, ""), origin: provenanceOf(s), index: inFile[id]})
+			inFile[id]++
+		})
+		occurrences := map[string]int{}
+		doc.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).Each(func(i int, s *goquery.Selection) {
+			id := s.AttrOr(util.ContentIdKey, "")
+			segment := syncSegment{ContentID: id, Occurrence: occurrences[id], File: item.Href, translationID: s.AttrOr(util.TranslationByIdKey, "")}
+			occurrences[id]++
+			if segment.translationID != "" {
+				// Until the translations are read, the index counts in the book.
+				segment.translationIndex = linked[segment.translationID]
+				linked[segment.translationID]++
+			}
+			segments[segment.key()] = segment
+		})
+	}
+
+	annotations, err := reviews.bookAnnotations()
+	if err != nil {
+		return nil, err
+	}
+	for key, segment := range segments {
+		if list := translations[segment.translationID]; segment.translationID != "" && segment.translationIndex < len(list) {
+			t := list[segment.translationIndex]
+			segment.Translation, segment.Lang, segment.Provenance, segment.translationFile = t.html, t.lang, t.origin, t.file
+			segment.translationIndex = t.index
+		}
+		if annotation, ok := annotations[reviewKey(segment.File, segment.ContentID)]; ok {
+			segment.Review = &annotation
+		}
+		segments[key] = segment
+	}
+	return segments, nil
+}
+
+func stateHashes(segments map[string]syncSegment) syncState {
+	state := syncState{Segments: make(map[string]syncHashes, len(segments))}
+	for id, segment := range segments {
+		state.Segments[id] = segment.hashes()
+	}
+	return state
+}
+
+// applySyncChanges applies changes to the book. A field that no longer has
+// the base hash of its change, because it changed meanwhile, and a segment
+// locked in serve are conflicts and left as they are. locks is nil outside
+// serve.
+func applySyncChanges(unpackedEpubPath string, reviews *reviewStore, edits *editLog, locks *segmentLocks, changes []syncChange) (syncResult, error) {
+	result := syncResult{Conflicts: []syncConflict{}}
+	book, err := openBookFiles(unpackedEpubPath)
+	if err != nil {
+		return result, err
+	}
+	state, err := bookSyncState(book, reviews)
+	if err != nil {
+		return result, err
+	}
+
+	changed := map[string]bool{}
+	// Translations are written file by file.
+	byFile := map[string][]syncChange{}
+	for _, change := range changes {
+		id := change.Segment.key()
+		current, ok := state[id]
+		if !ok {
+			result.Conflicts = append(result.Conflicts, syncConflict{ContentID: id, Reason: "segment not in the book"})
+			continue
+		}
+		if locks != nil {
+			if lock, held := locks.held(current.File, current.ContentID); held {
+				result.Conflicts = append(result.Conflicts, syncConflict{ContentID: id, Reason: "segment locked by " + lock.Holder})
+				continue
+			}
+		}
+
+		if change.Review {
+			if reviewHash(current.Review) != change.Base.Review {
+				result.Conflicts = append(result.Conflicts, syncConflict{ContentID: id, Field: syncFieldReview, Reason: "changed meanwhile"})
+			} else {
+				// An empty status removes the verdict.
+				var annotation reviewAnnotation
+				if change.Segment.Review != nil {
+					annotation = *change.Segment.Review
+				}
+				if err := reviews.annotate(current.File, current.ContentID, annotation); err != nil {
+					return result, err
+				}
+				changed[id] = true
+			}
+		}
+
+		if change.Translation {
+			if change.Segment.Translation == "" {
+				result.Conflicts = append(result.Conflicts, syncConflict{ContentID: id, Field: syncFieldTranslation, Reason: "removing a translation is not synced"})
+				continue
+			}
+			file := current.translationFile
+			if file == "" {
+				file = current.File
+			}
+			byFile[file] = append(byFile[file], change)
+		}
+	}
+
+	for file, fileChanges := range byFile {
+		applied, conflicts, err := applySyncTranslations(book.contentDir, file, fileChanges, state, edits)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, id := range applied {
+			changed[id] = true
+		}
+		result.Conflicts = append(result.Conflicts, conflicts...)
+	}
+
+	result.Applied = len(changed)
+	return result, nil
+}
+
+// applySyncTranslations writes the translations of changes to file, which
+// holds their translations or, for untranslated segments, their originals.
+func applySyncTranslations(contentDir, file string, changes []syncChange, state map[string]syncSegment, edits *editLog) (applied []string, conflicts []syncConflict, err error) {
+	filePath := filepath.Join(contentDir, file)
+	fileLock := getFileLock(filePath)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	doc, err := openAndReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var logged []editEntry
+	for _, change := range changes {
+		id := change.Segment.key()
+		current := state[id]
+		var translation *goquery.Selection
+		if current.translationID != "" {
+			translation = findTranslationAt(doc, current.translationID, current.translationIndex)
+		}
+
+		if translation == nil {
+			original, _ := findSegmentAt(doc, current.ContentID, current.Occurrence)
+			if original == nil || change.Base.Translation != "" {
+				conflicts = append(conflicts, syncConflict{ContentID: id, Field: syncFieldTranslation, Reason: "changed meanwhile"})
+				continue
+			}
+			lang := change.Segment.Lang
+			if lang == "" {
+				lang = targetLanguage
+			}
+			if err := placeTranslation(doc, original, filePath, lang, change.Segment.Translation, change.Segment.Provenance); err != nil {
+				return nil, nil, err
+			}
+			applied = append(applied, id)
+			continue
+		}
+
+		old, _ := translation.Html()
+		if translationHash(old) != change.Base.Translation {
+			conflicts = append(conflicts, syncConflict{ContentID: id, Field: syncFieldTranslation, Reason: "changed meanwhile"})
+			continue
+		}
+		edit := editEntry{Time: time.Now(), TranslationID: current.translationID, Old: old, OldProvenance: provenanceOf(translation)}
+		translation.SetHtml(change.Segment.Translation)
+		change.Segment.Provenance.apply(translation)
+		if original, _ := findSegmentAt(doc, current.ContentID, current.Occurrence); original != nil {
+			stampSource(translation, original)
+		}
+		edit.New, _ = translation.Html()
+		logged = append(logged, edit)
+		applied = append(applied, id)
+	}
+
+	if len(applied) == 0 {
+		return nil, conflicts, nil
+	}
+	if err := writeContentToFile(filePath, doc); err != nil {
+		return nil, nil, err
+	}
+	for _, edit := range logged {
+		recordEdit(edits, file, edit)
+	}
+	return applied, conflicts, nil
+}
+
+// registerSyncAPI adds the endpoints sync calls on the remote instance.
+func registerSyncAPI(api fiber.Router, unpackedEpubPath string, reviews *reviewStore, edits *editLog, locks *segmentLocks) {
+	readState := func() (map[string]syncSegment, error) {
+		book, err := openBookFiles(unpackedEpubPath)
+		if err != nil {
+			return nil, err
+		}
+		return bookSyncState(book, reviews)
+	}
+
+	api.Get("/sync/state", func(c *fiber.Ctx) error {
+		segments, err := readState()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(stateHashes(segments))
+	})
+
+	api.Post("/sync/fetch", func(c *fiber.Ctx) error {
+		var req SyncFetchRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		segments, err := readState()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		found := []syncSegment{}
+		for _, id := range req.ContentIDs {
+			if segment, ok := segments[id]; ok {
+				found = append(found, segment)
+			}
+		}
+		return c.JSON(found)
+	})
+
+	api.Post("/sync/push", func(c *fiber.Ctx) error {
+		var req SyncPushRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		result, err := applySyncChanges(unpackedEpubPath, reviews, edits, locks, req.Changes)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(result)
+	})
+}
+
+// syncAction is what sync does with a field of a segment.
+type syncAction int
+
+const (
+	syncKeep syncAction = iota
+	syncPull
+	syncPush
+	syncConflicting
+)
+
+// syncDecide tells how to bring a field in line on both sides from its hash
+// on each side and after the last sync. prefer, local or remote, resolves
+// conflicts.
+func syncDecide(local, remote, base, prefer string) syncAction {
+	switch {
+	case local == remote:
+		return syncKeep
+	case local == base:
+		return syncPull
+	case remote == base:
+		return syncPush
+	case prefer == "local":
+		return syncPush
+	case prefer == "remote":
+		return syncPull
+	}
+	return syncConflicting
+}
+
+// syncBase records, per remote, the hashes of the fields both sides had
+// after the last sync, which tell which side changed a field since.
+type syncBase struct {
+	path    string
+	Remotes map[string]map[string]syncHashes `json:"remotes"`
+}
+
+func syncBasePath(unpackedEpubPath string) string {
+	return filepath.Clean(unpackedEpubPath) + "-sync.json"
+}
+
+func loadSyncBase(basePath string) (*syncBase, error) {
+	base := &syncBase{path: basePath, Remotes: map[string]map[string]syncHashes{}}
+	data, err := os.ReadFile(basePath)
+	if os.IsNotExist(err) {
+		return base, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sync state: %w", err)
+	}
+	if err := json.Unmarshal(data, base); err != nil {
+		return nil, fmt.Errorf("parsing sync state: %w", err)
+	}
+	if base.Remotes == nil {
+		base.Remotes = map[string]map[string]syncHashes{}
+	}
+	return base, nil
+}
+
+func (b *syncBase) save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling sync state: %w", err)
+	}
+	return os.WriteFile(b.path, data, 0644)
+}
+
+// agree records as the base of remote the fields on which local and remote
+// agree; the others keep their previous base.
+func (b *syncBase) agree(remote string, local, remoteState syncState) {
+	previous := b.Remotes[remote]
+	next := make(map[string]syncHashes, len(local.Segments))
+	for id, l := range local.Segments {
+		r, ok := remoteState.Segments[id]
+		if !ok {
+			continue
+		}
+		h := previous[id]
+		if l.Translation == r.Translation {
+			h.Translation = l.Translation
+		}
+		if l.Review == r.Review {
+			h.Review = l.Review
+		}
+		next[id] = h
+	}
+	b.Remotes[remote] = next
+}
+
+// syncClient calls the sync API of a remote serve instance.
+type syncClient struct {
+	// api is the URL of version 1 of the API of the book.
+	api    string
+	client *http.Client
+}
+
+func (c *syncClient) call(method, endpoint string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.api+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, endpoint, failure.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *syncClient) state() (syncState, error) {
+	var state syncState
+	err := c.call(http.MethodGet, "/sync/state", nil, &state)
+	return state, err
+}
+
+// fetch returns the segments with ids, a batch at a time.
+func (c *syncClient) fetch(ids []string) (map[string]syncSegment, error) {
+	segments := make(map[string]syncSegment, len(ids))
+	for start := 0; start < len(ids); start += 500 {
+		var batch []syncSegment
+		if err := c.call(http.MethodPost, "/sync/fetch", SyncFetchRequest{ContentIDs: ids[start:min(start+500, len(ids))]}, &batch); err != nil {
+			return nil, err
+		}
+		for _, segment := range batch {
+			segments[segment.key()] = segment
+		}
+	}
+	return segments, nil
+}
+
+// push sends changes in batches of about syncBatchSize bytes.
+func (c *syncClient) push(changes []syncChange) (syncResult, error) {
+	result := syncResult{Conflicts: []syncConflict{}}
+	for len(changes) > 0 {
+		n, size := 0, 0
+		for n < len(changes) && (n == 0 || size < syncBatchSize) {
+			size += len(changes[n].Segment.Translation) + 512
+			if changes[n].Segment.Review != nil {
+				size += len(changes[n].Segment.Review.Note)
+			}
+			n++
+		}
+
+		var batch syncResult
+		if err := c.call(http.MethodPost, "/sync/push", SyncPushRequest{Changes: changes[:n]}, &batch); err != nil {
+			return result, err
+		}
+		result.Applied += batch.Applied
+		result.Conflicts = append(result.Conflicts, batch.Conflicts...)
+		changes = changes[n:]
+	}
+	return result, nil
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	unpackedEpubPath := args[0]
+	remote, _ := cmd.Flags().GetString("remote")
+	pullOnly, _ := cmd.Flags().GetBool("pull-only")
+	pushOnly, _ := cmd.Flags().GetBool("push-only")
+	prefer, _ := cmd.Flags().GetString("prefer")
+
+	switch {
+	case remote == "":
+		return fmt.Errorf("--remote is required")
+	case pullOnly && pushOnly:
+		return fmt.Errorf("--pull-only and --push-only cannot be combined")
+	case prefer != "" && prefer != "local" && prefer != "remote":
+		return fmt.Errorf("invalid --prefer %q: use local or remote", prefer)
+	}
+	return syncWithRemote(unpackedEpubPath, strings.TrimSuffix(remote, "/"), syncOptions{pullOnly: pullOnly, pushOnly: pushOnly, prefer: prefer})
+}
+
+type syncOptions struct {
+	pullOnly, pushOnly bool
+	// prefer, local or remote, resolves conflicts.
+	prefer string
+}
+
+// syncWithRemote syncs the book with the book at the URL remote.
+func syncWithRemote(unpackedEpubPath, remote string, opts syncOptions) error {
+	client := &syncClient{api: remote + apiV1, client: &http.Client{Timeout: 5 * time.Minute}}
+
+	book, err := openBookFiles(unpackedEpubPath)
+	if err != nil {
+		return err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+	edits := newEditLog(unpackedEpubPath)
+	base, err := loadSyncBase(syncBasePath(unpackedEpubPath))
+	if err != nil {
+		return err
+	}
+
+	local, err := bookSyncState(book, reviews)
+	if err != nil {
+		return err
+	}
+	remoteState, err := client.state()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(local))
+	for id := range local {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var pulls, pushes []syncChange
+	var conflicts []syncConflict
+	missing := 0
+	for _, id := range ids {
+		r, ok := remoteState.Segments[id]
+		if !ok {
+			missing++
+			continue
+		}
+		l, b := local[id].hashes(), base.Remotes[remote][id]
+
+		pull := syncChange{Segment: syncSegment{ContentID: local[id].ContentID, Occurrence: local[id].Occurrence, File: local[id].File}, Base: l}
+		push := syncChange{Segment: local[id], Base: r}
+		for _, field := range []struct {
+			name          string
+			local, remote string
+			base          string
+			pull, push    *bool
+		}{
+			{syncFieldTranslation, l.Translation, r.Translation, b.Translation, &pull.Translation, &push.Translation},
+			{syncFieldReview, l.Review, r.Review, b.Review, &pull.Review, &push.Review},
+		} {
+			switch syncDecide(field.local, field.remote, field.base, opts.prefer) {
+			case syncPull:
+				*field.pull = !opts.pushOnly
+			case syncPush:
+				*field.push = !opts.pullOnly
+			case syncConflicting:
+				conflicts = append(conflicts, syncConflict{ContentID: id, Field: field.name, Reason: "changed on both sides"})
+			}
+		}
+
+		if pull.Translation || pull.Review {
+			pulls = append(pulls, pull)
+		}
+		if push.Translation || push.Review {
+			pushes = append(pushes, push)
+		}
+	}
+	if missing > 0 {
+		fmt.Printf("Warning: %d segments are not in the remote book; was it marked from another version?\n", missing)
+	}
+
+	var pulled, pushed syncResult
+	if len(pulls) > 0 {
+		pullIDs := make([]string, len(pulls))
+		for i, pull := range pulls {
+			pullIDs[i] = pull.Segment.key()
+		}
+		segments, err := client.fetch(pullIDs)
+		if err != nil {
+			return err
+		}
+		// Segments gone from the remote meanwhile are left for the next sync.
+		fetched := pulls[:0]
+		for _, pull := range pulls {
+			if segment, ok := segments[pull.Segment.key()]; ok {
+				pull.Segment = segment
+				fetched = append(fetched, pull)
+			}
+		}
+		if pulled, err = applySyncChanges(unpackedEpubPath, reviews, edits, nil, fetched); err != nil {
+			return err
+		}
+	}
+	if len(pushes) > 0 {
+		if pushed, err = client.push(pushes); err != nil {
+			return err
+		}
+	}
+
+	// Read both sides again, so the base only records what they agree on.
+	if local, err = bookSyncState(book, reviews); err != nil {
+		return err
+	}
+	if remoteState, err = client.state(); err != nil {
+		return err
+	}
+	base.agree(remote, stateHashes(local), remoteState)
+	if err := base.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Pulled %d segments, pushed %d\n", pulled.Applied, pushed.Applied)
+	conflicts = append(conflicts, pulled.Conflicts...)
+	conflicts = append(conflicts, pushed.Conflicts...)
+	if len(conflicts) > 0 {
+		fmt.Printf("%d conflicts, left as they are; pass --prefer local or --prefer remote to resolve them:\n", len(conflicts))
+		for _, c := range conflicts {
+			fmt.Printf("  %s %s: %s\n", c.ContentID, c.Field, c.Reason)
+		}
+	}
+	return nil
+}
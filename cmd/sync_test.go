@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testSyncChapter = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title></head><body>
+<p data-content-id="a" data-translation-by-id="ta">Hello</p><p data-translation-id="ta" data-translation-lang="de">Hallo</p>
+<p data-content-id="b">World</p>
+<p data-content-id="c" data-translation-by-id="tc">One</p><p data-translation-id="tc" data-translation-lang="de">Eins</p>
+<p data-content-id="d" data-translation-by-id="td">Two</p><p data-translation-id="td" data-translation-lang="de">Zwei</p>
+</body></html>`
+
+// writeSyncBook writes an unpacked book with one chapter of testSyncChapter.
+func writeSyncBook(t *testing.T, dir string) {
+	t.Helper()
+	writeLibraryBook(t, dir, "Sync")
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Sync</dc:title></metadata>
+  <manifest><item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "content.opf"), []byte(opf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"), []byte(testSyncChapter), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// editSyncChapter replaces old with new in the chapter of a sync book.
+func editSyncChapter(t *testing.T, dir, old, new string) {
+	t.Helper()
+	chapter := filepath.Join(dir, "OEBPS", "ch1.xhtml")
+	data, err := os.ReadFile(chapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%q not in the chapter", old)
+	}
+	if err := os.WriteFile(chapter, []byte(strings.Replace(string(data), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func syncSegments(t *testing.T, dir string) map[string]syncSegment {
+	t.Helper()
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := bookSyncState(book, reviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The book has one chapter; its segments are returned by unit id.
+	segments := make(map[string]syncSegment, len(state))
+	for _, segment := range state {
+		segments[segmentUnitID(segment.ContentID, segment.Occurrence)] = segment
+	}
+	return segments
+}
+
+func TestSyncDecide(t *testing.T) {
+	tests := []struct {
+		local, remote, base, prefer string
+		want                        syncAction
+	}{
+		{"x", "x", "", "", syncKeep},
+		{"x", "y", "x", "", syncPull},
+		{"y", "x", "x", "", syncPush},
+		{"", "x", "", "", syncPull},
+		{"y", "z", "x", "", syncConflicting},
+		{"y", "z", "x", "local", syncPush},
+		{"y", "z", "x", "remote", syncPull},
+	}
+	for _, tt := range tests {
+		if got := syncDecide(tt.local, tt.remote, tt.base, tt.prefer); got != tt.want {
+			t.Errorf("syncDecide(%q, %q, %q, %q) = %v, want %v", tt.local, tt.remote, tt.base, tt.prefer, got, tt.want)
+		}
+	}
+}
+
+func TestSyncWithRemote(t *testing.T) {
+	root := t.TempDir()
+	laptop, server := filepath.Join(root, "laptop"), filepath.Join(root, "server")
+	writeSyncBook(t, laptop)
+	writeSyncBook(t, server)
+
+	serverReviews, err := loadReviewStore(reviewStorePath(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	registerSyncAPI(app.Group(apiV1), server, serverReviews, newEditLog(server), newSegmentLocks())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+	remote := "http://" + ln.Addr().String()
+
+	// The first sync records what both sides have.
+	if err := syncWithRemote(laptop, remote, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	editSyncChapter(t, laptop, ">Hallo<", ">Hallo!<")
+	editSyncChapter(t, laptop, `<p data-content-id="b">World</p>`, `<p data-content-id="b" data-translation-by-id="tb">World</p><p data-translation-id="tb" data-translation-lang="de">Welt</p>`)
+	editSyncChapter(t, laptop, ">Zwei<", ">Zwo<")
+	editSyncChapter(t, server, ">Zwei<", ">Zweitens<")
+	approved := reviewAnnotation{File: "ch1.xhtml", Status: reviewApproved, Note: "good", Translation: translationHash("Eins")}
+	if err := serverReviews.annotate("ch1.xhtml", "c", approved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syncWithRemote(laptop, remote, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	onServer, onLaptop := syncSegments(t, server), syncSegments(t, laptop)
+	if onServer["a"].Translation != "Hallo!" || onServer["b"].Translation != "Welt" {
+		t.Errorf("server translations = %q, %q; want the edits of the laptop", onServer["a"].Translation, onServer["b"].Translation)
+	}
+	if onLaptop["c"].Review == nil || onLaptop["c"].Review.Note != "good" {
+		t.Errorf("laptop review of c = %+v, want the verdict of the server", onLaptop["c"].Review)
+	}
+	// Changed on both sides: a conflict, left alone.
+	if onServer["d"].Translation != "Zweitens" || onLaptop["d"].Translation != "Zwo" {
+		t.Errorf("conflicting translations = %q on the server, %q on the laptop", onServer["d"].Translation, onLaptop["d"].Translation)
+	}
+	if entries, _ := newEditLog(server).entries("ch1.xhtml"); len(entries) != 1 || entries[0].New != "Hallo!" {
+		t.Errorf("edits on the server = %+v, want the edit of a", entries)
+	}
+
+	if err := syncWithRemote(laptop, remote, syncOptions{prefer: "remote"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := syncSegments(t, laptop)["d"].Translation; got != "Zweitens" {
+		t.Errorf("laptop translation of d = %q after preferring the remote", got)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// A translate run can be slowed down with --throttle to run in the
+// background of a workstation: fewer requests a minute, fewer at once, and
+// fewer CPUs for the work on the chapters. --max-concurrency and --workers,
+// when given, still win over the preset.
+
+// Throttle presets.
+const (
+	throttleOff        = "off"
+	throttleLow        = "low"
+	throttleBackground = "background"
+)
+
+// throttleSettings is the pace of a run.
+type throttleSettings struct {
+	// requestsPerMinute and burst set the request rate limiter.
+	requestsPerMinute int
+	burst             int
+	// concurrency bounds the requests and chapters at once; zero keeps the
+	// flags.
+	concurrency int
+	// cpus bounds the CPUs used; zero uses all of them.
+	cpus int
+}
+
+var (
+	// throttle is the throttle preset of a translate run.
+	throttle = throttleOff
+	// runThrottle is the pace of the current translate run.
+	runThrottle = throttleSettings{requestsPerMinute: 50, burst: 10}
+)
+
+func init() {
+	Translate.Flags().StringVar(&throttle, "throttle", throttleOff, "slow the run down to keep the machine and the API quota usable: off, low or background")
+}
+
+// throttleFor returns the settings of a throttle preset.
+func throttleFor(preset string) (throttleSettings, error) {
+	switch strings.ToLower(preset) {
+	case throttleOff, "":
+		return throttleSettings{requestsPerMinute: 50, burst: 10}, nil
+	case throttleLow:
+		return throttleSettings{requestsPerMinute: 20, burst: 2, concurrency: 2, cpus: max(runtime.NumCPU()/2, 1)}, nil
+	case throttleBackground:
+		return throttleSettings{requestsPerMinute: 6, burst: 1, concurrency: 1, cpus: 1}, nil
+	default:
+		return throttleSettings{}, fmt.Errorf("unknown throttle %q: use off, low or background", preset)
+	}
+}
+
+// applyThrottle sets the pace of the translate run of cmd from --throttle.
+func applyThrottle(cmd *cobra.Command) error {
+	settings, err := throttleFor(throttle)
+	if err != nil {
+		return err
+	}
+	runThrottle = settings
+
+	if settings.concurrency > 0 {
+		if !cmd.Flags().Changed("max-concurrency") {
+			maxConcurrency = settings.concurrency
+		}
+		if !cmd.Flags().Changed("workers") {
+			translateWorkers = settings.concurrency
+		}
+	}
+	if settings.cpus > 0 {
+		runtime.GOMAXPROCS(settings.cpus)
+	}
+
+	if settings.concurrency > 0 {
+		fmt.Printf("Throttled to %d requests a minute, %d at once, on %d CPUs\n", settings.requestsPerMinute, maxConcurrency, settings.cpus)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyThrottle(t *testing.T) {
+	defer func(procs, concurrency, workers int, preset string, settings throttleSettings) {
+		runtime.GOMAXPROCS(procs)
+		maxConcurrency, translateWorkers, throttle, runThrottle = concurrency, workers, preset, settings
+	}(runtime.GOMAXPROCS(0), maxConcurrency, translateWorkers, throttle, runThrottle)
+
+	cmd := &cobra.Command{}
+	cmd.Flags().IntVar(&maxConcurrency, "max-concurrency", 4, "")
+	cmd.Flags().IntVar(&translateWorkers, "workers", 0, "")
+	if err := cmd.Flags().Set("workers", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	throttle = throttleBackground
+	if err := applyThrottle(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if runThrottle.requestsPerMinute != 6 || maxConcurrency != 1 || runtime.GOMAXPROCS(0) != 1 {
+		t.Errorf("background: %+v, max concurrency %d, %d CPUs", runThrottle, maxConcurrency, runtime.GOMAXPROCS(0))
+	}
+	if translateWorkers != 3 {
+		t.Errorf("workers = %d, want the flag to win over the preset", translateWorkers)
+	}
+
+	throttle = "slow"
+	if err := applyThrottle(cmd); err == nil {
+		t.Error("unknown throttle accepted")
+	}
+}
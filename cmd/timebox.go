@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A translate run can be time-boxed with --max-duration, e.g. to fit the
+// window of a spot instance or a night tariff. When the time is up no further
+// batch is sent; the batches on their way are finished and written, and the
+// progress records the run as paused, so --resume continues it.
+
+// maxDuration is how long a translate run may take; zero is unlimited.
+var maxDuration time.Duration
+
+// errRunPaused is the cause of the cancellation of a run paused at its time
+// limit.
+var errRunPaused = errors.New("time limit reached")
+
+func init() {
+	Translate.Flags().DurationVar(&maxDuration, "max-duration", 0, "pause the run after this long, e.g. 2h; batches already sent are finished and --resume continues the run")
+}
+
+type requestContextKey struct{}
+
+// timeBox returns a context for the work of a run that is canceled with
+// errRunPaused after limit, and a function releasing its timer. A zero limit
+// never pauses. The requests of batches keep running on ctx, see
+// requestContext.
+func timeBox(ctx context.Context, limit time.Duration) (context.Context, func()) {
+	if limit <= 0 {
+		return ctx, func() {}
+	}
+
+	work, pause := context.WithCancelCause(context.WithValue(ctx, requestContextKey{}, ctx))
+	timer := time.AfterFunc(limit, func() {
+		fmt.Printf("\nTime limit of %s reached, pausing after the batches in progress...\n", limit)
+		jobLog.Info("time limit reached", "max_duration", limit.String())
+		pause(errRunPaused)
+	})
+	return work, func() {
+		timer.Stop()
+		pause(nil)
+	}
+}
+
+// requestContext returns the context to send the requests of a batch in,
+// which outlives a pause of the run but not an interrupt.
+func requestContext(ctx context.Context) context.Context {
+	if parent, ok := ctx.Value(requestContextKey{}).(context.Context); ok {
+		return parent
+	}
+	return ctx
+}
+
+// isPaused reports whether the run of ctx was paused at its time limit.
+func isPaused(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRunPaused)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeBox(t *testing.T) {
+	interrupt, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	work, stop := timeBox(interrupt, 10*time.Millisecond)
+	defer stop()
+	select {
+	case <-work.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run was not paused at its time limit")
+	}
+	if !isPaused(work) {
+		t.Errorf("cause = %v, want the time limit", context.Cause(work))
+	}
+
+	// Batches already sent go on until the run is interrupted.
+	requests := requestContext(work)
+	if requests.Err() != nil {
+		t.Fatal("the requests were canceled by the pause")
+	}
+	cancel()
+	if requests.Err() == nil {
+		t.Error("the requests outlived the interrupt")
+	}
+
+	unlimited, stop := timeBox(context.Background(), 0)
+	defer stop()
+	if unlimited.Done() != nil || requestContext(unlimited) != unlimited {
+		t.Error("a run without a time limit is time-boxed")
+	}
+}
+
+func TestProgressPaused(t *testing.T) {
+	book := filepath.Join(t.TempDir(), "book")
+	tracker, err := newProgressTracker(book, runProgress{Source: "English", Target: "Vietnamese"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.finish(nil, errRunPaused)
+
+	previous, err := loadProgress(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous.Status != progressPaused {
+		t.Errorf("run status = %q, want %q", previous.Status, progressPaused)
+	}
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serveTLS configures HTTPS for serve, so the editor can be used from other
+// devices without sending edits and API keys in the clear.
+var serveTLS = struct {
+	certFile   string
+	keyFile    string
+	selfSigned bool
+}{}
+
+const (
+	// selfSignedValidity is how long a generated certificate is valid; it is
+	// generated again when less than selfSignedRenewal is left.
+	selfSignedValidity = 365 * 24 * time.Hour
+	selfSignedRenewal  = 30 * 24 * time.Hour
+)
+
+func init() {
+	Serve.Flags().StringVar(&serveTLS.certFile, "tls-cert", "", "PEM certificate file to serve HTTPS with; requires --tls-key")
+	Serve.Flags().StringVar(&serveTLS.keyFile, "tls-key", "", "PEM private key file of --tls-cert")
+	Serve.Flags().BoolVar(&serveTLS.selfSigned, "tls-self-signed", false, "serve HTTPS with a self-signed certificate stored next to the book, generated if needed")
+}
+
+// serveScheme returns the scheme serve is reached with.
+func serveScheme() string {
+	if serveTLS.certFile != "" || serveTLS.selfSigned {
+		return "https"
+	}
+	return "http"
+}
+
+// checkServeTLS validates the TLS flags before the server is set up.
+func checkServeTLS() error {
+	switch {
+	case (serveTLS.certFile == "") != (serveTLS.keyFile == ""):
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	case serveTLS.certFile != "" && serveTLS.selfSigned:
+		return fmt.Errorf("--tls-self-signed cannot be combined with --tls-cert")
+	case strings.HasPrefix(serveHost, unixSocketPrefix) && serveScheme() == "https":
+		return fmt.Errorf("HTTPS is not served on a Unix socket; let the reverse proxy terminate TLS")
+	}
+	return nil
+}
+
+// listenServe serves app on addr over HTTP, or HTTPS as configured by the
+// TLS flags. An addr of unix:<path> is a Unix socket.
+func listenServe(app *fiber.App, addr, unpackedEpubPath string) error {
+	switch {
+	case strings.HasPrefix(addr, unixSocketPrefix):
+		return listenUnixSocket(app, strings.TrimPrefix(addr, unixSocketPrefix))
+	case serveTLS.certFile != "":
+		return app.ListenTLS(addr, serveTLS.certFile, serveTLS.keyFile)
+	case serveTLS.selfSigned:
+		cert, err := selfSignedCertificate(filepath.Clean(unpackedEpubPath)+"-tls-cert.pem", filepath.Clean(unpackedEpubPath)+"-tls-key.pem")
+		if err != nil {
+			return fmt.Errorf("preparing the self-signed certificate: %w", err)
+		}
+		return app.ListenTLSWithCertificate(addr, cert)
+	default:
+		return app.Listen(addr)
+	}
+}
+
+// selfSignedCertificate loads the certificate at certPath and keyPath, or
+// generates one when it is missing or about to expire. It is kept so
+// browsers that were told to trust it keep doing so across restarts.
+func selfSignedCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil && cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err == nil && time.Until(cert.Leaf.NotAfter) > selfSignedRenewal {
+		logCertificate(cert, certPath, false)
+		return cert, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Generating a new self-signed certificate", "reason", err)
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCertificate(time.Now())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	logCertificate(cert, certPath, true)
+	return cert, nil
+}
+
+// generateSelfSignedCertificate returns a certificate for localhost, the host
+// name and the addresses of the machine, valid from now.
+func generateSelfSignedCertificate(now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"epubtrans"}, CommonName: "epubtrans serve"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// logCertificate prints the fingerprint browsers show for the certificate,
+// to compare before trusting it on another device.
+func logCertificate(cert tls.Certificate, certPath string, generated bool) {
+	sum := sha256.Sum256(cert.Certificate[0])
+	verb := "Using"
+	if generated {
+		verb = "Generated"
+	}
+	slog.Info(fmt.Sprintf("%s self-signed certificate %s", verb, certPath), "sha256", hex.EncodeToString(sum[:]))
+}
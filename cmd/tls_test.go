@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "book-tls-cert.pem"), filepath.Join(dir, "book-tls-key.pem")
+
+	generated, err := selfSignedCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generated.Leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate not valid for localhost: %v", err)
+	}
+
+	reused, err := selfSignedCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Certificate[0], reused.Certificate[0]) {
+		t.Error("the stored certificate was not reused")
+	}
+}
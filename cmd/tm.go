@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/memory"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var ExportTM = &cobra.Command{
+	Use:   "export-tm [unpackedEpubPath] [output.tmx]",
+	Short: "Export the translated segments of a book as a translation memory",
+	Long: `This command collects every original segment of the book together with its translation, including manual
+edits made in serve, and writes them as a translation memory. A .tmx output is a TMX 1.4b file for CAT tools; any other
+extension writes the JSON format of series projects and --memory.`,
+	Example: `epubtrans export-tm path/to/unpacked/epub book.tmx --source English`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and an output file are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runExportTM,
+}
+
+var ImportTM = &cobra.Command{
+	Use:   "import-tm [input.tmx] [memory]",
+	Short: "Merge a TMX file into a translation memory",
+	Long: `This command adds the segments of a TMX file, e.g. one exported from a CAT tool, to a translation memory file
+(.json or .tmx) used by series projects or translate --memory. Entries for the same source text are replaced.
+Language codes are stored as the names --source and --target use, e.g. "vi-VN" as "Vietnamese".`,
+	Example: `epubtrans import-tm reviewed.tmx series/memory.json`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    runImportTM,
+}
+
+func init() {
+	ExportTM.Flags().String("source", "English", "language of the original text")
+}
+
+func runExportTM(cmd *cobra.Command, args []string) error {
+	source, _ := cmd.Flags().GetString("source")
+
+	entries, err := collectSegmentPairs(args[0], source)
+	if err != nil {
+		return err
+	}
+
+	m := memory.New()
+	for _, e := range entries {
+		m.Add(e.Source, e.Target, e.SourceLanguage, e.TargetLanguage)
+	}
+
+	tm.CreationToolVersion = Upgrade.Version
+	if err := tm.Save(args[1], m); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d segments to %s\n", m.Len(), args[1])
+	return nil
+}
+
+func runImportTM(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := tm.Import(f)
+	if err != nil {
+		return err
+	}
+
+	m, err := tm.Load(args[1])
+	if err != nil {
+		return err
+	}
+	before := m.Len()
+	for _, e := range entries {
+		m.Add(e.Source, e.Target, e.SourceLanguage, e.TargetLanguage)
+	}
+
+	tm.CreationToolVersion = Upgrade.Version
+	if err := tm.Save(args[1], m); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d segments into %s (%d new)\n", len(entries), args[1], m.Len()-before)
+	return nil
+}
+
+// collectSegmentPairs returns the original and translated markup of every
+// translated segment. Translations may live in another document than their
+// original (endnotes), so all XHTML documents of the manifest are read.
+func collectSegmentPairs(unzipPath, sourceLang string) ([]memory.Entry, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	type translation struct {
+		html string
+		lang string
+	}
+	translations := map[string]translation{}
+	var originals []*goquery.Selection
+
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		doc, err := openAndReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+
+		doc.Find(fmt.Sprintf("[%s]", util.TranslationIdKey)).Each(func(i int, s *goquery.Selection) {
+			html, _ := s.Html()
+			translations[s.AttrOr(util.TranslationIdKey, "")] = translation{html: html, lang: s.AttrOr(util.TranslationLangKey, "")}
+		})
+		doc.Find(fmt.Sprintf("[%s][%s]", util.ContentIdKey, util.TranslationByIdKey)).Each(func(i int, s *goquery.Selection) {
+			originals = append(originals, s)
+		})
+	}
+
+	var entries []memory.Entry
+	for _, s := range originals {
+		t, ok := translations[s.AttrOr(util.TranslationByIdKey, "")]
+		if !ok {
+			continue
+		}
+
+		original := s.Clone()
+		original.Find("a.epubtrans-noteref").Remove()
+		html, _ := original.Html()
+
+		entries = append(entries, memory.Entry{
+			Source:         strings.TrimSpace(html),
+			Target:         strings.TrimSpace(t.html),
+			SourceLanguage: sourceLang,
+			TargetLanguage: t.lang,
+		})
+	}
+
+	return entries, nil
+}
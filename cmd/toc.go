@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+const (
+	bilingualTOCID       = "epubtrans-bilingual-toc"
+	bilingualTOCFileName = "bilingual-toc.xhtml"
+)
+
+// tocEntry is a navigation point paired with the translation of its title.
+type tocEntry struct {
+	Src        string
+	Original   string
+	Translated string
+	Level      int
+}
+
+// generateBilingualTOC returns a table of contents page showing original and
+// translated chapter titles side by side, and the package document with the
+// page put at the front of the spine, by path. The unpacked book is left as it
+// is; pack puts the returned files in the package instead. A table of contents
+// file or package document in replaced is read from there rather than from
+// the book.
+func generateBilingualTOC(unzipPath string, replaced map[string][]byte) (map[string][]byte, error) {
+	container, err := loader.ParseContainer(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	opfPath := filepath.Join(unzipPath, container.Rootfile.FullPath)
+	pkg, err := loader.ParsePackage(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing package: %v", err)
+	}
+
+	contentDir := filepath.Dir(opfPath)
+	navPoints, tocHref, err := bookNavPoints(contentDir, pkg, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	tocDir := filepath.Dir(filepath.Join(contentDir, tocHref))
+	docs := make(map[string]*goquery.Document)
+	entries := collectTOCEntries(navPoints, 0, tocDir, docs)
+
+	pagePath := filepath.Join(tocDir, bilingualTOCFileName)
+	href, err := filepath.Rel(contentDir, pagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	opf, ok := replaced[opfPath]
+	if !ok {
+		if opf, err = os.ReadFile(opfPath); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", opfPath, err)
+		}
+	}
+	opf, err = addFrontMatterItem(opfPath, opf, bilingualTOCID, filepath.ToSlash(href))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		pagePath: []byte(renderBilingualTOC(entries)),
+		opfPath:  opf,
+	}, nil
+}
+
+// bookNavPoints returns the table of contents of the book, read from its
+// EPUB 3 nav document or, for books without one, from its NCX, along with the
+// href of the file it was read from; the src of every point is relative to
+// that file. A file in replaced is read from there rather than from disk.
+func bookNavPoints(contentDir string, pkg *loader.Package, replaced map[string][]byte) ([]NavPoint, string, error) {
+	for _, item := range pkg.Manifest.Items {
+		if !strings.Contains(" "+item.Properties+" ", " nav ") {
+			continue
+		}
+
+		navPath := filepath.Join(contentDir, item.Href)
+		var doc *goquery.Document
+		var err error
+		if content, ok := replaced[navPath]; ok {
+			doc, err = goquery.NewDocumentFromReader(bytes.NewReader(content))
+		} else {
+			doc, err = openAndReadFile(navPath)
+		}
+		if err != nil {
+			continue
+		}
+		toc := doc.Find("nav").FilterFunction(func(i int, s *goquery.Selection) bool {
+			return strings.Contains(" "+s.AttrOr("epub:type", "")+" ", " toc ")
+		}).First()
+		if navPoints := navListPoints(toc.ChildrenFiltered("ol").First()); len(navPoints) > 0 {
+			return navPoints, item.Href, nil
+		}
+	}
+
+	tocItem := pkg.Manifest.GetItemByID(pkg.Spine.Toc)
+	if tocItem == nil {
+		return nil, "", fmt.Errorf("the book has no table of contents: neither a nav document nor an NCX")
+	}
+
+	ncxPath := filepath.Join(contentDir, tocItem.Href)
+	tocContent, ok := replaced[ncxPath]
+	if !ok {
+		var err error
+		if tocContent, err = os.ReadFile(ncxPath); err != nil {
+			return nil, "", fmt.Errorf("error reading %s: %w", ncxPath, err)
+		}
+	}
+
+	var ncx NCX
+	if err := xml.Unmarshal(tocContent, &ncx); err != nil {
+		return nil, "", fmt.Errorf("error parsing %s: %w", ncxPath, err)
+	}
+
+	return ncx.NavMap.NavPoints, tocItem.Href, nil
+}
+
+// navListPoints converts the entries of an ol of a nav document to
+// navigation points. Headings of a group of entries are a span, without a
+// src.
+func navListPoints(ol *goquery.Selection) []NavPoint {
+	var navPoints []NavPoint
+
+	ol.ChildrenFiltered("li").Each(func(i int, li *goquery.Selection) {
+		label := li.ChildrenFiltered("a, span").First()
+		navPoints = append(navPoints, NavPoint{
+			NavLabel:  NavLabel{Text: strings.TrimSpace(whitespaceRegex.ReplaceAllString(label.Text(), " "))},
+			Content:   Content{Src: label.AttrOr("href", "")},
+			NavPoints: navListPoints(li.ChildrenFiltered("ol").First()),
+		})
+	})
+
+	return navPoints
+}
+
+// tocLink returns the URL serve has the target src of a navigation point at,
+// src being relative to the table of contents file tocHref.
+func tocLink(tocHref, src string) string {
+	if u, err := url.Parse(src); err != nil || u.IsAbs() {
+		return src
+	}
+
+	file, fragment, hasFragment := strings.Cut(src, "#")
+	link := "/" + tocHref
+	if file != "" {
+		link = "/" + path.Join(path.Dir(tocHref), file)
+	}
+	if hasFragment {
+		link += "#" + fragment
+	}
+	return link
+}
+
+func collectTOCEntries(navPoints []NavPoint, level int, baseDir string, docs map[string]*goquery.Document) []tocEntry {
+	var entries []tocEntry
+
+	for _, np := range navPoints {
+		label := strings.TrimSpace(np.NavLabel.Text)
+		entry := tocEntry{
+			Src:      np.Content.Src,
+			Original: label,
+			Level:    level,
+		}
+
+		file, fragment, _ := strings.Cut(np.Content.Src, "#")
+		if doc := loadTOCDocument(filepath.Join(baseDir, file), docs); doc != nil {
+			entry.Translated = findTranslatedTitle(doc, fragment, label)
+		}
+
+		entries = append(entries, entry)
+		entries = append(entries, collectTOCEntries(np.NavPoints, level+1, baseDir, docs)...)
+	}
+
+	return entries
+}
+
+func loadTOCDocument(filePath string, docs map[string]*goquery.Document) *goquery.Document {
+	if doc, ok := docs[filePath]; ok {
+		return doc
+	}
+
+	doc, err := openAndReadFile(filePath)
+	if err != nil {
+		doc = nil
+	}
+
+	docs[filePath] = doc
+	return doc
+}
+
+var whitespaceRegex = regexp.MustCompile(`\s+`)
+
+func normalizeTitle(s string) string {
+	return strings.ToLower(whitespaceRegex.ReplaceAllString(strings.TrimSpace(s), " "))
+}
+
+// findTranslatedTitle looks for the marked element holding the chapter title and
+// returns the text of its translation. It falls back to the first translated heading.
+func findTranslatedTitle(doc *goquery.Document, fragment, label string) string {
+	scope := doc.Selection
+	if fragment != "" {
+		if target := doc.Find("#" + fragment); target.Length() > 0 {
+			scope = target.Parent()
+		}
+	}
+
+	translationOf := func(s *goquery.Selection) string {
+		id, ok := s.Attr(util.TranslationByIdKey)
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(doc.Find(fmt.Sprintf("[%s=%q]", util.TranslationIdKey, id)).First().Text())
+	}
+
+	want := normalizeTitle(label)
+	var title string
+	scope.Find(fmt.Sprintf("[%s]", util.ContentIdKey)).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if normalizeTitle(s.Text()) == want {
+			title = translationOf(s)
+			return false
+		}
+		return true
+	})
+
+	if title == "" {
+		title = translationOf(doc.Find(fmt.Sprintf("h1[%[1]s], h2[%[1]s], h3[%[1]s]", util.TranslationByIdKey)).First())
+	}
+
+	return title
+}
+
+func renderBilingualTOC(entries []tocEntry) string {
+	var rows strings.Builder
+	for _, e := range entries {
+		indent := fmt.Sprintf(` style="padding-left: %.1fem"`, float64(e.Level)*1.5)
+		rows.WriteString(fmt.Sprintf("<tr><td%s><a href=\"%s\">%s</a></td><td%s><a href=\"%s\">%s</a></td></tr>\n",
+			indent, html.EscapeString(e.Src), html.EscapeString(e.Original),
+			indent, html.EscapeString(e.Src), html.EscapeString(e.Translated)))
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<meta charset="utf-8"/>
+<title>Table of Contents</title>
+<style>
+table { width: 100%%; border-collapse: collapse; }
+td { vertical-align: top; padding: 0.2em 0.5em; width: 50%%; }
+a { text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Table of Contents</h1>
+<table>
+%s</table>
+</body>
+</html>
+`, rows.String())
+}
+
+var (
+	manifestCloseRegex = regexp.MustCompile(`</(?:opf:)?manifest>`)
+	spineOpenRegex     = regexp.MustCompile(`<(?:opf:)?spine\b[^>]*>`)
+)
+
+// addFrontMatterItem returns the package document at opfPath, of content opf,
+// with href registered in the manifest and placed first in the spine. The
+// package document is edited textually to keep its formatting; an item that is
+// already registered is left untouched.
+func addFrontMatterItem(opfPath string, content []byte, id, href string) ([]byte, error) {
+	opf := string(content)
+	if strings.Contains(opf, fmt.Sprintf(`id="%s"`, id)) {
+		return content, nil
+	}
+
+	loc := manifestCloseRegex.FindStringIndex(opf)
+	if loc == nil {
+		return nil, fmt.Errorf("no manifest found in %s", opfPath)
+	}
+	item := fmt.Sprintf(`<item id="%s" href="%s" media-type="application/xhtml+xml"/>`+"\n", id, html.EscapeString(href))
+	opf = opf[:loc[0]] + item + opf[loc[0]:]
+
+	loc = spineOpenRegex.FindStringIndex(opf)
+	if loc == nil {
+		return nil, fmt.Errorf("no spine found in %s", opfPath)
+	}
+	itemRef := fmt.Sprintf("\n"+`<itemref idref="%s"/>`, id)
+	opf = opf[:loc[1]] + itemRef + opf[loc[1]:]
+
+	return []byte(opf), nil
+}
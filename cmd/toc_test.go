@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+const testNavDocument = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body>
+<nav epub:type="landmarks"><ol><li><a href="../Text/cover.xhtml">Cover</a></li></ol></nav>
+<nav epub:type="toc" id="toc">
+  <h1>Contents</h1>
+  <ol>
+    <li><a href="../Text/ch1.xhtml">Chapter
+      One</a></li>
+    <li><span>Part Two</span>
+      <ol>
+        <li><a href="../Text/ch2.xhtml#s1">Chapter Two</a></li>
+      </ol>
+    </li>
+  </ol>
+</nav>
+</body>
+</html>`
+
+const testNCX = `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="n1" playOrder="1"><navLabel><text>From the NCX</text></navLabel><content src="Text/ch1.xhtml"/></navPoint>
+  </navMap>
+</ncx>`
+
+func TestBookNavPoints(t *testing.T) {
+	contentDir := t.TempDir()
+	for name, content := range map[string]string{"Nav/nav.xhtml": testNavDocument, "toc.ncx": testNCX} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(contentDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(contentDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	navItem := loader.Item{ID: "nav", Href: "Nav/nav.xhtml", MediaType: "application/xhtml+xml", Properties: "nav"}
+	ncxItem := loader.Item{ID: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"}
+
+	t.Run("nav document", func(t *testing.T) {
+		pkg := &loader.Package{}
+		pkg.Manifest.Items = []loader.Item{navItem, ncxItem}
+		pkg.Spine.Toc = "ncx"
+
+		navPoints, tocHref, err := bookNavPoints(contentDir, pkg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []NavPoint{
+			{NavLabel: NavLabel{Text: "Chapter One"}, Content: Content{Src: "../Text/ch1.xhtml"}},
+			{NavLabel: NavLabel{Text: "Part Two"}, NavPoints: []NavPoint{
+				{NavLabel: NavLabel{Text: "Chapter Two"}, Content: Content{Src: "../Text/ch2.xhtml#s1"}},
+			}},
+		}
+		if tocHref != "Nav/nav.xhtml" || !reflect.DeepEqual(navPoints, want) {
+			t.Errorf("bookNavPoints() = %+v, %q", navPoints, tocHref)
+		}
+	})
+
+	t.Run("NCX only", func(t *testing.T) {
+		pkg := &loader.Package{}
+		pkg.Manifest.Items = []loader.Item{ncxItem}
+		pkg.Spine.Toc = "ncx"
+
+		navPoints, tocHref, err := bookNavPoints(contentDir, pkg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tocHref != "toc.ncx" || len(navPoints) != 1 || navPoints[0].NavLabel.Text != "From the NCX" {
+			t.Errorf("bookNavPoints() = %+v, %q", navPoints, tocHref)
+		}
+	})
+
+	t.Run("no table of contents", func(t *testing.T) {
+		if _, _, err := bookNavPoints(contentDir, &loader.Package{}, nil); err == nil {
+			t.Error("bookNavPoints() succeeded without a table of contents")
+		}
+	})
+}
+
+func TestTOCLink(t *testing.T) {
+	tests := []struct {
+		tocHref, src, want string
+	}{
+		{"toc.ncx", "Text/ch1.xhtml", "/Text/ch1.xhtml"},
+		{"Nav/nav.xhtml", "../Text/ch2.xhtml#s1", "/Text/ch2.xhtml#s1"},
+		{"Nav/nav.xhtml", "#toc", "/Nav/nav.xhtml#toc"},
+		{"Nav/nav.xhtml", "https://example.com/", "https://example.com/"},
+	}
+
+	for _, tt := range tests {
+		if got := tocLink(tt.tocHref, tt.src); got != tt.want {
+			t.Errorf("tocLink(%q, %q) = %q, want %q", tt.tocHref, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestHeadingTOCTitles(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Headings")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Headings</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="Text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch3" href="Text/ch3.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx"><itemref idref="cover"/><itemref idref="ch1"/><itemref idref="ch2"/><itemref idref="ch3"/></spine>
+</package>`,
+		"nav.xhtml": `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol>
+  <li><a href="Text/ch1.xhtml">Chapter 1</a></li>
+  <li><a href="Text/ch2.xhtml#s2"></a></li>
+  <li><a href="Text/ch2.xhtml">The Sea</a></li>
+</ol></nav>
+<nav epub:type="landmarks"><ol><li><a href="Text/ch1.xhtml">1</a></li></ol></nav>
+</body></html>`,
+		"toc.ncx": `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="n1" playOrder="1"><navLabel><text>CHAPTER I</text></navLabel><content src="Text/ch1.xhtml"/></navPoint>
+  </navMap>
+</ncx>`,
+		"Text/ch1.xhtml": `<html><body>
+<h1 data-content-id="h" data-translation-by-id="th">The Storm</h1>
+<h1 data-translation-id="th">Der Sturm &amp; Drang</h1>
+</body></html>`,
+		"Text/ch2.xhtml":   `<html><body><h1>The Sea</h1><section id="s2"><h2>The Shore</h2></section></body></html>`,
+		"Text/ch3.xhtml":   `<html><body><h1>The Harbour</h1></body></html>`,
+		"Text/cover.xhtml": `<html><body><img src="cover.jpg" alt=""/></body></html>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "OEBPS", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replaced, titled, added, err := headingTOCTitles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titled != 3 || added != 3 {
+		t.Errorf("titled %d entries and added %d, want 3 and 3", titled, added)
+	}
+
+	// The book itself keeps its table of contents.
+	navPath := filepath.Join(dir, "OEBPS", "nav.xhtml")
+	ncxPath := filepath.Join(dir, "OEBPS", "toc.ncx")
+	for path, name := range map[string]string{navPath: "nav.xhtml", ncxPath: "toc.ncx"} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != files[name] {
+			t.Errorf("%s was changed on disk: %s", name, content)
+		}
+	}
+
+	nav := string(replaced[navPath])
+	for _, want := range []string{
+		`<a href="Text/ch1.xhtml">Der Sturm &amp; Drang</a>`,
+		`<a href="Text/ch2.xhtml#s2">The Shore</a>`,
+		`<li><a href="Text/ch2.xhtml">The Sea</a></li>
+  <li><a href="Text/ch3.xhtml">The Harbour</a></li>
+</ol>`,
+		`<a href="Text/ch1.xhtml">1</a>`,
+	} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("nav document lacks %s:\n%s", want, nav)
+		}
+	}
+	if strings.Contains(nav, "cover.xhtml") {
+		t.Errorf("nav document has an entry for the cover, which has no heading:\n%s", nav)
+	}
+
+	ncx := string(replaced[ncxPath])
+	for _, want := range []string{
+		`<navPoint id="n1" playOrder="1"><navLabel><text>Der Sturm &amp; Drang</text>`,
+		`<navPoint id="epubtrans-toc-ch2" playOrder="2"><navLabel><text>The Sea</text></navLabel><content src="Text/ch2.xhtml"/></navPoint>`,
+		`<navPoint id="epubtrans-toc-ch3" playOrder="3"><navLabel><text>The Harbour</text></navLabel><content src="Text/ch3.xhtml"/></navPoint>`,
+	} {
+		if !strings.Contains(ncx, want) {
+			t.Errorf("NCX lacks %s:\n%s", want, ncx)
+		}
+	}
+
+	// The bilingual page lists the entries of the packed table of contents.
+	navPoints, _, err := bookNavPoints(filepath.Join(dir, "OEBPS"), &loader.Package{Manifest: loader.Manifest{Items: []loader.Item{{ID: "nav", Href: "nav.xhtml", Properties: "nav"}}}}, replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(navPoints) != 4 || navPoints[3].NavLabel.Text != "The Harbour" {
+		t.Errorf("bookNavPoints() = %+v, want the added entry last", navPoints)
+	}
+}
+
+func TestIsGenericTitle(t *testing.T) {
+	for title, want := range map[string]bool{
+		"":                    true,
+		"  ":                  true,
+		"Chapter 12":          true,
+		"chapter xiv.":        true,
+		"<span>Part 2</span>": true,
+		"7":                   true,
+		"Chapter One":         false,
+		"Civil":               false,
+		"The 39 Steps":        false,
+	} {
+		if got := isGenericTitle(title); got != want {
+			t.Errorf("isGenericTitle(%q) = %v, want %v", title, got, want)
+		}
+	}
+}
+
+func TestPackBilingualTOC(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	writeLibraryBook(t, dir, "Contents")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Contents</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="Nav/nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`,
+		"Nav/nav.xhtml":  testNavDocument,
+		"Text/ch1.xhtml": `<html><body><h1>Chapter One</h1></body></html>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "OEBPS", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replaced, err := generateBilingualTOC(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	if err := packFiles(dir, epubPath, nil, nil, replaced); err != nil {
+		t.Fatal(err)
+	}
+
+	// The book itself is left as it is.
+	if _, err := os.Stat(filepath.Join(dir, "OEBPS", "Nav", bilingualTOCFileName)); !os.IsNotExist(err) {
+		t.Errorf("the bilingual table of contents was written into the book: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "OEBPS", "content.opf")); err != nil || string(content) != files["content.opf"] {
+		t.Errorf("content.opf was changed on disk: %s", content)
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	packed := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		packed[f.Name] = string(content)
+	}
+
+	if page := packed["OEBPS/Nav/"+bilingualTOCFileName]; !strings.Contains(page, `<a href="../Text/ch1.xhtml">Chapter One</a>`) {
+		t.Errorf("packed bilingual table of contents lacks the first chapter:\n%s", page)
+	}
+	opf := packed["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<item id="` + bilingualTOCID + `" href="Nav/` + bilingualTOCFileName + `" media-type="application/xhtml+xml"/>`,
+		`<spine>` + "\n" + `<itemref idref="` + bilingualTOCID + `"/>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("packed content.opf lacks %s:\n%s", want, opf)
+		}
+	}
+}
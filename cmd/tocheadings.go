@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// Some books come with a table of contents whose entries have no title, or
+// only a number such as "Chapter 3", or that leaves chapters out. pack
+// --heading-titles titles those entries after the first heading of the
+// document they lead to, as translated, and adds entries for the chapters
+// left out. The nav document and the NCX are edited textually to keep their
+// formatting, in the packed book only.
+
+var (
+	// genericTitleRegex matches the titles that tell no more than the place
+	// of an entry.
+	genericTitleRegex = regexp.MustCompile(`(?i)^(?:(?:chapter|chap\.|part|section|book)\s+(?:\d+|[ivxlcdm]+)|\d+)\.?$`)
+
+	tocNavRegex     = regexp.MustCompile(`(?s)<nav\b[^>]*\bepub:type="(?:[^"]*\s)?toc(?:\s[^"]*)?"[^>]*>.*?</nav>`)
+	tocAnchorRegex  = regexp.MustCompile(`(?s)(<a\b[^>]*?\bhref="([^"]*)"[^>]*>)(.*?)(</a>)`)
+	ncxLabelRegex   = regexp.MustCompile(`(?s)(<navLabel>\s*<text>)(.*?)(</text>\s*</navLabel>\s*<content\b[^>]*?\bsrc="([^"]*)")`)
+	markupTagsRegex = regexp.MustCompile(`<[^>]*>`)
+
+	// tocListTagRegex matches the tags of the lists and entries of a nav
+	// document and of an NCX.
+	tocListTagRegex = regexp.MustCompile(`(?i)<(/?)(ol|li|navMap|navPoint)\b[^>]*?(/?)>`)
+	tocLinkRegex    = regexp.MustCompile(`\b(?:href|src)="([^"]*)"`)
+	playOrderRegex  = regexp.MustCompile(`\bplayOrder="\d+"`)
+	ncxNavMapRegex  = regexp.MustCompile(`(?s)<navMap\b.*?</navMap>`)
+)
+
+// isGenericTitle reports whether the title of a table of contents entry,
+// which may hold markup, is missing or only a number.
+func isGenericTitle(title string) bool {
+	text := strings.TrimSpace(whitespaceRegex.ReplaceAllString(html.UnescapeString(markupTagsRegex.ReplaceAllString(title, "")), " "))
+	return text == "" || genericTitleRegex.MatchString(text)
+}
+
+// headingTitle returns the translation of the first heading at fragment of
+// doc, or of the document when fragment is empty or has none. A heading not
+// translated yet gives its original text.
+func headingTitle(doc *goquery.Document, fragment string) string {
+	headings := fmt.Sprintf("h1:not([%[1]s]), h2:not([%[1]s]), h3:not([%[1]s]), h4:not([%[1]s]), h5:not([%[1]s]), h6:not([%[1]s])", util.TranslationIdKey)
+
+	var heading *goquery.Selection
+	if fragment != "" {
+		if target := doc.Find(fmt.Sprintf("[id=%q]", fragment)).First(); target.Length() > 0 {
+			heading = target.Filter(headings)
+			if heading.Length() == 0 {
+				heading = target.Find(headings).First()
+			}
+		}
+	}
+	if heading == nil || heading.Length() == 0 {
+		heading = doc.Find("body").Find(headings).First()
+	}
+	if heading.Length() == 0 {
+		return ""
+	}
+
+	title := heading.Text()
+	if id, ok := heading.Attr(util.TranslationByIdKey); ok {
+		if translated := doc.Find(fmt.Sprintf("[%s=%q]", util.TranslationIdKey, id)).First(); strings.TrimSpace(translated.Text()) != "" {
+			title = translated.Text()
+		}
+	}
+	return strings.TrimSpace(whitespaceRegex.ReplaceAllString(title, " "))
+}
+
+// headingTOCTitles returns the nav document and the NCX of the book, by path,
+// with their generic entries titled after the headings of their documents and
+// entries added for the documents of the spine they miss. The unpacked book
+// is left as it is; pack puts the returned files in the package instead. It
+// also returns the number of entries titled and added.
+func headingTOCTitles(unzipPath string) (map[string][]byte, int, int, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var tocHrefs []string
+	for _, item := range book.pkg.Manifest.Items {
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			tocHrefs = append(tocHrefs, item.Href)
+		}
+	}
+	if item := book.pkg.Manifest.GetItemByID(book.pkg.Spine.Toc); item != nil {
+		tocHrefs = append(tocHrefs, item.Href)
+	}
+
+	docs := make(map[string]*goquery.Document)
+	replaced := make(map[string][]byte)
+	titled, added := 0, 0
+	for _, tocHref := range tocHrefs {
+		tocPath := filepath.Join(book.contentDir, tocHref)
+		content, err := os.ReadFile(tocPath)
+		if err != nil {
+			return nil, titled, added, fmt.Errorf("error reading %s: %w", tocPath, err)
+		}
+
+		// retitle returns the title for the entry leading to src, or "" to
+		// keep the one it has.
+		retitle := func(title, src string) string {
+			if !isGenericTitle(title) {
+				return ""
+			}
+			file, fragment, _ := strings.Cut(html.UnescapeString(src), "#")
+			if unescaped, err := url.PathUnescape(file); err == nil {
+				file = unescaped
+			}
+			if file == "" {
+				return ""
+			}
+			doc := loadTOCDocument(filepath.Join(book.contentDir, filepath.FromSlash(path.Join(path.Dir(tocHref), file))), docs)
+			if doc == nil {
+				return ""
+			}
+			return headingTitle(doc, fragment)
+		}
+
+		count := 0
+		ncx := strings.HasSuffix(strings.ToLower(tocHref), ".ncx")
+		var updated string
+		if ncx {
+			updated = ncxLabelRegex.ReplaceAllStringFunc(string(content), func(m string) string {
+				parts := ncxLabelRegex.FindStringSubmatch(m)
+				title := retitle(parts[2], parts[4])
+				if title == "" {
+					return m
+				}
+				count++
+				return parts[1] + html.EscapeString(title) + parts[3]
+			})
+		} else {
+			updated = tocNavRegex.ReplaceAllStringFunc(string(content), func(nav string) string {
+				return tocAnchorRegex.ReplaceAllStringFunc(nav, func(m string) string {
+					parts := tocAnchorRegex.FindStringSubmatch(m)
+					title := retitle(parts[3], parts[2])
+					if title == "" {
+						return m
+					}
+					count++
+					return parts[1] + html.EscapeString(title) + parts[4]
+				})
+			})
+		}
+
+		updated, n := addSpineEntries(book, tocHref, updated, ncx, docs)
+		if count == 0 && n == 0 {
+			continue
+		}
+		replaced[tocPath] = []byte(updated)
+		titled += count
+		added += n
+	}
+
+	return replaced, titled, added, nil
+}
+
+// spineTOCEntry is an entry at the top level of a table of contents: where it
+// starts and ends in the file, and the place in the spine of the document it
+// leads to, -1 for none.
+type spineTOCEntry struct {
+	start, end, spine int
+}
+
+// addSpineEntries adds to content, the nav document or the NCX tocHref, an
+// entry for each document of the spine it has none for, titled after the
+// first heading of the document. An entry goes after the last entry at the
+// top level leading to a document before it in the spine. Documents without
+// a heading, such as covers, get none. It returns the new content and the
+// number of entries added.
+func addSpineEntries(book *bookFiles, tocHref, content string, ncx bool, docs map[string]*goquery.Document) (string, int) {
+	offset, region := 0, content
+	entryTag, listTag := "navpoint", "navmap"
+	if !ncx {
+		loc := tocNavRegex.FindStringIndex(content)
+		if loc == nil {
+			return content, 0
+		}
+		offset, region = loc[0], content[loc[0]:loc[1]]
+		entryTag, listTag = "li", "ol"
+	}
+
+	// target returns the href in the manifest of the document src leads to.
+	target := func(src string) string {
+		file, _, _ := strings.Cut(html.UnescapeString(src), "#")
+		if unescaped, err := url.PathUnescape(file); err == nil {
+			file = unescaped
+		}
+		if file == "" || strings.Contains(file, ":") {
+			return ""
+		}
+		return path.Join(path.Dir(tocHref), file)
+	}
+
+	var spine []loader.Item
+	spineIndex := map[string]int{}
+	for _, ref := range book.pkg.Spine.ItemRefs {
+		item := book.pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || ref.Linear == "no" || item.MediaType != "application/xhtml+xml" ||
+			strings.Contains(" "+item.Properties+" ", " nav ") || item.ID == bilingualTOCID {
+			continue
+		}
+		spineIndex[item.Href] = len(spine)
+		spine = append(spine, *item)
+	}
+	linked := map[string]bool{}
+	for _, m := range tocLinkRegex.FindAllStringSubmatch(region, -1) {
+		linked[target(m[1])] = true
+	}
+
+	// Find the entries at the top level of the first list, and its end.
+	var entries []spineTOCEntry
+	listEnd, entryStart, entryDepth, listDepth := -1, 0, 0, 0
+	for _, loc := range tocListTagRegex.FindAllStringSubmatchIndex(region, -1) {
+		closing, selfClosing := loc[3] > loc[2], loc[7] > loc[6]
+		switch strings.ToLower(region[loc[4]:loc[5]]) {
+		case entryTag:
+			switch {
+			case selfClosing:
+			case !closing:
+				if entryDepth == 0 {
+					entryStart = loc[0]
+				}
+				entryDepth++
+			case entryDepth > 0:
+				entryDepth--
+				if entryDepth == 0 {
+					entry := spineTOCEntry{start: entryStart, end: loc[1], spine: -1}
+					if link := tocLinkRegex.FindStringSubmatch(region[entryStart:loc[1]]); link != nil {
+						if i, ok := spineIndex[target(link[1])]; ok {
+							entry.spine = i
+						}
+					}
+					entries = append(entries, entry)
+				}
+			}
+		case listTag:
+			if !closing {
+				listDepth++
+			} else if listDepth--; listDepth == 0 {
+				listEnd = loc[0]
+			}
+		}
+		if listEnd >= 0 {
+			break
+		}
+	}
+	if listEnd < 0 {
+		return content, 0
+	}
+
+	type insertion struct {
+		at   int
+		text string
+	}
+	var insertions []insertion
+	for i, item := range spine {
+		if linked[item.Href] {
+			continue
+		}
+		doc := loadTOCDocument(filepath.Join(book.contentDir, filepath.FromSlash(item.Href)), docs)
+		if doc == nil {
+			continue
+		}
+		title := headingTitle(doc, "")
+		if title == "" {
+			continue
+		}
+
+		href := item.Href
+		if rel, err := filepath.Rel(filepath.FromSlash(path.Dir(tocHref)), filepath.FromSlash(item.Href)); err == nil {
+			href = filepath.ToSlash(rel)
+		}
+		href = html.EscapeString((&url.URL{Path: href}).String())
+		text := `<li><a href="` + href + `">` + html.EscapeString(title) + `</a></li>`
+		if ncx {
+			text = `<navPoint id="epubtrans-toc-` + html.EscapeString(item.ID) + `" playOrder="0"><navLabel><text>` + html.EscapeString(title) + `</text></navLabel><content src="` + href + `"/></navPoint>`
+		}
+
+		// After the last entry of a document before it, else before the
+		// first entry, else in the empty list.
+		at, after := listEnd, -1
+		for j, entry := range entries {
+			if entry.spine >= 0 && entry.spine < i {
+				after = j
+			}
+		}
+		switch {
+		case after >= 0:
+			at = entries[after].end
+			text = "\n" + indentBefore(region, entries[after].start) + text
+		case len(entries) > 0:
+			at = entries[0].start
+			text += "\n" + indentBefore(region, entries[0].start)
+		}
+		insertions = append(insertions, insertion{offset + at, text})
+	}
+	if len(insertions) == 0 {
+		return content, 0
+	}
+
+	// Entries going to the same place keep the order of the spine.
+	sort.SliceStable(insertions, func(a, b int) bool { return insertions[a].at < insertions[b].at })
+	var out strings.Builder
+	last := 0
+	for _, ins := range insertions {
+		out.WriteString(content[last:ins.at])
+		out.WriteString(ins.text)
+		last = ins.at
+	}
+	out.WriteString(content[last:])
+	updated := out.String()
+
+	// The play order of the NCX follows the entries again.
+	if ncx && playOrderRegex.MatchString(content) {
+		updated = ncxNavMapRegex.ReplaceAllStringFunc(updated, func(navMap string) string {
+			n := 0
+			return playOrderRegex.ReplaceAllStringFunc(navMap, func(string) string {
+				n++
+				return fmt.Sprintf(`playOrder="%d"`, n)
+			})
+		})
+	}
+	return updated, len(insertions)
+}
+
+// indentBefore returns the spaces and tabs before position at of s, on its
+// line.
+func indentBefore(s string, at int) string {
+	start := at
+	for start > 0 && (s[start-1] == ' ' || s[start-1] == '\t') {
+		start--
+	}
+	return s[start:at]
+}
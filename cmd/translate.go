@@ -1,32 +1,80 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/glossary"
 	"github.com/dutchsteven/epubtrans/pkg/loader"
+	"github.com/dutchsteven/epubtrans/pkg/memory"
 	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/replace"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
 	"github.com/dutchsteven/epubtrans/pkg/translator"
 	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/dutchsteven/epubtrans/pkg/xhtml"
 	"github.com/liushuangls/go-anthropic/v2"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/html"
 	"golang.org/x/time/rate"
 )
 
 var (
 	sourceLanguage string
 	targetLanguage string
+	// promptVersion pins the prompt version used in cache keys; empty means the current prompt.
+	promptVersion string
+
+	// translationInstructions is sent alongside every batch, e.g. a series character sheet.
+	translationInstructions string
+	// sharedGlossary holds terms of a series, which the glossary of each book extends.
+	sharedGlossary *glossary.Glossary
+	// bookGlossary holds the terms of the book being translated, and
+	// batchInstructions the instructions sent with its batches.
+	bookGlossary      *glossary.Glossary
+	batchInstructions string
+	// cacheSpec selects the translation cache, see translator.NewCache;
+	// bookCacheSpec keeps it in bookCacheFile inside the unpacked book.
+	cacheSpec string
+	// translationProvider names the model API, see translator.New.
+	translationProvider string
+	// deepLGlossaryID is the DeepL glossary used with --provider deepl.
+	deepLGlossaryID string
+	// mockFixture holds the translations --provider mock replays, and
+	// recordFixture is where the translations of the provider are recorded.
+	mockFixture   string
+	recordFixture string
+	// redisURL, when set, shares the rate limit and the cache with other instances.
+	redisURL string
+	// maxConcurrency bounds the number of concurrent requests.
+	maxConcurrency = 4
+	// translateWorkers is the number of chapters translated at once; zero
+	// means maxConcurrency.
+	translateWorkers int
+
+	// translationMemory, when set, is consulted before calling the model and
+	// updated with every accepted translation.
+	translationMemory *memory.Memory
+	// memoryPath is the JSON or TMX file translationMemory is kept in.
+	memoryPath string
+	// resumeTranslation continues the run recorded in the progress file.
+	resumeTranslation bool
 )
 
 var Translate = &cobra.Command{
@@ -49,7 +97,25 @@ Make sure to provide the path to the unpacked EPUB directory and the desired lan
 func init() {
 	Translate.Flags().StringVar(&sourceLanguage, "source", "English", "source language")
 	Translate.Flags().StringVar(&targetLanguage, "target", "Vietnamese", "target language")
-	Translate.Flags().String("model", string(anthropic.ModelClaude3Dot5SonnetLatest), "Anthropic model to use")
+	Translate.Flags().StringVar(&translationProvider, "provider", providerFromEnv(), "translation provider: "+strings.Join(translator.Providers(), ", "))
+	Translate.Flags().String("model", "", "model to use; defaults to "+string(anthropic.ModelClaude3Dot5SonnetLatest)+" for anthropic, "+translator.OpenAIModelGPT4o+" for openai, "+translator.OllamaModelLlama3Dot1+" for ollama, "+translator.GeminiModel1Dot5Flash+" for gemini, "+translator.DeepLModelPreferQualityOptimized+" for deepl and "+translator.MockModelPseudo+" for mock")
+	Translate.Flags().IntVar(&maxConcurrency, "max-concurrency", 4, "maximum number of concurrent API requests; the actual number adapts to the API rate limits")
+	Translate.Flags().IntVar(&translateWorkers, "workers", 0, "number of chapters translated at once, in reading order; defaults to --max-concurrency")
+	Translate.Flags().StringVar(&translationPlacement, "placement", placementAuto, "where to put translations: auto, inline, popup or endnote; auto uses popup footnotes on fixed-layout pages")
+	Translate.Flags().StringSliceVar(&skipPatterns, "skip", nil, "regular expression for file names not to translate (repeatable)")
+	Translate.Flags().BoolVar(&includeBoilerplate, "include-boilerplate", false, "also translate pages that look like copyright pages or publisher ads")
+	Translate.Flags().StringVar(&cacheSpec, "cache", bookCacheSpec, "translation cache: book keeps translations in the book directory between runs; also memory, none, file:<dir> or bolt:<file>")
+	Translate.Flags().StringVar(&deepLGlossaryID, "deepl-glossary", os.Getenv("DEEPL_GLOSSARY_ID"), "ID of a DeepL glossary to apply with --provider deepl")
+	Translate.Flags().StringVar(&mockFixture, "fixture", "", "with --provider mock, replay the translations recorded in this file with --record instead of pseudo-translating")
+	Translate.Flags().StringVar(&recordFixture, "record", "", "record the translations of the provider in this file, for --provider mock --fixture to replay")
+	Translate.Flags().StringVar(&redisURL, "redis", os.Getenv("EPUBTRANS_REDIS_URL"), "redis:// URL to share the rate limit and, unless --cache is set, the cache with other epubtrans instances")
+	Translate.Flags().StringSliceVar(&retranslateWhere, "retranslate-where", nil, "translate again the segments whose translation matches all conditions, e.g. model=claude-3-haiku or prompt-version!=<hash> (repeatable)")
+	Translate.Flags().StringVar(&glossaryFile, "glossary", "", "glossary file (.yaml, .yml or .csv) of preferred term translations; defaults to <unpackedEpubPath>-glossary.yaml")
+	Translate.Flags().StringVar(&memoryPath, "memory", "", "translation memory file (.json or .tmx) to reuse translations from and record every new one in")
+	Translate.Flags().BoolVar(&resumeTranslation, "resume", false, "continue the last run recorded in <unpackedEpubPath>-progress.json, skipping the files it finished")
+	Translate.Flags().StringVar(&promptVersion, "prompt-version", "", "reuse cached translations made with this prompt version instead of the current one")
+	Translate.Flags().StringSliceVar(&qaRules, "qa-rules", nil, "QA rule pack, or directory of packs, to check new translations with on top of the built-in ones (repeatable)")
+	addSamplingFlags(Translate)
 }
 
 type elementToTranslate struct {
@@ -59,15 +125,42 @@ type elementToTranslate struct {
 	totalElements int
 	index         int
 	content       string
+	// formulas holds the MathML masked out of content.
+	formulas []string
+	// citations holds the parts of a bibliography entry masked out of
+	// content, after the formulas.
+	citations []string
+	// markup holds the inline markup masked out of content, last.
+	markup []markupPart
 }
 
 type translationBatch struct {
 	elements []elementToTranslate
+	// labels marks a batch of SVG text labels, which must stay as short as the original.
+	labels bool
 }
 
+const (
+	// maxBatchLength is the length of original markup after which a batch is sent.
+	maxBatchLength = 3000
+	batchPreamble  = "Translate the following HTML segments. Each segment is marked with BEGIN_SEGMENT_X and END_SEGMENT_X markers. Preserve these markers exactly in your response and maintain all HTML tags.\n\n"
+
+	bookCacheSpec = "book"
+	// bookCacheFile is left out of packed EPUBs.
+	bookCacheFile = ".epubtrans-cache.db"
+)
+
 var fileLocks = make(map[string]*sync.Mutex)
 var fileLocksLock sync.Mutex
 
+// providerFromEnv returns the default provider: EPUBTRANS_PROVIDER, or anthropic.
+func providerFromEnv() string {
+	if provider := os.Getenv("EPUBTRANS_PROVIDER"); provider != "" {
+		return provider
+	}
+	return "anthropic"
+}
+
 func getFileLock(filePath string) *sync.Mutex {
 	fileLocksLock.Lock()
 	defer fileLocksLock.Unlock()
@@ -81,6 +174,23 @@ func getFileLock(filePath string) *sync.Mutex {
 	return lock
 }
 
+// lockFiles takes the locks of filePaths in the order of their paths, so two
+// callers locking the same files cannot each hold one the other waits for,
+// and returns the function releasing them.
+func lockFiles(filePaths ...string) func() {
+	paths := slices.Compact(slices.Sorted(slices.Values(filePaths)))
+	locks := make([]*sync.Mutex, len(paths))
+	for i, filePath := range paths {
+		locks[i] = getFileLock(filePath)
+		locks[i].Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
 func runTranslate(cmd *cobra.Command, args []string) error {
 	unzipPath := args[0]
 	ctx, cancel := context.WithCancel(cmd.Context())
@@ -100,37 +210,333 @@ func runTranslate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	var err error
+	if translationSampling, err = samplingFromFlags(cmd); err != nil {
+		return err
+	}
+
+	if err := applyThrottle(cmd); err != nil {
+		return err
+	}
+
+	if redisURL != "" && !cmd.Flags().Changed("cache") {
+		cacheSpec = redisURL
+	}
+	// Every cache keys translations by prompt version, but memory and none
+	// start empty, so they hold no translations of an older prompt to reuse.
+	if promptVersion != "" && (cacheSpec == "memory" || cacheSpec == "none") {
+		fmt.Printf("Warning: --prompt-version %s only reuses translations of a cache kept between runs, not --cache %s\n", promptVersion, cacheSpec)
+	}
+
+	runFlags = changedFlags(cmd.Flags())
+	retrySegments = nil
+	if retryFailures != "" {
+		if retrySegments, err = loadRetrySet(unzipPath, retryFailures); err != nil {
+			return err
+		}
+	}
+
+	if probeEnabled {
+		if err := probeProvider(ctx, cmd.Flag("model").Value.String()); err != nil {
+			return err
+		}
+	}
+
+	if memoryPath == "" {
+		return translateBook(ctx, unzipPath, cmd.Flag("model").Value.String())
+	}
+
+	translationMemory, err = tm.Load(memoryPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d translation memory entries\n", translationMemory.Len())
+
+	err = translateBook(ctx, unzipPath, cmd.Flag("model").Value.String())
+	// Keep what was translated even if the run failed half way.
+	if saveErr := tm.Save(memoryPath, translationMemory); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// translateBook translates every marked segment of the unpacked EPUB at unzipPath
+// using the package level source and target languages.
+func translateBook(ctx context.Context, unzipPath, model string) (err error) {
+	acknowledged, err := checkRights(unzipPath)
+	if err != nil {
+		return err
+	}
+
+	job, err := startJob(unzipPath, "translate")
+	if err != nil {
+		return err
+	}
+	runFailures = newFailureCollector(unzipPath)
+	defer func() {
+		job.finish(err)
+		writeFailureReport(unzipPath, job.info, model)
+	}()
+	if len(acknowledged) > 0 {
+		jobLog.Warn("rights check acknowledged", "flags", strings.Join(acknowledged, "; "))
+	}
+
 	// Extract book name from EPUB metadata
 	bookName, err := extractBookName(unzipPath)
 	if err != nil {
 		return fmt.Errorf("error extracting book name: %v", err)
 	}
 
-	limiter := rate.NewLimiter(rate.Every(time.Minute/50), 10)
+	var limiter translator.Limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(runThrottle.requestsPerMinute)), runThrottle.burst)
+	if redisURL != "" {
+		client, err := translator.NewRedisClient(redisURL)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
 
-	anthropicTranslator, err := translator.GetAnthropicTranslator(&translator.Config{
-		APIKey:      os.Getenv("ANTHROPIC_KEY"),
-		Model:       cmd.Flag("model").Value.String(),
-		Temperature: 0.7,
-		MaxTokens:   8192,
+		limiter = translator.NewRedisLimiter(client, translationProvider, runThrottle.requestsPerMinute, time.Minute)
+	}
+
+	spec := cacheSpec
+	if spec == bookCacheSpec {
+		spec = "bolt:" + filepath.Join(unzipPath, bookCacheFile)
+	}
+	cache, err := translator.NewCache(spec, 1e7)
+	if err != nil {
+		return err
+	}
+	if closer, ok := cache.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	provider, err := translator.New(translationProvider, &translator.Config{
+		Model:          model,
+		Temperature:    translationSampling.Temperature,
+		TopP:           translationSampling.TopP,
+		Seed:           translationSampling.Seed,
+		MaxTokens:      8192,
+		Cache:          cache,
+		PromptVersion:  promptVersion,
+		MaxConcurrency: maxConcurrency,
+		GlossaryID:     deepLGlossaryID,
+		Fixture:        mockFixture,
 	})
 	if err != nil {
 		return fmt.Errorf("error getting translator: %v", err)
 	}
+	if recordFixture != "" {
+		if provider, err = translator.NewRecorder(provider, recordFixture); err != nil {
+			return fmt.Errorf("error opening fixture: %v", err)
+		}
+	}
+
+	return runTranslation(ctx, unzipPath, provider, limiter, bookName)
+}
+
+// runTranslation translates the book at unzipPath with provider, sending
+// requests as limiter allows.
+func runTranslation(ctx context.Context, unzipPath string, provider translator.Provider, limiter translator.Limiter, bookName string) (err error) {
+	fmt.Printf("Prompt version: %s\n", provider.PromptVersion())
+	runProvenance = provenance{Provider: translationProvider, Model: provider.Model(), PromptVersion: provider.PromptVersion(), Sampling: provider.Sampling()}
+	if sampling := provider.Sampling(); sampling != "" {
+		fmt.Printf("Sampling: %s\n", sampling)
+		if translationSampling.Seed != nil && !strings.Contains(sampling, "seed=") {
+			fmt.Printf("Note: %s takes no seed, so reruns may translate differently\n", translationProvider)
+		}
+	}
+
+	ctx, stopTimeBox := timeBox(ctx, maxDuration)
+	defer stopTimeBox()
+
+	var previous *runProgress
+	if resumeTranslation {
+		if previous, err = resumableProgress(unzipPath, provider.Model()); err != nil {
+			return err
+		}
+		printProgressSummary(previous)
+	}
+	translateProgress, err = newProgressTracker(unzipPath, runProgress{
+		Source:   sourceLanguage,
+		Target:   targetLanguage,
+		Provider: translationProvider,
+		Model:    provider.Model(),
+	}, previous)
+	if err != nil {
+		return fmt.Errorf("error writing progress: %w", err)
+	}
+	defer func() {
+		translateProgress.finish(err, context.Cause(ctx))
+		translateProgress = nil
+	}()
+
+	terms, err := loadBookGlossary(unzipPath)
+	if err != nil {
+		return err
+	}
+	bookGlossary = glossary.New()
+	for _, t := range append(sharedGlossary.Terms(), terms.Terms()...) {
+		bookGlossary.Add(t.Source, t.Target)
+	}
+	style, err := styleInstructions(requestContext(ctx), unzipPath, styleSample, provider, limiter)
+	if err != nil {
+		return err
+	}
+	var instructions []string
+	for _, part := range []string{translationInstructions, bookGlossary.Prompt(), style} {
+		if part = strings.TrimSpace(part); part != "" {
+			instructions = append(instructions, part)
+		}
+	}
+	batchInstructions = strings.Join(instructions, "\n\n")
+	if bookGlossary.Len() > 0 {
+		fmt.Printf("Glossary: %d terms\n", bookGlossary.Len())
+	}
+
+	if qaPacks, err = loadQAPacks(unzipPath, sourceLanguage, targetLanguage); err != nil {
+		return err
+	}
+	if len(qaPacks) > 0 {
+		fmt.Printf("QA rules: %d\n", qaPacks.Rules())
+	}
+
+	if replaceRules, err = replace.Load(bookReplacePath(unzipPath)); err != nil {
+		return err
+	}
+	replaceHits.reset(len(replaceRules))
+	if len(replaceRules) > 0 {
+		fmt.Printf("Replace rules: %d\n", len(replaceRules))
+	}
+
+	for _, pattern := range skipPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid skip pattern %q: %w", pattern, err)
+		}
+	}
 
-	// 1 worker and 1 job at a time, mean 1 file at a time
+	switch translationPlacement {
+	case placementAuto, placementInline, placementPopup, placementEndnote:
+	default:
+		return fmt.Errorf("invalid placement %q: use auto, inline, popup or endnote", translationPlacement)
+	}
+
+	fixedLayoutPages, err = findFixedLayoutPages(unzipPath)
+	if err != nil {
+		return fmt.Errorf("error reading layout: %w", err)
+	}
+	if len(fixedLayoutPages) > 0 {
+		warnFixedLayout(len(fixedLayoutPages))
+	}
+
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return err
+	}
+	bookContentDir = book.contentDir
+	if err := loadBookSemantics(book); err != nil {
+		return err
+	}
+
+	// Skipped files keep their translations, so clearing waits for the
+	// semantics the skip decision reads.
+	retranslateContentIDs = nil
+	if len(retranslateWhere) > 0 {
+		filters, err := parseProvenanceFilters(retranslateWhere)
+		if err != nil {
+			return err
+		}
+
+		retranslateContentIDs, err = clearTranslationsWhere(unzipPath, filters)
+		if err != nil {
+			return fmt.Errorf("error clearing translations: %w", err)
+		}
+		fmt.Printf("Translating %d segments again (%s)\n", len(retranslateContentIDs), strings.Join(retranslateWhere, ", "))
+		jobLog.Info("translations cleared", "segments", len(retranslateContentIDs), "where", strings.Join(retranslateWhere, ", "))
+	}
+
+	if bookCitations, err = loadCitationStore(citationStorePath(unzipPath)); err != nil {
+		return err
+	}
+
+	endnotes = nil
+	if translationPlacement == placementEndnote {
+		endnotes, err = newEndnoteWriter(unzipPath)
+		if err != nil {
+			return fmt.Errorf("error opening endnotes: %w", err)
+		}
+	}
+
+	// One file per worker; the translator throttle decides how many requests actually run at once
+	workers := translateWorkers
+	if workers <= 0 {
+		workers = maxConcurrency
+	}
 	err = processor.ProcessEpub(ctx, unzipPath, processor.Config{
-		Workers:      1,
+		Workers:      max(workers, 1),
 		JobBuffer:    1,
 		ResultBuffer: 10,
+		// The log and the usage of a chapter are held back until the
+		// chapters before it are done, so they come out in reading order
+		// and repeated runs give the same files.
+		Completed: func(filePath string, err error) {
+			jobLogHold.release(path.Base(filePath))
+			provider.ReleaseUsage(filePath)
+		},
 	}, func(ctx context.Context, filePath string) error {
-		return processFileDirectly(ctx, filePath, anthropicTranslator, limiter, bookName)
+		jobLogHold.hold(path.Base(filePath))
+		ctx = translator.WithUsageKey(ctx, filePath)
+		if err := processFileDirectly(ctx, filePath, provider, limiter, bookName); err != nil {
+			jobLog.Error("file failed", "file", path.Base(filePath), "error", err)
+			translateProgress.failed(filePath, err)
+			runFailures.add(filePath, nil, failureFile, err)
+			return err
+		}
+		return nil
 	})
 
+	if endnotes != nil {
+		if flushErr := endnotes.flush(); flushErr != nil {
+			return fmt.Errorf("error writing endnotes: %w", flushErr)
+		}
+	}
+
+	if flushErr := provider.FlushMetadata(); flushErr != nil {
+		fmt.Printf("Error writing translator metadata: %v\n", flushErr)
+	}
+	printUsage(provider.Usage())
+	printReplaceHits()
+
+	if isPaused(ctx) {
+		fmt.Printf("\nPaused after %s; continue the run with --resume\n", maxDuration)
+		jobLog.Info("run paused", "max_duration", maxDuration.String())
+		return nil
+	}
 	return err
 }
 
-func processFileDirectly(ctx context.Context, filePath string, translator translator.Translator, limiter *rate.Limiter, bookName string) error {
+func printUsage(usage translator.UsageStats) {
+	if usage.Calls == 0 {
+		return
+	}
+	if usage.Characters > 0 {
+		fmt.Printf("\nCharacter usage: %d requests, %d characters billed\n", usage.Calls, usage.Characters)
+		return
+	}
+
+	saved, share := usage.CacheSavings()
+	fmt.Printf("\nToken usage: %d requests, %d input, %d output\n", usage.Calls, usage.InputTokens, usage.OutputTokens)
+	fmt.Printf("Prompt cache: %d tokens read, %d tokens written, saving %.0f input tokens (%.1f%% of input cost)\n",
+		usage.CacheReadTokens, usage.CacheWriteTokens, saved, share*100)
+	if usage.CacheReadTokens == 0 && usage.CacheWriteTokens == 0 {
+		fmt.Println("The system prompt is shorter than the model's minimum cacheable length, so nothing was cached.")
+	}
+}
+
+func processFileDirectly(ctx context.Context, filePath string, translator translator.Translator, limiter translator.Limiter, bookName string) error {
+	if resumeTranslation && translateProgress.isDone(filePath) {
+		fmt.Printf("\nSkipping %s: finished in the resumed run\n", path.Base(filePath))
+		return nil
+	}
 	fmt.Printf("\nProcessing file: %s\n", path.Base(filePath))
 
 	doc, err := openAndReadFile(filePath)
@@ -138,32 +544,92 @@ func processFileDirectly(ctx context.Context, filePath string, translator transl
 		return err
 	}
 
+	reason, err := skipReason(filePath, doc)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		fmt.Printf("Skipping %s: %s\n", path.Base(filePath), reason)
+		jobLog.Info("file skipped", "file", path.Base(filePath), "reason", reason)
+		translateProgress.skipped(filePath, reason)
+		return nil
+	}
+
 	ensureUTF8Charset(doc)
 
 	selector := fmt.Sprintf("[%s]:not([%s])", util.ContentIdKey, util.TranslationByIdKey)
 	elements := doc.Find(selector)
+	if retrySegments != nil {
+		elements = elements.FilterFunction(func(_ int, s *goquery.Selection) bool {
+			return retrySegments.retries(filePath, s.AttrOr(util.ContentIdKey, ""))
+		})
+	}
 
 	if elements.Length() == 0 {
 		fmt.Printf("No elements to translate in %s\n", path.Base(filePath))
+		translateProgress.finished(filePath, 0)
 		return nil
 	}
 
 	fmt.Printf("Found %d elements to translate in %s\n",
 		elements.Length(), path.Base(filePath))
+	jobLog.Info("file started", "file", path.Base(filePath), "segments", elements.Length())
+	translateProgress.started(filePath)
+
+	citationMode, err := citationModeFor(bookCitations, bookContentDir, filePath)
+	if err != nil {
+		return err
+	}
 
 	// Create batches directly
 	var currentBatch translationBatch
-	maxBatchLength := 3000
+	labelBatch := translationBatch{labels: true}
+	var memoryHits []string
+	// inTarget counts the segments in the target language already
+	inTarget := 0
+	// queued and accepted count the segments sent to the translator and those translated
+	queued, accepted := 0, 0
 
 	elements.Each(func(i int, contentEl *goquery.Selection) {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			htmlContent, err := contentEl.Html()
+			htmlContent, err := withoutPageBreaks(contentEl)
 			if err != nil || len(htmlContent) <= 1 {
 				return
 			}
+			htmlContent = normalizeHyphenation(htmlContent)
+
+			if isInTargetLanguage(contentEl.Text()) {
+				inTarget++
+				return
+			}
+
+			if translationMemory != nil && !retranslateContentIDs[contentEl.AttrOr(util.ContentIdKey, "")] {
+				if translation, ok := translationMemory.Lookup(htmlContent, sourceLanguage, targetLanguage); ok {
+					if err := placeTranslation(doc, contentEl, filePath, targetLanguage, translation, memoryProvenance); err == nil {
+						memoryHits = append(memoryHits, contentEl.AttrOr(util.ContentIdKey, ""))
+						return
+					}
+				}
+			}
+
+			masked, formulas := maskMath(htmlContent)
+			if isOnlyMath(masked, formulas) {
+				return
+			}
+			var citations []string
+			if isCitation(contentEl, citationMode) {
+				masked, citations = maskCitation(masked)
+				if isOnlyCitation(masked, citations) {
+					return
+				}
+			}
+			var markup []markupPart
+			if masksMarkup() {
+				masked, markup = maskMarkup(masked)
+			}
 
 			element := elementToTranslate{
 				filePath:      filePath,
@@ -171,13 +637,22 @@ func processFileDirectly(ctx context.Context, filePath string, translator transl
 				doc:           doc,
 				totalElements: elements.Length(),
 				index:         i,
-				content:       htmlContent,
+				content:       masked,
+				formulas:      formulas,
+				citations:     citations,
+				markup:        markup,
+			}
+
+			if isSVGLabel(contentEl) {
+				labelBatch.elements = append(labelBatch.elements, element)
+				return
 			}
 
 			currentBatchLength := getBatchLength(&currentBatch)
 			if currentBatchLength+len(htmlContent) > maxBatchLength && len(currentBatch.elements) > 0 {
 				// Process current batch
-				processBatch(ctx, filePath, currentBatch, translator, limiter, bookName)
+				queued += len(currentBatch.elements)
+				accepted += processBatch(ctx, filePath, currentBatch, translator, limiter, bookName)
 				// Start new batch
 				currentBatch = translationBatch{
 					elements: []elementToTranslate{element},
@@ -188,11 +663,40 @@ func processFileDirectly(ctx context.Context, filePath string, translator transl
 		}
 	})
 
-	// Process final batch if not empty
-	if len(currentBatch.elements) > 0 {
-		processBatch(ctx, filePath, currentBatch, translator, limiter, bookName)
+	// Process final batch if not empty, unless the run stopped meanwhile
+	if len(currentBatch.elements) > 0 && ctx.Err() == nil {
+		queued += len(currentBatch.elements)
+		accepted += processBatch(ctx, filePath, currentBatch, translator, limiter, bookName)
+	}
+
+	if len(labelBatch.elements) > 0 && ctx.Err() == nil {
+		queued += len(labelBatch.elements)
+		accepted += processBatch(ctx, filePath, labelBatch, translator, limiter, bookName)
+	}
+
+	if inTarget > 0 {
+		fmt.Printf("Left %d segments already in %s untranslated in %s\n", inTarget, targetLanguage, path.Base(filePath))
+		jobLog.Info("segments in the target language skipped", "file", path.Base(filePath), "segments", inTarget)
+	}
+
+	if len(memoryHits) > 0 {
+		fmt.Printf("Reused %d translations from translation memory in %s\n", len(memoryHits), path.Base(filePath))
+		jobLog.Info("translation memory hits", "file", path.Base(filePath), "segments", len(memoryHits))
+
+		fileLock := getFileLock(filePath)
+		fileLock.Lock()
+		defer fileLock.Unlock()
+
+		if err := writeContentToFile(filePath, doc); err != nil {
+			return fmt.Errorf("writing file %s: %w", filePath, err)
+		}
+		translateProgress.translated(filePath, memoryHits)
 	}
 
+	// An interrupted file is left running, so a resumed run takes it up again.
+	if ctx.Err() == nil {
+		translateProgress.finished(filePath, queued-accepted)
+	}
 	return nil
 }
 
@@ -219,9 +723,11 @@ func getBatchLength(batch *translationBatch) int {
 	return length
 }
 
-func processBatch(ctx context.Context, filePath string, batch translationBatch, anthropicTranslator translator.Translator, limiter *rate.Limiter, bookName string) {
+// processBatch translates the batch and writes the accepted translations to
+// the file. It returns the number of segments translated.
+func processBatch(ctx context.Context, filePath string, batch translationBatch, anthropicTranslator translator.Translator, limiter translator.Limiter, bookName string) int {
 	if len(batch.elements) == 0 {
-		return
+		return 0
 	}
 
 	fmt.Printf("\nTranslating batch from file %s (segments: %d; length: %d)\n",
@@ -229,17 +735,31 @@ func processBatch(ctx context.Context, filePath string, batch translationBatch,
 
 	// Combine contents with more distinct markers and instructions
 	var combinedContent strings.Builder
-	combinedContent.WriteString("Translate the following HTML segments. Each segment is marked with BEGIN_SEGMENT_X and END_SEGMENT_X markers. Preserve these markers exactly in your response and maintain all HTML tags.\n\n")
+	combinedContent.WriteString(batchPreamble)
+	if batchHasMath(batch) {
+		combinedContent.WriteString("Placeholders such as {{MATH_0}} stand for mathematical formulas. Keep every placeholder exactly once and unchanged, at the grammatically correct position.\n\n")
+	}
+	if batchHasCitations(batch) {
+		combinedContent.WriteString(citationInstructions + "\n\n")
+	}
+	if batchHasMarkup(batch) {
+		combinedContent.WriteString(markupInstructions + "\n\n")
+	}
+	if batch.labels {
+		combinedContent.WriteString("These segments are labels inside diagrams with very little room. Keep every translation as short as possible and never longer than the original; abbreviate if necessary.\n\n")
+	}
 
 	for i, element := range batch.elements {
 		combinedContent.WriteString(fmt.Sprintf("<SEGMENT_%d>\n%s\n</SEGMENT_%d>\n\n", i, element.content, i))
 	}
 
 	// Translate combined content
-	translatedContent, err := retryTranslate(ctx, anthropicTranslator, limiter, combinedContent.String(), sourceLanguage, targetLanguage, bookName)
+	translatedContent, err := retryTranslate(requestContext(ctx), anthropicTranslator, limiter, combinedContent.String(), sourceLanguage, targetLanguage, bookName)
 	if err != nil {
 		fmt.Printf("Batch translation error: %v\n", err)
-		return
+		jobLog.Error("batch translation failed", "file", path.Base(filePath), "segments", len(batch.elements), "error", err)
+		runFailures.add(filePath, batchSegmentIDs(batch), failureProvider, err)
+		return 0
 	}
 
 	// Split translated content and process individual elements
@@ -247,7 +767,9 @@ func processBatch(ctx context.Context, filePath string, batch translationBatch,
 	if len(translations) != len(batch.elements) {
 		fmt.Printf("Translation segments mismatch for %s: got %d, expected %d\n",
 			path.Base(filePath), len(translations), len(batch.elements))
-		return
+		jobLog.Error("segment count mismatch", "file", path.Base(filePath), "got", len(translations), "expected", len(batch.elements))
+		runFailures.add(filePath, batchSegmentIDs(batch), failureSegmentMismatch, fmt.Errorf("got %d segments, expected %d", len(translations), len(batch.elements)))
+		return 0
 	}
 
 	fmt.Printf("Successfully translated batch from %s, writing to file...\n", path.Base(filePath))
@@ -256,18 +778,74 @@ func processBatch(ctx context.Context, filePath string, batch translationBatch,
 	fileLock.Lock()
 	defer fileLock.Unlock()
 
+	var accepted []string
 	for i, element := range batch.elements {
-		if isTranslationValid(element.content, translations[i]) {
-			if err := manipulateHTML(element.contentEl, targetLanguage, translations[i]); err != nil {
-				fmt.Printf("HTML manipulation error: %v\n", err)
-				continue
-			}
+		translations[i] = applyAttributeQA(translations[i], path.Base(filePath), runProvenance)
+		if !isTranslationValid(element.content, translations[i]) {
+			jobLog.Warn("translation rejected: markup differs from the original", "file", path.Base(filePath), "content_id", contentID(element))
+			runFailures.add(filePath, []string{contentID(element)}, failureMarkupChanged, errors.New("markup differs from the original"))
+			continue
+		}
+		translations[i] = applyReplaceRules(path.Base(filePath), contentID(element), translations[i])
+
+		translation, err := unmaskMarkup(translations[i], element.markup)
+		if err != nil {
+			fmt.Printf("Markup lost in translation, skipping segment: %v\n", err)
+			jobLog.Warn("markup lost in translation", "file", path.Base(filePath), "content_id", contentID(element), "error", err)
+			runFailures.add(filePath, []string{contentID(element)}, failurePlaceholderLost, err)
+			continue
+		}
+		translation, err = unmaskCitation(translation, element.citations)
+		if err != nil {
+			fmt.Printf("Citation lost in translation, skipping segment: %v\n", err)
+			jobLog.Warn("citation lost in translation", "file", path.Base(filePath), "content_id", contentID(element), "error", err)
+			runFailures.add(filePath, []string{contentID(element)}, failurePlaceholderLost, err)
+			continue
+		}
+		translation, err = unmaskMath(translation, element.formulas)
+		if err != nil {
+			fmt.Printf("Formula lost in translation, skipping segment: %v\n", err)
+			jobLog.Warn("formula lost in translation", "file", path.Base(filePath), "content_id", contentID(element), "error", err)
+			runFailures.add(filePath, []string{contentID(element)}, failurePlaceholderLost, err)
+			continue
+		}
+		if err := placeTranslation(element.doc, element.contentEl, filePath, targetLanguage, translation, runProvenance); err != nil {
+			fmt.Printf("HTML manipulation error: %v\n", err)
+			jobLog.Error("inserting translation failed", "file", path.Base(filePath), "content_id", contentID(element), "error", err)
+			runFailures.add(filePath, []string{contentID(element)}, failureInsert, err)
+			continue
 		}
+		original, _ := unmaskMarkup(element.content, element.markup)
+		original, _ = unmaskCitation(original, element.citations)
+		original, _ = unmaskMath(original, element.formulas)
+		checkGlossary(path.Base(filePath), contentID(element), original, translation)
+		checkQARules(path.Base(filePath), contentID(element), original, translation)
+		if translationMemory != nil {
+			translationMemory.Add(original, translation, sourceLanguage, targetLanguage)
+		}
+		accepted = append(accepted, contentID(element))
 	}
 
 	if err := writeContentToFile(filePath, batch.elements[0].doc); err != nil {
 		fmt.Printf("Error writing to file: %v\n", err)
+		jobLog.Error("writing file failed", "file", path.Base(filePath), "error", err)
+		runFailures.add(filePath, accepted, failureWrite, err)
+		return 0
 	}
+	translateProgress.translated(filePath, accepted)
+
+	jobLog.Info("batch translated", "file", path.Base(filePath), "segments", len(batch.elements), "accepted", len(accepted), "provenance", runProvenance.String())
+	return len(accepted)
+}
+
+func contentID(element elementToTranslate) string {
+	id, _ := element.contentEl.Attr(util.ContentIdKey)
+	return id
+}
+
+// isSVGLabel reports whether the element is a text label of an SVG image.
+func isSVGLabel(s *goquery.Selection) bool {
+	return goquery.NodeName(s) == "text" && s.Closest("svg").Length() > 0
 }
 
 func splitTranslations(translatedContent string) []string {
@@ -306,38 +884,86 @@ func ensureUTF8Charset(doc *goquery.Document) {
 
 }
 
-func retryTranslate(ctx context.Context, t translator.Translator, limiter *rate.Limiter, content, sourceLang, targetLang, bookName string) (string, error) {
+func retryTranslate(ctx context.Context, t translator.Translator, limiter translator.Limiter, content, sourceLang, targetLang, bookName string) (string, error) {
 	maxRetries := 3
 	baseDelay := time.Second
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		select {
-		case <-ctx.Done():
+		// Wait while another worker backs off from a rate limit
+		if err := rateLimitPause.wait(ctx); err != nil {
+			return "", err
+		}
+
+		// Wait for rate limiter
+		if err := limiter.Wait(ctx); err != nil {
+			return "", fmt.Errorf("rate limiter error: %w", err)
+		}
+
+		translatedContent, err := t.Translate(ctx, batchInstructions, content, sourceLang, targetLang, bookName)
+		if err == nil {
+			return translatedContent, nil
+		}
+		if ctx.Err() != nil {
 			return "", ctx.Err()
-		default:
-			// Wait for rate limiter
-			if err := limiter.Wait(ctx); err != nil {
-				return "", fmt.Errorf("rate limiter error: %w", err)
-			}
+		}
 
-			translatedContent, err := t.Translate(ctx, "", content, sourceLang, targetLang, bookName)
-			if err == nil {
-				return translatedContent, nil
-			}
+		fmt.Println("Failed to translate, retrying...", err)
 
-			if errors.Is(err, translator.ErrRateLimitExceeded) {
-				time.Sleep(calculateBackoff(attempt, baseDelay*10))
-			} else {
-				time.Sleep(calculateBackoff(attempt, baseDelay))
-			}
+		if errors.Is(err, translator.ErrRateLimitExceeded) {
+			// Every worker would run into the same limit, so all of them pause.
+			rateLimitPause.extend(calculateBackoff(attempt, baseDelay*10))
+			continue
+		}
 
-			fmt.Println("Failed to translate, retrying...", err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(calculateBackoff(attempt, baseDelay)):
 		}
 	}
 
 	return "", fmt.Errorf("max retries reached")
 }
 
+// rateLimitPause holds back the requests of all workers after one of them was
+// rate limited.
+var rateLimitPause pauseGate
+
+// pauseGate is a pause shared by several goroutines.
+type pauseGate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// extend makes the pause last at least d from now.
+func (p *pauseGate) extend(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(p.until) {
+		p.until = until
+	}
+}
+
+// wait blocks until the pause is over.
+func (p *pauseGate) wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		d := time.Until(p.until)
+		p.mu.Unlock()
+
+		if d <= 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
 func calculateBackoff(attempt int, baseDelay time.Duration) time.Duration {
 	backoff := float64(baseDelay) * math.Pow(2, float64(attempt))
 	jitter := rand.Float64() * float64(baseDelay)
@@ -380,38 +1006,77 @@ func extractHTMLTags(html string) []string {
 	return tags
 }
 
-func manipulateHTML(doc *goquery.Selection, targetLang, translatedContent string) error {
-	translationID, err := generateContentID([]byte(translatedContent + targetLang))
+func manipulateHTML(doc *goquery.Selection, targetLang, translatedContent string, origin provenance) error {
+	translatedElement, translationID, err := newTranslatedElement(doc, targetLang, translatedContent, origin)
 	if err != nil {
 		return err
 	}
 
+	doc.SetAttr(util.TranslationByIdKey, translationID)
+	if isSentenceSegment(doc) {
+		placeSentenceTranslation(doc, translatedElement, targetLang)
+		return nil
+	}
+	doc.AfterSelection(translatedElement)
+
+	return nil
+}
+
+// newTranslatedElement clones the original element with the translated content.
+func newTranslatedElement(doc *goquery.Selection, targetLang, translatedContent string, origin provenance) (*goquery.Selection, string, error) {
+	translationID, err := generateContentID([]byte(translatedContent + targetLang))
+	if err != nil {
+		return nil, "", err
+	}
+
 	translatedElement := doc.Clone()
 	translatedElement.RemoveAttr(util.ContentIdKey)
 	translatedElement.SetHtml(translatedContent)
 	translatedElement.SetAttr(util.TranslationIdKey, translationID)
 	translatedElement.SetAttr(util.TranslationLangKey, targetLang)
+	origin.apply(translatedElement)
+	stampSource(translatedElement, doc)
 
-	doc.SetAttr(util.TranslationByIdKey, translationID)
-	doc.AfterSelection(translatedElement)
-
-	return nil
+	return translatedElement, translationID, nil
 }
 
+// writeContentToFile writes doc, read from filePath and edited, back to it.
 func writeContentToFile(filePath string, doc *goquery.Document) error {
-	file, err := os.Create(filePath)
+	data, err := serializeDocument(filePath, doc)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	html, err := doc.Html()
-	if err != nil {
-		return err
+	return writeBookFile(filePath, data)
+}
+
+// serializeDocument returns doc, read from filePath, as XHTML. Only what
+// changed is written anew: the rest keeps the bytes of the file, as strict
+// reading systems choke on the HTML goquery writes, e.g. <br> for <br/>.
+func serializeDocument(filePath string, doc *goquery.Document) ([]byte, error) {
+	return serializeNode(filePath, doc.Nodes[0])
+}
+
+// serializeNode is serializeDocument for a parse tree of the file.
+func serializeNode(filePath string, doc *html.Node) ([]byte, error) {
+	original, _ := os.ReadFile(filePath)
+	return renderXHTML(original, doc)
+}
+
+// renderXHTML writes doc, a parse tree of original, as XHTML over original,
+// or renders it whole if the two cannot be lined up.
+func renderXHTML(original []byte, doc *html.Node) ([]byte, error) {
+	if original != nil {
+		if data, err := xhtml.Splice(original, doc); err == nil {
+			return data, nil
+		}
 	}
 
-	_, err = file.WriteString(html)
-	return err
+	var buf bytes.Buffer
+	if err := xhtml.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func countWords(text string) int {
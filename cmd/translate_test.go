@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+func TestPauseGate(t *testing.T) {
+	var gate pauseGate
+	if err := gate.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gate.extend(50 * time.Millisecond)
+	gate.extend(10 * time.Millisecond) // a shorter pause does not cut it short
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := gate.wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+			if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+				t.Errorf("worker resumed after %s, before the pause ended", elapsed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	gate.extend(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := gate.wait(ctx); err == nil {
+		t.Error("wait() ignored the cancelled context")
+	}
+}
+
+func TestManipulateHTMLSentences(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>` +
+		`<p class="body"><span class="epubtrans-segment" data-content-id="s1">The storm came.</span> ` +
+		`<span class="epubtrans-segment" data-content-id="s2">It rained all night.</span> ` +
+		`<span class="epubtrans-segment" data-content-id="s3">By morning it was gone.</span></p>` +
+		`<p data-content-id="p2">Next paragraph.</p></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sentences are translated out of order, as concurrent batches may be.
+	for _, tt := range []struct{ id, translation string }{
+		{"s2", "Trời mưa suốt đêm."},
+		{"s3", "Đến sáng thì tạnh."},
+		{"s1", "Cơn bão đến."},
+	} {
+		span := doc.Find(`[data-content-id="` + tt.id + `"]`)
+		if err := manipulateHTML(span, "Vietnamese", tt.translation, provenance{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	original := doc.Find("p.body")
+	if original.Find("["+util.TranslationIdKey+"]").Length() != 0 {
+		t.Error("translated sentences were put inside the original paragraph")
+	}
+	translations := original.Next()
+	if !translations.HasClass(sentenceTranslationsClass) || goquery.NodeName(translations) != "p" {
+		t.Fatalf("the paragraph is followed by %s, want the translated sentences", goquery.NodeName(translations))
+	}
+	if got, want := translations.Text(), "Cơn bão đến. Trời mưa suốt đêm. Đến sáng thì tạnh."; got != want {
+		t.Errorf("translated sentences = %q, want %q", got, want)
+	}
+	if n := doc.Find("." + sentenceTranslationsClass).Length(); n != 1 {
+		t.Errorf("%d sentence translation blocks, want 1", n)
+	}
+	if translations.Next().AttrOr(util.ContentIdKey, "") != "p2" {
+		t.Error("the sentence translations were not put right after their paragraph")
+	}
+}
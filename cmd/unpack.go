@@ -36,6 +36,9 @@ var Unpack = &cobra.Command{
 		}); err != nil {
 			return fmt.Errorf("failed to unzip book: %w", err)
 		}
+		if err := takeSnapshot(unzipPath, "unpack"); err != nil {
+			return fmt.Errorf("failed to record the hashes of the files: %w", err)
+		}
 
 		cmd.Println("Unpacking completed successfully.")
 		return nil
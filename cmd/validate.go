@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var Validate = &cobra.Command{
+	Use:   "validate [unpackedEpubPath|book.epub]",
+	Short: "Check the unpacked EPUB for structural problems",
+	Long: `This command checks an unpacked EPUB for problems that break readers or bloat the book:
+files on disk that are missing from the manifest, manifest items whose file does not exist,
+duplicate manifest entries and files with identical content. It also checks that every entry of the page
+list leads to its page break, so that page-number citations of the print edition still resolve.
+Like epubcheck, it also checks the mimetype file, META-INF/container.xml, the links between the documents and
+that every XHTML document is well-formed.
+With --fix, orphan files are added to the manifest and entries of missing files are removed.
+
+Given a packed .epub file, it checks the archive as a reader opens it: the mimetype entry, which must come first and
+uncompressed, the manifest against the files and the same structure, links and XHTML checks.`,
+	Example: `epubtrans validate path/to/unpacked/epub --fix
+epubtrans validate book.epub`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unpackedEpubPath is required. Please provide the path to the unpacked EPUB directory.")
+		}
+		if isPackedEpub(args[0]) {
+			return nil
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runValidate,
+}
+
+func init() {
+	Validate.Flags().Bool("fix", false, "add orphan files to the manifest and remove entries of missing files")
+}
+
+// resourceReport lists the resource problems of a book.
+type resourceReport struct {
+	Orphans        []string   // files on disk that are not in the manifest, relative to the content dir
+	Missing        []string   // manifest ids whose file does not exist
+	DuplicateHrefs [][]string // groups of manifest ids sharing one href
+	DuplicateIDs   []string
+	IdenticalFiles [][]string // groups of files with the same content
+}
+
+func (r *resourceReport) problems() int {
+	return len(r.Orphans) + len(r.Missing) + len(r.DuplicateHrefs) + len(r.DuplicateIDs)
+}
+
+// isPackedEpub reports whether p is a packed EPUB rather than a directory.
+func isPackedEpub(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && !info.IsDir() && isEpubFile(p)
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	fix, _ := cmd.Flags().GetBool("fix")
+
+	if isPackedEpub(unzipPath) {
+		problems, err := checkArchive(unzipPath)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems", len(problems))
+		}
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return err
+	}
+
+	report, err := checkResources(unzipPath, book)
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range report.Orphans {
+		fmt.Printf("Orphan file (not in manifest): %s\n", orphan)
+	}
+	for _, id := range report.Missing {
+		fmt.Printf("Missing file for manifest item %q: %s\n", id, book.pkg.Manifest.GetItemByID(id).Href)
+	}
+	for _, ids := range report.DuplicateHrefs {
+		fmt.Printf("Duplicate manifest entries for %s: %s\n", book.pkg.Manifest.GetItemByID(ids[0]).Href, strings.Join(ids, ", "))
+	}
+	for _, id := range report.DuplicateIDs {
+		fmt.Printf("Duplicate manifest id: %s\n", id)
+	}
+	for _, files := range report.IdenticalFiles {
+		fmt.Printf("Identical content: %s\n", strings.Join(files, ", "))
+	}
+
+	broken, err := brokenPageTargets(book)
+	if err != nil {
+		return err
+	}
+	for _, t := range broken {
+		fmt.Printf("Broken page list entry in %s: %s\n", filepath.Base(t.Source), t)
+	}
+
+	structure, err := checkContent(os.DirFS(unzipPath), false)
+	if err != nil {
+		return err
+	}
+	for _, problem := range structure {
+		fmt.Println(problem)
+	}
+
+	if !fix {
+		if report.problems() > 0 {
+			return fmt.Errorf("found %d problems, run with --fix to repair the manifest", report.problems()+len(broken)+len(structure))
+		}
+		if len(broken)+len(structure) > 0 {
+			return fmt.Errorf("found %d problems", len(broken)+len(structure))
+		}
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	if err := fixResources(book, report); err != nil {
+		return err
+	}
+
+	fmt.Printf("Fixed manifest: %d files added, %d entries removed\n", len(report.Orphans), len(report.Missing))
+	if len(broken)+len(structure) > 0 {
+		return fmt.Errorf("found %d problems, which --fix does not repair", len(broken)+len(structure))
+	}
+	return nil
+}
+
+func checkResources(unzipPath string, book *bookFiles) (*resourceReport, error) {
+	report := &resourceReport{}
+
+	inManifest := make(map[string]bool)
+	hrefIDs := make(map[string][]string)
+	seenIDs := make(map[string]bool)
+
+	for _, item := range book.pkg.Manifest.Items {
+		filePath := filepath.Join(book.contentDir, item.Href)
+		inManifest[filePath] = true
+		hrefIDs[filePath] = append(hrefIDs[filePath], item.ID)
+
+		if seenIDs[item.ID] {
+			report.DuplicateIDs = append(report.DuplicateIDs, item.ID)
+		}
+		seenIDs[item.ID] = true
+
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			report.Missing = append(report.Missing, item.ID)
+		}
+	}
+
+	for _, ids := range hrefIDs {
+		if len(ids) > 1 {
+			report.DuplicateHrefs = append(report.DuplicateHrefs, ids)
+		}
+	}
+	sort.Slice(report.DuplicateHrefs, func(i, j int) bool {
+		return report.DuplicateHrefs[i][0] < report.DuplicateHrefs[j][0]
+	})
+
+	hashes := make(map[string][]string)
+	err := filepath.Walk(unzipPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(unzipPath, filePath)
+		if info.IsDir() {
+			if rel == "META-INF" || (strings.HasPrefix(info.Name(), ".") && rel != ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if rel == "mimetype" || filePath == book.opfPath || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		if !inManifest[filePath] {
+			orphan, _ := filepath.Rel(book.contentDir, filePath)
+			report.Orphans = append(report.Orphans, filepath.ToSlash(orphan))
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(content)
+		key := hex.EncodeToString(hash[:])
+		hashes[key] = append(hashes[key], filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking %s: %w", unzipPath, err)
+	}
+
+	for _, files := range hashes {
+		if len(files) > 1 {
+			report.IdenticalFiles = append(report.IdenticalFiles, files)
+		}
+	}
+	sort.Slice(report.IdenticalFiles, func(i, j int) bool {
+		return report.IdenticalFiles[i][0] < report.IdenticalFiles[j][0]
+	})
+
+	return report, nil
+}
+
+// fixResources adds orphan files to the manifest and removes the entries (and
+// spine references) of missing files.
+func fixResources(book *bookFiles, report *resourceReport) error {
+	content, err := os.ReadFile(book.opfPath)
+	if err != nil {
+		return err
+	}
+	opf := string(content)
+
+	for _, id := range report.Missing {
+		opf = removeTag(opf, "item", "id", id)
+		opf = removeTag(opf, "itemref", "idref", id)
+	}
+
+	var items []string
+	for i, orphan := range report.Orphans {
+		id := fmt.Sprintf("epubtrans-res-%d", i+1)
+		for book.pkg.Manifest.GetItemByID(id) != nil {
+			id += "-x"
+		}
+		items = append(items, fmt.Sprintf(`<item id="%s" href="%s" media-type="%s"/>`,
+			id, html.EscapeString(orphan), mediaTypeByExtension(orphan)))
+	}
+
+	if len(items) > 0 {
+		loc := manifestCloseRegex.FindStringIndex(opf)
+		if loc == nil {
+			return fmt.Errorf("no manifest found in %s", book.opfPath)
+		}
+		opf = opf[:loc[0]] + strings.Join(items, "\n") + "\n" + opf[loc[0]:]
+	}
+
+	return writeBookFile(book.opfPath, []byte(opf))
+}
+
+var epubMediaTypes = map[string]string{
+	".xhtml": "application/xhtml+xml",
+	".html":  "application/xhtml+xml",
+	".htm":   "application/xhtml+xml",
+	".css":   "text/css",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".png":   "image/png",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".svg":   "image/svg+xml",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ncx":   "application/x-dtbncx+xml",
+	".js":    "application/javascript",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".smil":  "application/smil+xml",
+}
+
+func mediaTypeByExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if mediaType, ok := epubMediaTypes[ext]; ok {
+		return mediaType
+	}
+	if mediaType := mime.TypeByExtension(ext); mediaType != "" {
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		return mediaType
+	}
+	return "application/octet-stream"
+}
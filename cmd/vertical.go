@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	verticalWritingRegex = regexp.MustCompile(`(?i)(?:-epub-|-webkit-)?writing-mode\s*:\s*(?:vertical-(?:rl|lr)|tb-rl)`)
+	// verticalDeclarationRegex matches a whole declaration, including its semicolon.
+	verticalDeclarationRegex = regexp.MustCompile(`(?i)(?:-epub-|-webkit-)?writing-mode\s*:\s*(?:vertical-(?:rl|lr)|tb-rl)\s*(?:!important)?\s*;?`)
+	pageProgressionRegex     = regexp.MustCompile(`(<(?:opf:)?spine\b[^>]*\bpage-progression-direction=")rtl(")`)
+	primaryWritingModeRegex  = regexp.MustCompile(`(<meta\b[^>]*\bname="primary-writing-mode"[^>]*\bcontent=")vertical-(?:rl|lr)(")`)
+)
+
+// isVerticalBook reports whether any stylesheet or document of the book sets a
+// vertical writing mode, as Japanese and Chinese books often do.
+func isVerticalBook(unzipPath string) (bool, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "text/css" && item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(book.contentDir, item.Href))
+		if err != nil {
+			continue
+		}
+
+		if verticalWritingRegex.Match(content) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// makeHorizontal strips vertical writing modes from stylesheets and documents
+// and switches the page progression to left-to-right, for editions that only
+// show the (horizontal) translation.
+func makeHorizontal(unzipPath string) error {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range book.pkg.Manifest.Items {
+		if item.MediaType != "text/css" && item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		filePath := filepath.Join(book.contentDir, item.Href)
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", filePath, err)
+		}
+
+		if !verticalWritingRegex.Match(content) {
+			continue
+		}
+
+		content = verticalDeclarationRegex.ReplaceAll(content, nil)
+		if err := writeBookFile(filePath, content); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", filePath, err)
+		}
+		fmt.Printf("Removed vertical writing mode from %s\n", item.Href)
+	}
+
+	opf, err := os.ReadFile(book.opfPath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", book.opfPath, err)
+	}
+
+	opf = pageProgressionRegex.ReplaceAll(opf, []byte("${1}ltr${2}"))
+	opf = primaryWritingModeRegex.ReplaceAll(opf, []byte("${1}horizontal-lr${2}"))
+
+	return writeBookFile(book.opfPath, opf)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakeHorizontal(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryBook(t, dir, "Vertical")
+	files := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Vertical</dc:title><meta name="primary-writing-mode" content="vertical-rl"/></metadata>
+  <manifest>
+    <item id="css" href="style.css" media-type="text/css"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="ch2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine page-progression-direction="rtl"><itemref idref="ch1"/><itemref idref="ch2"/></spine>
+</package>`,
+		"style.css": `html {
+  -epub-writing-mode: vertical-rl;
+  -webkit-writing-mode: vertical-rl !important;
+  writing-mode: tb-rl;
+  line-height: 1.8;
+}
+p { margin: 0 }`,
+		"ch1.xhtml": `<html><body style="writing-mode: vertical-lr; color: black"><p>縦書き</p></body></html>`,
+		"ch2.xhtml": `<html><body><p>Horizontal</p></body></html>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "OEBPS", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if vertical, err := isVerticalBook(dir); err != nil || !vertical {
+		t.Fatalf("isVerticalBook() = %v, %v, want true", vertical, err)
+	}
+
+	if err := makeHorizontal(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Vertical</dc:title><meta name="primary-writing-mode" content="horizontal-lr"/></metadata>
+  <manifest>
+    <item id="css" href="style.css" media-type="text/css"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="ch2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine page-progression-direction="ltr"><itemref idref="ch1"/><itemref idref="ch2"/></spine>
+</package>`,
+		// The declarations go, the rest of the rules stays.
+		"style.css": "html {\n  \n  \n  \n  line-height: 1.8;\n}\np { margin: 0 }",
+		"ch1.xhtml": `<html><body style=" color: black"><p>縦書き</p></body></html>`,
+		"ch2.xhtml": files["ch2.xhtml"],
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, "OEBPS", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+
+	if vertical, err := isVerticalBook(dir); err != nil || vertical {
+		t.Errorf("isVerticalBook() after makeHorizontal = %v, %v, want false", vertical, err)
+	}
+}
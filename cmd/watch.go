@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/translator"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+const watchStateFile = ".epubtrans-watch.json"
+
+var Watch = &cobra.Command{
+	Use:   "watch [inputDir]",
+	Short: "Watch a directory and translate new EPUB files as they appear",
+	Long: `This command periodically scans a directory for new EPUB files and runs the full
+pipeline (unpack, clean, mark, translate, pack) on each of them. Books that were already
+processed are remembered in a state file inside the directory, so the command can be left
+running or invoked from cron with --once.`,
+	Example: `epubtrans watch path/to/inbox --interval 30m --source "English" --target "Vietnamese"`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("inputDir is required. Please provide the directory to watch for EPUB files.")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runWatch,
+}
+
+func init() {
+	Watch.Flags().StringVar(&sourceLanguage, "source", "English", "source language")
+	Watch.Flags().StringVar(&targetLanguage, "target", "Vietnamese", "target language")
+	Watch.Flags().StringVar(&translationProvider, "provider", providerFromEnv(), "translation provider: "+strings.Join(translator.Providers(), ", "))
+	Watch.Flags().String("model", "", "model to use; defaults to the provider's default model")
+	Watch.Flags().StringVar(&deepLGlossaryID, "deepl-glossary", os.Getenv("DEEPL_GLOSSARY_ID"), "ID of a DeepL glossary to apply with --provider deepl")
+	Watch.Flags().Duration("interval", 10*time.Minute, "interval between directory scans")
+	Watch.Flags().Bool("once", false, "scan the directory once and exit")
+	Watch.Flags().Int("workers", runtime.NumCPU(), "Number of worker goroutines for clean and mark")
+	addSamplingFlags(Watch)
+}
+
+// watchState records which EPUB files have already been run through the pipeline.
+type watchState struct {
+	Processed map[string]time.Time `json:"processed"`
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	inputDir := args[0]
+	var err error
+	if translationSampling, err = samplingFromFlags(cmd); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	// Set up signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Println("Interrupt received, initiating graceful shutdown...")
+		cancel()
+	}()
+
+	interval, _ := cmd.Flags().GetDuration("interval")
+	once, _ := cmd.Flags().GetBool("once")
+	workers, _ := cmd.Flags().GetInt("workers")
+	model := cmd.Flag("model").Value.String()
+
+	if interval <= 0 {
+		return fmt.Errorf("interval must be greater than 0")
+	}
+	if workers <= 0 {
+		return fmt.Errorf("workers must be greater than 0")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := scanWatchDir(ctx, inputDir, model, workers); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Printf("Error scanning %s: %v\n", inputDir, err)
+		}
+
+		if once {
+			return nil
+		}
+
+		fmt.Printf("Next scan in %s\n", interval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func scanWatchDir(ctx context.Context, inputDir, model string, workers int) error {
+	state, err := loadWatchState(inputDir)
+	if err != nil {
+		return err
+	}
+
+	books, err := findNewEpubs(inputDir, state)
+	if err != nil {
+		return err
+	}
+
+	if len(books) == 0 {
+		fmt.Printf("No new EPUB files in %s\n", inputDir)
+		return nil
+	}
+
+	for _, book := range books {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		fmt.Printf("\nRunning pipeline for %s\n", filepath.Base(book))
+		if err := runPipeline(ctx, book, model, workers); err != nil {
+			// Leave the book unrecorded so the next scan retries it.
+			fmt.Printf("Pipeline failed for %s: %v\n", filepath.Base(book), err)
+			continue
+		}
+
+		state.Processed[filepath.Base(book)] = time.Now()
+		if err := saveWatchState(inputDir, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findNewEpubs(inputDir string, state *watchState) ([]string, error) {
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %s: %w", inputDir, err)
+	}
+
+	var books []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isEpubFile(name) {
+			continue
+		}
+
+		// Skip the books produced by previous runs.
+		if strings.Contains(name, strings.TrimSuffix(defaultSuffix, ".epub")) {
+			continue
+		}
+
+		if _, done := state.Processed[name]; done {
+			continue
+		}
+
+		books = append(books, filepath.Join(inputDir, name))
+	}
+
+	sort.Strings(books)
+	return books, nil
+}
+
+func runPipeline(ctx context.Context, epubPath, model string, workers int) error {
+	unzipPath, err := util.GetUnzipDestination(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to determine unzip destination: %w", err)
+	}
+
+	// Reuse an existing unpacked directory so an interrupted run resumes where it stopped.
+	if _, err := os.Stat(unzipPath); os.IsNotExist(err) {
+		if err := unzipBook(epubPath, unzipPath, func(format string, a ...interface{}) error {
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to unzip book: %w", err)
+		}
+		if err := takeSnapshot(unzipPath, "unpack"); err != nil {
+			return err
+		}
+	}
+
+	if err := cleanBook(ctx, unzipPath, workers); err != nil {
+		return fmt.Errorf("clean: %w", err)
+	}
+
+	if err := markBook(ctx, unzipPath, workers); err != nil {
+		return fmt.Errorf("mark: %w", err)
+	}
+
+	if err := translateBook(ctx, unzipPath, model); err != nil {
+		return fmt.Errorf("translate: %w", err)
+	}
+
+	if err := packFiles(unzipPath, "", nil, nil, nil); err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+
+	return takeSnapshot(unzipPath, "pack")
+}
+
+func loadWatchState(inputDir string) (*watchState, error) {
+	state := &watchState{Processed: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(filepath.Join(inputDir, watchStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("reading watch state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing watch state: %w", err)
+	}
+	if state.Processed == nil {
+		state.Processed = make(map[string]time.Time)
+	}
+
+	return state, nil
+}
+
+func saveWatchState(inputDir string, state *watchState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling watch state: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(inputDir, watchStateFile), data, 0644); err != nil {
+		return fmt.Errorf("writing watch state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dutchsteven/epubtrans/pkg/processor"
+	"github.com/dutchsteven/epubtrans/pkg/tm"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/dutchsteven/epubtrans/pkg/xliff"
+	"github.com/spf13/cobra"
+)
+
+var ExportXLIFF = &cobra.Command{
+	Use:   "export-xliff [unpackedEpubPath] [outputDir]",
+	Short: "Export the segments of a book as XLIFF 2.1 files for CAT tools",
+	Long: `This command writes the marked segments of the book as XLIFF 2.1 files, one per document of the spine, for
+translators working in CAT tools. Every segment is a unit identified by its content id, with its translation as the
+target if it has one. The inline markup of a segment becomes inline codes. The state of a unit tells how far its
+translation is: initial (untranslated), translated (machine-translated), reviewed (edited by hand) or final
+(approved); review notes and stale translations are written as notes. Bring the edited files back with import-xliff.`,
+	Example: `epubtrans export-xliff path/to/unpacked/epub xliff/ --source English`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("unpackedEpubPath and an output directory are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runExportXLIFF,
+}
+
+var ImportXLIFF = &cobra.Command{
+	Use:   "import-xliff [unpackedEpubPath] [file.xlf...]",
+	Short: "Merge the translations of XLIFF files into a book",
+	Long: `This command writes the targets of XLIFF 2.x files, e.g. exported with export-xliff and edited in a CAT tool, into
+the book as translations edited by hand, recorded in the edit history like edits in serve. Units are matched by
+content id. Units without a complete target, units whose source no longer matches the original and unchanged
+translations are left alone. The markup of the targets is cleaned like edits in serve. Units in the final state
+are approved in the review.`,
+	Example: `epubtrans import-xliff path/to/unpacked/epub xliff/*.xlf`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("unpackedEpubPath and at least one XLIFF file are required")
+		}
+
+		return util.ValidateEpubPath(args[0])
+	},
+	RunE: runImportXLIFF,
+}
+
+func init() {
+	ExportXLIFF.Flags().String("source", "English", "language of the original text")
+	ExportXLIFF.Flags().String("target", "", "language of the translations (default: the language of the translations in the book)")
+}
+
+// xliffStates are the XLIFF states of the segment statuses.
+var xliffStates = map[string]string{
+	statusUntranslated: xliff.StateInitial,
+	statusMachine:      xliff.StateTranslated,
+	statusEdited:       xliff.StateReviewed,
+	statusApproved:     xliff.StateFinal,
+	statusRejected:     xliff.StateTranslated,
+}
+
+// xliffFileName returns the name of the XLIFF file of the document href.
+func xliffFileName(href string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(href, path.Ext(href)), "/", "_") + ".xlf"
+}
+
+func runExportXLIFF(cmd *cobra.Command, args []string) error {
+	source, _ := cmd.Flags().GetString("source")
+	target, _ := cmd.Flags().GetString("target")
+	unzipPath, outputDir := args[0], args[1]
+
+	docs, err := exportXLIFF(unzipPath, source, target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	units := 0
+	for _, doc := range docs {
+		name := filepath.Join(outputDir, xliffFileName(doc.Files[0].Original))
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := xliff.Write(f, doc); err != nil {
+			f.Close()
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		units += len(doc.Files[0].Units)
+	}
+
+	fmt.Printf("Exported %d segments of %d documents to %s\n", units, len(docs), outputDir)
+	return nil
+}
+
+// exportXLIFF returns an XLIFF document for every document of the spine
+// with segments, in reading order.
+func exportXLIFF(unzipPath, sourceLang, targetLang string) ([]xliff.Document, error) {
+	book, err := openBookFiles(unzipPath)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := loadReviewStore(reviewStorePath(unzipPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []xliff.Document
+	for _, item := range processor.ReadingOrder(book.pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		segments, err := fileSegments(book, item.Href, reviews, newSegmentLocks())
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", item.Href, err)
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		file := xliff.File{ID: "f1", Original: item.Href}
+		for _, segment := range segments {
+			if targetLang == "" && segment.Lang != "" {
+				targetLang = segment.Lang
+			}
+			unit := xliff.Unit{ID: segmentUnitID(segment.ContentID, segment.Occurrence), Source: segment.Source, Target: segment.Translation, State: xliffStates[segment.Status]}
+			if segment.Review != nil && segment.Review.Note != "" {
+				unit.Notes = append(unit.Notes, fmt.Sprintf("Review (%s): %s", segment.Review.Status, segment.Review.Note))
+			}
+			if segment.Stale {
+				unit.Notes = append(unit.Notes, "The original changed since it was translated.")
+			}
+			file.Units = append(file.Units, unit)
+		}
+		docs = append(docs, xliff.Document{SrcLang: tm.LanguageCode(sourceLang), Files: []xliff.File{file}})
+	}
+
+	for i := range docs {
+		docs[i].TrgLang = tm.LanguageCode(targetLang)
+	}
+	return docs, nil
+}
+
+func runImportXLIFF(cmd *cobra.Command, args []string) error {
+	unzipPath := args[0]
+	for _, name := range args[1:] {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		doc, err := xliff.Read(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		result, err := importXLIFF(unzipPath, doc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, skipped := range result.Skipped {
+			fmt.Printf("  skipped %s\n", skipped)
+		}
+		fmt.Printf("Imported %d translations from %s (%d unchanged, %d skipped)\n", result.Applied, name, result.Unchanged, len(result.Skipped))
+	}
+	return nil
+}
+
+// importXLIFF writes the translated units of doc into the book.
+func importXLIFF(unzipPath string, doc xliff.Document) (segmentImport, error) {
+	lang := ""
+	if doc.TrgLang != "" {
+		lang = tm.LanguageName(doc.TrgLang)
+	}
+
+	var edits []segmentEdit
+	for _, file := range doc.Files {
+		for _, unit := range file.Units {
+			if strings.TrimSpace(unit.Target) == "" {
+				continue
+			}
+			edit := segmentEdit{File: file.Original, Source: unit.Source, Translation: unit.Target}
+			edit.ContentID, edit.Occurrence = splitSegmentUnitID(unit.ID)
+			if unit.State == xliff.StateFinal {
+				edit.Verdict = reviewApproved
+			}
+			edits = append(edits, edit)
+		}
+	}
+	return importSegmentEdits(unzipPath, lang, edits)
+}
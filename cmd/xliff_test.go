@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/xliff"
+)
+
+func TestXLIFFRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+
+	docs, err := exportXLIFF(dir, "English", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].SrcLang != "en" || docs[0].TrgLang != "de" {
+		t.Fatalf("exportXLIFF() = %+v", docs)
+	}
+	units := map[string]*xliff.Unit{}
+	for i := range docs[0].Files[0].Units {
+		unit := &docs[0].Files[0].Units[i]
+		units[unit.ID] = unit
+	}
+	if a := units["a"]; a.Source != "Hello" || a.Target != "Hallo" || a.State != xliff.StateTranslated {
+		t.Errorf("unit a = %+v", a)
+	}
+	if b := units["b"]; b.Target != "" || b.State != xliff.StateInitial {
+		t.Errorf("unit b = %+v", b)
+	}
+
+	// A translator edits a, translates b, approves c and works on d, whose
+	// original changes meanwhile.
+	units["a"].Target = "Guten <em>Tag</em>"
+	units["b"].Target = "Welt"
+	units["c"].State = xliff.StateFinal
+	units["d"].Target = "Zwo"
+	editSyncChapter(t, dir, ">Two<", ">Three<")
+
+	result, err := importXLIFF(dir, docs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied != 3 || len(result.Skipped) != 1 {
+		t.Errorf("importXLIFF() = %+v, want 3 applied and d skipped", result)
+	}
+
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := fileSegments(book, "ch1.xhtml", reviews, newSegmentLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{ translation, status string }{
+		"a": {"Guten <em>Tag</em>", statusEdited},
+		"b": {"Welt", statusEdited},
+		"c": {"Eins", statusApproved},
+		"d": {"Zwei", statusMachine},
+	}
+	for _, segment := range segments {
+		if w := want[segment.ContentID]; segment.Translation != w.translation || segment.Status != w.status {
+			t.Errorf("segment %s = %q, %s; want %q, %s", segment.ContentID, segment.Translation, segment.Status, w.translation, w.status)
+		}
+	}
+
+	// Importing the same file again changes nothing.
+	if result, err := importXLIFF(dir, docs[0]); err != nil || result.Applied != 0 || result.Unchanged != 3 {
+		t.Errorf("importing again = %+v, %v", result, err)
+	}
+}
+
+func TestXLIFFRepeatedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	writeSyncBook(t, dir)
+	// Three scene breaks share a content id, and the first two a translation.
+	chapter := `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title></head><body>
+<p data-content-id="r" data-translation-by-id="tr">Later that day</p><p data-translation-id="tr" data-translation-lang="de">Später am Tag</p>
+<p data-content-id="r" data-translation-by-id="tr">Later that day</p><p data-translation-id="tr" data-translation-lang="de">Später am Tag</p>
+<p data-content-id="r">Later that day</p>
+</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "OEBPS", "ch1.xhtml"), []byte(chapter), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := exportXLIFF(dir, "English", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := docs[0].Files[0].Units
+	if len(units) != 3 || units[0].ID != "r" || units[1].ID != "r.1" || units[2].ID != "r.2" {
+		t.Fatalf("units = %+v, want the ids r, r.1 and r.2", units)
+	}
+
+	rows, err := segmentRows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[2][1] != "r.1" || rows[3][1] != "r.2" {
+		t.Errorf("segmentRows() = %q, want the ids r, r.1 and r.2", rows)
+	}
+
+	units[1].Target = "Am Nachmittag"
+	units[2].Target = "Am Abend"
+	result, err := importXLIFF(dir, docs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied != 2 || len(result.Skipped) != 0 {
+		t.Errorf("importXLIFF() = %+v, want 2 applied", result)
+	}
+
+	book, err := openBookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviews, err := loadReviewStore(reviewStorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := fileSegments(book, "ch1.xhtml", reviews, newSegmentLocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Später am Tag", "Am Nachmittag", "Am Abend"}
+	if len(segments) != len(want) {
+		t.Fatalf("%d segments, want %d", len(segments), len(want))
+	}
+	for i, segment := range segments {
+		if segment.Occurrence != i || segment.Translation != want[i] {
+			t.Errorf("segment %d = occurrence %d, %q; want %q", i, segment.Occurrence, segment.Translation, want[i])
+		}
+	}
+}
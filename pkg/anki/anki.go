@@ -0,0 +1,310 @@
+// Package anki writes Anki decks as .apkg packages, which Anki imports with
+// File > Import. A package is a zip of a collection database in the schema of
+// Anki 2.1 and the media files the notes refer to.
+//
+// The collection must be an SQLite database, as Anki opens it with SQLite;
+// the bbolt and file stores of the translation cache cannot produce one.
+// modernc.org/sqlite is SQLite translated to Go, so the package needs no cgo
+// and epubtrans still cross-compiles into a single static binary.
+package anki
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Model is a note type: the fields of its notes and the template of their
+// single card, in Anki's {{Field}} syntax.
+type Model struct {
+	Name   string
+	Fields []string
+	Front  string
+	Back   string
+	CSS    string
+}
+
+// Note is one note of the deck. Fields follow the order of the model's fields
+// and hold HTML; sound files are included with [sound:name].
+type Note struct {
+	// GUID identifies the note across imports, so importing a deck again
+	// updates its notes instead of adding them twice. Empty derives it from
+	// the fields.
+	GUID   string
+	Fields []string
+	Tags   []string
+}
+
+// Deck is the content of one package.
+type Deck struct {
+	Name        string
+	Description string
+	Model       Model
+	Notes       []Note
+	// Media maps file names to the content of the files the notes refer to.
+	Media map[string][]byte
+}
+
+// WriteFile writes the deck as an .apkg package to path.
+func (d *Deck) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := d.Write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes the deck as an .apkg package to w.
+func (d *Deck) Write(w io.Writer) error {
+	for i, note := range d.Notes {
+		if len(note.Fields) != len(d.Model.Fields) {
+			return fmt.Errorf("note %d has %d fields, the model %d", i, len(note.Fields), len(d.Model.Fields))
+		}
+	}
+
+	// The database driver needs a file, which is copied into the zip.
+	dir, err := os.MkdirTemp("", "epubtrans-anki-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	collectionPath := filepath.Join(dir, "collection.anki2")
+	if err := d.writeCollection(collectionPath); err != nil {
+		return fmt.Errorf("writing the collection: %w", err)
+	}
+
+	z := zip.NewWriter(w)
+	collection, err := os.ReadFile(collectionPath)
+	if err != nil {
+		return err
+	}
+	if err := addZipFile(z, "collection.anki2", collection); err != nil {
+		return err
+	}
+
+	// Media files are stored under their index, the media file maps the
+	// indexes to their names.
+	names := make([]string, 0, len(d.Media))
+	for name := range d.Media {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	indexes := make(map[string]string, len(names))
+	for i, name := range names {
+		index := strconv.Itoa(i)
+		indexes[index] = name
+		if err := addZipFile(z, index, d.Media[name]); err != nil {
+			return err
+		}
+	}
+	media, err := json.Marshal(indexes)
+	if err != nil {
+		return err
+	}
+	if err := addZipFile(z, "media", media); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+func addZipFile(z *zip.Writer, name string, content []byte) error {
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+const schema = `
+CREATE TABLE col (
+	id integer primary key, crt integer not null, mod integer not null, scm integer not null,
+	ver integer not null, dty integer not null, usn integer not null, ls integer not null,
+	conf text not null, models text not null, decks text not null, dconf text not null, tags text not null
+);
+CREATE TABLE notes (
+	id integer primary key, guid text not null, mid integer not null, mod integer not null,
+	usn integer not null, tags text not null, flds text not null, sfld integer not null,
+	csum integer not null, flags integer not null, data text not null
+);
+CREATE TABLE cards (
+	id integer primary key, nid integer not null, did integer not null, ord integer not null,
+	mod integer not null, usn integer not null, type integer not null, queue integer not null,
+	due integer not null, ivl integer not null, factor integer not null, reps integer not null,
+	lapses integer not null, left integer not null, odue integer not null, odid integer not null,
+	flags integer not null, data text not null
+);
+CREATE TABLE revlog (
+	id integer primary key, cid integer not null, usn integer not null, ease integer not null,
+	ivl integer not null, lastIvl integer not null, factor integer not null, time integer not null,
+	type integer not null
+);
+CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null);
+CREATE INDEX ix_notes_usn on notes (usn);
+CREATE INDEX ix_cards_usn on cards (usn);
+CREATE INDEX ix_revlog_usn on revlog (usn);
+CREATE INDEX ix_cards_nid on cards (nid);
+CREATE INDEX ix_cards_sched on cards (did, queue, due);
+CREATE INDEX ix_revlog_cid on revlog (cid);
+CREATE INDEX ix_notes_csum on notes (csum);
+`
+
+// defaultDeckConfig is the deck options Anki creates new collections with.
+const defaultDeckConfig = `{"1": {"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60, "autoplay": true,
+"timer": 0, "replayq": true, "dyn": false,
+"new": {"bury": true, "delays": [1, 10], "initialFactor": 2500, "ints": [1, 4, 7], "order": 1, "perDay": 20, "separate": true},
+"lapse": {"delays": [10], "leechAction": 0, "leechFails": 8, "minInt": 1, "mult": 0},
+"rev": {"bury": true, "ease4": 1.3, "fuzz": 0.05, "ivlFct": 1, "maxIvl": 36500, "minSpace": 1, "perDay": 100}}}`
+
+func (d *Deck) writeCollection(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	mod := now.Unix()
+	deckID, modelID := stableID("deck", d.Name), stableID("model", d.Model.Name)
+
+	models, err := json.Marshal(map[string]any{strconv.FormatInt(modelID, 10): d.Model.json(modelID, deckID, mod)})
+	if err != nil {
+		return err
+	}
+	decks, err := json.Marshal(map[string]any{
+		"1":                           deckJSON(1, "Default", "", mod),
+		strconv.FormatInt(deckID, 10): deckJSON(deckID, d.Name, d.Description, mod),
+	})
+	if err != nil {
+		return err
+	}
+	conf, err := json.Marshal(map[string]any{
+		"activeDecks": []int64{deckID}, "curDeck": deckID, "curModel": modelID, "newSpread": 0,
+		"collapseTime": 1200, "timeLim": 0, "estTimes": true, "dueCounts": true, "nextPos": len(d.Notes) + 1,
+		"sortType": "noteFld", "sortBackwards": false, "addToCur": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		mod, now.UnixMilli(), now.UnixMilli(), string(conf), string(models), string(decks), defaultDeckConfig); err != nil {
+		return err
+	}
+
+	// Notes and cards need unique IDs, which Anki takes from the time they
+	// were created in milliseconds.
+	baseID := now.UnixMilli()
+	for i, note := range d.Notes {
+		guid := note.GUID
+		if guid == "" {
+			guid = strings.Join(note.Fields, "\x1f")
+		}
+		tags := ""
+		if len(note.Tags) > 0 {
+			tags = " " + strings.Join(note.Tags, " ") + " "
+		}
+		sortField := stripHTML(note.Fields[0])
+
+		noteID := baseID + int64(i)
+		if _, err := tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+			noteID, guidOf(guid), modelID, mod, tags, strings.Join(note.Fields, "\x1f"), sortField, checksum(sortField)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+			noteID, noteID, deckID, mod, i+1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m Model) json(id, deckID, mod int64) map[string]any {
+	fields := make([]map[string]any, len(m.Fields))
+	for i, name := range m.Fields {
+		fields[i] = map[string]any{"name": name, "ord": i, "font": "Arial", "size": 20, "media": []string{}, "rtl": false, "sticky": false}
+	}
+
+	return map[string]any{
+		"id": id, "name": m.Name, "type": 0, "mod": mod, "usn": -1, "sortf": 0, "did": deckID,
+		"flds": fields, "css": m.CSS, "tags": []string{}, "vers": []int{},
+		"tmpls": []map[string]any{{
+			"name": "Card 1", "ord": 0, "qfmt": m.Front, "afmt": m.Back, "bqfmt": "", "bafmt": "", "did": nil,
+		}},
+		// The card is generated when the first field is not empty.
+		"req":       []any{[]any{0, "all", []int{0}}},
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+	}
+}
+
+func deckJSON(id int64, name, description string, mod int64) map[string]any {
+	return map[string]any{
+		"id": id, "name": name, "desc": description, "mod": mod, "usn": -1, "conf": 1, "dyn": 0,
+		"collapsed": false, "extendNew": 10, "extendRev": 50,
+		"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+	}
+}
+
+// stableID derives the ID of a deck or model from its name, so a deck
+// exported again is imported into the same deck with the same note type.
+// IDs stay below 2^53, which Anki's JavaScript can represent.
+func stableID(kind, name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(kind + "\x00" + name))
+	return int64(h.Sum64()>>12) | 1<<40
+}
+
+// guidOf shortens key to a GUID like the ones Anki generates.
+func guidOf(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return strconv.FormatUint(binary.BigEndian.Uint64(sum[:8]), 36)
+}
+
+var htmlTagRegex = regexp.MustCompile(`(?s)<[^>]*>|\[sound:[^\]]*\]`)
+
+func stripHTML(field string) string {
+	return strings.TrimSpace(htmlTagRegex.ReplaceAllString(field, ""))
+}
+
+// checksum is the duplicate check Anki runs on the first field: the first 8
+// hex digits of its SHA-1.
+func checksum(field string) int64 {
+	sum := sha1.Sum([]byte(field))
+	n, _ := strconv.ParseInt(hex.EncodeToString(sum[:4]), 16, 64)
+	return n
+}
@@ -0,0 +1,105 @@
+package anki
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeckWrite(t *testing.T) {
+	deck := &Deck{
+		Name:  "Book::001 chapter1",
+		Model: Model{Name: "Sentence", Fields: []string{"Original", "Translation"}, Front: "{{Original}}", Back: "{{Translation}}"},
+		Notes: []Note{
+			{GUID: "a", Fields: []string{"<b>Hello</b> [sound:hello.mp3]", "Xin chào"}, Tags: []string{"chapter1"}},
+			{Fields: []string{"Goodbye", "Tạm biệt"}},
+		},
+		Media: map[string][]byte{"hello.mp3": []byte("audio")},
+	}
+
+	path := filepath.Join(t.TempDir(), "deck.apkg")
+	if err := deck.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	var media map[string]string
+	if err := json.Unmarshal(files["media"], &media); err != nil {
+		t.Fatal(err)
+	}
+	if media["0"] != "hello.mp3" || string(files["0"]) != "audio" {
+		t.Errorf("media = %v, file 0 = %q", media, files["0"])
+	}
+
+	collectionPath := filepath.Join(t.TempDir(), "collection.anki2")
+	if err := os.WriteFile(collectionPath, files["collection.anki2"], 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", collectionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT n.flds, n.sfld, n.tags, c.due FROM notes n JOIN cards c ON c.nid = n.id ORDER BY c.due`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var fields, sortField, tags string
+		var due int
+		if err := rows.Scan(&fields, &sortField, &tags, &due); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{strings.ReplaceAll(fields, "\x1f", "|"), sortField, tags}, ";"))
+	}
+	want := []string{
+		"<b>Hello</b> [sound:hello.mp3]|Xin chào;Hello; chapter1 ",
+		"Goodbye|Tạm biệt;Goodbye;",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var decks string
+	if err := db.QueryRow(`SELECT decks FROM col`).Scan(&decks); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(decks, `"name":"Book::001 chapter1"`) {
+		t.Errorf("decks = %s", decks)
+	}
+}
+
+func TestDeckWriteFieldCount(t *testing.T) {
+	deck := &Deck{
+		Name:  "Book",
+		Model: Model{Name: "Sentence", Fields: []string{"Original", "Translation"}},
+		Notes: []Note{{Fields: []string{"only one"}}},
+	}
+	if err := deck.Write(io.Discard); err == nil {
+		t.Error("expected an error for a note with too few fields")
+	}
+}
@@ -0,0 +1,64 @@
+// Package bundle joins the scripts and stylesheets of the serve pages into
+// one script and one stylesheet per page. The bundles are built ahead of time
+// with go generate ./cmd and embedded into the binary, so serve fetches
+// nothing at runtime and a page loads two files instead of one per source.
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+)
+
+// Bundle is a file served to the pages and the sources it joins, in order.
+type Bundle struct {
+	Name    string
+	Sources []string
+}
+
+// Bundles are the bundles of the serve pages. theme comes first in every
+// bundle, as the other scripts call addThemeToggle and the stylesheets build
+// on its variables.
+var Bundles = []Bundle{
+	{Name: "theme.js", Sources: []string{"theme.js"}},
+	{Name: "theme.css", Sources: []string{"theme.css"}},
+	{Name: "editor.js", Sources: []string{"theme.js", "app.js"}},
+	{Name: "editor.css", Sources: []string{"theme.css", "app.css"}},
+	{Name: "review.js", Sources: []string{"theme.js", "review.js"}},
+	{Name: "review.css", Sources: []string{"theme.css", "review.css"}},
+}
+
+var importRegex = regexp.MustCompile(`(?m)^@import url\("([^"]+)"\);[ \t]*\n?`)
+
+// Build joins the sources of b read from fsys. A stylesheet's @import of a
+// source earlier in the bundle is dropped, since its rules are already in.
+func Build(fsys fs.FS, b Bundle) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("/* Generated by go generate ./cmd from cmd/assets; do not edit. */\n")
+
+	included := map[string]bool{}
+	for _, source := range b.Sources {
+		content, err := fs.ReadFile(fsys, source)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", source, err)
+		}
+		if path.Ext(source) == ".css" {
+			content = importRegex.ReplaceAllFunc(content, func(m []byte) []byte {
+				if included[string(importRegex.FindSubmatch(m)[1])] {
+					return nil
+				}
+				return m
+			})
+		}
+
+		fmt.Fprintf(&out, "\n/* %s */\n", source)
+		out.Write(content)
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			out.WriteByte('\n')
+		}
+		included[source] = true
+	}
+	return out.Bytes(), nil
+}
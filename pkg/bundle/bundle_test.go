@@ -0,0 +1,36 @@
+package bundle
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuild(t *testing.T) {
+	fsys := fstest.MapFS{
+		"theme.css": {Data: []byte(":root { --bg: white; }\n")},
+		"app.css":   {Data: []byte("@import url(\"theme.css\");\n@import url(\"fonts.css\");\n\nbody { color: black; }")},
+	}
+
+	got, err := Build(fsys, Bundle{Name: "editor.css", Sources: []string{"theme.css", "app.css"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `/* Generated by go generate ./cmd from cmd/assets; do not edit. */
+
+/* theme.css */
+:root { --bg: white; }
+
+/* app.css */
+@import url("fonts.css");
+
+body { color: black; }
+`
+	if string(got) != want {
+		t.Errorf("Build() = %q, want %q", got, want)
+	}
+
+	if _, err := Build(fsys, Bundle{Name: "editor.js", Sources: []string{"app.js"}}); err == nil || !strings.Contains(err.Error(), "app.js") {
+		t.Errorf("Build() of a missing source: error = %v", err)
+	}
+}
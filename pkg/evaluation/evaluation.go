@@ -0,0 +1,169 @@
+package evaluation
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	bleuMaxOrder = 4
+	chrFMaxOrder = 6
+	chrFBeta     = 2
+)
+
+// BLEUStats holds the n-gram counts needed to compute BLEU. Stats of several
+// segments can be added together to get a corpus level score.
+type BLEUStats struct {
+	Matches         [bleuMaxOrder]int
+	Totals          [bleuMaxOrder]int
+	HypothesisLen   int
+	ReferenceLength int
+}
+
+// ComputeBLEUStats collects the BLEU counts of a hypothesis against a single reference.
+func ComputeBLEUStats(hypothesis, reference string) BLEUStats {
+	hyp := Tokenize(hypothesis)
+	ref := Tokenize(reference)
+
+	stats := BLEUStats{
+		HypothesisLen:   len(hyp),
+		ReferenceLength: len(ref),
+	}
+
+	for n := 1; n <= bleuMaxOrder; n++ {
+		refCounts := countNGrams(ref, n)
+		for gram, count := range countNGrams(hyp, n) {
+			stats.Matches[n-1] += min(count, refCounts[gram])
+			stats.Totals[n-1] += count
+		}
+	}
+
+	return stats
+}
+
+// Add accumulates other into s.
+func (s *BLEUStats) Add(other BLEUStats) {
+	for i := range s.Matches {
+		s.Matches[i] += other.Matches[i]
+		s.Totals[i] += other.Totals[i]
+	}
+	s.HypothesisLen += other.HypothesisLen
+	s.ReferenceLength += other.ReferenceLength
+}
+
+// Score returns the BLEU score on a 0-100 scale.
+func (s BLEUStats) Score() float64 {
+	if s.HypothesisLen == 0 {
+		return 0
+	}
+
+	var logPrecision float64
+	for i := 0; i < bleuMaxOrder; i++ {
+		if s.Matches[i] == 0 || s.Totals[i] == 0 {
+			return 0
+		}
+		logPrecision += math.Log(float64(s.Matches[i]) / float64(s.Totals[i]))
+	}
+
+	brevityPenalty := 1.0
+	if s.HypothesisLen < s.ReferenceLength {
+		brevityPenalty = math.Exp(1 - float64(s.ReferenceLength)/float64(s.HypothesisLen))
+	}
+
+	return 100 * brevityPenalty * math.Exp(logPrecision/bleuMaxOrder)
+}
+
+// ChrFStats holds the character n-gram counts needed to compute chrF.
+type ChrFStats struct {
+	Matches         [chrFMaxOrder]int
+	HypothesisTotal [chrFMaxOrder]int
+	ReferenceTotal  [chrFMaxOrder]int
+}
+
+// ComputeChrFStats collects the chrF counts of a hypothesis against a single reference.
+func ComputeChrFStats(hypothesis, reference string) ChrFStats {
+	hyp := characters(hypothesis)
+	ref := characters(reference)
+
+	var stats ChrFStats
+	for n := 1; n <= chrFMaxOrder; n++ {
+		refCounts := countNGrams(ref, n)
+		hypCounts := countNGrams(hyp, n)
+
+		for gram, count := range hypCounts {
+			stats.Matches[n-1] += min(count, refCounts[gram])
+			stats.HypothesisTotal[n-1] += count
+		}
+		for _, count := range refCounts {
+			stats.ReferenceTotal[n-1] += count
+		}
+	}
+
+	return stats
+}
+
+// Add accumulates other into s.
+func (s *ChrFStats) Add(other ChrFStats) {
+	for i := range s.Matches {
+		s.Matches[i] += other.Matches[i]
+		s.HypothesisTotal[i] += other.HypothesisTotal[i]
+		s.ReferenceTotal[i] += other.ReferenceTotal[i]
+	}
+}
+
+// Score returns the chrF score (beta = 2) on a 0-100 scale.
+func (s ChrFStats) Score() float64 {
+	var precision, recall float64
+	orders := 0
+
+	for i := 0; i < chrFMaxOrder; i++ {
+		if s.HypothesisTotal[i] == 0 || s.ReferenceTotal[i] == 0 {
+			continue
+		}
+		precision += float64(s.Matches[i]) / float64(s.HypothesisTotal[i])
+		recall += float64(s.Matches[i]) / float64(s.ReferenceTotal[i])
+		orders++
+	}
+
+	if orders == 0 {
+		return 0
+	}
+
+	precision /= float64(orders)
+	recall /= float64(orders)
+	if precision == 0 && recall == 0 {
+		return 0
+	}
+
+	beta2 := float64(chrFBeta * chrFBeta)
+	return 100 * (1 + beta2) * precision * recall / (beta2*precision + recall)
+}
+
+var punctuationRegex = regexp.MustCompile(`([\p{P}\p{S}])`)
+
+// Tokenize lowercases text and splits it into words and punctuation marks.
+func Tokenize(text string) []string {
+	text = punctuationRegex.ReplaceAllString(strings.ToLower(text), " $1 ")
+	return strings.Fields(text)
+}
+
+// characters returns the characters of text with whitespace removed, as chrF expects.
+func characters(text string) []string {
+	var chars []string
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			chars = append(chars, string(r))
+		}
+	}
+	return chars
+}
+
+func countNGrams(tokens []string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i+n <= len(tokens); i++ {
+		counts[strings.Join(tokens[i:i+n], "\x00")]++
+	}
+	return counts
+}
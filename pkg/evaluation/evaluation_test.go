@@ -0,0 +1,77 @@
+package evaluation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBLEU(t *testing.T) {
+	tests := []struct {
+		name       string
+		hypothesis string
+		reference  string
+		want       float64
+	}{
+		{
+			name:       "Identical text",
+			hypothesis: "The cat sat on the mat.",
+			reference:  "The cat sat on the mat.",
+			want:       100,
+		},
+		{
+			name:       "No overlap",
+			hypothesis: "completely different words here",
+			reference:  "the cat sat on the mat",
+			want:       0,
+		},
+		{
+			name:       "Empty hypothesis",
+			hypothesis: "",
+			reference:  "the cat sat on the mat",
+			want:       0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeBLEUStats(tt.hypothesis, tt.reference).Score()
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("BLEU = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBLEUBrevityPenalty(t *testing.T) {
+	full := ComputeBLEUStats("the cat sat on the mat today", "the cat sat on the mat today").Score()
+	short := ComputeBLEUStats("the cat sat on the", "the cat sat on the mat today").Score()
+
+	if short <= 0 || short >= full {
+		t.Errorf("BLEU of a truncated hypothesis = %v, want between 0 and %v", short, full)
+	}
+}
+
+func TestChrF(t *testing.T) {
+	if got := ComputeChrFStats("xin chào", "xin chào").Score(); math.Abs(got-100) > 1e-9 {
+		t.Errorf("chrF of identical text = %v, want 100", got)
+	}
+
+	close := ComputeChrFStats("xin chao ban", "xin chào bạn").Score()
+	far := ComputeChrFStats("tạm biệt", "xin chào bạn").Score()
+	if close <= far {
+		t.Errorf("chrF close = %v should be greater than far = %v", close, far)
+	}
+}
+
+func TestStatsAdd(t *testing.T) {
+	var bleu BLEUStats
+	bleu.Add(ComputeBLEUStats("a b c d", "a b c d"))
+	bleu.Add(ComputeBLEUStats("e f g h", "e f g h"))
+
+	if got := bleu.Score(); math.Abs(got-100) > 1e-9 {
+		t.Errorf("corpus BLEU = %v, want 100", got)
+	}
+	if bleu.HypothesisLen != 8 {
+		t.Errorf("HypothesisLen = %d, want 8", bleu.HypothesisLen)
+	}
+}
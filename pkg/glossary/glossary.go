@@ -0,0 +1,267 @@
+// Package glossary holds the preferred translations of the terms of a book.
+// A glossary is kept as YAML (a mapping of term to translation) or as CSV
+// (term,translation rows), is added to the prompt and is checked against
+// every translation.
+package glossary
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Term is a source term and its preferred translation.
+type Term struct {
+	Source string
+	Target string
+}
+
+// Glossary is a set of terms, looked up case-insensitively.
+type Glossary struct {
+	terms map[string]Term
+}
+
+// New returns an empty glossary.
+func New() *Glossary {
+	return &Glossary{terms: make(map[string]Term)}
+}
+
+// Load reads a glossary from a .yaml, .yml or .csv file.
+// A missing file yields an empty glossary.
+func Load(filePath string) (*Glossary, error) {
+	format, err := formatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	g := New()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return g, nil
+		}
+		return nil, fmt.Errorf("reading glossary: %w", err)
+	}
+
+	var terms []Term
+	if format == "csv" {
+		terms, err = parseCSV(data)
+	} else {
+		terms, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing glossary %s: %w", filePath, err)
+	}
+
+	for _, t := range terms {
+		g.Add(t.Source, t.Target)
+	}
+
+	return g, nil
+}
+
+// Save writes the glossary to filePath, as CSV or YAML depending on its extension.
+func (g *Glossary) Save(filePath string) error {
+	format, err := formatOf(filePath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if format == "csv" {
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"term", "translation"})
+		for _, t := range g.Terms() {
+			w.Write([]string{t.Source, t.Target})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("encoding glossary: %w", err)
+		}
+	} else {
+		// yaml.v3 writes mapping keys sorted.
+		m := make(map[string]string, len(g.terms))
+		for _, t := range g.terms {
+			m[t.Source] = t.Target
+		}
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(m); err != nil {
+			return fmt.Errorf("encoding glossary: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing glossary: %w", err)
+	}
+
+	return nil
+}
+
+// Add stores or replaces the translation of source.
+func (g *Glossary) Add(source, target string) {
+	source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+	if source == "" || target == "" {
+		return
+	}
+	g.terms[key(source)] = Term{Source: source, Target: target}
+}
+
+// Remove deletes source and reports whether it was in the glossary.
+func (g *Glossary) Remove(source string) bool {
+	k := key(source)
+	_, ok := g.terms[k]
+	delete(g.terms, k)
+	return ok
+}
+
+// Len returns the number of terms.
+func (g *Glossary) Len() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.terms)
+}
+
+// Terms returns all terms sorted by source term.
+func (g *Glossary) Terms() []Term {
+	if g == nil {
+		return nil
+	}
+
+	terms := make([]Term, 0, len(g.terms))
+	for _, t := range g.terms {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return key(terms[i].Source) < key(terms[j].Source)
+	})
+
+	return terms
+}
+
+// Prompt returns the instructions that make the model use the glossary, or ""
+// for an empty glossary.
+func (g *Glossary) Prompt() string {
+	if g.Len() == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Glossary (always translate these terms as given):\n")
+	for _, t := range g.Terms() {
+		fmt.Fprintf(&sb, "- %s → %s\n", t.Source, t.Target)
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+// Check returns the terms that occur in the source text but whose preferred
+// translation is missing from the translated text. Both are plain text.
+func (g *Glossary) Check(source, translated string) []Term {
+	var missing []Term
+
+	translated = strings.ToLower(translated)
+	for _, t := range g.Terms() {
+		if containsWord(source, t.Source) && !strings.Contains(translated, strings.ToLower(t.Target)) {
+			missing = append(missing, t)
+		}
+	}
+
+	return missing
+}
+
+// containsWord reports whether text contains term as a whole word, ignoring
+// case. Terms in scripts without spaces, such as Chinese, match anywhere.
+func containsWord(text, term string) bool {
+	text, term = strings.ToLower(text), strings.ToLower(term)
+
+	for from := 0; ; {
+		i := strings.Index(text[from:], term)
+		if i == -1 {
+			return false
+		}
+		start, end := from+i, from+i+len(term)
+
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		first, _ := utf8.DecodeRuneInString(term)
+		last, _ := utf8.DecodeLastRuneInString(term)
+		if (start == 0 || !joins(before, first)) && (end == len(text) || !joins(last, after)) {
+			return true
+		}
+
+		from = start + 1
+	}
+}
+
+// joins reports whether a and b next to each other are part of one word.
+func joins(a, b rune) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return isWord(a) && isWord(b) && !unicode.Is(unicode.Han, a) && !unicode.Is(unicode.Han, b)
+}
+
+func parseYAML(data []byte) ([]Term, error) {
+	var m map[string]string
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	terms := make([]Term, 0, len(m))
+	for source, target := range m {
+		terms = append(terms, Term{Source: source, Target: target})
+	}
+
+	return terms, nil
+}
+
+func parseCSV(data []byte) ([]Term, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var terms []Term
+	for line := 0; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return terms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// An optional header row
+		if line == 0 && strings.EqualFold(record[0], "term") && strings.EqualFold(record[1], "translation") {
+			continue
+		}
+		terms = append(terms, Term{Source: record[0], Target: record[1]})
+	}
+}
+
+func formatOf(filePath string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".csv":
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("unsupported glossary format %q: use .yaml, .yml or .csv", filepath.Ext(filePath))
+	}
+}
+
+func key(source string) string {
+	return strings.ToLower(strings.TrimSpace(source))
+}
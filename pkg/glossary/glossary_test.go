@@ -0,0 +1,103 @@
+package glossary
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	g := New()
+	g.Add("Ministry of Magic", "Bộ Pháp thuật")
+	g.Add("wand", "đũa phép")
+	g.Add("魔杖", "đũa phép")
+
+	tests := []struct {
+		name       string
+		source     string
+		translated string
+		want       []Term
+	}{
+		{
+			name:       "preferred translation used",
+			source:     "He went to the ministry of magic.",
+			translated: "Anh ấy đến bộ Pháp thuật.",
+		},
+		{
+			name:       "preferred translation missing",
+			source:     "His wand broke.",
+			translated: "Cây gậy của anh ấy gãy.",
+			want:       []Term{{Source: "wand", Target: "đũa phép"}},
+		},
+		{
+			name:       "term only inside another word",
+			source:     "She wandered off.",
+			translated: "Cô ấy đi lang thang.",
+		},
+		{
+			name:       "term in a script without spaces",
+			source:     "他的魔杖断了。",
+			translated: "Cây gậy của anh ấy gãy.",
+			want:       []Term{{Source: "魔杖", Target: "đũa phép"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.Check(tt.source, tt.translated); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"glossary.yaml", "glossary.csv"} {
+		path := filepath.Join(dir, name)
+
+		g, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.Add("Hogwarts", "Hogwarts")
+		g.Add("Ministry of Magic", "Bộ Pháp thuật, \"Bộ\"")
+		if err := g.Save(path); err != nil {
+			t.Fatal(err)
+		}
+
+		g, err = Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Remove("ministry of magic") {
+			t.Errorf("%s: term not loaded", name)
+		}
+		if got := g.Terms(); len(got) != 1 || got[0].Target != "Hogwarts" {
+			t.Errorf("%s: Terms() = %v", name, got)
+		}
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glossary.csv")
+	data := "# names\nTerm,Translation\nMuggle, Muggle\n\"Diagon Alley\",Hẻm Xéo\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Term{{Source: "Diagon Alley", Target: "Hẻm Xéo"}, {Source: "Muggle", Target: "Muggle"}}
+	if got := g.Terms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms() = %v, want %v", got, want)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "glossary.txt")); err == nil {
+		t.Error("Load() accepted an unsupported format")
+	}
+}
@@ -0,0 +1,92 @@
+// Package hotspots lists the functions that take the most of a profile
+// written by runtime/pprof, without the pprof tool.
+package hotspots
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// Function is a function of a profile with its share of the samples.
+type Function struct {
+	Name string
+	// Flat sums the samples in the function itself, Cum those with the
+	// function anywhere on the stack.
+	Flat int64
+	Cum  int64
+}
+
+// Top returns the n functions with the highest flat value of sampleType, such
+// as "samples" of a CPU profile or "alloc_space" of an allocation profile,
+// and the total value of all samples.
+func Top(r io.Reader, sampleType string, n int) ([]Function, int64, error) {
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parsing profile: %w", err)
+	}
+
+	index := -1
+	for i, t := range p.SampleType {
+		if t.Type == sampleType {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, 0, fmt.Errorf("profile has no %s samples", sampleType)
+	}
+
+	byName := make(map[string]*Function)
+	function := func(name string) *Function {
+		f, ok := byName[name]
+		if !ok {
+			f = &Function{Name: name}
+			byName[name] = f
+		}
+		return f
+	}
+
+	var total int64
+	for _, s := range p.Sample {
+		value := s.Value[index]
+		total += value
+
+		seen := make(map[*Function]bool)
+		for i, location := range s.Location {
+			// The first line of a location is the innermost inlined function.
+			for j, line := range location.Line {
+				if line.Function == nil {
+					continue
+				}
+				f := function(line.Function.Name)
+				if i == 0 && j == 0 {
+					f.Flat += value
+				}
+				if !seen[f] {
+					f.Cum += value
+					seen[f] = true
+				}
+			}
+		}
+	}
+
+	functions := make([]Function, 0, len(byName))
+	for _, f := range byName {
+		functions = append(functions, *f)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Flat != functions[j].Flat {
+			return functions[i].Flat > functions[j].Flat
+		}
+		if functions[i].Cum != functions[j].Cum {
+			return functions[i].Cum > functions[j].Cum
+		}
+		return functions[i].Name < functions[j].Name
+	})
+	if len(functions) > n {
+		functions = functions[:n]
+	}
+	return functions, total, nil
+}
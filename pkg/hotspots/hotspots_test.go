@@ -0,0 +1,59 @@
+package hotspots
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+var sink [][]byte
+
+//go:noinline
+func allocateBuffers() {
+	for i := 0; i < 1000; i++ {
+		sink = append(sink, make([]byte, 4096))
+	}
+}
+
+func TestTopAllocations(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+
+	allocateBuffers()
+	sink = nil
+	runtime.GC()
+
+	var profile bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&profile, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	functions, total, err := Top(&profile, "alloc_space", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) == 0 || !strings.HasSuffix(functions[0].Name, ".allocateBuffers") {
+		t.Fatalf("Top() = %+v, want allocateBuffers first", functions)
+	}
+	if functions[0].Flat < 1000*4096 || functions[0].Cum < functions[0].Flat || total < functions[0].Flat {
+		t.Errorf("allocateBuffers = %+v of %d bytes", functions[0], total)
+	}
+}
+
+func TestTopUnknownSampleType(t *testing.T) {
+	var profile bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&profile, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Top(&profile, "samples", 5); err == nil {
+		t.Error("Top() of a missing sample type succeeded")
+	}
+}
+
+func TestTopInvalidProfile(t *testing.T) {
+	if _, _, err := Top(strings.NewReader("not a profile"), "samples", 5); err == nil {
+		t.Error("Top() of an invalid profile succeeded")
+	}
+}
@@ -1,6 +1,7 @@
 package loader
 
 import (
+	"archive/zip"
 	"encoding/xml"
 	"os"
 	"path"
@@ -30,6 +31,7 @@ type Metadata struct {
 	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator" json:"creator"`
 	Publisher   string `xml:"http://purl.org/dc/elements/1.1/ publisher" json:"publisher"`
 	Description string `xml:"http://purl.org/dc/elements/1.1/ description" json:"description"`
+	Rights      string `xml:"http://purl.org/dc/elements/1.1/ rights" json:"rights"`
 	Metas       []Meta `xml:"meta" json:"metas"`
 }
 
@@ -71,7 +73,9 @@ type Spine struct {
 }
 
 type ItemRef struct {
-	IDRef string `xml:"idref,attr" json:"IDRef"`
+	IDRef      string `xml:"idref,attr" json:"IDRef"`
+	Linear     string `xml:"linear,attr,omitempty" json:"linear,omitempty"`
+	Properties string `xml:"properties,attr,omitempty" json:"properties,omitempty"`
 }
 
 func ParseContainer(filePath string) (*Container, error) {
@@ -111,3 +115,39 @@ func ParsePackage(filePath string) (*Package, error) {
 
 	return &pkg, nil
 }
+
+// ParseEpubPackage reads the package document directly from a packed EPUB file
+// without extracting it.
+func ParseEpubPackage(epubPath string) (*Package, error) {
+	if epubPath == "" {
+		return nil, errors.New("epubPath cannot be empty")
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to open epub file")
+	}
+	defer r.Close()
+
+	var container Container
+	if err := decodeZipEntry(&r.Reader, containerFilePath, &container); err != nil {
+		return nil, errors.WithMessage(err, "failed to decode container")
+	}
+
+	var pkg Package
+	if err := decodeZipEntry(&r.Reader, container.Rootfile.FullPath, &pkg); err != nil {
+		return nil, errors.WithMessage(err, "failed to decode package")
+	}
+
+	return &pkg, nil
+}
+
+func decodeZipEntry(r *zip.Reader, name string, v interface{}) error {
+	f, err := r.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return xml.NewDecoder(f).Decode(v)
+}
@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Entry is a single aligned source/target pair stored in the translation memory.
+type Entry struct {
+	Source         string `json:"source"`
+	Target         string `json:"target"`
+	SourceLanguage string `json:"source_language"`
+	TargetLanguage string `json:"target_language"`
+}
+
+// Memory is a thread-safe store of previously translated segments, keyed by
+// their source content and language pair.
+type Memory struct {
+	mu      sync.RWMutex
+	entries map[string]Entry
+}
+
+// New returns an empty translation memory.
+func New() *Memory {
+	return &Memory{entries: make(map[string]Entry)}
+}
+
+// Load reads a translation memory from filePath.
+// A missing file yields an empty memory.
+func Load(filePath string) (*Memory, error) {
+	m := New()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("reading translation memory: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing translation memory: %w", err)
+	}
+
+	for _, e := range entries {
+		m.Add(e.Source, e.Target, e.SourceLanguage, e.TargetLanguage)
+	}
+
+	return m, nil
+}
+
+// Save writes the translation memory to filePath as JSON.
+func (m *Memory) Save(filePath string) error {
+	data, err := json.MarshalIndent(m.Entries(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling translation memory: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("writing translation memory: %w", err)
+	}
+
+	return nil
+}
+
+// Lookup returns the stored translation for source, if any.
+func (m *Memory) Lookup(source, sourceLang, targetLang string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.entries[key(source, sourceLang, targetLang)]
+	return e.Target, ok
+}
+
+// Add stores or replaces the translation for source.
+func (m *Memory) Add(source, target, sourceLang, targetLang string) {
+	if strings.TrimSpace(source) == "" || strings.TrimSpace(target) == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key(source, sourceLang, targetLang)] = Entry{
+		Source:         strings.TrimSpace(source),
+		Target:         strings.TrimSpace(target),
+		SourceLanguage: sourceLang,
+		TargetLanguage: targetLang,
+	}
+}
+
+// Len returns the number of stored entries.
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.entries)
+}
+
+// Entries returns all entries sorted by source text.
+func (m *Memory) Entries() []Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Source != entries[j].Source {
+			return entries[i].Source < entries[j].Source
+		}
+		return entries[i].TargetLanguage < entries[j].TargetLanguage
+	})
+
+	return entries
+}
+
+func key(source, sourceLang, targetLang string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", strings.TrimSpace(source), strings.ToLower(sourceLang), strings.ToLower(targetLang))))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryLookup(t *testing.T) {
+	m := New()
+	m.Add("  Hello <em>world</em> ", "Xin chào <em>thế giới</em>", "English", "Vietnamese")
+
+	tests := []struct {
+		name       string
+		source     string
+		sourceLang string
+		targetLang string
+		want       string
+		wantOK     bool
+	}{
+		{
+			name:       "Exact match",
+			source:     "Hello <em>world</em>",
+			sourceLang: "English",
+			targetLang: "Vietnamese",
+			want:       "Xin chào <em>thế giới</em>",
+			wantOK:     true,
+		},
+		{
+			name:       "Language names are case insensitive",
+			source:     "Hello <em>world</em>",
+			sourceLang: "english",
+			targetLang: "VIETNAMESE",
+			want:       "Xin chào <em>thế giới</em>",
+			wantOK:     true,
+		},
+		{
+			name:       "Different target language",
+			source:     "Hello <em>world</em>",
+			sourceLang: "English",
+			targetLang: "French",
+			wantOK:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := m.Lookup(tt.source, tt.sourceLang, tt.targetLang)
+			if ok != tt.wantOK {
+				t.Fatalf("Lookup() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("Lookup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemorySaveLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "tm.json")
+
+	m := New()
+	m.Add("One", "Một", "English", "Vietnamese")
+	m.Add("Two", "Hai", "English", "Vietnamese")
+	m.Add("", "ignored", "English", "Vietnamese")
+
+	if err := m.Save(filePath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := Load(filePath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if loaded.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", loaded.Len())
+	}
+	if got, _ := loaded.Lookup("Two", "English", "Vietnamese"); got != "Hai" {
+		t.Errorf("Lookup() = %q, want %q", got, "Hai")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"sync"
 
 	"github.com/dutchsteven/epubtrans/pkg/loader"
 	"github.com/pkg/errors"
@@ -16,6 +17,10 @@ type Config struct {
 	Workers      int
 	JobBuffer    int
 	ResultBuffer int
+	// Completed, when set, is called with the outcome of every processed
+	// file once all files before it are processed too, so in reading order
+	// whatever order the workers finish in. Calls never overlap.
+	Completed func(filePath string, err error)
 }
 
 // EpubItemProcessor is a function type for processing individual EPUB items
@@ -36,6 +41,32 @@ func ProcessEpub(ctx context.Context, unzipPath string, cfg Config, processor Ep
 
 	contentDir := filepath.Dir(containerFileAbsPath)
 
+	var files []string
+	for _, item := range ReadingOrder(pkg) {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		if ShouldExcludeFile(item.Href) {
+			fmt.Printf("Excluded file: %s\n", item.Href)
+			continue
+		}
+		files = append(files, filepath.Join(contentDir, item.Href))
+	}
+
+	if cfg.Completed != nil {
+		seq := newSequencer(files, cfg.Completed)
+		// Files after one that was never processed, as when cancelled,
+		// are completed at the end.
+		defer seq.flush()
+		process := processor
+		processor = func(ctx context.Context, filePath string) error {
+			err := process(ctx, filePath)
+			seq.complete(filePath, err)
+			return err
+		}
+	}
+
 	jobs := make(chan string, cfg.JobBuffer)
 	results := make(chan error, cfg.ResultBuffer)
 
@@ -48,19 +79,10 @@ func ProcessEpub(ctx context.Context, unzipPath string, cfg Config, processor Ep
 		})
 	}
 
-	// Feed jobs
+	// Feed jobs in reading order, so the first chapters are done first
 	go func() {
 		defer close(jobs)
-		for _, item := range pkg.Manifest.Items {
-			if item.MediaType != "application/xhtml+xml" {
-				continue
-			}
-
-			if ShouldExcludeFile(item.Href) {
-				fmt.Printf("Excluded file: %s\n", item.Href)
-				continue
-			}
-			filePath := filepath.Join(contentDir, item.Href)
+		for _, filePath := range files {
 			select {
 			case jobs <- filePath:
 			case <-ctx.Done():
@@ -93,6 +115,30 @@ func ProcessEpub(ctx context.Context, unzipPath string, cfg Config, processor Ep
 	return nil
 }
 
+// ReadingOrder returns the manifest items in spine order, followed by the
+// items not in the spine in manifest order.
+func ReadingOrder(pkg *loader.Package) []loader.Item {
+	items := make([]loader.Item, 0, len(pkg.Manifest.Items))
+	inSpine := make(map[string]bool, len(pkg.Spine.ItemRefs))
+
+	for _, ref := range pkg.Spine.ItemRefs {
+		item := pkg.Manifest.GetItemByID(ref.IDRef)
+		if item == nil || inSpine[item.ID] {
+			continue
+		}
+		inSpine[item.ID] = true
+		items = append(items, *item)
+	}
+
+	for _, item := range pkg.Manifest.Items {
+		if !inSpine[item.ID] {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
 func worker(ctx context.Context, jobs <-chan string, results chan<- error, processor EpubItemProcessor) error {
 	for {
 		select {
@@ -107,6 +153,52 @@ func worker(ctx context.Context, jobs <-chan string, results chan<- error, proce
 	}
 }
 
+// sequencer hands the outcomes of files to completed in the order of files.
+type sequencer struct {
+	mu        sync.Mutex
+	files     []string
+	next      int
+	outcomes  map[string]error
+	finished  map[string]bool
+	completed func(filePath string, err error)
+}
+
+func newSequencer(files []string, completed func(filePath string, err error)) *sequencer {
+	return &sequencer{files: files, outcomes: make(map[string]error), finished: make(map[string]bool), completed: completed}
+}
+
+// complete records the outcome of filePath and passes on the outcomes of the
+// files no longer waiting for an earlier one.
+func (s *sequencer) complete(filePath string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outcomes[filePath], s.finished[filePath] = err, true
+	for s.next < len(s.files) && s.finished[s.files[s.next]] {
+		s.pass(s.files[s.next])
+		s.next++
+	}
+}
+
+// flush passes on the outcomes of the remaining finished files, skipping
+// those never processed.
+func (s *sequencer) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; s.next < len(s.files); s.next++ {
+		if s.finished[s.files[s.next]] {
+			s.pass(s.files[s.next])
+		}
+	}
+}
+
+// pass hands the outcome of filePath to completed. The caller holds s.mu.
+func (s *sequencer) pass(filePath string) {
+	s.completed(filePath, s.outcomes[filePath])
+	delete(s.outcomes, filePath)
+}
+
 var excludeRegex = regexp.MustCompile(`(?i)(preface|introduction|foreword|prologue|toc|table\s*of\s*contents|title|cover|copyright|colophon|dedication|acknowledgements?|about\s*the\s*author|bibliography|glossary|index|appendix|notes?|footnotes?|endnotes?|references|epub-meta|metadata|nav|ncx|opf|front\s*matter|back\s*matter|halftitle|frontispiece|epigraph|list\s*of\s*(figures|tables|illustrations)|copyright\s*page|series\s*page|reviews|praise|also\s*by\s*the\s*author|author\s*bio|publication\s*info|imprint|credits|permissions|disclaimer|errata|synopsis|summary|f\d+)`)
 
 // ShouldExcludeFile determines if a file should be excluded based on its name
@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/loader"
+)
+
+func TestReadingOrder(t *testing.T) {
+	pkg := &loader.Package{
+		Manifest: loader.Manifest{Items: []loader.Item{
+			{ID: "css", Href: "style.css"},
+			{ID: "ch2", Href: "ch2.xhtml"},
+			{ID: "notes", Href: "notes.xhtml"},
+			{ID: "ch1", Href: "ch1.xhtml"},
+		}},
+		Spine: loader.Spine{ItemRefs: []loader.ItemRef{
+			{IDRef: "ch1"}, {IDRef: "ch2"}, {IDRef: "ch1"}, {IDRef: "missing"},
+		}},
+	}
+
+	var got []string
+	for _, item := range ReadingOrder(pkg) {
+		got = append(got, item.ID)
+	}
+
+	want := []string{"ch1", "ch2", "css", "notes"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadingOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	var got []string
+	s := newSequencer([]string{"ch1", "ch2", "ch3", "ch4"}, func(filePath string, err error) {
+		got = append(got, filePath)
+	})
+
+	s.complete("ch2", nil)
+	if len(got) != 0 {
+		t.Fatalf("ch2 completed before ch1: %v", got)
+	}
+	s.complete("ch1", nil)
+	s.complete("ch4", nil)
+	// ch3 is never processed, as when the run is cancelled.
+	s.flush()
+
+	want := []string{"ch1", "ch2", "ch4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("completed = %v, want %v", got, want)
+	}
+}
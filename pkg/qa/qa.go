@@ -0,0 +1,212 @@
+// Package qa checks translations against rule packs of the mistakes a
+// language pair is prone to, such as false friends and word-for-word
+// translations of idioms. Packs are YAML files; those in rules/ ship with
+// epubtrans and others can be loaded from disk, so packs for more language
+// pairs can be contributed without changing the code.
+package qa
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/*.yaml
+var builtinRules embed.FS
+
+// Rule flags a translation that matches Target while its original matches
+// Source. Both are Go regular expressions matched without regard to case;
+// note that \b only knows ASCII letters.
+type Rule struct {
+	ID string `yaml:"id"`
+	// Source must match the original for the rule to apply; empty for every
+	// original.
+	Source string `yaml:"source,omitempty"`
+	// Target matches the mistake in the translation.
+	Target string `yaml:"target"`
+	// Message explains the mistake and the usual translation.
+	Message string `yaml:"message"`
+
+	source, target *regexp.Regexp
+}
+
+// Pack is a set of rules for translations from one language into another.
+type Pack struct {
+	Name string `yaml:"name"`
+	// Source and Target are language codes such as "en" and "vi". An empty
+	// Source makes the pack apply to translations from any language.
+	Source string `yaml:"source,omitempty"`
+	Target string `yaml:"target"`
+	Rules  []Rule `yaml:"rules"`
+}
+
+// Finding is a rule a translation broke.
+type Finding struct {
+	Pack    string
+	Rule    string
+	Message string
+	// Match is the text of the translation the rule matched.
+	Match string
+}
+
+// Parse reads a pack from YAML; name identifies the pack in errors and, if
+// the pack has no name, in findings.
+func Parse(data []byte, name string) (*Pack, error) {
+	var p Pack
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing QA rules %s: %w", name, err)
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	if p.Target == "" {
+		return nil, fmt.Errorf("QA rules %s: target language is required", name)
+	}
+
+	ids := make(map[string]bool)
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.ID == "" || r.Target == "" {
+			return nil, fmt.Errorf("QA rules %s: rule %d needs an id and a target pattern", name, i+1)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("QA rules %s: duplicate rule id %q", name, r.ID)
+		}
+		ids[r.ID] = true
+
+		var err error
+		if r.Source != "" {
+			if r.source, err = regexp.Compile("(?i)" + r.Source); err != nil {
+				return nil, fmt.Errorf("QA rules %s: rule %s: %w", name, r.ID, err)
+			}
+		}
+		if r.target, err = regexp.Compile("(?i)" + r.Target); err != nil {
+			return nil, fmt.Errorf("QA rules %s: rule %s: %w", name, r.ID, err)
+		}
+	}
+
+	return &p, nil
+}
+
+// Check returns the rules of the pack that the translation breaks. Both texts
+// are plain text.
+func (p *Pack) Check(source, translated string) []Finding {
+	var findings []Finding
+	for _, r := range p.Rules {
+		if r.source != nil && !r.source.MatchString(source) {
+			continue
+		}
+		if match := r.target.FindString(translated); match != "" {
+			findings = append(findings, Finding{Pack: p.Name, Rule: r.ID, Message: r.Message, Match: match})
+		}
+	}
+	return findings
+}
+
+// Set is a list of packs.
+type Set []*Pack
+
+// Builtin returns the packs that ship with epubtrans.
+func Builtin() Set {
+	files, _ := fs.Glob(builtinRules, "rules/*.yaml")
+	var set Set
+	for _, file := range files {
+		data, _ := builtinRules.ReadFile(file)
+		p, err := Parse(data, path.Base(file))
+		if err != nil {
+			// The embedded packs are covered by the tests.
+			panic(err)
+		}
+		set = append(set, p)
+	}
+	return set
+}
+
+// Load reads the packs of the given files, and of the .yaml and .yml files in
+// the given directories.
+func Load(paths ...string) (Set, error) {
+	var set Set
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading QA rules: %w", err)
+		}
+
+		files := []string{p}
+		if info.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, fmt.Errorf("reading QA rules: %w", err)
+			}
+			files = nil
+			for _, e := range entries {
+				switch strings.ToLower(filepath.Ext(e.Name())) {
+				case ".yaml", ".yml":
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+			sort.Strings(files)
+		}
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("reading QA rules: %w", err)
+			}
+			pack, err := Parse(data, filepath.Base(file))
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, pack)
+		}
+	}
+	return set, nil
+}
+
+// For returns the packs for translations from source into target, both
+// language codes such as "en" or "pt-BR"; only the primary subtags are
+// compared.
+func (s Set) For(source, target string) Set {
+	var set Set
+	for _, p := range s {
+		if (p.Source == "" || sameLanguage(p.Source, source)) && sameLanguage(p.Target, target) {
+			set = append(set, p)
+		}
+	}
+	return set
+}
+
+// Rules returns the number of rules of the packs.
+func (s Set) Rules() int {
+	n := 0
+	for _, p := range s {
+		n += len(p.Rules)
+	}
+	return n
+}
+
+// Check returns the rules of all packs that the translation breaks.
+func (s Set) Check(source, translated string) []Finding {
+	var findings []Finding
+	for _, p := range s {
+		findings = append(findings, p.Check(source, translated)...)
+	}
+	return findings
+}
+
+func sameLanguage(a, b string) bool {
+	primary := func(code string) string {
+		code, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(code)), "-")
+		code, _, _ = strings.Cut(code, "_")
+		return code
+	}
+	return primary(a) == primary(b)
+}
@@ -0,0 +1,91 @@
+package qa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct {
+		source, target string
+		original       string
+		translated     string
+		want           string
+	}{
+		{"en", "vi", "The meeting took place in Hanoi.", "Cuộc họp lấy chỗ ở Hà Nội.", "take-place"},
+		{"en", "vi", "The meeting took place in Hanoi.", "Cuộc họp diễn ra ở Hà Nội.", ""},
+		{"en", "vi", "Please pay attention.", "Xin hãy trả sự chú ý.", "pay-attention"},
+		{"en", "es", "Actually, I was embarrassed.", "En realidad, estaba avergonzado.", ""},
+		{"en", "es", "I was so embarrassed.", "Estaba tan embarazada.", "embarrassed"},
+		{"en", "es", "She went to the library.", "Fue a la librería.", "library"},
+		{"en-US", "es-MX", "Actually, no.", "Actualmente, no.", "actually"},
+		{"en", "de", "He became a doctor.", "Er bekam einen Arzt.", "become"},
+		// A mistake of another language pair is not flagged.
+		{"en", "de", "She went to the library.", "Fue a la librería.", ""},
+	}
+
+	for _, tt := range tests {
+		findings := Builtin().For(tt.source, tt.target).Check(tt.original, tt.translated)
+		switch {
+		case tt.want == "" && len(findings) > 0:
+			t.Errorf("%q → %q: unexpected findings %+v", tt.original, tt.translated, findings)
+		case tt.want != "" && (len(findings) != 1 || findings[0].Rule != tt.want):
+			t.Errorf("%q → %q: findings %+v, want rule %s", tt.original, tt.translated, findings, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr bool
+	}{
+		{"valid", "target: vi\nrules:\n  - id: a\n    target: x\n", false},
+		{"no target language", "rules:\n  - id: a\n    target: x\n", true},
+		{"rule without pattern", "target: vi\nrules:\n  - id: a\n", true},
+		{"duplicate id", "target: vi\nrules:\n  - id: a\n    target: x\n  - id: a\n    target: y\n", true},
+		{"invalid pattern", "target: vi\nrules:\n  - id: a\n    target: '('\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.Name != "test.yaml" {
+				t.Errorf("Parse() name = %q, want the file name", p.Name)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	pack := "name: Typography\ntarget: fr\nrules:\n  - id: space-before-colon\n    target: '\\w:'\n    message: French puts a space before a colon\n"
+	if err := os.WriteFile(filepath.Join(dir, "fr.yaml"), []byte(pack), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a pack"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 || set.Rules() != 1 {
+		t.Fatalf("Load() = %d packs with %d rules, want 1 with 1", len(set), set.Rules())
+	}
+
+	// A pack without a source language applies to every source.
+	findings := set.For("ja", "fr").Check("", "Attention: le chien")
+	if len(findings) != 1 || findings[0].Match != "n:" || findings[0].Pack != "Typography" {
+		t.Errorf("Check() = %+v", findings)
+	}
+	if len(set.For("en", "vi")) != 0 {
+		t.Error("For() returned a pack of another target language")
+	}
+}
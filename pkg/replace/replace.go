@@ -0,0 +1,117 @@
+// Package replace applies find/replace rules to translations, e.g. to
+// enforce the spellings a publisher mandates. Rules are kept as YAML:
+//
+//	rules:
+//	  - id: colour
+//	    find: '\bcolour'
+//	    replace: color
+//
+// Find is a Go regular expression and Replace may refer to its groups as $1
+// or ${name}. Rules apply in order, to the text of a translation only, never
+// to its tags. They see the text with its character references resolved, so
+// find: '&' matches "&amp;", and what they insert is escaped.
+package replace
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule replaces every match of Find with Replace.
+type Rule struct {
+	ID      string `yaml:"id"`
+	Find    string `yaml:"find"`
+	Replace string `yaml:"replace"`
+
+	find *regexp.Regexp
+}
+
+// Rules is an ordered list of rules.
+type Rules []Rule
+
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Parse reads rules from YAML; name identifies them in errors.
+func Parse(data []byte, name string) (Rules, error) {
+	var file struct {
+		Rules Rules `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing replace rules %s: %w", name, err)
+	}
+
+	ids := make(map[string]bool)
+	for i := range file.Rules {
+		r := &file.Rules[i]
+		if r.ID == "" || r.Find == "" {
+			return nil, fmt.Errorf("replace rules %s: rule %d needs an id and a find pattern", name, i+1)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("replace rules %s: duplicate rule id %q", name, r.ID)
+		}
+		ids[r.ID] = true
+
+		var err error
+		if r.find, err = regexp.Compile(r.Find); err != nil {
+			return nil, fmt.Errorf("replace rules %s: rule %s: %w", name, r.ID, err)
+		}
+		if strings.ContainsAny(r.Replace, "<>") {
+			return nil, fmt.Errorf("replace rules %s: rule %s: the replacement cannot hold markup", name, r.ID)
+		}
+	}
+	return file.Rules, nil
+}
+
+// Load reads the rules of a YAML file. A missing file yields no rules.
+func Load(filePath string) (Rules, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading replace rules: %w", err)
+	}
+	return Parse(data, filePath)
+}
+
+// Apply applies the rules to the text of the markup. It returns the result
+// and the number of replacements of every rule, by index.
+func (rs Rules) Apply(markup string) (string, []int) {
+	hits := make([]int, len(rs))
+	if len(rs) == 0 {
+		return markup, hits
+	}
+
+	var sb strings.Builder
+	pos := 0
+	// A text run no rule changes is kept as it is, character references
+	// and all.
+	text := func(s string) {
+		replaced, changed := html.UnescapeString(s), false
+		for i, r := range rs {
+			if n := len(r.find.FindAllStringIndex(replaced, -1)); n > 0 {
+				hits[i] += n
+				replaced, changed = r.find.ReplaceAllString(replaced, r.Replace), true
+			}
+		}
+		if !changed {
+			sb.WriteString(s)
+			return
+		}
+		sb.WriteString(textEscaper.Replace(replaced))
+	}
+	for _, loc := range tagRegex.FindAllStringIndex(markup, -1) {
+		text(markup[pos:loc[0]])
+		sb.WriteString(markup[loc[0]:loc[1]])
+		pos = loc[1]
+	}
+	text(markup[pos:])
+	return sb.String(), hits
+}
@@ -0,0 +1,86 @@
+package replace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testRules = `rules:
+  - id: colour
+    find: '\b([Cc])olour'
+    replace: '${1}olor'
+  - id: ok
+    find: '\bOK\b'
+    replace: okay
+  - id: and
+    find: ' & '
+    replace: ' and '
+  - id: plus
+    find: ' plus '
+    replace: ' & '
+`
+
+func TestApply(t *testing.T) {
+	rules, err := Parse([]byte(testRules), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		markup string
+		want   string
+		hits   []int
+	}{
+		{"Colour is OK, colours too.", "Color is okay, colors too.", []int{2, 1, 0, 0}},
+		// Tags and attributes are left alone.
+		{`<span class="colour" title="OK">colour</span>`, `<span class="colour" title="OK">color</span>`, []int{1, 0, 0, 0}},
+		{"Nothing to do.", "Nothing to do.", []int{0, 0, 0, 0}},
+		// Rules see the text unescaped, and what they insert is escaped.
+		{"Salt &amp; pepper", "Salt and pepper", []int{0, 0, 1, 0}},
+		{"Colour&#160;&amp; OK", "Color\u00a0&amp; okay", []int{1, 1, 0, 0}},
+		{"bread plus &lt;butter&gt;", "bread &amp; &lt;butter&gt;", []int{0, 0, 0, 1}},
+		// Text no rule changes keeps its character references.
+		{"Fish&amp;chips&#160;<br/>colour", "Fish&amp;chips&#160;<br/>color", []int{1, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, hits := rules.Apply(tt.markup)
+		if got != tt.want || !reflect.DeepEqual(hits, tt.hits) {
+			t.Errorf("Apply(%q) = %q, %v, want %q, %v", tt.markup, got, hits, tt.want, tt.hits)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"missing find", "rules:\n  - id: a\n    replace: b\n"},
+		{"duplicate id", "rules:\n  - id: a\n    find: x\n  - id: a\n    find: y\n"},
+		{"invalid pattern", "rules:\n  - id: a\n    find: '('\n"},
+		{"markup", "rules:\n  - id: a\n    find: x\n    replace: <b>x</b>\n"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.yaml), "test"); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	rules, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil || len(rules) != 0 {
+		t.Errorf("missing file: %v, %v", rules, err)
+	}
+
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(path, []byte(testRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = Load(path); err != nil || len(rules) != 4 {
+		t.Errorf("Load = %v, %v", rules, err)
+	}
+}
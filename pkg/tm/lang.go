@@ -0,0 +1,74 @@
+package tm
+
+import "strings"
+
+// languageCodes maps the language names used by --source and --target to the
+// codes TMX files carry in xml:lang.
+var languageCodes = map[string]string{
+	"arabic":     "ar",
+	"bulgarian":  "bg",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"estonian":   "et",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"indonesian": "id",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"latvian":    "lv",
+	"lithuanian": "lt",
+	"norwegian":  "nb",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"slovak":     "sk",
+	"slovenian":  "sl",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"thai":       "th",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+	"vietnamese": "vi",
+}
+
+// LanguageCode returns the code of a language name such as "Vietnamese".
+// Names it does not know, including codes, are returned unchanged.
+func LanguageCode(name string) string {
+	if code, ok := languageCodes[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return code
+	}
+	return name
+}
+
+// LanguageName returns the name of a code such as "vi" or "pt-BR", so entries
+// imported from TMX match the names translate looks them up with. Unknown
+// codes are returned unchanged.
+func LanguageName(code string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(code), "-")
+	for name, c := range languageCodes {
+		if c == primary {
+			return strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	return code
+}
+
+// sameLanguage reports whether a TMX language code denotes the language name
+// or code lang, ignoring regional variants.
+func sameLanguage(code, lang string) bool {
+	primary := func(s string) string {
+		p, _, _ := strings.Cut(strings.ToLower(LanguageCode(s)), "-")
+		return p
+	}
+	return primary(code) == primary(lang)
+}
@@ -0,0 +1,239 @@
+// Package tm exchanges translation memories with other tools. It reads and
+// writes TMX 1.4b, the format of CAT tools used by human translators, besides
+// the JSON files of package memory.
+package tm
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/memory"
+)
+
+// CreationToolVersion is written to the header of exported TMX files.
+var CreationToolVersion = "1"
+
+type tmxDocument struct {
+	XMLName xml.Name  `xml:"tmx"`
+	Version string    `xml:"version,attr"`
+	Header  tmxHeader `xml:"header"`
+	Units   []tmxUnit `xml:"body>tu"`
+}
+
+type tmxHeader struct {
+	CreationTool        string `xml:"creationtool,attr"`
+	CreationToolVersion string `xml:"creationtoolversion,attr"`
+	SegType             string `xml:"segtype,attr"`
+	OTMF                string `xml:"o-tmf,attr"`
+	AdminLang           string `xml:"adminlang,attr"`
+	SrcLang             string `xml:"srclang,attr"`
+	DataType            string `xml:"datatype,attr"`
+	CreationDate        string `xml:"creationdate,attr,omitempty"`
+}
+
+type tmxUnit struct {
+	SrcLang  string       `xml:"srclang,attr,omitempty"`
+	Variants []tmxVariant `xml:"tuv"`
+}
+
+type tmxVariant struct {
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	// LegacyLang is the lang attribute of TMX 1.1 files.
+	LegacyLang string     `xml:"lang,attr,omitempty"`
+	Seg        tmxSegment `xml:"seg"`
+}
+
+func (v tmxVariant) lang() string {
+	if v.Lang != "" {
+		return v.Lang
+	}
+	return v.LegacyLang
+}
+
+// tmxSegment is the content of a seg element. Segments are written as
+// escaped HTML; the inline codes of CAT tools (bpt, ept, ph, it, ut) enclose
+// escaped native markup too, so reading keeps the text of all elements.
+type tmxSegment string
+
+func (s *tmxSegment) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var sb strings.Builder
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				*s = tmxSegment(sb.String())
+				return nil
+			}
+			depth--
+		case xml.CharData:
+			sb.Write(t)
+		}
+	}
+}
+
+// Export writes entries as a TMX document. Languages are written as codes,
+// e.g. "vi" for Vietnamese.
+func Export(w io.Writer, entries []memory.Entry) error {
+	srcLang := "*all*"
+	if len(entries) > 0 {
+		srcLang = LanguageCode(entries[0].SourceLanguage)
+		for _, e := range entries {
+			if !strings.EqualFold(LanguageCode(e.SourceLanguage), srcLang) {
+				srcLang = "*all*"
+				break
+			}
+		}
+	}
+
+	doc := tmxDocument{
+		Version: "1.4",
+		Header: tmxHeader{
+			CreationTool:        "epubtrans",
+			CreationToolVersion: CreationToolVersion,
+			SegType:             "block",
+			OTMF:                "epubtrans",
+			AdminLang:           "en",
+			SrcLang:             srcLang,
+			DataType:            "html",
+			CreationDate:        time.Now().UTC().Format("20060102T150405Z"),
+		},
+	}
+	for _, e := range entries {
+		unit := tmxUnit{Variants: []tmxVariant{
+			{Lang: LanguageCode(e.SourceLanguage), Seg: tmxSegment(e.Source)},
+			{Lang: LanguageCode(e.TargetLanguage), Seg: tmxSegment(e.Target)},
+		}}
+		if srcLang == "*all*" {
+			unit.SrcLang = LanguageCode(e.SourceLanguage)
+		}
+		doc.Units = append(doc.Units, unit)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encoding TMX: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Import reads the entries of a TMX document. Every variant of a unit besides
+// the source language becomes an entry, with languages named as translate
+// names them, e.g. "Vietnamese" for "vi-VN".
+func Import(r io.Reader) ([]memory.Entry, error) {
+	var doc tmxDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing TMX: %w", err)
+	}
+
+	var entries []memory.Entry
+	for i, unit := range doc.Units {
+		srcLang := unit.SrcLang
+		if srcLang == "" {
+			srcLang = doc.Header.SrcLang
+		}
+		if len(unit.Variants) < 2 {
+			continue
+		}
+
+		// With srclang *all*, the first variant is the source.
+		source := unit.Variants[0]
+		if srcLang != "*all*" {
+			found := false
+			for _, v := range unit.Variants {
+				if sameLanguage(v.lang(), srcLang) {
+					source, found = v, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("parsing TMX: unit %d has no %s variant", i+1, srcLang)
+			}
+		}
+
+		for _, v := range unit.Variants {
+			if v.lang() == source.lang() {
+				continue
+			}
+			entries = append(entries, memory.Entry{
+				Source:         strings.TrimSpace(string(source.Seg)),
+				Target:         strings.TrimSpace(string(v.Seg)),
+				SourceLanguage: LanguageName(source.lang()),
+				TargetLanguage: LanguageName(v.lang()),
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// Load reads a translation memory from a .tmx file or, for any other
+// extension, a JSON file of package memory. A missing file yields an empty
+// memory.
+func Load(path string) (*memory.Memory, error) {
+	if !isTMX(path) {
+		return memory.Load(path)
+	}
+
+	m := memory.New()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading translation memory: %w", err)
+	}
+	defer f.Close()
+
+	entries, err := Import(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		m.Add(e.Source, e.Target, e.SourceLanguage, e.TargetLanguage)
+	}
+
+	return m, nil
+}
+
+// Save writes m to path as TMX or JSON, depending on its extension.
+func Save(path string, m *memory.Memory) error {
+	if !isTMX(path) {
+		return m.Save(path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writing translation memory: %w", err)
+	}
+	if err := Export(f, m.Entries()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isTMX(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".tmx")
+}
@@ -0,0 +1,111 @@
+package tm
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dutchsteven/epubtrans/pkg/memory"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	entries := []memory.Entry{
+		{Source: "Hello <em>world</em> & more", Target: "Xin chào <em>thế giới</em>", SourceLanguage: "English", TargetLanguage: "Vietnamese"},
+		{Source: "Goodbye", Target: "Tạm biệt", SourceLanguage: "English", TargetLanguage: "Vietnamese"},
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`srclang="en"`, `xml:lang="vi"`, `Hello &lt;em&gt;world&lt;/em&gt; &amp; more`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("exported TMX lacks %s:\n%s", want, buf.String())
+		}
+	}
+
+	got, err := Import(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("Import() = %+v, want %+v", got, entries)
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmx     string
+		want    []memory.Entry
+		wantErr bool
+	}{
+		{
+			name: "inline codes of a CAT tool",
+			tmx: `<tmx version="1.4"><header srclang="en-US"/><body>
+				<tu><tuv xml:lang="en-US"><seg><bpt i="1">&lt;b&gt;</bpt>Bold<ept i="1">&lt;/b&gt;</ept> text</seg></tuv>
+				<tuv xml:lang="fr-FR"><seg><bpt i="1">&lt;b&gt;</bpt>Texte<ept i="1">&lt;/b&gt;</ept> gras</seg></tuv></tu>
+			</body></tmx>`,
+			want: []memory.Entry{{Source: "<b>Bold</b> text", Target: "<b>Texte</b> gras", SourceLanguage: "English", TargetLanguage: "French"}},
+		},
+		{
+			name: "TMX 1.1 lang and several targets",
+			tmx: `<tmx version="1.1"><header srclang="EN"/><body>
+				<tu><tuv lang="DE"><seg>Hallo</seg></tuv><tuv lang="EN"><seg>Hello</seg></tuv><tuv lang="xx"><seg>Hi</seg></tuv></tu>
+			</body></tmx>`,
+			want: []memory.Entry{
+				{Source: "Hello", Target: "Hallo", SourceLanguage: "English", TargetLanguage: "German"},
+				{Source: "Hello", Target: "Hi", SourceLanguage: "English", TargetLanguage: "xx"},
+			},
+		},
+		{
+			name:    "source language missing",
+			tmx:     `<tmx version="1.4"><header srclang="en"/><body><tu><tuv xml:lang="de"><seg>a</seg></tuv><tuv xml:lang="fr"><seg>b</seg></tuv></tu></body></tmx>`,
+			wantErr: true,
+		},
+		{
+			name:    "not TMX",
+			tmx:     `{"source": "Hello"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Import(strings.NewReader(tt.tmx))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Import() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"memory.tmx", "memory.json"} {
+		path := filepath.Join(dir, name)
+
+		m, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Add("Hello", "Xin chào", "English", "Vietnamese")
+		if err := Save(path, m); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err = Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := m.Lookup("Hello", "English", "Vietnamese"); !ok || got != "Xin chào" {
+			t.Errorf("%s: Lookup() = %q, %v", name, got, ok)
+		}
+	}
+}
@@ -5,141 +5,127 @@ import (
 	"crypto/sha256"
 	_ "embed"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/ristretto"
 	"github.com/liushuangls/go-anthropic/v2"
 )
 
-var (
-	_anthropic    *Anthropic
-	anthropicOnce sync.Once
-)
+func init() {
+	Register("anthropic", factoryOf(NewAnthropic))
+}
 
 type Config struct {
 	APIKey                string
 	Model                 string
 	Temperature           float32
+	TopP                  float32 // Nucleus sampling threshold; zero keeps the provider's default
+	Seed                  *int64  // Sampling seed, sent to providers that support one
 	MaxTokens             int
-	CacheTTL              time.Duration
+	Cache                 Cache         // Translation cache; an in-memory cache when nil
+	CacheTTL              time.Duration // Zero keeps cached translations forever, except in the default cache
 	CacheMaxCost          int64
 	TranslationGuidelines string // New field for translation guidelines
 	SystemPrompt          string // New field for system prompt
+	PromptVersion         string // Pins the prompt version used in cache keys; computed from the guidelines when empty
+	MaxConcurrency        int    // Upper bound for concurrent requests; the throttle adapts below it
+	GlossaryID            string // DeepL glossary applied to every request
+	Fixture               string // Recorded translations replayed by the mock provider, see Recorder
 }
 
-type UsageMetadata struct {
-	TotalCalls     int                       `json:"total_calls"`
-	LastUsed       time.Time                 `json:"last_used"`
-	ModelUsage     map[string]int            `json:"model_usage"`
-	PromptExamples []string                  `json:"prompt_examples"`
-	TokenUsage     atomic.Uint64             `json:"token_usage"`
-	TokenUsageList []anthropic.MessagesUsage `json:"token_usage_list"`
-} 
-
-func GetAnthropicTranslator(cfg *Config) (*Anthropic, error) {
-	var err error
-	anthropicOnce.Do(func() {
-		if cfg == nil {
-			cfg = &Config{
-				APIKey:      os.Getenv("ANTHROPIC_KEY"),
-				Model:       string(anthropic.ModelClaude3Dot5SonnetLatest),
-				Temperature: 0.3,
-				MaxTokens:   8192,
-			}
-		}
-
-		if cfg.APIKey == "" {
-			err = errors.New("missing ANTHROPIC_KEY")
-			return
-		}
-
-		if cfg.TranslationGuidelines == "" {
-			cfg.TranslationGuidelines = os.Getenv("TRANSLATION_GUIDELINES")
-		}
-		if cfg.SystemPrompt == "" {
-			cfg.SystemPrompt = os.Getenv("SYSTEM_PROMPT")
-		}
-
-		cfg.CacheTTL = 15 * time.Minute
-		cfg.CacheMaxCost = 1e7
-
-		cache, cacheErr := ristretto.NewCache(&ristretto.Config{
-			NumCounters: 1e7,              // number of keys to track frequency of (10M).
-			MaxCost:     cfg.CacheMaxCost, // maximum cost of cache (1GB).
-			BufferItems: 64,               // number of keys per Get buffer.
-		})
-		if cacheErr != nil {
-			err = fmt.Errorf("failed to create cache: %w", cacheErr)
-			return
-		}
-
-		_anthropic = &Anthropic{
-			client: anthropic.NewClient(cfg.APIKey, anthropic.WithBetaVersion("prompt-caching-2024-07-31")),
-			cache:  cache,
-			config: cfg,
-			metadata: &UsageMetadata{
-				ModelUsage: make(map[string]int),
-			},
-		}
+// UsageStats sums the token usage of the current run.
+type UsageStats struct {
+	Calls            int
+	InputTokens      int
+	OutputTokens     int
+	CacheReadTokens  int
+	CacheWriteTokens int
+	Characters       int // billed by providers that charge per character
+}
 
-		_anthropic.loadMetadata(context.Background()) // Pass a background context
-	})
+func (u *UsageStats) add(usage anthropic.MessagesUsage) {
+	u.Calls++
+	u.InputTokens += usage.InputTokens
+	u.OutputTokens += usage.OutputTokens
+	u.CacheReadTokens += usage.CacheReadInputTokens
+	u.CacheWriteTokens += usage.CacheCreationInputTokens
+}
 
-	if err != nil {
-		return nil, err
+// CacheSavings returns the input tokens saved by prompt caching, in units of
+// regular input tokens: cache reads cost a tenth of an input token and cache
+// writes a quarter more. It also returns the share of the input cost saved.
+func (u UsageStats) CacheSavings() (float64, float64) {
+	saved := 0.9*float64(u.CacheReadTokens) - 0.25*float64(u.CacheWriteTokens)
+	uncached := float64(u.InputTokens + u.CacheReadTokens + u.CacheWriteTokens)
+	if uncached == 0 {
+		return 0, 0
 	}
-
-	return _anthropic, nil
+	return saved, saved / uncached
 }
 
-func (a *Anthropic) loadMetadata(ctx context.Context) {
-	data, err := os.ReadFile(a.getMetadataFilePath())
-	if err != nil {
-		return // File doesn't exist or can't be read, use default values
+// NewAnthropic returns an Anthropic translator. The API key defaults to
+// ANTHROPIC_KEY.
+func NewAnthropic(cfg *Config) (*Anthropic, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("ANTHROPIC_KEY")
 	}
-
-	err = json.Unmarshal(data, a.metadata)
-	if err != nil {
-		fmt.Printf("Error unmarshaling metadata: %v\n", err)
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing ANTHROPIC_KEY")
 	}
+	if cfg.Model == "" {
+		cfg.Model = string(anthropic.ModelClaude3Dot5SonnetLatest)
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+
+	return &Anthropic{
+		client:   anthropic.NewClient(cfg.APIKey, anthropic.WithBetaVersion("prompt-caching-2024-07-31")),
+		cache:    cfg.Cache,
+		config:   cfg,
+		throttle: NewThrottle(cfg.MaxConcurrency),
+
+		usageRecorder: newUsageRecorder(),
+	}, nil
 }
 
-func (a *Anthropic) saveMetadata(ctx context.Context) {
-	data, err := json.MarshalIndent(a.metadata, "", "  ")
-	if err != nil {
-		fmt.Printf("Error marshaling metadata: %v\n", err)
-		return
+// applyDefaults fills in the settings shared by all providers: the prompts
+// from the environment and, when none is given, an in-memory cache.
+func applyDefaults(cfg *Config) error {
+	if cfg.TranslationGuidelines == "" {
+		cfg.TranslationGuidelines = os.Getenv("TRANSLATION_GUIDELINES")
 	}
-
-	err = os.MkdirAll(filepath.Dir(a.getMetadataFilePath()), 0755)
-	if err != nil {
-		fmt.Printf("Error creating directory: %v\n", err)
-		return
+	if cfg.SystemPrompt == "" {
+		cfg.SystemPrompt = os.Getenv("SYSTEM_PROMPT")
 	}
 
-	err = os.WriteFile(a.getMetadataFilePath(), data, 0644)
-	if err != nil {
-		fmt.Printf("Error writing metadata file: %v\n", err)
+	if cfg.Cache == nil {
+		if cfg.CacheTTL == 0 {
+			cfg.CacheTTL = 15 * time.Minute
+		}
+		if cfg.CacheMaxCost == 0 {
+			cfg.CacheMaxCost = 1e7
+		}
+
+		cache, err := NewMemoryCache(cfg.CacheMaxCost)
+		if err != nil {
+			return err
+		}
+		cfg.Cache = cache
 	}
-}
 
-func (a *Anthropic) getMetadataFilePath() string {
-	return filepath.Join("unpackage", "translator_metadata.json")
+	return nil
 }
 
 type Anthropic struct {
 	client   *anthropic.Client
-	cache    *ristretto.Cache
+	cache    Cache
 	config   *Config
-	metadata *UsageMetadata
-	mu       sync.Mutex
+	throttle *Throttle
+
+	*usageRecorder
 }
 
 // Replace the promptLib map with embedded content
@@ -154,26 +140,48 @@ var promptLib = map[string]string{
 	"technical":  technicalPrompt,
 }
 
-func createTranslationSystem(source, target, guidelines, bookName string) string {
+// PromptVersion returns a short hash identifying the prompt template. It is part
+// of every cache key, so editing the guidelines invalidates cached translations.
+func PromptVersion(guidelines, systemPrompt string) string {
 	if guidelines == "" {
 		guidelines = promptLib["technical"]
 	}
-	return fmt.Sprintf(guidelines, source, target, bookName)
+	hash := sha256.Sum256([]byte(guidelines + "\x00" + systemPrompt))
+	return hex.EncodeToString(hash[:6])
 }
 
-func (a *Anthropic) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// Model returns the model translations are made with.
+func (a *Anthropic) Model() string {
+	return a.config.Model
+}
+
+// PromptVersion returns the prompt version used in this translator's cache keys.
+func (a *Anthropic) PromptVersion() string {
+	if a.config.PromptVersion != "" {
+		return a.config.PromptVersion
+	}
+	return PromptVersion(a.config.TranslationGuidelines, a.config.SystemPrompt)
+}
 
-	cacheKey := generateCacheKey(prompt+content, source, target)
+// Sampling describes the sampling settings; Anthropic takes no seed.
+func (a *Anthropic) Sampling() string {
+	return a.config.sampling(false).String()
+}
 
-	if prompt != "" {
-		if cachedTranslation, found := a.cache.Get(cacheKey); found {
-			return cachedTranslation.(string), nil
-		}
+func createTranslationSystem(source, target, guidelines, bookName string) string {
+	if guidelines == "" {
+		guidelines = promptLib["technical"]
 	}
+	return fmt.Sprintf(guidelines, source, target, bookName)
+}
 
-	systemMessages := []anthropic.MessageSystemPart{
+// systemParts builds the system prompt: the guidelines, then the book context
+// (glossary, character sheet) that stays the same for the whole run. Both end
+// in a cache breakpoint, so every request of a run, from any worker, shares
+// the cached prefix, and the guidelines stay cached when the context changes.
+// Nothing that varies per request may go into the system prompt.
+func (a *Anthropic) systemParts(source, target, bookName, bookContext string) []anthropic.MessageSystemPart {
+	parts := []anthropic.MessageSystemPart{
 		{
 			Type: "text",
 			Text: createTranslationSystem(source, target, a.config.TranslationGuidelines, bookName),
@@ -183,20 +191,62 @@ func (a *Anthropic) Translate(ctx context.Context, prompt, content, source, targ
 		},
 	}
 
-	if prompt != "" {
-		systemMessages = append(systemMessages, anthropic.MessageSystemPart{
+	if bookContext != "" {
+		parts = append(parts, anthropic.MessageSystemPart{
 			Type: "text",
-			Text: prompt,
+			Text: bookContext,
+			CacheControl: &anthropic.MessageCacheControl{
+				Type: anthropic.CacheControlTypeEphemeral,
+			},
 		})
 	}
 
-	resp, err := a.createMessageWithRetry(ctx, anthropic.MessagesRequest{
+	return parts
+}
+
+func (a *Anthropic) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	return a.TranslateStream(ctx, prompt, content, source, target, bookName, nil)
+}
+
+// TranslateStream translates like Translate, streaming the response when
+// onDelta is set.
+func (a *Anthropic) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	cacheKey := generateCacheKey(prompt+content, source, target, a.config.Model, a.PromptVersion(), a.Sampling())
+
+	if cachedTranslation, found := a.cache.Get(cacheKey); found {
+		emitWhole(onDelta, cachedTranslation)
+		return cachedTranslation, nil
+	}
+
+	systemMessages := a.systemParts(source, target, bookName, prompt)
+
+	req := anthropic.MessagesRequest{
 		Model:       anthropic.Model(a.config.Model),
 		MultiSystem: systemMessages,
 		Messages:    []anthropic.Message{anthropic.NewUserTextMessage("Translate this and not say anything otherwise the translation: " + content)},
 		Temperature: &a.config.Temperature,
 		MaxTokens:   a.config.MaxTokens,
-	})
+	}
+	if a.config.TopP > 0 {
+		req.SetTopP(a.config.TopP)
+	}
+	send := func(ctx context.Context) (anthropic.MessagesResponse, error) {
+		return a.client.CreateMessages(ctx, req)
+	}
+	if onDelta != nil {
+		send = func(ctx context.Context) (anthropic.MessagesResponse, error) {
+			return a.client.CreateMessagesStream(ctx, anthropic.MessagesStreamRequest{
+				MessagesRequest: req,
+				OnContentBlockDelta: func(data anthropic.MessagesEventContentBlockDeltaData) {
+					if text := data.Delta.GetText(); text != "" {
+						onDelta(text)
+					}
+				},
+			})
+		}
+	}
+
+	resp, err := a.createMessageWithRetry(ctx, send)
 
 	if err != nil {
 		return "", fmt.Errorf("createMessageWithRetry: %w", err)
@@ -207,48 +257,45 @@ func (a *Anthropic) Translate(ctx context.Context, prompt, content, source, targ
 	}
 
 	translation := resp.GetFirstContentText()
-	a.cache.SetWithTTL(cacheKey, translation, 0, a.config.CacheTTL)
-
-	// Update metadata
-	a.metadata.TotalCalls++
-	a.metadata.LastUsed = time.Now()
-	a.metadata.ModelUsage[a.config.Model]++
-	if len(a.metadata.PromptExamples) < 5 {
-		a.metadata.PromptExamples = append(a.metadata.PromptExamples, content[:min(100, len(content))])
+	if cacheable(content, translation) {
+		if err := a.cache.SetWithTTL(cacheKey, translation, a.config.CacheTTL); err != nil {
+			fmt.Printf("Error caching translation: %v\n", err)
+		}
 	}
 
-	// Update token usage
-	totalTokens := uint64(resp.Usage.InputTokens + resp.Usage.OutputTokens)
-	a.metadata.TokenUsage.Add(totalTokens)
-	a.metadata.TokenUsageList = append(a.metadata.TokenUsageList, resp.Usage)
-
-	// Save updated metadata
-	a.saveMetadata(ctx) // Pass the context to saveMetadata
+	a.record(ctx, a.config.Model, content, resp.Usage)
 
 	return translation, nil
 }
 
 const maxRetries = 3
 
-func (a *Anthropic) createMessageWithRetry(ctx context.Context, req anthropic.MessagesRequest) (*anthropic.MessagesResponse, error) {
+// createMessageWithRetry sends the request once the throttle allows it and
+// feeds the rate limit headers of every response back to the throttle.
+// Rate limit errors come before a streamed response starts, so retrying never
+// repeats deltas.
+func (a *Anthropic) createMessageWithRetry(ctx context.Context, send func(ctx context.Context) (anthropic.MessagesResponse, error)) (*anthropic.MessagesResponse, error) {
 	var resp anthropic.MessagesResponse
 	var err error
 
 	for retries := 0; retries < maxRetries; retries++ {
-		resp, err = a.client.CreateMessages(ctx, req)
+		if err := a.throttle.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = send(ctx)
+		a.throttle.Release()
+
+		rateLimit := anthropicRateLimit(resp)
 		if err == nil {
+			a.throttle.Observe(rateLimit)
 			return &resp, nil
 		}
 
 		var apiErr *anthropic.APIError
 		if errors.As(err, &apiErr) && apiErr.IsRateLimitErr() {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(time.Duration(retries+1) * time.Second):
-				fmt.Println("\t\t\tretrying after rate limit error")
-				continue
-			}
+			delay := a.throttle.Backoff(rateLimit, time.Duration(retries+1)*time.Second)
+			fmt.Printf("\t\t\trate limited, retrying in %s\n", delay)
+			continue
 		}
 
 		return nil, err
@@ -257,7 +304,31 @@ func (a *Anthropic) createMessageWithRetry(ctx context.Context, req anthropic.Me
 	return nil, fmt.Errorf("max retries reached: %w", err)
 }
 
-func generateCacheKey(content, source, target string) string {
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", content, source, target)))
+// anthropicRateLimit reads the rate limit headers of a response. Missing
+// headers parse as a zero limit, which the throttle ignores.
+func anthropicRateLimit(resp anthropic.MessagesResponse) RateLimit {
+	headers, _ := resp.GetRateLimitHeaders()
+
+	return RateLimit{
+		RequestsLimit:     headers.RequestsLimit,
+		RequestsRemaining: headers.RequestsRemaining,
+		RequestsReset:     headers.RequestsReset,
+		TokensLimit:       headers.TokensLimit,
+		TokensRemaining:   headers.TokensRemaining,
+		TokensReset:       headers.TokensReset,
+		RetryAfter:        time.Duration(max(headers.RetryAfter, 0)) * time.Second,
+	}
+}
+
+// generateCacheKey includes the model and the sampling settings, so
+// translating again with another model, or another temperature or seed, does
+// not return the translations of the previous one. Keys without sampling
+// settings are those of the provider defaults, as before they were set.
+func generateCacheKey(content, source, target, model, promptVersion, sampling string) string {
+	key := fmt.Sprintf("%s:%s:%s:%s:%s", model, promptVersion, content, source, target)
+	if sampling != "" {
+		key += ":" + sampling
+	}
+	hash := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,97 @@
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("translations")
+
+// BoltCache keeps translations in a single BoltDB file, so they survive
+// between runs. Only one process can open the file at a time.
+type BoltCache struct {
+	db *bolt.DB
+}
+
+type boltEntry struct {
+	Value string `json:"value"`
+	// Expires is zero for translations kept forever.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// NewBoltCache opens or creates the cache file at path.
+func NewBoltCache(path string) (*BoltCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("cache %s is in use by another epubtrans process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+
+	return &BoltCache{db: db}, nil
+}
+
+func (c *BoltCache) Get(key string) (string, bool) {
+	var entry boltEntry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get([]byte(key))
+		if data == nil {
+			return errCacheMiss
+		}
+		return json.Unmarshal(data, &entry)
+	})
+	if err != nil || (!entry.Expires.IsZero() && time.Now().After(entry.Expires)) {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+func (c *BoltCache) SetWithTTL(key, value string, ttl time.Duration) error {
+	entry := boltEntry{Value: value}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+// Close releases the cache file.
+func (c *BoltCache) Close() error {
+	return c.db.Close()
+}
+
+var errCacheMiss = errors.New("cache miss")
+
+// cacheable reports whether a translation may be cached: a batch answer that
+// lost or duplicated segments is rejected by translate and must not be served
+// again on the next run.
+func cacheable(content, translation string) bool {
+	return strings.Count(translation, "</SEGMENT_") == strings.Count(content, "</SEGMENT_")
+}
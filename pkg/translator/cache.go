@@ -0,0 +1,149 @@
+package translator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/dutchsteven/epubtrans/pkg/util"
+)
+
+// Cache stores translations by cache key.
+type Cache interface {
+	Get(key string) (string, bool)
+	// SetWithTTL stores value for ttl; a ttl of zero or less keeps it forever.
+	SetWithTTL(key, value string, ttl time.Duration) error
+}
+
+// NewCache returns the cache described by spec:
+//
+//	memory       an in-process cache, lost on exit (the default)
+//	none         no caching
+//	file:<dir>   a persistent cache, one file per translation in dir
+//	bolt:<file>  a persistent cache in a single BoltDB file
+//	redis://...  a cache shared through Redis
+func NewCache(spec string, maxCost int64) (Cache, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return NewMemoryCache(maxCost)
+	case spec == "none":
+		return NoopCache{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileCache(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "bolt:"):
+		return NewBoltCache(strings.TrimPrefix(spec, "bolt:"))
+	case strings.HasPrefix(spec, "redis://") || strings.HasPrefix(spec, "rediss://"):
+		client, err := NewRedisClient(spec)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisCache(client), nil
+	default:
+		return nil, fmt.Errorf("unknown cache %q: use memory, none, file:<dir>, bolt:<file> or a redis:// URL", spec)
+	}
+}
+
+// MemoryCache keeps translations in memory for the life of the process.
+type MemoryCache struct {
+	cache *ristretto.Cache
+}
+
+func NewMemoryCache(maxCost int64) (*MemoryCache, error) {
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,     // number of keys to track frequency of (10M).
+		MaxCost:     maxCost, // maximum cost of cache.
+		BufferItems: 64,      // number of keys per Get buffer.
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache: %w", err)
+	}
+	return &MemoryCache{cache: cache}, nil
+}
+
+func (c *MemoryCache) Get(key string) (string, bool) {
+	value, found := c.cache.Get(key)
+	if !found {
+		return "", false
+	}
+	return value.(string), true
+}
+
+func (c *MemoryCache) SetWithTTL(key, value string, ttl time.Duration) error {
+	c.cache.SetWithTTL(key, value, int64(len(value)), max(ttl, 0))
+	return nil
+}
+
+// NoopCache caches nothing.
+type NoopCache struct{}
+
+func (NoopCache) Get(string) (string, bool) { return "", false }
+
+func (NoopCache) SetWithTTL(string, string, time.Duration) error { return nil }
+
+// FileCache keeps translations on disk, so they survive between runs.
+type FileCache struct {
+	dir string
+}
+
+type fileCacheEntry struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func NewFileCache(dir string) (*FileCache, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file cache needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+func (c *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name+".json")
+}
+
+func (c *FileCache) Get(key string) (string, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return "", false
+	}
+
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", false
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		os.Remove(c.path(key))
+		return "", false
+	}
+
+	return entry.Value, true
+}
+
+func (c *FileCache) SetWithTTL(key, value string, ttl time.Duration) error {
+	entry := fileCacheEntry{Value: value}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
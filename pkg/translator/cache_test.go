@@ -0,0 +1,269 @@
+package translator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewCache(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		spec      string
+		wantFound bool
+		wantErr   bool
+	}{
+		{"", true, false},
+		{"memory", true, false},
+		{"none", false, false},
+		{"file:" + dir, true, false},
+		{"file:", false, true},
+		{"bolt:" + filepath.Join(dir, "cache.db"), true, false},
+		{"disk", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			cache, err := NewCache(tt.spec, 1e6)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCache(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := cache.SetWithTTL("key", "value", 0); err != nil {
+				t.Fatal(err)
+			}
+			if m, ok := cache.(*MemoryCache); ok {
+				m.cache.Wait()
+			}
+
+			got, found := cache.Get("key")
+			if found != tt.wantFound || (found && got != "value") {
+				t.Errorf("Get() = %q, %v, want found %v", got, found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestFileCacheExpires(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.SetWithTTL("key", "value", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, found := cache.Get("key"); found {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestBoltCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book", ".epubtrans-cache.db")
+
+	cache, err := NewBoltCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetWithTTL("kept", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetWithTTL("expired", "value", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBoltCache(path); err == nil {
+		t.Error("a second process could open the cache in use")
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewBoltCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if got, found := cache.Get("kept"); !found || got != "value" {
+		t.Errorf("Get() after reopening = %q, %v", got, found)
+	}
+	if _, found := cache.Get("expired"); found {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	batch := "<SEGMENT_0>\na\n</SEGMENT_0>\n\n<SEGMENT_1>\nb\n</SEGMENT_1>"
+
+	if !cacheable(batch, "<SEGMENT_0>\nx\n</SEGMENT_0>\n<SEGMENT_1>\ny\n</SEGMENT_1>") {
+		t.Error("complete batch not cacheable")
+	}
+	if cacheable(batch, "<SEGMENT_0>\nx\n</SEGMENT_0>") {
+		t.Error("batch missing a segment cacheable")
+	}
+	if !cacheable("Hello", "Xin chào") {
+		t.Error("single segment not cacheable")
+	}
+}
+
+func TestCacheKeyedByPromptVersion(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Xin chào"}}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+
+	dir := t.TempDir()
+	for _, spec := range []string{"memory", "file:" + dir, "bolt:" + filepath.Join(dir, "cache.db")} {
+		t.Run(spec, func(t *testing.T) {
+			cache, err := NewCache(spec, 1e6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, ok := cache.(*BoltCache); ok {
+				defer b.Close()
+			}
+
+			calls.Store(0)
+			// Every translation is cached under the prompt version it was
+			// made with, and only found again with that version.
+			for i, version := range []string{"v1", "v2", "v1", "v2"} {
+				o, err := NewOpenAI(&Config{APIKey: "test-key", Model: OpenAIModelGPT4oMini, Cache: cache, PromptVersion: version})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := o.Translate(context.Background(), "", "Hello", "English", "Vietnamese", "Book"); err != nil {
+					t.Fatal(err)
+				}
+				if m, ok := cache.(*MemoryCache); ok {
+					m.cache.Wait()
+				}
+				if want := int32(min(i+1, 2)); calls.Load() != want {
+					t.Errorf("after translating with prompt version %s, %d requests were sent, want %d", version, calls.Load(), want)
+				}
+			}
+		})
+	}
+}
+
+// TestCacheHitWithoutPrompt checks that a run without batch instructions,
+// the default, reads the translations it cached.
+func TestCacheHitWithoutPrompt(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/translate"):
+			w.Write([]byte(`{"translations": [{"text": "Xin chào", "billed_characters": 5}]}`))
+		case strings.Contains(r.URL.Path, ":generateContent"):
+			w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Xin chào"}]}, "finishReason": "STOP"}]}`))
+		default:
+			w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Xin chào"}}]}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+	t.Setenv("GEMINI_BASE_URL", server.URL)
+	t.Setenv("DEEPL_SERVER_URL", server.URL)
+
+	providers := map[string]func(cache Cache) (Translator, error){
+		"openai": func(cache Cache) (Translator, error) {
+			return NewOpenAI(&Config{APIKey: "test-key", Cache: cache})
+		},
+		"gemini": func(cache Cache) (Translator, error) {
+			return NewGemini(&Config{APIKey: "test-key", Cache: cache})
+		},
+		"deepl": func(cache Cache) (Translator, error) {
+			return NewDeepL(&Config{APIKey: "test-key:fx", Cache: cache})
+		},
+	}
+	for name, newTranslator := range providers {
+		t.Run(name, func(t *testing.T) {
+			cache, err := NewFileCache(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr, err := newTranslator(cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			calls.Store(0)
+			for i := 0; i < 2; i++ {
+				got, err := tr.Translate(context.Background(), "", "Hello", "English", "Vietnamese", "Book")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != "Xin chào" {
+					t.Errorf("Translate() = %q, want Xin chào", got)
+				}
+			}
+			if calls.Load() != 1 {
+				t.Errorf("%d requests were sent for the same segment, want 1", calls.Load())
+			}
+		})
+	}
+}
+
+// TestReuseOlderPromptVersion follows the README: editing the guidelines
+// misses the cache, and passing the old prompt version finds it again, also
+// for runs without batch instructions.
+func TestReuseOlderPromptVersion(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Xin chào"}}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+
+	cache, err := NewBoltCache(filepath.Join(t.TempDir(), ".epubtrans-cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	translate := func(guidelines, version string) string {
+		t.Helper()
+		o, err := NewOpenAI(&Config{APIKey: "test-key", Cache: cache, TranslationGuidelines: guidelines, PromptVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.Translate(context.Background(), "", "Hello", "English", "Vietnamese", "Book"); err != nil {
+			t.Fatal(err)
+		}
+		return o.PromptVersion()
+	}
+
+	old := translate("Keep it formal.", "")
+	steps := []struct {
+		name       string
+		guidelines string
+		version    string
+		wantCalls  int32
+	}{
+		{"same guidelines hit", "Keep it formal.", "", 1},
+		{"edited guidelines miss", "Keep it casual.", "", 2},
+		{"older prompt version hits", "Keep it casual.", old, 2},
+	}
+	for _, step := range steps {
+		translate(step.guidelines, step.version)
+		if calls.Load() != step.wantCalls {
+			t.Errorf("%s: %d requests were sent, want %d", step.name, calls.Load(), step.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeepLModelQualityOptimized       = "quality_optimized"
+	DeepLModelPreferQualityOptimized = "prefer_quality_optimized"
+	DeepLModelLatencyOptimized       = "latency_optimized"
+
+	deepLFreeBaseURL = "https://api-free.deepl.com/v2"
+	deepLProBaseURL  = "https://api.deepl.com/v2"
+
+	// deepLMaxTexts is the number of texts DeepL accepts in one request.
+	deepLMaxTexts = 50
+
+	// statusQuotaExceeded is DeepL's answer once the character quota is used up.
+	statusQuotaExceeded = 456
+)
+
+func init() {
+	Register("deepl", factoryOf(NewDeepL))
+}
+
+// deepLLanguages maps language names to DeepL codes. Targets that DeepL needs
+// a regional variant for use the most common one; pass the code, e.g. EN-GB,
+// to choose another.
+var deepLLanguages = map[string]struct{ source, target string }{
+	"arabic":     {"AR", "AR"},
+	"bulgarian":  {"BG", "BG"},
+	"chinese":    {"ZH", "ZH-HANS"},
+	"czech":      {"CS", "CS"},
+	"danish":     {"DA", "DA"},
+	"dutch":      {"NL", "NL"},
+	"english":    {"EN", "EN-US"},
+	"estonian":   {"ET", "ET"},
+	"finnish":    {"FI", "FI"},
+	"french":     {"FR", "FR"},
+	"german":     {"DE", "DE"},
+	"greek":      {"EL", "EL"},
+	"hungarian":  {"HU", "HU"},
+	"indonesian": {"ID", "ID"},
+	"italian":    {"IT", "IT"},
+	"japanese":   {"JA", "JA"},
+	"korean":     {"KO", "KO"},
+	"latvian":    {"LV", "LV"},
+	"lithuanian": {"LT", "LT"},
+	"norwegian":  {"NB", "NB"},
+	"polish":     {"PL", "PL"},
+	"portuguese": {"PT", "PT-BR"},
+	"romanian":   {"RO", "RO"},
+	"russian":    {"RU", "RU"},
+	"slovak":     {"SK", "SK"},
+	"slovenian":  {"SL", "SL"},
+	"spanish":    {"ES", "ES"},
+	"swedish":    {"SV", "SV"},
+	"turkish":    {"TR", "TR"},
+	"ukrainian":  {"UK", "UK"},
+	"vietnamese": {"VI", "VI"},
+}
+
+var (
+	deepLCodePattern    = regexp.MustCompile(`^[A-Za-z]{2}(-[A-Za-z]{2,4})?$`)
+	deepLSegmentPattern = regexp.MustCompile(`(?s)<SEGMENT_(\d+)>\n?(.*?)\n?</SEGMENT_\d+>`)
+	// Placeholders like {{MATH_0}} are kept out of the translation.
+	deepLPlaceholderPattern = regexp.MustCompile(`\{\{[A-Z]+_\d+\}\}`)
+	deepLProtectedPattern   = regexp.MustCompile(`<span translate="no">(\{\{[A-Z]+_\d+\}\})</span>`)
+)
+
+// DeepL translates with the DeepL API. It is no language model: prompts are
+// only passed as context, and usage is billed in characters.
+type DeepL struct {
+	baseURL  string
+	client   *http.Client
+	cache    Cache
+	config   *Config
+	throttle *Throttle
+
+	*usageRecorder
+}
+
+// NewDeepL returns a DeepL translator. The API key defaults to DEEPL_AUTH_KEY;
+// keys of free accounts, which end in ":fx", use the free endpoint.
+// DEEPL_SERVER_URL overrides the endpoint.
+func NewDeepL(cfg *Config) (*DeepL, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("DEEPL_AUTH_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing DEEPL_AUTH_KEY")
+	}
+	if cfg.Model == "" {
+		cfg.Model = DeepLModelPreferQualityOptimized
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("DEEPL_SERVER_URL")
+	if baseURL == "" {
+		baseURL = deepLProBaseURL
+		if strings.HasSuffix(cfg.APIKey, ":fx") {
+			baseURL = deepLFreeBaseURL
+		}
+	}
+
+	return &DeepL{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		cache:    cfg.Cache,
+		config:   cfg,
+		throttle: NewThrottle(cfg.MaxConcurrency),
+
+		usageRecorder: newUsageRecorder(),
+	}, nil
+}
+
+// Model returns the DeepL model type translations are made with.
+func (d *DeepL) Model() string {
+	return d.config.Model
+}
+
+// PromptVersion identifies the glossary, the only thing besides the text that
+// changes DeepL's translations.
+func (d *DeepL) PromptVersion() string {
+	if d.config.PromptVersion != "" {
+		return d.config.PromptVersion
+	}
+	if d.config.GlossaryID != "" {
+		return "glossary-" + d.config.GlossaryID
+	}
+	return "none"
+}
+
+// Sampling is empty: DeepL translates without sampling settings.
+func (d *DeepL) Sampling() string {
+	return ""
+}
+
+type deepLRequest struct {
+	Text                 []string `json:"text"`
+	SourceLang           string   `json:"source_lang,omitempty"`
+	TargetLang           string   `json:"target_lang"`
+	Context              string   `json:"context,omitempty"`
+	GlossaryID           string   `json:"glossary_id,omitempty"`
+	ModelType            string   `json:"model_type,omitempty"`
+	TagHandling          string   `json:"tag_handling"`
+	ShowBilledCharacters bool     `json:"show_billed_characters"`
+}
+
+type deepLResponse struct {
+	Translations []struct {
+		Text             string `json:"text"`
+		BilledCharacters int    `json:"billed_characters"`
+	} `json:"translations"`
+}
+
+type deepLError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *deepLError) Error() string {
+	return fmt.Sprintf("deepl: status %d: %s", e.StatusCode, e.Message)
+}
+
+// TranslateStream translates like Translate. DeepL does not stream, so the
+// translation is passed to onDelta at once.
+func (d *DeepL) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	translation, err := d.Translate(ctx, prompt, content, source, target, bookName)
+	if err != nil {
+		return "", err
+	}
+	emitWhole(onDelta, translation)
+	return translation, nil
+}
+
+// Translate translates content, which is either a batch of <SEGMENT_n>
+// segments or a single piece of HTML. Each segment is sent as a text of its
+// own, so the instructions around them are not translated.
+func (d *DeepL) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	cacheKey := generateCacheKey(prompt+content, source, target, d.config.Model, d.PromptVersion(), d.Sampling())
+	if cachedTranslation, found := d.cache.Get(cacheKey); found {
+		return cachedTranslation, nil
+	}
+
+	sourceLang, err := deepLLanguage(source, false)
+	if err != nil {
+		return "", err
+	}
+	targetLang, err := deepLLanguage(target, true)
+	if err != nil {
+		return "", err
+	}
+
+	matches := deepLSegmentPattern.FindAllStringSubmatch(content, -1)
+	texts := make([]string, 0, max(len(matches), 1))
+	for _, m := range matches {
+		texts = append(texts, m[2])
+	}
+	if len(matches) == 0 {
+		texts = append(texts, content)
+	}
+	for i, text := range texts {
+		texts[i] = deepLPlaceholderPattern.ReplaceAllString(text, `<span translate="no">$0</span>`)
+	}
+
+	req := deepLRequest{
+		SourceLang:           sourceLang,
+		TargetLang:           targetLang,
+		Context:              strings.TrimSpace(prompt),
+		GlossaryID:           d.config.GlossaryID,
+		ModelType:            d.config.Model,
+		TagHandling:          "html",
+		ShowBilledCharacters: true,
+	}
+	if bookName != "" {
+		req.Context = strings.TrimSpace(fmt.Sprintf("From the book %q.\n%s", bookName, req.Context))
+	}
+
+	translations := make([]string, 0, len(texts))
+	for start := 0; start < len(texts); start += deepLMaxTexts {
+		req.Text = texts[start:min(start+deepLMaxTexts, len(texts))]
+		resp, err := d.translateWithRetry(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("translateWithRetry: %w", err)
+		}
+		if len(resp.Translations) != len(req.Text) {
+			return "", fmt.Errorf("deepl returned %d translations for %d texts", len(resp.Translations), len(req.Text))
+		}
+
+		billed := 0
+		for _, t := range resp.Translations {
+			translations = append(translations, deepLProtectedPattern.ReplaceAllString(t.Text, "$1"))
+			billed += t.BilledCharacters
+		}
+		d.recordCharacters(ctx, d.config.Model, content, billed)
+	}
+
+	translation := translations[0]
+	if len(matches) > 0 {
+		var sb strings.Builder
+		for i, m := range matches {
+			sb.WriteString(fmt.Sprintf("<SEGMENT_%s>\n%s\n</SEGMENT_%s>\n\n", m[1], translations[i], m[1]))
+		}
+		translation = sb.String()
+	}
+
+	if cacheable(content, translation) {
+		if err := d.cache.SetWithTTL(cacheKey, translation, d.config.CacheTTL); err != nil {
+			fmt.Printf("Error caching translation: %v\n", err)
+		}
+	}
+
+	return translation, nil
+}
+
+// deepLLanguage returns the DeepL code of a language name such as "English";
+// codes such as "en" or "pt-PT" are passed through.
+func deepLLanguage(name string, target bool) (string, error) {
+	if lang, ok := deepLLanguages[strings.ToLower(strings.TrimSpace(name))]; ok {
+		if target {
+			return lang.target, nil
+		}
+		return lang.source, nil
+	}
+	if deepLCodePattern.MatchString(name) {
+		code := strings.ToUpper(name)
+		if !target {
+			// Source languages have no regional variants.
+			code, _, _ = strings.Cut(code, "-")
+		}
+		return code, nil
+	}
+	return "", fmt.Errorf("deepl: unsupported language %q: use a language name or a DeepL code such as EN-GB", name)
+}
+
+// translateWithRetry sends the request once the throttle allows it, backing off
+// when DeepL reports too many requests.
+func (d *DeepL) translateWithRetry(ctx context.Context, req deepLRequest) (*deepLResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for retries := 0; retries < maxRetries; retries++ {
+		if err = d.throttle.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		var resp *deepLResponse
+		resp, err = d.translate(ctx, body)
+		d.throttle.Release()
+
+		if err == nil {
+			d.throttle.ObserveSuccess()
+			return resp, nil
+		}
+
+		var apiErr *deepLError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500) {
+			delay := d.throttle.Backoff(RateLimit{RetryAfter: apiErr.RetryAfter}, time.Duration(retries+1)*time.Second)
+			fmt.Printf("\t\t\trate limited, retrying in %s\n", delay)
+			continue
+		}
+
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("max retries reached: %w", err)
+}
+
+func (d *DeepL) translate(ctx context.Context, body []byte) (*deepLResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "DeepL-Auth-Key "+d.config.APIKey)
+
+	httpResp, err := d.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		apiErr := &deepLError{StatusCode: httpResp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var errResp struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Message != "" {
+			apiErr.Message = errResp.Message
+		}
+		if seconds, err := strconv.Atoi(httpResp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+
+		switch httpResp.StatusCode {
+		case http.StatusTooManyRequests:
+			return nil, fmt.Errorf("%w: %w", ErrRateLimitExceeded, apiErr)
+		case statusQuotaExceeded:
+			apiErr.Message = "character quota exceeded"
+		}
+		return nil, apiErr
+	}
+
+	var resp deepLResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,85 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeepLTranslateSegments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/translate" || r.Header.Get("Authorization") != "DeepL-Auth-Key test-key:fx" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+
+		var req deepLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.SourceLang != "EN" || req.TargetLang != "VI" || req.GlossaryID != "g-1" || req.TagHandling != "html" {
+			t.Errorf("unexpected request %+v", req)
+		}
+
+		var resp deepLResponse
+		for _, text := range req.Text {
+			if strings.Contains(text, "SEGMENT") || strings.Contains(text, "Translate the following") {
+				t.Errorf("instructions sent for translation: %q", text)
+			}
+			resp.Translations = append(resp.Translations, struct {
+				Text             string `json:"text"`
+				BilledCharacters int    `json:"billed_characters"`
+			}{strings.ReplaceAll(text, "Hello", "Xin chào"), len(text)})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	t.Setenv("DEEPL_SERVER_URL", server.URL)
+
+	d, err := NewDeepL(&Config{APIKey: "test-key:fx", GlossaryID: "g-1", Cache: NoopCache{}, MaxConcurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "Translate the following HTML segments.\n\n<SEGMENT_0>\nHello <em>world</em>\n</SEGMENT_0>\n\n<SEGMENT_1>\nHello {{MATH_0}}\n</SEGMENT_1>\n\n"
+	got, err := d.Translate(context.Background(), "", content, "English", "Vietnamese", "Book")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<SEGMENT_0>\nXin chào <em>world</em>\n</SEGMENT_0>\n\n<SEGMENT_1>\nXin chào {{MATH_0}}\n</SEGMENT_1>\n\n"
+	if got != want {
+		t.Errorf("Translate() = %q, want %q", got, want)
+	}
+	if usage := d.Usage(); usage.Calls != 1 || usage.Characters == 0 {
+		t.Errorf("Usage() = %+v", usage)
+	}
+	if d.PromptVersion() != "glossary-g-1" {
+		t.Errorf("PromptVersion() = %q", d.PromptVersion())
+	}
+}
+
+func TestDeepLLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  bool
+		want    string
+		wantErr bool
+	}{
+		{"English", false, "EN", false},
+		{"English", true, "EN-US", false},
+		{"en-GB", true, "EN-GB", false},
+		{"pt-PT", false, "PT", false},
+		{"Klingon", true, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := deepLLanguage(tt.name, tt.target)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("deepLLanguage(%q, %v) = %q, %v; want %q", tt.name, tt.target, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+const (
+	GeminiModel1Dot5Pro   = "gemini-1.5-pro"
+	GeminiModel1Dot5Flash = "gemini-1.5-flash"
+
+	geminiDefaultBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+func init() {
+	Register("gemini", factoryOf(NewGemini))
+}
+
+// Gemini translates with the Google Gemini API.
+type Gemini struct {
+	baseURL  string
+	client   *http.Client
+	cache    Cache
+	config   *Config
+	throttle *Throttle
+
+	*usageRecorder
+}
+
+// NewGemini returns a Gemini translator. The API key defaults to
+// GEMINI_API_KEY, or GOOGLE_API_KEY.
+func NewGemini(cfg *Config) (*Gemini, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("GOOGLE_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing GEMINI_API_KEY")
+	}
+	if cfg.Model == "" {
+		cfg.Model = GeminiModel1Dot5Flash
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("GEMINI_BASE_URL")
+	if baseURL == "" {
+		baseURL = geminiDefaultBaseURL
+	}
+
+	return &Gemini{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		cache:    cfg.Cache,
+		config:   cfg,
+		throttle: NewThrottle(cfg.MaxConcurrency),
+
+		usageRecorder: newUsageRecorder(),
+	}, nil
+}
+
+// Model returns the model translations are made with.
+func (g *Gemini) Model() string {
+	return g.config.Model
+}
+
+// PromptVersion returns the prompt version used in this translator's cache keys.
+func (g *Gemini) PromptVersion() string {
+	if g.config.PromptVersion != "" {
+		return g.config.PromptVersion
+	}
+	return PromptVersion(g.config.TranslationGuidelines, g.config.SystemPrompt)
+}
+
+// Sampling describes the sampling settings, seed included.
+func (g *Gemini) Sampling() string {
+	return g.config.sampling(true).String()
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction geminiContent   `json:"systemInstruction"`
+	Contents          []geminiContent `json:"contents"`
+	GenerationConfig  struct {
+		Temperature     float32 `json:"temperature"`
+		TopP            float32 `json:"topP,omitempty"`
+		Seed            *int64  `json:"seed,omitempty"`
+		MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// text joins the parts of the first candidate.
+func (r *geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+type geminiError struct {
+	StatusCode int
+	Status     string
+	Message    string
+	// RetryDelay is the delay the API asks for before retrying, if any.
+	RetryDelay time.Duration
+}
+
+func (e *geminiError) Error() string {
+	return fmt.Sprintf("gemini: %s (%d): %s", e.Status, e.StatusCode, e.Message)
+}
+
+func (g *Gemini) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	return g.TranslateStream(ctx, prompt, content, source, target, bookName, nil)
+}
+
+// TranslateStream translates like Translate, streaming the response when
+// onDelta is set.
+func (g *Gemini) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	cacheKey := generateCacheKey(prompt+content, source, target, g.config.Model, g.PromptVersion(), g.Sampling())
+
+	if cachedTranslation, found := g.cache.Get(cacheKey); found {
+		emitWhole(onDelta, cachedTranslation)
+		return cachedTranslation, nil
+	}
+
+	req := geminiRequest{
+		SystemInstruction: geminiContent{Parts: []geminiPart{{Text: createTranslationSystem(source, target, g.config.TranslationGuidelines, bookName)}}},
+		Contents: []geminiContent{{
+			Role:  "user",
+			Parts: []geminiPart{{Text: "Translate this and not say anything otherwise the translation: " + content}},
+		}},
+	}
+	if prompt != "" {
+		req.SystemInstruction.Parts = append(req.SystemInstruction.Parts, geminiPart{Text: prompt})
+	}
+	req.GenerationConfig.Temperature = g.config.Temperature
+	req.GenerationConfig.TopP = g.config.TopP
+	req.GenerationConfig.Seed = g.config.Seed
+	req.GenerationConfig.MaxOutputTokens = g.config.MaxTokens
+
+	resp, err := g.generateContentWithRetry(ctx, req, onDelta)
+	if err != nil {
+		return "", fmt.Errorf("generateContentWithRetry: %w", err)
+	}
+
+	translation := resp.text()
+	if translation == "" {
+		if resp.PromptFeedback.BlockReason != "" {
+			return "", fmt.Errorf("no translation received: prompt blocked (%s)", resp.PromptFeedback.BlockReason)
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != "" {
+			return "", fmt.Errorf("no translation received: %s", resp.Candidates[0].FinishReason)
+		}
+		return "", errors.New("no translation received")
+	}
+
+	if cacheable(content, translation) {
+		if err := g.cache.SetWithTTL(cacheKey, translation, g.config.CacheTTL); err != nil {
+			fmt.Printf("Error caching translation: %v\n", err)
+		}
+	}
+
+	// Usage is recorded in the same shape as Anthropic's, cached prompt
+	// tokens counting as cache reads rather than input.
+	cached := resp.UsageMetadata.CachedContentTokenCount
+	g.record(ctx, g.config.Model, content, anthropic.MessagesUsage{
+		InputTokens:          resp.UsageMetadata.PromptTokenCount - cached,
+		OutputTokens:         resp.UsageMetadata.CandidatesTokenCount,
+		CacheReadInputTokens: cached,
+	})
+
+	return translation, nil
+}
+
+// generateContentWithRetry sends the request once the throttle allows it.
+// Gemini reports no remaining budget, so concurrency grows with successes and
+// is halved whenever the API answers RESOURCE_EXHAUSTED, after which requests
+// wait for the retry delay the API asks for.
+func (g *Gemini) generateContentWithRetry(ctx context.Context, req geminiRequest, onDelta func(delta string)) (*geminiResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for retries := 0; retries < maxRetries; retries++ {
+		if err = g.throttle.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		var resp *geminiResponse
+		resp, err = g.generateContent(ctx, body, onDelta)
+		g.throttle.Release()
+
+		if err == nil {
+			g.throttle.ObserveSuccess()
+			return resp, nil
+		}
+
+		var apiErr *geminiError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusServiceUnavailable) {
+			delay := g.throttle.Backoff(RateLimit{RetryAfter: apiErr.RetryDelay}, time.Duration(retries+1)*time.Second)
+			fmt.Printf("\t\t\trate limited, retrying in %s\n", delay)
+			continue
+		}
+
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("max retries reached: %w", err)
+}
+
+// generateContent sends the request, to the streaming endpoint when onDelta
+// is set.
+func (g *Gemini) generateContent(ctx context.Context, body []byte, onDelta func(delta string)) (*geminiResponse, error) {
+	url := fmt.Sprintf("%s/models/%s:generateContent", g.baseURL, g.config.Model)
+	if onDelta != nil {
+		url = fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", g.baseURL, g.config.Model)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", g.config.APIKey)
+
+	httpResp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode == http.StatusOK && onDelta != nil {
+		return readGeminiStream(httpResp.Body, onDelta)
+	}
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		apiErr := parseGeminiError(httpResp.StatusCode, data)
+		if apiErr.RetryDelay == 0 {
+			if seconds, err := strconv.Atoi(httpResp.Header.Get("Retry-After")); err == nil {
+				apiErr.RetryDelay = time.Duration(seconds) * time.Second
+			}
+		}
+		if httpResp.StatusCode == http.StatusTooManyRequests {
+			return nil, fmt.Errorf("%w: %w", ErrRateLimitExceeded, apiErr)
+		}
+		return nil, apiErr
+	}
+
+	var resp geminiResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// readGeminiStream reads a streamed response, passing the text of every chunk
+// to onDelta, into the response it would have been unstreamed: the text of
+// all chunks, with the finish reason and usage of the last.
+func readGeminiStream(r io.Reader, onDelta func(delta string)) (*geminiResponse, error) {
+	var resp geminiResponse
+	var text strings.Builder
+
+	err := readEvents(r, func(data []byte) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		if delta := chunk.text(); delta != "" {
+			text.WriteString(delta)
+			onDelta(delta)
+		}
+		if len(chunk.Candidates) > 0 {
+			resp.Candidates = chunk.Candidates[:1]
+		}
+		if chunk.PromptFeedback.BlockReason != "" {
+			resp.PromptFeedback = chunk.PromptFeedback
+		}
+		if chunk.UsageMetadata.PromptTokenCount > 0 {
+			resp.UsageMetadata = chunk.UsageMetadata
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Candidates) > 0 {
+		resp.Candidates[0].Content.Parts = []geminiPart{{Text: text.String()}}
+	}
+	return &resp, nil
+}
+
+// parseGeminiError reads a Google API error, including the retry delay of its
+// google.rpc.RetryInfo detail.
+func parseGeminiError(statusCode int, data []byte) *geminiError {
+	apiErr := &geminiError{StatusCode: statusCode, Message: strings.TrimSpace(string(data))}
+
+	var errResp struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &errResp) != nil {
+		return apiErr
+	}
+
+	if errResp.Error.Message != "" {
+		apiErr.Message = errResp.Error.Message
+	}
+	apiErr.Status = errResp.Error.Status
+	for _, detail := range errResp.Error.Details {
+		if strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") {
+			if d, err := time.ParseDuration(detail.RetryDelay); err == nil {
+				apiErr.RetryDelay = d
+			}
+		}
+	}
+
+	return apiErr
+}
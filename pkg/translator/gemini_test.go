@@ -0,0 +1,100 @@
+package translator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGeminiTranslateRetriesRateLimit(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/models/gemini-1.5-pro:generateContent" || r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED", "message": "quota exceeded",
+				"details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "0.05s"}]}}`))
+			return
+		}
+
+		w.Write([]byte(`{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "Xin "}, {"text": "chào"}]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 200, "candidatesTokenCount": 4, "cachedContentTokenCount": 150}
+		}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("GEMINI_BASE_URL", server.URL)
+
+	g, err := NewGemini(&Config{APIKey: "test-key", Model: GeminiModel1Dot5Pro, Cache: NoopCache{}, MaxConcurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	got, err := g.Translate(context.Background(), "", "Hello", "English", "Vietnamese", "Book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Xin chào" {
+		t.Errorf("Translate() = %q", got)
+	}
+	if calls != 2 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected one retry after the retry delay, got %d calls in %s", calls, time.Since(start))
+	}
+
+	usage := g.Usage()
+	if usage.Calls != 1 || usage.InputTokens != 50 || usage.CacheReadTokens != 150 || usage.OutputTokens != 4 {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestGeminiTranslateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-1.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Xin "}]}}]}
+
+data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "chào"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 3}}
+
+`))
+	}))
+	defer server.Close()
+
+	t.Setenv("GEMINI_BASE_URL", server.URL)
+
+	g, err := NewGemini(&Config{APIKey: "test-key", Cache: NoopCache{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas []string
+	got, err := g.TranslateStream(context.Background(), "", "Hello", "English", "Vietnamese", "Book", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Xin chào" || len(deltas) != 2 {
+		t.Errorf("TranslateStream() = %q with deltas %q", got, deltas)
+	}
+	if usage := g.Usage(); usage.InputTokens != 20 || usage.OutputTokens != 3 {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestParseGeminiError(t *testing.T) {
+	apiErr := parseGeminiError(http.StatusBadRequest, []byte(`{"error": {"code": 400, "status": "INVALID_ARGUMENT", "message": "API key not valid"}}`))
+	if apiErr.Status != "INVALID_ARGUMENT" || apiErr.Message != "API key not valid" || apiErr.RetryDelay != 0 {
+		t.Errorf("parseGeminiError() = %+v", apiErr)
+	}
+}
@@ -0,0 +1,315 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dutchsteven/epubtrans/pkg/util"
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+const (
+	// Metadata is written after this many calls or this much time, whichever comes first.
+	metadataFlushCalls    = 10
+	metadataFlushInterval = 30 * time.Second
+
+	// maxTokenUsageEntries bounds the per-call usage history; the totals keep counting.
+	maxTokenUsageEntries = 100
+	maxPromptExamples    = 5
+)
+
+type UsageMetadata struct {
+	TotalCalls     int                       `json:"total_calls"`
+	LastUsed       time.Time                 `json:"last_used"`
+	ModelUsage     map[string]int            `json:"model_usage"`
+	PromptExamples []string                  `json:"prompt_examples"`
+	TokenUsage     uint64                    `json:"token_usage"`
+	TokenUsageList []anthropic.MessagesUsage `json:"token_usage_list"` // the most recent calls only
+	// Totals over all runs.
+	InputTokens      uint64 `json:"input_tokens"`
+	OutputTokens     uint64 `json:"output_tokens"`
+	CacheReadTokens  uint64 `json:"cache_read_tokens"`
+	CacheWriteTokens uint64 `json:"cache_write_tokens"`
+	// Characters billed by providers that charge per character, such as DeepL.
+	Characters uint64 `json:"characters,omitempty"`
+}
+
+func newUsageMetadata() *UsageMetadata {
+	return &UsageMetadata{ModelUsage: make(map[string]int)}
+}
+
+// record adds one call to the metadata.
+func (m *UsageMetadata) record(model, content string, usage anthropic.MessagesUsage) {
+	m.TotalCalls++
+	m.LastUsed = time.Now()
+	m.ModelUsage[model]++
+	if len(m.PromptExamples) < maxPromptExamples {
+		m.PromptExamples = append(m.PromptExamples, content[:min(100, len(content))])
+	}
+
+	m.TokenUsage += uint64(usage.InputTokens + usage.OutputTokens)
+	m.TokenUsageList = append(m.TokenUsageList, usage)
+	m.InputTokens += uint64(usage.InputTokens)
+	m.OutputTokens += uint64(usage.OutputTokens)
+	m.CacheReadTokens += uint64(usage.CacheReadInputTokens)
+	m.CacheWriteTokens += uint64(usage.CacheCreationInputTokens)
+}
+
+// merge adds the calls recorded in delta and trims the usage history.
+func (m *UsageMetadata) merge(delta *UsageMetadata) {
+	m.TotalCalls += delta.TotalCalls
+	if delta.LastUsed.After(m.LastUsed) {
+		m.LastUsed = delta.LastUsed
+	}
+	for model, calls := range delta.ModelUsage {
+		m.ModelUsage[model] += calls
+	}
+	for _, example := range delta.PromptExamples {
+		if len(m.PromptExamples) < maxPromptExamples {
+			m.PromptExamples = append(m.PromptExamples, example)
+		}
+	}
+
+	m.TokenUsage += delta.TokenUsage
+	m.TokenUsageList = append(m.TokenUsageList, delta.TokenUsageList...)
+	if extra := len(m.TokenUsageList) - maxTokenUsageEntries; extra > 0 {
+		m.TokenUsageList = append([]anthropic.MessagesUsage(nil), m.TokenUsageList[extra:]...)
+	}
+	m.InputTokens += delta.InputTokens
+	m.OutputTokens += delta.OutputTokens
+	m.CacheReadTokens += delta.CacheReadTokens
+	m.CacheWriteTokens += delta.CacheWriteTokens
+	m.Characters += delta.Characters
+}
+
+// readUsageMetadata reads the metadata file; a missing file gives empty metadata.
+func readUsageMetadata(path string) (*UsageMetadata, error) {
+	m := newUsageMetadata()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		// Older versions wrote token_usage as an empty object; keep the rest.
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+	}
+	if m.ModelUsage == nil {
+		m.ModelUsage = make(map[string]int)
+	}
+
+	return m, nil
+}
+
+// usageRecorder keeps the token usage of a translator and batches its writes
+// to the metadata file shared by all translators.
+type usageRecorder struct {
+	mu       sync.Mutex // guards everything below
+	metadata *UsageMetadata
+	// pending holds the calls not yet written to the metadata file.
+	pending *UsageMetadata
+	// held holds the calls made with a usage key until it is released.
+	held      map[string]*UsageMetadata
+	lastFlush time.Time
+	usage     UsageStats
+}
+
+type usageKeyContextKey struct{}
+
+// WithUsageKey returns a context whose calls are held back from the metadata
+// file until ReleaseUsage(key). Workers translating chapters concurrently
+// release their chapters in reading order, so the per-call history comes out
+// the same whatever order the calls finish in.
+func WithUsageKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, usageKeyContextKey{}, key)
+}
+
+type usageObserverContextKey struct{}
+
+// WithUsageObserver returns a context whose calls are also reported to
+// observe with the usage of each call, so callers can attribute them, e.g.
+// to the user who asked for them.
+func WithUsageObserver(ctx context.Context, observe func(UsageStats)) context.Context {
+	return context.WithValue(ctx, usageObserverContextKey{}, observe)
+}
+
+// observeUsage reports the usage of one call to the observer of ctx, if any.
+func observeUsage(ctx context.Context, usage UsageStats) {
+	if observe, ok := ctx.Value(usageObserverContextKey{}).(func(UsageStats)); ok {
+		observe(usage)
+	}
+}
+
+func usageKey(ctx context.Context) string {
+	key, _ := ctx.Value(usageKeyContextKey{}).(string)
+	return key
+}
+
+func newUsageRecorder() *usageRecorder {
+	r := &usageRecorder{metadata: newUsageMetadata(), pending: newUsageMetadata(), held: make(map[string]*UsageMetadata), lastFlush: time.Now()}
+
+	m, err := readUsageMetadata(metadataFilePath())
+	if err != nil {
+		fmt.Printf("Error reading metadata: %v\n", err)
+		return r
+	}
+	r.metadata = m
+
+	return r
+}
+
+func metadataFilePath() string {
+	return filepath.Join("unpackage", "translator_metadata.json")
+}
+
+// record adds one call to the usage of the run and to the pending metadata,
+// or to the held metadata of the usage key of ctx.
+func (r *usageRecorder) record(ctx context.Context, model, content string, usage anthropic.MessagesUsage) {
+	var call UsageStats
+	call.add(usage)
+	observeUsage(ctx, call)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.usage.add(usage)
+	r.metadataFor(ctx).record(model, content, usage)
+	r.maybeFlushMetadata()
+}
+
+// recordCharacters adds one call billed by characters rather than tokens.
+func (r *usageRecorder) recordCharacters(ctx context.Context, model, content string, characters int) {
+	observeUsage(ctx, UsageStats{Calls: 1, Characters: characters})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.usage.Calls++
+	r.usage.Characters += characters
+	m := r.metadataFor(ctx)
+	m.record(model, content, anthropic.MessagesUsage{})
+	m.Characters += uint64(characters)
+	r.maybeFlushMetadata()
+}
+
+// metadataFor returns the metadata the calls made with ctx are added to. The
+// caller holds r.mu.
+func (r *usageRecorder) metadataFor(ctx context.Context) *UsageMetadata {
+	key := usageKey(ctx)
+	if key == "" {
+		return r.pending
+	}
+	m, ok := r.held[key]
+	if !ok {
+		m = newUsageMetadata()
+		r.held[key] = m
+	}
+	return m
+}
+
+// ReleaseUsage adds the calls held back for key to the pending metadata.
+func (r *usageRecorder) ReleaseUsage(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.release(key)
+	r.maybeFlushMetadata()
+}
+
+// release moves the calls held for key to the pending metadata. The caller
+// holds r.mu.
+func (r *usageRecorder) release(key string) {
+	if m, ok := r.held[key]; ok {
+		r.pending.merge(m)
+		delete(r.held, key)
+	}
+}
+
+// Usage returns the token usage of the current run.
+func (r *usageRecorder) Usage() UsageStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+// maybeFlushMetadata writes the pending metadata once enough calls or time
+// have accumulated. The caller holds r.mu.
+func (r *usageRecorder) maybeFlushMetadata() {
+	if r.pending.TotalCalls < metadataFlushCalls && time.Since(r.lastFlush) < metadataFlushInterval {
+		return
+	}
+	if err := r.flushMetadata(); err != nil {
+		fmt.Printf("Error writing metadata: %v\n", err)
+	}
+}
+
+// FlushMetadata writes the usage metadata recorded since the last write,
+// releasing the calls still held back in the order of their keys.
+func (r *usageRecorder) FlushMetadata() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.held))
+	for key := range r.held {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.release(key)
+	}
+	return r.flushMetadata()
+}
+
+// flushMetadata merges the pending calls into the file on disk rather than
+// overwriting it, so a CLI run and serve sharing the file do not lose each
+// other's calls. The caller holds r.mu.
+func (r *usageRecorder) flushMetadata() error {
+	r.lastFlush = time.Now()
+	if r.pending.TotalCalls == 0 {
+		return nil
+	}
+
+	path := metadataFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	unlock, err := util.LockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := readUsageMetadata(path)
+	if err != nil {
+		return err
+	}
+	m.merge(r.pending)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling metadata: %w", err)
+	}
+
+	if err := util.WriteFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+
+	r.metadata = m
+	r.pending = newUsageMetadata()
+	return nil
+}
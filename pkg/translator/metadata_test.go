@@ -0,0 +1,145 @@
+package translator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+func TestReadUsageMetadata(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantCalls int
+		wantErr   bool
+	}{
+		{"missing file", "", 0, false},
+		{"current format", `{"total_calls": 3, "token_usage": 42}`, 3, false},
+		{"legacy token usage object", `{"total_calls": 7, "token_usage": {}}`, 7, false},
+		{"corrupt file", `{"total_calls": 7,`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metadata.json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, err := readUsageMetadata(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readUsageMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && m.TotalCalls != tt.wantCalls {
+				t.Errorf("TotalCalls = %d, want %d", m.TotalCalls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUsageMetadataMergeRotates(t *testing.T) {
+	m := newUsageMetadata()
+	for i := 0; i < 3; i++ {
+		delta := newUsageMetadata()
+		for j := 0; j < maxTokenUsageEntries; j++ {
+			delta.record("model", "content", anthropic.MessagesUsage{InputTokens: 1, OutputTokens: 2})
+		}
+		m.merge(delta)
+	}
+
+	if got := len(m.TokenUsageList); got != maxTokenUsageEntries {
+		t.Errorf("len(TokenUsageList) = %d, want %d", got, maxTokenUsageEntries)
+	}
+	if m.TotalCalls != 3*maxTokenUsageEntries || m.InputTokens != 3*maxTokenUsageEntries || m.TokenUsage != 9*maxTokenUsageEntries {
+		t.Errorf("totals = %d calls, %d input, %d tokens", m.TotalCalls, m.InputTokens, m.TokenUsage)
+	}
+	if len(m.PromptExamples) != maxPromptExamples {
+		t.Errorf("len(PromptExamples) = %d, want %d", len(m.PromptExamples), maxPromptExamples)
+	}
+}
+
+func TestFlushMetadataConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// Two translators stand in for a CLI run and serve sharing the file.
+	writers := []*usageRecorder{newUsageRecorder(), newUsageRecorder()}
+
+	var wg sync.WaitGroup
+	for _, r := range writers {
+		wg.Add(1)
+		go func(r *usageRecorder) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				r.record(context.Background(), "model", "content", anthropic.MessagesUsage{InputTokens: 1})
+				if err := r.FlushMetadata(); err != nil {
+					t.Error(err)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	m, err := readUsageMetadata(metadataFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TotalCalls != 40 {
+		t.Errorf("TotalCalls = %d, want 40", m.TotalCalls)
+	}
+}
+
+func TestUsageRecorderReleasesHeldCallsInOrder(t *testing.T) {
+	r := &usageRecorder{metadata: newUsageMetadata(), pending: newUsageMetadata(), held: make(map[string]*UsageMetadata), lastFlush: time.Now()}
+	first, second := WithUsageKey(context.Background(), "ch1"), WithUsageKey(context.Background(), "ch2")
+
+	// The second chapter finishes first.
+	r.record(second, "model", "second", anthropic.MessagesUsage{InputTokens: 2})
+	r.record(first, "model", "first", anthropic.MessagesUsage{InputTokens: 1})
+	if r.pending.TotalCalls != 0 || r.Usage().Calls != 2 {
+		t.Fatalf("held calls: pending %d, run usage %d", r.pending.TotalCalls, r.Usage().Calls)
+	}
+
+	r.ReleaseUsage("ch1")
+	r.ReleaseUsage("ch2")
+	var inputs []int
+	for _, usage := range r.pending.TokenUsageList {
+		inputs = append(inputs, usage.InputTokens)
+	}
+	if len(inputs) != 2 || inputs[0] != 1 || inputs[1] != 2 {
+		t.Errorf("usage history = %v, want [1 2]", inputs)
+	}
+	if r.pending.PromptExamples[0] != "first" {
+		t.Errorf("first prompt example = %q", r.pending.PromptExamples[0])
+	}
+}
+
+func TestUsageRecorderReportsCallsToObserver(t *testing.T) {
+	r := &usageRecorder{metadata: newUsageMetadata(), pending: newUsageMetadata(), held: make(map[string]*UsageMetadata), lastFlush: time.Now()}
+	var observed []UsageStats
+	ctx := WithUsageObserver(context.Background(), func(u UsageStats) { observed = append(observed, u) })
+
+	r.record(ctx, "model", "text", anthropic.MessagesUsage{InputTokens: 10, OutputTokens: 20})
+	r.recordCharacters(ctx, "model", "text", 4)
+	r.record(context.Background(), "model", "other", anthropic.MessagesUsage{InputTokens: 99})
+
+	want := []UsageStats{{Calls: 1, InputTokens: 10, OutputTokens: 20}, {Calls: 1, Characters: 4}}
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("observed %+v, want %+v", observed, want)
+	}
+}
@@ -0,0 +1,169 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MockModelPseudo is the model of the mock translator: pseudo-translations
+// made by accenting the letters of the original.
+const MockModelPseudo = "pseudo"
+
+// mockKeepPattern matches what a pseudo-translation leaves as it is: tags,
+// character references and placeholders such as {{MATH_0}} or {{/TAG_0}}.
+var mockKeepPattern = regexp.MustCompile(`<[^>]*>|&#?\w+;|\{\{/?[A-Z]+_\d+\}\}`)
+
+// mockLetters swaps letters with their accented forms both ways, so
+// pseudo-translating twice gives back the original.
+var mockLetters = strings.NewReplacer(
+	"a", "á", "e", "é", "i", "í", "o", "ó", "u", "ú", "y", "ý", "c", "ç", "n", "ñ",
+	"A", "Á", "E", "É", "I", "Í", "O", "Ó", "U", "Ú", "Y", "Ý", "C", "Ç", "N", "Ñ",
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ý", "y", "ç", "c", "ñ", "n",
+	"Á", "A", "É", "E", "Í", "I", "Ó", "O", "Ú", "U", "Ý", "Y", "Ç", "C", "Ñ", "N",
+)
+
+func init() {
+	Register("mock", factoryOf(NewMock))
+}
+
+// Mock is a translator that calls no model. It answers every segment with a
+// pseudo-translation keeping the markup, or with the translation recorded in
+// the fixture of cfg.Fixture, so the pipeline can be exercised, demonstrated
+// and measured without an API key or network.
+type Mock struct {
+	config  *Config
+	fixture *Fixture
+
+	mu    sync.Mutex
+	usage UsageStats
+	// replayed and missed count the segments found and not found in the
+	// fixture.
+	replayed int
+	missed   int
+}
+
+func NewMock(cfg *Config) (*Mock, error) {
+	if cfg.Model == "" {
+		cfg.Model = MockModelPseudo
+	}
+	m := &Mock{config: cfg}
+	if cfg.Fixture != "" {
+		fixture, err := LoadFixture(cfg.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		m.fixture = fixture
+	}
+	return m, nil
+}
+
+func (m *Mock) Model() string {
+	return m.config.Model
+}
+
+func (m *Mock) PromptVersion() string {
+	if m.config.PromptVersion != "" {
+		return m.config.PromptVersion
+	}
+	return PromptVersion(m.config.TranslationGuidelines, m.config.SystemPrompt)
+}
+
+// Sampling is empty: pseudo-translations are the same every time.
+func (m *Mock) Sampling() string {
+	return ""
+}
+
+// Translate pseudo-translates content, which is either a batch of
+// <SEGMENT_n> segments or a single piece of HTML.
+func (m *Mock) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	observeUsage(ctx, UsageStats{Calls: 1, Characters: len(content)})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.Calls++
+	m.usage.Characters += len(content)
+
+	if translation, ok := m.fixture.lookup(target, content); ok {
+		m.replayed++
+		return translation, nil
+	}
+
+	matches := deepLSegmentPattern.FindAllStringSubmatch(content, -1)
+	if len(matches) == 0 {
+		return m.translateSegment(target, content), nil
+	}
+	var sb strings.Builder
+	for _, match := range matches {
+		sb.WriteString(fmt.Sprintf("<SEGMENT_%s>\n%s\n</SEGMENT_%s>\n\n", match[1], m.translateSegment(target, match[2]), match[1]))
+	}
+	return sb.String(), nil
+}
+
+// translateSegment replays the recorded translation of a segment, or
+// pseudo-translates it.
+func (m *Mock) translateSegment(target, segment string) string {
+	if m.fixture == nil {
+		return pseudoTranslate(segment)
+	}
+	if translation, ok := m.fixture.lookupSegment(target, segment); ok {
+		m.replayed++
+		return translation
+	}
+	m.missed++
+	return pseudoTranslate(segment)
+}
+
+func (m *Mock) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	translation, err := m.Translate(ctx, prompt, content, source, target, bookName)
+	if err != nil {
+		return "", err
+	}
+	emitWhole(onDelta, translation)
+	return translation, nil
+}
+
+// Usage returns the calls of the current run and the characters sent.
+func (m *Mock) Usage() UsageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+// FlushMetadata keeps no metadata, as the calls of the mock are not worth
+// keeping, but reports how much of a fixture was replayed.
+func (m *Mock) FlushMetadata() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fixture != nil && m.missed > 0 {
+		fmt.Printf("Fixture: %d translations replayed, %d segments not recorded were pseudo-translated\n", m.replayed, m.missed)
+	}
+	return nil
+}
+
+func (m *Mock) ReleaseUsage(key string) {}
+
+// ReversePseudo returns the original of a pseudo-translation, so tests can
+// check that every segment came back to its place.
+func ReversePseudo(translation string) string {
+	return pseudoTranslate(translation)
+}
+
+// pseudoTranslate accents the letters of the text of html, and takes the
+// accents off those already accented.
+func pseudoTranslate(html string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range mockKeepPattern.FindAllStringIndex(html, -1) {
+		sb.WriteString(mockLetters.Replace(html[last:loc[0]]))
+		sb.WriteString(html[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	sb.WriteString(mockLetters.Replace(html[last:]))
+	return sb.String()
+}
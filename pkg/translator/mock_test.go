@@ -0,0 +1,98 @@
+package translator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+var _ Provider = (*Mock)(nil)
+
+func TestMockTranslate(t *testing.T) {
+	m, err := NewMock(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "Translate the following HTML segments.\n\n" +
+		"<SEGMENT_0>\nA <em>cat</em> &amp; {{MATH_0}}\n</SEGMENT_0>\n\n" +
+		"<SEGMENT_1>\nNo\n</SEGMENT_1>\n\n"
+	got, err := m.Translate(context.Background(), "", content, "English", "Vietnamese", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<SEGMENT_0>\nÁ <em>çát</em> &amp; {{MATH_0}}\n</SEGMENT_0>\n\n" +
+		"<SEGMENT_1>\nÑó\n</SEGMENT_1>\n\n"
+	if got != want {
+		t.Errorf("Translate() = %q, want %q", got, want)
+	}
+	if usage := m.Usage(); usage.Calls != 1 || usage.Characters != len(content) {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestReversePseudo(t *testing.T) {
+	for _, original := range []string{
+		"A <em>cat</em> &amp; {{MATH_0}}",
+		"Café, señor, año",
+		"Ça ne fait rien",
+	} {
+		if got := ReversePseudo(pseudoTranslate(original)); got != original {
+			t.Errorf("ReversePseudo(pseudoTranslate(%q)) = %q", original, got)
+		}
+	}
+}
+
+func TestMockReplaysRecordedFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	ctx := context.Background()
+
+	real, err := NewMock(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := NewRecorder(&fixedTranslator{Mock: real, translation: "<SEGMENT_0>\nHallo\n</SEGMENT_0>\n\n<SEGMENT_1>\nWereld\n</SEGMENT_1>\n\n"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := "<SEGMENT_0>\nHello\n</SEGMENT_0>\n\n<SEGMENT_1>\nWorld\n</SEGMENT_1>\n\n"
+	if _, err := recorder.Translate(ctx, "", batch, "English", "Dutch", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.FlushMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMock(&Config{Fixture: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Translate(ctx, "", batch, "English", "Dutch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<SEGMENT_0>\nHallo\n</SEGMENT_0>\n\n<SEGMENT_1>\nWereld\n</SEGMENT_1>\n\n"; got != want {
+		t.Errorf("replayed call = %q, want %q", got, want)
+	}
+
+	// Batched otherwise, the segments are still found; unknown ones are
+	// pseudo-translated.
+	got, err = m.Translate(ctx, "", "<SEGMENT_0>\nWorld\n</SEGMENT_0>\n\n<SEGMENT_1>\nNo\n</SEGMENT_1>\n\n", "English", "Dutch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<SEGMENT_0>\nWereld\n</SEGMENT_0>\n\n<SEGMENT_1>\nÑó\n</SEGMENT_1>\n\n"; got != want {
+		t.Errorf("rebatched call = %q, want %q", got, want)
+	}
+}
+
+// fixedTranslator answers every call with the same translation.
+type fixedTranslator struct {
+	*Mock
+	translation string
+}
+
+func (f *fixedTranslator) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	return f.translation, nil
+}
@@ -0,0 +1,51 @@
+package translator
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	OllamaModelLlama3Dot1 = "llama3.1"
+
+	ollamaDefaultHost = "http://localhost:11434"
+)
+
+func init() {
+	Register("ollama", factoryOf(NewOllama))
+}
+
+// NewOllama returns a translator for a local Ollama server, which needs no API
+// key and costs nothing per token. The server is OLLAMA_HOST, as for the
+// ollama command itself, and is spoken to through its OpenAI compatible API,
+// so caching, retries and usage accounting work as for OpenAI.
+func NewOllama(cfg *Config) (*OpenAI, error) {
+	if cfg.Model == "" {
+		cfg.Model = OllamaModelLlama3Dot1
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+
+	// Local models can take minutes for a long batch.
+	return newOpenAICompatible(cfg, ollamaHost()+"/v1", 30*time.Minute), nil
+}
+
+// ollamaHost returns OLLAMA_HOST as a URL; like the ollama command, it accepts
+// a bare host:port.
+func ollamaHost() string {
+	host := strings.TrimSuffix(os.Getenv("OLLAMA_HOST"), "/")
+	switch {
+	case host == "":
+		return ollamaDefaultHost
+	case strings.HasPrefix(host, "http://"), strings.HasPrefix(host, "https://"):
+		return host
+	case strings.HasPrefix(host, ":"):
+		return "http://localhost" + host
+	case !strings.Contains(host, ":"):
+		return "http://" + host + ":11434"
+	default:
+		return "http://" + host
+	}
+}
@@ -0,0 +1,25 @@
+package translator
+
+import "testing"
+
+func TestOllamaHost(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "http://localhost:11434"},
+		{"0.0.0.0:11434", "http://0.0.0.0:11434"},
+		{"gpu-box", "http://gpu-box:11434"},
+		{":8000", "http://localhost:8000"},
+		{"https://ollama.example.com/", "https://ollama.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", tt.env)
+			if got := ollamaHost(); got != tt.want {
+				t.Errorf("ollamaHost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
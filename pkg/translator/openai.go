@@ -0,0 +1,360 @@
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+const (
+	OpenAIModelGPT4o     = "gpt-4o"
+	OpenAIModelGPT4oMini = "gpt-4o-mini"
+
+	openAIDefaultBaseURL = "https://api.openai.com/v1"
+)
+
+func init() {
+	Register("openai", factoryOf(NewOpenAI))
+}
+
+// OpenAI translates with the OpenAI chat completions API.
+type OpenAI struct {
+	baseURL  string
+	client   *http.Client
+	cache    Cache
+	config   *Config
+	throttle *Throttle
+
+	*usageRecorder
+}
+
+// NewOpenAI returns an OpenAI translator. The API key defaults to
+// OPENAI_API_KEY and the endpoint to OPENAI_BASE_URL, if set.
+func NewOpenAI(cfg *Config) (*OpenAI, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing OPENAI_API_KEY")
+	}
+	if cfg.Model == "" {
+		cfg.Model = OpenAIModelGPT4o
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = openAIDefaultBaseURL
+	}
+
+	return newOpenAICompatible(cfg, baseURL, 5*time.Minute), nil
+}
+
+// newOpenAICompatible returns a translator for any server implementing the
+// OpenAI chat completions API at baseURL.
+func newOpenAICompatible(cfg *Config, baseURL string, timeout time.Duration) *OpenAI {
+	return &OpenAI{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   &http.Client{Timeout: timeout},
+		cache:    cfg.Cache,
+		config:   cfg,
+		throttle: NewThrottle(cfg.MaxConcurrency),
+
+		usageRecorder: newUsageRecorder(),
+	}
+}
+
+// Model returns the model translations are made with.
+func (o *OpenAI) Model() string {
+	return o.config.Model
+}
+
+// PromptVersion returns the prompt version used in this translator's cache keys.
+func (o *OpenAI) PromptVersion() string {
+	if o.config.PromptVersion != "" {
+		return o.config.PromptVersion
+	}
+	return PromptVersion(o.config.TranslationGuidelines, o.config.SystemPrompt)
+}
+
+// Sampling describes the sampling settings, seed included; Ollama takes a
+// seed as well.
+func (o *OpenAI) Sampling() string {
+	return o.config.sampling(true).String()
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Temperature   float32              `json:"temperature"`
+	TopP          float32              `json:"top_p,omitempty"`
+	Seed          *int64               `json:"seed,omitempty"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		// Delta is the piece of the message in a chunk of a streamed response.
+		Delta openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		PromptTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	} `json:"usage"`
+}
+
+type openAIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *openAIError) Error() string {
+	return fmt.Sprintf("openai: status %d: %s", e.StatusCode, e.Message)
+}
+
+func (o *OpenAI) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	return o.TranslateStream(ctx, prompt, content, source, target, bookName, nil)
+}
+
+// TranslateStream translates like Translate, streaming the response when
+// onDelta is set.
+func (o *OpenAI) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	cacheKey := generateCacheKey(prompt+content, source, target, o.config.Model, o.PromptVersion(), o.Sampling())
+
+	if cachedTranslation, found := o.cache.Get(cacheKey); found {
+		emitWhole(onDelta, cachedTranslation)
+		return cachedTranslation, nil
+	}
+
+	// As with Anthropic, the guidelines and the book context come first, so
+	// OpenAI's automatic prompt caching can reuse them across requests.
+	messages := []openAIMessage{
+		{Role: "system", Content: createTranslationSystem(source, target, o.config.TranslationGuidelines, bookName)},
+	}
+	if prompt != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: prompt})
+	}
+	messages = append(messages, openAIMessage{Role: "user", Content: "Translate this and not say anything otherwise the translation: " + content})
+
+	req := openAIRequest{
+		Model:       o.config.Model,
+		Messages:    messages,
+		Temperature: o.config.Temperature,
+		TopP:        o.config.TopP,
+		Seed:        o.config.Seed,
+		MaxTokens:   o.config.MaxTokens,
+	}
+	if onDelta != nil {
+		req.Stream, req.StreamOptions = true, &openAIStreamOptions{IncludeUsage: true}
+	}
+	resp, err := o.createChatCompletionWithRetry(ctx, req, onDelta)
+	if err != nil {
+		return "", fmt.Errorf("createChatCompletionWithRetry: %w", err)
+	}
+
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", errors.New("no translation received")
+	}
+
+	translation := resp.Choices[0].Message.Content
+	if cacheable(content, translation) {
+		if err := o.cache.SetWithTTL(cacheKey, translation, o.config.CacheTTL); err != nil {
+			fmt.Printf("Error caching translation: %v\n", err)
+		}
+	}
+
+	// Usage is recorded in the same shape as Anthropic's, cached prompt
+	// tokens counting as cache reads rather than input.
+	cached := resp.Usage.PromptTokensDetails.CachedTokens
+	o.record(ctx, o.config.Model, content, anthropic.MessagesUsage{
+		InputTokens:          resp.Usage.PromptTokens - cached,
+		OutputTokens:         resp.Usage.CompletionTokens,
+		CacheReadInputTokens: cached,
+	})
+
+	return translation, nil
+}
+
+// createChatCompletionWithRetry sends the request once the throttle allows it
+// and feeds the rate limit headers of every response back to the throttle.
+func (o *OpenAI) createChatCompletionWithRetry(ctx context.Context, req openAIRequest, onDelta func(delta string)) (*openAIResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for retries := 0; retries < maxRetries; retries++ {
+		if err = o.throttle.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		var resp *openAIResponse
+		var rateLimit RateLimit
+		resp, rateLimit, err = o.createChatCompletion(ctx, body, onDelta)
+		o.throttle.Release()
+
+		if err == nil {
+			o.throttle.Observe(rateLimit)
+			return resp, nil
+		}
+
+		var apiErr *openAIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500) {
+			delay := o.throttle.Backoff(rateLimit, time.Duration(retries+1)*time.Second)
+			fmt.Printf("\t\t\trate limited, retrying in %s\n", delay)
+			continue
+		}
+
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("max retries reached: %w", err)
+}
+
+func (o *OpenAI) createChatCompletion(ctx context.Context, body []byte, onDelta func(delta string)) (*openAIResponse, RateLimit, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, RateLimit{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.config.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+	}
+
+	httpResp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, RateLimit{}, err
+	}
+	defer httpResp.Body.Close()
+
+	rateLimit := openAIRateLimit(httpResp.Header)
+
+	if httpResp.StatusCode == http.StatusOK && onDelta != nil {
+		resp, err := readOpenAIStream(httpResp.Body, onDelta)
+		return resp, rateLimit, err
+	}
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, rateLimit, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			message = errResp.Error.Message
+		}
+		if httpResp.StatusCode == http.StatusTooManyRequests {
+			return nil, rateLimit, fmt.Errorf("%w: %w", ErrRateLimitExceeded, &openAIError{StatusCode: httpResp.StatusCode, Message: message})
+		}
+		return nil, rateLimit, &openAIError{StatusCode: httpResp.StatusCode, Message: message}
+	}
+
+	var resp openAIResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, rateLimit, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &resp, rateLimit, nil
+}
+
+// readOpenAIStream reads a streamed chat completion, passing the content of
+// every chunk to onDelta, into the response it would have been unstreamed.
+func readOpenAIStream(r io.Reader, onDelta func(delta string)) (*openAIResponse, error) {
+	var content strings.Builder
+	var resp openAIResponse
+
+	err := readEvents(r, func(data []byte) error {
+		var chunk openAIResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		if len(chunk.Choices) > 0 {
+			if resp.Choices == nil {
+				resp.Choices = chunk.Choices[:1]
+			}
+			if delta := chunk.Choices[0].Delta.Content; delta != "" {
+				content.WriteString(delta)
+				onDelta(delta)
+			}
+		}
+		// Only the last chunk carries the usage.
+		if chunk.Usage.PromptTokens > 0 {
+			resp.Usage = chunk.Usage
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) > 0 {
+		resp.Choices[0].Message = openAIMessage{Role: "assistant", Content: content.String()}
+	}
+	return &resp, nil
+}
+
+// openAIRateLimit reads the x-ratelimit-* headers of a response. Their reset
+// values are durations such as "1s" or "6m0s".
+func openAIRateLimit(header http.Header) RateLimit {
+	now := time.Now()
+	intHeader := func(name string) int {
+		n, err := strconv.Atoi(header.Get(name))
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	resetHeader := func(name string) time.Time {
+		d, err := time.ParseDuration(header.Get(name))
+		if err != nil {
+			return time.Time{}
+		}
+		return now.Add(d)
+	}
+
+	rl := RateLimit{
+		RequestsLimit:     intHeader("x-ratelimit-limit-requests"),
+		RequestsRemaining: intHeader("x-ratelimit-remaining-requests"),
+		RequestsReset:     resetHeader("x-ratelimit-reset-requests"),
+		TokensLimit:       intHeader("x-ratelimit-limit-tokens"),
+		TokensRemaining:   intHeader("x-ratelimit-remaining-tokens"),
+		TokensReset:       resetHeader("x-ratelimit-reset-tokens"),
+	}
+	if seconds, err := strconv.Atoi(header.Get("retry-after")); err == nil {
+		rl.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return rl
+}
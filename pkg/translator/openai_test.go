@@ -0,0 +1,120 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenAITranslate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Model != OpenAIModelGPT4oMini || len(req.Messages) != 3 {
+			t.Errorf("unexpected request: %+v", req)
+		}
+
+		w.Header().Set("x-ratelimit-limit-requests", "500")
+		w.Header().Set("x-ratelimit-remaining-requests", "499")
+		w.Write([]byte(`{
+			"choices": [{"message": {"role": "assistant", "content": "Xin chào"}}],
+			"usage": {"prompt_tokens": 120, "completion_tokens": 5, "prompt_tokens_details": {"cached_tokens": 100}}
+		}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+
+	o, err := NewOpenAI(&Config{APIKey: "test-key", Model: OpenAIModelGPT4oMini, Cache: NoopCache{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := o.Translate(context.Background(), "Glossary: hello = xin chào", "Hello", "English", "Vietnamese", "Book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Xin chào" {
+		t.Errorf("Translate() = %q", got)
+	}
+
+	usage := o.Usage()
+	if usage.Calls != 1 || usage.InputTokens != 20 || usage.CacheReadTokens != 100 || usage.OutputTokens != 5 {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestOpenAITranslateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("request is not streamed: %+v", req)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"choices":[{"delta":{"role":"assistant","content":""}}]}
+
+data: {"choices":[{"delta":{"content":"Xin "}}]}
+
+data: {"choices":[{"delta":{"content":"chào"}}]}
+
+data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}
+
+data: [DONE]
+
+`))
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+
+	o, err := NewOpenAI(&Config{APIKey: "test-key", Cache: NoopCache{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas []string
+	got, err := o.TranslateStream(context.Background(), "", "Hello", "English", "Vietnamese", "Book", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Xin chào" || len(deltas) != 2 {
+		t.Errorf("TranslateStream() = %q with deltas %q", got, deltas)
+	}
+	if usage := o.Usage(); usage.InputTokens != 12 || usage.OutputTokens != 3 {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestOpenAIRateLimit(t *testing.T) {
+	header := http.Header{}
+	header.Set("x-ratelimit-limit-tokens", "30000")
+	header.Set("x-ratelimit-remaining-tokens", "1200")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+	header.Set("retry-after", "7")
+
+	rl := openAIRateLimit(header)
+	if rl.TokensLimit != 30000 || rl.TokensRemaining != 1200 || rl.RequestsLimit != 0 {
+		t.Errorf("limits = %+v", rl)
+	}
+	if d := time.Until(rl.TokensReset); d < 5*time.Minute || d > 6*time.Minute {
+		t.Errorf("TokensReset in %s, want about 6m", d)
+	}
+	if rl.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %s", rl.RetryAfter)
+	}
+}
@@ -0,0 +1,83 @@
+package translator
+
+import (
+	"math"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+// Price is the list price of a model in US dollars per million tokens. APIs
+// that bill the characters of the source text instead, such as DeepL, set
+// Characters, the price per million characters.
+type Price struct {
+	Provider   string
+	Model      string
+	Input      float64
+	Output     float64
+	Characters float64
+}
+
+// Prices lists the list prices of the default and common models of every
+// provider, without discounts for prompt caching or batch APIs. Local models
+// served by Ollama cost nothing.
+var Prices = []Price{
+	{Provider: "anthropic", Model: string(anthropic.ModelClaude3Dot5SonnetLatest), Input: 3, Output: 15},
+	{Provider: "anthropic", Model: "claude-3-5-haiku-latest", Input: 0.8, Output: 4},
+	{Provider: "anthropic", Model: string(anthropic.ModelClaude3Haiku20240307), Input: 0.25, Output: 1.25},
+	{Provider: "anthropic", Model: string(anthropic.ModelClaude3Opus20240229), Input: 15, Output: 75},
+	{Provider: "openai", Model: OpenAIModelGPT4o, Input: 2.5, Output: 10},
+	{Provider: "openai", Model: OpenAIModelGPT4oMini, Input: 0.15, Output: 0.6},
+	{Provider: "gemini", Model: GeminiModel1Dot5Flash, Input: 0.075, Output: 0.3},
+	{Provider: "gemini", Model: GeminiModel1Dot5Pro, Input: 1.25, Output: 5},
+	{Provider: "deepl", Model: DeepLModelPreferQualityOptimized, Characters: 25},
+	{Provider: "ollama", Model: OllamaModelLlama3Dot1},
+}
+
+// Cost returns the price of a run with the given usage.
+func (p Price) Cost(inputTokens, outputTokens, characters int) float64 {
+	return (float64(inputTokens)*p.Input + float64(outputTokens)*p.Output + float64(characters)*p.Characters) / 1e6
+}
+
+// UsageCost returns the price of the calls of usage. Prompt cache reads cost
+// a tenth of an input token and cache writes a quarter more.
+func (p Price) UsageCost(usage UsageStats) float64 {
+	input := float64(usage.InputTokens) + 0.1*float64(usage.CacheReadTokens) + 1.25*float64(usage.CacheWriteTokens)
+	return (input*p.Input + float64(usage.OutputTokens)*p.Output + float64(usage.Characters)*p.Characters) / 1e6
+}
+
+// PriceOf returns the list price of a model of a provider, if it is known.
+func PriceOf(provider, model string) (Price, bool) {
+	for _, p := range Prices {
+		if p.Provider == provider && p.Model == model {
+			return p, true
+		}
+	}
+	return Price{}, false
+}
+
+// EstimateTokens approximates the number of tokens of text without the
+// tokenizer of a model: about four characters of ASCII text make a token,
+// every CJK character is a token of its own, and other letters, such as the
+// accented letters of Vietnamese, take half a token each.
+func EstimateTokens(text string) int {
+	var tokens float64
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+			tokens += 0.25
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			tokens++
+		default:
+			tokens += 0.5
+		}
+	}
+	return int(math.Ceil(tokens))
+}
+
+// SystemPrompt returns the system prompt the token-billed providers send with
+// every request, without the book context.
+func SystemPrompt(source, target, guidelines, bookName string) string {
+	return createTranslationSystem(source, target, guidelines, bookName)
+}
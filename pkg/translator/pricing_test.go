@@ -0,0 +1,62 @@
+package translator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"Hello, world", 3},
+		{"Xin chào", 3},
+		{"日本語", 3},
+		{"<p>b</p>", 2},
+	}
+
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPriceCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		price Price
+		want  float64
+	}{
+		{"tokens", Price{Input: 3, Output: 15}, 3 + 7.5},
+		{"characters", Price{Characters: 25}, 5},
+		{"free", Price{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.price.Cost(1e6, 5e5, 2e5); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Cost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceUsageCost(t *testing.T) {
+	price := Price{Input: 3, Output: 15}
+	usage := UsageStats{Calls: 1, InputTokens: 1e6, OutputTokens: 1e5, CacheReadTokens: 1e6, CacheWriteTokens: 4e5}
+	// 1M input, 0.1M for the cache reads and 0.5M for the cache writes.
+	if got, want := price.UsageCost(usage), 1.6*3+0.1*15; math.Abs(got-want) > 1e-9 {
+		t.Errorf("UsageCost() = %v, want %v", got, want)
+	}
+
+	if _, ok := PriceOf("ollama", OllamaModelLlama3Dot1); !ok {
+		t.Error("PriceOf() found no price for the default Ollama model")
+	}
+	if _, ok := PriceOf("openai", "no-such-model"); ok {
+		t.Error("PriceOf() found a price for an unknown model")
+	}
+}
@@ -0,0 +1,116 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/liushuangls/go-anthropic/v2"
+)
+
+// ProbeError is a failed Probe, with a hint of what to fix.
+type ProbeError struct {
+	Hint string
+	Err  error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Hint, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// Probe translates a single word with t, to check the API key, the model
+// name and the connection before translating a book. The error it returns
+// is a *ProbeError that tells what is likely wrong.
+func Probe(ctx context.Context, t Translator, source, target string) error {
+	if _, err := t.Translate(ctx, "", "Hello", source, target, ""); err != nil {
+		_, hint := Diagnose(err)
+		if hint == "" {
+			hint = "the provider failed a test request"
+		}
+		return &ProbeError{Hint: hint, Err: err}
+	}
+	return nil
+}
+
+// Classes of the errors of a provider, see Diagnose.
+const (
+	ErrorClassAuth      = "auth"
+	ErrorClassModel     = "model"
+	ErrorClassRateLimit = "rate_limit"
+	ErrorClassQuota     = "quota"
+	ErrorClassTimeout   = "timeout"
+	ErrorClassNetwork   = "network"
+)
+
+const rateLimitHint = "the provider keeps rate limiting the API key; wait a minute and try again"
+
+// Diagnose tells what an error of a provider likely means: its class and a
+// hint of what to fix. Both are empty for errors it does not recognize.
+func Diagnose(err error) (class, hint string) {
+	var apiErr *anthropic.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case anthropic.ErrTypeAuthentication:
+			return ErrorClassAuth, "the API key was rejected; check ANTHROPIC_KEY"
+		case anthropic.ErrTypePermission:
+			return ErrorClassAuth, "the API key may not use this model; check ANTHROPIC_KEY and --model"
+		case anthropic.ErrTypeNotFound:
+			return ErrorClassModel, "the model was not found; check --model"
+		case anthropic.ErrTypeRateLimit, anthropic.ErrTypeOverloaded:
+			return ErrorClassRateLimit, rateLimitHint
+		}
+	}
+
+	switch status := errorStatus(err); {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorClassAuth, "the API key was rejected; check the key of the provider"
+	case status == http.StatusNotFound:
+		return ErrorClassModel, "the model or endpoint was not found; check --model and the base URL of the provider"
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimit, rateLimitHint
+	case status == 456:
+		return ErrorClassQuota, "the quota of the API key is used up"
+	case status == http.StatusBadRequest && strings.Contains(strings.ToLower(err.Error()), "api key"):
+		return ErrorClassAuth, "the API key was rejected; check the key of the provider"
+	case status == http.StatusBadRequest && strings.Contains(strings.ToLower(err.Error()), "model"):
+		return ErrorClassModel, "the model was not accepted; check --model"
+	}
+
+	if errors.Is(err, ErrRateLimitExceeded) {
+		return ErrorClassRateLimit, rateLimitHint
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout, "the provider did not answer in time; check the network connection and the base URL of the provider"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorClassNetwork, "the provider could not be reached; check the network connection and the base URL of the provider"
+	}
+	return "", ""
+}
+
+// errorStatus returns the HTTP status of an API error, or zero.
+func errorStatus(err error) int {
+	var openAIErr *openAIError
+	var geminiErr *geminiError
+	var deepLErr *deepLError
+	var requestErr *anthropic.RequestError
+	switch {
+	case errors.As(err, &openAIErr):
+		return openAIErr.StatusCode
+	case errors.As(err, &geminiErr):
+		return geminiErr.StatusCode
+	case errors.As(err, &deepLErr):
+		return deepLErr.StatusCode
+	case errors.As(err, &requestErr):
+		return requestErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantHint string
+	}{
+		{"ok", http.StatusOK, `{"choices": [{"message": {"role": "assistant", "content": "Xin chào"}}]}`, ""},
+		{"bad key", http.StatusUnauthorized, `{"error": {"message": "Incorrect API key provided"}}`, "API key was rejected"},
+		{"bad model", http.StatusNotFound, `{"error": {"message": "The model gpt-5o does not exist"}}`, "check --model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			t.Setenv("OPENAI_BASE_URL", server.URL)
+
+			o, err := NewOpenAI(&Config{APIKey: "test-key", Cache: NoopCache{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Probe(context.Background(), o, "English", "Vietnamese")
+			if tt.wantHint == "" {
+				if err != nil {
+					t.Fatalf("Probe() = %v", err)
+				}
+				return
+			}
+			var probeErr *ProbeError
+			if !errors.As(err, &probeErr) || !strings.Contains(probeErr.Hint, tt.wantHint) {
+				t.Errorf("Probe() = %v, want hint %q", err, tt.wantHint)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		t.Setenv("OPENAI_BASE_URL", server.URL)
+
+		o, err := NewOpenAI(&Config{APIKey: "test-key", Cache: NoopCache{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var probeErr *ProbeError
+		if err := Probe(context.Background(), o, "English", "Vietnamese"); !errors.As(err, &probeErr) || !strings.Contains(probeErr.Hint, "could not be reached") {
+			t.Errorf("Probe() = %v", err)
+		}
+	})
+}
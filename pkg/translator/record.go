@@ -0,0 +1,164 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FixtureCall is a translation made by a provider: the content sent and the
+// translation answered.
+type FixtureCall struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Content     string `json:"content"`
+	Translation string `json:"translation"`
+}
+
+// Fixture holds translations recorded by a Recorder for the mock provider to
+// replay. Besides whole calls, the mock finds the segments of recorded
+// batches, so a replay still works when the segments are batched otherwise.
+type Fixture struct {
+	Calls []FixtureCall `json:"calls"`
+
+	calls    map[string]int
+	segments map[string]string
+}
+
+// LoadFixture reads the fixture at path; a missing file is an empty fixture.
+func LoadFixture(path string) (*Fixture, error) {
+	f := &Fixture{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, f); err != nil {
+			return nil, fmt.Errorf("reading fixture %s: %w", path, err)
+		}
+	}
+
+	calls := f.Calls
+	f.Calls = nil
+	for _, call := range calls {
+		f.add(call)
+	}
+	return f, nil
+}
+
+func fixtureKey(target, content string) string {
+	return target + "\x00" + content
+}
+
+// add records call, replacing an earlier call with the same content.
+func (f *Fixture) add(call FixtureCall) {
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+		f.segments = make(map[string]string)
+	}
+
+	key := fixtureKey(call.Target, call.Content)
+	if i, ok := f.calls[key]; ok {
+		f.Calls[i] = call
+	} else {
+		f.calls[key] = len(f.Calls)
+		f.Calls = append(f.Calls, call)
+	}
+
+	translated := make(map[string]string)
+	for _, m := range deepLSegmentPattern.FindAllStringSubmatch(call.Translation, -1) {
+		translated[m[1]] = m[2]
+	}
+	for _, m := range deepLSegmentPattern.FindAllStringSubmatch(call.Content, -1) {
+		if translation, ok := translated[m[1]]; ok {
+			f.segments[fixtureKey(call.Target, m[2])] = translation
+		}
+	}
+}
+
+// lookup returns the recorded translation of a call. A nil fixture has none.
+func (f *Fixture) lookup(target, content string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	i, ok := f.calls[fixtureKey(target, content)]
+	if !ok {
+		return "", false
+	}
+	return f.Calls[i].Translation, true
+}
+
+// lookupSegment returns the recorded translation of a segment of a batch.
+func (f *Fixture) lookupSegment(target, segment string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	translation, ok := f.segments[fixtureKey(target, segment)]
+	return translation, ok
+}
+
+// Save writes the fixture to path.
+func (f *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Recorder is a Provider that records the translations of another in a
+// fixture, which --provider mock replays without calling the model.
+type Recorder struct {
+	Provider
+	path string
+
+	mu      sync.Mutex
+	fixture *Fixture
+}
+
+// NewRecorder records the translations of p in the fixture at path, adding to
+// the translations recorded before.
+func NewRecorder(p Provider, path string) (*Recorder, error) {
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{Provider: p, path: path, fixture: fixture}, nil
+}
+
+func (r *Recorder) Translate(ctx context.Context, prompt, content, source, target, bookName string) (string, error) {
+	translation, err := r.Provider.Translate(ctx, prompt, content, source, target, bookName)
+	if err == nil {
+		r.record(source, target, content, translation)
+	}
+	return translation, err
+}
+
+func (r *Recorder) TranslateStream(ctx context.Context, prompt, content, source, target, bookName string, onDelta func(delta string)) (string, error) {
+	translation, err := r.Provider.TranslateStream(ctx, prompt, content, source, target, bookName, onDelta)
+	if err == nil {
+		r.record(source, target, content, translation)
+	}
+	return translation, err
+}
+
+func (r *Recorder) record(source, target, content, translation string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.add(FixtureCall{Source: source, Target: target, Content: content, Translation: translation})
+}
+
+// FlushMetadata writes the metadata of the provider and the fixture.
+func (r *Recorder) FlushMetadata() error {
+	err := r.Provider.FlushMetadata()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if saveErr := r.fixture.Save(r.path); saveErr != nil {
+		return errors.Join(err, fmt.Errorf("writing fixture: %w", saveErr))
+	}
+	return err
+}
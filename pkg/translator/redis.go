@@ -0,0 +1,97 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the keys epubtrans writes to a shared Redis.
+const redisKeyPrefix = "epubtrans:"
+
+// Limiter paces requests to the provider. *rate.Limiter implements it for a
+// single process, RedisLimiter for several processes sharing one API key.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRedisClient connects to the Redis server at url, e.g. redis://localhost:6379/0.
+func NewRedisClient(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+
+	return client, nil
+}
+
+// RedisCache shares translations between epubtrans instances.
+type RedisCache struct {
+	client *redis.Client
+}
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (c *RedisCache) Get(key string) (string, bool) {
+	value, err := c.client.Get(context.Background(), redisKeyPrefix+"cache:"+key).Result()
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+func (c *RedisCache) SetWithTTL(key, value string, ttl time.Duration) error {
+	return c.client.Set(context.Background(), redisKeyPrefix+"cache:"+key, value, max(ttl, 0)).Err()
+}
+
+// RedisLimiter allows limit requests per window across all processes using
+// the same Redis, counting them in one key per window.
+type RedisLimiter struct {
+	client *redis.Client
+	name   string
+	limit  int64
+	window time.Duration
+}
+
+// NewRedisLimiter returns a limiter for limit requests per window; instances
+// with the same name share the budget.
+func NewRedisLimiter(client *redis.Client, name string, limit int, window time.Duration) *RedisLimiter {
+	return &RedisLimiter{client: client, name: name, limit: int64(max(limit, 1)), window: window}
+}
+
+func (l *RedisLimiter) Wait(ctx context.Context) error {
+	for {
+		now := time.Now()
+		start := now.Truncate(l.window)
+		key := fmt.Sprintf("%sratelimit:%s:%d", redisKeyPrefix, l.name, start.Unix())
+
+		pipe := l.client.TxPipeline()
+		count := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, 2*l.window)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("redis rate limiter: %w", err)
+		}
+		if count.Val() <= l.limit {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(start.Add(l.window).Sub(now)):
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package translator
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Sampling holds the settings a model draws its answer with. With a
+// temperature of 0 and a seed, a rerun with the same input mostly gives the
+// same translation, which makes runs auditable.
+type Sampling struct {
+	Temperature float32
+	// TopP is the nucleus sampling threshold; zero keeps the provider's default.
+	TopP float32
+	// Seed makes sampling repeatable on providers that support one; nil for none.
+	Seed *int64
+}
+
+// String describes the settings, such as "temperature=0 seed=42".
+func (s Sampling) String() string {
+	parts := []string{"temperature=" + strconv.FormatFloat(float64(s.Temperature), 'g', -1, 32)}
+	if s.TopP > 0 {
+		parts = append(parts, "top_p="+strconv.FormatFloat(float64(s.TopP), 'g', -1, 32))
+	}
+	if s.Seed != nil {
+		parts = append(parts, "seed="+strconv.FormatInt(*s.Seed, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// sampling returns the settings of cfg that a provider sends, leaving out the
+// seed for providers without one.
+func (cfg *Config) sampling(seed bool) Sampling {
+	s := Sampling{Temperature: cfg.Temperature, TopP: cfg.TopP}
+	if seed {
+		s.Seed = cfg.Seed
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package translator
+
+import "testing"
+
+func TestSamplingString(t *testing.T) {
+	seed := int64(42)
+	tests := []struct {
+		s    Sampling
+		want string
+	}{
+		{Sampling{Temperature: 0.7}, "temperature=0.7"},
+		{Sampling{Temperature: 0, Seed: &seed}, "temperature=0 seed=42"},
+		{Sampling{Temperature: 1, TopP: 0.9, Seed: &seed}, "temperature=1 top_p=0.9 seed=42"},
+	}
+	for _, tt := range tests {
+		if got := tt.s.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+
+	cfg := &Config{Temperature: 0, Seed: &seed}
+	if got := cfg.sampling(false).String(); got != "temperature=0" {
+		t.Errorf("sampling without seed = %q", got)
+	}
+}
+
+func TestCacheKeySampling(t *testing.T) {
+	base := generateCacheKey("Hello", "English", "Vietnamese", "gpt-4o-mini", "v1", "")
+	cold := generateCacheKey("Hello", "English", "Vietnamese", "gpt-4o-mini", "v1", "temperature=0 seed=42")
+	warm := generateCacheKey("Hello", "English", "Vietnamese", "gpt-4o-mini", "v1", "temperature=0.9")
+	if base == cold || cold == warm || base == warm {
+		t.Error("translations with other sampling settings share a cache key")
+	}
+	if again := generateCacheKey("Hello", "English", "Vietnamese", "gpt-4o-mini", "v1", "temperature=0 seed=42"); again != cold {
+		t.Error("the cache key of the same settings changed")
+	}
+}
@@ -0,0 +1,40 @@
+package translator
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// readEvents calls onData with the data of every server-sent event of r until
+// r ends or an event carries [DONE], as the OpenAI API ends its streams.
+func readEvents(r io.Reader, onData func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err := onData(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// emitWhole passes a translation that was not streamed, such as a cached one,
+// to onDelta at once.
+func emitWhole(onDelta func(delta string), translation string) {
+	if onDelta != nil && translation != "" {
+		onDelta(translation)
+	}
+}
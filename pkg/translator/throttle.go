@@ -0,0 +1,168 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimit is the rate limit state a provider reports with each response.
+// A limit of zero or less means the provider did not report it.
+type RateLimit struct {
+	RequestsLimit     int
+	RequestsRemaining int
+	RequestsReset     time.Time
+	TokensLimit       int
+	TokensRemaining   int
+	TokensReset       time.Time
+	RetryAfter        time.Duration
+}
+
+const (
+	// throttleLowBudget is the remaining share of a limit below which concurrency shrinks.
+	throttleLowBudget = 0.1
+	// throttleHighBudget is the remaining share above which concurrency may grow.
+	throttleHighBudget   = 0.5
+	throttlePollInterval = 50 * time.Millisecond
+)
+
+// Throttle adapts the number of concurrent requests to the rate limits reported
+// by the provider. Concurrency grows by one after a round of requests with a
+// healthy budget, shrinks when the budget runs low and is halved on a rate
+// limit error, after which requests pause until the limit resets.
+type Throttle struct {
+	mu         sync.Mutex
+	max        int
+	limit      int
+	inFlight   int
+	successes  int
+	pauseUntil time.Time
+}
+
+// NewThrottle returns a throttle that allows up to maxConcurrency concurrent
+// requests, starting with one.
+func NewThrottle(maxConcurrency int) *Throttle {
+	return &Throttle{max: max(maxConcurrency, 1), limit: 1}
+}
+
+// Acquire blocks until a request may be sent.
+func (t *Throttle) Acquire(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		wait := time.Until(t.pauseUntil)
+		if wait <= 0 && t.inFlight < t.limit {
+			t.inFlight++
+			t.mu.Unlock()
+			return nil
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(max(wait, throttlePollInterval), time.Second)):
+		}
+	}
+}
+
+// Release marks a request acquired with Acquire as finished.
+func (t *Throttle) Release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+}
+
+// Observe adapts the concurrency to the remaining budget of a successful response.
+func (t *Throttle) Observe(rl RateLimit) {
+	if rl.RequestsLimit <= 0 && rl.TokensLimit <= 0 {
+		// Without a reported budget there is nothing to adapt to.
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests := remainingShare(rl.RequestsRemaining, rl.RequestsLimit)
+	tokens := remainingShare(rl.TokensRemaining, rl.TokensLimit)
+	budget := min(requests, tokens)
+
+	switch {
+	case budget < throttleLowBudget:
+		// Let the window reset instead of running into a rate limit error.
+		if requests < throttleLowBudget {
+			t.pauseUntil = later(t.pauseUntil, rl.RequestsReset)
+		}
+		if tokens < throttleLowBudget {
+			t.pauseUntil = later(t.pauseUntil, rl.TokensReset)
+		}
+		t.setLimit(t.limit-1, budget)
+	case budget > throttleHighBudget:
+		t.successes++
+		if t.successes >= t.limit {
+			t.setLimit(t.limit+1, budget)
+		}
+	}
+}
+
+// ObserveSuccess adapts the concurrency for providers that report no budget
+// with their responses: it grows after each round of successful requests and
+// only shrinks through Backoff, when the provider rejects a request.
+func (t *Throttle) ObserveSuccess() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.successes++
+	if t.successes >= t.limit {
+		t.setLimit(t.limit+1, 1)
+	}
+}
+
+// Backoff handles a rate limit error: it halves the concurrency and pauses
+// until the provider's retry-after, or the given fallback delay.
+func (t *Throttle) Backoff(rl RateLimit, fallback time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delay := rl.RetryAfter
+	if delay <= 0 {
+		delay = fallback
+	}
+
+	t.pauseUntil = later(t.pauseUntil, time.Now().Add(delay))
+	t.setLimit(t.limit/2, 0)
+
+	return delay
+}
+
+// Limit returns the current number of allowed concurrent requests.
+func (t *Throttle) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+func (t *Throttle) setLimit(limit int, budget float64) {
+	limit = min(max(limit, 1), t.max)
+	t.successes = 0
+	if limit == t.limit {
+		return
+	}
+
+	t.limit = limit
+	fmt.Printf("Throttle: %d concurrent requests (%.0f%% of rate limit left)\n", limit, budget*100)
+}
+
+func remainingShare(remaining, limit int) float64 {
+	if remaining < 0 || limit <= 0 {
+		return 1
+	}
+	return float64(remaining) / float64(limit)
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
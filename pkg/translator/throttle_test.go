@@ -0,0 +1,49 @@
+package translator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleAdaptsToBudget(t *testing.T) {
+	healthy := RateLimit{RequestsLimit: 50, RequestsRemaining: 45, TokensLimit: 40000, TokensRemaining: 30000}
+	low := RateLimit{RequestsLimit: 50, RequestsRemaining: 45, TokensLimit: 40000, TokensRemaining: 1000}
+
+	throttle := NewThrottle(3)
+	if got := throttle.Limit(); got != 1 {
+		t.Fatalf("initial limit = %d, want 1", got)
+	}
+
+	// A full round of healthy responses at each level raises the limit by one, up to the maximum.
+	for i := 0; i < 10; i++ {
+		throttle.Observe(healthy)
+	}
+	if got := throttle.Limit(); got != 3 {
+		t.Errorf("limit after healthy responses = %d, want 3", got)
+	}
+
+	throttle.Observe(low)
+	if got := throttle.Limit(); got != 2 {
+		t.Errorf("limit after low budget = %d, want 2", got)
+	}
+
+	delay := throttle.Backoff(RateLimit{RetryAfter: 2 * time.Second}, time.Second)
+	if delay != 2*time.Second {
+		t.Errorf("backoff delay = %s, want retry-after of 2s", delay)
+	}
+	if got := throttle.Limit(); got != 1 {
+		t.Errorf("limit after rate limit error = %d, want 1", got)
+	}
+
+	// Missing headers leave the limit alone.
+	throttle.Observe(RateLimit{})
+	if got := throttle.Limit(); got != 1 {
+		t.Errorf("limit after response without headers = %d, want 1", got)
+	}
+
+	// Without a budget, a round of successful requests raises the limit.
+	throttle.ObserveSuccess()
+	if got := throttle.Limit(); got != 2 {
+		t.Errorf("limit after successful response = %d, want 2", got)
+	}
+}
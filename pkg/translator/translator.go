@@ -3,10 +3,105 @@ package translator
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
 )
 
 var ErrRateLimitExceeded = errors.New("rate limit exceeded")
 
 type Translator interface {
 	Translate(ctx context.Context, prompt string, content string, source string, target string, bookName string) (string, error)
+	// TranslateStream translates like Translate and passes the translation to
+	// onDelta piece by piece as the model produces it. It returns the whole
+	// translation; backends that cannot stream pass it to onDelta at once.
+	TranslateStream(ctx context.Context, prompt string, content string, source string, target string, bookName string, onDelta func(delta string)) (string, error)
+}
+
+// Provider is a Translator backed by a model API, which reports what it used.
+type Provider interface {
+	Translator
+	// Model returns the model translations are made with.
+	Model() string
+	// PromptVersion returns the prompt version used in cache keys.
+	PromptVersion() string
+	// Sampling describes the sampling settings sent with every request, such
+	// as "temperature=0 seed=42"; empty for providers that do not sample.
+	Sampling() string
+	// Usage returns the token usage of the current run.
+	Usage() UsageStats
+	// FlushMetadata writes the usage metadata not yet written.
+	FlushMetadata() error
+	// ReleaseUsage lets the calls made with the usage key into the metadata;
+	// see WithUsageKey.
+	ReleaseUsage(key string)
+}
+
+// Factory creates the translator of a provider. cfg is never nil; an empty
+// cfg.Model selects the provider's default model.
+type Factory func(cfg *Config) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a provider available to New under name. Backends register
+// themselves from init; Register panics if name is registered twice.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("translator: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("translator: Register called twice for provider " + name)
+	}
+	registry[name] = factory
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a new translator of the named provider; an empty name selects
+// anthropic.
+func New(provider string, cfg *Config) (Provider, error) {
+	if provider == "" {
+		provider = "anthropic"
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[provider]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q: use one of %v", provider, Providers())
+	}
+
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return factory(cfg)
+}
+
+// factoryOf adapts a backend constructor to a Factory, so a failed
+// constructor does not yield a non-nil Provider holding a nil pointer.
+func factoryOf[T Provider](newTranslator func(cfg *Config) (T, error)) Factory {
+	return func(cfg *Config) (Provider, error) {
+		t, err := newTranslator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
 }
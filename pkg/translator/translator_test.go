@@ -0,0 +1,46 @@
+package translator
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{"anthropic", "openai", "ollama", "gemini", "deepl", "mock"} {
+		if !slices.Contains(Providers(), name) {
+			t.Errorf("Providers() = %v, missing %s", Providers(), name)
+		}
+	}
+
+	wantErr := errors.New("factory called")
+	Register("test-registry", func(cfg *Config) (Provider, error) {
+		if cfg == nil {
+			t.Error("factory got a nil config")
+		}
+		return nil, wantErr
+	})
+	if _, err := New("test-registry", nil); !errors.Is(err, wantErr) {
+		t.Errorf("New() error = %v, want %v", err, wantErr)
+	}
+
+	if _, err := New("no-such-provider", &Config{}); err == nil {
+		t.Error("New() of an unknown provider succeeded")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a provider twice did not panic")
+		}
+	}()
+	Register("test-registry", func(cfg *Config) (Provider, error) { return nil, nil })
+}
+
+func TestFactoryOfFailure(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+
+	p, err := New("openai", &Config{})
+	if err == nil || p != nil {
+		t.Errorf("New() = %v, %v; want a nil Provider and an error", p, err)
+	}
+}
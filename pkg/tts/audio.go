@@ -0,0 +1,80 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Concat joins audio clips of format into one. MP3 and Ogg clips are streams
+// of frames and are appended; the PCM data of WAV clips, which must share
+// their sample format, is put under one header.
+func Concat(format string, clips [][]byte) ([]byte, error) {
+	if format != "wav" {
+		return bytes.Join(clips, nil), nil
+	}
+
+	var fmtChunk, data []byte
+	for i, clip := range clips {
+		f, d, err := parseWAV(clip)
+		if err != nil {
+			return nil, fmt.Errorf("clip %d: %w", i, err)
+		}
+		if fmtChunk == nil {
+			fmtChunk = f
+		} else if !bytes.Equal(fmtChunk, f) {
+			return nil, fmt.Errorf("clip %d: sample format differs from the first clip", i)
+		}
+		data = append(data, d...)
+	}
+	if fmtChunk == nil {
+		return nil, errors.New("no clips")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(fmtChunk)+8+len(data)))
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(fmtChunk)))
+	b.Write(fmtChunk)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes(), nil
+}
+
+// parseWAV returns the fmt chunk and the PCM data of a RIFF WAVE file.
+// Streaming encoders leave the size of the data chunk at zero or too large,
+// so the data runs to the end of the file then.
+func parseWAV(clip []byte) (fmtChunk, data []byte, err error) {
+	if len(clip) < 12 || string(clip[:4]) != "RIFF" || string(clip[8:12]) != "WAVE" {
+		return nil, nil, errors.New("not a WAV file")
+	}
+
+	for rest := clip[12:]; len(rest) >= 8; {
+		id, size := string(rest[:4]), int(binary.LittleEndian.Uint32(rest[4:8]))
+		rest = rest[8:]
+		if id == "data" {
+			if size == 0 || size > len(rest) {
+				size = len(rest)
+			}
+			data = rest[:size]
+			break
+		}
+		if size > len(rest) {
+			return nil, nil, fmt.Errorf("truncated %q chunk", id)
+		}
+		if id == "fmt " {
+			fmtChunk = rest[:size]
+		}
+		// Chunks are padded to an even size.
+		rest = rest[min(size+size%2, len(rest)):]
+	}
+
+	if fmtChunk == nil || data == nil {
+		return nil, nil, errors.New("missing fmt or data chunk")
+	}
+	return fmtChunk, data, nil
+}
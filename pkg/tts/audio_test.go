@@ -0,0 +1,49 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func wav(format []byte, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(format)+8+len(data)))
+	b.WriteString("WAVELIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.WriteString("abc\x00fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(format)))
+	b.Write(format)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestConcatWAV(t *testing.T) {
+	format := []byte("0123456789abcdef")
+	got, err := Concat("wav", [][]byte{wav(format, []byte{1, 2}), wav(format, []byte{3, 4, 5, 6})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, data, err := parseWAV(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f, format) || !bytes.Equal(data, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Concat() = fmt %q, data %v", f, data)
+	}
+
+	if _, err := Concat("wav", [][]byte{wav(format, nil), wav([]byte("other format...."), nil)}); err == nil {
+		t.Error("Concat() of different sample formats succeeded")
+	}
+}
+
+func TestConcatMP3(t *testing.T) {
+	got, err := Concat("mp3", [][]byte{[]byte("ab"), []byte("cd")})
+	if err != nil || string(got) != "abcd" {
+		t.Errorf("Concat() = %q, %v; want abcd", got, err)
+	}
+}
@@ -0,0 +1,111 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ElevenLabsModelMultilingualV2 = "eleven_multilingual_v2"
+	// ElevenLabsVoiceRachel is a premade voice available to every account.
+	ElevenLabsVoiceRachel = "21m00Tcm4TlvDq8ikWAM"
+
+	elevenLabsDefaultBaseURL = "https://api.elevenlabs.io/v1"
+	elevenLabsMaxInput       = 5000
+)
+
+func init() {
+	Register("elevenlabs", factoryOf(NewElevenLabs))
+}
+
+// ElevenLabs synthesizes MP3 speech with the ElevenLabs text to speech API.
+type ElevenLabs struct {
+	baseURL string
+	client  *http.Client
+	config  *Config
+}
+
+// NewElevenLabs returns an ElevenLabs synthesizer. The API key defaults to
+// ELEVENLABS_API_KEY, and Voice is the ID of a voice of the account.
+func NewElevenLabs(cfg *Config) (*ElevenLabs, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("ELEVENLABS_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing ELEVENLABS_API_KEY")
+	}
+	if cfg.Model == "" {
+		cfg.Model = ElevenLabsModelMultilingualV2
+	}
+	if cfg.Voice == "" {
+		cfg.Voice = ElevenLabsVoiceRachel
+	}
+
+	baseURL := os.Getenv("ELEVENLABS_BASE_URL")
+	if baseURL == "" {
+		baseURL = elevenLabsDefaultBaseURL
+	}
+
+	return &ElevenLabs{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 2 * time.Minute},
+		config:  cfg,
+	}, nil
+}
+
+// Format returns the audio format, always mp3.
+func (e *ElevenLabs) Format() string {
+	return "mp3"
+}
+
+type elevenLabsRequest struct {
+	Text          string                   `json:"text"`
+	ModelID       string                   `json:"model_id"`
+	VoiceSettings *elevenLabsVoiceSettings `json:"voice_settings,omitempty"`
+}
+
+type elevenLabsVoiceSettings struct {
+	Speed float64 `json:"speed"`
+}
+
+// Synthesize returns the speech of text; longer texts than the API accepts
+// are synthesized in chunks.
+func (e *ElevenLabs) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	return synthesizeChunks(ctx, text, elevenLabsMaxInput, e.synthesize)
+}
+
+func (e *ElevenLabs) synthesize(ctx context.Context, text string) ([]byte, error) {
+	payload := elevenLabsRequest{Text: text, ModelID: e.config.Model}
+	if e.config.Speed != 0 {
+		payload.VoiceSettings = &elevenLabsVoiceSettings{Speed: e.config.Speed}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := e.baseURL + "/text-to-speech/" + url.PathEscape(e.config.Voice) + "?output_format=mp3_44100_128"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "audio/mpeg")
+	req.Header.Set("xi-api-key", e.config.APIKey)
+
+	return doAudioRequest(e.client, req, "elevenlabs")
+}
+
+// phoneme marks up an IPA pronunciation with an SSML phoneme tag, which the
+// English models of ElevenLabs pronounce and the others read as the term.
+func (e *ElevenLabs) phoneme(term, ipa string) string {
+	return `<phoneme alphabet="ipa" ph="` + html.EscapeString(ipa) + `">` + html.EscapeString(term) + `</phoneme>`
+}
@@ -0,0 +1,132 @@
+package tts
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lexicon overrides how names and terms are pronounced. A pronunciation is a
+// phonetic spelling, such as "her-MY-oh-nee", or IPA between slashes, such as
+// "/hɜːˈmaɪ.ə.ni/", for backends that accept IPA.
+type Lexicon struct {
+	pronunciations map[string]string
+	pattern        *regexp.Regexp
+}
+
+// NewLexicon returns a lexicon of term to pronunciation. Terms match whole
+// words regardless of case: "Harry's" matches Harry, but inflected and
+// compound forms such as "Harrying" do not and need terms of their own.
+func NewLexicon(pronunciations map[string]string) *Lexicon {
+	l := &Lexicon{pronunciations: make(map[string]string, len(pronunciations))}
+
+	terms := make([]string, 0, len(pronunciations))
+	for term, pronunciation := range pronunciations {
+		term = strings.TrimSpace(term)
+		if term == "" || strings.TrimSpace(pronunciation) == "" {
+			continue
+		}
+		l.pronunciations[strings.ToLower(term)] = strings.TrimSpace(pronunciation)
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	if len(terms) == 0 {
+		return l
+	}
+
+	// Longer terms first, so "Harry Potter" wins over "Harry".
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	l.pattern = regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+	return l
+}
+
+// Len returns the number of terms of the lexicon; a nil lexicon is empty.
+func (l *Lexicon) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.pronunciations)
+}
+
+// IPA reports whether a pronunciation is IPA rather than a phonetic spelling.
+func IPA(pronunciation string) (string, bool) {
+	if len(pronunciation) > 2 && strings.HasPrefix(pronunciation, "/") && strings.HasSuffix(pronunciation, "/") {
+		return pronunciation[1 : len(pronunciation)-1], true
+	}
+	return "", false
+}
+
+// Apply replaces the terms of text with their pronunciation. phoneme renders
+// an IPA pronunciation; when it is nil, terms with IPA are left as they are.
+func (l *Lexicon) Apply(text string, phoneme func(term, ipa string) string) string {
+	if l.Len() == 0 || l.pattern == nil {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range l.pattern.FindAllStringIndex(text, -1) {
+		start, end := m[0], m[1]
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start > 0 && isWordRune(before)) || (end < len(text) && isWordRune(after)) {
+			continue
+		}
+
+		term := text[start:end]
+		replacement := l.pronunciations[strings.ToLower(term)]
+		if ipa, ok := IPA(replacement); ok {
+			if phoneme == nil {
+				continue
+			}
+			replacement = phoneme(term, ipa)
+		}
+
+		b.WriteString(text[last:start])
+		b.WriteString(replacement)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isWordRune reports whether r continues a word. Han characters do not, as
+// Chinese is written without spaces.
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !unicode.Is(unicode.Han, r)
+}
+
+// phonemeMarkup is implemented by backends that accept IPA pronunciations
+// inline in the text.
+type phonemeMarkup interface {
+	phoneme(term, ipa string) string
+}
+
+// WithLexicon returns a synthesizer that applies the lexicon to the text
+// before passing it to s.
+func WithLexicon(s Synthesizer, lexicon *Lexicon) Synthesizer {
+	if lexicon.Len() == 0 {
+		return s
+	}
+	return &lexiconSynthesizer{Synthesizer: s, lexicon: lexicon}
+}
+
+type lexiconSynthesizer struct {
+	Synthesizer
+	lexicon *Lexicon
+}
+
+func (l *lexiconSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	var phoneme func(term, ipa string) string
+	if m, ok := l.Synthesizer.(phonemeMarkup); ok {
+		phoneme = m.phoneme
+	}
+	return l.Synthesizer.Synthesize(ctx, l.lexicon.Apply(text, phoneme))
+}
@@ -0,0 +1,34 @@
+package tts
+
+import "testing"
+
+func TestLexiconApply(t *testing.T) {
+	lexicon := NewLexicon(map[string]string{
+		"Hermione":     "her-MY-oh-nee",
+		"Harry":        "HA-ree",
+		"Harry Potter": "HA-ree POT-ter",
+		"Nguyễn":       "/ŋwiən/",
+		"哈利":           "ha li",
+	})
+	ipa := func(term, ipa string) string { return "[" + ipa + "]" }
+
+	tests := []struct {
+		text    string
+		phoneme func(term, ipa string) string
+		want    string
+	}{
+		{"Hermione and Harry Potter.", nil, "her-MY-oh-nee and HA-ree POT-ter."},
+		{"HARRY said: harry!", nil, "HA-ree said: HA-ree!"},
+		{"Harrying Hermiones", nil, "Harrying Hermiones"},
+		{"Harry's wand", nil, "HA-ree's wand"},
+		{"Ông Nguyễn", nil, "Ông Nguyễn"},
+		{"Ông Nguyễn", ipa, "Ông [ŋwiən]"},
+		{"哈利说", nil, "ha li说"},
+	}
+
+	for _, tt := range tests {
+		if got := lexicon.Apply(tt.text, tt.phoneme); got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
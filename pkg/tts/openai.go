@@ -0,0 +1,123 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	OpenAIModelTTS1   = "tts-1"
+	OpenAIModelTTS1HD = "tts-1-hd"
+	OpenAIVoiceAlloy  = "alloy"
+
+	openAIDefaultBaseURL = "https://api.openai.com/v1"
+	// openAIMaxInput is the most characters the speech endpoint accepts.
+	openAIMaxInput = 4096
+)
+
+func init() {
+	Register("openai", factoryOf(NewOpenAI))
+}
+
+// OpenAI synthesizes MP3 speech with the OpenAI audio speech API.
+type OpenAI struct {
+	baseURL string
+	client  *http.Client
+	config  *Config
+}
+
+// NewOpenAI returns an OpenAI synthesizer. As for translation, the API key
+// defaults to OPENAI_API_KEY and the endpoint to OPENAI_BASE_URL, if set.
+func NewOpenAI(cfg *Config) (*OpenAI, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing OPENAI_API_KEY")
+	}
+	if cfg.Model == "" {
+		cfg.Model = OpenAIModelTTS1
+	}
+	if cfg.Voice == "" {
+		cfg.Voice = OpenAIVoiceAlloy
+	}
+
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = openAIDefaultBaseURL
+	}
+
+	return &OpenAI{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 2 * time.Minute},
+		config:  cfg,
+	}, nil
+}
+
+// Format returns the audio format, always mp3.
+func (o *OpenAI) Format() string {
+	return "mp3"
+}
+
+type openAISpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// Synthesize returns the speech of text; longer texts than the API accepts
+// are synthesized in chunks.
+func (o *OpenAI) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	return synthesizeChunks(ctx, text, openAIMaxInput, o.synthesize)
+}
+
+func (o *OpenAI) synthesize(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(openAISpeechRequest{
+		Model:          o.config.Model,
+		Input:          text,
+		Voice:          o.config.Voice,
+		ResponseFormat: o.Format(),
+		Speed:          o.config.Speed,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+
+	return doAudioRequest(o.client, req, "openai")
+}
+
+// doAudioRequest sends req and returns the audio of the response, or the
+// error message of the API.
+func doAudioRequest(client *http.Client, req *http.Request, provider string) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
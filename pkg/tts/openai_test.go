@@ -0,0 +1,43 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAISynthesize(t *testing.T) {
+	var inputs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req openAISpeechRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inputs = append(inputs, req.Input)
+		w.Write([]byte(req.Voice + ":"))
+	}))
+	defer server.Close()
+	t.Setenv("OPENAI_BASE_URL", server.URL+"/v1")
+
+	s, err := New("openai", &Config{APIKey: "key", Voice: "nova"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := strings.Repeat("A sentence. ", openAIMaxInput/6)
+	audio, err := s.Synthesize(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || string(audio) != "nova:nova:" {
+		t.Errorf("got %d requests and audio %q, want 2 chunks concatenated", len(inputs), audio)
+	}
+}
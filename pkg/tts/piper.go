@@ -0,0 +1,86 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("piper", factoryOf(NewPiper))
+}
+
+// Piper synthesizes WAV speech offline with the piper command
+// (https://github.com/rhasspy/piper), which costs nothing per character.
+type Piper struct {
+	bin    string
+	config *Config
+}
+
+// NewPiper returns a synthesizer running the piper command, PIPER_BIN if set.
+// Model is the .onnx voice model and defaults to PIPER_MODEL; Voice selects
+// the speaker of a model with several speakers.
+func NewPiper(cfg *Config) (*Piper, error) {
+	if cfg.Model == "" {
+		cfg.Model = os.Getenv("PIPER_MODEL")
+	}
+	if cfg.Model == "" {
+		return nil, errors.New("missing piper voice model: pass a .onnx file or set PIPER_MODEL")
+	}
+
+	bin := os.Getenv("PIPER_BIN")
+	if bin == "" {
+		bin = "piper"
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, fmt.Errorf("piper: %w", err)
+	}
+
+	return &Piper{bin: path, config: cfg}, nil
+}
+
+// Format returns the audio format, always wav.
+func (p *Piper) Format() string {
+	return "wav"
+}
+
+// Synthesize runs piper with text on its standard input. Piper splits long
+// texts into sentences itself, and WAV files cannot simply be concatenated,
+// so text is passed whole.
+func (p *Piper) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	out, err := os.CreateTemp("", "epubtrans-piper-*.wav")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	cmd := exec.CommandContext(ctx, p.bin, p.args(out.Name())...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("piper: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(out.Name())
+}
+
+func (p *Piper) args(outputFile string) []string {
+	args := []string{"--model", filepath.Clean(p.config.Model), "--output_file", outputFile}
+	if p.config.Voice != "" {
+		args = append(args, "--speaker", p.config.Voice)
+	}
+	if p.config.Speed > 0 {
+		// Piper stretches phonemes rather than speeding them up.
+		args = append(args, "--length_scale", strconv.FormatFloat(1/p.config.Speed, 'f', 2, 64))
+	}
+	return args
+}
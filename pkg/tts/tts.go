@@ -0,0 +1,153 @@
+// Package tts turns text into speech. Backends register themselves like the
+// providers of package translator, so every command that reads text aloud
+// selects them with the same --tts-provider names and configuration.
+package tts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Synthesizer turns text into speech.
+type Synthesizer interface {
+	// Synthesize returns the audio of text, encoded in Format.
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+	// Format returns the file extension of the audio, such as "mp3" or "wav".
+	Format() string
+}
+
+// Config configures a backend. Empty fields select the backend's defaults.
+type Config struct {
+	APIKey string
+	Model  string  // Model of the API, or the voice model file of a local engine
+	Voice  string  // Voice name or ID
+	Speed  float64 // Relative speaking rate; 1 is normal
+}
+
+// Factory creates the synthesizer of a backend. cfg is never nil.
+type Factory func(cfg *Config) (Synthesizer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a backend available to New under name. Backends register
+// themselves from init; Register panics if name is registered twice.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("tts: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("tts: Register called twice for provider " + name)
+	}
+	registry[name] = factory
+}
+
+// Providers returns the names of the registered backends, sorted.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a synthesizer of the named backend; an empty name selects openai.
+func New(provider string, cfg *Config) (Synthesizer, error) {
+	if provider == "" {
+		provider = "openai"
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[provider]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown TTS provider %q: use one of %v", provider, Providers())
+	}
+
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return factory(cfg)
+}
+
+// factoryOf adapts a backend constructor to a Factory, so a failed
+// constructor does not yield a non-nil Synthesizer holding a nil pointer.
+func factoryOf[T Synthesizer](newSynthesizer func(cfg *Config) (T, error)) Factory {
+	return func(cfg *Config) (Synthesizer, error) {
+		s, err := newSynthesizer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// ContentType returns the MIME type of audio in format.
+func ContentType(format string) string {
+	switch format {
+	case "mp3":
+		return "audio/mpeg"
+	case "wav":
+		return "audio/wav"
+	case "opus", "ogg":
+		return "audio/ogg"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Split cuts text into chunks of at most max characters for APIs that limit
+// the length of their input, preferably after the end of a sentence, else
+// after a space.
+func Split(text string, max int) []string {
+	var chunks []string
+	text = strings.TrimSpace(text)
+	for utf8.RuneCountInString(text) > max {
+		prefix := string([]rune(text)[:max])
+		cut := -1
+		for _, end := range []string{". ", "! ", "? ", "。", "！", "？", "\n"} {
+			if i := strings.LastIndex(prefix, end); i >= 0 && i+len(end) > cut {
+				cut = i + len(end)
+			}
+		}
+		if cut <= 0 {
+			cut = strings.LastIndex(prefix, " ") + 1
+		}
+		if cut <= 0 {
+			cut = len(prefix)
+		}
+		chunks = append(chunks, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// synthesizeChunks synthesizes the chunks of text one after another and
+// concatenates their audio, which works for streams of frames such as MP3.
+func synthesizeChunks(ctx context.Context, text string, max int, synthesize func(ctx context.Context, chunk string) ([]byte, error)) ([]byte, error) {
+	var audio []byte
+	for _, chunk := range Split(text, max) {
+		data, err := synthesize(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		audio = append(audio, data...)
+	}
+	return audio, nil
+}
@@ -0,0 +1,39 @@
+package tts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{"short", "One sentence.", 100, []string{"One sentence."}},
+		{"sentences", "First one. Second one. Third.", 24, []string{"First one. Second one.", "Third."}},
+		{"words", "no sentence ends here at all", 12, []string{"no sentence", "ends here", "at all"}},
+		{"cjk", "第一句。第二句。", 5, []string{"第一句。", "第二句。"}},
+		{"unbroken", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"empty", "  ", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.text, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	_, err := New("nope", nil)
+	if err == nil || !strings.Contains(err.Error(), "elevenlabs") {
+		t.Errorf("New(nope) error = %v, want a list of providers", err)
+	}
+}
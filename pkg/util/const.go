@@ -4,3 +4,15 @@ const ContentIdKey = "data-content-id"
 const TranslationIdKey = "data-translation-id"
 const TranslationByIdKey = "data-translation-by-id"
 const TranslationLangKey = "data-translation-lang"
+
+// Provenance of a translation: what produced it, so books translated by
+// several providers or prompts stay auditable.
+const TranslationProviderKey = "data-translation-provider"
+const TranslationModelKey = "data-translation-model"
+const TranslationPromptVersionKey = "data-translation-prompt-version"
+const TranslationSamplingKey = "data-translation-sampling"
+
+// TranslationSourceHashKey holds a hash of the text of the original a
+// translation was made from; the translation is stale once the original
+// changes.
+const TranslationSourceHashKey = "data-translation-source-hash"
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DetectLanguage guesses the language of text and returns its ISO 639-1
+// code, or "" when it cannot tell with confidence. Languages with a script of
+// their own are told by script; Vietnamese by its letters; English, German,
+// French, Spanish, Italian, Portuguese and Dutch by the character trigrams
+// they use most. Other languages are never detected, so a caller skipping
+// text in a language only skips what it is sure of.
+func DetectLanguage(text string) string {
+	letters := 0
+	scripts := map[string]int{}
+	vietnamese := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if script := letterScript(r); script != "" {
+			scripts[script]++
+		}
+		if strings.ContainsRune(vietnameseLetters, unicode.ToLower(r)) {
+			vietnamese++
+		}
+	}
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	// A script used by most letters decides, except the Latin script.
+	for script, n := range scripts {
+		if script != "latin" && n*2 > letters {
+			return scriptLanguage(script, text, scripts)
+		}
+	}
+	if scripts["latin"]*2 <= letters {
+		return ""
+	}
+	if vietnamese*20 > letters {
+		return "vi"
+	}
+	return detectByTrigrams(text)
+}
+
+// minDetectLetters is the number of letters below which DetectLanguage does
+// not guess.
+const minDetectLetters = 20
+
+// vietnameseLetters are the lower case letters only Vietnamese uses among the
+// languages of Latin script.
+const vietnameseLetters = "ăơưđạảấầẩẫậắằẳẵặẹẻẽếềểễệỉịọỏốồổỗộớờởỡợụủứừửữựỳỵỷỹ"
+
+func letterScript(r rune) string {
+	switch {
+	case unicode.Is(unicode.Latin, r):
+		return "latin"
+	case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+		return "kana"
+	case unicode.Is(unicode.Han, r):
+		return "han"
+	case unicode.Is(unicode.Hangul, r):
+		return "hangul"
+	case unicode.Is(unicode.Cyrillic, r):
+		return "cyrillic"
+	case unicode.Is(unicode.Greek, r):
+		return "greek"
+	case unicode.Is(unicode.Arabic, r):
+		return "arabic"
+	case unicode.Is(unicode.Hebrew, r):
+		return "hebrew"
+	case unicode.Is(unicode.Thai, r):
+		return "thai"
+	case unicode.Is(unicode.Devanagari, r):
+		return "devanagari"
+	}
+	return ""
+}
+
+// scriptLanguage returns the language of text written mostly in script.
+func scriptLanguage(script, text string, scripts map[string]int) string {
+	switch script {
+	case "han", "kana":
+		// Japanese mixes kanji with kana; Chinese has no kana.
+		if scripts["kana"] > 0 {
+			return "ja"
+		}
+		return "zh"
+	case "hangul":
+		return "ko"
+	case "cyrillic":
+		if strings.ContainsAny(strings.ToLower(text), "іїєґ") {
+			return "uk"
+		}
+		return "ru"
+	case "greek":
+		return "el"
+	case "arabic":
+		return "ar"
+	case "hebrew":
+		return "he"
+	case "thai":
+		return "th"
+	case "devanagari":
+		return "hi"
+	}
+	return ""
+}
+
+// trigramProfiles hold the most frequent trigrams of running text in every
+// language, most frequent first, with "_" for the space around words.
+var trigramProfiles = map[string][]string{
+	"en": strings.Fields("_th the he_ and _an nd_ ing ng_ _of of_ _to to_ ion _in ed_ er_ _a_ is_ at_ hat tha _wa was on_ re_ _he his es_ it_ _hi"),
+	"de": strings.Fields("en_ er_ _de der ich ie_ ein sch che und _un nd_ die _di den cht ine in_ gen te_ _ei es_ ung _ge ch_ eit _da das ber _zu"),
+	"fr": strings.Fields("es_ _de de_ le_ ent _le nt_ _la la_ ion re_ _pa _et et_ que _qu ue_ les _co ne_ ait men our _un it_ ais _il _pr des _po pou _ne son"),
+	"es": strings.Fields("_de de_ os_ la_ _la el_ _el es_ ue_ que _qu en_ as_ _co _en _lo ent _se _y_ do_ ado con ra_ aba _a_ _su los _es ion _pa _po por _ha _ya ya_ él_ _al al_"),
+	"it": strings.Fields("_di di_ la_ to_ _la che _ch he_ re_ _co _il il_ ell ono ato no_ _de _un del _e_ per _pe lla ent _no one _in sta gli _gl non on_ _pi più _al al_"),
+	"pt": strings.Fields("_de de_ os_ _qu que ue_ _a_ do_ _co ão_ ção _o_ da_ ent _e_ _do _se as_ ra_ nte _da com _pa em_ _em _na ndo era um_ _um _ao ao_ não _nã uma _el ele ela _mu"),
+	"nl": strings.Fields("en_ _de de_ an_ et_ _he het van _va _en er_ ijk _in in_ een _ee aar ede _ge te_ ver _ve oor _zi ik_ _ik ijn _da dat _ni"),
+}
+
+// distinctiveLetters are letters of the languages of trigramProfiles that the
+// others do not use, or rarely; each counts as several trigrams.
+var distinctiveLetters = map[string]string{
+	"de": "ßäöü",
+	"fr": "œêèëîûù",
+	"es": "ñ¿¡",
+	"pt": "ãõ",
+	"it": "ìò",
+}
+
+// detectByTrigrams returns the language of trigramProfiles whose trigrams
+// make up most of text, if it clearly beats the others.
+func detectByTrigrams(text string) string {
+	counts := map[string]int{}
+	total := 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune("_" + word + "_")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+			total++
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+
+	best, bestScore, secondScore := "", 0.0, 0.0
+	for lang, profile := range trigramProfiles {
+		hits := 0
+		for _, trigram := range profile {
+			hits += counts[trigram]
+		}
+		for _, r := range distinctiveLetters[lang] {
+			hits += 3 * strings.Count(strings.ToLower(text), string(r))
+		}
+		score := float64(hits) / float64(total)
+		switch {
+		case score > bestScore:
+			best, bestScore, secondScore = lang, score, bestScore
+		case score > secondScore:
+			secondScore = score
+		}
+	}
+	if bestScore < 0.12 || bestScore < 1.3*secondScore {
+		return ""
+	}
+	return best
+}
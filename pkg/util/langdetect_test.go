@@ -0,0 +1,31 @@
+package util
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"The old man walked down to the harbour, and he was thinking of his son.", "en"},
+		{"Der alte Mann ging zum Hafen hinunter und dachte an seinen Sohn, den er nicht gesehen hatte.", "de"},
+		{"Le vieil homme descendit vers le port et il pensait à son fils qu'il ne voyait plus.", "fr"},
+		{"El viejo bajó al puerto y pensaba en su hijo, que ya no estaba con él en la casa.", "es"},
+		{"Il vecchio scese al porto e pensava al figlio, che non vedeva più da molto tempo.", "it"},
+		{"O velho desceu ao porto e pensava no filho, que não via havia muito tempo.", "pt"},
+		{"De oude man liep naar de haven en dacht aan zijn zoon, die hij niet meer zag.", "nl"},
+		{"Ông lão đi xuống bến cảng và nghĩ về đứa con trai của mình.", "vi"},
+		{"Старик спустился к гавани и думал о своём сыне.", "ru"},
+		{"老人走到港口，想着他的儿子，他已经很久没有见到他了。", "zh"},
+		{"老人は港へ下りていき、息子のことを考えていた。", "ja"},
+		{"노인은 항구로 내려가 아들을 생각했다. 오랫동안 보지 못한 아들이었다.", "ko"},
+		// Too short to tell.
+		{"Chapter One", ""},
+		{"42", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
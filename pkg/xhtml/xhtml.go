@@ -0,0 +1,522 @@
+// Package xhtml writes HTML node trees, as parsed by golang.org/x/net/html,
+// back as XHTML. Render serializes a whole tree; Splice writes an edited
+// tree of a document over its original bytes, so everything that did not
+// change, including entities, self-closing tags, the XML declaration and the
+// doctype, stays byte for byte as the author wrote it.
+package xhtml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// voidElements are the HTML elements without end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements hold text that the parser keeps as written.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true,
+}
+
+// impliedElements are inserted by the parser when a document leaves them out.
+var impliedElements = map[string]bool{
+	"html": true, "head": true, "body": true, "tbody": true, "colgroup": true,
+}
+
+// Render writes n and its descendants as XHTML.
+func Render(w io.Writer, n *html.Node) error {
+	var buf bytes.Buffer
+	if err := render(&buf, n); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func render(buf *bytes.Buffer, n *html.Node) error {
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := render(buf, c); err != nil {
+				return err
+			}
+		}
+	case html.DoctypeNode:
+		return html.Render(buf, n)
+	case html.CommentNode:
+		// The parser reads processing instructions such as the XML
+		// declaration as comments.
+		if len(n.Data) > 1 && strings.HasPrefix(n.Data, "?") && strings.HasSuffix(n.Data, "?") {
+			buf.WriteString("<" + n.Data + ">")
+			return nil
+		}
+		buf.WriteString("<!--" + n.Data + "-->")
+	case html.TextNode:
+		if n.Parent != nil && n.Parent.Type == html.ElementNode && n.Parent.Namespace == "" && rawTextElements[n.Parent.Data] {
+			buf.WriteString(n.Data)
+			return nil
+		}
+		escapeText(buf, n.Data, false)
+	case html.ElementNode:
+		buf.WriteString(startTag(n))
+		if n.FirstChild == nil && (voidElements[n.Data] || n.Namespace != "") {
+			buf.Truncate(buf.Len() - 1)
+			buf.WriteString("/>")
+			return nil
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := render(buf, c); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</" + n.Data + ">")
+	case html.RawNode:
+		buf.WriteString(n.Data)
+	default:
+		return fmt.Errorf("xhtml: unknown node type %d", n.Type)
+	}
+	return nil
+}
+
+func startTag(n *html.Node) string {
+	var buf bytes.Buffer
+	buf.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		buf.WriteByte(' ')
+		if a.Namespace != "" {
+			buf.WriteString(a.Namespace + ":")
+		}
+		buf.WriteString(a.Key + `="`)
+		escapeText(&buf, a.Val, true)
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	return buf.String()
+}
+
+func escapeText(buf *bytes.Buffer, s string, attr bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r == '<':
+			buf.WriteString("&lt;")
+		case r == '>':
+			buf.WriteString("&gt;")
+		case r == '"' && attr:
+			buf.WriteString("&quot;")
+		case r == '\r':
+			buf.WriteString("&#13;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// ErrUnmapped is returned by Splice when the original cannot be lined up
+// with the tree the parser makes of it, e.g. because it has self-closing
+// tags the parser reads as start tags. Render the document instead.
+var ErrUnmapped = errors.New("xhtml: the original does not line up with its parse tree")
+
+// source is an element of the original: its name and where its start tag,
+// content and end tag are.
+type source struct {
+	name       string
+	start, end int
+	// content runs from contentStart to contentEnd; both are end for a
+	// self-closing element.
+	contentStart, contentEnd int
+	children                 []*source
+}
+
+// Splice returns the document doc, an edited parse tree of original, written
+// over original: subtrees and text that did not change keep their original
+// bytes, an element whose attributes changed gets a new start tag, and new
+// content is rendered as XHTML.
+func Splice(original []byte, doc *html.Node) ([]byte, error) {
+	root, err := sourceTree(original)
+	if err != nil {
+		return nil, err
+	}
+	old, err := html.Parse(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &splicer{original: original, sources: make(map[*html.Node]*source), hashes: make(map[*html.Node]uint64)}
+	i := 0
+	if !s.match(old, root.children, &i) || i != len(root.children) {
+		return nil, ErrUnmapped
+	}
+	s.sources[old] = root
+	s.hash(old)
+	s.hash(doc)
+
+	var buf bytes.Buffer
+	if err := s.content(&buf, doc, old); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sourceTree returns the elements of original as a tree under a root
+// spanning the whole document.
+func sourceTree(original []byte) (*source, error) {
+	root := &source{start: 0, end: len(original), contentStart: 0, contentEnd: len(original)}
+	stack := []*source{root}
+	foreign := 0
+
+	z := html.NewTokenizer(bytes.NewReader(original))
+	pos := 0
+	for {
+		z.AllowCDATA(foreign > 0)
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if !errors.Is(z.Err(), io.EOF) {
+				return nil, z.Err()
+			}
+			break
+		}
+		raw := len(z.Raw())
+		start, end := pos, pos+raw
+		pos = end
+
+		parent := stack[len(stack)-1]
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			el := &source{name: string(name), start: start, end: end, contentStart: end, contentEnd: end}
+			parent.children = append(parent.children, el)
+			if voidElements[el.name] && foreign == 0 {
+				continue
+			}
+			if tt == html.SelfClosingTagToken {
+				// Outside SVG and MathML the parser ignores the slash.
+				if foreign == 0 {
+					return nil, ErrUnmapped
+				}
+				continue
+			}
+			if el.name == "svg" || el.name == "math" || foreign > 0 {
+				foreign++
+			}
+			stack = append(stack, el)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if voidElements[string(name)] && foreign == 0 {
+				// <img></img>: the end tag belongs to the void element before it.
+				if n := len(parent.children); n > 0 && parent.children[n-1].name == string(name) && parent.children[n-1].end == start {
+					parent.children[n-1].end = end
+					continue
+				}
+				return nil, ErrUnmapped
+			}
+			if len(stack) == 1 || parent.name != string(name) {
+				return nil, ErrUnmapped
+			}
+			parent.contentEnd, parent.end = start, end
+			stack = stack[:len(stack)-1]
+			if foreign > 0 {
+				foreign--
+			}
+		}
+	}
+	if len(stack) != 1 {
+		return nil, ErrUnmapped
+	}
+	return root, nil
+}
+
+type splicer struct {
+	original []byte
+	sources  map[*html.Node]*source
+	hashes   map[*html.Node]uint64
+}
+
+// match maps the element children of parent in the parse tree to the
+// sources from *i on, looking through elements the parser implied.
+func (s *splicer) match(parent *html.Node, sources []*source, i *int) bool {
+	for c := parent.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if *i < len(sources) && strings.EqualFold(sources[*i].name, c.Data) {
+			src := sources[*i]
+			s.sources[c] = src
+			j := 0
+			if !s.match(c, src.children, &j) || j != len(src.children) {
+				return false
+			}
+			*i++
+			continue
+		}
+		if c.Namespace == "" && impliedElements[c.Data] {
+			if !s.match(c, sources, i) {
+				return false
+			}
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// hash records a hash of every subtree of n; equal subtrees hash equally.
+func (s *splicer) hash(n *html.Node) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00", n.Type, n.Namespace, n.Data)
+	for _, a := range n.Attr {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", a.Namespace, a.Key, a.Val)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		fmt.Fprintf(h, "%x\x00", s.hash(c))
+	}
+	sum := h.Sum64()
+	s.hashes[n] = sum
+	return sum
+}
+
+// node writes n, which replaces old, or is new when old is nil.
+func (s *splicer) node(buf *bytes.Buffer, n, old *html.Node) error {
+	src := s.sources[old]
+	if old == nil || src == nil {
+		return render(buf, n)
+	}
+	if s.hashes[n] == s.hashes[old] {
+		buf.Write(s.original[src.start:src.end])
+		return nil
+	}
+	if n.Type != html.ElementNode || old.Type != html.ElementNode || n.Data != old.Data || n.Namespace != old.Namespace {
+		return render(buf, n)
+	}
+
+	selfClosing := src.contentStart == src.end
+	if sameAttrs(n.Attr, old.Attr) && !selfClosing {
+		buf.Write(s.original[src.start:src.contentStart])
+	} else {
+		buf.WriteString(startTag(n))
+	}
+	if selfClosing {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := render(buf, c); err != nil {
+				return err
+			}
+		}
+		if n.FirstChild == nil && (voidElements[n.Data] || n.Namespace != "") {
+			buf.Truncate(buf.Len() - 1)
+			buf.WriteString("/>")
+			return nil
+		}
+		buf.WriteString("</" + n.Data + ">")
+		return nil
+	}
+	if err := s.content(buf, n, old); err != nil {
+		return err
+	}
+	buf.Write(s.original[src.contentEnd:src.end])
+	return nil
+}
+
+// content writes the children of n over those of old, whose source is
+// known.
+func (s *splicer) content(buf *bytes.Buffer, n, old *html.Node) error {
+	src := s.sources[old]
+	newElements, newRuns := partition(n)
+	oldElements, oldRuns := partition(old)
+	for _, el := range oldElements {
+		if s.sources[el] == nil {
+			// The parser implied an element around some of the children.
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if err := render(buf, c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	pairs := s.align(newElements, oldElements)
+	// bounds returns where the original bytes before and after the old
+	// element j are, with -1 and len(oldElements) for the content edges.
+	before := func(j int) int {
+		if j == len(oldElements) {
+			return src.contentEnd
+		}
+		return s.sources[oldElements[j]].start
+	}
+	after := func(j int) int {
+		if j == -1 {
+			return src.contentStart
+		}
+		return s.sources[oldElements[j]].end
+	}
+
+	for k := 0; k <= len(newElements); k++ {
+		prev, next := -1, len(oldElements)
+		if k > 0 {
+			prev = pairs[k-1]
+		}
+		if k < len(newElements) {
+			next = pairs[k]
+		}
+		kept := (k == 0 || prev >= 0) && next >= 0 && next == prev+1 && s.sameRun(newRuns[k], oldRuns[next])
+		if kept {
+			buf.Write(s.original[after(prev):before(next)])
+		} else {
+			for _, c := range newRuns[k] {
+				if err := render(buf, c); err != nil {
+					return err
+				}
+			}
+		}
+
+		if k == len(newElements) {
+			break
+		}
+		var oldEl *html.Node
+		if pairs[k] >= 0 {
+			oldEl = oldElements[pairs[k]]
+		}
+		if err := s.node(buf, newElements[k], oldEl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partition returns the element children of n and the runs of other
+// children around them: runs[k] precedes elements[k], and the last run
+// follows the last element.
+func partition(n *html.Node) (elements []*html.Node, runs [][]*html.Node) {
+	runs = [][]*html.Node{nil}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			elements = append(elements, c)
+			runs = append(runs, nil)
+			continue
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], c)
+	}
+	return elements, runs
+}
+
+func (s *splicer) sameRun(a, b []*html.Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if s.hashes[a[i]] != s.hashes[b[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// align pairs the new elements with the old ones they replace: unchanged
+// elements first, then elements that kept their name and most of their
+// attributes. It returns the old index of every new element, or -1 for
+// new elements.
+func (s *splicer) align(newElements, oldElements []*html.Node) []int {
+	pairs := make([]int, len(newElements))
+	newAt := make(map[uint64][]int)
+	for i, n := range newElements {
+		newAt[s.hashes[n]] = append(newAt[s.hashes[n]], i)
+	}
+	oldAt := make(map[uint64][]int)
+	for j, n := range oldElements {
+		oldAt[s.hashes[n]] = append(oldAt[s.hashes[n]], j)
+	}
+	// next returns the first of positions after from, or -1.
+	next := func(positions []int, from int) int {
+		for _, p := range positions {
+			if p > from {
+				return p
+			}
+		}
+		return -1
+	}
+
+	i, j := 0, 0
+	for i < len(newElements) {
+		if j == len(oldElements) {
+			pairs[i] = -1
+			i++
+			continue
+		}
+		n, o := newElements[i], oldElements[j]
+		if s.hashes[n] == s.hashes[o] {
+			pairs[i] = j
+			i, j = i+1, j+1
+			continue
+		}
+
+		// An unchanged old element further on means new elements were
+		// inserted; an unchanged new element further on means old ones
+		// were removed.
+		ni, oj := next(newAt[s.hashes[o]], i), next(oldAt[s.hashes[n]], j)
+		switch {
+		case ni >= 0 && (oj < 0 || ni-i <= oj-j):
+			pairs[i] = -1
+			i++
+			continue
+		case oj >= 0:
+			j++
+			continue
+		}
+
+		similarity := similar(n, o)
+		switch {
+		case i+1 < len(newElements) && similar(newElements[i+1], o) > similarity:
+			pairs[i] = -1
+			i++
+		case j+1 < len(oldElements) && similar(n, oldElements[j+1]) > similarity:
+			j++
+		case similarity >= 0:
+			pairs[i] = j
+			i, j = i+1, j+1
+		default:
+			pairs[i] = -1
+			i++
+		}
+	}
+	return pairs
+}
+
+// similar returns how alike two elements are: -1 for elements of another
+// name, else one more than the number of attributes they share.
+func similar(a, b *html.Node) int {
+	if a.Data != b.Data || a.Namespace != b.Namespace {
+		return -1
+	}
+	shared := 1
+	for _, x := range a.Attr {
+		for _, y := range b.Attr {
+			if x == y {
+				shared++
+				break
+			}
+		}
+	}
+	return shared
+}
+
+func sameAttrs(a, b []html.Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package xhtml
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+const testChapter = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>One</title>
+  <link rel="stylesheet" type="text/css" href="style.css" />
+</head>
+<body>
+  <p id="a">Hello&#160;world<br />one &amp; two</p>
+  <p id="b">Goodbye</p>
+  <svg xmlns="http://www.w3.org/2000/svg"><image xlink:href="cover.jpg" /></svg>
+</body>
+</html>
+`
+
+func parse(t *testing.T, src string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestSpliceUnchanged(t *testing.T) {
+	got, err := Splice([]byte(testChapter), parse(t, testChapter).Nodes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != testChapter {
+		t.Errorf("Splice changed an unchanged document:\n%s", got)
+	}
+}
+
+func TestSpliceEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(doc *goquery.Document)
+		old  string
+		new  string
+	}{
+		{
+			"insert",
+			func(doc *goquery.Document) {
+				doc.Find("#b").AfterHtml(`<p data-translation-id="tb">Tot ziens</p>`)
+			},
+			`<p id="b">Goodbye</p>`,
+			`<p id="b">Goodbye</p><p data-translation-id="tb">Tot ziens</p>`,
+		},
+		{
+			"attribute",
+			func(doc *goquery.Document) { doc.Find("#b").SetAttr("class", "done") },
+			`<p id="b">`,
+			`<p id="b" class="done">`,
+		},
+		{
+			"text",
+			func(doc *goquery.Document) { doc.Find("#b").SetText("So long & farewell") },
+			`Goodbye`,
+			`So long &amp; farewell`,
+		},
+		{
+			"remove",
+			func(doc *goquery.Document) { doc.Find("#b").Remove() },
+			`<p id="b">Goodbye</p>`,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		doc := parse(t, testChapter)
+		tt.edit(doc)
+		got, err := Splice([]byte(testChapter), doc.Nodes[0])
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if want := strings.Replace(testChapter, tt.old, tt.new, 1); string(got) != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestSpliceUnmapped(t *testing.T) {
+	// The parser reads <p/> as a start tag, so the original and its tree
+	// differ.
+	src := `<html><body><p/>One</body></html>`
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Splice([]byte(src), doc); !errors.Is(err, ErrUnmapped) {
+		t.Errorf("Splice = %v, want ErrUnmapped", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := `<?xml version="1.0" encoding="utf-8"?><html><head></head><body><p title="a &quot;b&quot;">x &lt; y<br/><img src="a.jpg"/></p><svg><path d="M0"></path></svg></body></html>`
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Render(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="utf-8"?><html><head></head><body><p title="a &quot;b&quot;">x &lt; y<br/><img src="a.jpg"/></p><svg><path d="M0"/></svg></body></html>`
+	if buf.String() != want {
+		t.Errorf("Render =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
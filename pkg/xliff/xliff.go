@@ -0,0 +1,423 @@
+// Package xliff reads and writes XLIFF 2.1 files, the exchange format of the
+// CAT tools professional translators work in. Segments are given as HTML
+// markup; the inline elements of the markup become inline codes (pc and ph)
+// whose native markup is kept in the originalData of the unit, so translators
+// see and move the codes instead of tags.
+package xliff
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The states of a segment, in the order of the work on it.
+const (
+	StateInitial    = "initial"
+	StateTranslated = "translated"
+	StateReviewed   = "reviewed"
+	StateFinal      = "final"
+)
+
+// Document is an XLIFF document. Languages are BCP 47 codes such as "vi".
+type Document struct {
+	SrcLang string
+	TrgLang string
+	Files   []File
+}
+
+// File holds the units of one document, Original being its path.
+type File struct {
+	ID       string
+	Original string
+	Units    []Unit
+}
+
+// Unit is a segment to translate.
+type Unit struct {
+	ID string
+	// Source and Target are HTML markup; Target is "" for a segment not
+	// translated yet.
+	Source string
+	Target string
+	State  string
+	Notes  []string
+}
+
+type xliffDocument struct {
+	XMLName xml.Name    `xml:"urn:oasis:names:tc:xliff:document:2.0 xliff"`
+	Version string      `xml:"version,attr"`
+	SrcLang string      `xml:"srcLang,attr"`
+	TrgLang string      `xml:"trgLang,attr,omitempty"`
+	Files   []xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	ID       string       `xml:"id,attr"`
+	Original string       `xml:"original,attr,omitempty"`
+	Units    []xliffUnit  `xml:"unit"`
+	Groups   []xliffGroup `xml:"group"`
+}
+
+// xliffGroup is a group of units, which CAT tools may add.
+type xliffGroup struct {
+	Units  []xliffUnit  `xml:"unit"`
+	Groups []xliffGroup `xml:"group"`
+}
+
+type xliffUnit struct {
+	ID           string      `xml:"id,attr"`
+	Notes        []string    `xml:"notes>note,omitempty"`
+	OriginalData []xliffData `xml:"originalData>data,omitempty"`
+	// Parts are the segment and ignorable elements, in order; CAT tools
+	// may split a unit into several segments.
+	Parts []xliffPart `xml:",any"`
+}
+
+type xliffData struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xliffPart struct {
+	XMLName xml.Name
+	State   string        `xml:"state,attr,omitempty"`
+	Source  xliffContent  `xml:"source"`
+	Target  *xliffContent `xml:"target"`
+}
+
+// xliffContent is the content of a source or target element, with its
+// inline codes.
+type xliffContent struct {
+	Inner string `xml:",innerxml"`
+}
+
+// Write writes doc as an XLIFF 2.1 document.
+func Write(w io.Writer, doc Document) error {
+	out := xliffDocument{Version: "2.1", SrcLang: doc.SrcLang, TrgLang: doc.TrgLang}
+	for _, file := range doc.Files {
+		f := xliffFile{ID: file.ID, Original: file.Original}
+		for _, unit := range file.Units {
+			u, err := encodeUnit(unit)
+			if err != nil {
+				return fmt.Errorf("unit %s: %w", unit.ID, err)
+			}
+			f.Units = append(f.Units, u)
+		}
+		out.Files = append(out.Files, f)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return fmt.Errorf("encoding XLIFF: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Read reads an XLIFF 2.x document. The segments of a unit are joined; the
+// inline codes are replaced by the native markup they stand for.
+func Read(r io.Reader) (Document, error) {
+	var in xliffDocument
+	if err := xml.NewDecoder(r).Decode(&in); err != nil {
+		return Document{}, fmt.Errorf("parsing XLIFF: %w", err)
+	}
+	if !strings.HasPrefix(in.Version, "2.") {
+		return Document{}, fmt.Errorf("XLIFF version %q is not supported, only 2.x", in.Version)
+	}
+
+	doc := Document{SrcLang: in.SrcLang, TrgLang: in.TrgLang}
+	for _, f := range in.Files {
+		file := File{ID: f.ID, Original: f.Original}
+		units := append([]xliffUnit(nil), f.Units...)
+		units = append(units, groupUnits(f.Groups)...)
+		for _, u := range units {
+			unit, err := decodeUnit(u)
+			if err != nil {
+				return Document{}, fmt.Errorf("unit %s: %w", u.ID, err)
+			}
+			file.Units = append(file.Units, unit)
+		}
+		doc.Files = append(doc.Files, file)
+	}
+	return doc, nil
+}
+
+func groupUnits(groups []xliffGroup) []xliffUnit {
+	var units []xliffUnit
+	for _, g := range groups {
+		units = append(units, g.Units...)
+		units = append(units, groupUnits(g.Groups)...)
+	}
+	return units
+}
+
+func encodeUnit(unit Unit) (xliffUnit, error) {
+	c := newCodec()
+	source, err := c.encode(unit.Source, false)
+	if err != nil {
+		return xliffUnit{}, err
+	}
+	segment := xliffPart{XMLName: xml.Name{Local: "segment"}, State: unit.State, Source: xliffContent{source}}
+	if unit.Target != "" {
+		target, err := c.encode(unit.Target, true)
+		if err != nil {
+			return xliffUnit{}, err
+		}
+		segment.Target = &xliffContent{target}
+	}
+	return xliffUnit{ID: unit.ID, Notes: unit.Notes, OriginalData: c.data, Parts: []xliffPart{segment}}, nil
+}
+
+func decodeUnit(u xliffUnit) (Unit, error) {
+	data := map[string]string{}
+	for _, d := range u.OriginalData {
+		data[d.ID] = d.Value
+	}
+
+	unit := Unit{ID: u.ID, Notes: u.Notes}
+	var source, target strings.Builder
+	// A unit is translated once all its segments are.
+	segments, translated := 0, 0
+	for _, part := range u.Parts {
+		if part.XMLName.Local != "segment" && part.XMLName.Local != "ignorable" {
+			continue
+		}
+		if part.XMLName.Local == "segment" {
+			if segments == 0 || stateRank(part.State) < stateRank(unit.State) {
+				unit.State = part.State
+			}
+			segments++
+		}
+		s, err := decodeContent(part.Source.Inner, data)
+		if err != nil {
+			return unit, err
+		}
+		source.WriteString(s)
+		if part.Target == nil {
+			// An ignorable without a target is the same in both.
+			if part.XMLName.Local == "ignorable" {
+				target.WriteString(s)
+			}
+			continue
+		}
+		t, err := decodeContent(part.Target.Inner, data)
+		if err != nil {
+			return unit, err
+		}
+		target.WriteString(t)
+		if part.XMLName.Local == "segment" {
+			translated++
+		}
+	}
+
+	unit.Source = source.String()
+	if segments > 0 && translated == segments {
+		unit.Target = target.String()
+	}
+	return unit, nil
+}
+
+// stateRank orders the states; a unit is as far as its least advanced
+// segment.
+func stateRank(state string) int {
+	switch state {
+	case StateTranslated:
+		return 1
+	case StateReviewed:
+		return 2
+	case StateFinal:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// codec turns the inline elements of the markup of a unit into inline codes.
+type codec struct {
+	data    []xliffData
+	dataIDs map[string]string
+	codes   int
+	// sourceCodes are the ids of the codes of the source by their data, which
+	// the same codes of the target take.
+	sourceCodes map[string][]string
+}
+
+func newCodec() *codec {
+	return &codec{dataIDs: map[string]string{}, sourceCodes: map[string][]string{}}
+}
+
+// dataRef returns the id of the original data value.
+func (c *codec) dataRef(value string) string {
+	if id, ok := c.dataIDs[value]; ok {
+		return id
+	}
+	id := "d" + strconv.Itoa(len(c.data)+1)
+	c.data = append(c.data, xliffData{ID: id, Value: value})
+	c.dataIDs[value] = id
+	return id
+}
+
+// codeID returns the id of a code standing for the data refs, the id of the
+// same code of the source for the target.
+func (c *codec) codeID(key string, target bool) string {
+	if target {
+		if ids := c.sourceCodes[key]; len(ids) > 0 {
+			c.sourceCodes[key] = ids[1:]
+			return ids[0]
+		}
+	}
+	c.codes++
+	id := strconv.Itoa(c.codes)
+	if !target {
+		c.sourceCodes[key] = append(c.sourceCodes[key], id)
+	}
+	return id
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (c *codec) encode(markup string, target bool) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(markup), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	var encodeNode func(n *html.Node)
+	encodeNode = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(textEscaper.Replace(n.Data))
+		case html.CommentNode:
+			ref := c.dataRef("<!--" + n.Data + "-->")
+			fmt.Fprintf(&sb, `<ph id="%s" dataRef="%s"/>`, c.codeID(ref, target), ref)
+		case html.ElementNode:
+			if n.FirstChild == nil {
+				value := startTag(n, true)
+				if !isVoid(n) {
+					value = startTag(n, false) + "</" + n.Data + ">"
+				}
+				ref := c.dataRef(value)
+				fmt.Fprintf(&sb, `<ph id="%s" dataRef="%s"/>`, c.codeID(ref, target), ref)
+				return
+			}
+			start, end := c.dataRef(startTag(n, false)), c.dataRef("</"+n.Data+">")
+			fmt.Fprintf(&sb, `<pc id="%s" dataRefStart="%s" dataRefEnd="%s">`, c.codeID(start+" "+end, target), start, end)
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				encodeNode(child)
+			}
+			sb.WriteString("</pc>")
+		}
+	}
+	for _, n := range nodes {
+		encodeNode(n)
+	}
+	return sb.String(), nil
+}
+
+func startTag(n *html.Node, selfClosing bool) string {
+	var sb strings.Builder
+	sb.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		key := a.Key
+		if a.Namespace != "" {
+			key = a.Namespace + ":" + key
+		}
+		fmt.Fprintf(&sb, ` %s="%s"`, key, html.EscapeString(a.Val))
+	}
+	if selfClosing {
+		sb.WriteString("/")
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
+
+func isVoid(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Area, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input, atom.Link, atom.Meta, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+	return n.Namespace != ""
+}
+
+// decodeContent returns the markup of the content of a source or target:
+// codes become their original data, annotations (mrk) their content.
+func decodeContent(inner string, data map[string]string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader("<content>" + inner + "</content>"))
+	var sb strings.Builder
+	// ends holds the markup written when the open elements end.
+	var ends []string
+	ref := func(id string) (string, error) {
+		if id == "" {
+			return "", nil
+		}
+		value, ok := data[id]
+		if !ok {
+			return "", fmt.Errorf("no original data %q", id)
+		}
+		return value, nil
+	}
+
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			attr := func(name string) string {
+				for _, a := range t.Attr {
+					if a.Name.Local == name {
+						return a.Value
+					}
+				}
+				return ""
+			}
+			end := ""
+			switch t.Name.Local {
+			case "pc":
+				start, err := ref(attr("dataRefStart"))
+				if err != nil {
+					return "", err
+				}
+				if end, err = ref(attr("dataRefEnd")); err != nil {
+					return "", err
+				}
+				sb.WriteString(start)
+			case "ph", "sc", "ec":
+				value, err := ref(attr("dataRef"))
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(value)
+			case "cp":
+				r, err := strconv.ParseUint(attr("hex"), 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("invalid cp %q", attr("hex"))
+				}
+				sb.WriteString(textEscaper.Replace(string(rune(r))))
+			}
+			ends = append(ends, end)
+		case xml.EndElement:
+			sb.WriteString(ends[len(ends)-1])
+			ends = ends[:len(ends)-1]
+		case xml.CharData:
+			sb.WriteString(textEscaper.Replace(string(t)))
+		}
+	}
+	return sb.String(), nil
+}
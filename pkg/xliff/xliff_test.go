@@ -0,0 +1,71 @@
+package xliff
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	doc := Document{SrcLang: "en", TrgLang: "vi", Files: []File{{
+		ID:       "f1",
+		Original: "Text/ch1.xhtml",
+		Units: []Unit{
+			{ID: "a", Source: `Hello <em class="x">world</em> &amp; more<br/>`, Target: `Xin chào <em class="x">thế giới</em><br/>`, State: StateTranslated},
+			{ID: "b", Source: "Goodbye", State: StateInitial, Notes: []string{"Keep it short"}},
+		},
+	}}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.1" srcLang="en" trgLang="vi">`,
+		`<data id="d1">&lt;em class=&#34;x&#34;&gt;</data>`,
+		`<source>Hello <pc id="1" dataRefStart="d1" dataRefEnd="d2">world</pc> &amp; more<ph id="2" dataRef="d3"/></source>`,
+		`<target>Xin chào <pc id="1" dataRefStart="d1" dataRefEnd="d2">thế giới</pc><ph id="2" dataRef="d3"/></target>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("XLIFF lacks %s:\n%s", want, buf.String())
+		}
+	}
+
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Errorf("Read() = %+v, want %+v", got, doc)
+	}
+}
+
+func TestReadSegmentedUnit(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="UTF-8"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="en" trgLang="de">
+  <file id="f1" original="ch1.xhtml">
+    <group id="g1">
+      <unit id="a">
+        <originalData><data id="d1">&lt;b&gt;</data><data id="d2">&lt;/b&gt;</data></originalData>
+        <segment state="final"><source>One.</source><target>Eins.</target></segment>
+        <ignorable><source> </source></ignorable>
+        <segment state="reviewed"><source><pc id="1" dataRefStart="d1" dataRefEnd="d2">Two</pc>.</source><target><mrk id="m1" translate="yes"><pc id="1" dataRefStart="d1" dataRefEnd="d2">Zwei</pc></mrk>&lt;3<cp hex="00A0"/></target></segment>
+      </unit>
+    </group>
+  </file>
+</xliff>`
+
+	doc, err := Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Unit{ID: "a", Source: "One. <b>Two</b>.", Target: "Eins. <b>Zwei</b>&lt;3\u00a0", State: StateReviewed}
+	if len(doc.Files) != 1 || len(doc.Files[0].Units) != 1 || !reflect.DeepEqual(doc.Files[0].Units[0], want) {
+		t.Errorf("Read() = %+v, want the unit %+v", doc, want)
+	}
+
+	if _, err := Read(strings.NewReader(`<xliff xmlns="urn:oasis:names:tc:xliff:document:1.2" version="1.2"></xliff>`)); err == nil {
+		t.Error("Read() accepted XLIFF 1.2")
+	}
+}
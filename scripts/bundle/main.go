@@ -0,0 +1,31 @@
+// Command bundle writes the bundles of the serve pages. It runs with
+// go generate ./cmd after changing the scripts or stylesheets in cmd/assets.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dutchsteven/epubtrans/pkg/bundle"
+)
+
+func main() {
+	src := flag.String("src", "assets", "directory of the sources")
+	out := flag.String("out", "assets/dist", "directory to write the bundles to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+	for _, b := range bundle.Bundles {
+		content, err := bundle.Build(os.DirFS(*src), b)
+		if err != nil {
+			log.Fatalf("building %s: %v", b.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(*out, b.Name), content, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}